@@ -0,0 +1,135 @@
+package alerting
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Alert states
+const (
+	StateFiring   = "firing"
+	StateResolved = "resolved"
+)
+
+// Alert is a notification about a server-side condition
+type Alert struct {
+	Name    string    `json:"name"`
+	State   string    `json:"state"`
+	Summary string    `json:"summary"`
+	Server  string    `json:"server"` // public URL of the instance that raised the alert
+	At      time.Time `json:"at"`
+}
+
+// Sink delivers alerts to an operator
+type Sink interface {
+	Name() string
+	Send(ctx context.Context, alert Alert) error
+}
+
+// Condition is checked periodically; Check reports whether the condition currently holds and why
+type Condition struct {
+	Name  string
+	Check func(ctx context.Context) (firing bool, summary string)
+}
+
+// Alerter checks conditions and notifies the sinks when one starts or stops holding.
+// Conditions that keep holding are re-sent every repeat interval.
+type Alerter struct {
+	sinks      []Sink
+	conditions []Condition
+	server     string
+	repeat     time.Duration
+
+	mu     sync.Mutex
+	firing map[string]time.Time // condition name -> time of the last notification
+}
+
+func NewAlerter(sinks []Sink, server string, repeat time.Duration) *Alerter {
+	return &Alerter{
+		sinks:  sinks,
+		server: server,
+		repeat: repeat,
+		firing: make(map[string]time.Time),
+	}
+}
+
+// Enabled reports whether any sink is configured
+func (a *Alerter) Enabled() bool {
+	return a != nil && len(a.sinks) > 0
+}
+
+// Watch adds a condition to the periodic checks. It must be called before Run.
+func (a *Alerter) Watch(condition Condition) {
+	a.conditions = append(a.conditions, condition)
+}
+
+// Run checks every condition each interval until the process exits
+func (a *Alerter) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		<-ticker.C
+		a.checkAll()
+	}
+}
+
+func (a *Alerter) checkAll() {
+	for _, condition := range a.conditions {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		firing, summary := condition.Check(ctx)
+		cancel()
+
+		if firing {
+			a.Fire(condition.Name, summary)
+		} else {
+			a.Resolve(condition.Name, summary)
+		}
+	}
+}
+
+// Fire notifies the sinks that a condition holds, unless they were notified within the repeat interval
+func (a *Alerter) Fire(name, summary string) {
+	a.mu.Lock()
+	last, wasFiring := a.firing[name]
+	now := time.Now()
+	if wasFiring && now.Sub(last) < a.repeat {
+		a.mu.Unlock()
+		return
+	}
+	a.firing[name] = now
+	a.mu.Unlock()
+
+	log.Printf("ALERT: %s: %s", name, summary)
+	a.send(Alert{Name: name, State: StateFiring, Summary: summary, Server: a.server, At: now})
+}
+
+// Resolve notifies the sinks that a firing condition no longer holds
+func (a *Alerter) Resolve(name, summary string) {
+	a.mu.Lock()
+	_, wasFiring := a.firing[name]
+	delete(a.firing, name)
+	a.mu.Unlock()
+
+	if !wasFiring {
+		return
+	}
+
+	log.Printf("Resolved: %s: %s", name, summary)
+	a.send(Alert{Name: name, State: StateResolved, Summary: summary, Server: a.server, At: time.Now()})
+}
+
+// send delivers an alert to every sink in the background; a slow sink must not delay the checks
+func (a *Alerter) send(alert Alert) {
+	for _, sink := range a.sinks {
+		go func(sink Sink) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := sink.Send(ctx, alert); err != nil {
+				log.Printf("Warning: failed to send alert %s to %s: %v", alert.Name, sink.Name(), err)
+			}
+		}(sink)
+	}
+}
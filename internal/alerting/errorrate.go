@@ -0,0 +1,64 @@
+package alerting
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrorRate counts responses and server errors, both in total and since the last check
+type ErrorRate struct {
+	total       atomic.Int64
+	totalErrors atomic.Int64
+
+	mu       sync.Mutex
+	requests int64
+	errors   int64
+}
+
+func NewErrorRate() *ErrorRate {
+	return &ErrorRate{}
+}
+
+// Record counts a response; status codes of 500 and above are errors
+func (e *ErrorRate) Record(status int) {
+	isError := status >= 500
+
+	e.total.Add(1)
+	if isError {
+		e.totalErrors.Add(1)
+	}
+
+	e.mu.Lock()
+	e.requests++
+	if isError {
+		e.errors++
+	}
+	e.mu.Unlock()
+}
+
+// Totals returns the number of responses and server errors since startup
+func (e *ErrorRate) Totals() (requests, errors int64) {
+	return e.total.Load(), e.totalErrors.Load()
+}
+
+// Condition fires when at least threshold (a fraction) of the responses since the previous check
+// were server errors. Intervals with fewer than minRequests responses are ignored.
+func (e *ErrorRate) Condition(threshold float64, minRequests int64) Condition {
+	return Condition{
+		Name: "error_rate",
+		Check: func(ctx context.Context) (bool, string) {
+			e.mu.Lock()
+			requests, errors := e.requests, e.errors
+			e.requests, e.errors = 0, 0
+			e.mu.Unlock()
+
+			if requests < minRequests {
+				return false, fmt.Sprintf("%d of %d responses were server errors", errors, requests)
+			}
+			rate := float64(errors) / float64(requests)
+			return rate >= threshold, fmt.Sprintf("%.1f%% of %d responses were server errors", rate*100, requests)
+		},
+	}
+}
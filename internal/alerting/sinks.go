@@ -0,0 +1,106 @@
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// WebhookSink posts alerts as JSON to an arbitrary URL
+type WebhookSink struct {
+	url    string
+	client *http.Client
+}
+
+func NewWebhookSink(url string) *WebhookSink {
+	return &WebhookSink{url: url, client: http.DefaultClient}
+}
+
+func (s *WebhookSink) Name() string { return "webhook" }
+
+func (s *WebhookSink) Send(ctx context.Context, alert Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return fmt.Errorf("failed to marshal alert: %w", err)
+	}
+	return post(ctx, s.client, s.url, "application/json", body, nil)
+}
+
+// SlackSink posts alerts to a Slack incoming webhook
+type SlackSink struct {
+	url    string
+	client *http.Client
+}
+
+func NewSlackSink(url string) *SlackSink {
+	return &SlackSink{url: url, client: http.DefaultClient}
+}
+
+func (s *SlackSink) Name() string { return "slack" }
+
+func (s *SlackSink) Send(ctx context.Context, alert Alert) error {
+	body, err := json.Marshal(map[string]string{"text": alertText(alert)})
+	if err != nil {
+		return fmt.Errorf("failed to marshal alert: %w", err)
+	}
+	return post(ctx, s.client, s.url, "application/json", body, nil)
+}
+
+// NtfySink publishes alerts to an ntfy topic URL
+type NtfySink struct {
+	url    string
+	client *http.Client
+}
+
+func NewNtfySink(url string) *NtfySink {
+	return &NtfySink{url: url, client: http.DefaultClient}
+}
+
+func (s *NtfySink) Name() string { return "ntfy" }
+
+func (s *NtfySink) Send(ctx context.Context, alert Alert) error {
+	headers := map[string]string{
+		"Title":    fmt.Sprintf("Helios sync: %s %s", alert.Name, alert.State),
+		"Priority": "high",
+		"Tags":     "warning",
+	}
+	if alert.State == StateResolved {
+		headers["Priority"] = "default"
+		headers["Tags"] = "white_check_mark"
+	}
+	return post(ctx, s.client, s.url, "text/plain", []byte(alertText(alert)), headers)
+}
+
+// alertText renders an alert as a single human-readable line
+func alertText(alert Alert) string {
+	state := strings.ToUpper(alert.State)
+	if alert.Server == "" {
+		return fmt.Sprintf("[%s] %s: %s", state, alert.Name, alert.Summary)
+	}
+	return fmt.Sprintf("[%s] %s on %s: %s", state, alert.Name, alert.Server, alert.Summary)
+}
+
+func post(ctx context.Context, client *http.Client, url, contentType string, body []byte, headers map[string]string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+package blobstore
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrNotFound is returned when a requested object does not exist
+var ErrNotFound = errors.New("object not found")
+
+// Store is a minimal object store used for data that does not need to live in Redis
+type Store interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	Delete(ctx context.Context, key string) error
+}
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"log"
 	"os"
 	"strconv"
 	"strings"
@@ -14,11 +15,209 @@ type Config struct {
 	JWTSecret     string
 	GinMode       string
 	CORSOrigins   []string
+
+	// Token signing: "HS256" with JWTSecret, or "RS256"/"EdDSA" with a PEM private key whose
+	// public half is published at /.well-known/jwks.json
+	JWTSigningMethod  string
+	JWTPrivateKeyFile string
+
+	// Argon2id cost of new passphrase and recovery code hashes; existing passphrases are rehashed at login
+	Argon2Time     int
+	Argon2MemoryKB int
+	Argon2Threads  int
+
+	// Passphrase policy for new wallets and passphrase resets
+	PassphraseMinLength      int
+	PassphraseMinEntropyBits int
+	PassphraseDenyListFile   string // extra denied passphrases, one per line
+
+	// Login lockout after repeated failures per wallet and per client IP (LoginLockoutThreshold 0 disables)
+	LoginLockoutThreshold   int
+	LoginIPLockoutThreshold int
+	LoginLockoutBaseSeconds int // first lockout; doubles with every further failure
+	LoginLockoutMaxMinutes  int
+	LoginFailureWindowMins  int // failures are forgotten after this long without another one
+	// Shortest time login, recovery and wallet creation requests take to answer (0 disables)
+	AuthMinResponseMs int
+
+	// Public URL of this instance; tokens are issued for and only accepted by this URL
+	PublicURL string
+
+	// Storage backend: "redis" or "bolt" (embedded, for deployments without Redis)
+	StorageBackend string
+	BoltPath       string
+
+	// Bearer token required to scrape /metrics (empty allows anonymous scraping)
+	MetricsToken string
+
+	// Operator alert sinks (each empty URL is disabled) and thresholds
+	AlertWebhookURL           string
+	AlertSlackWebhookURL      string
+	AlertNtfyURL              string
+	AlertCheckInterval        int     // seconds between condition checks
+	AlertRepeatInterval       int     // minutes before a still-firing alert is sent again
+	AlertErrorRate            float64 // fraction of server errors per check interval that fires an alert
+	AlertErrorRateMinRequests int64   // check intervals with fewer responses are ignored
+
+	// Per-operation Redis timeout in milliseconds (0 disables)
+	RedisOpTimeoutMs int
+
+	// Retries of transient Redis failures (idempotent operations only)
+	RedisRetryAttempts     int
+	RedisRetryBackoffMs    int
+	RedisRetryMaxBackoffMs int
+
+	// Compression of stored values: "off", "gzip" or "zstd"
+	StorageCompression         string
+	StorageCompressionMinBytes int
+
+	// Redis memory protection
+	RedisMemoryThreshold     float64 // fraction of maxmemory above which non-essential writes are refused
+	RedisMemoryCheckInterval int     // seconds between memory samples
+	RedisAllowEviction       bool    // allow starting with an eviction policy other than noeviction
+
+	// Write durability: the level writes get unless they ask with ?durability=, "ack" or "flush"
+	DurabilityDefault string
+	// What a flush waits for on Redis: an AOF fsync (Redis 7.2+) and/or acknowledging replicas
+	RedisDurabilityFsync     bool
+	RedisDurabilityReplicas  int
+	RedisDurabilityTimeoutMs int
+
+	// What becomes of thread and settings writes whose version isn't newer than the stored one:
+	// "reject" answers 409, "last_writer_wins" applies them and reports the overwrite
+	ThreadConflictStrategy   string
+	SettingsConflictStrategy string
+
+	// Refuse writes whose machine ID is not in the wallet's device registry
+	RequireRegisteredMachines bool
+	// Refuse writes not signed with the machine's signing secret (X-Signature)
+	RequireRequestSignatures bool
+
+	// Base64 master key sealing machine, session and change-log metadata per user (empty disables)
+	MetadataEncryptionKey string
+
+	// Base64 key encrypting TOTP secrets; TOTP enrollment is unavailable without it
+	TOTPEncryptionKey string
+
+	// WebAuthn relying party for passkey login: the domain of the client app (empty disables passkeys),
+	// the name authenticators show and the origins the client app is served from
+	WebAuthnRPID    string
+	WebAuthnRPName  string
+	WebAuthnOrigins []string
+
+	// Base64 of the 32 byte secret the OPAQUE login's server keys derive from (empty disables OPAQUE)
+	OpaqueServerKey string
+	// Refuse passphrase logins of wallets registered for OPAQUE
+	OpaqueExclusive bool
+
+	// Per-user limits; writes carry warnings once 80% and 95% are reached (0 disables)
+	QuotaMaxThreads  int64
+	QuotaMaxMessages int64
+	QuotaMaxMemories int64
+	// Hard limit on the messages of one thread, 0 for none
+	QuotaMaxMessagesPerThread int64
+	// Hard limit on the attachment bytes of one user, 0 for none
+	QuotaMaxAttachmentBytes int64
+
+	// Largest single item (a thread's fields, a message, a settings document) an import accepts, in bytes (0 disables)
+	ImportMaxItemBytes int64
+
+	// Sliding-window caps on wallet creation per IP, subnet (/24, /48) and ASN, e.g. "ip:5/1h,subnet:20/1h,asn:100/24h".
+	// ASN rules need RegistrationASNDB, an iptoasn.com ip2asn-combined.tsv file.
+	RegistrationLimits string
+	RegistrationASNDB  string
+
+	// Demo mode: POST /auth/demo-wallet creates wallets erased with all data after DemoWalletTTLHours (0 disables)
+	DemoWalletTTLHours  int
+	DemoCleanupMinutes  int
+	DemoWalletRateLimit int // demo wallets per client IP and hour (0 disables the limit)
+
+	// Benchmark runs allowed per user and hour (0 disables the limit)
+	BenchmarkRateLimit int
+
+	// Authorization audit: "off", "log" (structured logs only) or "store" (logs plus Redis stream)
+	AuditMode       string
+	AuditMaxEntries int64
+
+	// Client-side encryption envelope versions
+	EncryptionCurrentVersion    int
+	EncryptionSupportedVersions []int
+
+	// Announcement that the instance is moving: its new URL and from when (RFC 3339 or YYYY-MM-DD),
+	// with an optional message for users. Empty MigrationURL announces nothing.
+	MigrationURL     string
+	MigrationDate    string
+	MigrationMessage string
+
+	// Archival of cold threads to S3-compatible storage (disabled when ArchiveAfterMonths is 0)
+	ArchiveAfterMonths     int
+	ArchiveIntervalMinutes int
+	S3Endpoint             string
+	S3Region               string
+	S3Bucket               string
+	S3AccessKey            string
+	S3SecretKey            string
+	S3UseSSL               bool
+
+	// Storage of client-encrypted attachments: "off", "fs" (files below AttachmentDir) or "s3"
+	// (the S3 settings above)
+	AttachmentStore    string
+	AttachmentDir      string
+	AttachmentMaxBytes int64
+	// Collection of attachments no message references (disabled when the interval is 0)
+	AttachmentGCIntervalMinutes int
+	AttachmentGCGraceHours      int
+
+	// How long drafts are kept after their last write
+	DraftTTLHours int
 }
 
 func Load() *Config {
 	redisDB, _ := strconv.Atoi(getEnv("REDIS_DB", "0"))
+	redisOpTimeoutMs, _ := strconv.Atoi(getEnv("REDIS_OP_TIMEOUT_MS", "2000"))
+	redisRetryAttempts, _ := strconv.Atoi(getEnv("REDIS_RETRY_ATTEMPTS", "3"))
+	redisRetryBackoffMs, _ := strconv.Atoi(getEnv("REDIS_RETRY_BACKOFF_MS", "50"))
+	redisRetryMaxBackoffMs, _ := strconv.Atoi(getEnv("REDIS_RETRY_MAX_BACKOFF_MS", "1000"))
+	argon2Time, _ := strconv.Atoi(getEnv("ARGON2_TIME", "1"))
+	argon2MemoryKB, _ := strconv.Atoi(getEnv("ARGON2_MEMORY_KB", "65536"))
+	argon2Threads, _ := strconv.Atoi(getEnv("ARGON2_THREADS", "4"))
+	passphraseMinLength, _ := strconv.Atoi(getEnv("PASSPHRASE_MIN_LENGTH", "12"))
+	passphraseMinEntropyBits, _ := strconv.Atoi(getEnv("PASSPHRASE_MIN_ENTROPY_BITS", "50"))
+	loginLockoutThreshold, _ := strconv.Atoi(getEnv("LOGIN_LOCKOUT_THRESHOLD", "5"))
+	loginIPLockoutThreshold, _ := strconv.Atoi(getEnv("LOGIN_IP_LOCKOUT_THRESHOLD", "20"))
+	loginLockoutBaseSeconds, _ := strconv.Atoi(getEnv("LOGIN_LOCKOUT_BASE_SECONDS", "30"))
+	loginLockoutMaxMinutes, _ := strconv.Atoi(getEnv("LOGIN_LOCKOUT_MAX_MINUTES", "60"))
+	loginFailureWindowMins, _ := strconv.Atoi(getEnv("LOGIN_FAILURE_WINDOW_MINUTES", "15"))
+	authMinResponseMs, _ := strconv.Atoi(getEnv("AUTH_MIN_RESPONSE_MS", "250"))
 	corsOrigins := strings.Split(getEnv("CORS_ORIGINS", "http://localhost:3000"), ",")
+	storageCompressionMinBytes, _ := strconv.Atoi(getEnv("STORAGE_COMPRESSION_MIN_BYTES", "512"))
+	memoryThresholdPercent, _ := strconv.Atoi(getEnv("REDIS_MEMORY_THRESHOLD_PERCENT", "90"))
+	memoryCheckInterval := getInterval("REDIS_MEMORY_CHECK_SECONDS", "30")
+	redisDurabilityReplicas, _ := strconv.Atoi(getEnv("REDIS_DURABILITY_REPLICAS", "0"))
+	redisDurabilityTimeoutMs, _ := strconv.Atoi(getEnv("REDIS_DURABILITY_TIMEOUT_MS", "1000"))
+	alertCheckInterval := getInterval("ALERT_CHECK_SECONDS", "60")
+	alertRepeatInterval, _ := strconv.Atoi(getEnv("ALERT_REPEAT_MINUTES", "60"))
+	alertErrorRatePercent, _ := strconv.Atoi(getEnv("ALERT_ERROR_RATE_PERCENT", "5"))
+	alertErrorRateMinRequests, _ := strconv.ParseInt(getEnv("ALERT_ERROR_RATE_MIN_REQUESTS", "20"), 10, 64)
+	auditMaxEntries, _ := strconv.ParseInt(getEnv("AUDIT_MAX_ENTRIES", "100000"), 10, 64)
+	quotaMaxThreads, _ := strconv.ParseInt(getEnv("QUOTA_MAX_THREADS", "0"), 10, 64)
+	quotaMaxMessages, _ := strconv.ParseInt(getEnv("QUOTA_MAX_MESSAGES", "0"), 10, 64)
+	quotaMaxMemories, _ := strconv.ParseInt(getEnv("QUOTA_MAX_MEMORIES", "0"), 10, 64)
+	quotaMaxMessagesPerThread, _ := strconv.ParseInt(getEnv("QUOTA_MAX_MESSAGES_PER_THREAD", "0"), 10, 64)
+	quotaMaxAttachmentBytes, _ := strconv.ParseInt(getEnv("QUOTA_MAX_ATTACHMENT_BYTES", "0"), 10, 64)
+	importMaxItemBytes, _ := strconv.ParseInt(getEnv("IMPORT_MAX_ITEM_BYTES", "16777216"), 10, 64)
+	attachmentMaxBytes, _ := strconv.ParseInt(getEnv("ATTACHMENT_MAX_BYTES", "26214400"), 10, 64)
+	attachmentGCIntervalMinutes, _ := strconv.Atoi(getEnv("ATTACHMENT_GC_INTERVAL_MINUTES", "60"))
+	attachmentGCGraceHours, _ := strconv.Atoi(getEnv("ATTACHMENT_GC_GRACE_HOURS", "24"))
+	draftTTLHours, _ := strconv.Atoi(getEnv("DRAFT_TTL_HOURS", "72"))
+	demoWalletTTLHours, _ := strconv.Atoi(getEnv("DEMO_WALLET_TTL_HOURS", "0"))
+	demoCleanupMinutes := getInterval("DEMO_CLEANUP_MINUTES", "10")
+	demoWalletRateLimit, _ := strconv.Atoi(getEnv("DEMO_WALLET_RATE_LIMIT", "5"))
+	benchmarkRateLimit, _ := strconv.Atoi(getEnv("BENCHMARK_RATE_LIMIT", "10"))
+	encryptionCurrentVersion, _ := strconv.Atoi(getEnv("ENCRYPTION_CURRENT_VERSION", "1"))
+	encryptionSupportedVersions := parseIntList(getEnv("ENCRYPTION_SUPPORTED_VERSIONS", "1"))
+	archiveAfterMonths, _ := strconv.Atoi(getEnv("ARCHIVE_AFTER_MONTHS", "0"))
+	archiveIntervalMinutes := getInterval("ARCHIVE_INTERVAL_MINUTES", "60")
 
 	return &Config{
 		Port:          getEnv("PORT", "8080"),
@@ -28,6 +227,119 @@ func Load() *Config {
 		JWTSecret:     getEnv("JWT_SECRET", "your-super-secret-key-change-this-in-production"),
 		GinMode:       getEnv("GIN_MODE", "debug"),
 		CORSOrigins:   corsOrigins,
+
+		JWTSigningMethod:  getEnv("JWT_SIGNING_METHOD", "HS256"),
+		JWTPrivateKeyFile: getEnv("JWT_PRIVATE_KEY_FILE", ""),
+
+		Argon2Time:     argon2Time,
+		Argon2MemoryKB: argon2MemoryKB,
+		Argon2Threads:  argon2Threads,
+
+		PassphraseMinLength:      passphraseMinLength,
+		PassphraseMinEntropyBits: passphraseMinEntropyBits,
+		PassphraseDenyListFile:   getEnv("PASSPHRASE_DENY_LIST_FILE", ""),
+
+		LoginLockoutThreshold:   loginLockoutThreshold,
+		LoginIPLockoutThreshold: loginIPLockoutThreshold,
+		LoginLockoutBaseSeconds: loginLockoutBaseSeconds,
+		LoginLockoutMaxMinutes:  loginLockoutMaxMinutes,
+		LoginFailureWindowMins:  loginFailureWindowMins,
+		AuthMinResponseMs:       authMinResponseMs,
+
+		PublicURL: strings.TrimSuffix(getEnv("PUBLIC_URL", "http://localhost:8080"), "/"),
+
+		StorageBackend: getEnv("STORAGE_BACKEND", "redis"),
+		BoltPath:       getEnv("BOLT_PATH", "helios.db"),
+
+		MetricsToken: getEnv("METRICS_TOKEN", ""),
+
+		AlertWebhookURL:           getEnv("ALERT_WEBHOOK_URL", ""),
+		AlertSlackWebhookURL:      getEnv("ALERT_SLACK_WEBHOOK_URL", ""),
+		AlertNtfyURL:              getEnv("ALERT_NTFY_URL", ""),
+		AlertCheckInterval:        alertCheckInterval,
+		AlertRepeatInterval:       alertRepeatInterval,
+		AlertErrorRate:            float64(alertErrorRatePercent) / 100,
+		AlertErrorRateMinRequests: alertErrorRateMinRequests,
+
+		RedisOpTimeoutMs: redisOpTimeoutMs,
+
+		RedisRetryAttempts:     redisRetryAttempts,
+		RedisRetryBackoffMs:    redisRetryBackoffMs,
+		RedisRetryMaxBackoffMs: redisRetryMaxBackoffMs,
+
+		StorageCompression:         getEnv("STORAGE_COMPRESSION", "off"),
+		StorageCompressionMinBytes: storageCompressionMinBytes,
+
+		RedisMemoryThreshold:     float64(memoryThresholdPercent) / 100,
+		RedisMemoryCheckInterval: memoryCheckInterval,
+		RedisAllowEviction:       getEnv("REDIS_ALLOW_EVICTION", "false") == "true",
+
+		DurabilityDefault:        getEnv("DURABILITY_DEFAULT", "ack"),
+		RedisDurabilityFsync:     getEnv("REDIS_DURABILITY_FSYNC", "false") == "true",
+		RedisDurabilityReplicas:  redisDurabilityReplicas,
+		RedisDurabilityTimeoutMs: redisDurabilityTimeoutMs,
+
+		ThreadConflictStrategy:   getEnv("THREAD_CONFLICT_STRATEGY", "reject"),
+		SettingsConflictStrategy: getEnv("SETTINGS_CONFLICT_STRATEGY", "last_writer_wins"),
+
+		RequireRegisteredMachines: getEnv("REQUIRE_REGISTERED_MACHINES", "false") == "true",
+		RequireRequestSignatures:  getEnv("REQUIRE_REQUEST_SIGNATURES", "false") == "true",
+
+		MetadataEncryptionKey: getEnv("METADATA_ENCRYPTION_KEY", ""),
+		TOTPEncryptionKey:     getEnv("TOTP_ENCRYPTION_KEY", ""),
+
+		WebAuthnRPID:    getEnv("WEBAUTHN_RP_ID", ""),
+		WebAuthnRPName:  getEnv("WEBAUTHN_RP_NAME", "Helios"),
+		WebAuthnOrigins: parseList(getEnv("WEBAUTHN_ORIGINS", "")),
+
+		OpaqueServerKey: getEnv("OPAQUE_SERVER_KEY", ""),
+		OpaqueExclusive: getEnv("OPAQUE_EXCLUSIVE", "false") == "true",
+
+		QuotaMaxThreads:  quotaMaxThreads,
+		QuotaMaxMessages: quotaMaxMessages,
+		QuotaMaxMemories: quotaMaxMemories,
+
+		QuotaMaxMessagesPerThread: quotaMaxMessagesPerThread,
+		QuotaMaxAttachmentBytes:   quotaMaxAttachmentBytes,
+
+		ImportMaxItemBytes: importMaxItemBytes,
+
+		RegistrationLimits: getEnv("REGISTRATION_LIMITS", "ip:10/1h,subnet:50/1h,asn:500/1h"),
+		RegistrationASNDB:  getEnv("REGISTRATION_ASN_DB", ""),
+
+		DemoWalletTTLHours:  demoWalletTTLHours,
+		DemoCleanupMinutes:  demoCleanupMinutes,
+		DemoWalletRateLimit: demoWalletRateLimit,
+
+		BenchmarkRateLimit: benchmarkRateLimit,
+
+		AuditMode:       getEnv("AUDIT_MODE", "off"),
+		AuditMaxEntries: auditMaxEntries,
+
+		EncryptionCurrentVersion:    encryptionCurrentVersion,
+		EncryptionSupportedVersions: encryptionSupportedVersions,
+
+		MigrationURL:     getEnv("MIGRATION_URL", ""),
+		MigrationDate:    getEnv("MIGRATION_DATE", ""),
+		MigrationMessage: getEnv("MIGRATION_MESSAGE", ""),
+
+		ArchiveAfterMonths:     archiveAfterMonths,
+		ArchiveIntervalMinutes: archiveIntervalMinutes,
+		S3Endpoint:             getEnv("S3_ENDPOINT", ""),
+		S3Region:               getEnv("S3_REGION", "us-east-1"),
+		S3Bucket:               getEnv("S3_BUCKET", ""),
+		S3AccessKey:            getEnv("S3_ACCESS_KEY", ""),
+		S3SecretKey:            getEnv("S3_SECRET_KEY", ""),
+		S3UseSSL:               getEnv("S3_USE_SSL", "true") == "true",
+
+		AttachmentStore:    getEnv("ATTACHMENT_STORE", "off"),
+		AttachmentDir:      getEnv("ATTACHMENT_DIR", "./data/attachments"),
+		AttachmentMaxBytes: attachmentMaxBytes,
+
+		AttachmentGCIntervalMinutes: attachmentGCIntervalMinutes,
+		AttachmentGCGraceHours:      attachmentGCGraceHours,
+
+		DraftTTLHours: draftTTLHours,
 	}
 }
 
@@ -37,3 +349,33 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// getInterval reads a timer interval, which has to be a positive number: tickers panic otherwise
+func getInterval(key, defaultValue string) int {
+	value := getEnv(key, defaultValue)
+	interval, err := strconv.Atoi(value)
+	if err != nil || interval <= 0 {
+		log.Fatalf("%s must be a positive integer, got %q", key, value)
+	}
+	return interval
+}
+
+func parseIntList(value string) []int {
+	var result []int
+	for _, part := range strings.Split(value, ",") {
+		if n, err := strconv.Atoi(strings.TrimSpace(part)); err == nil {
+			result = append(result, n)
+		}
+	}
+	return result
+}
+
+func parseList(value string) []string {
+	var result []string
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			result = append(result, part)
+		}
+	}
+	return result
+}
@@ -0,0 +1,96 @@
+package database
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrNotFound is returned by non-Redis backends and transactions for missing keys, fields and list
+// elements
+var ErrNotFound = errors.New("not found")
+
+// ErrConcurrentUpdate is returned by HUpdate and Atomic when what they read kept changing while
+// they were writing
+var ErrConcurrentUpdate = errors.New("concurrent update")
+
+// Backend is the key-value storage used by the services. Its operations follow Redis
+// semantics; RedisClient implements it natively and BoltStore emulates it on an embedded database.
+type Backend interface {
+	Close() error
+
+	// Atomic runs fn against a transaction: the writes fn makes through tx take effect together
+	// once it returns nil, and not at all if it returns an error. Reads through tx see its writes,
+	// and fn runs again if what it read changes before the writes are applied, so it mustn't have
+	// side effects besides them. Nested calls join the outer transaction.
+	Atomic(ctx context.Context, fn func(tx Backend) error) error
+
+	// Strings
+	Set(ctx context.Context, key string, value interface{}, expiration int64) error
+	SetNX(ctx context.Context, key string, value interface{}, expiration int64) (bool, error)
+	Get(ctx context.Context, key string) (string, error)
+	GetDel(ctx context.Context, key string) (string, error)
+	MGet(ctx context.Context, keys ...string) ([]interface{}, error)
+	Del(ctx context.Context, key string) error
+	Incr(ctx context.Context, key string) (int64, error)
+	IncrBy(ctx context.Context, key string, value int64) (int64, error)
+	Keys(ctx context.Context, pattern string) ([]string, error)
+
+	// Lists
+	LPush(ctx context.Context, key string, values ...interface{}) error
+	RPop(ctx context.Context, key string) (string, error)
+	LLen(ctx context.Context, key string) (int64, error)
+	LIndex(ctx context.Context, key string, index int64) (string, error)
+	LRange(ctx context.Context, key string, start, stop int64) ([]string, error)
+	LTrim(ctx context.Context, key string, start, stop int64) error
+
+	// Hashes
+	HSet(ctx context.Context, key string, field string, value interface{}) error
+	HSetNX(ctx context.Context, key string, field string, value interface{}) (bool, error)
+	HGet(ctx context.Context, key string, field string) (string, error)
+	HMGet(ctx context.Context, key string, fields ...string) ([]interface{}, error)
+	HGetAll(ctx context.Context, key string) (map[string]string, error)
+	// HDel deletes fields, returning how many of them existed
+	HDel(ctx context.Context, key string, fields ...string) (int64, error)
+	// HUpdate reads fields, passes their values (nil for missing ones) to update and writes the
+	// fields update returns, as one atomic step. update may run more than once, so it mustn't have
+	// side effects.
+	HUpdate(ctx context.Context, key string, fields []string, update func(values []interface{}) (map[string]interface{}, error)) error
+
+	// Sets
+	SAdd(ctx context.Context, key string, members ...interface{}) error
+	SRem(ctx context.Context, key string, members ...interface{}) error
+	SMembers(ctx context.Context, key string) ([]string, error)
+
+	// Sorted sets
+	ZAdd(ctx context.Context, key string, score float64, member interface{}) error
+	ZRangeByScore(ctx context.Context, key string, min, max string) ([]string, error)
+	ZRevRangeByScore(ctx context.Context, key string, min, max string, offset, count int64) ([]string, error)
+	ZCount(ctx context.Context, key string, min, max string) (int64, error)
+	ZRem(ctx context.Context, key string, members ...interface{}) error
+
+	// Streams
+	XAdd(ctx context.Context, stream string, maxLen int64, values map[string]interface{}) (string, error)
+	XRange(ctx context.Context, stream, start, end string, count int64) ([]StreamEntry, error)
+	XRevRange(ctx context.Context, stream, end, start string, count int64) ([]StreamEntry, error)
+	XLen(ctx context.Context, stream string) (int64, error)
+	XTrimMinID(ctx context.Context, stream, minID string) error
+
+	// Pub/sub
+	// Publish sends message to the subscribers of channel; in a transaction, once it is applied
+	Publish(ctx context.Context, channel, message string) error
+	// Subscribe starts receiving the messages published on channel from now on
+	Subscribe(ctx context.Context, channel string) (Subscription, error)
+}
+
+// Subscription receives the messages published on a channel, until it is closed. Like Redis
+// pub/sub it doesn't queue them: a subscriber that falls behind, or whose connection to Redis is
+// re-established, misses messages published meanwhile.
+type Subscription interface {
+	Messages() <-chan string
+	Close() error
+}
+
+// subscriptionBuffer is how many messages a subscription holds for its subscriber
+const subscriptionBuffer = 64
+
+var _ Backend = (*RedisClient)(nil)
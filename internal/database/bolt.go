@@ -0,0 +1,1027 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// BoltStore implements Backend on an embedded bbolt database, for air-gapped or edge deployments
+// without Redis. Each Redis data type lives in its own top-level bucket; hashes, sets, sorted sets
+// and streams get a nested bucket per key. bbolt serializes writers, so every operation is atomic.
+// Pub/sub reaches the subscribers of the same process, the only one with the database open.
+type BoltStore struct {
+	db       *bolt.DB
+	tx       *bolt.Tx // the transaction of Atomic every operation runs in, nil outside of one
+	codec    CompressionPolicy
+	channels *boltChannels
+
+	published []boltMessage // messages published in the transaction, sent once it is committed
+}
+
+var (
+	boltStrings    = []byte("strings")
+	boltExpiry     = []byte("expiry") // key -> expiry time in unix ms
+	boltLists      = []byte("lists")  // key -> JSON array, head first
+	boltHashes     = []byte("hashes")
+	boltSets       = []byte("sets")
+	boltZSets      = []byte("zsets") // member -> float64 score
+	boltStreams    = []byte("streams")
+	boltStreamLens = []byte("stream_lengths")
+)
+
+// boltNestedTypes are the buckets that hold one nested bucket per key
+var boltNestedTypes = [][]byte{boltHashes, boltSets, boltZSets, boltStreams}
+
+func NewBoltStore(file string, compression CompressionPolicy) (*BoltStore, error) {
+	db, err := bolt.Open(file, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bolt database: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltStrings, boltExpiry, boltLists, boltHashes, boltSets, boltZSets, boltStreams, boltStreamLens} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize bolt database: %w", err)
+	}
+
+	return &BoltStore{db: db, codec: compression, channels: &boltChannels{subscribers: map[string]map[*boltSubscription]struct{}{}}}, nil
+}
+
+func (b *BoltStore) Close() error {
+	if b.tx != nil {
+		return nil
+	}
+	return b.db.Close()
+}
+
+// Atomic runs fn in one read-write transaction. bbolt serializes them, so fn runs once.
+func (b *BoltStore) Atomic(ctx context.Context, fn func(tx Backend) error) error {
+	if b.tx != nil {
+		return fn(b)
+	}
+	store := &BoltStore{db: b.db, codec: b.codec, channels: b.channels}
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		store.tx = tx
+		return fn(store)
+	})
+	if err != nil {
+		return err
+	}
+	for _, message := range store.published {
+		b.channels.publish(message.channel, message.message)
+	}
+	return nil
+}
+
+// update runs fn in a read-write transaction of its own, or in Atomic's
+func (b *BoltStore) update(fn func(tx *bolt.Tx) error) error {
+	if b.tx != nil {
+		return fn(b.tx)
+	}
+	return b.db.Update(fn)
+}
+
+// view runs fn in a read-only transaction of its own, or in Atomic's
+func (b *BoltStore) view(fn func(tx *bolt.Tx) error) error {
+	if b.tx != nil {
+		return fn(b.tx)
+	}
+	return b.db.View(fn)
+}
+
+// Strings
+
+func (b *BoltStore) Set(ctx context.Context, key string, value interface{}, expiration int64) error {
+	return b.update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(boltStrings).Put([]byte(key), []byte(toString(b.codec.compress(toString(value))))); err != nil {
+			return err
+		}
+		if expiration > 0 {
+			expiresAt := time.Now().Add(time.Duration(expiration) * time.Second).UnixMilli()
+			return tx.Bucket(boltExpiry).Put([]byte(key), encodeUint(uint64(expiresAt)))
+		}
+		return tx.Bucket(boltExpiry).Delete([]byte(key))
+	})
+}
+
+func (b *BoltStore) SetNX(ctx context.Context, key string, value interface{}, expiration int64) (bool, error) {
+	set := false
+	err := b.update(func(tx *bolt.Tx) error {
+		if getString(tx, key) != nil {
+			return nil
+		}
+		set = true
+		if err := tx.Bucket(boltStrings).Put([]byte(key), []byte(toString(b.codec.compress(toString(value))))); err != nil {
+			return err
+		}
+		if expiration > 0 {
+			expiresAt := time.Now().Add(time.Duration(expiration) * time.Second).UnixMilli()
+			return tx.Bucket(boltExpiry).Put([]byte(key), encodeUint(uint64(expiresAt)))
+		}
+		return tx.Bucket(boltExpiry).Delete([]byte(key))
+	})
+	return set && err == nil, err
+}
+
+func (b *BoltStore) Get(ctx context.Context, key string) (string, error) {
+	var value string
+	err := b.view(func(tx *bolt.Tx) error {
+		data := getString(tx, key)
+		if data == nil {
+			return ErrNotFound
+		}
+		value = string(data)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return decompress(value)
+}
+
+func (b *BoltStore) GetDel(ctx context.Context, key string) (string, error) {
+	var value string
+	err := b.update(func(tx *bolt.Tx) error {
+		data := getString(tx, key)
+		if data == nil {
+			return ErrNotFound
+		}
+		value = string(data)
+		if err := tx.Bucket(boltStrings).Delete([]byte(key)); err != nil {
+			return err
+		}
+		return tx.Bucket(boltExpiry).Delete([]byte(key))
+	})
+	if err != nil {
+		return "", err
+	}
+	return decompress(value)
+}
+
+func (b *BoltStore) MGet(ctx context.Context, keys ...string) ([]interface{}, error) {
+	values := make([]interface{}, len(keys))
+	err := b.view(func(tx *bolt.Tx) error {
+		for i, key := range keys {
+			if data := getString(tx, key); data != nil {
+				values[i] = string(data)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return decompressAll(values)
+}
+
+func (b *BoltStore) Del(ctx context.Context, key string) error {
+	return b.update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltStrings, boltExpiry, boltLists, boltStreamLens} {
+			if err := tx.Bucket(name).Delete([]byte(key)); err != nil {
+				return err
+			}
+		}
+		for _, name := range boltNestedTypes {
+			if err := tx.Bucket(name).DeleteBucket([]byte(key)); err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *BoltStore) Incr(ctx context.Context, key string) (int64, error) {
+	return b.IncrBy(ctx, key, 1)
+}
+
+func (b *BoltStore) IncrBy(ctx context.Context, key string, increment int64) (int64, error) {
+	var value int64
+	err := b.update(func(tx *bolt.Tx) error {
+		if data := getString(tx, key); data != nil {
+			current, err := strconv.ParseInt(string(data), 10, 64)
+			if err != nil {
+				return fmt.Errorf("value is not an integer: %w", err)
+			}
+			value = current
+		}
+		value += increment
+		return tx.Bucket(boltStrings).Put([]byte(key), []byte(strconv.FormatInt(value, 10)))
+	})
+	return value, err
+}
+
+// Keys returns the keys of all types matching a glob-style pattern
+func (b *BoltStore) Keys(ctx context.Context, pattern string) ([]string, error) {
+	// Everything before the first wildcard has to match literally, so only that range is scanned
+	prefix := []byte(pattern)
+	if i := strings.IndexAny(pattern, "*?[\\"); i >= 0 {
+		prefix = prefix[:i]
+	}
+
+	var keys []string
+	err := b.view(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltStrings, boltLists, boltHashes, boltSets, boltZSets, boltStreams} {
+			c := tx.Bucket(name).Cursor()
+			for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+				if bytes.Equal(name, boltStrings) && getString(tx, string(k)) == nil {
+					continue // expired
+				}
+				if ok, _ := path.Match(pattern, string(k)); ok {
+					keys = append(keys, string(k))
+				}
+			}
+		}
+		return nil
+	})
+	return keys, err
+}
+
+// getString returns a string value, treating expired keys as missing
+func getString(tx *bolt.Tx, key string) []byte {
+	if expiresAt := tx.Bucket(boltExpiry).Get([]byte(key)); expiresAt != nil {
+		if int64(binary.BigEndian.Uint64(expiresAt)) <= time.Now().UnixMilli() {
+			return nil
+		}
+	}
+	return tx.Bucket(boltStrings).Get([]byte(key))
+}
+
+// Lists
+
+func (b *BoltStore) LPush(ctx context.Context, key string, values ...interface{}) error {
+	return b.updateList(key, func(list []string) []string {
+		for _, v := range values {
+			list = append([]string{toString(v)}, list...)
+		}
+		return list
+	})
+}
+
+func (b *BoltStore) RPop(ctx context.Context, key string) (string, error) {
+	var value string
+	err := b.update(func(tx *bolt.Tx) error {
+		list, err := loadList(tx, key)
+		if err != nil {
+			return err
+		}
+		if len(list) == 0 {
+			return ErrNotFound
+		}
+		value = list[len(list)-1]
+		return saveList(tx, key, list[:len(list)-1])
+	})
+	return value, err
+}
+
+func (b *BoltStore) LLen(ctx context.Context, key string) (int64, error) {
+	list, err := b.viewList(key)
+	return int64(len(list)), err
+}
+
+func (b *BoltStore) LIndex(ctx context.Context, key string, index int64) (string, error) {
+	list, err := b.viewList(key)
+	if err != nil {
+		return "", err
+	}
+	n := int64(len(list))
+	if index < 0 {
+		index += n
+	}
+	if index < 0 || index >= n {
+		return "", ErrNotFound
+	}
+	return list[index], nil
+}
+
+func (b *BoltStore) LRange(ctx context.Context, key string, start, stop int64) ([]string, error) {
+	list, err := b.viewList(key)
+	if err != nil {
+		return nil, err
+	}
+	start, stop = listRange(int64(len(list)), start, stop)
+	return append([]string{}, list[start:stop]...), nil
+}
+
+func (b *BoltStore) LTrim(ctx context.Context, key string, start, stop int64) error {
+	return b.updateList(key, func(list []string) []string {
+		start, stop := listRange(int64(len(list)), start, stop)
+		return list[start:stop]
+	})
+}
+
+// listRange converts Redis-style inclusive, possibly negative indexes into a slice range
+func listRange(n, start, stop int64) (int64, int64) {
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	start = max(start, 0)
+	stop = min(stop, n-1)
+	if start > stop {
+		return 0, 0
+	}
+	return start, stop + 1
+}
+
+func (b *BoltStore) viewList(key string) ([]string, error) {
+	var list []string
+	err := b.view(func(tx *bolt.Tx) error {
+		var err error
+		list, err = loadList(tx, key)
+		return err
+	})
+	return list, err
+}
+
+func (b *BoltStore) updateList(key string, update func([]string) []string) error {
+	return b.update(func(tx *bolt.Tx) error {
+		list, err := loadList(tx, key)
+		if err != nil {
+			return err
+		}
+		return saveList(tx, key, update(list))
+	})
+}
+
+func loadList(tx *bolt.Tx, key string) ([]string, error) {
+	data := tx.Bucket(boltLists).Get([]byte(key))
+	if data == nil {
+		return nil, nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("corrupt list %s: %w", key, err)
+	}
+	return list, nil
+}
+
+// saveList stores a list; like Redis, empty lists are removed
+func saveList(tx *bolt.Tx, key string, list []string) error {
+	if len(list) == 0 {
+		return tx.Bucket(boltLists).Delete([]byte(key))
+	}
+	data, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(boltLists).Put([]byte(key), data)
+}
+
+// Hashes
+
+func (b *BoltStore) HSet(ctx context.Context, key string, field string, value interface{}) error {
+	return b.update(func(tx *bolt.Tx) error {
+		bucket, err := tx.Bucket(boltHashes).CreateBucketIfNotExists([]byte(key))
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(field), []byte(toString(b.codec.compress(toString(value)))))
+	})
+}
+
+func (b *BoltStore) HSetNX(ctx context.Context, key string, field string, value interface{}) (bool, error) {
+	set := false
+	err := b.update(func(tx *bolt.Tx) error {
+		bucket, err := tx.Bucket(boltHashes).CreateBucketIfNotExists([]byte(key))
+		if err != nil {
+			return err
+		}
+		if bucket.Get([]byte(field)) != nil {
+			return nil
+		}
+		set = true
+		return bucket.Put([]byte(field), []byte(toString(b.codec.compress(toString(value)))))
+	})
+	return set && err == nil, err
+}
+
+func (b *BoltStore) HGet(ctx context.Context, key string, field string) (string, error) {
+	var value string
+	err := b.view(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltHashes).Bucket([]byte(key))
+		if bucket == nil {
+			return ErrNotFound
+		}
+		data := bucket.Get([]byte(field))
+		if data == nil {
+			return ErrNotFound
+		}
+		value = string(data)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return decompress(value)
+}
+
+func (b *BoltStore) HMGet(ctx context.Context, key string, fields ...string) ([]interface{}, error) {
+	values := make([]interface{}, len(fields))
+	err := b.view(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltHashes).Bucket([]byte(key))
+		if bucket == nil {
+			return nil
+		}
+		for i, field := range fields {
+			if data := bucket.Get([]byte(field)); data != nil {
+				values[i] = string(data)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return decompressAll(values)
+}
+
+func (b *BoltStore) HUpdate(ctx context.Context, key string, fields []string, update func(values []interface{}) (map[string]interface{}, error)) error {
+	return b.update(func(tx *bolt.Tx) error {
+		values := make([]interface{}, len(fields))
+		if bucket := tx.Bucket(boltHashes).Bucket([]byte(key)); bucket != nil {
+			for i, field := range fields {
+				if data := bucket.Get([]byte(field)); data != nil {
+					values[i] = string(data)
+				}
+			}
+		}
+		values, err := decompressAll(values)
+		if err != nil {
+			return err
+		}
+
+		changes, err := update(values)
+		if err != nil || len(changes) == 0 {
+			return err
+		}
+		bucket, err := tx.Bucket(boltHashes).CreateBucketIfNotExists([]byte(key))
+		if err != nil {
+			return err
+		}
+		for field, value := range changes {
+			if err := bucket.Put([]byte(field), []byte(toString(b.codec.compress(toString(value))))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *BoltStore) HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	values := map[string]string{}
+	err := b.view(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltHashes).Bucket([]byte(key))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			decoded, err := decompress(string(v))
+			if err != nil {
+				return err
+			}
+			values[string(k)] = decoded
+			return nil
+		})
+	})
+	return values, err
+}
+
+func (b *BoltStore) HDel(ctx context.Context, key string, fields ...string) (int64, error) {
+	return b.deleteMembers(boltHashes, key, fields)
+}
+
+// Sets
+
+func (b *BoltStore) SAdd(ctx context.Context, key string, members ...interface{}) error {
+	return b.update(func(tx *bolt.Tx) error {
+		bucket, err := tx.Bucket(boltSets).CreateBucketIfNotExists([]byte(key))
+		if err != nil {
+			return err
+		}
+		for _, member := range members {
+			if err := bucket.Put([]byte(toString(member)), []byte{}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *BoltStore) SRem(ctx context.Context, key string, members ...interface{}) error {
+	_, err := b.deleteMembers(boltSets, key, toStrings(members))
+	return err
+}
+
+func (b *BoltStore) SMembers(ctx context.Context, key string) ([]string, error) {
+	members := []string{}
+	err := b.view(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltSets).Bucket([]byte(key))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, _ []byte) error {
+			members = append(members, string(k))
+			return nil
+		})
+	})
+	return members, err
+}
+
+// deleteMembers removes entries from a nested bucket, dropping the bucket once it is empty. It
+// returns how many of the entries existed.
+func (b *BoltStore) deleteMembers(kind []byte, key string, members []string) (int64, error) {
+	var removed int64
+	err := b.update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(kind).Bucket([]byte(key))
+		if bucket == nil {
+			return nil
+		}
+		for _, member := range members {
+			if bucket.Get([]byte(member)) == nil {
+				continue
+			}
+			if err := bucket.Delete([]byte(member)); err != nil {
+				return err
+			}
+			removed++
+		}
+		if k, _ := bucket.Cursor().First(); k == nil {
+			return tx.Bucket(kind).DeleteBucket([]byte(key))
+		}
+		return nil
+	})
+	return removed, err
+}
+
+// Sorted sets
+
+type scoredMember struct {
+	member string
+	score  float64
+}
+
+func (b *BoltStore) ZAdd(ctx context.Context, key string, score float64, member interface{}) error {
+	return b.update(func(tx *bolt.Tx) error {
+		bucket, err := tx.Bucket(boltZSets).CreateBucketIfNotExists([]byte(key))
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(toString(member)), encodeUint(math.Float64bits(score)))
+	})
+}
+
+func (b *BoltStore) ZRangeByScore(ctx context.Context, key string, min, max string) ([]string, error) {
+	members, err := b.zrangeByScore(key, min, max)
+	if err != nil {
+		return nil, err
+	}
+	return memberNames(members), nil
+}
+
+func (b *BoltStore) ZRevRangeByScore(ctx context.Context, key string, min, max string, offset, count int64) ([]string, error) {
+	members, err := b.zrangeByScore(key, min, max)
+	if err != nil {
+		return nil, err
+	}
+	return reverseMemberNames(members, offset, count), nil
+}
+
+func (b *BoltStore) ZCount(ctx context.Context, key string, min, max string) (int64, error) {
+	members, err := b.zrangeByScore(key, min, max)
+	return int64(len(members)), err
+}
+
+func (b *BoltStore) ZRem(ctx context.Context, key string, members ...interface{}) error {
+	_, err := b.deleteMembers(boltZSets, key, toStrings(members))
+	return err
+}
+
+// zrangeByScore returns the members within the score bounds, ordered by score then member like Redis
+func (b *BoltStore) zrangeByScore(key string, min, max string) ([]scoredMember, error) {
+	within, err := scoreRange(min, max)
+	if err != nil {
+		return nil, err
+	}
+
+	var members []scoredMember
+	err = b.view(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltZSets).Bucket([]byte(key))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			if score := math.Float64frombits(binary.BigEndian.Uint64(v)); within(score) {
+				members = append(members, scoredMember{member: string(k), score: score})
+			}
+			return nil
+		})
+	})
+
+	sortScored(members)
+	return members, err
+}
+
+// scoreRange returns whether scores are within the ZRANGEBYSCORE bounds min and max
+func scoreRange(min, max string) (func(score float64) bool, error) {
+	lower, lowerExclusive, err := parseScoreBound(min)
+	if err != nil {
+		return nil, err
+	}
+	upper, upperExclusive, err := parseScoreBound(max)
+	if err != nil {
+		return nil, err
+	}
+	return func(score float64) bool {
+		return !(score < lower || (lowerExclusive && score == lower) || score > upper || (upperExclusive && score == upper))
+	}, nil
+}
+
+func memberNames(members []scoredMember) []string {
+	names := make([]string, len(members))
+	for i, m := range members {
+		names[i] = m.member
+	}
+	return names
+}
+
+// reverseMemberNames returns up to count members, highest first, skipping the offset highest. A
+// count of 0 returns all of them.
+func reverseMemberNames(members []scoredMember, offset, count int64) []string {
+	names := []string{}
+	for i := len(members) - 1 - int(offset); i >= 0; i-- {
+		if count > 0 && int64(len(names)) >= count {
+			break
+		}
+		names = append(names, members[i].member)
+	}
+	return names
+}
+
+// sortScored orders members by score then member like Redis
+func sortScored(members []scoredMember) {
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].score != members[j].score {
+			return members[i].score < members[j].score
+		}
+		return members[i].member < members[j].member
+	})
+}
+
+// parseScoreBound parses a ZRANGEBYSCORE bound such as "-inf", "42" or "(42"
+func parseScoreBound(bound string) (float64, bool, error) {
+	exclusive := strings.HasPrefix(bound, "(")
+	bound = strings.TrimPrefix(bound, "(")
+
+	switch bound {
+	case "-inf":
+		return math.Inf(-1), exclusive, nil
+	case "+inf", "inf":
+		return math.Inf(1), exclusive, nil
+	}
+
+	value, err := strconv.ParseFloat(bound, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid score bound %q: %w", bound, err)
+	}
+	return value, exclusive, nil
+}
+
+// Streams
+
+func (b *BoltStore) XAdd(ctx context.Context, stream string, maxLen int64, values map[string]interface{}) (string, error) {
+	fields := make(map[string]string, len(values))
+	for k, v := range values {
+		fields[k] = toString(v)
+	}
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return "", err
+	}
+
+	var id string
+	err = b.update(func(tx *bolt.Tx) error {
+		bucket, err := tx.Bucket(boltStreams).CreateBucketIfNotExists([]byte(stream))
+		if err != nil {
+			return err
+		}
+
+		// IDs are <ms>-<seq> and strictly increasing, even if the clock goes backwards
+		ms, seq := uint64(time.Now().UnixMilli()), uint64(0)
+		if last, _ := bucket.Cursor().Last(); last != nil {
+			lastMs, lastSeq := decodeStreamID(last)
+			if lastMs >= ms {
+				ms, seq = lastMs, lastSeq+1
+			}
+		}
+		if err := bucket.Put(encodeStreamID(ms, seq), data); err != nil {
+			return err
+		}
+		id = fmt.Sprintf("%d-%d", ms, seq)
+
+		length := streamLength(tx, stream) + 1
+		if maxLen > 0 {
+			c := bucket.Cursor()
+			for k, _ := c.First(); k != nil && length > uint64(maxLen); k, _ = c.First() {
+				if err := c.Delete(); err != nil {
+					return err
+				}
+				length--
+			}
+		}
+		return tx.Bucket(boltStreamLens).Put([]byte(stream), encodeUint(length))
+	})
+	return id, err
+}
+
+func (b *BoltStore) XRange(ctx context.Context, stream, start, end string, count int64) ([]StreamEntry, error) {
+	startKey, err := parseStreamBound(start, false)
+	if err != nil {
+		return nil, err
+	}
+	endKey, err := parseStreamBound(end, true)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []StreamEntry{}
+	err = b.view(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltStreams).Bucket([]byte(stream))
+		if bucket == nil {
+			return nil
+		}
+		c := bucket.Cursor()
+		for k, v := c.Seek(startKey); k != nil && bytes.Compare(k, endKey) <= 0; k, v = c.Next() {
+			if count > 0 && int64(len(entries)) >= count {
+				break
+			}
+			entry, err := decodeStreamEntry(k, v)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	return entries, err
+}
+
+func (b *BoltStore) XRevRange(ctx context.Context, stream, end, start string, count int64) ([]StreamEntry, error) {
+	startKey, err := parseStreamBound(start, false)
+	if err != nil {
+		return nil, err
+	}
+	endKey, err := parseStreamBound(end, true)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []StreamEntry{}
+	err = b.view(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltStreams).Bucket([]byte(stream))
+		if bucket == nil {
+			return nil
+		}
+		c := bucket.Cursor()
+		k, v := c.Seek(endKey)
+		if k == nil {
+			k, v = c.Last()
+		} else if bytes.Compare(k, endKey) > 0 {
+			k, v = c.Prev()
+		}
+		for ; k != nil && bytes.Compare(k, startKey) >= 0; k, v = c.Prev() {
+			if count > 0 && int64(len(entries)) >= count {
+				break
+			}
+			entry, err := decodeStreamEntry(k, v)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	return entries, err
+}
+
+func (b *BoltStore) XLen(ctx context.Context, stream string) (int64, error) {
+	var length uint64
+	err := b.view(func(tx *bolt.Tx) error {
+		length = streamLength(tx, stream)
+		return nil
+	})
+	return int64(length), err
+}
+
+func (b *BoltStore) XTrimMinID(ctx context.Context, stream, minID string) error {
+	minKey, err := parseStreamBound(minID, false)
+	if err != nil {
+		return err
+	}
+
+	return b.update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltStreams).Bucket([]byte(stream))
+		if bucket == nil {
+			return nil
+		}
+		length := streamLength(tx, stream)
+		c := bucket.Cursor()
+		for k, _ := c.First(); k != nil && bytes.Compare(k, minKey) < 0; k, _ = c.First() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+			length--
+		}
+		return tx.Bucket(boltStreamLens).Put([]byte(stream), encodeUint(length))
+	})
+}
+
+func streamLength(tx *bolt.Tx, stream string) uint64 {
+	if data := tx.Bucket(boltStreamLens).Get([]byte(stream)); data != nil {
+		return binary.BigEndian.Uint64(data)
+	}
+	return 0
+}
+
+// parseStreamBound parses an XRANGE bound ("-", "+", "<ms>" or "<ms>-<seq>") into a key.
+// A bare millisecond covers the whole millisecond, so its sequence depends on the side of the range.
+func parseStreamBound(bound string, upper bool) ([]byte, error) {
+	switch bound {
+	case "-":
+		return encodeStreamID(0, 0), nil
+	case "+":
+		return encodeStreamID(math.MaxUint64, math.MaxUint64), nil
+	}
+
+	msPart, seqPart, hasSeq := strings.Cut(bound, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid stream ID %q: %w", bound, err)
+	}
+
+	seq := uint64(0)
+	if upper {
+		seq = math.MaxUint64
+	}
+	if hasSeq {
+		if seq, err = strconv.ParseUint(seqPart, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid stream ID %q: %w", bound, err)
+		}
+	}
+	return encodeStreamID(ms, seq), nil
+}
+
+func encodeStreamID(ms, seq uint64) []byte {
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key[:8], ms)
+	binary.BigEndian.PutUint64(key[8:], seq)
+	return key
+}
+
+func decodeStreamID(key []byte) (uint64, uint64) {
+	return binary.BigEndian.Uint64(key[:8]), binary.BigEndian.Uint64(key[8:])
+}
+
+func decodeStreamEntry(key, data []byte) (StreamEntry, error) {
+	var fields map[string]string
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return StreamEntry{}, fmt.Errorf("corrupt stream entry: %w", err)
+	}
+
+	values := make(map[string]interface{}, len(fields))
+	for k, v := range fields {
+		values[k] = v
+	}
+
+	ms, seq := decodeStreamID(key)
+	return StreamEntry{ID: fmt.Sprintf("%d-%d", ms, seq), Values: values}, nil
+}
+
+// Helpers
+
+func encodeUint(v uint64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, v)
+	return buf
+}
+
+// toString formats a value the way Redis clients send arguments
+func toString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func toStrings(values []interface{}) []string {
+	result := make([]string, len(values))
+	for i, v := range values {
+		result[i] = toString(v)
+	}
+	return result
+}
+
+var _ Backend = (*BoltStore)(nil)
+
+// Pub/sub
+
+// boltChannels are the subscriptions of a BoltStore's channels
+type boltChannels struct {
+	mu          sync.Mutex
+	subscribers map[string]map[*boltSubscription]struct{}
+}
+
+type boltMessage struct {
+	channel string
+	message string
+}
+
+// boltSubscription is a subscription to a channel of a BoltStore
+type boltSubscription struct {
+	channels *boltChannels
+	channel  string
+	messages chan string
+	closed   bool
+}
+
+func (b *BoltStore) Publish(ctx context.Context, channel, message string) error {
+	if b.tx != nil {
+		b.published = append(b.published, boltMessage{channel: channel, message: message})
+		return nil
+	}
+	b.channels.publish(channel, message)
+	return nil
+}
+
+func (b *BoltStore) Subscribe(ctx context.Context, channel string) (Subscription, error) {
+	sub := &boltSubscription{channels: b.channels, channel: channel, messages: make(chan string, subscriptionBuffer)}
+
+	b.channels.mu.Lock()
+	defer b.channels.mu.Unlock()
+	if b.channels.subscribers[channel] == nil {
+		b.channels.subscribers[channel] = map[*boltSubscription]struct{}{}
+	}
+	b.channels.subscribers[channel][sub] = struct{}{}
+	return sub, nil
+}
+
+// publish sends message to the subscribers of channel, skipping those that fell behind
+func (c *boltChannels) publish(channel, message string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for sub := range c.subscribers[channel] {
+		select {
+		case sub.messages <- message:
+		default:
+		}
+	}
+}
+
+func (s *boltSubscription) Messages() <-chan string {
+	return s.messages
+}
+
+func (s *boltSubscription) Close() error {
+	s.channels.mu.Lock()
+	defer s.channels.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	delete(s.channels.subscribers[s.channel], s)
+	if len(s.channels.subscribers[s.channel]) == 0 {
+		delete(s.channels.subscribers, s.channel)
+	}
+	close(s.messages)
+	return nil
+}
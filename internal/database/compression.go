@@ -0,0 +1,106 @@
+package database
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Header bytes marking compressed values. Stored values are otherwise JSON or plain
+// strings, which never start with these control characters.
+const (
+	headerGzip byte = 0x01
+	headerZstd byte = 0x02
+)
+
+// CompressionPolicy configures compression of stored string values
+type CompressionPolicy struct {
+	Algorithm string // "off", "gzip" or "zstd"
+	MinBytes  int    // values shorter than this are stored as-is
+}
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+// compress encodes a value for storage according to the policy. Values that are not
+// strings, are too small, or don't shrink are returned unchanged.
+func (p CompressionPolicy) compress(value interface{}) interface{} {
+	s, ok := value.(string)
+	if !ok || p.Algorithm == "" || p.Algorithm == "off" || len(s) < p.MinBytes {
+		return value
+	}
+
+	var encoded []byte
+	switch p.Algorithm {
+	case "zstd":
+		encoded = zstdEncoder.EncodeAll([]byte(s), []byte{headerZstd})
+	case "gzip":
+		var buf bytes.Buffer
+		buf.WriteByte(headerGzip)
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write([]byte(s)); err != nil {
+			return value
+		}
+		if err := w.Close(); err != nil {
+			return value
+		}
+		encoded = buf.Bytes()
+	default:
+		return value
+	}
+
+	if len(encoded) >= len(s) {
+		return value
+	}
+	return string(encoded)
+}
+
+// decompress decodes a stored value. Uncompressed values are returned unchanged,
+// so data written before compression was enabled stays readable.
+func decompress(value string) (string, error) {
+	if value == "" {
+		return value, nil
+	}
+
+	switch value[0] {
+	case headerZstd:
+		decoded, err := zstdDecoder.DecodeAll([]byte(value[1:]), nil)
+		if err != nil {
+			return "", fmt.Errorf("failed to decompress zstd value: %w", err)
+		}
+		return string(decoded), nil
+	case headerGzip:
+		r, err := gzip.NewReader(bytes.NewReader([]byte(value[1:])))
+		if err != nil {
+			return "", fmt.Errorf("failed to decompress gzip value: %w", err)
+		}
+		defer r.Close()
+		decoded, err := io.ReadAll(r)
+		if err != nil {
+			return "", fmt.Errorf("failed to decompress gzip value: %w", err)
+		}
+		return string(decoded), nil
+	}
+	return value, nil
+}
+
+// decompressAll decodes the string values of an MGET/HMGET reply in place
+func decompressAll(values []interface{}) ([]interface{}, error) {
+	for i, v := range values {
+		s, ok := v.(string)
+		if !ok {
+			continue
+		}
+		decoded, err := decompress(s)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = decoded
+	}
+	return values, nil
+}
@@ -0,0 +1,113 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Durability levels a write can ask for
+const (
+	DurabilityAck   = "ack"   // the backend applied the write; a crash right after may still lose it
+	DurabilityFlush = "flush" // the write reached disk or replicas, as the backend is configured
+)
+
+// ErrNotDurable is returned when writes could not be made durable in time
+var ErrNotDurable = errors.New("writes could not be made durable")
+
+// Flusher is implemented by backends that can make every write acknowledged so far durable
+type Flusher interface {
+	// CanFlush reports whether Flush waits for anything, i.e. whether DurabilityFlush is available
+	CanFlush() bool
+	Flush(ctx context.Context) error
+}
+
+// SupportedDurability returns the durability levels db supports
+func SupportedDurability(db Backend) []string {
+	if flusher, ok := db.(Flusher); ok && flusher.CanFlush() {
+		return []string{DurabilityAck, DurabilityFlush}
+	}
+	return []string{DurabilityAck}
+}
+
+// DurabilityPolicy configures what flushing Redis waits for. With neither set, Redis can't flush.
+type DurabilityPolicy struct {
+	LocalFsync bool          // the AOF was fsynced; needs Redis 7.2+ with appendonly enabled
+	Replicas   int           // replicas that acknowledged the writes
+	Timeout    time.Duration // how long to wait for them
+}
+
+// durabilityMarkerKey is written before waiting: WAIT and WAITAOF only cover the writes of their
+// own connection, and the marker's offset in the replication stream covers everyone's
+const durabilityMarkerKey = "durability_marker"
+
+func (r *RedisClient) CanFlush() bool {
+	return r.durability.LocalFsync || r.durability.Replicas > 0
+}
+
+// Flush waits until every write acknowledged so far is fsynced to the AOF and/or held by the
+// configured number of replicas
+func (r *RedisClient) Flush(ctx context.Context) error {
+	if !r.CanFlush() {
+		return fmt.Errorf("%w: no durability configured for Redis", ErrNotDurable)
+	}
+
+	// The wait itself may take up to the durability timeout on top of the usual operation timeout
+	ctx, cancel := context.WithCancel(ctx)
+	if r.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, r.timeout+r.durability.Timeout)
+	}
+	defer cancel()
+
+	timeoutMs := r.durability.Timeout.Milliseconds()
+	pipe := r.client.Pipeline()
+	pipe.Incr(ctx, durabilityMarkerKey)
+	var wait interface{ Result() (interface{}, error) }
+	if r.durability.LocalFsync {
+		wait = pipe.Do(ctx, "WAITAOF", 1, r.durability.Replicas, timeoutMs)
+	} else {
+		wait = pipe.Do(ctx, "WAIT", r.durability.Replicas, timeoutMs)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to flush writes: %w", err)
+	}
+
+	result, err := wait.Result()
+	if err != nil {
+		return fmt.Errorf("failed to flush writes: %w", err)
+	}
+	local, replicas := int64(0), int64(0)
+	switch v := result.(type) {
+	case int64: // WAIT: replicas
+		replicas = v
+	case []interface{}: // WAITAOF: local fsyncs, replicas
+		if len(v) == 2 {
+			local, _ = v[0].(int64)
+			replicas, _ = v[1].(int64)
+		}
+	}
+	if (r.durability.LocalFsync && local < 1) || replicas < int64(r.durability.Replicas) {
+		return fmt.Errorf("%w: %d of 1 local fsyncs and %d of %d replicas within %s", ErrNotDurable, local, replicas, r.durability.Replicas, r.durability.Timeout)
+	}
+	return nil
+}
+
+// CanFlush is always true: Bolt commits are fsynced
+func (b *BoltStore) CanFlush() bool {
+	return true
+}
+
+// Flush fsyncs the database file. Every committed transaction already is, so this only covers
+// writes made outside of one.
+func (b *BoltStore) Flush(ctx context.Context) error {
+	if err := b.db.Sync(); err != nil {
+		return fmt.Errorf("%w: %v", ErrNotDurable, err)
+	}
+	return nil
+}
+
+var (
+	_ Flusher = (*RedisClient)(nil)
+	_ Flusher = (*BoltStore)(nil)
+)
@@ -0,0 +1,133 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// MemoryStats is a snapshot of Redis memory usage
+type MemoryStats struct {
+	UsedMemory     int64
+	MaxMemory      int64 // 0 means no limit
+	EvictionPolicy string
+}
+
+// UsageRatio returns used/max memory, or 0 if Redis has no memory limit
+func (m MemoryStats) UsageRatio() float64 {
+	if m.MaxMemory <= 0 {
+		return 0
+	}
+	return float64(m.UsedMemory) / float64(m.MaxMemory)
+}
+
+// MemoryStats reads memory usage and eviction policy from INFO memory
+func (r *RedisClient) MemoryStats(ctx context.Context) (*MemoryStats, error) {
+	info, err := doResult(ctx, r, true, func(ctx context.Context) (string, error) {
+		return r.client.Info(ctx, "memory").Result()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Redis memory info: %w", err)
+	}
+
+	stats := &MemoryStats{}
+	for _, line := range strings.Split(info, "\n") {
+		key, value, found := strings.Cut(strings.TrimSpace(line), ":")
+		if !found {
+			continue
+		}
+
+		switch key {
+		case "used_memory":
+			stats.UsedMemory, _ = strconv.ParseInt(value, 10, 64)
+		case "maxmemory":
+			stats.MaxMemory, _ = strconv.ParseInt(value, 10, 64)
+		case "maxmemory_policy":
+			stats.EvictionPolicy = value
+		}
+	}
+
+	return stats, nil
+}
+
+// VerifyNoEviction fails unless Redis is configured with the noeviction policy.
+// Any other policy lets Redis silently delete user data when memory runs out.
+func (r *RedisClient) VerifyNoEviction(ctx context.Context) error {
+	stats, err := r.MemoryStats(ctx)
+	if err != nil {
+		return err
+	}
+
+	if stats.EvictionPolicy != "noeviction" {
+		return fmt.Errorf("redis maxmemory-policy is %q, expected \"noeviction\"", stats.EvictionPolicy)
+	}
+
+	return nil
+}
+
+// MemoryMonitor periodically samples Redis memory usage and flags memory pressure
+type MemoryMonitor struct {
+	db            *RedisClient
+	threshold     float64
+	underPressure atomic.Bool
+	usage         atomic.Uint64 // math.Float64bits of the last sampled usage ratio
+}
+
+func NewMemoryMonitor(db *RedisClient, threshold float64) *MemoryMonitor {
+	return &MemoryMonitor{
+		db:        db,
+		threshold: threshold,
+	}
+}
+
+// UnderPressure reports whether the last sample was above the threshold
+func (m *MemoryMonitor) UnderPressure() bool {
+	return m.underPressure.Load()
+}
+
+// Usage returns the last sampled memory usage ratio
+func (m *MemoryMonitor) Usage() float64 {
+	return math.Float64frombits(m.usage.Load())
+}
+
+// Run samples memory usage every interval until the process exits
+func (m *MemoryMonitor) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		m.check()
+		<-ticker.C
+	}
+}
+
+func (m *MemoryMonitor) check() {
+	stats, err := m.db.MemoryStats(context.Background())
+	if err != nil {
+		log.Printf("Warning: memory monitor failed to sample Redis: %v", err)
+		return
+	}
+
+	ratio := stats.UsageRatio()
+	m.usage.Store(math.Float64bits(ratio))
+	wasUnderPressure := m.underPressure.Load()
+
+	if ratio >= m.threshold {
+		m.underPressure.Store(true)
+		if !wasUnderPressure {
+			// Operators are alerted through the storage_full alert condition
+			log.Printf("Warning: Redis memory usage at %.1f%% (%d/%d bytes), refusing non-essential writes", ratio*100, stats.UsedMemory, stats.MaxMemory)
+		}
+		return
+	}
+
+	m.underPressure.Store(false)
+	if wasUnderPressure {
+		log.Printf("Redis memory usage back to %.1f%%, accepting writes again", ratio*100)
+	}
+}
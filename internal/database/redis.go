@@ -2,92 +2,468 @@ package database
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 )
 
 type RedisClient struct {
-	client *redis.Client
-	ctx    context.Context
+	client  *redis.Client
+	timeout time.Duration // per-operation timeout, 0 disables
+	retry   RetryPolicy
+	codec   CompressionPolicy
+
+	durability DurabilityPolicy // what Flush waits for
 }
 
-func NewRedisClient(url, password string, db int) (*RedisClient, error) {
+func NewRedisClient(url, password string, db int, timeout time.Duration, retry RetryPolicy, compression CompressionPolicy, durability DurabilityPolicy) (*RedisClient, error) {
 	rdb := redis.NewClient(&redis.Options{
 		Addr:     parseRedisURL(url),
 		Password: password,
 		DB:       db,
+		// Retries are handled by RedisClient so non-idempotent commands are never replayed
+		MaxRetries: -1,
 	})
 
-	ctx := context.Background()
+	r := &RedisClient{
+		client:     rdb,
+		timeout:    timeout,
+		retry:      retry,
+		codec:      compression,
+		durability: durability,
+	}
 
 	// Test connection
+	ctx, cancel := r.withTimeout(context.Background())
+	defer cancel()
 	if err := rdb.Ping(ctx).Err(); err != nil {
 		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
 	}
 
-	return &RedisClient{
-		client: rdb,
-		ctx:    ctx,
-	}, nil
+	return r, nil
+}
+
+// withTimeout bounds a single Redis operation so a slow Redis can't hang callers indefinitely
+func (r *RedisClient) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, r.timeout)
 }
 
 func (r *RedisClient) Close() error {
 	return r.client.Close()
 }
 
-func (r *RedisClient) Set(key string, value interface{}, expiration int64) error {
+func (r *RedisClient) Set(ctx context.Context, key string, value interface{}, expiration int64) error {
+	ttl := time.Duration(0)
 	if expiration > 0 {
-		return r.client.Set(r.ctx, key, value, time.Duration(expiration)*time.Second).Err()
+		ttl = time.Duration(expiration) * time.Second
+	}
+
+	return r.do(ctx, true, func(ctx context.Context) error {
+		return r.client.Set(ctx, key, r.codec.compress(value), ttl).Err()
+	})
+}
+
+// SetNX sets key only if it does not exist yet and reports whether it did
+func (r *RedisClient) SetNX(ctx context.Context, key string, value interface{}, expiration int64) (bool, error) {
+	ttl := time.Duration(0)
+	if expiration > 0 {
+		ttl = time.Duration(expiration) * time.Second
+	}
+
+	return doResult(ctx, r, false, func(ctx context.Context) (bool, error) {
+		return r.client.SetNX(ctx, key, r.codec.compress(value), ttl).Result()
+	})
+}
+
+func (r *RedisClient) Get(ctx context.Context, key string) (string, error) {
+	value, err := doResult(ctx, r, true, func(ctx context.Context) (string, error) {
+		return r.client.Get(ctx, key).Result()
+	})
+	if err != nil {
+		return "", err
+	}
+	return decompress(value)
+}
+
+// GetDel reads and deletes a key in one step, so at most one caller gets its value
+func (r *RedisClient) GetDel(ctx context.Context, key string) (string, error) {
+	value, err := doResult(ctx, r, false, func(ctx context.Context) (string, error) {
+		return r.client.GetDel(ctx, key).Result()
+	})
+	if err != nil {
+		return "", err
+	}
+	return decompress(value)
+}
+
+func (r *RedisClient) MGet(ctx context.Context, keys ...string) ([]interface{}, error) {
+	values, err := doResult(ctx, r, true, func(ctx context.Context) ([]interface{}, error) {
+		return r.client.MGet(ctx, keys...).Result()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return decompressAll(values)
+}
+
+func (r *RedisClient) Del(ctx context.Context, key string) error {
+	return r.do(ctx, true, func(ctx context.Context) error {
+		return r.client.Del(ctx, key).Err()
+	})
+}
+
+func (r *RedisClient) Incr(ctx context.Context, key string) (int64, error) {
+	return doResult(ctx, r, false, func(ctx context.Context) (int64, error) {
+		return r.client.Incr(ctx, key).Result()
+	})
+}
+
+func (r *RedisClient) IncrBy(ctx context.Context, key string, value int64) (int64, error) {
+	return doResult(ctx, r, false, func(ctx context.Context) (int64, error) {
+		return r.client.IncrBy(ctx, key, value).Result()
+	})
+}
+
+func (r *RedisClient) LPush(ctx context.Context, key string, values ...interface{}) error {
+	return r.do(ctx, false, func(ctx context.Context) error {
+		return r.client.LPush(ctx, key, values...).Err()
+	})
+}
+
+func (r *RedisClient) RPop(ctx context.Context, key string) (string, error) {
+	return doResult(ctx, r, false, func(ctx context.Context) (string, error) {
+		return r.client.RPop(ctx, key).Result()
+	})
+}
+
+func (r *RedisClient) LLen(ctx context.Context, key string) (int64, error) {
+	return doResult(ctx, r, true, func(ctx context.Context) (int64, error) {
+		return r.client.LLen(ctx, key).Result()
+	})
+}
+
+func (r *RedisClient) LIndex(ctx context.Context, key string, index int64) (string, error) {
+	return doResult(ctx, r, true, func(ctx context.Context) (string, error) {
+		return r.client.LIndex(ctx, key, index).Result()
+	})
+}
+
+func (r *RedisClient) LRange(ctx context.Context, key string, start, stop int64) ([]string, error) {
+	return doResult(ctx, r, true, func(ctx context.Context) ([]string, error) {
+		return r.client.LRange(ctx, key, start, stop).Result()
+	})
+}
+
+func (r *RedisClient) LTrim(ctx context.Context, key string, start, stop int64) error {
+	return r.do(ctx, true, func(ctx context.Context) error {
+		return r.client.LTrim(ctx, key, start, stop).Err()
+	})
+}
+
+func (r *RedisClient) HSet(ctx context.Context, key string, field string, value interface{}) error {
+	return r.do(ctx, true, func(ctx context.Context) error {
+		return r.client.HSet(ctx, key, field, r.codec.compress(value)).Err()
+	})
+}
+
+// HSetNX sets field only if the hash doesn't have it yet and reports whether it did
+func (r *RedisClient) HSetNX(ctx context.Context, key string, field string, value interface{}) (bool, error) {
+	return doResult(ctx, r, false, func(ctx context.Context) (bool, error) {
+		return r.client.HSetNX(ctx, key, field, r.codec.compress(value)).Result()
+	})
+}
+
+func (r *RedisClient) HGet(ctx context.Context, key string, field string) (string, error) {
+	value, err := doResult(ctx, r, true, func(ctx context.Context) (string, error) {
+		return r.client.HGet(ctx, key, field).Result()
+	})
+	if err != nil {
+		return "", err
+	}
+	return decompress(value)
+}
+
+func (r *RedisClient) HMGet(ctx context.Context, key string, fields ...string) ([]interface{}, error) {
+	values, err := doResult(ctx, r, true, func(ctx context.Context) ([]interface{}, error) {
+		return r.client.HMGet(ctx, key, fields...).Result()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return decompressAll(values)
+}
+
+// watchAttempts bounds how often HUpdate and Atomic start over when keys they read change under them
+const watchAttempts = 10
+
+// HUpdate watches the hash while reading it, so the write fails and is retried with fresh values
+// if another client changed the hash in between
+func (r *RedisClient) HUpdate(ctx context.Context, key string, fields []string, update func(values []interface{}) (map[string]interface{}, error)) error {
+	for attempt := 0; attempt < watchAttempts; attempt++ {
+		err := r.do(ctx, false, func(ctx context.Context) error {
+			return r.client.Watch(ctx, func(tx *redis.Tx) error {
+				values, err := tx.HMGet(ctx, key, fields...).Result()
+				if err != nil {
+					return err
+				}
+				if values, err = decompressAll(values); err != nil {
+					return err
+				}
+
+				changes, err := update(values)
+				if err != nil || len(changes) == 0 {
+					return err
+				}
+				args := make([]interface{}, 0, 2*len(changes))
+				for field, value := range changes {
+					args = append(args, field, r.codec.compress(value))
+				}
+				_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+					return pipe.HSet(ctx, key, args...).Err()
+				})
+				return err
+			}, key)
+		})
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+	return ErrConcurrentUpdate
+}
+
+func (r *RedisClient) HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	values, err := doResult(ctx, r, true, func(ctx context.Context) (map[string]string, error) {
+		return r.client.HGetAll(ctx, key).Result()
+	})
+	if err != nil {
+		return nil, err
+	}
+	for field, value := range values {
+		decoded, err := decompress(value)
+		if err != nil {
+			return nil, err
+		}
+		values[field] = decoded
 	}
-	return r.client.Set(r.ctx, key, value, 0).Err()
+	return values, nil
+}
+
+func (r *RedisClient) HDel(ctx context.Context, key string, fields ...string) (int64, error) {
+	// Not retried blindly: a retry after a delete that went through would report nothing removed
+	return doResult(ctx, r, false, func(ctx context.Context) (int64, error) {
+		return r.client.HDel(ctx, key, fields...).Result()
+	})
+}
+
+func (r *RedisClient) SAdd(ctx context.Context, key string, members ...interface{}) error {
+	return r.do(ctx, true, func(ctx context.Context) error {
+		return r.client.SAdd(ctx, key, members...).Err()
+	})
+}
+
+func (r *RedisClient) SRem(ctx context.Context, key string, members ...interface{}) error {
+	return r.do(ctx, true, func(ctx context.Context) error {
+		return r.client.SRem(ctx, key, members...).Err()
+	})
 }
 
-func (r *RedisClient) Get(key string) (string, error) {
-	return r.client.Get(r.ctx, key).Result()
+func (r *RedisClient) SMembers(ctx context.Context, key string) ([]string, error) {
+	return doResult(ctx, r, true, func(ctx context.Context) ([]string, error) {
+		return r.client.SMembers(ctx, key).Result()
+	})
 }
 
-func (r *RedisClient) Del(key string) error {
-	return r.client.Del(r.ctx, key).Err()
+func (r *RedisClient) Keys(ctx context.Context, pattern string) ([]string, error) {
+	return doResult(ctx, r, true, func(ctx context.Context) ([]string, error) {
+		return r.client.Keys(ctx, pattern).Result()
+	})
 }
 
-func (r *RedisClient) HSet(key string, field string, value interface{}) error {
-	return r.client.HSet(r.ctx, key, field, value).Err()
+func (r *RedisClient) ZAdd(ctx context.Context, key string, score float64, member interface{}) error {
+	return r.do(ctx, true, func(ctx context.Context) error {
+		return r.client.ZAdd(ctx, key, &redis.Z{
+			Score:  score,
+			Member: member,
+		}).Err()
+	})
 }
 
-func (r *RedisClient) HGet(key string, field string) (string, error) {
-	return r.client.HGet(r.ctx, key, field).Result()
+func (r *RedisClient) ZRangeByScore(ctx context.Context, key string, min, max string) ([]string, error) {
+	return doResult(ctx, r, true, func(ctx context.Context) ([]string, error) {
+		return r.client.ZRangeByScore(ctx, key, &redis.ZRangeBy{
+			Min: min,
+			Max: max,
+		}).Result()
+	})
 }
 
-func (r *RedisClient) HGetAll(key string) (map[string]string, error) {
-	return r.client.HGetAll(r.ctx, key).Result()
+func (r *RedisClient) ZRevRangeByScore(ctx context.Context, key string, min, max string, offset, count int64) ([]string, error) {
+	return doResult(ctx, r, true, func(ctx context.Context) ([]string, error) {
+		return r.client.ZRevRangeByScore(ctx, key, &redis.ZRangeBy{
+			Min:    min,
+			Max:    max,
+			Offset: offset,
+			Count:  count,
+		}).Result()
+	})
 }
 
-func (r *RedisClient) HDel(key string, fields ...string) error {
-	return r.client.HDel(r.ctx, key, fields...).Err()
+func (r *RedisClient) ZCount(ctx context.Context, key string, min, max string) (int64, error) {
+	return doResult(ctx, r, true, func(ctx context.Context) (int64, error) {
+		return r.client.ZCount(ctx, key, min, max).Result()
+	})
 }
 
-func (r *RedisClient) Keys(pattern string) ([]string, error) {
-	return r.client.Keys(r.ctx, pattern).Result()
+func (r *RedisClient) ZRem(ctx context.Context, key string, members ...interface{}) error {
+	return r.do(ctx, true, func(ctx context.Context) error {
+		return r.client.ZRem(ctx, key, members...).Err()
+	})
 }
 
-func (r *RedisClient) ZAdd(key string, score float64, member interface{}) error {
-	return r.client.ZAdd(r.ctx, key, &redis.Z{
-		Score:  score,
-		Member: member,
-	}).Err()
+// IsNotFound reports whether err indicates a missing key
+func IsNotFound(err error) bool {
+	return errors.Is(err, redis.Nil) || errors.Is(err, ErrNotFound)
 }
 
-func (r *RedisClient) ZRangeByScore(key string, min, max string) ([]string, error) {
-	return r.client.ZRangeByScore(r.ctx, key, &redis.ZRangeBy{
-		Min: min,
-		Max: max,
-	}).Result()
+func (r *RedisClient) XAdd(ctx context.Context, stream string, maxLen int64, values map[string]interface{}) (string, error) {
+	return doResult(ctx, r, false, func(ctx context.Context) (string, error) {
+		return r.client.XAdd(ctx, &redis.XAddArgs{
+			Stream: stream,
+			MaxLen: maxLen,
+			Approx: true,
+			Values: values,
+		}).Result()
+	})
+}
+
+// StreamEntry is a single entry read from a Redis stream
+type StreamEntry struct {
+	ID     string
+	Values map[string]interface{}
+}
+
+// XRange returns up to count stream entries with IDs between start and end, inclusive.
+// A count of 0 returns all of them.
+func (r *RedisClient) XRange(ctx context.Context, stream, start, end string, count int64) ([]StreamEntry, error) {
+	return doResult(ctx, r, true, func(ctx context.Context) ([]StreamEntry, error) {
+		return xRange(ctx, r.client, stream, start, end, count)
+	})
+}
+
+// XRevRange returns up to count stream entries with IDs between end and start, newest first
+func (r *RedisClient) XRevRange(ctx context.Context, stream, end, start string, count int64) ([]StreamEntry, error) {
+	return doResult(ctx, r, true, func(ctx context.Context) ([]StreamEntry, error) {
+		return xRevRange(ctx, r.client, stream, end, start, count)
+	})
 }
 
-func (r *RedisClient) ZRem(key string, members ...interface{}) error {
-	return r.client.ZRem(r.ctx, key, members...).Err()
+func xRange(ctx context.Context, c redis.Cmdable, stream, start, end string, count int64) ([]StreamEntry, error) {
+	var messages []redis.XMessage
+	var err error
+	if count > 0 {
+		messages, err = c.XRangeN(ctx, stream, start, end, count).Result()
+	} else {
+		messages, err = c.XRange(ctx, stream, start, end).Result()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return streamEntries(messages), nil
+}
+
+func xRevRange(ctx context.Context, c redis.Cmdable, stream, end, start string, count int64) ([]StreamEntry, error) {
+	messages, err := c.XRevRangeN(ctx, stream, end, start, count).Result()
+	if err != nil {
+		return nil, err
+	}
+	return streamEntries(messages), nil
+}
+
+func streamEntries(messages []redis.XMessage) []StreamEntry {
+	entries := make([]StreamEntry, len(messages))
+	for i, m := range messages {
+		entries[i] = StreamEntry{ID: m.ID, Values: m.Values}
+	}
+	return entries
+}
+
+func (r *RedisClient) XLen(ctx context.Context, stream string) (int64, error) {
+	return doResult(ctx, r, true, func(ctx context.Context) (int64, error) {
+		return r.client.XLen(ctx, stream).Result()
+	})
+}
+
+// XTrimMinID removes the stream entries with IDs lower than minID
+func (r *RedisClient) XTrimMinID(ctx context.Context, stream, minID string) error {
+	return r.do(ctx, true, func(ctx context.Context) error {
+		return r.client.XTrimMinID(ctx, stream, minID).Err()
+	})
+}
+
+// Pub/sub
+
+func (r *RedisClient) Publish(ctx context.Context, channel, message string) error {
+	return r.do(ctx, false, func(ctx context.Context) error {
+		return r.client.Publish(ctx, channel, message).Err()
+	})
+}
+
+// Subscribe returns once Redis confirmed the subscription, so every message published after it
+// returns is received
+func (r *RedisClient) Subscribe(ctx context.Context, channel string) (Subscription, error) {
+	pubsub := r.client.Subscribe(context.Background(), channel)
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, fmt.Errorf("failed to subscribe to %s: %w", channel, err)
+	}
+
+	sub := &redisSubscription{
+		pubsub:   pubsub,
+		messages: make(chan string, subscriptionBuffer),
+		done:     make(chan struct{}),
+	}
+	go sub.forward()
+	return sub, nil
+}
+
+// redisSubscription passes on the payloads of a Redis subscription
+type redisSubscription struct {
+	pubsub   *redis.PubSub
+	messages chan string
+	done     chan struct{}
+	close    sync.Once
+}
+
+func (s *redisSubscription) forward() {
+	defer close(s.messages)
+	for message := range s.pubsub.Channel() {
+		select {
+		case s.messages <- message.Payload:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *redisSubscription) Messages() <-chan string {
+	return s.messages
+}
+
+func (s *redisSubscription) Close() error {
+	var err error
+	s.close.Do(func() {
+		close(s.done)
+		err = s.pubsub.Close()
+	})
+	return err
 }
 
 func parseRedisURL(url string) string {
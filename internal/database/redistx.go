@@ -0,0 +1,666 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ErrStreamWritten is returned for reads in a transaction of a stream it added entries to; they
+// only get their IDs once the transaction is applied
+var ErrStreamWritten = errors.New("stream written in this transaction")
+
+// Atomic runs fn against a view of Redis that watches the keys fn reads and queues its writes, and
+// applies the writes in one MULTI/EXEC. If a watched key changed in the meantime, fn runs again on
+// the new values.
+func (r *RedisClient) Atomic(ctx context.Context, fn func(tx Backend) error) error {
+	for attempt := 0; attempt < watchAttempts; attempt++ {
+		err := r.client.Watch(ctx, func(tx *redis.Tx) error {
+			t := &redisTx{r: r, tx: tx, keys: make(map[string]*txKey)}
+			if err := fn(t); err != nil || len(t.queue) == 0 {
+				return err
+			}
+
+			ctx, cancel := r.withTimeout(ctx)
+			defer cancel()
+			_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				for _, write := range t.queue {
+					write(ctx, pipe)
+				}
+				return nil
+			})
+			return err
+		})
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+	return ErrConcurrentUpdate
+}
+
+// redisTx is the Backend that Atomic's function runs against. A key is watched and read whole the
+// first time the transaction reads it, so later reads are answered from the transaction's copy with
+// its own writes applied. Counters that are only incremented are the exception: they aren't
+// watched, so counting doesn't make transactions conflict with every other write.
+type redisTx struct {
+	r     *RedisClient
+	tx    *redis.Tx
+	keys  map[string]*txKey
+	queue []func(ctx context.Context, pipe redis.Pipeliner)
+}
+
+// txKey is what a transaction knows of a key
+type txKey struct {
+	loaded   bool
+	watched  bool
+	value    txValue
+	writes   []func(*txValue) // the transaction's writes, replayed on the value when it is read
+	reset    bool             // the first write replaced the whole value, so it needn't be read
+	streamed bool             // the transaction added stream entries under the key
+}
+
+// txValue is the value of a key; only the field of the key's type is set
+type txValue struct {
+	str  *string
+	list []string
+	hash map[string]string
+	set  map[string]bool
+	zset map[string]float64
+}
+
+func (t *redisTx) key(key string) *txKey {
+	k, ok := t.keys[key]
+	if !ok {
+		k = &txKey{}
+		t.keys[key] = k
+	}
+	return k
+}
+
+// load returns the value of key as the transaction leaves it, reading it with fetch on first use
+func (t *redisTx) load(ctx context.Context, key string, watch bool, fetch func(ctx context.Context) (txValue, error)) (*txValue, error) {
+	k := t.key(key)
+	if k.loaded && (k.watched || !watch) {
+		return &k.value, nil
+	}
+
+	value := txValue{}
+	if !k.reset {
+		ctx, cancel := t.r.withTimeout(ctx)
+		defer cancel()
+		if watch {
+			if err := t.tx.Watch(ctx, key).Err(); err != nil {
+				return nil, err
+			}
+		}
+		var err error
+		if value, err = fetch(ctx); err != nil {
+			return nil, err
+		}
+	}
+	for _, write := range k.writes {
+		write(&value)
+	}
+	k.value, k.loaded, k.watched = value, true, watch || k.reset
+	return &k.value, nil
+}
+
+// write queues cmd and applies apply to the transaction's copy of key. A write that replaces the
+// whole value makes earlier ones moot.
+func (t *redisTx) write(key string, replace bool, cmd func(ctx context.Context, pipe redis.Pipeliner), apply func(*txValue)) {
+	t.queue = append(t.queue, cmd)
+	k := t.key(key)
+	if replace && !k.loaded {
+		k.writes, k.reset = nil, true
+	}
+	k.writes = append(k.writes, apply)
+	if k.loaded {
+		apply(&k.value)
+	}
+}
+
+func (t *redisTx) Close() error {
+	return nil
+}
+
+func (t *redisTx) Atomic(ctx context.Context, fn func(tx Backend) error) error {
+	return fn(t)
+}
+
+// Strings
+
+func (t *redisTx) loadString(ctx context.Context, key string, watch bool) (*txValue, error) {
+	return t.load(ctx, key, watch, func(ctx context.Context) (txValue, error) {
+		data, err := t.tx.Get(ctx, key).Result()
+		if errors.Is(err, redis.Nil) {
+			return txValue{}, nil
+		}
+		if err != nil {
+			return txValue{}, err
+		}
+		if data, err = decompress(data); err != nil {
+			return txValue{}, err
+		}
+		return txValue{str: &data}, nil
+	})
+}
+
+func (t *redisTx) Set(ctx context.Context, key string, value interface{}, expiration int64) error {
+	ttl := time.Duration(0)
+	if expiration > 0 {
+		ttl = time.Duration(expiration) * time.Second
+	}
+	data, stored := toString(value), t.r.codec.compress(value)
+	t.write(key, true, func(ctx context.Context, pipe redis.Pipeliner) {
+		pipe.Set(ctx, key, stored, ttl)
+	}, func(v *txValue) {
+		*v = txValue{str: &data}
+	})
+	return nil
+}
+
+func (t *redisTx) SetNX(ctx context.Context, key string, value interface{}, expiration int64) (bool, error) {
+	v, err := t.loadString(ctx, key, true)
+	if err != nil || v.str != nil {
+		return false, err
+	}
+	return true, t.Set(ctx, key, value, expiration)
+}
+
+func (t *redisTx) Get(ctx context.Context, key string) (string, error) {
+	v, err := t.loadString(ctx, key, true)
+	if err != nil {
+		return "", err
+	}
+	if v.str == nil {
+		return "", ErrNotFound
+	}
+	return *v.str, nil
+}
+
+func (t *redisTx) GetDel(ctx context.Context, key string) (string, error) {
+	value, err := t.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	return value, t.Del(ctx, key)
+}
+
+func (t *redisTx) MGet(ctx context.Context, keys ...string) ([]interface{}, error) {
+	values := make([]interface{}, len(keys))
+	for i, key := range keys {
+		value, err := t.Get(ctx, key)
+		if err == nil {
+			values[i] = value
+		} else if !IsNotFound(err) {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+func (t *redisTx) Del(ctx context.Context, key string) error {
+	t.write(key, true, func(ctx context.Context, pipe redis.Pipeliner) {
+		pipe.Del(ctx, key)
+	}, func(v *txValue) {
+		*v = txValue{}
+	})
+	return nil
+}
+
+func (t *redisTx) Incr(ctx context.Context, key string) (int64, error) {
+	return t.IncrBy(ctx, key, 1)
+}
+
+// IncrBy of a key the transaction hasn't read otherwise isn't watched; its result counts from the
+// value the key had when IncrBy ran
+func (t *redisTx) IncrBy(ctx context.Context, key string, increment int64) (int64, error) {
+	v, err := t.loadString(ctx, key, false)
+	if err != nil {
+		return 0, err
+	}
+	value, err := txInteger(v)
+	if err != nil {
+		return 0, err
+	}
+	t.write(key, false, func(ctx context.Context, pipe redis.Pipeliner) {
+		pipe.IncrBy(ctx, key, increment)
+	}, func(v *txValue) {
+		current, _ := txInteger(v)
+		data := strconv.FormatInt(current+increment, 10)
+		v.str = &data
+	})
+	return value + increment, nil
+}
+
+func txInteger(v *txValue) (int64, error) {
+	if v.str == nil {
+		return 0, nil
+	}
+	value, err := strconv.ParseInt(*v.str, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("value is not an integer: %w", err)
+	}
+	return value, nil
+}
+
+// Keys doesn't see the transaction's writes
+func (t *redisTx) Keys(ctx context.Context, pattern string) ([]string, error) {
+	ctx, cancel := t.r.withTimeout(ctx)
+	defer cancel()
+	return t.tx.Keys(ctx, pattern).Result()
+}
+
+// Lists
+
+func (t *redisTx) loadList(ctx context.Context, key string) (*txValue, error) {
+	return t.load(ctx, key, true, func(ctx context.Context) (txValue, error) {
+		list, err := t.tx.LRange(ctx, key, 0, -1).Result()
+		return txValue{list: list}, err
+	})
+}
+
+func (t *redisTx) LPush(ctx context.Context, key string, values ...interface{}) error {
+	pushed := toStrings(values)
+	t.write(key, false, func(ctx context.Context, pipe redis.Pipeliner) {
+		pipe.LPush(ctx, key, values...)
+	}, func(v *txValue) {
+		for _, value := range pushed {
+			v.list = append([]string{value}, v.list...)
+		}
+	})
+	return nil
+}
+
+func (t *redisTx) RPop(ctx context.Context, key string) (string, error) {
+	v, err := t.loadList(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	if len(v.list) == 0 {
+		return "", ErrNotFound
+	}
+	value := v.list[len(v.list)-1]
+	t.write(key, false, func(ctx context.Context, pipe redis.Pipeliner) {
+		pipe.RPop(ctx, key)
+	}, func(v *txValue) {
+		if len(v.list) > 0 {
+			v.list = v.list[:len(v.list)-1]
+		}
+	})
+	return value, nil
+}
+
+func (t *redisTx) LLen(ctx context.Context, key string) (int64, error) {
+	v, err := t.loadList(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(v.list)), nil
+}
+
+func (t *redisTx) LIndex(ctx context.Context, key string, index int64) (string, error) {
+	v, err := t.loadList(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	n := int64(len(v.list))
+	if index < 0 {
+		index += n
+	}
+	if index < 0 || index >= n {
+		return "", ErrNotFound
+	}
+	return v.list[index], nil
+}
+
+func (t *redisTx) LRange(ctx context.Context, key string, start, stop int64) ([]string, error) {
+	v, err := t.loadList(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	start, stop = listRange(int64(len(v.list)), start, stop)
+	return append([]string{}, v.list[start:stop]...), nil
+}
+
+func (t *redisTx) LTrim(ctx context.Context, key string, start, stop int64) error {
+	t.write(key, false, func(ctx context.Context, pipe redis.Pipeliner) {
+		pipe.LTrim(ctx, key, start, stop)
+	}, func(v *txValue) {
+		start, stop := listRange(int64(len(v.list)), start, stop)
+		v.list = v.list[start:stop]
+	})
+	return nil
+}
+
+// Hashes
+
+func (t *redisTx) loadHash(ctx context.Context, key string) (*txValue, error) {
+	return t.load(ctx, key, true, func(ctx context.Context) (txValue, error) {
+		hash, err := t.tx.HGetAll(ctx, key).Result()
+		if err != nil {
+			return txValue{}, err
+		}
+		for field, value := range hash {
+			if hash[field], err = decompress(value); err != nil {
+				return txValue{}, err
+			}
+		}
+		return txValue{hash: hash}, nil
+	})
+}
+
+func (t *redisTx) HSet(ctx context.Context, key string, field string, value interface{}) error {
+	data, stored := toString(value), t.r.codec.compress(value)
+	t.write(key, false, func(ctx context.Context, pipe redis.Pipeliner) {
+		pipe.HSet(ctx, key, field, stored)
+	}, func(v *txValue) {
+		if v.hash == nil {
+			v.hash = make(map[string]string)
+		}
+		v.hash[field] = data
+	})
+	return nil
+}
+
+func (t *redisTx) HSetNX(ctx context.Context, key string, field string, value interface{}) (bool, error) {
+	v, err := t.loadHash(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	if _, ok := v.hash[field]; ok {
+		return false, nil
+	}
+	return true, t.HSet(ctx, key, field, value)
+}
+
+func (t *redisTx) HGet(ctx context.Context, key string, field string) (string, error) {
+	v, err := t.loadHash(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	value, ok := v.hash[field]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+func (t *redisTx) HMGet(ctx context.Context, key string, fields ...string) ([]interface{}, error) {
+	v, err := t.loadHash(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]interface{}, len(fields))
+	for i, field := range fields {
+		if value, ok := v.hash[field]; ok {
+			values[i] = value
+		}
+	}
+	return values, nil
+}
+
+func (t *redisTx) HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	v, err := t.loadHash(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]string, len(v.hash))
+	for field, value := range v.hash {
+		values[field] = value
+	}
+	return values, nil
+}
+
+func (t *redisTx) HDel(ctx context.Context, key string, fields ...string) (int64, error) {
+	v, err := t.loadHash(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	var removed int64
+	for _, field := range fields {
+		if _, ok := v.hash[field]; ok {
+			removed++
+		}
+	}
+	t.write(key, false, func(ctx context.Context, pipe redis.Pipeliner) {
+		pipe.HDel(ctx, key, fields...)
+	}, func(v *txValue) {
+		for _, field := range fields {
+			delete(v.hash, field)
+		}
+	})
+	return removed, nil
+}
+
+func (t *redisTx) HUpdate(ctx context.Context, key string, fields []string, update func(values []interface{}) (map[string]interface{}, error)) error {
+	values, err := t.HMGet(ctx, key, fields...)
+	if err != nil {
+		return err
+	}
+	changes, err := update(values)
+	if err != nil {
+		return err
+	}
+	for field, value := range changes {
+		if err := t.HSet(ctx, key, field, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sets
+
+func (t *redisTx) SAdd(ctx context.Context, key string, members ...interface{}) error {
+	added := toStrings(members)
+	t.write(key, false, func(ctx context.Context, pipe redis.Pipeliner) {
+		pipe.SAdd(ctx, key, members...)
+	}, func(v *txValue) {
+		if v.set == nil {
+			v.set = make(map[string]bool)
+		}
+		for _, member := range added {
+			v.set[member] = true
+		}
+	})
+	return nil
+}
+
+func (t *redisTx) SRem(ctx context.Context, key string, members ...interface{}) error {
+	removed := toStrings(members)
+	t.write(key, false, func(ctx context.Context, pipe redis.Pipeliner) {
+		pipe.SRem(ctx, key, members...)
+	}, func(v *txValue) {
+		for _, member := range removed {
+			delete(v.set, member)
+		}
+	})
+	return nil
+}
+
+func (t *redisTx) SMembers(ctx context.Context, key string) ([]string, error) {
+	v, err := t.load(ctx, key, true, func(ctx context.Context) (txValue, error) {
+		members, err := t.tx.SMembers(ctx, key).Result()
+		if err != nil {
+			return txValue{}, err
+		}
+		set := make(map[string]bool, len(members))
+		for _, member := range members {
+			set[member] = true
+		}
+		return txValue{set: set}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	members := make([]string, 0, len(v.set))
+	for member := range v.set {
+		members = append(members, member)
+	}
+	return members, nil
+}
+
+// Sorted sets
+
+func (t *redisTx) ZAdd(ctx context.Context, key string, score float64, member interface{}) error {
+	name := toString(member)
+	t.write(key, false, func(ctx context.Context, pipe redis.Pipeliner) {
+		pipe.ZAdd(ctx, key, &redis.Z{Score: score, Member: member})
+	}, func(v *txValue) {
+		if v.zset == nil {
+			v.zset = make(map[string]float64)
+		}
+		v.zset[name] = score
+	})
+	return nil
+}
+
+func (t *redisTx) ZRangeByScore(ctx context.Context, key string, min, max string) ([]string, error) {
+	members, err := t.zrangeByScore(ctx, key, min, max)
+	if err != nil {
+		return nil, err
+	}
+	return memberNames(members), nil
+}
+
+func (t *redisTx) ZRevRangeByScore(ctx context.Context, key string, min, max string, offset, count int64) ([]string, error) {
+	members, err := t.zrangeByScore(ctx, key, min, max)
+	if err != nil {
+		return nil, err
+	}
+	return reverseMemberNames(members, offset, count), nil
+}
+
+func (t *redisTx) ZCount(ctx context.Context, key string, min, max string) (int64, error) {
+	members, err := t.zrangeByScore(ctx, key, min, max)
+	return int64(len(members)), err
+}
+
+func (t *redisTx) ZRem(ctx context.Context, key string, members ...interface{}) error {
+	removed := toStrings(members)
+	t.write(key, false, func(ctx context.Context, pipe redis.Pipeliner) {
+		pipe.ZRem(ctx, key, members...)
+	}, func(v *txValue) {
+		for _, member := range removed {
+			delete(v.zset, member)
+		}
+	})
+	return nil
+}
+
+func (t *redisTx) zrangeByScore(ctx context.Context, key string, min, max string) ([]scoredMember, error) {
+	within, err := scoreRange(min, max)
+	if err != nil {
+		return nil, err
+	}
+	v, err := t.load(ctx, key, true, func(ctx context.Context) (txValue, error) {
+		scored, err := t.tx.ZRangeWithScores(ctx, key, 0, -1).Result()
+		if err != nil {
+			return txValue{}, err
+		}
+		zset := make(map[string]float64, len(scored))
+		for _, z := range scored {
+			zset[toString(z.Member)] = z.Score
+		}
+		return txValue{zset: zset}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var members []scoredMember
+	for member, score := range v.zset {
+		if within(score) {
+			members = append(members, scoredMember{member: member, score: score})
+		}
+	}
+	sortScored(members)
+	return members, nil
+}
+
+// Streams
+
+// XAdd in a transaction returns no ID; the entry only gets one once the transaction is applied
+func (t *redisTx) XAdd(ctx context.Context, stream string, maxLen int64, values map[string]interface{}) (string, error) {
+	t.key(stream).streamed = true
+	t.queue = append(t.queue, func(ctx context.Context, pipe redis.Pipeliner) {
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: stream,
+			MaxLen: maxLen,
+			Approx: true,
+			Values: values,
+		})
+	})
+	return "", nil
+}
+
+// watchStream watches a stream the transaction hasn't added entries to, before it is read from Redis
+func (t *redisTx) watchStream(ctx context.Context, stream string) error {
+	k := t.key(stream)
+	if k.streamed {
+		return fmt.Errorf("%w: %s", ErrStreamWritten, stream)
+	}
+	if !k.watched {
+		if err := t.tx.Watch(ctx, stream).Err(); err != nil {
+			return err
+		}
+		k.watched = true
+	}
+	return nil
+}
+
+func (t *redisTx) XRange(ctx context.Context, stream, start, end string, count int64) ([]StreamEntry, error) {
+	ctx, cancel := t.r.withTimeout(ctx)
+	defer cancel()
+	if err := t.watchStream(ctx, stream); err != nil {
+		return nil, err
+	}
+	return xRange(ctx, t.tx, stream, start, end, count)
+}
+
+func (t *redisTx) XRevRange(ctx context.Context, stream, end, start string, count int64) ([]StreamEntry, error) {
+	ctx, cancel := t.r.withTimeout(ctx)
+	defer cancel()
+	if err := t.watchStream(ctx, stream); err != nil {
+		return nil, err
+	}
+	return xRevRange(ctx, t.tx, stream, end, start, count)
+}
+
+func (t *redisTx) XLen(ctx context.Context, stream string) (int64, error) {
+	ctx, cancel := t.r.withTimeout(ctx)
+	defer cancel()
+	if err := t.watchStream(ctx, stream); err != nil {
+		return 0, err
+	}
+	return t.tx.XLen(ctx, stream).Result()
+}
+
+func (t *redisTx) XTrimMinID(ctx context.Context, stream, minID string) error {
+	t.key(stream).streamed = true
+	t.queue = append(t.queue, func(ctx context.Context, pipe redis.Pipeliner) {
+		pipe.XTrimMinID(ctx, stream, minID)
+	})
+	return nil
+}
+
+var _ Backend = (*redisTx)(nil)
+
+// Pub/sub
+
+func (t *redisTx) Publish(ctx context.Context, channel, message string) error {
+	t.queue = append(t.queue, func(ctx context.Context, pipe redis.Pipeliner) {
+		pipe.Publish(ctx, channel, message)
+	})
+	return nil
+}
+
+// Subscribe isn't part of the transaction
+func (t *redisTx) Subscribe(ctx context.Context, channel string) (Subscription, error) {
+	return t.r.Subscribe(ctx, channel)
+}
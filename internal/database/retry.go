@@ -0,0 +1,90 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+)
+
+// RetryPolicy configures retries of transient Redis failures
+type RetryPolicy struct {
+	Attempts   int           // total attempts per operation, including the first
+	Backoff    time.Duration // delay before the first retry, doubled after each attempt
+	MaxBackoff time.Duration // upper bound for the delay between attempts
+}
+
+// do runs op with a per-attempt timeout. Idempotent operations are retried on transient
+// failures such as connection resets and failovers. Non-idempotent ones (INCR, LPUSH, XADD, ...)
+// run exactly once, since a retry after a lost reply could apply them twice.
+func (r *RedisClient) do(ctx context.Context, idempotent bool, op func(ctx context.Context) error) error {
+	attempts := r.retry.Attempts
+	if !idempotent || attempts < 1 {
+		attempts = 1
+	}
+
+	backoff := r.retry.Backoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		attemptCtx, cancel := r.withTimeout(ctx)
+		err = op(attemptCtx)
+		cancel()
+
+		if err == nil || attempt >= attempts || !isTransient(err) || ctx.Err() != nil {
+			return err
+		}
+
+		// Full jitter keeps instances from retrying in lockstep after a failover
+		delay := time.Duration(rand.Int63n(int64(backoff) + 1))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+
+		backoff *= 2
+		if r.retry.MaxBackoff > 0 && backoff > r.retry.MaxBackoff {
+			backoff = r.retry.MaxBackoff
+		}
+	}
+}
+
+// doResult is do for operations that return a value
+func doResult[T any](ctx context.Context, r *RedisClient, idempotent bool, op func(ctx context.Context) (T, error)) (T, error) {
+	var result T
+	err := r.do(ctx, idempotent, func(ctx context.Context) error {
+		var err error
+		result, err = op(ctx)
+		return err
+	})
+	return result, err
+}
+
+// isTransient reports whether err is worth retrying
+func isTransient(err error) bool {
+	if IsNotFound(err) || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	// Server replies sent while a replica is promoted or a dataset is loading
+	msg := err.Error()
+	for _, prefix := range []string{"LOADING ", "READONLY ", "MASTERDOWN ", "TRYAGAIN ", "CLUSTERDOWN "} {
+		if strings.HasPrefix(msg, prefix) {
+			return true
+		}
+	}
+
+	return strings.Contains(msg, "connection reset by peer") || strings.Contains(msg, "broken pipe")
+}
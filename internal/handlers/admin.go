@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/helioschat/sync/internal/i18n"
+	"github.com/helioschat/sync/internal/middleware"
+	"github.com/helioschat/sync/internal/services"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// AdminHandler serves the operator API under /api/v1/admin. Admin tokens are issued with the
+// admin-token command.
+type AdminHandler struct {
+	authService *services.AuthService
+}
+
+func NewAdminHandler(authService *services.AuthService) *AdminHandler {
+	return &AdminHandler{
+		authService: authService,
+	}
+}
+
+// GetToken returns the claims of the admin token the request was made with
+func (h *AdminHandler) GetToken(c *gin.Context) {
+	claims, ok := middleware.GetAdminClaims(c)
+	if !ok {
+		c.JSON(http.StatusForbidden, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusForbidden, i18n.CodeAdminRequired, ""),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    claims,
+	})
+}
+
+// RevokeToken revokes the admin token the request was made with
+func (h *AdminHandler) RevokeToken(c *gin.Context) {
+	claims, ok := middleware.GetAdminClaims(c)
+	if !ok {
+		c.JSON(http.StatusForbidden, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusForbidden, i18n.CodeAdminRequired, ""),
+		})
+		return
+	}
+
+	if err := h.authService.RevokeAdminToken(c.Request.Context(), claims); err != nil {
+		c.JSON(http.StatusInternalServerError, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusInternalServerError,
+				Message: "Failed to revoke admin token",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    gin.H{"message": "Admin token revoked"},
+	})
+}
@@ -0,0 +1,263 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/i18n"
+	"github.com/helioschat/sync/internal/middleware"
+	"github.com/helioschat/sync/internal/services"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// attachmentError maps an attachment service error to a status code and message
+func attachmentError(err error, fallback string) (int, string) {
+	switch {
+	case errors.Is(err, services.ErrAttachmentsDisabled):
+		return http.StatusNotImplemented, "Attachment storage is not enabled on this instance"
+	case errors.Is(err, services.ErrAttachmentNotFound):
+		return http.StatusNotFound, "Attachment not found"
+	case errors.Is(err, services.ErrAttachmentExists):
+		return http.StatusConflict, "Attachment already exists with different content"
+	case errors.Is(err, services.ErrAttachmentUploading):
+		return http.StatusConflict, "Attachment is being uploaded"
+	case errors.Is(err, services.ErrAttachmentTooLarge):
+		return http.StatusRequestEntityTooLarge, "Attachment too large"
+	case errors.Is(err, services.ErrAttachmentQuota):
+		return http.StatusRequestEntityTooLarge, "Attachment storage quota exceeded"
+	}
+	return http.StatusInternalServerError, fallback
+}
+
+// parseAttachmentID parses the :id parameter, answering 400 if it isn't a UUID
+func parseAttachmentID(c *gin.Context) (uuid.UUID, bool) {
+	attachmentID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid attachment ID format - must be a valid UUID",
+				Details: err.Error(),
+			},
+		})
+		return uuid.Nil, false
+	}
+	return attachmentID, true
+}
+
+// GetAttachments lists the metadata of the user's attachments
+func (h *SyncHandler) GetAttachments(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+		})
+		return
+	}
+
+	attachments, err := h.syncService.GetAttachments(c.Request.Context(), userID)
+	if err != nil {
+		statusCode, message := attachmentError(err, "Failed to get attachments")
+		c.JSON(statusCode, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    statusCode,
+				Message: message,
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    attachments,
+	})
+}
+
+// GetAttachment downloads an attachment's encrypted blob. Its metadata is sent in headers.
+func (h *SyncHandler) GetAttachment(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+		})
+		return
+	}
+
+	// Metadata-only tokens don't get payloads, and an attachment is nothing but payload
+	if middleware.IsMetadataOnly(c) {
+		c.JSON(http.StatusForbidden, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusForbidden, i18n.CodeGuestForbidden, "metadata-only tokens can't download attachments"),
+		})
+		return
+	}
+
+	attachmentID, ok := parseAttachmentID(c)
+	if !ok {
+		return
+	}
+
+	attachment, data, err := h.syncService.GetAttachment(c.Request.Context(), userID, attachmentID)
+	if err != nil {
+		statusCode, message := attachmentError(err, "Failed to get attachment")
+		c.JSON(statusCode, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    statusCode,
+				Message: message,
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	// Attachments never change, so their digest is a stable ETag
+	if notModified(c, `"`+attachment.SHA256+`"`) {
+		return
+	}
+	c.Header("X-Attachment-SHA256", attachment.SHA256)
+	c.Header("X-Attachment-Enc-V", strconv.Itoa(attachment.EncV))
+	c.Data(http.StatusOK, "application/octet-stream", data)
+}
+
+// UploadAttachment stores the request body as an encrypted attachment. The envelope version of the
+// encryption is given as ?enc_v=.
+func (h *SyncHandler) UploadAttachment(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+		})
+		return
+	}
+
+	attachmentID, ok := parseAttachmentID(c)
+	if !ok {
+		return
+	}
+
+	var encV int
+	if raw := c.Query("enc_v"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, types.APIResponse{
+				Success: false,
+				Error: &types.APIError{
+					Code:    http.StatusBadRequest,
+					Message: "Invalid enc_v - must be an integer",
+					Details: err.Error(),
+				},
+			})
+			return
+		}
+		encV = parsed
+	}
+	if !h.validateEncryptionVersion(c, &encV) {
+		return
+	}
+
+	machineID := middleware.GetMachineID(c)
+
+	if !h.requireActiveMachine(c, userID, machineID) {
+		return
+	}
+
+	// Read one byte past the limit, so oversized uploads are told apart from ones at the limit
+	body := io.Reader(c.Request.Body)
+	if maxBytes := h.syncService.AttachmentMaxBytes(); maxBytes > 0 {
+		body = io.LimitReader(body, maxBytes+1)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "Failed to read attachment",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	attachment, created, err := h.syncService.PutAttachment(c.Request.Context(), userID, attachmentID, data, encV, machineID)
+	if errors.Is(err, services.ErrAttachmentQuota) {
+		c.JSON(http.StatusRequestEntityTooLarge, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusRequestEntityTooLarge, i18n.CodeAttachmentQuota, err.Error()),
+		})
+		return
+	}
+	if err != nil {
+		statusCode, message := attachmentError(err, "Failed to save attachment")
+		c.JSON(statusCode, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    statusCode,
+				Message: message,
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	statusCode := http.StatusOK
+	if created {
+		statusCode = http.StatusCreated
+	}
+
+	c.JSON(statusCode, types.APIResponse{
+		Success:  true,
+		Data:     attachment,
+		Warnings: h.quotaWarnings(c, userID),
+	})
+}
+
+func (h *SyncHandler) DeleteAttachment(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+		})
+		return
+	}
+
+	attachmentID, ok := parseAttachmentID(c)
+	if !ok {
+		return
+	}
+
+	machineID := middleware.GetMachineID(c)
+
+	if !h.requireActiveMachine(c, userID, machineID) {
+		return
+	}
+
+	if err := h.syncService.DeleteAttachment(c.Request.Context(), userID, attachmentID, machineID); err != nil {
+		statusCode, message := attachmentError(err, "Failed to delete attachment")
+		c.JSON(statusCode, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    statusCode,
+				Message: message,
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    gin.H{"message": "Attachment deleted successfully"},
+	})
+}
@@ -1,29 +1,40 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid" // Added for UUID parsing
+	"github.com/helioschat/sync/internal/i18n"
+	"github.com/helioschat/sync/internal/middleware"
 	"github.com/helioschat/sync/internal/services"
 	"github.com/helioschat/sync/internal/types"
 )
 
 type AuthHandler struct {
 	AuthService *services.AuthService
+	syncService *services.SyncService
+	eraser      *services.AccountEraser
+	merger      *services.AccountMerger
 }
 
-func NewAuthHandler(authService *services.AuthService) *AuthHandler {
+func NewAuthHandler(authService *services.AuthService, syncService *services.SyncService, eraser *services.AccountEraser, merger *services.AccountMerger) *AuthHandler {
 	return &AuthHandler{
 		AuthService: authService,
+		syncService: syncService,
+		eraser:      eraser,
+		merger:      merger,
 	}
 }
 
 // GenerateWallet creates a new wallet with passphrase
 func (h *AuthHandler) GenerateWallet(c *gin.Context) {
 	var req struct {
-		Passphrase string `json:"passphrase" binding:"required"`
+		Passphrase   string `json:"passphrase" binding:"required"`
+		RecoveryCode bool   `json:"recovery_code"` // also issue a recovery code for passphrase resets
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -38,7 +49,20 @@ func (h *AuthHandler) GenerateWallet(c *gin.Context) {
 		return
 	}
 
-	wallet, err := h.AuthService.GenerateWallet(req.Passphrase)
+	wallet, recoveryCode, err := h.AuthService.GenerateWallet(c.Request.Context(), req.Passphrase, req.RecoveryCode)
+	var policyErr *services.PolicyError
+	if errors.As(err, &policyErr) {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:       http.StatusBadRequest,
+				ErrorCode:  string(i18n.CodeWeakPassphrase),
+				Message:    i18n.Message(i18n.CodeWeakPassphrase, middleware.GetLocale(c)),
+				Violations: policyErr.Violations,
+			},
+		})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, types.APIResponse{
 			Success: false,
@@ -51,12 +75,17 @@ func (h *AuthHandler) GenerateWallet(c *gin.Context) {
 		return
 	}
 
+	data := gin.H{
+		"uid":        wallet.UID.String(), // Ensure UID is stringified
+		"created_at": wallet.CreatedAt.Format(time.RFC3339Nano),
+	}
+	if recoveryCode != "" {
+		data["recovery_code"] = recoveryCode // shown once, only its hash is stored
+	}
+
 	c.JSON(http.StatusOK, types.APIResponse{
 		Success: true,
-		Data: gin.H{
-			"uid":        wallet.UID.String(), // Ensure UID is stringified
-			"created_at": wallet.CreatedAt.Format(time.RFC3339Nano),
-		},
+		Data:    data,
 	})
 }
 
@@ -65,6 +94,8 @@ func (h *AuthHandler) Login(c *gin.Context) {
 	var req struct {
 		UserID     string `json:"user_id" binding:"required"`
 		Passphrase string `json:"passphrase" binding:"required"`
+		TOTPCode   string `json:"totp_code"`  // required once the wallet has TOTP enabled
+		MachineID  string `json:"machine_id"` // optional; binds the tokens so the machine can be signed out remotely
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -92,15 +123,51 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
-	tokens, err := h.AuthService.Login(parsedUID, req.Passphrase)
+	if req.MachineID != "" {
+		machineID, err := uuid.Parse(req.MachineID)
+		if err == nil {
+			err = types.ValidateUUIDv7(machineID)
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, types.APIResponse{
+				Success: false,
+				Error: &types.APIError{
+					Code:    http.StatusBadRequest,
+					Message: "Machine ID must be a valid UUIDv7",
+					Details: err.Error(),
+				},
+			})
+			return
+		}
+	}
+
+	tokens, err := h.AuthService.Login(c.Request.Context(), parsedUID, req.Passphrase, req.TOTPCode, req.MachineID, c.ClientIP())
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, types.APIResponse{
+		statusCode := http.StatusUnauthorized
+		code := i18n.CodeAuthFailed
+		var locked *services.LockedError
+		switch {
+		case errors.Is(err, services.ErrMachineDeactivated):
+			statusCode = http.StatusForbidden
+			code = i18n.CodeMachineSignedOut
+		case errors.Is(err, services.ErrWalletMerged):
+			statusCode = http.StatusGone
+			code = i18n.CodeWalletMerged
+		case errors.Is(err, services.ErrOpaqueRequired):
+			statusCode = http.StatusForbidden
+			code = i18n.CodeOpaqueRequired
+		case errors.Is(err, services.ErrTOTPRequired):
+			code = i18n.CodeTOTPRequired
+		case errors.Is(err, services.ErrInvalidTOTP):
+			code = i18n.CodeInvalidTOTP
+		case errors.As(err, &locked):
+			statusCode = http.StatusTooManyRequests
+			code = i18n.CodeLoginLocked
+			c.Header("Retry-After", strconv.Itoa(int(locked.RetryAfter.Seconds())+1))
+		}
+		c.JSON(statusCode, types.APIResponse{
 			Success: false,
-			Error: &types.APIError{
-				Code:    http.StatusUnauthorized,
-				Message: "Authentication failed",
-				Details: err.Error(),
-			},
+			Error:   middleware.LocalizedError(c, statusCode, code, err.Error()),
 		})
 		return
 	}
@@ -132,13 +199,313 @@ func (h *AuthHandler) RefreshToken(c *gin.Context) {
 		return
 	}
 
-	tokens, err := h.AuthService.RefreshToken(req.RefreshToken)
+	tokens, err := h.AuthService.RefreshToken(c.Request.Context(), req.RefreshToken)
 	if err != nil {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeInvalidRefreshToken, err.Error()),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    tokens,
+	})
+}
+
+// GetJWKS publishes the keys tokens of this instance can be verified with, in the standard JWKS format
+func (h *AuthHandler) GetJWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=3600")
+	c.JSON(http.StatusOK, h.AuthService.JWKS())
+}
+
+// GetWallet returns non-sensitive information about the authenticated wallet
+func (h *AuthHandler) GetWallet(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+		})
+		return
+	}
+
+	info, err := h.AuthService.GetWalletInfo(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusInternalServerError,
+				Message: "Failed to get wallet",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	usage, err := h.syncService.GetUsage(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusInternalServerError,
+				Message: "Failed to get storage usage",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+	info.Usage = *usage
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    info,
+	})
+}
+
+// DeleteWallet erases the authenticated wallet and all of its data after confirming the passphrase.
+// The loss of the data has to be acknowledged; a final export can be returned or staged for download.
+func (h *AuthHandler) DeleteWallet(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+		})
+		return
+	}
+
+	var req types.WalletDeletionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid request format: passphrase is required",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	if err := h.AuthService.VerifyPassphrase(c.Request.Context(), userID, req.Passphrase); err != nil {
 		c.JSON(http.StatusUnauthorized, types.APIResponse{
 			Success: false,
 			Error: &types.APIError{
 				Code:    http.StatusUnauthorized,
-				Message: "Invalid refresh token",
+				Message: "Passphrase confirmation failed",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	deletion, err := h.eraser.EraseWithExport(c.Request.Context(), userID, req.AcknowledgeDataLoss, req.Export)
+	if err != nil {
+		var apiErr *types.APIError
+		switch {
+		case errors.Is(err, services.ErrDeletionNotAcknowledged):
+			apiErr = middleware.LocalizedError(c, http.StatusBadRequest, i18n.CodeDeletionNotAcknowledged, "")
+		case errors.Is(err, services.ErrInvalidExportMode):
+			apiErr = &types.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid export mode",
+				Details: err.Error(),
+			}
+		default:
+			apiErr = &types.APIError{
+				Code:    http.StatusInternalServerError,
+				Message: "Failed to delete account",
+				Details: err.Error(),
+			}
+		}
+		c.JSON(apiErr.Code, types.APIResponse{
+			Success: false,
+			Error:   apiErr,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    deletion,
+	})
+}
+
+// GetFinalExport downloads the export staged by a wallet deletion. The wallet is gone, so the
+// unguessable token in the path is the only credential.
+func (h *AuthHandler) GetFinalExport(c *gin.Context) {
+	export, err := h.syncService.GetStagedExport(c.Request.Context(), c.Param("token"))
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		if errors.Is(err, services.ErrExportNotFound) {
+			statusCode = http.StatusNotFound
+		}
+		c.JSON(statusCode, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    statusCode,
+				Message: "Failed to get export",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	c.Header("Content-Disposition", `attachment; filename="helios-export-`+export.UID.String()+`.json"`)
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    export,
+	})
+}
+
+// Logout revokes the presented access token and the session's refresh token, if provided
+func (h *AuthHandler) Logout(c *gin.Context) {
+	claims, ok := middleware.GetTokenClaims(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+		})
+		return
+	}
+
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, types.APIResponse{
+				Success: false,
+				Error: &types.APIError{
+					Code:    http.StatusBadRequest,
+					Message: "Invalid request format",
+					Details: err.Error(),
+				},
+			})
+			return
+		}
+	}
+
+	if err := h.AuthService.Logout(c.Request.Context(), claims, req.RefreshToken); err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "Failed to log out",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    gin.H{"message": "Logged out successfully"},
+	})
+}
+
+// ListSessions returns the wallet's login sessions that can still be refreshed
+func (h *AuthHandler) ListSessions(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	if !ok || middleware.IsGuest(c) {
+		c.JSON(http.StatusForbidden, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusForbidden,
+				Message: "Sessions can only be listed with a full access token",
+			},
+		})
+		return
+	}
+
+	sessions, err := h.AuthService.ListSessions(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusInternalServerError,
+				Message: "Failed to list sessions",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    sessions,
+	})
+}
+
+// CreateGuestToken mints a short-lived, read-only token the user can share with support
+func (h *AuthHandler) CreateGuestToken(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	if !ok || middleware.IsGuest(c) {
+		c.JSON(http.StatusForbidden, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusForbidden,
+				Message: "Guest tokens can only be created with a full access token",
+			},
+		})
+		return
+	}
+
+	var req types.GuestTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid request format",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	token, err := h.AuthService.CreateGuestToken(c.Request.Context(), userID, req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "Failed to create guest token",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, types.APIResponse{
+		Success: true,
+		Data:    token,
+	})
+}
+
+// ListGuestTokens returns the unexpired guest tokens the user has minted
+func (h *AuthHandler) ListGuestTokens(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	if !ok || middleware.IsGuest(c) {
+		c.JSON(http.StatusForbidden, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusForbidden,
+				Message: "Guest tokens can only be listed with a full access token",
+			},
+		})
+		return
+	}
+
+	tokens, err := h.AuthService.ListGuestTokens(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusInternalServerError,
+				Message: "Failed to list guest tokens",
 				Details: err.Error(),
 			},
 		})
@@ -150,3 +517,115 @@ func (h *AuthHandler) RefreshToken(c *gin.Context) {
 		Data:    tokens,
 	})
 }
+
+// CreateScopedToken mints an access token limited to some scopes, e.g. a read-only token for a dashboard
+func (h *AuthHandler) CreateScopedToken(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	claims, _ := middleware.GetTokenClaims(c)
+	if !ok || claims == nil || claims.Type != "access" {
+		c.JSON(http.StatusForbidden, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusForbidden,
+				Message: "Scoped tokens can only be created with a full access token",
+			},
+		})
+		return
+	}
+
+	var req types.ScopedTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid request format",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	token, err := h.AuthService.CreateScopedToken(c.Request.Context(), userID, req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "Failed to create scoped token",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, types.APIResponse{
+		Success: true,
+		Data:    token,
+	})
+}
+
+// ListScopedTokens returns the unexpired scoped tokens the wallet has minted
+func (h *AuthHandler) ListScopedTokens(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+		})
+		return
+	}
+
+	tokens, err := h.AuthService.ListScopedTokens(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusInternalServerError,
+				Message: "Failed to list scoped tokens",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    tokens,
+	})
+}
+
+// RevokeScopedToken revokes a scoped token before it expires
+func (h *AuthHandler) RevokeScopedToken(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+		})
+		return
+	}
+
+	if err := h.AuthService.RevokeScopedToken(c.Request.Context(), userID, c.Param("id")); err != nil {
+		status := http.StatusInternalServerError
+		message := "Failed to revoke scoped token"
+		if errors.Is(err, services.ErrScopedTokenNotFound) {
+			status = http.StatusNotFound
+			message = "Scoped token not found"
+		}
+		c.JSON(status, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    status,
+				Message: message,
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    gin.H{"message": "Scoped token revoked"},
+	})
+}
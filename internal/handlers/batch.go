@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/helioschat/sync/internal/i18n"
+	"github.com/helioschat/sync/internal/middleware"
+	"github.com/helioschat/sync/internal/services"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// batchOperationsMax caps the operations of one sync batch
+const batchOperationsMax = 500
+
+// ApplyBatch applies a list of thread and message writes all or nothing, so a client pushing a new
+// thread with its first messages can't leave it half uploaded. A failing operation is named by its
+// index in the error details, and nothing of the batch is kept. With ?dry_run=true the batch is
+// only validated: the response tells what applying it would do, or which operation would fail.
+func (h *SyncHandler) ApplyBatch(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+		})
+		return
+	}
+
+	var req types.BatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid request format",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	if len(req.Operations) == 0 || len(req.Operations) > batchOperationsMax {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid batch size",
+				Details: fmt.Sprintf("a batch holds 1 to %d operations", batchOperationsMax),
+			},
+		})
+		return
+	}
+
+	for _, op := range req.Operations {
+		if op.Thread != nil && !h.validateEncryptionVersion(c, &op.Thread.EncV) {
+			return
+		}
+		if op.Message != nil && !h.validateEncryptionVersion(c, &op.Message.EncV) {
+			return
+		}
+	}
+
+	machineID, ok := machineIDFor(c, req.MachineID)
+	if !ok {
+		return
+	}
+
+	if !h.requireActiveMachine(c, userID, machineID) {
+		return
+	}
+
+	dryRun := false
+	if dryRunStr := c.Query("dry_run"); dryRunStr != "" {
+		if parsed, err := strconv.ParseBool(dryRunStr); err == nil {
+			dryRun = parsed
+		}
+	}
+
+	var result *types.BatchResult
+	var err error
+	if dryRun {
+		result, err = h.batches.Validate(c.Request.Context(), userID, req.Operations)
+	} else {
+		result, err = h.batches.Apply(c.Request.Context(), userID, req.Operations, machineID)
+	}
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		message := "Failed to apply batch"
+		switch {
+		case errors.Is(err, services.ErrInvalidBatchOperation):
+			statusCode = http.StatusBadRequest
+			message = "Invalid batch operation"
+		case errors.Is(err, services.ErrThreadNotFound):
+			statusCode = http.StatusNotFound
+			message = "Thread not found"
+		case errors.Is(err, services.ErrVersionConflict), errors.Is(err, services.ErrMessageIDTaken):
+			statusCode = http.StatusConflict
+			message = "Batch conflicts with stored data"
+		case errors.Is(err, services.ErrThreadDeleted):
+			statusCode = http.StatusConflict
+			message = "Thread was deleted"
+		case errors.Is(err, services.ErrThreadExists):
+			statusCode = http.StatusConflict
+			message = "Thread ID is taken"
+		case errors.Is(err, services.ErrThreadMessageLimit):
+			statusCode = http.StatusForbidden
+			message = "Thread message limit reached"
+		}
+		c.JSON(statusCode, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    statusCode,
+				Message: message,
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success:  true,
+		Data:     result,
+		Warnings: h.quotaWarnings(c, userID),
+	})
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// serverFeatures are the optional parts of the sync API this build implements. Those depending on
+// the instance's configuration are filled in by SyncHandler.Features.
+var serverFeatures = types.ServerFeatures{
+	Batch:          true,
+	BatchDryRun:    true,
+	WebSocket:      true,
+	LongPoll:       true,
+	SyncCursors:    true,
+	ThreadArchive:  true,
+	ThreadBranches: true,
+	Purge:          true,
+	Import:         true,
+	ETags:          true,
+	Compression:    []string{},
+}
+
+type CapabilitiesHandler struct {
+	encryption types.EncryptionPolicy
+	durability types.DurabilityLevels
+	migration  *types.MigrationNotice // nil unless the instance is moving
+	features   types.ServerFeatures
+	limits     types.SyncLimits
+}
+
+func NewCapabilitiesHandler(encryption types.EncryptionPolicy, durability types.DurabilityLevels, migration *types.MigrationNotice, features types.ServerFeatures, limits types.SyncLimits) *CapabilitiesHandler {
+	return &CapabilitiesHandler{
+		encryption: encryption,
+		durability: durability,
+		migration:  migration,
+		features:   features,
+		limits:     limits,
+	}
+}
+
+// GetCapabilities describes what this server instance supports.
+// Clients compare encryption.current_version with the enc_v of their records to prompt migrations,
+// durability tells which ?durability= levels writes can ask for and what they get by default,
+// and clients prompt users to move their wallets when migration announces that the instance is moving.
+// protocol_version, features and limits let clients adapt to this server build instead of
+// hard-coding what the newest one supports.
+func (h *CapabilitiesHandler) GetCapabilities(c *gin.Context) {
+	capabilities := gin.H{
+		"protocol_version": types.ProtocolVersion,
+		"features":         h.features,
+		"limits":           h.limits,
+		"encryption":       h.encryption,
+		"durability":       h.durability,
+	}
+	if h.migration != nil {
+		capabilities["migration"] = h.migration
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    capabilities,
+	})
+}
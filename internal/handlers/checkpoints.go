@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/helioschat/sync/internal/i18n"
+	"github.com/helioschat/sync/internal/middleware"
+	"github.com/helioschat/sync/internal/services"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// GetSyncCheckpoint returns the last changes-since cursor the requesting machine stored, so a
+// reinstalled client can resume syncing from it
+func (h *SyncHandler) GetSyncCheckpoint(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+		})
+		return
+	}
+
+	machineID, ok := machineIDFor(c, c.Query("machine_id"))
+	if !ok {
+		return
+	}
+
+	checkpoint, err := h.syncService.GetSyncCheckpoint(c.Request.Context(), userID, machineID)
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		message := "Failed to get sync checkpoint"
+		if errors.Is(err, services.ErrCheckpointNotFound) {
+			statusCode = http.StatusNotFound
+			message = "Sync checkpoint not found"
+		}
+		c.JSON(statusCode, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    statusCode,
+				Message: message,
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    checkpoint,
+	})
+}
+
+// PutSyncCheckpoint stores the sync_cursor of the last changes-since response the requesting
+// machine applied. Change-log entries every machine has acknowledged this way can be trimmed.
+func (h *SyncHandler) PutSyncCheckpoint(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+		})
+		return
+	}
+
+	var req types.SyncCheckpointRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid request format",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	machineID, ok := machineIDFor(c, req.MachineID)
+	if !ok {
+		return
+	}
+
+	if !h.requireActiveMachine(c, userID, machineID) {
+		return
+	}
+
+	checkpoint, err := h.syncService.SaveSyncCheckpoint(c.Request.Context(), userID, machineID, req.SyncCursor, req.SyncTimestamp)
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		message := "Failed to save sync checkpoint"
+		if errors.Is(err, services.ErrInvalidCheckpoint) {
+			statusCode = http.StatusBadRequest
+			message = "Invalid sync checkpoint"
+		}
+		c.JSON(statusCode, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    statusCode,
+				Message: message,
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    checkpoint,
+	})
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/helioschat/sync/internal/i18n"
+	"github.com/helioschat/sync/internal/middleware"
+	"github.com/helioschat/sync/internal/services"
+	"github.com/helioschat/sync/internal/types"
+)
+
+type DebugHandler struct {
+	tracer *services.DebugTracer
+}
+
+func NewDebugHandler(tracer *services.DebugTracer) *DebugHandler {
+	return &DebugHandler{
+		tracer: tracer,
+	}
+}
+
+// EnableTrace starts recording redacted envelopes of the user's sync requests for a limited time
+func (h *DebugHandler) EnableTrace(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+		})
+		return
+	}
+
+	var req struct {
+		DurationMinutes int `json:"duration_minutes" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid request format: duration_minutes is required",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	until, err := h.tracer.Enable(c.Request.Context(), userID, time.Duration(req.DurationMinutes)*time.Minute)
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		if errors.Is(err, services.ErrInvalidTraceDuration) {
+			statusCode = http.StatusBadRequest
+		}
+		c.JSON(statusCode, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    statusCode,
+				Message: "Failed to enable debug tracing",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    types.DebugTrace{EnabledUntil: &until, Entries: []types.TraceEntry{}},
+	})
+}
+
+// GetTrace returns the recorded envelopes, newest first
+func (h *DebugHandler) GetTrace(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+		})
+		return
+	}
+
+	trace, err := h.tracer.Trace(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusInternalServerError,
+				Message: "Failed to get debug trace",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    trace,
+	})
+}
+
+// DisableTrace stops tracing and deletes the recorded envelopes
+func (h *DebugHandler) DisableTrace(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+		})
+		return
+	}
+
+	if err := h.tracer.Disable(c.Request.Context(), userID); err != nil {
+		c.JSON(http.StatusInternalServerError, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusInternalServerError,
+				Message: "Failed to disable debug tracing",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    gin.H{"message": "Debug tracing disabled"},
+	})
+}
@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/helioschat/sync/internal/services"
+	"github.com/helioschat/sync/internal/types"
+)
+
+type DemoHandler struct {
+	demoService *services.DemoService
+}
+
+func NewDemoHandler(demoService *services.DemoService) *DemoHandler {
+	return &DemoHandler{
+		demoService: demoService,
+	}
+}
+
+// CreateDemoWallet creates an auto-expiring wallet with a generated passphrase and returns it logged in
+func (h *DemoHandler) CreateDemoWallet(c *gin.Context) {
+	wallet, err := h.demoService.CreateDemoWallet(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusInternalServerError,
+				Message: "Failed to create demo wallet",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    wallet,
+	})
+}
@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/helioschat/sync/internal/types"
+)
+
+type DiscoveryHandler struct {
+	discovery types.Discovery
+}
+
+func NewDiscoveryHandler(discovery types.Discovery) *DiscoveryHandler {
+	return &DiscoveryHandler{
+		discovery: discovery,
+	}
+}
+
+// GetDiscovery serves the instance's discovery document, so clients only need its domain to set
+// themselves up. Like JWKS, it is a bare document rather than an API response.
+func (h *DiscoveryHandler) GetDiscovery(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=3600")
+	c.JSON(http.StatusOK, h.discovery)
+}
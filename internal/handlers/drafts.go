@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/i18n"
+	"github.com/helioschat/sync/internal/middleware"
+	"github.com/helioschat/sync/internal/services"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// Draft handlers, keyed by the ID of the thread the draft belongs to
+func (h *SyncHandler) GetDrafts(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+		})
+		return
+	}
+
+	drafts, err := h.syncService.GetDrafts(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusInternalServerError,
+				Message: "Failed to get drafts",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	if middleware.IsMetadataOnly(c) {
+		for i := range drafts {
+			drafts[i] = drafts[i].Redacted()
+		}
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    drafts,
+	})
+}
+
+// UpsertDraft saves the draft of a thread. Drafts have no version, so the last write wins.
+func (h *SyncHandler) UpsertDraft(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+		})
+		return
+	}
+
+	threadID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid thread ID format - must be a valid UUID",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	var req types.DraftUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid request format",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	// Validate that the user ID in the request matches the authenticated user
+	if req.UserID != userID {
+		c.JSON(http.StatusForbidden, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusForbidden,
+				Message: "User ID in request does not match authenticated user",
+			},
+		})
+		return
+	}
+
+	machineID, ok := machineIDFor(c, req.MachineID)
+	if !ok {
+		return
+	}
+
+	draft := req.Data
+	if !h.validateEncryptionVersion(c, &draft.EncV) {
+		return
+	}
+
+	// Validate that the thread ID in the body matches the URL parameter
+	if draft.ThreadID != uuid.Nil && draft.ThreadID != threadID {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "Thread ID in request body does not match URL parameter",
+			},
+		})
+		return
+	}
+
+	draft.ThreadID = threadID
+
+	if !h.requireActiveMachine(c, userID, machineID) {
+		return
+	}
+
+	if !h.requireThreadOwner(c, userID, threadID.String()) {
+		return
+	}
+
+	if err := h.syncService.UpsertDraft(c.Request.Context(), userID, &draft, machineID); err != nil {
+		c.JSON(http.StatusInternalServerError, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusInternalServerError,
+				Message: "Failed to save draft",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    draft,
+	})
+}
+
+func (h *SyncHandler) DeleteDraft(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+		})
+		return
+	}
+
+	threadID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid thread ID",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	machineID := middleware.GetMachineID(c)
+
+	if !h.requireActiveMachine(c, userID, machineID) {
+		return
+	}
+
+	if err := h.syncService.DeleteDraft(c.Request.Context(), userID, threadID, machineID); err != nil {
+		statusCode := http.StatusInternalServerError
+		message := "Failed to delete draft"
+		if errors.Is(err, services.ErrDraftNotFound) {
+			statusCode = http.StatusNotFound
+			message = "Draft not found"
+		}
+		c.JSON(statusCode, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    statusCode,
+				Message: message,
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    gin.H{"message": "Draft deleted successfully"},
+	})
+}
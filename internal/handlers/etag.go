@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/middleware"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// ETags of GET responses are derived from the versions of what the response holds, so polling
+// clients can revalidate with If-None-Match instead of downloading the same payloads again.
+// Metadata-only tokens get redacted documents, which have ETags of their own.
+
+// settingsETag derives the ETag of a settings document. Settings are last write wins, so besides
+// the client's version the server time of the write goes into it.
+func settingsETag(c *gin.Context, version int64, updatedAt time.Time) string {
+	return etag(c, fmt.Sprintf("%d.%d", version, updatedAt.UnixMilli()))
+}
+
+// threadsETag derives the ETag of a page of threads from the IDs and versions on it and its position
+func threadsETag(c *gin.Context, page *types.PaginatedThreadsResponse) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%d/%d/%d/%t/%s/%d", page.Total, page.Offset, page.Limit, page.HasMore, page.NextCursor, page.CorruptedCount)
+	for _, thread := range page.Threads {
+		fmt.Fprintf(hash, "/%s:%d:%t:%d", thread.ID, thread.Version, thread.Archived, page.MessageCounts[thread.ID.String()])
+	}
+	return etag(c, hex.EncodeToString(hash.Sum(nil)[:16]))
+}
+
+func etag(c *gin.Context, tag string) string {
+	if middleware.IsMetadataOnly(c) {
+		tag += ".meta"
+	}
+	return `"` + tag + `"`
+}
+
+// etagListed reports whether an If-None-Match or If-Match header lists etag. Weak and strong
+// ETags compare equal, as If-None-Match does.
+func etagListed(header, etag string) bool {
+	for _, listed := range strings.Split(header, ",") {
+		listed = strings.TrimSpace(listed)
+		if listed == "*" || strings.TrimPrefix(listed, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified sets the response's ETag and answers 304 Not Modified if the client already has it
+func notModified(c *gin.Context, etag string) bool {
+	c.Header("ETag", etag)
+	if header := c.GetHeader("If-None-Match"); header != "" && etagListed(header, etag) {
+		c.Status(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// requireIfMatch checks a write's If-Match header against the stored document the write would
+// replace: the header has to name the document's version, its ETag or "*". On a mismatch it answers
+// 409 with the stored document, so the client can merge without reading it again. Writes without
+// If-Match always pass.
+func (h *SyncHandler) requireIfMatch(c *gin.Context, userID uuid.UUID, resource, id string) bool {
+	header := c.GetHeader("If-Match")
+	if header == "" {
+		return true
+	}
+
+	stored, err := h.syncService.GetStoredDocument(c.Request.Context(), userID, resource, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusInternalServerError,
+				Message: "Failed to check If-Match",
+				Details: err.Error(),
+			},
+		})
+		return false
+	}
+
+	if stored != nil {
+		version := strconv.FormatInt(stored.Version, 10)
+		if etagListed(header, `"`+version+`"`) || etagListed(header, version) {
+			return true
+		}
+		if !stored.UpdatedAt.IsZero() && etagListed(header, settingsETag(c, stored.Version, stored.UpdatedAt)) {
+			return true
+		}
+	}
+
+	apiError := &types.APIError{
+		Code:    http.StatusConflict,
+		Message: "Document was changed",
+		Details: fmt.Sprintf("If-Match %s doesn't match the stored %s", header, resource),
+	}
+	if stored != nil {
+		apiError.Current = stored.Data
+	} else {
+		apiError.Details = fmt.Sprintf("If-Match %s doesn't match: there is no stored %s", header, resource)
+	}
+	c.JSON(http.StatusConflict, types.APIResponse{
+		Success: false,
+		Error:   apiError,
+	})
+	return false
+}
@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/i18n"
+	"github.com/helioschat/sync/internal/middleware"
+	"github.com/helioschat/sync/internal/services"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// Folder handlers
+func (h *SyncHandler) GetFolders(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+		})
+		return
+	}
+
+	includeDeleted := c.Query("include_deleted") == "true"
+
+	folders, err := h.syncService.GetFolders(c.Request.Context(), userID, includeDeleted)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusInternalServerError,
+				Message: "Failed to get folders",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	if middleware.IsMetadataOnly(c) {
+		for i := range folders {
+			folders[i] = folders[i].Redacted()
+		}
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    folders,
+	})
+}
+
+func (h *SyncHandler) UpsertFolder(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+		})
+		return
+	}
+
+	folderID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid folder ID format - must be a valid UUID",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	var req types.FolderUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid request format",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	// Validate that the user ID in the request matches the authenticated user
+	if req.UserID != userID {
+		c.JSON(http.StatusForbidden, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusForbidden,
+				Message: "User ID in request does not match authenticated user",
+			},
+		})
+		return
+	}
+
+	machineID, ok := machineIDFor(c, req.MachineID)
+	if !ok {
+		return
+	}
+
+	folder := req.Data
+	if !h.validateEncryptionVersion(c, &folder.EncV) {
+		return
+	}
+
+	// Validate that the folder ID in the body matches the URL parameter
+	if folder.ID != uuid.Nil && folder.ID != folderID {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "Folder ID in request body does not match URL parameter",
+			},
+		})
+		return
+	}
+
+	folder.ID = folderID
+	folder.Version = req.Version
+
+	if !h.requireActiveMachine(c, userID, machineID) {
+		return
+	}
+
+	if !h.requireIfMatch(c, userID, "folder", folderID.String()) {
+		return
+	}
+
+	created, err := h.syncService.UpsertFolder(c.Request.Context(), userID, &folder, machineID)
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		if errors.Is(err, services.ErrVersionConflict) {
+			statusCode = http.StatusConflict
+		}
+		c.JSON(statusCode, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    statusCode,
+				Message: "Failed to save folder",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	statusCode := http.StatusOK
+	if created {
+		statusCode = http.StatusCreated
+	}
+
+	c.JSON(statusCode, types.APIResponse{
+		Success:  true,
+		Data:     folder,
+		Warnings: h.quotaWarnings(c, userID),
+	})
+}
+
+func (h *SyncHandler) DeleteFolder(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+		})
+		return
+	}
+
+	folderID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid folder ID",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	machineID := middleware.GetMachineID(c)
+
+	if !h.requireActiveMachine(c, userID, machineID) {
+		return
+	}
+
+	if err := h.syncService.DeleteFolder(c.Request.Context(), userID, folderID, machineID); err != nil {
+		statusCode := http.StatusInternalServerError
+		message := "Failed to delete folder"
+		if errors.Is(err, services.ErrFolderNotFound) {
+			statusCode = http.StatusNotFound
+			message = "Folder not found"
+		}
+		c.JSON(statusCode, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    statusCode,
+				Message: message,
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    gin.H{"message": "Folder deleted successfully"},
+	})
+}
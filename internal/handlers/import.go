@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/helioschat/sync/internal/i18n"
+	"github.com/helioschat/sync/internal/middleware"
+	"github.com/helioschat/sync/internal/services"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// ImportData imports a document in the account export format into the authenticated wallet. The
+// body is read as it arrives and the response is an NDJSON stream with one line per imported item,
+// ending with a summary line. The status is sent before the import starts, so a document that
+// can't be read to the end is reported in the summary line's error, not the status.
+func (h *SyncHandler) ImportData(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+		})
+		return
+	}
+
+	machineID := middleware.GetMachineID(c)
+	if !h.requireActiveMachine(c, userID, machineID) {
+		return
+	}
+
+	// HTTP/1 servers drain the request before responding unless told otherwise. Where the
+	// response writer can't allow reading and writing at once, the results are sent at the end.
+	streaming := http.NewResponseController(c.Writer).EnableFullDuplex() == nil
+	var held []types.ImportEvent
+
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Status(http.StatusOK)
+	encoder := json.NewEncoder(c.Writer)
+	emit := func(event types.ImportEvent) {
+		if !streaming {
+			held = append(held, event)
+			return
+		}
+		if err := encoder.Encode(event); err != nil {
+			fmt.Printf("Warning: failed to send import progress for user %s: %v\n", userID, err)
+			return
+		}
+		c.Writer.Flush()
+	}
+
+	opts := services.ImportOptions{
+		MachineID:    machineID,
+		MaxItemBytes: h.importMaxItemBytes,
+		Encryption:   h.encryption,
+	}
+	summary, err := h.syncService.ImportUserData(c.Request.Context(), userID, c.Request.Body, opts, func(item types.ImportItemResult) {
+		emit(types.ImportEvent{Item: &item})
+	})
+	final := types.ImportEvent{Summary: summary}
+	if err != nil {
+		final.Error = err.Error()
+	}
+	emit(final)
+
+	for _, event := range held {
+		if err := encoder.Encode(event); err != nil {
+			fmt.Printf("Warning: failed to send import results for user %s: %v\n", userID, err)
+			return
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/i18n"
+	"github.com/helioschat/sync/internal/middleware"
+	"github.com/helioschat/sync/internal/services"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// ListMachines returns the machines registered to the authenticated wallet
+func (h *AuthHandler) ListMachines(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+		})
+		return
+	}
+
+	machines, err := h.AuthService.GetMachines(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusInternalServerError,
+				Message: "Failed to list machines",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    machines,
+	})
+}
+
+// RegisterMachine registers a device, or updates the name and platform of an already registered one
+func (h *AuthHandler) RegisterMachine(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+		})
+		return
+	}
+
+	var req types.MachineRegisterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid request format: id and name are required",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	registration, created, err := h.AuthService.RegisterMachine(c.Request.Context(), userID, req)
+	if err != nil {
+		statusCode := http.StatusBadRequest
+		message := "Failed to register machine"
+		switch {
+		case errors.Is(err, services.ErrMachineDeactivated):
+			statusCode = http.StatusForbidden
+			message = "Machine has been deactivated"
+		case errors.Is(err, services.ErrPassphraseConfirmation):
+			statusCode = http.StatusUnauthorized
+			message = "Passphrase confirmation required to issue a signing secret"
+		}
+		c.JSON(statusCode, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    statusCode,
+				Message: message,
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	statusCode := http.StatusOK
+	if created {
+		statusCode = http.StatusCreated
+	}
+
+	c.JSON(statusCode, types.APIResponse{
+		Success: true,
+		Data:    registration,
+	})
+}
+
+// RenameMachine changes the display name of a registered machine
+func (h *AuthHandler) RenameMachine(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+		})
+		return
+	}
+
+	machineID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid machine ID",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	var req types.MachineRenameRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid request format: name is required",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	machine, err := h.AuthService.RenameMachine(c.Request.Context(), userID, machineID, req.Name)
+	if err != nil {
+		statusCode := http.StatusBadRequest
+		message := "Failed to rename machine"
+		if errors.Is(err, services.ErrMachineNotFound) {
+			statusCode = http.StatusNotFound
+			message = "Machine not found"
+		}
+		c.JSON(statusCode, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    statusCode,
+				Message: message,
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    machine,
+	})
+}
+
+// DeactivateMachine blocks all further writes from a machine
+func (h *AuthHandler) DeactivateMachine(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+		})
+		return
+	}
+
+	machineID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid machine ID",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	machine, err := h.AuthService.DeactivateMachine(c.Request.Context(), userID, machineID)
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		message := "Failed to deactivate machine"
+		if errors.Is(err, services.ErrMachineNotFound) {
+			statusCode = http.StatusNotFound
+			message = "Machine not found"
+		}
+		c.JSON(statusCode, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    statusCode,
+				Message: message,
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    machine,
+	})
+}
+
+// SignOutMachine revokes every token issued to a machine and blocks its writes
+func (h *AuthHandler) SignOutMachine(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+		})
+		return
+	}
+
+	machineID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid machine ID",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	machine, err := h.AuthService.SignOutMachine(c.Request.Context(), userID, machineID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusInternalServerError,
+				Message: "Failed to sign out machine",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    machine,
+	})
+}
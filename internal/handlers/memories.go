@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/i18n"
+	"github.com/helioschat/sync/internal/middleware"
+	"github.com/helioschat/sync/internal/services"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// Memory handlers
+func (h *SyncHandler) GetMemories(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+		})
+		return
+	}
+
+	includeDeleted := c.Query("include_deleted") == "true"
+
+	memories, err := h.syncService.GetMemories(c.Request.Context(), userID, includeDeleted)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusInternalServerError,
+				Message: "Failed to get memories",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	if middleware.IsMetadataOnly(c) {
+		for i := range memories {
+			memories[i] = memories[i].Redacted()
+		}
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    memories,
+	})
+}
+
+func (h *SyncHandler) UpsertMemory(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+		})
+		return
+	}
+
+	memoryID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid memory ID format - must be a valid UUID",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	var req types.MemoryUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid request format",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	// Validate that the user ID in the request matches the authenticated user
+	if req.UserID != userID {
+		c.JSON(http.StatusForbidden, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusForbidden,
+				Message: "User ID in request does not match authenticated user",
+			},
+		})
+		return
+	}
+
+	machineID, ok := machineIDFor(c, req.MachineID)
+	if !ok {
+		return
+	}
+
+	memory := req.Data
+	if !h.validateEncryptionVersion(c, &memory.EncV) {
+		return
+	}
+
+	// Validate that the memory ID in the body matches the URL parameter
+	if memory.ID != uuid.Nil && memory.ID != memoryID {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "Memory ID in request body does not match URL parameter",
+			},
+		})
+		return
+	}
+
+	memory.ID = memoryID
+	memory.Version = req.Version
+
+	if !h.requireActiveMachine(c, userID, machineID) {
+		return
+	}
+
+	if !h.requireIfMatch(c, userID, "memory", memoryID.String()) {
+		return
+	}
+
+	created, err := h.syncService.UpsertMemory(c.Request.Context(), userID, &memory, machineID)
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		if errors.Is(err, services.ErrVersionConflict) {
+			statusCode = http.StatusConflict
+		}
+		c.JSON(statusCode, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    statusCode,
+				Message: "Failed to save memory",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	statusCode := http.StatusOK
+	if created {
+		statusCode = http.StatusCreated
+	}
+
+	c.JSON(statusCode, types.APIResponse{
+		Success:  true,
+		Data:     memory,
+		Warnings: h.quotaWarnings(c, userID),
+	})
+}
+
+func (h *SyncHandler) DeleteMemory(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+		})
+		return
+	}
+
+	memoryID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid memory ID",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	machineID := middleware.GetMachineID(c)
+
+	if !h.requireActiveMachine(c, userID, machineID) {
+		return
+	}
+
+	if err := h.syncService.DeleteMemory(c.Request.Context(), userID, memoryID, machineID); err != nil {
+		statusCode := http.StatusInternalServerError
+		message := "Failed to delete memory"
+		if errors.Is(err, services.ErrMemoryNotFound) {
+			statusCode = http.StatusNotFound
+			message = "Memory not found"
+		}
+		c.JSON(statusCode, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    statusCode,
+				Message: message,
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    gin.H{"message": "Memory deleted successfully"},
+	})
+}
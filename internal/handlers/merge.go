@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/i18n"
+	"github.com/helioschat/sync/internal/middleware"
+	"github.com/helioschat/sync/internal/services"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// MergeAccount moves the data of another wallet the user owns into the authenticated one and erases
+// the other wallet. Ownership of the other wallet is proven with its passphrase.
+func (h *AuthHandler) MergeAccount(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+		})
+		return
+	}
+
+	var req types.AccountMergeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid request format: source_uid and source_passphrase are required",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	sourceID, err := uuid.Parse(req.SourceUID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid source UID format",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	if err := h.AuthService.VerifyPassphrase(c.Request.Context(), sourceID, req.SourcePassphrase); err != nil {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusUnauthorized,
+				Message: "Passphrase confirmation of the source wallet failed",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	report, err := h.merger.Merge(c.Request.Context(), sourceID, userID, req.ConflictPolicy, req.DryRun)
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		if errors.Is(err, services.ErrInvalidMergePolicy) || errors.Is(err, services.ErrMergeIntoSelf) {
+			statusCode = http.StatusBadRequest
+		}
+		c.JSON(statusCode, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    statusCode,
+				Message: "Failed to merge accounts",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    report,
+	})
+}
+
+// RotateWallet moves the authenticated wallet to a new UID, for users who believe theirs has leaked.
+// The requesting device gets tokens for the new UID; every other device has to log in with it.
+func (h *AuthHandler) RotateWallet(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+		})
+		return
+	}
+
+	var req types.WalletRotationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid request format: passphrase is required",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	rotation, err := h.merger.RotateWallet(c.Request.Context(), userID, req.Passphrase, middleware.GetMachineID(c))
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		message := "Failed to rotate wallet"
+		switch {
+		case errors.Is(err, services.ErrPassphraseConfirmation):
+			statusCode = http.StatusUnauthorized
+			message = "Passphrase confirmation failed"
+		case errors.Is(err, services.ErrTOTPUnavailable):
+			statusCode = http.StatusNotImplemented
+		}
+		c.JSON(statusCode, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    statusCode,
+				Message: message,
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    rotation,
+	})
+}
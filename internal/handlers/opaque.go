@@ -0,0 +1,404 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/i18n"
+	"github.com/helioschat/sync/internal/middleware"
+	"github.com/helioschat/sync/internal/services"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// OpaqueHandler serves the OPAQUE login, where the passphrase never leaves the client. Binary
+// protocol messages are base64 encoded in the JSON bodies.
+type OpaqueHandler struct {
+	opaqueService *services.OpaqueService
+}
+
+func NewOpaqueHandler(opaqueService *services.OpaqueService) *OpaqueHandler {
+	return &OpaqueHandler{
+		opaqueService: opaqueService,
+	}
+}
+
+// BeginRegistration evaluates the client's registration request for the authenticated wallet
+func (h *OpaqueHandler) BeginRegistration(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+		})
+		return
+	}
+
+	var req struct {
+		RegistrationRequest []byte `json:"registration_request" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid request format: registration_request is required",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	response, err := h.opaqueService.BeginRegistration(userID, req.RegistrationRequest)
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		if errors.Is(err, services.ErrInvalidOpaqueMessage) {
+			statusCode = http.StatusBadRequest
+		}
+		c.JSON(statusCode, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    statusCode,
+				Message: "Failed to start OPAQUE registration",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    response,
+	})
+}
+
+// FinishRegistration stores the client's registration record
+func (h *OpaqueHandler) FinishRegistration(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+		})
+		return
+	}
+
+	var req struct {
+		Record []byte `json:"record" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid request format: record is required",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	if err := h.opaqueService.FinishRegistration(c.Request.Context(), userID, req.Record); err != nil {
+		statusCode := http.StatusInternalServerError
+		if errors.Is(err, services.ErrInvalidOpaqueMessage) {
+			statusCode = http.StatusBadRequest
+		}
+		c.JSON(statusCode, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    statusCode,
+				Message: "Failed to register for OPAQUE login",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, types.APIResponse{
+		Success: true,
+		Data:    gin.H{"message": "OPAQUE login registered"},
+	})
+}
+
+// DeleteRegistration turns OPAQUE login off for the wallet
+func (h *OpaqueHandler) DeleteRegistration(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+		})
+		return
+	}
+
+	if err := h.opaqueService.DeleteRegistration(c.Request.Context(), userID); err != nil {
+		statusCode := http.StatusInternalServerError
+		if errors.Is(err, services.ErrOpaqueNotRegistered) {
+			statusCode = http.StatusNotFound
+		}
+		c.JSON(statusCode, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    statusCode,
+				Message: "Failed to delete OPAQUE registration",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    gin.H{"message": "OPAQUE registration deleted"},
+	})
+}
+
+// BeginSignup starts creating a wallet with OPAQUE, so its passphrase is never sent to the server
+func (h *OpaqueHandler) BeginSignup(c *gin.Context) {
+	var req struct {
+		RegistrationRequest []byte `json:"registration_request" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid request format: registration_request is required",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	challenge, err := h.opaqueService.BeginSignup(c.Request.Context(), req.RegistrationRequest)
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		if errors.Is(err, services.ErrInvalidOpaqueMessage) {
+			statusCode = http.StatusBadRequest
+		}
+		c.JSON(statusCode, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    statusCode,
+				Message: "Failed to start wallet creation",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    challenge,
+	})
+}
+
+// FinishSignup creates the wallet with the client's registration record
+func (h *OpaqueHandler) FinishSignup(c *gin.Context) {
+	var req struct {
+		UserID       string `json:"user_id" binding:"required"`
+		Record       []byte `json:"record" binding:"required"`
+		RecoveryCode bool   `json:"recovery_code"` // also issue a recovery code for passphrase resets
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid request format: user_id and record are required",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	parsedUID, err := uuid.Parse(req.UserID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid user_id format",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	wallet, recoveryCode, err := h.opaqueService.FinishSignup(c.Request.Context(), parsedUID, req.Record, req.RecoveryCode)
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, services.ErrInvalidOpaqueMessage):
+			statusCode = http.StatusBadRequest
+		case errors.Is(err, services.ErrOpaqueSignupNotFound):
+			statusCode = http.StatusNotFound
+		}
+		c.JSON(statusCode, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    statusCode,
+				Message: "Failed to generate wallet",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	data := gin.H{
+		"uid":        wallet.UID.String(),
+		"created_at": wallet.CreatedAt.Format(time.RFC3339Nano),
+	}
+	if recoveryCode != "" {
+		data["recovery_code"] = recoveryCode // shown once, only its hash is stored
+	}
+
+	c.JSON(http.StatusCreated, types.APIResponse{
+		Success: true,
+		Data:    data,
+	})
+}
+
+// BeginLogin answers the client's KE1 with KE2
+func (h *OpaqueHandler) BeginLogin(c *gin.Context) {
+	var req struct {
+		UserID string `json:"user_id" binding:"required"`
+		KE1    []byte `json:"ke1" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid request format: user_id and ke1 are required",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	parsedUID, err := uuid.Parse(req.UserID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid user_id format",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	challenge, err := h.opaqueService.BeginLogin(c.Request.Context(), parsedUID, req.KE1, c.ClientIP())
+	if err != nil {
+		var locked *services.LockedError
+		switch {
+		case errors.As(err, &locked):
+			c.Header("Retry-After", strconv.Itoa(int(locked.RetryAfter.Seconds())+1))
+			c.JSON(http.StatusTooManyRequests, types.APIResponse{
+				Success: false,
+				Error:   middleware.LocalizedError(c, http.StatusTooManyRequests, i18n.CodeLoginLocked, err.Error()),
+			})
+		case errors.Is(err, services.ErrInvalidOpaqueMessage):
+			c.JSON(http.StatusBadRequest, types.APIResponse{
+				Success: false,
+				Error: &types.APIError{
+					Code:    http.StatusBadRequest,
+					Message: "Invalid KE1",
+					Details: err.Error(),
+				},
+			})
+		default:
+			c.JSON(http.StatusInternalServerError, types.APIResponse{
+				Success: false,
+				Error: &types.APIError{
+					Code:    http.StatusInternalServerError,
+					Message: "Failed to start OPAQUE login",
+					Details: err.Error(),
+				},
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    challenge,
+	})
+}
+
+// FinishLogin verifies the client's KE3 and returns the same tokens as a passphrase login
+func (h *OpaqueHandler) FinishLogin(c *gin.Context) {
+	var req struct {
+		LoginID   string `json:"login_id" binding:"required"`
+		KE3       []byte `json:"ke3" binding:"required"`
+		TOTPCode  string `json:"totp_code"`  // required once the wallet has TOTP enabled
+		MachineID string `json:"machine_id"` // UUIDv7 of the client device, optional
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid request format: login_id and ke3 are required",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	if req.MachineID != "" {
+		machineID, err := uuid.Parse(req.MachineID)
+		if err == nil {
+			err = types.ValidateUUIDv7(machineID)
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, types.APIResponse{
+				Success: false,
+				Error: &types.APIError{
+					Code:    http.StatusBadRequest,
+					Message: "Machine ID must be a valid UUIDv7",
+					Details: err.Error(),
+				},
+			})
+			return
+		}
+	}
+
+	userID, tokens, err := h.opaqueService.FinishLogin(c.Request.Context(), req.LoginID, req.KE3, req.TOTPCode, req.MachineID, c.ClientIP())
+	if err != nil {
+		statusCode := http.StatusUnauthorized
+		code := i18n.CodeAuthFailed
+		var locked *services.LockedError
+		switch {
+		case errors.Is(err, services.ErrMachineDeactivated):
+			statusCode = http.StatusForbidden
+			code = i18n.CodeMachineSignedOut
+		case errors.Is(err, services.ErrTOTPRequired):
+			code = i18n.CodeTOTPRequired
+		case errors.Is(err, services.ErrInvalidTOTP):
+			code = i18n.CodeInvalidTOTP
+		case errors.As(err, &locked):
+			statusCode = http.StatusTooManyRequests
+			code = i18n.CodeLoginLocked
+			c.Header("Retry-After", strconv.Itoa(int(locked.RetryAfter.Seconds())+1))
+		}
+		c.JSON(statusCode, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, statusCode, code, err.Error()),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data: gin.H{
+			"tokens":  tokens,
+			"user_id": userID.String(),
+		},
+	})
+}
@@ -0,0 +1,293 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/i18n"
+	"github.com/helioschat/sync/internal/middleware"
+	"github.com/helioschat/sync/internal/services"
+	"github.com/helioschat/sync/internal/types"
+)
+
+type PasskeyHandler struct {
+	passkeyService *services.PasskeyService
+}
+
+func NewPasskeyHandler(passkeyService *services.PasskeyService) *PasskeyHandler {
+	return &PasskeyHandler{
+		passkeyService: passkeyService,
+	}
+}
+
+// BeginRegistration returns the options for navigator.credentials.create() to register a passkey
+func (h *PasskeyHandler) BeginRegistration(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+		})
+		return
+	}
+
+	options, err := h.passkeyService.BeginRegistration(c.Request.Context(), userID)
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		if errors.Is(err, services.ErrTooManyPasskeys) {
+			statusCode = http.StatusConflict
+		}
+		c.JSON(statusCode, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    statusCode,
+				Message: "Failed to start passkey registration",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    options,
+	})
+}
+
+// FinishRegistration stores the passkey created by the browser
+func (h *PasskeyHandler) FinishRegistration(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+		})
+		return
+	}
+
+	var req struct {
+		Name       string                  `json:"name"`
+		Credential types.PasskeyCredential `json:"credential" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid request format: credential is required",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	passkey, err := h.passkeyService.FinishRegistration(c.Request.Context(), userID, req.Name, &req.Credential)
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		if errors.Is(err, services.ErrInvalidPasskey) {
+			statusCode = http.StatusBadRequest
+		}
+		c.JSON(statusCode, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    statusCode,
+				Message: "Failed to register passkey",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, types.APIResponse{
+		Success: true,
+		Data:    passkey,
+	})
+}
+
+// BeginLogin returns the options for navigator.credentials.get(). The user ID is optional: without
+// it, the user picks one of their passkeys for the client app.
+func (h *PasskeyHandler) BeginLogin(c *gin.Context) {
+	var req struct {
+		UserID string `json:"user_id"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, types.APIResponse{
+				Success: false,
+				Error: &types.APIError{
+					Code:    http.StatusBadRequest,
+					Message: "Invalid request format",
+					Details: err.Error(),
+				},
+			})
+			return
+		}
+	}
+
+	var userID *uuid.UUID
+	if req.UserID != "" {
+		parsedUID, err := uuid.Parse(req.UserID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, types.APIResponse{
+				Success: false,
+				Error: &types.APIError{
+					Code:    http.StatusBadRequest,
+					Message: "Invalid UID format",
+					Details: err.Error(),
+				},
+			})
+			return
+		}
+		userID = &parsedUID
+	}
+
+	options, err := h.passkeyService.BeginLogin(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusInternalServerError,
+				Message: "Failed to start passkey login",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    options,
+	})
+}
+
+// FinishLogin verifies the passkey assertion and returns the same tokens as a passphrase login
+func (h *PasskeyHandler) FinishLogin(c *gin.Context) {
+	var req struct {
+		Credential types.PasskeyCredential `json:"credential" binding:"required"`
+		MachineID  string                  `json:"machine_id"` // UUIDv7 of the client device, optional
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid request format: credential is required",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	if req.MachineID != "" {
+		machineID, err := uuid.Parse(req.MachineID)
+		if err == nil {
+			err = types.ValidateUUIDv7(machineID)
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, types.APIResponse{
+				Success: false,
+				Error: &types.APIError{
+					Code:    http.StatusBadRequest,
+					Message: "Machine ID must be a valid UUIDv7",
+					Details: err.Error(),
+				},
+			})
+			return
+		}
+	}
+
+	userID, tokens, err := h.passkeyService.FinishLogin(c.Request.Context(), &req.Credential, req.MachineID, c.ClientIP())
+	if err != nil {
+		statusCode := http.StatusUnauthorized
+		code := i18n.CodeAuthFailed
+		var locked *services.LockedError
+		switch {
+		case errors.Is(err, services.ErrMachineDeactivated):
+			statusCode = http.StatusForbidden
+			code = i18n.CodeMachineSignedOut
+		case errors.As(err, &locked):
+			statusCode = http.StatusTooManyRequests
+			code = i18n.CodeLoginLocked
+			c.Header("Retry-After", strconv.Itoa(int(locked.RetryAfter.Seconds())+1))
+		}
+		c.JSON(statusCode, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, statusCode, code, err.Error()),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data: gin.H{
+			"tokens":  tokens,
+			"user_id": userID.String(),
+		},
+	})
+}
+
+// ListPasskeys returns the passkeys registered to the wallet
+func (h *PasskeyHandler) ListPasskeys(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+		})
+		return
+	}
+
+	passkeys, err := h.passkeyService.ListPasskeys(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusInternalServerError,
+				Message: "Failed to list passkeys",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    passkeys,
+	})
+}
+
+// DeletePasskey removes a passkey from the wallet
+func (h *PasskeyHandler) DeletePasskey(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+		})
+		return
+	}
+
+	if err := h.passkeyService.DeletePasskey(c.Request.Context(), userID, c.Param("id")); err != nil {
+		statusCode := http.StatusInternalServerError
+		if errors.Is(err, services.ErrPasskeyNotFound) {
+			statusCode = http.StatusNotFound
+		}
+		c.JSON(statusCode, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    statusCode,
+				Message: "Failed to delete passkey",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    gin.H{"message": "Passkey deleted"},
+	})
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"github.com/helioschat/sync/internal/i18n"
+	"github.com/helioschat/sync/internal/middleware"
+	"github.com/helioschat/sync/internal/services"
+	"github.com/helioschat/sync/internal/types"
+)
+
+type ProtocolHandler struct {
+	authService *services.AuthService
+	syncService *services.SyncService
+	routes      func() gin.RoutesInfo
+	limits      map[string]int64
+}
+
+// NewProtocolHandler creates the protocol description handler. limits adds limits enforced outside
+// the services, e.g. rate limits.
+func NewProtocolHandler(authService *services.AuthService, syncService *services.SyncService, limits map[string]int64) *ProtocolHandler {
+	return &ProtocolHandler{
+		authService: authService,
+		syncService: syncService,
+		routes:      func() gin.RoutesInfo { return nil },
+		limits:      limits,
+	}
+}
+
+// UseRoutes sets how the endpoints are listed; they are read when the description is requested,
+// so routes registered after this call are included
+func (h *ProtocolHandler) UseRoutes(routes func() gin.RoutesInfo) {
+	h.routes = routes
+}
+
+// GetProtocol describes the sync protocol of this exact instance: its endpoints, conflict rules,
+// cursor semantics, error codes, limits and example payloads
+func (h *ProtocolHandler) GetProtocol(c *gin.Context) {
+	protocol := services.DescribeProtocol(h.authService, h.syncService)
+	for name, limit := range h.limits {
+		protocol.Limits[name] = limit
+	}
+
+	protocol.Endpoints = []types.ProtocolEndpoint{}
+	for _, route := range h.routes() {
+		protocol.Endpoints = append(protocol.Endpoints, types.ProtocolEndpoint{
+			Method: route.Method,
+			Path:   route.Path,
+			Scope:  middleware.RequiredScope(route.Method, route.Path),
+		})
+	}
+	sort.Slice(protocol.Endpoints, func(i, j int) bool {
+		if protocol.Endpoints[i].Path != protocol.Endpoints[j].Path {
+			return protocol.Endpoints[i].Path < protocol.Endpoints[j].Path
+		}
+		return protocol.Endpoints[i].Method < protocol.Endpoints[j].Method
+	})
+
+	locale := middleware.GetLocale(c)
+	for _, code := range i18n.Codes() {
+		protocol.ErrorCodes = append(protocol.ErrorCodes, types.ProtocolErrorCode{
+			Code:    string(code),
+			Message: i18n.Message(code, locale),
+		})
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    protocol,
+	})
+}
+
+// GetSchema serves the JSON Schema of the sync types and request envelopes, for clients written
+// against the wire format rather than this server's code. Like the discovery document, it is a
+// bare document rather than an API response.
+func (h *ProtocolHandler) GetSchema(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=3600")
+	c.Header("Content-Type", "application/schema+json")
+	c.JSON(http.StatusOK, services.SyncSchema())
+}
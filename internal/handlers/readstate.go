@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/i18n"
+	"github.com/helioschat/sync/internal/middleware"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// readStateBatchMax caps the read states of one write
+const readStateBatchMax = 500
+
+// Read state handlers
+func (h *SyncHandler) GetReadStates(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+		})
+		return
+	}
+
+	states, err := h.syncService.GetReadStates(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusInternalServerError,
+				Message: "Failed to get read states",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	if middleware.IsMetadataOnly(c) {
+		for i := range states {
+			states[i] = states[i].Redacted()
+		}
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    states,
+	})
+}
+
+// UpdateReadStates writes the read states of several threads. Every state is checked before any
+// is written, so a rejected request changes nothing.
+func (h *SyncHandler) UpdateReadStates(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+		})
+		return
+	}
+
+	var req types.ReadStateBatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid request format",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	// Validate that the user ID in the request matches the authenticated user
+	if req.UserID != userID {
+		c.JSON(http.StatusForbidden, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusForbidden,
+				Message: "User ID in request does not match authenticated user",
+			},
+		})
+		return
+	}
+
+	if len(req.States) == 0 || len(req.States) > readStateBatchMax {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid batch size",
+				Details: fmt.Sprintf("a request holds 1 to %d read states", readStateBatchMax),
+			},
+		})
+		return
+	}
+
+	for i := range req.States {
+		state := &req.States[i]
+		if state.ThreadID == uuid.Nil || state.ReadAt.IsZero() {
+			c.JSON(http.StatusBadRequest, types.APIResponse{
+				Success: false,
+				Error: &types.APIError{
+					Code:    http.StatusBadRequest,
+					Message: "Invalid read state",
+					Details: fmt.Sprintf("read state %d needs a thread_id and read_at", i),
+				},
+			})
+			return
+		}
+		if !h.validateEncryptionVersion(c, &state.EncV) {
+			return
+		}
+	}
+
+	machineID, ok := machineIDFor(c, req.MachineID)
+	if !ok {
+		return
+	}
+
+	if !h.requireActiveMachine(c, userID, machineID) {
+		return
+	}
+
+	threadIDs := make([]string, len(req.States))
+	for i, state := range req.States {
+		threadIDs[i] = state.ThreadID.String()
+	}
+	if !h.requireThreadOwners(c, userID, threadIDs) {
+		return
+	}
+
+	stored, err := h.syncService.UpdateReadStates(c.Request.Context(), userID, req.States, machineID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusInternalServerError,
+				Message: "Failed to save read states",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    stored,
+	})
+}
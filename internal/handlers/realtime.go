@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"cmp"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/helioschat/sync/internal/i18n"
+	"github.com/helioschat/sync/internal/middleware"
+	"github.com/helioschat/sync/internal/types"
+	"golang.org/x/net/websocket"
+)
+
+// socketRecheckInterval is how often an open socket checks that its token is still good
+const socketRecheckInterval = 30 * time.Second
+
+// SyncSocket upgrades to a WebSocket that pushes the user's changes as other machines write them,
+// as the same operations changes-since returns. A device syncs with changes-since once after
+// connecting and then only listens. The socket closes when the access token expires, and within
+// socketRecheckInterval of it being revoked or its machine deactivated; a "resync" event means
+// events were lost and the device has to run changes-since again.
+func (h *SyncHandler) SyncSocket(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+		})
+		return
+	}
+
+	if h.feed == nil {
+		c.JSON(http.StatusNotFound, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusNotFound,
+				Message: "Realtime sync is disabled",
+			},
+		})
+		return
+	}
+
+	// The device's own writes aren't echoed back to it. Browsers can't set X-Machine-Id on the
+	// handshake, so the machine ID may come as a query parameter too.
+	claims, _ := middleware.GetTokenClaims(c)
+	machineID := cmp.Or(c.Query("machine_id"), middleware.GetMachineID(c))
+	if machineID != "" || (claims != nil && claims.MachineID != "") {
+		if machineID, ok = machineIDFor(c, machineID); !ok {
+			return
+		}
+	}
+
+	sub, err := h.feed.Subscribe(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusInternalServerError,
+				Message: "Failed to subscribe to changes",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+	defer sub.Close()
+
+	var expiry <-chan time.Time
+	if claims != nil && !claims.ExpiresAt.IsZero() {
+		timer := time.NewTimer(time.Until(claims.ExpiresAt))
+		defer timer.Stop()
+		expiry = timer.C
+	}
+
+	server := websocket.Server{
+		// The token authenticates the socket, so any origin may open it. Browsers that offered
+		// their token as a subprotocol get "bearer" accepted.
+		Handshake: func(config *websocket.Config, r *http.Request) error {
+			if slices.Contains(config.Protocol, "bearer") {
+				config.Protocol = []string{"bearer"}
+			} else {
+				config.Protocol = nil
+			}
+			return nil
+		},
+		Handler: func(ws *websocket.Conn) {
+			// Nothing is read from clients; reading notices when they disconnect
+			closed := make(chan struct{})
+			go func() {
+				var discard []byte
+				for websocket.Message.Receive(ws, &discard) == nil {
+				}
+				close(closed)
+			}()
+
+			recheck := time.NewTicker(socketRecheckInterval)
+			defer recheck.Stop()
+
+			for {
+				select {
+				case event, ok := <-sub.Events:
+					if !ok {
+						websocket.JSON.Send(ws, types.SyncEvent{Type: types.SyncEventResync})
+						return
+					}
+					if machineID != "" && event.Operation != nil && strings.EqualFold(event.Operation.MachineID, machineID) {
+						continue
+					}
+					if err := websocket.JSON.Send(ws, event); err != nil {
+						return
+					}
+				case <-closed:
+					return
+				case <-expiry:
+					return
+				case <-recheck.C:
+					if claims == nil {
+						continue
+					}
+					if err := h.authService.CheckClaims(c.Request.Context(), claims); err != nil {
+						return
+					}
+				}
+			}
+		},
+	}
+	server.ServeHTTP(c.Writer, c.Request)
+}
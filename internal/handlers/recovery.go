@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/i18n"
+	"github.com/helioschat/sync/internal/middleware"
+	"github.com/helioschat/sync/internal/services"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// IssueRecoveryCode creates or replaces the recovery code of the authenticated wallet
+func (h *AuthHandler) IssueRecoveryCode(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+		})
+		return
+	}
+
+	var req struct {
+		Passphrase string `json:"passphrase" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid request format: passphrase is required",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	code, err := h.AuthService.IssueRecoveryCode(c.Request.Context(), userID, req.Passphrase)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusUnauthorized,
+				Message: "Failed to issue recovery code",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    gin.H{"recovery_code": code},
+	})
+}
+
+// ResetPassphrase sets a new passphrase for a wallet using its recovery code
+func (h *AuthHandler) ResetPassphrase(c *gin.Context) {
+	var req struct {
+		UserID        string `json:"user_id" binding:"required"`
+		RecoveryCode  string `json:"recovery_code" binding:"required"`
+		TOTPCode      string `json:"totp_code"` // required once the wallet has TOTP enabled
+		NewPassphrase string `json:"new_passphrase" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid request format: user_id, recovery_code and new_passphrase are required",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	userID, err := uuid.Parse(req.UserID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid user_id format",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	code, err := h.AuthService.ResetPassphrase(c.Request.Context(), userID, req.RecoveryCode, req.TOTPCode, req.NewPassphrase)
+	if err != nil {
+		apiErr := &types.APIError{
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to reset passphrase",
+		}
+		var policyErr *services.PolicyError
+		switch {
+		case errors.Is(err, services.ErrInvalidRecoveryCode):
+			apiErr = middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeInvalidRecoveryCode, "")
+		case errors.Is(err, services.ErrTOTPRequired):
+			apiErr = middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeTOTPRequired, "")
+		case errors.Is(err, services.ErrInvalidTOTP):
+			apiErr = middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeInvalidTOTP, "")
+		case errors.As(err, &policyErr):
+			apiErr = middleware.LocalizedError(c, http.StatusBadRequest, i18n.CodeWeakPassphrase, "")
+			apiErr.Violations = policyErr.Violations
+		}
+		c.JSON(apiErr.Code, types.APIResponse{
+			Success: false,
+			Error:   apiErr,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data: gin.H{
+			"recovery_code": code, // the used code is spent; this one replaces it
+		},
+	})
+}
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/clock"
+	"github.com/helioschat/sync/internal/database"
+	"github.com/helioschat/sync/internal/services"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// settingsTestServer serves the settings routes as main registers them, authenticated as userID,
+// on a fresh bolt database
+func settingsTestServer(t *testing.T, userID uuid.UUID) http.Handler {
+	t.Helper()
+	db, err := database.NewBoltStore(filepath.Join(t.TempDir(), "sync.db"), database.CompressionPolicy{})
+	if err != nil {
+		t.Fatalf("NewBoltStore: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	syncService := services.NewSyncService(db, nil, types.Quotas{}, nil, clock.System)
+	authService := services.NewAuthService(nil, db, services.MachinePolicy{}, "", types.Argon2Params{}, services.LockoutPolicy{}, nil, nil, nil, clock.System)
+	h := NewSyncHandler(syncService, authService, types.EncryptionPolicy{CurrentVersion: 1, SupportedVersions: []int{1}})
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	sync := router.Group("/api/v1/sync", func(c *gin.Context) { c.Set("user_id", userID) })
+	for _, settings := range services.SettingsResources {
+		sync.GET(settings.Path(), h.GetSettings(settings))
+		sync.PUT(settings.Path(), h.UpdateSettings(settings))
+		if settings.Deletable {
+			sync.DELETE(settings.Path(), h.DeleteSettings(settings))
+		}
+	}
+	return router
+}
+
+// settingsRequest sends a request to a settings route and decodes the document it answers with
+func settingsRequest(t *testing.T, server http.Handler, method, path string, body interface{}) (int, map[string]interface{}) {
+	t.Helper()
+	var reader bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reader).Encode(body); err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+	}
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(method, "/api/v1/sync"+path, &reader))
+
+	var response struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if strings.HasPrefix(recorder.Header().Get("Content-Type"), "application/json") {
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+			t.Fatalf("%s %s: invalid response %q: %v", method, path, recorder.Body.String(), err)
+		}
+	}
+	return recorder.Code, response.Data
+}
+
+// settingsWrite is the body of a settings PUT
+func settingsWrite(userID uuid.UUID, version int64, data map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"machine_id": uuid.Must(uuid.NewV7()).String(),
+		"user_id":    userID,
+		"version":    version,
+		"data":       data,
+	}
+}
+
+func TestSettingsRoutesDeleteTagsWithTombstone(t *testing.T) {
+	userID := uuid.New()
+	server := settingsTestServer(t, userID)
+
+	if code, _ := settingsRequest(t, server, http.MethodGet, "/tags", nil); code != http.StatusNotFound {
+		t.Errorf("GET before any write: status = %d, want 404", code)
+	}
+	if code, _ := settingsRequest(t, server, http.MethodDelete, "/tags", nil); code != http.StatusNotFound {
+		t.Errorf("DELETE before any write: status = %d, want 404", code)
+	}
+
+	tags := map[string]interface{}{"definitions": map[string]interface{}{"t1": "sealed"}}
+	if code, _ := settingsRequest(t, server, http.MethodPut, "/tags", settingsWrite(userID, 1, tags)); code != http.StatusOK {
+		t.Fatalf("PUT: status = %d, want 200", code)
+	}
+	code, document := settingsRequest(t, server, http.MethodGet, "/tags", nil)
+	if code != http.StatusOK || document["version"] != float64(1) || document["definitions"] == nil {
+		t.Fatalf("GET: status = %d, document = %v, want version 1 with its definitions", code, document)
+	}
+
+	if code, _ := settingsRequest(t, server, http.MethodDelete, "/tags", nil); code != http.StatusOK {
+		t.Fatalf("DELETE: status = %d, want 200", code)
+	}
+	code, tombstone := settingsRequest(t, server, http.MethodGet, "/tags", nil)
+	if code != http.StatusOK || tombstone["deleted"] != true || tombstone["definitions"] != nil {
+		t.Fatalf("GET after DELETE: status = %d, document = %v, want a tombstone", code, tombstone)
+	}
+	deletedAt := int64(tombstone["version"].(float64))
+	if deletedAt <= 1 {
+		t.Errorf("tombstone version = %d, want it past the deleted document's", deletedAt)
+	}
+
+	// A device still holding the old tags can't write them back
+	if code, _ := settingsRequest(t, server, http.MethodPut, "/tags", settingsWrite(userID, 2, tags)); code != http.StatusConflict {
+		t.Errorf("PUT over the tombstone: status = %d, want 409", code)
+	}
+	if code, _ := settingsRequest(t, server, http.MethodPut, "/tags", settingsWrite(userID, deletedAt+1, tags)); code != http.StatusOK {
+		t.Fatalf("PUT newer than the tombstone: status = %d, want 200", code)
+	}
+	code, document = settingsRequest(t, server, http.MethodGet, "/tags", nil)
+	if code != http.StatusOK || document["deleted"] != nil || document["definitions"] == nil {
+		t.Errorf("GET after writing over the tombstone: status = %d, document = %v, want the new tags", code, document)
+	}
+}
+
+func TestSettingsRoutesKeepProfile(t *testing.T) {
+	userID := uuid.New()
+	server := settingsTestServer(t, userID)
+
+	profile := map[string]interface{}{"display_name": "sealed"}
+	if code, _ := settingsRequest(t, server, http.MethodPut, "/profile", settingsWrite(userID, 1, profile)); code != http.StatusOK {
+		t.Fatalf("PUT: status = %d, want 200", code)
+	}
+	if code, _ := settingsRequest(t, server, http.MethodPut, "/profile", settingsWrite(uuid.New(), 2, profile)); code != http.StatusForbidden {
+		t.Errorf("PUT for another user: status = %d, want 403", code)
+	}
+
+	if code, _ := settingsRequest(t, server, http.MethodDelete, "/profile", nil); code != http.StatusNotFound {
+		t.Errorf("DELETE: status = %d, want 404, as the profile has no delete route", code)
+	}
+	code, document := settingsRequest(t, server, http.MethodGet, "/profile", nil)
+	if code != http.StatusOK || document["display_name"] != "sealed" || document["version"] != float64(1) {
+		t.Errorf("GET: status = %d, document = %v, want the profile written", code, document)
+	}
+}
@@ -1,276 +1,430 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/i18n"
 	"github.com/helioschat/sync/internal/middleware"
 	"github.com/helioschat/sync/internal/services"
 	"github.com/helioschat/sync/internal/types"
 )
 
+// Page size limits
+const (
+	threadsPageDefault  = 10
+	threadsPageMax      = 28
+	messagesPageDefault = 20
+	messagesPageMax     = 50
+)
+
 type SyncHandler struct {
 	syncService *services.SyncService
 	authService *services.AuthService
+	encryption  types.EncryptionPolicy
+	batches     *services.BatchApplier
+	feed        *services.ChangeFeed
+
+	importMaxItemBytes int64
 }
 
-func NewSyncHandler(syncService *services.SyncService, authService *services.AuthService) *SyncHandler {
+func NewSyncHandler(syncService *services.SyncService, authService *services.AuthService, encryption types.EncryptionPolicy) *SyncHandler {
 	return &SyncHandler{
 		syncService: syncService,
 		authService: authService,
+		encryption:  encryption,
 	}
 }
 
-// Thread handlers
-func (h *SyncHandler) GetThreads(c *gin.Context) {
-	userID, ok := middleware.GetUserID(c)
-	if !ok {
-		c.JSON(http.StatusUnauthorized, types.APIResponse{
+// UseBatches applies sync batches with batches
+func (h *SyncHandler) UseBatches(batches *services.BatchApplier) {
+	h.batches = batches
+}
+
+// UseChangeFeed enables the realtime sync socket, fed by feed
+func (h *SyncHandler) UseChangeFeed(feed *services.ChangeFeed) {
+	h.feed = feed
+}
+
+// LimitImportItems caps the size of single items in imported documents (0 means no limit)
+func (h *SyncHandler) LimitImportItems(maxBytes int64) {
+	h.importMaxItemBytes = maxBytes
+}
+
+// validateEncryptionVersion rejects payloads written with an unsupported encryption envelope
+func (h *SyncHandler) validateEncryptionVersion(c *gin.Context, encV *int) bool {
+	if err := h.encryption.Validate(encV); err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
 			Success: false,
 			Error: &types.APIError{
-				Code:    http.StatusUnauthorized,
-				Message: "User not authenticated",
+				Code:    http.StatusBadRequest,
+				Message: "Unsupported encryption version",
+				Details: err.Error(),
 			},
 		})
-		return
+		return false
 	}
+	return true
+}
 
-	// Parse pagination parameters
-	const maxLimit = 28 // Hard-coded maximum limit
-	const defaultLimit = 10
+// requireThreadOwner answers 404 unless threadID is one of the user's threads
+func (h *SyncHandler) requireThreadOwner(c *gin.Context, userID uuid.UUID, threadID string) bool {
+	return h.respondThreadOwner(c, h.syncService.CheckThreadOwner(c.Request.Context(), userID, threadID))
+}
 
-	offset := 0
-	if offsetStr := c.Query("offset"); offsetStr != "" {
-		if parsedOffset, err := strconv.Atoi(offsetStr); err == nil && parsedOffset >= 0 {
-			offset = parsedOffset
-		}
-	}
+// requireThreadOwners is requireThreadOwner for several threads, checked in one pass
+func (h *SyncHandler) requireThreadOwners(c *gin.Context, userID uuid.UUID, threadIDs []string) bool {
+	return h.respondThreadOwner(c, h.syncService.CheckThreadOwners(c.Request.Context(), userID, threadIDs))
+}
 
-	limit := defaultLimit
-	if limitStr := c.Query("limit"); limitStr != "" {
-		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 {
-			limit = parsedLimit
-			if limit > maxLimit {
-				limit = maxLimit
-			}
-		}
+// respondThreadOwner answers a failed ownership check
+func (h *SyncHandler) respondThreadOwner(c *gin.Context, err error) bool {
+	if err == nil {
+		return true
 	}
 
-	// Parse optional since parameter
-	var since *time.Time
-	if sinceStr := c.Query("since"); sinceStr != "" {
-		if sinceTime, err := time.Parse(time.RFC3339, sinceStr); err == nil {
-			since = &sinceTime
-		}
+	statusCode := http.StatusInternalServerError
+	message := "Failed to check thread"
+	details := err.Error()
+	if errors.Is(err, services.ErrThreadNotFound) {
+		statusCode = http.StatusNotFound
+		message = "Thread not found"
+		details = ""
 	}
+	c.JSON(statusCode, types.APIResponse{
+		Success: false,
+		Error: &types.APIError{
+			Code:    statusCode,
+			Message: message,
+			Details: details,
+		},
+	})
+	return false
+}
 
-	// Use paginated method
-	result, err := h.syncService.GetThreadsPaginated(userID, offset, limit, since)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, types.APIResponse{
-			Success: false,
-			Error: &types.APIError{
-				Code:    http.StatusInternalServerError,
-				Message: "Failed to get threads",
-				Details: err.Error(),
-			},
-		})
-		return
+// requireActiveMachine rejects writes from deactivated machines and, if the instance requires it, unregistered ones
+func (h *SyncHandler) requireActiveMachine(c *gin.Context, userID uuid.UUID, machineID string) bool {
+	err := h.authService.CheckMachine(c.Request.Context(), userID, machineID)
+	if err == nil {
+		return true
 	}
 
-	c.JSON(http.StatusOK, types.APIResponse{
-		Success: true,
-		Data:    result,
+	statusCode := http.StatusForbidden
+	message := "Machine is not allowed to write"
+	switch {
+	case errors.Is(err, services.ErrMachineDeactivated):
+		message = "Machine has been deactivated"
+	case errors.Is(err, services.ErrMachineNotRegistered):
+		message = "Machine is not registered"
+	default:
+		statusCode = http.StatusInternalServerError
+		message = "Failed to check machine registration"
+	}
+	c.JSON(statusCode, types.APIResponse{
+		Success: false,
+		Error: &types.APIError{
+			Code:    statusCode,
+			Message: message,
+			Details: err.Error(),
+		},
 	})
+	return false
 }
 
-func (h *SyncHandler) UpsertThread(c *gin.Context) {
-	userID, ok := middleware.GetUserID(c)
-	if !ok {
-		c.JSON(http.StatusUnauthorized, types.APIResponse{
-			Success: false,
-			Error: &types.APIError{
-				Code:    http.StatusUnauthorized,
-				Message: "User not authenticated",
-			},
-		})
-		return
+// machineIDFor returns the machine a write is attributed to. A token bound to a machine at login
+// decides it, and a machine_id in the body must agree; tokens issued without one fall back to the
+// body's, which has to be a UUIDv7.
+func machineIDFor(c *gin.Context, bodyID string) (string, bool) {
+	if claims, ok := middleware.GetTokenClaims(c); ok && claims.MachineID != "" {
+		if bodyID != "" && !strings.EqualFold(bodyID, claims.MachineID) {
+			c.JSON(http.StatusForbidden, types.APIResponse{
+				Success: false,
+				Error:   middleware.LocalizedError(c, http.StatusForbidden, i18n.CodeMachineMismatch, "machine_id in the request body differs from the token's"),
+			})
+			return "", false
+		}
+		return claims.MachineID, true
 	}
 
-	// Validate and parse thread ID from URL parameter
-	threadID, err := uuid.Parse(c.Param("id"))
+	machineID, err := uuid.Parse(bodyID)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, types.APIResponse{
 			Success: false,
 			Error: &types.APIError{
 				Code:    http.StatusBadRequest,
-				Message: "Invalid thread ID format - must be a valid UUID",
+				Message: "Invalid machine ID format - must be a valid UUID",
 				Details: err.Error(),
 			},
 		})
-		return
+		return "", false
 	}
 
-	var req types.ThreadUpdateRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
+	if err := types.ValidateUUIDv7(machineID); err != nil {
 		c.JSON(http.StatusBadRequest, types.APIResponse{
 			Success: false,
 			Error: &types.APIError{
 				Code:    http.StatusBadRequest,
-				Message: "Invalid request format",
+				Message: "Machine ID must be a valid UUIDv7",
 				Details: err.Error(),
 			},
 		})
-		return
+		return "", false
 	}
+	middleware.SetMachineID(c, bodyID)
+	return bodyID, true
+}
 
-	// Validate that the user ID in the request matches the authenticated user
-	if req.UserID != userID {
-		c.JSON(http.StatusForbidden, types.APIResponse{
+// threadIDQuery reads the required thread_id query parameter. Thread IDs end up in storage keys,
+// so anything but a UUID is rejected and accepted IDs are normalized to their canonical lowercase form.
+func threadIDQuery(c *gin.Context) (string, bool) {
+	raw := c.Query("thread_id")
+	if raw == "" {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
 			Success: false,
 			Error: &types.APIError{
-				Code:    http.StatusForbidden,
-				Message: "User ID in request does not match authenticated user",
+				Code:    http.StatusBadRequest,
+				Message: "thread_id parameter is required",
 			},
 		})
-		return
+		return "", false
 	}
 
-	// Validate machine ID is a valid UUIDv7
-	machineID, err := uuid.Parse(req.MachineID)
+	threadID, err := uuid.Parse(raw)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, types.APIResponse{
 			Success: false,
 			Error: &types.APIError{
 				Code:    http.StatusBadRequest,
-				Message: "Invalid machine ID format - must be a valid UUID",
+				Message: "Invalid thread ID format - must be a valid UUID",
 				Details: err.Error(),
 			},
 		})
-		return
+		return "", false
 	}
 
-	if err := types.ValidateUUIDv7(machineID); err != nil {
+	return threadID.String(), true
+}
+
+// validMessageID checks a message ID chosen by the client against the ID policy, responding with 400 if it fails
+func validMessageID(c *gin.Context, messageID string) bool {
+	if err := types.ValidateMessageID(messageID); err != nil {
 		c.JSON(http.StatusBadRequest, types.APIResponse{
 			Success: false,
 			Error: &types.APIError{
 				Code:    http.StatusBadRequest,
-				Message: "Machine ID must be a valid UUIDv7",
+				Message: "Invalid message ID",
 				Details: err.Error(),
 			},
 		})
-		return
+		return false
 	}
+	return true
+}
 
-	thread := req.Data
+// quotaWarnings returns the limit warnings to embed in a successful write response.
+// A failure to compute them is logged and never fails the write.
+func (h *SyncHandler) quotaWarnings(c *gin.Context, userID uuid.UUID) []types.QuotaWarning {
+	warnings, err := h.syncService.QuotaWarnings(c.Request.Context(), userID)
+	if err != nil {
+		fmt.Printf("Warning: failed to compute quota warnings for user %s: %v\n", userID, err)
+		return nil
+	}
+	return localizeQuotaWarnings(c, warnings)
+}
 
-	// Validate that the thread ID in the body matches the URL parameter
-	if thread.ID != uuid.Nil && thread.ID != threadID {
-		c.JSON(http.StatusBadRequest, types.APIResponse{
+// localizeQuotaWarnings fills in the warning messages in the client's locale
+func localizeQuotaWarnings(c *gin.Context, warnings []types.QuotaWarning) []types.QuotaWarning {
+	locale := middleware.GetLocale(c)
+	for i := range warnings {
+		code := i18n.CodeQuotaWarning
+		if warnings[i].Level == types.QuotaCriticalLevel {
+			code = i18n.CodeQuotaCritical
+		}
+		warnings[i].Message = i18n.Message(code, locale, warnings[i].Used, warnings[i].Limit)
+	}
+	return warnings
+}
+
+// GetUsage reports the user's stored record counts against their limits
+func (h *SyncHandler) GetUsage(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
 			Success: false,
-			Error: &types.APIError{
-				Code:    http.StatusBadRequest,
-				Message: "Thread ID in request body does not match URL parameter",
-			},
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
 		})
 		return
 	}
 
-	// Set the IDs and version from the request
-	thread.ID = threadID
-	thread.UserID = req.UserID
-	thread.Version = req.Version
-
-	// Try to upsert the thread
-	created, err := h.syncService.UpsertThread(&thread, req.MachineID)
+	usage, err := h.syncService.GetUsageReport(c.Request.Context(), userID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, types.APIResponse{
 			Success: false,
 			Error: &types.APIError{
 				Code:    http.StatusInternalServerError,
-				Message: "Failed to save thread",
+				Message: "Failed to get usage",
 				Details: err.Error(),
 			},
 		})
 		return
 	}
 
-	statusCode := http.StatusOK
-	if created {
-		statusCode = http.StatusCreated
-	}
+	usage.Warnings = localizeQuotaWarnings(c, usage.Warnings)
 
-	c.JSON(statusCode, types.APIResponse{
+	c.JSON(http.StatusOK, types.APIResponse{
 		Success: true,
-		Data:    thread,
+		Data:    usage,
 	})
 }
 
-func (h *SyncHandler) DeleteThread(c *gin.Context) {
+// Limits returns the page sizes and request limits of the sync endpoints
+func (h *SyncHandler) Limits() types.SyncLimits {
+	return types.SyncLimits{
+		ThreadsPageDefault:  threadsPageDefault,
+		ThreadsPageMax:      threadsPageMax,
+		MessagesPageDefault: messagesPageDefault,
+		MessagesPageMax:     messagesPageMax,
+		BatchOperationsMax:  batchOperationsMax,
+		ReadStateBatchMax:   readStateBatchMax,
+		ImportItemMaxBytes:  h.importMaxItemBytes,
+		MessageIDMaxLength:  types.MessageIDMaxLength,
+		AttachmentMaxBytes:  h.syncService.AttachmentMaxBytes(),
+	}
+}
+
+// Features returns the optional parts of the sync API this instance serves
+func (h *SyncHandler) Features() types.ServerFeatures {
+	features := serverFeatures
+	features.Attachments = h.syncService.AttachmentsEnabled()
+	return features
+}
+
+// Benchmark measures storage latency with small synthetic records for the client's server health screen
+func (h *SyncHandler) Benchmark(c *gin.Context) {
 	userID, ok := middleware.GetUserID(c)
 	if !ok {
 		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+		})
+		return
+	}
+
+	var req types.BenchmarkRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, types.APIResponse{
+				Success: false,
+				Error: &types.APIError{
+					Code:    http.StatusBadRequest,
+					Message: "Invalid request format",
+					Details: err.Error(),
+				},
+			})
+			return
+		}
+	}
+
+	report, err := h.syncService.Benchmark(c.Request.Context(), userID, req)
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		if errors.Is(err, services.ErrInvalidBenchmark) {
+			statusCode = http.StatusBadRequest
+		}
+		c.JSON(statusCode, types.APIResponse{
 			Success: false,
 			Error: &types.APIError{
-				Code:    http.StatusUnauthorized,
-				Message: "User not authenticated",
+				Code:    statusCode,
+				Message: "Benchmark failed",
+				Details: err.Error(),
 			},
 		})
 		return
 	}
 
-	threadID, err := uuid.Parse(c.Param("id"))
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    report,
+	})
+}
+
+// GetBootstrap returns settings, the first page of threads, limits and a sync cursor in one call
+func (h *SyncHandler) GetBootstrap(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+		})
+		return
+	}
+
+	limit := threadsPageDefault
+	if limitStr := c.Query("limit"); limitStr != "" {
+		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 {
+			limit = min(parsedLimit, threadsPageMax)
+		}
+	}
+
+	response, err := h.syncService.GetBootstrap(c.Request.Context(), userID, limit)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, types.APIResponse{
+		c.JSON(http.StatusInternalServerError, types.APIResponse{
 			Success: false,
 			Error: &types.APIError{
-				Code:    http.StatusBadRequest,
-				Message: "Invalid thread ID",
+				Code:    http.StatusInternalServerError,
+				Message: "Failed to load bootstrap data",
 				Details: err.Error(),
 			},
 		})
 		return
 	}
 
-	if err := h.syncService.DeleteThread(userID, threadID); err != nil {
+	response.Machines, err = h.authService.GetMachines(c.Request.Context(), userID)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, types.APIResponse{
 			Success: false,
 			Error: &types.APIError{
 				Code:    http.StatusInternalServerError,
-				Message: "Failed to delete thread",
+				Message: "Failed to load bootstrap data",
 				Details: err.Error(),
 			},
 		})
 		return
 	}
 
+	response.Limits = h.Limits()
+
+	if middleware.IsMetadataOnly(c) {
+		redacted := response.Redacted()
+		response = &redacted
+	}
+
 	c.JSON(http.StatusOK, types.APIResponse{
 		Success: true,
-		Data:    gin.H{"message": "Thread deleted successfully"},
+		Data:    response,
 	})
 }
 
-// Message handlers
-func (h *SyncHandler) GetMessages(c *gin.Context) {
-	// Parse required thread_id parameter
-	threadIDStr := c.Query("thread_id")
-	if threadIDStr == "" {
-		c.JSON(http.StatusBadRequest, types.APIResponse{
+// Thread handlers
+func (h *SyncHandler) GetThreads(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
 			Success: false,
-			Error: &types.APIError{
-				Code:    http.StatusBadRequest,
-				Message: "thread_id parameter is required",
-			},
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
 		})
 		return
 	}
 
 	// Parse pagination parameters
-	const maxLimit = 50 // Hard-coded maximum limit for messages
-	const defaultLimit = 20
+	const maxLimit = threadsPageMax
+	const defaultLimit = threadsPageDefault
 
 	offset := 0
 	if offsetStr := c.Query("offset"); offsetStr != "" {
@@ -282,10 +436,9 @@ func (h *SyncHandler) GetMessages(c *gin.Context) {
 	limit := defaultLimit
 	if limitStr := c.Query("limit"); limitStr != "" {
 		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 {
-			if parsedLimit > maxLimit {
+			limit = parsedLimit
+			if limit > maxLimit {
 				limit = maxLimit
-			} else {
-				limit = parsedLimit
 			}
 		}
 	}
@@ -298,42 +451,87 @@ func (h *SyncHandler) GetMessages(c *gin.Context) {
 		}
 	}
 
-	// Use paginated method
-	result, err := h.syncService.GetMessagesPaginated(threadIDStr, offset, limit, since)
+	// Archived threads are hidden unless asked for
+	includeArchived := false
+	if includeStr := c.Query("include_archived"); includeStr != "" {
+		if parsed, err := strconv.ParseBool(includeStr); err == nil {
+			includeArchived = parsed
+		}
+	}
+
+	// Pages follow a cursor when given one, an offset otherwise
+	var result *types.PaginatedThreadsResponse
+	var err error
+	if cursor := c.Query("cursor"); cursor != "" {
+		result, err = h.syncService.GetThreadsAfter(c.Request.Context(), userID, cursor, limit, since, includeArchived)
+	} else {
+		result, err = h.syncService.GetThreadsPaginated(c.Request.Context(), userID, offset, limit, since, includeArchived)
+	}
+	if errors.Is(err, services.ErrInvalidCursor) {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid cursor",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, types.APIResponse{
 			Success: false,
 			Error: &types.APIError{
 				Code:    http.StatusInternalServerError,
-				Message: "Failed to get messages",
+				Message: "Failed to get threads",
 				Details: err.Error(),
 			},
 		})
 		return
 	}
 
+	if notModified(c, threadsETag(c, result)) {
+		return
+	}
+
+	if middleware.IsMetadataOnly(c) {
+		for i := range result.Threads {
+			result.Threads[i] = result.Threads[i].Redacted()
+		}
+	}
+
 	c.JSON(http.StatusOK, types.APIResponse{
 		Success: true,
 		Data:    result,
 	})
 }
 
-func (h *SyncHandler) CreateMessage(c *gin.Context) {
-	// Get threadID from URL parameter or request body
-	threadIDStr := c.Query("thread_id")
-	if threadIDStr == "" {
+func (h *SyncHandler) UpsertThread(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+		})
+		return
+	}
+
+	// Validate and parse thread ID from URL parameter
+	threadID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
 		c.JSON(http.StatusBadRequest, types.APIResponse{
 			Success: false,
 			Error: &types.APIError{
 				Code:    http.StatusBadRequest,
-				Message: "thread_id parameter is required",
+				Message: "Invalid thread ID format - must be a valid UUID",
+				Details: err.Error(),
 			},
 		})
 		return
 	}
 
-	var message types.Message
-	if err := c.ShouldBindJSON(&message); err != nil {
+	var req types.ThreadUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, types.APIResponse{
 			Success: false,
 			Error: &types.APIError{
@@ -345,139 +543,132 @@ func (h *SyncHandler) CreateMessage(c *gin.Context) {
 		return
 	}
 
-	// Since the Message struct no longer has UserID, we don't set it
-	// The service will handle ID generation if needed
-
-	if err := h.syncService.CreateMessage(threadIDStr, &message); err != nil {
-		c.JSON(http.StatusInternalServerError, types.APIResponse{
+	// Validate that the user ID in the request matches the authenticated user
+	if req.UserID != userID {
+		c.JSON(http.StatusForbidden, types.APIResponse{
 			Success: false,
 			Error: &types.APIError{
-				Code:    http.StatusInternalServerError,
-				Message: "Failed to create message",
-				Details: err.Error(),
+				Code:    http.StatusForbidden,
+				Message: "User ID in request does not match authenticated user",
 			},
 		})
 		return
 	}
 
-	c.JSON(http.StatusCreated, types.APIResponse{
-		Success: true,
-		Data:    message,
-	})
-}
-
-func (h *SyncHandler) UpdateMessage(c *gin.Context) {
-	userID, ok := middleware.GetUserID(c)
+	machineID, ok := machineIDFor(c, req.MachineID)
 	if !ok {
-		c.JSON(http.StatusUnauthorized, types.APIResponse{
-			Success: false,
-			Error: &types.APIError{
-				Code:    http.StatusUnauthorized,
-				Message: "User not authenticated",
-			},
-		})
 		return
 	}
 
-	messageID := c.Param("id") // Now expecting string ID
+	thread := req.Data
+	if !h.validateEncryptionVersion(c, &thread.EncV) {
+		return
+	}
 
-	var req types.MessageUpdateRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
+	// Validate that the thread ID in the body matches the URL parameter
+	if thread.ID != uuid.Nil && thread.ID != threadID {
 		c.JSON(http.StatusBadRequest, types.APIResponse{
 			Success: false,
 			Error: &types.APIError{
 				Code:    http.StatusBadRequest,
-				Message: "Invalid request format",
-				Details: err.Error(),
+				Message: "Thread ID in request body does not match URL parameter",
 			},
 		})
 		return
 	}
 
-	// Validate that the user ID in the request matches the authenticated user
-	if req.UserID != userID {
-		c.JSON(http.StatusForbidden, types.APIResponse{
-			Success: false,
-			Error: &types.APIError{
-				Code:    http.StatusForbidden,
-				Message: "User ID in request does not match authenticated user",
-			},
-		})
+	// Set the IDs and version from the request
+	thread.ID = threadID
+	thread.UserID = req.UserID
+	thread.Version = req.Version
+
+	if !h.requireActiveMachine(c, userID, machineID) {
 		return
 	}
 
-	// Validate machine ID is a valid UUIDv7
-	machineID, err := uuid.Parse(req.MachineID)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, types.APIResponse{
-			Success: false,
-			Error: &types.APIError{
-				Code:    http.StatusBadRequest,
-				Message: "Invalid machine ID format - must be a valid UUID",
-				Details: err.Error(),
-			},
-		})
+	if !h.requireIfMatch(c, userID, "thread", threadID.String()) {
 		return
 	}
 
-	if err := types.ValidateUUIDv7(machineID); err != nil {
-		c.JSON(http.StatusBadRequest, types.APIResponse{
+	// Try to upsert the thread
+	created, resolution, err := h.syncService.UpsertThread(c.Request.Context(), &thread, machineID)
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		message := "Failed to save thread"
+		var current interface{}
+		var conflict *services.ThreadConflictError
+		switch {
+		case errors.As(err, &conflict):
+			// The stored thread goes back with the error, for the client to merge into its copy
+			statusCode = http.StatusConflict
+			message = "Thread was changed"
+			current = conflict.Current
+		case errors.Is(err, services.ErrThreadDeleted):
+			// A device re-uploading a thread deleted elsewhere; it should drop its copy
+			statusCode = http.StatusConflict
+			message = "Thread was deleted"
+		case errors.Is(err, services.ErrThreadExists):
+			statusCode = http.StatusConflict
+			message = "Thread ID is taken"
+		}
+		c.JSON(statusCode, types.APIResponse{
 			Success: false,
 			Error: &types.APIError{
-				Code:    http.StatusBadRequest,
-				Message: "Machine ID must be a valid UUIDv7",
+				Code:    statusCode,
+				Message: message,
 				Details: err.Error(),
+				Current: current,
 			},
 		})
 		return
 	}
 
-	message := req.Data
-	message.ID = messageID
-	// Note: UserID and Version are no longer part of Message struct
+	statusCode := http.StatusOK
+	if created {
+		statusCode = http.StatusCreated
+	}
 
-	threadIDStr := req.ThreadID.String() // Convert UUID to string for service call
+	c.JSON(statusCode, types.APIResponse{
+		Success:  true,
+		Data:     thread,
+		Warnings: h.quotaWarnings(c, userID),
+		Conflict: resolution,
+	})
+}
 
-	if err := h.syncService.UpdateMessage(threadIDStr, &message, req.MachineID); err != nil {
-		c.JSON(http.StatusConflict, types.APIResponse{
+func (h *SyncHandler) DeleteThread(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
 			Success: false,
-			Error: &types.APIError{
-				Code:    http.StatusConflict,
-				Message: "Failed to update message",
-				Details: err.Error(),
-			},
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
 		})
 		return
 	}
 
-	c.JSON(http.StatusOK, types.APIResponse{
-		Success: true,
-		Data:    message,
-	})
-}
-
-func (h *SyncHandler) DeleteMessage(c *gin.Context) {
-	// Parse required thread_id parameter
-	threadIDStr := c.Query("thread_id")
-	if threadIDStr == "" {
+	threadID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
 		c.JSON(http.StatusBadRequest, types.APIResponse{
 			Success: false,
 			Error: &types.APIError{
 				Code:    http.StatusBadRequest,
-				Message: "thread_id parameter is required",
+				Message: "Invalid thread ID",
+				Details: err.Error(),
 			},
 		})
 		return
 	}
 
-	messageID := c.Param("id") // Now expecting string ID
+	if !h.requireActiveMachine(c, userID, middleware.GetMachineID(c)) {
+		return
+	}
 
-	if err := h.syncService.DeleteMessage(threadIDStr, messageID); err != nil {
+	if err := h.syncService.DeleteThread(c.Request.Context(), userID, threadID, middleware.GetMachineID(c)); err != nil {
 		c.JSON(http.StatusInternalServerError, types.APIResponse{
 			Success: false,
 			Error: &types.APIError{
 				Code:    http.StatusInternalServerError,
-				Message: "Failed to delete message",
+				Message: "Failed to delete thread",
 				Details: err.Error(),
 			},
 		})
@@ -486,56 +677,108 @@ func (h *SyncHandler) DeleteMessage(c *gin.Context) {
 
 	c.JSON(http.StatusOK, types.APIResponse{
 		Success: true,
-		Data:    gin.H{"message": "Message deleted successfully"},
+		Data:    gin.H{"message": "Thread deleted successfully"},
 	})
 }
 
-// User settings handlers
-func (h *SyncHandler) GetProviderInstances(c *gin.Context) {
+// ArchiveThread hides a thread from thread listings without deleting it
+func (h *SyncHandler) ArchiveThread(c *gin.Context) {
+	h.setThreadArchived(c, true)
+}
+
+// UnarchiveThread lists an archived thread again
+func (h *SyncHandler) UnarchiveThread(c *gin.Context) {
+	h.setThreadArchived(c, false)
+}
+
+func (h *SyncHandler) setThreadArchived(c *gin.Context, archived bool) {
 	userID, ok := middleware.GetUserID(c)
 	if !ok {
 		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+		})
+		return
+	}
+
+	threadID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
 			Success: false,
 			Error: &types.APIError{
-				Code:    http.StatusUnauthorized,
-				Message: "User not authenticated",
+				Code:    http.StatusBadRequest,
+				Message: "Invalid thread ID",
+				Details: err.Error(),
 			},
 		})
 		return
 	}
 
-	providers, err := h.syncService.GetProviderInstances(userID)
-	if err != nil {
+	if !h.requireActiveMachine(c, userID, middleware.GetMachineID(c)) {
+		return
+	}
+
+	thread, err := h.syncService.SetThreadArchived(c.Request.Context(), userID, threadID, archived, middleware.GetMachineID(c))
+	if errors.Is(err, services.ErrThreadNotFound) {
 		c.JSON(http.StatusNotFound, types.APIResponse{
 			Success: false,
 			Error: &types.APIError{
 				Code:    http.StatusNotFound,
-				Message: "Provider instances not found",
+				Message: "Thread not found",
 			},
 		})
 		return
 	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusInternalServerError,
+				Message: "Failed to archive thread",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	if middleware.IsMetadataOnly(c) {
+		redacted := thread.Redacted()
+		thread = &redacted
+	}
 
 	c.JSON(http.StatusOK, types.APIResponse{
 		Success: true,
-		Data:    providers,
+		Data:    thread,
 	})
 }
 
-func (h *SyncHandler) UpdateProviderInstances(c *gin.Context) {
+// BranchThread creates a new thread from the messages of a thread up to a given message. The
+// messages are shared with the source thread server-side, so the client only uploads the new
+// thread's metadata.
+func (h *SyncHandler) BranchThread(c *gin.Context) {
 	userID, ok := middleware.GetUserID(c)
 	if !ok {
 		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+		})
+		return
+	}
+
+	sourceID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
 			Success: false,
 			Error: &types.APIError{
-				Code:    http.StatusUnauthorized,
-				Message: "User not authenticated",
+				Code:    http.StatusBadRequest,
+				Message: "Invalid thread ID format - must be a valid UUID",
+				Details: err.Error(),
 			},
 		})
 		return
 	}
 
-	var req types.ProviderInstancesUpdateRequest
+	var req types.BranchThreadRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, types.APIResponse{
 			Success: false,
@@ -548,7 +791,6 @@ func (h *SyncHandler) UpdateProviderInstances(c *gin.Context) {
 		return
 	}
 
-	// Validate that the user ID in the request matches the authenticated user
 	if req.UserID != userID {
 		c.JSON(http.StatusForbidden, types.APIResponse{
 			Success: false,
@@ -560,216 +802,298 @@ func (h *SyncHandler) UpdateProviderInstances(c *gin.Context) {
 		return
 	}
 
-	// Validate machine ID is a valid UUIDv7
-	machineID, err := uuid.Parse(req.MachineID)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, types.APIResponse{
-			Success: false,
-			Error: &types.APIError{
-				Code:    http.StatusBadRequest,
-				Message: "Invalid machine ID format - must be a valid UUID",
-				Details: err.Error(),
-			},
-		})
+	machineID, ok := machineIDFor(c, req.MachineID)
+	if !ok {
 		return
 	}
 
-	if err := types.ValidateUUIDv7(machineID); err != nil {
+	branch := req.Data
+	if !h.validateEncryptionVersion(c, &branch.EncV) {
+		return
+	}
+	if branch.ID == uuid.Nil || branch.ID == sourceID {
 		c.JSON(http.StatusBadRequest, types.APIResponse{
 			Success: false,
 			Error: &types.APIError{
 				Code:    http.StatusBadRequest,
-				Message: "Machine ID must be a valid UUIDv7",
-				Details: err.Error(),
+				Message: "The branch needs a thread ID of its own",
 			},
 		})
 		return
 	}
+	if !validMessageID(c, req.UpToMessageID) {
+		return
+	}
+	branch.Version = req.Version
 
-	providers := req.Data
-	providers.UserID = req.UserID
-	providers.Version = req.Version
+	if !h.requireActiveMachine(c, userID, machineID) {
+		return
+	}
 
-	if err := h.syncService.UpdateProviderInstances(&providers, req.MachineID); err != nil {
-		c.JSON(http.StatusInternalServerError, types.APIResponse{
+	inherited, err := h.syncService.BranchThread(c.Request.Context(), userID, sourceID, &branch, req.UpToMessageID, machineID)
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		message := "Failed to branch thread"
+		switch {
+		case errors.Is(err, services.ErrThreadNotFound):
+			statusCode = http.StatusNotFound
+			message = "Thread not found"
+		case errors.Is(err, services.ErrBranchPointNotFound):
+			statusCode = http.StatusUnprocessableEntity
+			message = "The thread has no message with this ID"
+		case errors.Is(err, services.ErrThreadExists):
+			statusCode = http.StatusConflict
+			message = "A thread with the branch's ID already exists"
+		case errors.Is(err, services.ErrThreadDeleted):
+			statusCode = http.StatusConflict
+			message = "A thread with the branch's ID was deleted"
+		}
+		c.JSON(statusCode, types.APIResponse{
 			Success: false,
 			Error: &types.APIError{
-				Code:    http.StatusInternalServerError,
-				Message: "Failed to update provider instances",
+				Code:    statusCode,
+				Message: message,
 				Details: err.Error(),
 			},
 		})
 		return
 	}
 
-	c.JSON(http.StatusOK, types.APIResponse{
-		Success: true,
-		Data:    providers,
+	c.JSON(http.StatusCreated, types.APIResponse{
+		Success:  true,
+		Data:     types.ThreadBranch{Thread: branch, InheritedMessages: inherited},
+		Warnings: h.quotaWarnings(c, userID),
 	})
 }
 
-func (h *SyncHandler) GetDisabledModels(c *gin.Context) {
+func (h *SyncHandler) GetThreadMetaHistory(c *gin.Context) {
 	userID, ok := middleware.GetUserID(c)
 	if !ok {
 		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+		})
+		return
+	}
+
+	threadID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
 			Success: false,
 			Error: &types.APIError{
-				Code:    http.StatusUnauthorized,
-				Message: "User not authenticated",
+				Code:    http.StatusBadRequest,
+				Message: "Invalid thread ID",
+				Details: err.Error(),
 			},
 		})
 		return
 	}
 
-	models, err := h.syncService.GetDisabledModels(userID)
+	history, err := h.syncService.GetThreadMetaHistory(c.Request.Context(), userID, threadID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, types.APIResponse{
 			Success: false,
 			Error: &types.APIError{
 				Code:    http.StatusNotFound,
-				Message: "Disabled models not found",
+				Message: "Thread not found",
 			},
 		})
 		return
 	}
 
+	if middleware.IsMetadataOnly(c) {
+		for i := range history {
+			history[i] = history[i].Redacted()
+		}
+	}
+
 	c.JSON(http.StatusOK, types.APIResponse{
 		Success: true,
-		Data:    models,
+		Data:    history,
 	})
 }
 
-func (h *SyncHandler) UpdateDisabledModels(c *gin.Context) {
+// Message handlers
+func (h *SyncHandler) GetMessages(c *gin.Context) {
 	userID, ok := middleware.GetUserID(c)
 	if !ok {
 		c.JSON(http.StatusUnauthorized, types.APIResponse{
 			Success: false,
-			Error: &types.APIError{
-				Code:    http.StatusUnauthorized,
-				Message: "User not authenticated",
-			},
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
 		})
 		return
 	}
 
-	var req types.DisabledModelsUpdateRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, types.APIResponse{
-			Success: false,
-			Error: &types.APIError{
-				Code:    http.StatusBadRequest,
-				Message: "Invalid request format",
-				Details: err.Error(),
-			},
-		})
+	// Parse required thread_id parameter
+	threadIDStr, ok := threadIDQuery(c)
+	if !ok {
 		return
 	}
-
-	// Validate that the user ID in the request matches the authenticated user
-	if req.UserID != userID {
-		c.JSON(http.StatusForbidden, types.APIResponse{
-			Success: false,
-			Error: &types.APIError{
-				Code:    http.StatusForbidden,
-				Message: "User ID in request does not match authenticated user",
-			},
-		})
+	if !h.requireThreadOwner(c, userID, threadIDStr) {
 		return
 	}
 
-	// Validate machine ID is a valid UUIDv7
-	machineID, err := uuid.Parse(req.MachineID)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, types.APIResponse{
-			Success: false,
-			Error: &types.APIError{
-				Code:    http.StatusBadRequest,
-				Message: "Invalid machine ID format - must be a valid UUID",
-				Details: err.Error(),
-			},
-		})
-		return
+	// Parse pagination parameters
+	const maxLimit = messagesPageMax
+	const defaultLimit = messagesPageDefault
+
+	offset := 0
+	if offsetStr := c.Query("offset"); offsetStr != "" {
+		if parsedOffset, err := strconv.Atoi(offsetStr); err == nil && parsedOffset >= 0 {
+			offset = parsedOffset
+		}
 	}
 
-	if err := types.ValidateUUIDv7(machineID); err != nil {
+	limit := defaultLimit
+	if limitStr := c.Query("limit"); limitStr != "" {
+		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 {
+			if parsedLimit > maxLimit {
+				limit = maxLimit
+			} else {
+				limit = parsedLimit
+			}
+		}
+	}
+
+	// Parse optional since parameter
+	var since *time.Time
+	if sinceStr := c.Query("since"); sinceStr != "" {
+		if sinceTime, err := time.Parse(time.RFC3339, sinceStr); err == nil {
+			since = &sinceTime
+		}
+	}
+
+	// Pages follow a cursor when given one, an offset otherwise
+	var result *types.PaginatedMessagesResponse
+	var err error
+	if cursor := c.Query("cursor"); cursor != "" {
+		result, err = h.syncService.GetMessagesAfter(c.Request.Context(), threadIDStr, cursor, limit)
+	} else {
+		result, err = h.syncService.GetMessagesPaginated(c.Request.Context(), threadIDStr, offset, limit, since)
+	}
+	if errors.Is(err, services.ErrInvalidCursor) {
 		c.JSON(http.StatusBadRequest, types.APIResponse{
 			Success: false,
 			Error: &types.APIError{
 				Code:    http.StatusBadRequest,
-				Message: "Machine ID must be a valid UUIDv7",
+				Message: "Invalid cursor",
 				Details: err.Error(),
 			},
 		})
 		return
 	}
-
-	models := req.Data
-	models.UserID = req.UserID
-	models.Version = req.Version
-
-	if err := h.syncService.UpdateDisabledModels(&models, req.MachineID); err != nil {
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, types.APIResponse{
 			Success: false,
 			Error: &types.APIError{
 				Code:    http.StatusInternalServerError,
-				Message: "Failed to update disabled models",
+				Message: "Failed to get messages",
 				Details: err.Error(),
 			},
 		})
 		return
 	}
 
+	if middleware.IsMetadataOnly(c) {
+		for i := range result.Messages {
+			result.Messages[i] = result.Messages[i].Redacted()
+		}
+	}
+
 	c.JSON(http.StatusOK, types.APIResponse{
 		Success: true,
-		Data:    models,
+		Data:    result,
 	})
 }
 
-func (h *SyncHandler) GetAdvancedSettings(c *gin.Context) {
+func (h *SyncHandler) CreateMessage(c *gin.Context) {
 	userID, ok := middleware.GetUserID(c)
 	if !ok {
 		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+		})
+		return
+	}
+
+	// Get threadID from URL parameter or request body
+	threadIDStr, ok := threadIDQuery(c)
+	if !ok {
+		return
+	}
+
+	var message types.Message
+	if err := c.ShouldBindJSON(&message); err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
 			Success: false,
 			Error: &types.APIError{
-				Code:    http.StatusUnauthorized,
-				Message: "User not authenticated",
+				Code:    http.StatusBadRequest,
+				Message: "Invalid request format",
+				Details: err.Error(),
 			},
 		})
 		return
 	}
 
-	settings, err := h.syncService.GetAdvancedSettings(userID)
-	if err != nil {
-		c.JSON(http.StatusNotFound, types.APIResponse{
+	if !h.validateEncryptionVersion(c, &message.EncV) {
+		return
+	}
+
+	// Since the Message struct no longer has UserID, we don't set it
+	// The service will handle ID generation if needed
+	if message.ID != "" && !validMessageID(c, message.ID) {
+		return
+	}
+
+	if !h.requireActiveMachine(c, userID, middleware.GetMachineID(c)) {
+		return
+	}
+
+	if !h.requireThreadOwner(c, userID, threadIDStr) {
+		return
+	}
+
+	if err := h.syncService.CreateMessage(c.Request.Context(), userID, threadIDStr, &message, middleware.GetMachineID(c)); err != nil {
+		statusCode := http.StatusInternalServerError
+		if errors.Is(err, services.ErrMessageIDTaken) {
+			statusCode = http.StatusConflict
+		} else if errors.Is(err, services.ErrThreadMessageLimit) {
+			statusCode = http.StatusForbidden
+		}
+		c.JSON(statusCode, types.APIResponse{
 			Success: false,
 			Error: &types.APIError{
-				Code:    http.StatusNotFound,
-				Message: "Advanced settings not found",
+				Code:    statusCode,
+				Message: "Failed to create message",
+				Details: err.Error(),
 			},
 		})
 		return
 	}
 
-	c.JSON(http.StatusOK, types.APIResponse{
-		Success: true,
-		Data:    settings,
+	c.JSON(http.StatusCreated, types.APIResponse{
+		Success:  true,
+		Data:     message,
+		Warnings: h.quotaWarnings(c, userID),
 	})
 }
 
-func (h *SyncHandler) UpdateAdvancedSettings(c *gin.Context) {
+func (h *SyncHandler) UpdateMessage(c *gin.Context) {
 	userID, ok := middleware.GetUserID(c)
 	if !ok {
 		c.JSON(http.StatusUnauthorized, types.APIResponse{
 			Success: false,
-			Error: &types.APIError{
-				Code:    http.StatusUnauthorized,
-				Message: "User not authenticated",
-			},
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
 		})
 		return
 	}
 
-	var req types.AdvancedSettingsUpdateRequest
+	messageID := c.Param("id") // Now expecting string ID
+	if !validMessageID(c, messageID) {
+		return
+	}
+
+	var req types.MessageUpdateRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, types.APIResponse{
 			Success: false,
@@ -794,42 +1118,91 @@ func (h *SyncHandler) UpdateAdvancedSettings(c *gin.Context) {
 		return
 	}
 
-	// Validate machine ID is a valid UUIDv7
-	machineID, err := uuid.Parse(req.MachineID)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, types.APIResponse{
+	machineID, ok := machineIDFor(c, req.MachineID)
+	if !ok {
+		return
+	}
+
+	message := req.Data
+	if !h.validateEncryptionVersion(c, &message.EncV) {
+		return
+	}
+	message.ID = messageID
+	// Message versions are encrypted; the plaintext req.Version is what the server checks
+
+	threadIDStr := req.ThreadID.String() // Convert UUID to string for service call
+
+	if !h.requireActiveMachine(c, userID, machineID) {
+		return
+	}
+
+	if !h.requireThreadOwner(c, userID, threadIDStr) {
+		return
+	}
+
+	if err := h.syncService.UpdateMessage(c.Request.Context(), userID, threadIDStr, &message, req.Version, machineID); err != nil {
+		statusCode := http.StatusInternalServerError
+		errMessage := "Failed to update message"
+		var current interface{}
+		var conflict *services.MessageConflictError
+		if errors.As(err, &conflict) {
+			statusCode = http.StatusConflict
+			errMessage = "Message was changed"
+			if conflict.Current != nil {
+				current = conflict.Current
+			}
+		}
+		c.JSON(statusCode, types.APIResponse{
 			Success: false,
 			Error: &types.APIError{
-				Code:    http.StatusBadRequest,
-				Message: "Invalid machine ID format - must be a valid UUID",
+				Code:    statusCode,
+				Message: errMessage,
 				Details: err.Error(),
+				Current: current,
 			},
 		})
 		return
 	}
 
-	if err := types.ValidateUUIDv7(machineID); err != nil {
-		c.JSON(http.StatusBadRequest, types.APIResponse{
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success:  true,
+		Data:     message,
+		Warnings: h.quotaWarnings(c, userID),
+	})
+}
+
+func (h *SyncHandler) DeleteMessage(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
 			Success: false,
-			Error: &types.APIError{
-				Code:    http.StatusBadRequest,
-				Message: "Machine ID must be a valid UUIDv7",
-				Details: err.Error(),
-			},
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
 		})
 		return
 	}
 
-	settings := req.Data
-	settings.UserID = req.UserID
-	settings.Version = req.Version
+	// Parse required thread_id parameter
+	threadIDStr, ok := threadIDQuery(c)
+	if !ok {
+		return
+	}
+
+	messageID := c.Param("id") // Now expecting string ID
+
+	if !h.requireActiveMachine(c, userID, middleware.GetMachineID(c)) {
+		return
+	}
+
+	if !h.requireThreadOwner(c, userID, threadIDStr) {
+		return
+	}
 
-	if err := h.syncService.UpdateAdvancedSettings(&settings, req.MachineID); err != nil {
+	if err := h.syncService.DeleteMessage(c.Request.Context(), userID, threadIDStr, messageID, middleware.GetMachineID(c)); err != nil {
 		c.JSON(http.StatusInternalServerError, types.APIResponse{
 			Success: false,
 			Error: &types.APIError{
 				Code:    http.StatusInternalServerError,
-				Message: "Failed to update advanced settings",
+				Message: "Failed to delete message",
 				Details: err.Error(),
 			},
 		})
@@ -838,52 +1211,282 @@ func (h *SyncHandler) UpdateAdvancedSettings(c *gin.Context) {
 
 	c.JSON(http.StatusOK, types.APIResponse{
 		Success: true,
-		Data:    settings,
+		Data:    gin.H{"message": "Message deleted successfully"},
 	})
 }
 
-func (h *SyncHandler) GetChangesSince(c *gin.Context) {
+// GetSettingsRevisions returns when each settings resource last changed, so clients
+// only refetch the settings that actually changed
+func (h *SyncHandler) GetSettingsRevisions(c *gin.Context) {
 	userID, ok := middleware.GetUserID(c)
 	if !ok {
 		c.JSON(http.StatusUnauthorized, types.APIResponse{
 			Success: false,
-			Error: &types.APIError{
-				Code:    http.StatusUnauthorized,
-				Message: "User not authenticated",
-			},
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
 		})
 		return
 	}
 
-	timestampStr := c.Param("timestamp")
-	timestampInt, err := strconv.ParseInt(timestampStr, 10, 64)
+	revisions, err := h.syncService.GetSettingsRevisions(c.Request.Context(), userID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, types.APIResponse{
+		c.JSON(http.StatusInternalServerError, types.APIResponse{
 			Success: false,
 			Error: &types.APIError{
-				Code:    http.StatusBadRequest,
-				Message: "Invalid timestamp format",
+				Code:    http.StatusInternalServerError,
+				Message: "Failed to get settings revisions",
 				Details: err.Error(),
 			},
 		})
 		return
 	}
 
-	timestamp := time.UnixMilli(timestampInt)
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    revisions,
+	})
+}
+
+// GetSettings returns the handler answering reads of the settings document of resource
+func (h *SyncHandler) GetSettings(resource services.SettingsResource) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := middleware.GetUserID(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, types.APIResponse{
+				Success: false,
+				Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+			})
+			return
+		}
+
+		document, err := h.syncService.GetSettings(c.Request.Context(), resource.Name, userID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, types.APIResponse{
+				Success: false,
+				Error: &types.APIError{
+					Code:    http.StatusNotFound,
+					Message: resource.Label + " not found",
+				},
+			})
+			return
+		}
 
-	response, err := h.syncService.GetChangesSince(userID, timestamp)
+		fields := document.Fields()
+		if notModified(c, settingsETag(c, *fields.Version, *fields.UpdatedAt)) {
+			return
+		}
+
+		if middleware.IsMetadataOnly(c) {
+			document.Redact()
+		}
+
+		c.JSON(http.StatusOK, types.APIResponse{
+			Success: true,
+			Data:    document,
+		})
+	}
+}
+
+// UpdateSettings returns the handler answering writes of the settings document of resource
+func (h *SyncHandler) UpdateSettings(resource services.SettingsResource) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := middleware.GetUserID(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, types.APIResponse{
+				Success: false,
+				Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+			})
+			return
+		}
+
+		// The document is decoded into the type of the resource; a null document stays empty
+		req := types.SyncRequest[types.SettingsDocument]{Data: resource.New()}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, types.APIResponse{
+				Success: false,
+				Error: &types.APIError{
+					Code:    http.StatusBadRequest,
+					Message: "Invalid request format",
+					Details: err.Error(),
+				},
+			})
+			return
+		}
+		document := req.Data
+		if document == nil {
+			document = resource.New()
+		}
+
+		// Validate that the user ID in the request matches the authenticated user
+		if req.UserID != userID {
+			c.JSON(http.StatusForbidden, types.APIResponse{
+				Success: false,
+				Error: &types.APIError{
+					Code:    http.StatusForbidden,
+					Message: "User ID in request does not match authenticated user",
+				},
+			})
+			return
+		}
+
+		machineID, ok := machineIDFor(c, req.MachineID)
+		if !ok {
+			return
+		}
+
+		fields := document.Fields()
+		if !h.validateEncryptionVersion(c, fields.EncV) {
+			return
+		}
+		*fields.UserID = req.UserID
+		*fields.Version = req.Version
+
+		if !h.requireActiveMachine(c, userID, machineID) {
+			return
+		}
+
+		if !h.requireIfMatch(c, userID, resource.Name, "") {
+			return
+		}
+
+		resolution, err := h.syncService.UpdateSettings(c.Request.Context(), resource.Name, document, machineID)
+		if err != nil {
+			statusCode := http.StatusInternalServerError
+			message := "Failed to update " + strings.ToLower(resource.Label)
+			var current interface{}
+			var conflict *services.SettingsConflictError
+			switch {
+			case errors.As(err, &conflict):
+				statusCode = http.StatusConflict
+				message = "Settings were changed"
+				current = conflict.Current
+			case errors.Is(err, services.ErrSettingsDeleted):
+				statusCode = http.StatusConflict
+			}
+			c.JSON(statusCode, types.APIResponse{
+				Success: false,
+				Error: &types.APIError{
+					Code:    statusCode,
+					Message: message,
+					Details: err.Error(),
+					Current: current,
+				},
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, types.APIResponse{
+			Success:  true,
+			Data:     document,
+			Warnings: h.quotaWarnings(c, userID),
+			Conflict: resolution,
+		})
+	}
+}
+
+// DeleteSettings returns the handler clearing the settings document of resource on all devices
+func (h *SyncHandler) DeleteSettings(resource services.SettingsResource) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := middleware.GetUserID(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, types.APIResponse{
+				Success: false,
+				Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+			})
+			return
+		}
+
+		machineID := middleware.GetMachineID(c)
+
+		if !h.requireActiveMachine(c, userID, machineID) {
+			return
+		}
+
+		if err := h.syncService.DeleteSettings(c.Request.Context(), resource.Name, userID, machineID); err != nil {
+			statusCode := http.StatusInternalServerError
+			message := "Failed to delete " + strings.ToLower(resource.Label)
+			details := err.Error()
+			if errors.Is(err, services.ErrSettingsNotFound) {
+				statusCode = http.StatusNotFound
+				message = resource.Label + " not found"
+				details = ""
+			}
+			c.JSON(statusCode, types.APIResponse{
+				Success: false,
+				Error: &types.APIError{
+					Code:    statusCode,
+					Message: message,
+					Details: details,
+				},
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, types.APIResponse{
+			Success: true,
+			Data:    gin.H{"message": resource.Label + " deleted successfully"},
+		})
+	}
+}
+
+func (h *SyncHandler) GetChangesSince(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+		})
+		return
+	}
+
+	// The cursor is a sync_cursor from an earlier response; sync timestamps in unix milliseconds
+	// from older clients are still accepted, and 0 asks for a full sync
+	since := c.Param("cursor")
+	var err error
+
+	// Long polling: ?wait=30s holds the request open until something changes
+	var wait time.Duration
+	if raw := c.Query("wait"); raw != "" {
+		wait, err = time.ParseDuration(raw)
+		if seconds, atoiErr := strconv.Atoi(raw); atoiErr == nil {
+			wait, err = time.Duration(seconds)*time.Second, nil
+		}
+		if err != nil || wait < 0 || wait > services.ChangesWaitMax {
+			c.JSON(http.StatusBadRequest, types.APIResponse{
+				Success: false,
+				Error: &types.APIError{
+					Code:    http.StatusBadRequest,
+					Message: "Invalid wait",
+					Details: fmt.Sprintf("wait is a duration such as 30s, at most %s", services.ChangesWaitMax),
+				},
+			})
+			return
+		}
+	}
+
+	response, err := h.syncService.WaitForChanges(c.Request.Context(), userID, since, wait)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, types.APIResponse{
+		statusCode := http.StatusInternalServerError
+		message := "Failed to get changes"
+		if errors.Is(err, services.ErrInvalidCursor) {
+			statusCode = http.StatusBadRequest
+			message = "Invalid cursor"
+		}
+		c.JSON(statusCode, types.APIResponse{
 			Success: false,
 			Error: &types.APIError{
-				Code:    http.StatusInternalServerError,
-				Message: "Failed to get changes",
+				Code:    statusCode,
+				Message: message,
 				Details: err.Error(),
 			},
 		})
 		return
 	}
 
+	if middleware.IsMetadataOnly(c) {
+		redacted := response.Redacted()
+		response = &redacted
+	}
+
 	c.JSON(http.StatusOK, types.APIResponse{
 		Success: true,
 		Data:    response,
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/helioschat/sync/internal/i18n"
+	"github.com/helioschat/sync/internal/middleware"
+	"github.com/helioschat/sync/internal/services"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// totpError maps TOTP errors to API errors, falling back to status and message
+func totpError(c *gin.Context, err error, status int, message string) *types.APIError {
+	switch {
+	case errors.Is(err, services.ErrTOTPRequired):
+		return middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeTOTPRequired, "")
+	case errors.Is(err, services.ErrInvalidTOTP):
+		return middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeInvalidTOTP, "")
+	case errors.Is(err, services.ErrPassphraseConfirmation):
+		status = http.StatusUnauthorized
+	case errors.Is(err, services.ErrTOTPUnavailable):
+		status = http.StatusNotImplemented
+	case errors.Is(err, services.ErrTOTPAlreadyEnabled), errors.Is(err, services.ErrTOTPNotEnrolled):
+		status = http.StatusConflict
+	}
+	return &types.APIError{
+		Code:    status,
+		Message: message,
+		Details: err.Error(),
+	}
+}
+
+// EnrollTOTP generates a TOTP secret for the authenticated wallet, to be confirmed with ConfirmTOTP
+func (h *AuthHandler) EnrollTOTP(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+		})
+		return
+	}
+
+	var req struct {
+		Passphrase string `json:"passphrase" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid request format: passphrase is required",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	enrollment, err := h.AuthService.EnrollTOTP(c.Request.Context(), userID, req.Passphrase)
+	if err != nil {
+		apiErr := totpError(c, err, http.StatusInternalServerError, "Failed to enroll TOTP")
+		c.JSON(apiErr.Code, types.APIResponse{
+			Success: false,
+			Error:   apiErr,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    enrollment,
+	})
+}
+
+// ConfirmTOTP enables TOTP once the user submits a code from their authenticator
+func (h *AuthHandler) ConfirmTOTP(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+		})
+		return
+	}
+
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid request format: code is required",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	if err := h.AuthService.ConfirmTOTP(c.Request.Context(), userID, req.Code); err != nil {
+		apiErr := totpError(c, err, http.StatusInternalServerError, "Failed to confirm TOTP")
+		c.JSON(apiErr.Code, types.APIResponse{
+			Success: false,
+			Error:   apiErr,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    gin.H{"message": "TOTP enabled"},
+	})
+}
+
+// DisableTOTP turns the second factor off, confirmed with the passphrase and a current code
+func (h *AuthHandler) DisableTOTP(c *gin.Context) {
+	userID, ok := middleware.GetUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   middleware.LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+		})
+		return
+	}
+
+	var req struct {
+		Passphrase string `json:"passphrase" binding:"required"`
+		Code       string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid request format: passphrase and code are required",
+				Details: err.Error(),
+			},
+		})
+		return
+	}
+
+	if err := h.AuthService.DisableTOTP(c.Request.Context(), userID, req.Passphrase, req.Code); err != nil {
+		apiErr := totpError(c, err, http.StatusInternalServerError, "Failed to disable TOTP")
+		c.JSON(apiErr.Code, types.APIResponse{
+			Success: false,
+			Error:   apiErr,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    gin.H{"message": "TOTP disabled"},
+	})
+}
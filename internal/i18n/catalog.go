@@ -0,0 +1,305 @@
+package i18n
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Code is a stable machine-readable error code. Clients branch on codes; messages may change and
+// are translated.
+type Code string
+
+const (
+	CodeAuthRequired            Code = "auth_required"
+	CodeAuthHeaderRequired      Code = "auth_header_required"
+	CodeInvalidAuthHeader       Code = "invalid_auth_header"
+	CodeInvalidToken            Code = "invalid_token"
+	CodeGuestForbidden          Code = "guest_forbidden"
+	CodeInsufficientScope       Code = "insufficient_scope"
+	CodeAdminRequired           Code = "admin_required"
+	CodeAuthFailed              Code = "auth_failed"
+	CodeMachineSignedOut        Code = "machine_signed_out"
+	CodeMachineMismatch         Code = "machine_mismatch"
+	CodeLoginLocked             Code = "login_locked"
+	CodeWalletMerged            Code = "wallet_merged"
+	CodeDeletionNotAcknowledged Code = "deletion_not_acknowledged"
+	CodeTOTPRequired            Code = "totp_required"
+	CodeInvalidTOTP             Code = "invalid_totp"
+	CodeSignatureRequired       Code = "signature_required"
+	CodeInvalidSignature        Code = "invalid_signature"
+	CodeInvalidRefreshToken     Code = "invalid_refresh_token"
+	CodeInvalidRecoveryCode     Code = "invalid_recovery_code"
+	CodeWeakPassphrase          Code = "weak_passphrase"
+	CodeRateLimited             Code = "rate_limited"
+	CodeRegistrationThrottled   Code = "registration_throttled"
+	CodeStorageFull             Code = "storage_full"
+	CodeQuotaWarning            Code = "quota_warning"
+	CodeQuotaCritical           Code = "quota_critical"
+	CodeInvalidDurability       Code = "invalid_durability"
+	CodeWriteNotDurable         Code = "write_not_durable"
+	CodeOpaqueRequired          Code = "opaque_required"
+	CodeAttachmentQuota         Code = "attachment_quota_exceeded"
+)
+
+// DefaultLocale is used when the client accepts none of the translated locales
+const DefaultLocale = "en"
+
+// catalog holds the messages of every code per locale. Every code must have an English message;
+// format verbs are filled in by Message.
+var catalog = map[Code]map[string]string{
+	CodeAuthRequired: {
+		"en": "User not authenticated",
+		"de": "Benutzer ist nicht angemeldet",
+		"fr": "Utilisateur non authentifié",
+		"es": "Usuario no autenticado",
+	},
+	CodeAuthHeaderRequired: {
+		"en": "Authorization header required",
+		"de": "Authorization-Header erforderlich",
+		"fr": "En-tête d'autorisation requis",
+		"es": "Se requiere la cabecera de autorización",
+	},
+	CodeInvalidAuthHeader: {
+		"en": "Invalid authorization header format",
+		"de": "Ungültiges Format des Authorization-Headers",
+		"fr": "Format de l'en-tête d'autorisation invalide",
+		"es": "Formato de la cabecera de autorización no válido",
+	},
+	CodeInvalidToken: {
+		"en": "Invalid or expired token",
+		"de": "Ungültiges oder abgelaufenes Token",
+		"fr": "Jeton invalide ou expiré",
+		"es": "Token no válido o caducado",
+	},
+	CodeGuestForbidden: {
+		"en": "Guest token does not grant access to this resource",
+		"de": "Das Gast-Token gewährt keinen Zugriff auf diese Ressource",
+		"fr": "Le jeton invité ne donne pas accès à cette ressource",
+		"es": "El token de invitado no da acceso a este recurso",
+	},
+	CodeInsufficientScope: {
+		"en": "Token scope does not permit this request",
+		"de": "Der Geltungsbereich des Tokens erlaubt diese Anfrage nicht",
+		"fr": "La portée du jeton ne permet pas cette requête",
+		"es": "El alcance del token no permite esta solicitud",
+	},
+	CodeAdminRequired: {
+		"en": "Admin token required",
+		"de": "Admin-Token erforderlich",
+		"fr": "Jeton d'administration requis",
+		"es": "Se requiere un token de administrador",
+	},
+	CodeAuthFailed: {
+		"en": "Authentication failed",
+		"de": "Anmeldung fehlgeschlagen",
+		"fr": "Échec de l'authentification",
+		"es": "Error de autenticación",
+	},
+	CodeMachineSignedOut: {
+		"en": "Machine has been signed out",
+		"de": "Das Gerät wurde abgemeldet",
+		"fr": "L'appareil a été déconnecté",
+		"es": "Se ha cerrado la sesión del dispositivo",
+	},
+	CodeMachineMismatch: {
+		"en": "Token was issued to a different machine",
+		"de": "Das Token wurde für ein anderes Gerät ausgestellt",
+		"fr": "Le jeton a été émis pour un autre appareil",
+		"es": "El token se emitió para otro dispositivo",
+	},
+	CodeLoginLocked: {
+		"en": "Too many failed logins, please try again later",
+		"de": "Zu viele fehlgeschlagene Anmeldungen, bitte versuche es später erneut",
+		"fr": "Trop de tentatives de connexion échouées, veuillez réessayer plus tard",
+		"es": "Demasiados inicios de sesión fallidos, inténtalo de nuevo más tarde",
+	},
+	CodeWalletMerged: {
+		"en": "This wallet was merged into another wallet, please log in with that one",
+		"de": "Diese Wallet wurde mit einer anderen Wallet zusammengeführt, bitte melde dich mit dieser an",
+		"fr": "Ce portefeuille a été fusionné avec un autre portefeuille, veuillez vous connecter avec celui-ci",
+		"es": "Este monedero se ha fusionado con otro monedero, inicia sesión con ese",
+	},
+	CodeDeletionNotAcknowledged: {
+		"en": "Deleting the wallet erases all synced data; confirm with acknowledge_data_loss",
+		"de": "Das Löschen der Wallet löscht alle synchronisierten Daten; bestätige mit acknowledge_data_loss",
+		"fr": "La suppression du portefeuille efface toutes les données synchronisées ; confirmez avec acknowledge_data_loss",
+		"es": "Eliminar el monedero borra todos los datos sincronizados; confírmalo con acknowledge_data_loss",
+	},
+	CodeTOTPRequired: {
+		"en": "One-time code from your authenticator app required",
+		"de": "Einmalcode aus deiner Authenticator-App erforderlich",
+		"fr": "Code à usage unique de votre application d'authentification requis",
+		"es": "Se requiere el código de un solo uso de tu aplicación de autenticación",
+	},
+	CodeInvalidTOTP: {
+		"en": "Invalid or already used one-time code",
+		"de": "Ungültiger oder bereits verwendeter Einmalcode",
+		"fr": "Code à usage unique invalide ou déjà utilisé",
+		"es": "Código de un solo uso no válido o ya utilizado",
+	},
+	CodeSignatureRequired: {
+		"en": "Writes must be signed with the machine's signing secret",
+		"de": "Schreibzugriffe müssen mit dem Signaturschlüssel des Geräts signiert werden",
+		"fr": "Les écritures doivent être signées avec le secret de signature de l'appareil",
+		"es": "Las escrituras deben firmarse con el secreto de firma del dispositivo",
+	},
+	CodeInvalidSignature: {
+		"en": "Invalid request signature",
+		"de": "Ungültige Anfragesignatur",
+		"fr": "Signature de requête invalide",
+		"es": "Firma de solicitud no válida",
+	},
+	CodeInvalidRefreshToken: {
+		"en": "Invalid refresh token",
+		"de": "Ungültiges Refresh-Token",
+		"fr": "Jeton de rafraîchissement invalide",
+		"es": "Token de actualización no válido",
+	},
+	CodeInvalidRecoveryCode: {
+		"en": "Invalid user ID or recovery code",
+		"de": "Ungültige Benutzer-ID oder ungültiger Wiederherstellungscode",
+		"fr": "Identifiant utilisateur ou code de récupération invalide",
+		"es": "ID de usuario o código de recuperación no válido",
+	},
+	CodeWeakPassphrase: {
+		"en": "Passphrase is too weak",
+		"de": "Die Passphrase ist zu schwach",
+		"fr": "La phrase secrète est trop faible",
+		"es": "La frase de contraseña es demasiado débil",
+	},
+	CodeRateLimited: {
+		"en": "Too many requests, please try again later",
+		"de": "Zu viele Anfragen, bitte versuche es später erneut",
+		"fr": "Trop de requêtes, veuillez réessayer plus tard",
+		"es": "Demasiadas solicitudes, inténtalo de nuevo más tarde",
+	},
+	CodeRegistrationThrottled: {
+		"en": "Too many wallets were created from your network, please try again later",
+		"de": "Aus deinem Netzwerk wurden zu viele Wallets erstellt, bitte versuche es später erneut",
+		"fr": "Trop de portefeuilles ont été créés depuis votre réseau, veuillez réessayer plus tard",
+		"es": "Se han creado demasiados monederos desde tu red, inténtalo de nuevo más tarde",
+	},
+	CodeStorageFull: {
+		"en": "Server storage is temporarily full, please try again later",
+		"de": "Der Serverspeicher ist vorübergehend voll, bitte versuche es später erneut",
+		"fr": "Le stockage du serveur est temporairement plein, veuillez réessayer plus tard",
+		"es": "El almacenamiento del servidor está lleno temporalmente, inténtalo de nuevo más tarde",
+	},
+	CodeQuotaWarning: {
+		"en": "You are approaching this limit (%d of %d used)",
+		"de": "Du näherst dich diesem Limit (%d von %d genutzt)",
+		"fr": "Vous approchez de cette limite (%d sur %d utilisés)",
+		"es": "Te estás acercando a este límite (%d de %d usados)",
+	},
+	CodeQuotaCritical: {
+		"en": "You have almost reached this limit (%d of %d used)",
+		"de": "Du hast dieses Limit fast erreicht (%d von %d genutzt)",
+		"fr": "Vous avez presque atteint cette limite (%d sur %d utilisés)",
+		"es": "Casi has alcanzado este límite (%d de %d usados)",
+	},
+	CodeInvalidDurability: {
+		"en": "This server does not support the requested durability level",
+		"de": "Dieser Server unterstützt die angeforderte Dauerhaftigkeitsstufe nicht",
+		"fr": "Ce serveur ne prend pas en charge le niveau de durabilité demandé",
+		"es": "Este servidor no admite el nivel de durabilidad solicitado",
+	},
+	CodeWriteNotDurable: {
+		"en": "Your change was saved but could not be confirmed as durable, please try again",
+		"de": "Deine Änderung wurde gespeichert, konnte aber nicht als dauerhaft bestätigt werden, bitte versuche es erneut",
+		"fr": "Votre modification a été enregistrée mais sa durabilité n'a pas pu être confirmée, veuillez réessayer",
+		"es": "Tu cambio se guardó pero no se pudo confirmar que sea duradero, inténtalo de nuevo",
+	},
+	CodeOpaqueRequired: {
+		"en": "This wallet signs in without sending its passphrase, please update your app",
+		"de": "Diese Wallet meldet sich an, ohne ihre Passphrase zu senden, bitte aktualisiere deine App",
+		"fr": "Ce portefeuille se connecte sans envoyer sa phrase secrète, veuillez mettre à jour votre application",
+		"es": "Este monedero inicia sesión sin enviar su frase de contraseña, actualiza tu aplicación",
+	},
+	CodeAttachmentQuota: {
+		"en": "Your attachment storage is full, delete attachments you no longer need to upload more",
+		"de": "Dein Speicher für Anhänge ist voll, lösche nicht mehr benötigte Anhänge, um weitere hochzuladen",
+		"fr": "Votre espace de stockage des pièces jointes est plein, supprimez celles dont vous n'avez plus besoin pour en envoyer d'autres",
+		"es": "Tu almacenamiento de adjuntos está lleno, elimina los adjuntos que ya no necesites para subir más",
+	},
+}
+
+// locales are the locales every message is translated to
+var locales = []string{"en", "de", "fr", "es"}
+
+// Message returns the message of code in locale, falling back to English, formatted with args
+// Codes returns every code of the catalog, sorted
+func Codes() []Code {
+	codes := make([]Code, 0, len(catalog))
+	for code := range catalog {
+		codes = append(codes, code)
+	}
+	sort.Slice(codes, func(i, j int) bool {
+		return codes[i] < codes[j]
+	})
+	return codes
+}
+
+func Message(code Code, locale string, args ...interface{}) string {
+	messages, ok := catalog[code]
+	if !ok {
+		return string(code)
+	}
+	message, ok := messages[locale]
+	if !ok {
+		message = messages[DefaultLocale]
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(message, args...)
+	}
+	return message
+}
+
+// Negotiate picks the best translated locale for an Accept-Language header such as
+// "fr-CH, fr;q=0.9, en;q=0.8". Region subtags match their base language.
+func Negotiate(acceptLanguage string) string {
+	type candidate struct {
+		locale string
+		q      float64
+	}
+
+	var candidates []candidate
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if tag == "" {
+			continue
+		}
+
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q <= 0 {
+			continue
+		}
+
+		base, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		candidates = append(candidates, candidate{locale: base, q: q})
+	}
+
+	// Stable, so equally weighted languages keep the client's order
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].q > candidates[j].q
+	})
+	for _, c := range candidates {
+		if c.locale == "*" {
+			return DefaultLocale
+		}
+		for _, locale := range locales {
+			if c.locale == locale {
+				return locale
+			}
+		}
+	}
+	return DefaultLocale
+}
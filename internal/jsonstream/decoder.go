@@ -0,0 +1,147 @@
+// Package jsonstream walks large JSON documents value by value, so request bodies of any size can be
+// processed with memory bounded by their largest item rather than their total size.
+package jsonstream
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrItemTooLarge is returned when a single decoded value exceeds the decoder's item limit
+var ErrItemTooLarge = errors.New("JSON item too large")
+
+// Decoder reads a JSON document from a stream. Objects and arrays are walked with Object and Array;
+// the values inside are decoded one at a time with Decode or skipped with Skip.
+type Decoder struct {
+	dec     *json.Decoder
+	limiter *limitedReader
+	maxItem int64
+}
+
+// NewDecoder reads from r, refusing values larger than maxItemBytes (0 means no limit)
+func NewDecoder(r io.Reader, maxItemBytes int64) *Decoder {
+	limiter := &limitedReader{r: r, remaining: -1}
+	dec := json.NewDecoder(limiter)
+	dec.UseNumber()
+	return &Decoder{dec: dec, limiter: limiter, maxItem: maxItemBytes}
+}
+
+// Offset returns how many bytes of the document were consumed so far
+func (d *Decoder) Offset() int64 {
+	return d.dec.InputOffset()
+}
+
+// Object walks an object, calling fn with each key. fn has to consume the key's value.
+func (d *Decoder) Object(fn func(key string) error) error {
+	if err := d.expectDelim('{'); err != nil {
+		return err
+	}
+	for d.dec.More() {
+		token, err := d.token()
+		if err != nil {
+			return err
+		}
+		key, ok := token.(string)
+		if !ok {
+			return fmt.Errorf("invalid JSON: expected object key at offset %d", d.Offset())
+		}
+		if err := fn(key); err != nil {
+			return err
+		}
+	}
+	return d.expectDelim('}')
+}
+
+// Array walks an array, calling fn with the index of each element. fn has to consume the element.
+func (d *Decoder) Array(fn func(index int) error) error {
+	if err := d.expectDelim('['); err != nil {
+		return err
+	}
+	for i := 0; d.dec.More(); i++ {
+		if err := fn(i); err != nil {
+			return err
+		}
+	}
+	return d.expectDelim(']')
+}
+
+// Decode decodes the next value into v
+func (d *Decoder) Decode(v interface{}) error {
+	d.limit()
+	defer d.unlimit()
+	return d.wrap(d.dec.Decode(v))
+}
+
+// Skip consumes the next value without keeping it
+func (d *Decoder) Skip() error {
+	var raw json.RawMessage
+	return d.Decode(&raw)
+}
+
+func (d *Decoder) expectDelim(want json.Delim) error {
+	token, err := d.token()
+	if err != nil {
+		return err
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != want {
+		return fmt.Errorf("invalid JSON: expected %q at offset %d", want, d.Offset())
+	}
+	return nil
+}
+
+// token reads the next key or delimiter, bounded like values so huge keys can't be buffered either
+func (d *Decoder) token() (json.Token, error) {
+	d.limit()
+	defer d.unlimit()
+	token, err := d.dec.Token()
+	return token, d.wrap(err)
+}
+
+// limit bounds what the next value may read beyond what the decoder already buffered
+func (d *Decoder) limit() {
+	if d.maxItem > 0 {
+		d.limiter.remaining = d.maxItem
+	}
+}
+
+func (d *Decoder) unlimit() {
+	d.limiter.remaining = -1
+}
+
+func (d *Decoder) wrap(err error) error {
+	if err == nil || errors.Is(err, ErrItemTooLarge) {
+		return err
+	}
+	if d.limiter.exceeded {
+		return fmt.Errorf("%w: items may be at most %d bytes", ErrItemTooLarge, d.maxItem)
+	}
+	if errors.Is(err, io.EOF) {
+		return fmt.Errorf("invalid JSON: unexpected end of input at offset %d", d.Offset())
+	}
+	return fmt.Errorf("invalid JSON: %w", err)
+}
+
+// limitedReader fails reads once remaining is used up; a negative remaining doesn't limit
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+	exceeded  bool
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return l.r.Read(p)
+	}
+	if l.remaining == 0 {
+		l.exceeded = true
+		return 0, ErrItemTooLarge
+	}
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	return n, err
+}
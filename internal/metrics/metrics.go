@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Collector computes the current value of a metric at scrape time
+type Collector func(ctx context.Context) (float64, error)
+
+type metric struct {
+	name      string
+	help      string
+	kind      string // "gauge" or "counter"
+	collector Collector
+}
+
+// Registry holds the metrics exposed on the /metrics endpoint
+type Registry struct {
+	mu      sync.RWMutex
+	metrics []metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Gauge registers a metric whose value can go up and down
+func (r *Registry) Gauge(name, help string, collector Collector) {
+	r.register(metric{name: name, help: help, kind: "gauge", collector: collector})
+}
+
+// Counter registers a monotonically increasing metric
+func (r *Registry) Counter(name, help string, collector Collector) {
+	r.register(metric{name: name, help: help, kind: "counter", collector: collector})
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// Snapshot collects the current value of every metric, skipping those that fail
+func (r *Registry) Snapshot(ctx context.Context) map[string]float64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	values := make(map[string]float64, len(r.metrics))
+	for _, m := range r.metrics {
+		if value, err := m.collector(ctx); err == nil {
+			values[m.name] = value
+		}
+	}
+	return values
+}
+
+// Handler serves the metrics in the Prometheus text exposition format.
+// If token is non-empty, scrapers must send it as a bearer token.
+func (r *Registry) Handler(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token != "" && c.GetHeader("Authorization") != "Bearer "+token {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+
+		var b strings.Builder
+		for _, m := range r.metrics {
+			value, err := m.collector(c.Request.Context())
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(&b, "# HELP %s %s\n", m.name, m.help)
+			fmt.Fprintf(&b, "# TYPE %s %s\n", m.name, m.kind)
+			fmt.Fprintf(&b, "%s %g\n", m.name, value)
+		}
+
+		c.Data(http.StatusOK, "text/plain; version=0.0.4", []byte(b.String()))
+	}
+}
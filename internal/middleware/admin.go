@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/helioschat/sync/internal/i18n"
+	"github.com/helioschat/sync/internal/services"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// RequireAdmin validates admin tokens. Wallet tokens of any type are rejected.
+func RequireAdmin(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		authHeader := c.GetHeader("Authorization")
+		if authHeader == "" {
+			c.JSON(http.StatusUnauthorized, types.APIResponse{
+				Success: false,
+				Error:   LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthHeaderRequired, ""),
+			})
+			c.Abort()
+			return
+		}
+
+		tokenParts := strings.Split(authHeader, " ")
+		if len(tokenParts) != 2 || tokenParts[0] != "Bearer" {
+			c.JSON(http.StatusUnauthorized, types.APIResponse{
+				Success: false,
+				Error:   LocalizedError(c, http.StatusUnauthorized, i18n.CodeInvalidAuthHeader, ""),
+			})
+			c.Abort()
+			return
+		}
+
+		claims, err := authService.VerifyAdminToken(c.Request.Context(), tokenParts[1])
+		if errors.Is(err, services.ErrNotAdminToken) {
+			c.JSON(http.StatusForbidden, types.APIResponse{
+				Success: false,
+				Error:   LocalizedError(c, http.StatusForbidden, i18n.CodeAdminRequired, ""),
+			})
+			c.Abort()
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, types.APIResponse{
+				Success: false,
+				Error:   LocalizedError(c, http.StatusUnauthorized, i18n.CodeInvalidToken, err.Error()),
+			})
+			c.Abort()
+			return
+		}
+
+		c.Set("admin_claims", claims)
+		c.Next()
+	}
+}
+
+// GetAdminClaims extracts the validated admin token claims from gin context
+func GetAdminClaims(c *gin.Context) (*types.AdminClaims, bool) {
+	claims, exists := c.Get("admin_claims")
+	if !exists {
+		return nil, false
+	}
+
+	ac, ok := claims.(*types.AdminClaims)
+	return ac, ok
+}
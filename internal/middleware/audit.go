@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/helioschat/sync/internal/services"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// Audit records the authorization outcome of every request it wraps.
+// It must be registered before RequireAuth so rejected requests are recorded too.
+func Audit(auditService *services.AuditService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		decision := "allow"
+		status := c.Writer.Status()
+		if status == http.StatusUnauthorized || status == http.StatusForbidden {
+			decision = "deny"
+		}
+
+		entry := types.AuditEntry{
+			Timestamp:  time.Now(),
+			MachineID:  GetMachineID(c),
+			Method:     c.Request.Method,
+			Route:      c.FullPath(),
+			ResourceID: c.Param("id"),
+			Decision:   decision,
+			Status:     status,
+			ClientIP:   c.ClientIP(),
+		}
+		if entry.ResourceID == "" {
+			entry.ResourceID = c.Query("thread_id")
+		}
+		if userID, ok := GetUserID(c); ok {
+			entry.UserID = userID.String()
+		}
+		if claims, ok := GetTokenClaims(c); ok {
+			entry.TokenType = claims.Type
+			entry.TokenID = claims.TokenID
+		}
+		if claims, ok := GetAdminClaims(c); ok {
+			entry.TokenType = "admin"
+			entry.TokenID = claims.TokenID
+		}
+
+		// The response is already written; don't lose the entry if the client went away
+		auditService.Record(context.WithoutCancel(c.Request.Context()), entry)
+	}
+}
@@ -1,11 +1,14 @@
 package middleware
 
 import (
+	"log"
 	"net/http"
+	"slices"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/i18n"
 	"github.com/helioschat/sync/internal/services"
 	"github.com/helioschat/sync/internal/types"
 )
@@ -28,8 +31,9 @@ func CORS(allowedOrigins []string) gin.HandlerFunc {
 			c.Header("Access-Control-Allow-Origin", origin)
 		}
 
-		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
+		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, X-Machine-Id, X-Signature, X-Signature-Timestamp, If-None-Match, If-Match")
+		c.Header("Access-Control-Expose-Headers", "ETag")
 		c.Header("Access-Control-Allow-Credentials", "true")
 		c.Header("Access-Control-Max-Age", "86400")
 
@@ -42,6 +46,21 @@ func CORS(allowedOrigins []string) gin.HandlerFunc {
 	}
 }
 
+// WebSocketBearer lets browsers, which can't set headers on WebSocket handshakes, offer their
+// access token as the subprotocols "bearer, <token>". Put before RequireAuth; tokens in the URL
+// instead would end up in access logs.
+func WebSocketBearer() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetHeader("Authorization") == "" {
+			protocols := strings.Split(c.GetHeader("Sec-WebSocket-Protocol"), ",")
+			if len(protocols) == 2 && strings.TrimSpace(protocols[0]) == "bearer" {
+				c.Request.Header.Set("Authorization", "Bearer "+strings.TrimSpace(protocols[1]))
+			}
+		}
+		c.Next()
+	}
+}
+
 // RequireAuth middleware validates JWT tokens
 func RequireAuth(authService *services.AuthService) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -50,10 +69,7 @@ func RequireAuth(authService *services.AuthService) gin.HandlerFunc {
 		if authHeader == "" {
 			c.JSON(http.StatusUnauthorized, types.APIResponse{
 				Success: false,
-				Error: &types.APIError{
-					Code:    http.StatusUnauthorized,
-					Message: "Authorization header required",
-				},
+				Error:   LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthHeaderRequired, ""),
 			})
 			c.Abort()
 			return
@@ -64,10 +80,7 @@ func RequireAuth(authService *services.AuthService) gin.HandlerFunc {
 		if len(tokenParts) != 2 || tokenParts[0] != "Bearer" {
 			c.JSON(http.StatusUnauthorized, types.APIResponse{
 				Success: false,
-				Error: &types.APIError{
-					Code:    http.StatusUnauthorized,
-					Message: "Invalid authorization header format",
-				},
+				Error:   LocalizedError(c, http.StatusUnauthorized, i18n.CodeInvalidAuthHeader, ""),
 			})
 			c.Abort()
 			return
@@ -76,26 +89,140 @@ func RequireAuth(authService *services.AuthService) gin.HandlerFunc {
 		token := tokenParts[1]
 
 		// Validate token
-		userID, err := authService.ValidateToken(token)
+		claims, err := authService.VerifyToken(c.Request.Context(), token)
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, types.APIResponse{
 				Success: false,
-				Error: &types.APIError{
-					Code:    http.StatusUnauthorized,
-					Message: "Invalid or expired token",
-					Details: err.Error(),
-				},
+				Error:   LocalizedError(c, http.StatusUnauthorized, i18n.CodeInvalidToken, err.Error()),
+			})
+			c.Abort()
+			return
+		}
+
+		// Only access, guest and scoped tokens are bearer tokens; refresh tokens are only exchanged
+		switch claims.Type {
+		case "access", "guest", "scoped":
+		default:
+			c.JSON(http.StatusUnauthorized, types.APIResponse{
+				Success: false,
+				Error:   LocalizedError(c, http.StatusUnauthorized, i18n.CodeInvalidToken, "tokens of type \""+claims.Type+"\" can't authenticate requests"),
 			})
 			c.Abort()
 			return
 		}
 
-		// Set user ID in context
-		c.Set("user_id", userID)
+		// Guest tokens are read-only and limited to the resources they were minted for
+		if claims.Type == "guest" {
+			resource := guestResource(c.FullPath())
+			if c.Request.Method != http.MethodGet || resource == "" || !slices.Contains(claims.Resources, resource) {
+				c.JSON(http.StatusForbidden, types.APIResponse{
+					Success: false,
+					Error:   LocalizedError(c, http.StatusForbidden, i18n.CodeGuestForbidden, ""),
+				})
+				c.Abort()
+				return
+			}
+
+			// Shared tokens show their owner how often they were used
+			if err := authService.RecordGuestAccess(c.Request.Context(), claims); err != nil {
+				log.Printf("Warning: %v", err)
+			}
+		}
+
+		// Scoped tokens only reach the routes their scopes grant
+		if scope := RequiredScope(c.Request.Method, c.FullPath()); scope != "" && !claims.HasScope(scope) {
+			c.JSON(http.StatusForbidden, types.APIResponse{
+				Success: false,
+				Error:   LocalizedError(c, http.StatusForbidden, i18n.CodeInsufficientScope, "token lacks the "+scope+" scope"),
+			})
+			c.Abort()
+			return
+		}
+
+		// Tokens bound to a machine at login are only accepted from that machine, and writes are
+		// attributed to it whatever the request body says
+		if claims.MachineID != "" {
+			if header := c.GetHeader("X-Machine-Id"); header != "" && !strings.EqualFold(header, claims.MachineID) {
+				c.JSON(http.StatusForbidden, types.APIResponse{
+					Success: false,
+					Error:   LocalizedError(c, http.StatusForbidden, i18n.CodeMachineMismatch, ""),
+				})
+				c.Abort()
+				return
+			}
+			SetMachineID(c, claims.MachineID)
+		}
+
+		// Set user ID and token claims in context
+		c.Set("user_id", claims.UserID)
+		c.Set("token_claims", claims)
 		c.Next()
 	}
 }
 
+// RequiredScope maps a route to the token scope it requires
+func RequiredScope(method, route string) string {
+	switch {
+	case route == "/api/v1/auth/logout":
+		return "" // any token may end itself
+	case strings.HasPrefix(route, "/api/v1/admin/"):
+		return "" // admin tokens only, see RequireAdmin
+	case strings.HasPrefix(route, "/api/v1/auth/"):
+		return types.ScopeAdmin
+	case method == http.MethodGet || method == http.MethodHead:
+		return types.ScopeRead
+	}
+	return types.ScopeWrite
+}
+
+// guestResource maps a route to the resource name guest tokens are scoped by
+func guestResource(route string) string {
+	switch {
+	case strings.HasPrefix(route, "/api/v1/sync/threads"):
+		return "threads"
+	case strings.HasPrefix(route, "/api/v1/sync/messages"):
+		return "messages"
+	case strings.HasPrefix(route, "/api/v1/sync/settings-revisions"),
+		strings.HasPrefix(route, "/api/v1/sync/usage"):
+		return "settings"
+	case strings.HasPrefix(route, "/api/v1/sync/memories"):
+		return "memories"
+	case strings.HasPrefix(route, "/api/v1/sync/folders"):
+		return "folders"
+	case strings.HasPrefix(route, "/api/v1/sync/changes-since"):
+		return "changes"
+	}
+	for _, settings := range services.SettingsResources {
+		if strings.HasPrefix(route, "/api/v1/sync"+settings.Path()) {
+			return "settings"
+		}
+	}
+	return ""
+}
+
+// GetTokenClaims extracts the validated token claims from gin context
+func GetTokenClaims(c *gin.Context) (*types.TokenClaims, bool) {
+	claims, exists := c.Get("token_claims")
+	if !exists {
+		return nil, false
+	}
+
+	tc, ok := claims.(*types.TokenClaims)
+	return tc, ok
+}
+
+// IsMetadataOnly reports whether payload bodies must be redacted for this request
+func IsMetadataOnly(c *gin.Context) bool {
+	claims, ok := GetTokenClaims(c)
+	return ok && claims.Type == "guest" && claims.MetadataOnly
+}
+
+// IsGuest reports whether the request was authenticated with a guest token
+func IsGuest(c *gin.Context) bool {
+	claims, ok := GetTokenClaims(c)
+	return ok && claims.Type == "guest"
+}
+
 // GetUserID extracts user ID from gin context
 func GetUserID(c *gin.Context) (uuid.UUID, bool) {
 	userID, exists := c.Get("user_id")
@@ -106,3 +233,18 @@ func GetUserID(c *gin.Context) (uuid.UUID, bool) {
 	uid, ok := userID.(uuid.UUID)
 	return uid, ok
 }
+
+// SetMachineID records the machine making the request in gin context
+func SetMachineID(c *gin.Context, machineID string) {
+	c.Set("machine_id", machineID)
+}
+
+// GetMachineID extracts the machine ID from gin context, falling back to the X-Machine-Id header
+func GetMachineID(c *gin.Context) string {
+	if machineID, exists := c.Get("machine_id"); exists {
+		if id, ok := machineID.(string); ok {
+			return id
+		}
+	}
+	return c.GetHeader("X-Machine-Id")
+}
@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/helioschat/sync/internal/database"
+	"github.com/helioschat/sync/internal/i18n"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// Durability lets writes ask for a durability level with ?durability=. Writes at
+// database.DurabilityFlush are only answered once the backend flushed them; if it can't, the client
+// gets a 503 instead of the response and should retry the (idempotent) write. The level a request
+// got is returned in X-Durability.
+func Durability(db database.Backend, levels types.DurabilityLevels) gin.HandlerFunc {
+	flusher, _ := db.(database.Flusher)
+
+	return func(c *gin.Context) {
+		level := c.Query("durability")
+		if level == "" {
+			level = levels.Default
+		} else if !slices.Contains(levels.Supported, level) {
+			c.JSON(http.StatusBadRequest, types.APIResponse{
+				Success: false,
+				Error:   LocalizedError(c, http.StatusBadRequest, i18n.CodeInvalidDurability, fmt.Sprintf("supported: %s", strings.Join(levels.Supported, ", "))),
+			})
+			c.Abort()
+			return
+		}
+
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+		c.Header("X-Durability", level)
+		if level != database.DurabilityFlush || flusher == nil {
+			c.Next()
+			return
+		}
+
+		// Hold the response back until the writes it acknowledges are durable
+		writer := c.Writer
+		buffered := &durabilityWriter{ResponseWriter: writer}
+		c.Writer = buffered
+		c.Next()
+		c.Writer = writer
+
+		if status := writer.Status(); status < 300 {
+			if err := flusher.Flush(c.Request.Context()); err != nil {
+				c.Header("X-Durability", database.DurabilityAck)
+				c.JSON(http.StatusServiceUnavailable, types.APIResponse{
+					Success: false,
+					Error:   LocalizedError(c, http.StatusServiceUnavailable, i18n.CodeWriteNotDurable, err.Error()),
+				})
+				return
+			}
+		}
+		writer.WriteHeaderNow()
+		writer.Write(buffered.body.Bytes())
+	}
+}
+
+// durabilityWriter holds the response body back; the status and headers are recorded by the
+// wrapped writer but not sent
+type durabilityWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *durabilityWriter) Write(data []byte) (int, error) {
+	return w.body.Write(data)
+}
+
+func (w *durabilityWriter) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
+
+func (w *durabilityWriter) WriteHeaderNow() {}
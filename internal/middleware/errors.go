@@ -0,0 +1,14 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/helioschat/sync/internal/alerting"
+)
+
+// TrackErrors records the status of every response for error-rate alerts and metrics
+func TrackErrors(tracker *alerting.ErrorRate) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		tracker.Record(c.Writer.Status())
+	}
+}
@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/helioschat/sync/internal/i18n"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// GetLocale negotiates the locale of user-facing messages from the Accept-Language header
+func GetLocale(c *gin.Context) string {
+	if locale, exists := c.Get("locale"); exists {
+		if l, ok := locale.(string); ok {
+			return l
+		}
+	}
+
+	locale := i18n.Negotiate(c.GetHeader("Accept-Language"))
+	c.Set("locale", locale)
+	c.Header("Content-Language", locale)
+	c.Header("Vary", "Accept-Language")
+	return locale
+}
+
+// LocalizedError builds an API error carrying the stable code and its message in the client's locale
+func LocalizedError(c *gin.Context, status int, code i18n.Code, details string) *types.APIError {
+	return &types.APIError{
+		Code:      status,
+		ErrorCode: string(code),
+		Message:   i18n.Message(code, GetLocale(c)),
+		Details:   details,
+	}
+}
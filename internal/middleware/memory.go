@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/helioschat/sync/internal/database"
+	"github.com/helioschat/sync/internal/i18n"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// spaceFreeingRoutes are the POST and PUT routes that only delete data, allowed under memory pressure
+var spaceFreeingRoutes = []string{"/api/v1/sync/purge"}
+
+// RejectWritesUnderMemoryPressure refuses data-growing writes while Redis is close to its memory limit.
+// Reads and deletes are still allowed so users can free up space.
+func RejectWritesUnderMemoryPressure(monitor *database.MemoryMonitor) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if monitor == nil || !monitor.UnderPressure() {
+			c.Next()
+			return
+		}
+
+		write := c.Request.Method == http.MethodPost || c.Request.Method == http.MethodPut
+		if write && !slices.Contains(spaceFreeingRoutes, c.FullPath()) {
+			c.JSON(http.StatusInsufficientStorage, types.APIResponse{
+				Success: false,
+				Error:   LocalizedError(c, http.StatusInsufficientStorage, i18n.CodeStorageFull, ""),
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/helioschat/sync/internal/clock"
+	"github.com/helioschat/sync/internal/i18n"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// RateLimiter counts requests per caller in fixed windows. Counts are kept in memory, so
+// each server instance enforces its own limit.
+type RateLimiter struct {
+	limit  int
+	window time.Duration
+	clock  clock.Clock
+
+	mu      sync.Mutex
+	windows map[string]*rateWindow
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+// NewRateLimiter allows limit requests per caller in every window. A limit of 0 disables limiting.
+func NewRateLimiter(limit int, window time.Duration, clock clock.Clock) *RateLimiter {
+	return &RateLimiter{
+		limit:   limit,
+		window:  window,
+		clock:   clock,
+		windows: make(map[string]*rateWindow),
+	}
+}
+
+// Allow records a request of key and reports whether it is within the limit.
+// If not, it also returns how long until the caller may try again.
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	if l == nil || l.limit <= 0 {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	w, ok := l.windows[key]
+	if !ok || now.Sub(w.start) >= l.window {
+		// Forget finished windows of other callers while we hold the lock
+		for k, other := range l.windows {
+			if now.Sub(other.start) >= l.window {
+				delete(l.windows, k)
+			}
+		}
+		w = &rateWindow{start: now}
+		l.windows[key] = w
+	}
+
+	if w.count >= l.limit {
+		return false, w.start.Add(l.window).Sub(now)
+	}
+	w.count++
+	return true, 0
+}
+
+// RateLimit rejects callers exceeding limiter's limit with 429. Authenticated callers are
+// counted by user ID, anyone else by client IP.
+func RateLimit(limiter *RateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.ClientIP()
+		if userID, ok := GetUserID(c); ok {
+			key = userID.String()
+		}
+
+		allowed, retryAfter := limiter.Allow(key)
+		if !allowed {
+			c.Header("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+			c.JSON(http.StatusTooManyRequests, types.APIResponse{
+				Success: false,
+				Error:   LocalizedError(c, http.StatusTooManyRequests, i18n.CodeRateLimited, ""),
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
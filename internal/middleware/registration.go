@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/helioschat/sync/internal/i18n"
+	"github.com/helioschat/sync/internal/services"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// ThrottleRegistrations refuses wallet creations exceeding the registration policy with 429.
+// Only successful creations count against the limits.
+func ThrottleRegistrations(throttle *services.RegistrationThrottle) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ip := net.ParseIP(c.ClientIP())
+		if throttle == nil || ip == nil {
+			c.Next()
+			return
+		}
+
+		if err := throttle.Check(c.Request.Context(), ip); err != nil {
+			var throttled *services.ThrottledError
+			if !errors.As(err, &throttled) {
+				// A storage failure must not take registration down with it
+				log.Printf("Warning: registration throttle check failed: %v", err)
+				c.Next()
+				return
+			}
+
+			c.Header("Retry-After", strconv.Itoa(int(throttled.RetryAfter.Seconds())+1))
+			c.JSON(http.StatusTooManyRequests, types.APIResponse{
+				Success: false,
+				Error:   LocalizedError(c, http.StatusTooManyRequests, i18n.CodeRegistrationThrottled, throttled.Error()),
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+
+		if c.Writer.Status() >= 200 && c.Writer.Status() < 300 {
+			if err := throttle.Record(c.Request.Context(), ip); err != nil {
+				log.Printf("Warning: failed to record registration: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/helioschat/sync/internal/i18n"
+	"github.com/helioschat/sync/internal/services"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// VerifySignatures checks the X-Signature of writes made by the requesting machine (the one the token
+// is bound to, or X-Machine-Id) with its signing secret (see services.SignRequest). Signed writes are always verified; unsigned ones are only refused
+// when the instance requires signatures. Must run after RequireAuth.
+func VerifySignatures(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			c.Next()
+			return
+		}
+
+		signature := c.GetHeader("X-Signature")
+		if signature == "" && !authService.RequiresSignatures() {
+			c.Next()
+			return
+		}
+
+		userID, ok := GetUserID(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, types.APIResponse{
+				Success: false,
+				Error:   LocalizedError(c, http.StatusUnauthorized, i18n.CodeAuthRequired, ""),
+			})
+			c.Abort()
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, types.APIResponse{
+				Success: false,
+				Error: &types.APIError{
+					Code:    http.StatusBadRequest,
+					Message: "Failed to read request body",
+					Details: err.Error(),
+				},
+			})
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		machineID := GetMachineID(c)
+		err = authService.VerifyRequestSignature(c.Request.Context(), userID, machineID, c.GetHeader("X-Signature-Timestamp"), signature, c.Request.Method, c.Request.URL.RequestURI(), body)
+		if err != nil {
+			code := i18n.CodeInvalidSignature
+			switch {
+			case errors.Is(err, services.ErrSignatureRequired):
+				code = i18n.CodeSignatureRequired
+			case !errors.Is(err, services.ErrInvalidSignature):
+				c.JSON(http.StatusInternalServerError, types.APIResponse{
+					Success: false,
+					Error: &types.APIError{
+						Code:    http.StatusInternalServerError,
+						Message: "Failed to verify request signature",
+						Details: err.Error(),
+					},
+				})
+				c.Abort()
+				return
+			}
+			c.JSON(http.StatusUnauthorized, types.APIResponse{
+				Success: false,
+				Error:   LocalizedError(c, http.StatusUnauthorized, code, err.Error()),
+			})
+			c.Abort()
+			return
+		}
+
+		SetMachineID(c, machineID)
+		c.Next()
+	}
+}
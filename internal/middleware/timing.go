@@ -0,0 +1,23 @@
+package middleware
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PadResponseTime holds responses back until at least min has passed since the request came in, so
+// how fast a request fails doesn't tell whether the wallet it named exists. Responses are buffered
+// by net/http until the handler chain returns, so they leave after the padding.
+func PadResponseTime(min time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		if remaining := min - time.Since(start); remaining > 0 {
+			select {
+			case <-time.After(remaining):
+			case <-c.Request.Context().Done():
+			}
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/helioschat/sync/internal/services"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// Bodies larger than this are traced by size only
+const traceMaxBody = 1 << 20
+
+// traceFields are the body fields a trace keeps: server-visible protocol fields, never payloads
+var traceFields = map[string]bool{
+	"id":              true,
+	"user_id":         true,
+	"thread_id":       true,
+	"machine_id":      true,
+	"version":         true,
+	"enc_v":           true,
+	"resource":        true,
+	"operation":       true,
+	"sync_timestamp":  true,
+	"corrupted_count": true,
+	"created":         true,
+	"success":         true,
+}
+
+// TraceRequests records redacted envelopes of the requests of users who turned debug tracing on.
+// It runs after RequireAuth.
+func TraceRequests(tracer *services.DebugTracer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := GetUserID(c)
+		if !ok || !tracer.Enabled(c.Request.Context(), userID) {
+			c.Next()
+			return
+		}
+
+		start := time.Now()
+		var body []byte
+		if c.Request.Body != nil {
+			body, _ = io.ReadAll(c.Request.Body)
+			c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		writer := &traceWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+
+		c.Next()
+
+		entry := types.TraceEntry{
+			MachineID:  GetMachineID(c),
+			Method:     c.Request.Method,
+			Route:      c.FullPath(),
+			Params:     map[string]string{},
+			Status:     c.Writer.Status(),
+			DurationMs: time.Since(start).Milliseconds(),
+			BytesIn:    len(body),
+			BytesOut:   writer.size,
+			Request:    summarizeBody(body),
+			Response:   summarizeBody(writer.body.Bytes()),
+		}
+		for _, param := range c.Params {
+			entry.Params[param.Key] = param.Value
+		}
+		for key, values := range c.Request.URL.Query() {
+			entry.Params[key] = values[0]
+		}
+
+		var envelope types.APIResponse
+		if json.Unmarshal(writer.body.Bytes(), &envelope) == nil && envelope.Error != nil {
+			entry.ErrorCode = envelope.Error.ErrorCode
+			entry.Error = envelope.Error.Message
+		}
+		if response, ok := entry.Response["data"].(map[string]interface{}); ok {
+			entry.Response = response
+		}
+
+		// The response is already written; don't lose the entry if the client went away
+		tracer.Record(context.WithoutCancel(c.Request.Context()), userID, entry)
+	}
+}
+
+// traceWriter keeps a copy of the response body for the trace
+type traceWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+	size int
+}
+
+func (w *traceWriter) Write(data []byte) (int, error) {
+	w.size += len(data)
+	if w.body.Len()+len(data) <= traceMaxBody {
+		w.body.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *traceWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// summarizeBody reduces a JSON body to its traceFields and the lengths of its lists
+func summarizeBody(body []byte) map[string]interface{} {
+	if len(body) == 0 || len(body) > traceMaxBody {
+		return nil
+	}
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return nil
+	}
+	summary, _ := summarizeValue(value, 2).(map[string]interface{})
+	return summary
+}
+
+// summarizeValue descends depth levels of objects: the response envelope and its data, or a
+// request body and its direct children. Deeper objects are client documents and are skipped.
+func summarizeValue(value interface{}, depth int) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		summary := map[string]interface{}{}
+		for key, field := range v {
+			switch f := field.(type) {
+			case []interface{}:
+				summary[key+"_count"] = len(f)
+			case map[string]interface{}:
+				if depth <= 1 {
+					continue
+				}
+				if nested, ok := summarizeValue(f, depth-1).(map[string]interface{}); ok && len(nested) > 0 {
+					summary[key] = nested
+				}
+			default:
+				if traceFields[key] {
+					summary[key] = f
+				}
+			}
+		}
+		return summary
+	case []interface{}:
+		return map[string]interface{}{"count": len(v)}
+	}
+	return nil
+}
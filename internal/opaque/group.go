@@ -0,0 +1,166 @@
+package opaque
+
+import (
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math/big"
+)
+
+// P-256 group operations, with hash-to-curve (RFC 9380, P256_XMD:SHA-256_SSWU_RO_) and
+// hash-to-scalar as the P256-SHA256 OPRF (RFC 9497) uses them. Elements are SEC1 compressed points.
+
+var (
+	curve  = elliptic.P256()
+	params = curve.Params()
+)
+
+// Sizes of serialized elements and scalars
+const (
+	elementSize = 33
+	scalarSize  = 32
+)
+
+// fieldBytes is L of hash_to_field for P-256: ceil((ceil(log2(p)) + k) / 8) with k = 128
+const fieldBytes = 48
+
+type element struct {
+	x, y *big.Int
+}
+
+func (e *element) bytes() []byte {
+	return elliptic.MarshalCompressed(curve, e.x, e.y)
+}
+
+// decodeElement parses a compressed point, rejecting points not on the curve
+func decodeElement(data []byte) (*element, error) {
+	if len(data) != elementSize {
+		return nil, errors.New("invalid element length")
+	}
+	x, y := elliptic.UnmarshalCompressed(curve, data)
+	if x == nil {
+		return nil, errors.New("invalid element")
+	}
+	return &element{x: x, y: y}, nil
+}
+
+func scalarBytes(k *big.Int) []byte {
+	return k.FillBytes(make([]byte, scalarSize))
+}
+
+func scalarBaseMult(k *big.Int) *element {
+	x, y := curve.ScalarBaseMult(scalarBytes(k))
+	return &element{x: x, y: y}
+}
+
+func scalarMult(e *element, k *big.Int) *element {
+	x, y := curve.ScalarMult(e.x, e.y, scalarBytes(k))
+	return &element{x: x, y: y}
+}
+
+// expandMessageXMD is expand_message_xmd with SHA-256 (RFC 9380 section 5.3.1)
+func expandMessageXMD(msg, dst []byte, length int) []byte {
+	ell := (length + sha256.Size - 1) / sha256.Size
+	dstPrime := append(append([]byte{}, dst...), byte(len(dst)))
+
+	h := sha256.New()
+	h.Write(make([]byte, sha256.BlockSize))
+	h.Write(msg)
+	h.Write(binary.BigEndian.AppendUint16(nil, uint16(length)))
+	h.Write([]byte{0})
+	h.Write(dstPrime)
+	b0 := h.Sum(nil)
+
+	out := make([]byte, 0, ell*sha256.Size)
+	prev := make([]byte, sha256.Size)
+	for i := 1; i <= ell; i++ {
+		block := make([]byte, sha256.Size)
+		for j := range block {
+			block[j] = b0[j] ^ prev[j]
+		}
+		h.Reset()
+		h.Write(block)
+		h.Write([]byte{byte(i)})
+		h.Write(dstPrime)
+		prev = h.Sum(nil)
+		out = append(out, prev...)
+	}
+	return out[:length]
+}
+
+// hashToField hashes msg to count integers modulo modulus
+func hashToField(msg, dst []byte, count int, modulus *big.Int) []*big.Int {
+	uniform := expandMessageXMD(msg, dst, count*fieldBytes)
+	elements := make([]*big.Int, count)
+	for i := range elements {
+		e := new(big.Int).SetBytes(uniform[i*fieldBytes : (i+1)*fieldBytes])
+		elements[i] = e.Mod(e, modulus)
+	}
+	return elements
+}
+
+// hashToGroup hashes msg to a point of P-256 with the random oracle encoding
+func hashToGroup(msg, dst []byte) *element {
+	u := hashToField(msg, dst, 2, params.P)
+	q0 := mapToCurve(u[0])
+	q1 := mapToCurve(u[1])
+	x, y := curve.Add(q0.x, q0.y, q1.x, q1.y)
+	return &element{x: x, y: y} // P-256 has cofactor 1
+}
+
+// hashToScalar hashes msg to a scalar modulo the group order
+func hashToScalar(msg, dst []byte) *big.Int {
+	return hashToField(msg, dst, 1, params.N)[0]
+}
+
+// mapToCurve is the simplified SWU map for P-256 (RFC 9380 section 6.6.2, Z = -10)
+func mapToCurve(u *big.Int) *element {
+	p := params.P
+	mod := func(v *big.Int) *big.Int { return v.Mod(v, p) }
+	mul := func(a, b *big.Int) *big.Int { return mod(new(big.Int).Mul(a, b)) }
+	inv := func(a *big.Int) *big.Int { return new(big.Int).ModInverse(a, p) }
+	g := func(x *big.Int) *big.Int {
+		gx := mul(mul(x, x), x)
+		gx.Add(gx, mul(a, x))
+		gx.Add(gx, params.B)
+		return mod(gx)
+	}
+
+	zu2 := mul(z, mul(u, u))
+	tv1 := mod(new(big.Int).Add(mul(zu2, zu2), zu2))
+	var x1 *big.Int
+	if tv1.Sign() == 0 {
+		x1 = mul(params.B, inv(mul(z, a)))
+	} else {
+		negBOverA := mul(mod(new(big.Int).Neg(params.B)), inv(a))
+		x1 = mul(negBOverA, mod(new(big.Int).Add(big.NewInt(1), inv(tv1))))
+	}
+
+	x := x1
+	y, ok := sqrt(g(x1))
+	if !ok {
+		x = mul(zu2, x1)
+		y, _ = sqrt(g(x))
+	}
+	if u.Bit(0) != y.Bit(0) {
+		y = mod(new(big.Int).Neg(y))
+	}
+	return &element{x: x, y: y}
+}
+
+// Constants of the simplified SWU map: a = -3 and Z = -10 modulo p
+var (
+	a = new(big.Int).Sub(params.P, big.NewInt(3))
+	z = new(big.Int).Sub(params.P, big.NewInt(10))
+
+	// sqrtExponent is (p + 1) / 4; p = 3 mod 4
+	sqrtExponent = new(big.Int).Rsh(new(big.Int).Add(params.P, big.NewInt(1)), 2)
+)
+
+// sqrt returns a square root of v modulo p and whether v is a square
+func sqrt(v *big.Int) (*big.Int, bool) {
+	root := new(big.Int).Exp(v, sqrtExponent, params.P)
+	check := new(big.Int).Mul(root, root)
+	return root, check.Mod(check, params.P).Cmp(v) == 0
+}
@@ -0,0 +1,270 @@
+// Package opaque implements the server side of OPAQUE (RFC 9807) with the OPAQUE-3DH key exchange
+// and the P256-SHA256 OPRF, so clients can log in with a passphrase the server never sees. The key
+// stretching function is the client's choice and invisible to the server.
+package opaque
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// Sizes of the protocol values (RFC 9807 section 4 for P256-SHA256)
+const (
+	NonceSize     = 32                                    // Nn
+	hashSize      = sha256.Size                           // Nh, Nm and Nx
+	seedSize      = 32                                    // Nseed and Nok
+	EnvelopeSize  = NonceSize + hashSize                  // envelope nonce and auth tag
+	RecordSize    = elementSize + hashSize + EnvelopeSize // client public key, masking key, envelope
+	KE1Size       = elementSize + NonceSize + elementSize // credential request, client nonce and key share
+	KE2Size       = credentialResponseSize + NonceSize + elementSize + hashSize
+	KE3Size       = hashSize // client MAC
+	ServerKeySize = 32       // seed the server's keys are derived from
+
+	maskedResponseSize     = elementSize + EnvelopeSize
+	credentialResponseSize = elementSize + NonceSize + maskedResponseSize
+)
+
+// ErrInvalidMessage is returned for protocol messages that are malformed or don't verify
+var ErrInvalidMessage = errors.New("invalid OPAQUE message")
+
+// Server holds the long-term keys of an OPAQUE server: its key exchange key pair and the seed
+// per-client OPRF keys are derived from
+type Server struct {
+	privateKey *big.Int
+	publicKey  []byte
+	oprfSeed   []byte
+	context    []byte
+}
+
+// NewServer derives the server's keys from a ServerKeySize byte secret. context binds the key
+// exchanges to this application; clients have to use the same.
+func NewServer(secret []byte, context string) (*Server, error) {
+	if len(secret) != ServerKeySize {
+		return nil, fmt.Errorf("OPAQUE server key must be %d bytes, got %d", ServerKeySize, len(secret))
+	}
+	privateKey, publicKey, err := deriveDiffieHellmanKeyPair(expand(secret, []byte("ServerKeyPair"), seedSize))
+	if err != nil {
+		return nil, err
+	}
+	return &Server{
+		privateKey: privateKey,
+		publicKey:  publicKey.bytes(),
+		oprfSeed:   expand(secret, []byte("OprfSeed"), hashSize),
+		context:    []byte(context),
+	}, nil
+}
+
+// PublicKey returns the server's key exchange public key, which is also its identity
+func (s *Server) PublicKey() []byte {
+	return s.publicKey
+}
+
+// RegistrationResponse evaluates a client's registration request for credentialID
+func (s *Server) RegistrationResponse(request, credentialID []byte) ([]byte, error) {
+	blinded, err := decodeElement(request)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMessage, err)
+	}
+	key, err := s.oprfKey(credentialID)
+	if err != nil {
+		return nil, err
+	}
+	return append(blindEvaluate(key, blinded).bytes(), s.publicKey...), nil
+}
+
+// Record is what a client uploads at the end of a registration and a login is checked against
+type Record struct {
+	ClientPublicKey []byte
+	MaskingKey      []byte
+	Envelope        []byte
+}
+
+// ParseRecord parses a serialized registration record
+func ParseRecord(data []byte) (*Record, error) {
+	if len(data) != RecordSize {
+		return nil, fmt.Errorf("%w: record must be %d bytes", ErrInvalidMessage, RecordSize)
+	}
+	record := &Record{
+		ClientPublicKey: data[:elementSize],
+		MaskingKey:      data[elementSize : elementSize+hashSize],
+		Envelope:        data[elementSize+hashSize:],
+	}
+	if _, err := decodeElement(record.ClientPublicKey); err != nil {
+		return nil, fmt.Errorf("%w: client public key: %v", ErrInvalidMessage, err)
+	}
+	return record, nil
+}
+
+// Bytes serializes the record
+func (r *Record) Bytes() []byte {
+	return bytes.Join([][]byte{r.ClientPublicKey, r.MaskingKey, r.Envelope}, nil)
+}
+
+// FakeRecord returns a record for a credential ID nobody registered, so logins with it look like
+// logins of a registered client failing their passphrase. It is the same for every login attempt.
+func (s *Server) FakeRecord(credentialID []byte) (*Record, error) {
+	seed := expand(s.oprfSeed, append(append([]byte{}, credentialID...), "FakeRecord"...), seedSize+hashSize)
+	_, publicKey, err := deriveDiffieHellmanKeyPair(seed[:seedSize])
+	if err != nil {
+		return nil, err
+	}
+	return &Record{
+		ClientPublicKey: publicKey.bytes(),
+		MaskingKey:      seed[seedSize:],
+		Envelope:        make([]byte, EnvelopeSize),
+	}, nil
+}
+
+// LoginState is what the server keeps between sending KE2 and receiving KE3
+type LoginState struct {
+	ExpectedClientMAC []byte
+	SessionKey        []byte
+}
+
+// GenerateKE2 answers a client's KE1 for the registration record of credentialID
+func (s *Server) GenerateKE2(record *Record, credentialID, ke1 []byte) ([]byte, *LoginState, error) {
+	if len(ke1) != KE1Size {
+		return nil, nil, fmt.Errorf("%w: KE1 must be %d bytes", ErrInvalidMessage, KE1Size)
+	}
+	blinded, err := decodeElement(ke1[:elementSize])
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: credential request: %v", ErrInvalidMessage, err)
+	}
+	clientKeyShare, err := decodeElement(ke1[elementSize+NonceSize:])
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: client key share: %v", ErrInvalidMessage, err)
+	}
+	clientPublicKey, err := decodeElement(record.ClientPublicKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid record: %w", err)
+	}
+
+	// Credential response: the OPRF evaluation and the envelope, masked with the record's key
+	key, err := s.oprfKey(credentialID)
+	if err != nil {
+		return nil, nil, err
+	}
+	maskingNonce, err := randomBytes(NonceSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	pad := expand(record.MaskingKey, append(maskingNonce, "CredentialResponsePad"...), maskedResponseSize)
+	masked := append(append([]byte{}, s.publicKey...), record.Envelope...)
+	subtle.XORBytes(masked, masked, pad)
+	credentialResponse := bytes.Join([][]byte{blindEvaluate(key, blinded).bytes(), maskingNonce, masked}, nil)
+
+	// 3DH with a fresh key share
+	serverNonce, err := randomBytes(NonceSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyShareSeed, err := randomBytes(seedSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	keySharePrivate, keyShare, err := deriveDiffieHellmanKeyPair(keyShareSeed)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	preamble := bytes.Join([][]byte{
+		[]byte("OPAQUEv1-"),
+		lengthPrefixed(s.context),
+		lengthPrefixed(record.ClientPublicKey),
+		ke1,
+		lengthPrefixed(s.publicKey),
+		credentialResponse,
+		serverNonce,
+		keyShare.bytes(),
+	}, nil)
+	ikm := bytes.Join([][]byte{
+		scalarMult(clientKeyShare, keySharePrivate).bytes(),
+		scalarMult(clientKeyShare, s.privateKey).bytes(),
+		scalarMult(clientPublicKey, keySharePrivate).bytes(),
+	}, nil)
+	serverMACKey, clientMACKey, sessionKey := deriveKeys(ikm, preamble)
+
+	transcript := sha256.Sum256(preamble)
+	serverMAC := mac(serverMACKey, transcript[:])
+	clientTranscript := sha256.Sum256(append(preamble, serverMAC...))
+
+	ke2 := bytes.Join([][]byte{credentialResponse, serverNonce, keyShare.bytes(), serverMAC}, nil)
+	return ke2, &LoginState{
+		ExpectedClientMAC: mac(clientMACKey, clientTranscript[:]),
+		SessionKey:        sessionKey,
+	}, nil
+}
+
+// Finish verifies the client's KE3 and returns the session key both sides now share
+func (l *LoginState) Finish(ke3 []byte) ([]byte, error) {
+	if len(ke3) != KE3Size || !hmac.Equal(ke3, l.ExpectedClientMAC) {
+		return nil, fmt.Errorf("%w: client authentication failed", ErrInvalidMessage)
+	}
+	return l.SessionKey, nil
+}
+
+// oprfKey derives the OPRF key of a credential identifier
+func (s *Server) oprfKey(credentialID []byte) (*big.Int, error) {
+	seed := expand(s.oprfSeed, append(append([]byte{}, credentialID...), "OprfKey"...), seedSize)
+	key, _, err := deriveKeyPair(seed, []byte("OPAQUE-DeriveKeyPair"))
+	return key, err
+}
+
+func deriveDiffieHellmanKeyPair(seed []byte) (*big.Int, *element, error) {
+	return deriveKeyPair(seed, []byte("OPAQUE-DeriveDiffieHellmanKeyPair"))
+}
+
+// deriveKeys derives the MAC keys and the session key of a key exchange (RFC 9807 section 6.4.2)
+func deriveKeys(ikm, preamble []byte) (serverMACKey, clientMACKey, sessionKey []byte) {
+	prk := hkdf.Extract(sha256.New, ikm, nil)
+	transcript := sha256.Sum256(preamble)
+	handshakeSecret := expandLabel(prk, "HandshakeSecret", transcript[:])
+	sessionKey = expandLabel(prk, "SessionKey", transcript[:])
+	serverMACKey = expandLabel(handshakeSecret, "ServerMAC", nil)
+	clientMACKey = expandLabel(handshakeSecret, "ClientMAC", nil)
+	return serverMACKey, clientMACKey, sessionKey
+}
+
+// expandLabel is Expand-Label for outputs of hashSize bytes
+func expandLabel(secret []byte, label string, context []byte) []byte {
+	label = "OPAQUE-" + label
+	info := []byte{0, hashSize, byte(len(label))}
+	info = append(info, label...)
+	info = append(info, byte(len(context)))
+	info = append(info, context...)
+	return expand(secret, info, hashSize)
+}
+
+func expand(secret, info []byte, length int) []byte {
+	out := make([]byte, length)
+	if _, err := io.ReadFull(hkdf.Expand(sha256.New, secret, info), out); err != nil {
+		panic(err) // only fails for lengths over 255 hashes
+	}
+	return out
+}
+
+func mac(key, data []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// random is the source of nonces and key share seeds, fixed by the test vectors
+var random io.Reader = rand.Reader
+
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(random, b); err != nil {
+		return nil, fmt.Errorf("failed to generate random bytes: %w", err)
+	}
+	return b, nil
+}
@@ -0,0 +1,166 @@
+package opaque
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// OPAQUE-3DH P256-SHA256 test vector without client or server identities (RFC 9807 appendix C.1).
+// The client side is represented by its messages; the server side is run against them.
+var vector = struct {
+	context, oprfSeed, credentialIdentifier                       string
+	serverPrivateKey, serverPublicKey                             string
+	maskingNonce, serverNonce, serverKeyshareSeed                 string
+	oprfKey                                                       string
+	registrationRequest, registrationResponse, registrationUpload string
+	ke1, ke2, ke3, sessionKey                                     string
+}{
+	context:              "4f50415155452d504f43",
+	oprfSeed:             "62f60b286d20ce4fd1d64809b0021dad6ed5d52a2c8cf27ae6582543a0a8dce2",
+	credentialIdentifier: "31323334",
+	serverPrivateKey:     "c36139381df63bfc91c850db0b9cfbec7a62e86d80040a41aa7725bf0e79d5e5",
+	serverPublicKey:      "035f40ff9cf88aa1f5cd4fe5fd3da9ea65a4923a5594f84fd9f2092d6067784874",
+	maskingNonce:         "38fe59af0df2c79f57b8780278f5ae47355fe1f817119041951c80f612fdfc6d",
+	serverNonce:          "71cd9960ecef2fe0d0f7494986fa3d8b2bb01963537e60efb13981e138e3d4a1",
+	serverKeyshareSeed:   "05a4f54206eef1ba2f615bc0aa285cb22f26d1153b5b40a1e85ff80da12f982f",
+	oprfKey:              "2dfb5cb9aa1476093be74ca0d43e5b02862a05f5d6972614d7433acdc66f7f31",
+	registrationRequest:  "029e949a29cfa0bf7c1287333d2fb3dc586c41aa652f5070d26a5315a1b50229f8",
+	registrationResponse: "0350d3694c00978f00a5ce7cd08a00547e4ab5fb5fc2b2f6717cdaa6c89136efef035f40ff9cf88aa1f5cd4fe5fd3da9ea65a4923a5594f84fd9f2092d6067784874",
+	registrationUpload: "03b218507d978c3db570ca994aaf36695a731ddb2db272c817f79746fc37ae52147f0ed53532d3ae8e505ecc70d42d2b81" +
+		"4b6b0e48156def71ea029148b2803aafa921f2a014513bd8a90e477a629794e89fec12d12206dde662ebdcf65670e51fad30bbcfc1f8ed" +
+		"a0211553ab9aaf26345ad59a128e80188f035fe4924fad67b8",
+	ke1: "037342f0bcb3ecea754c1e67576c86aa90c1de3875f390ad599a26686cdfee6e07ab3d33bde0e93eda72392346a7a73051110674bbf6b1" +
+		"b7ffab8be4f91fdaeeb1022ed3f32f318f81bab80da321fecab3cd9b6eea11a95666dfa6beeaab321280b6",
+	ke2: "0246da9fe4d41d5ba69faa6c509a1d5bafd49a48615a47a8dd4b0823cc1476481138fe59af0df2c79f57b8780278f5ae47355fe1f8171190" +
+		"41951c80f612fdfc6d2f0c547f70deaeca54d878c14c1aa5e1ab405dec833777132eea905c2fbb12504a67dcbe0e66740c76b62c13b04a38" +
+		"a77926e19072953319ec65e41f9bfd2ae26837b6ce688bf9af2542f04eec9ab96a1b9328812dc2f5c89182ed47fead61f09f71cd9960ecef" +
+		"2fe0d0f7494986fa3d8b2bb01963537e60efb13981e138e3d4a103c1701353219b53acf337bf6456a83cefed8f563f1040b65afbf3b65d3b" +
+		"c9a19b50a73b145bc87a157e8c58c0342e2047ee22ae37b63db17e0a82a30fcc4ecf7b",
+	ke3:        "e97cab4433aa39d598e76f13e768bba61c682947bdcf9936035e8a3a3ebfb66e",
+	sessionKey: "484ad345715ccce138ca49e4ea362c6183f0949aaaa1125dc3bc3f80876e7cd1",
+}
+
+// vectorServer returns the server of the test vector. Its keys are set directly, since NewServer
+// derives them from a secret the vector doesn't have.
+func vectorServer(t *testing.T) *Server {
+	t.Helper()
+	return &Server{
+		privateKey: mustScalar(t, vector.serverPrivateKey),
+		publicKey:  mustHex(t, vector.serverPublicKey),
+		oprfSeed:   mustHex(t, vector.oprfSeed),
+		context:    mustHex(t, vector.context),
+	}
+}
+
+// fixRandom makes the server draw the vector's masking nonce, server nonce and key share seed
+func fixRandom(t *testing.T) {
+	t.Helper()
+	previous := random
+	random = bytes.NewReader(mustHex(t, vector.maskingNonce+vector.serverNonce+vector.serverKeyshareSeed))
+	t.Cleanup(func() { random = previous })
+}
+
+func TestOPAQUEVectorRegistration(t *testing.T) {
+	server := vectorServer(t)
+	credentialID := mustHex(t, vector.credentialIdentifier)
+
+	if got := scalarBaseMult(server.privateKey).bytes(); !bytes.Equal(got, server.publicKey) {
+		t.Fatalf("server_public_key = %x, want %x", got, server.publicKey)
+	}
+
+	key, err := server.oprfKey(credentialID)
+	if err != nil {
+		t.Fatalf("oprfKey: %v", err)
+	}
+	if got, want := scalarBytes(key), mustHex(t, vector.oprfKey); !bytes.Equal(got, want) {
+		t.Fatalf("oprf_key = %x, want %x", got, want)
+	}
+
+	response, err := server.RegistrationResponse(mustHex(t, vector.registrationRequest), credentialID)
+	if err != nil {
+		t.Fatalf("RegistrationResponse: %v", err)
+	}
+	if want := mustHex(t, vector.registrationResponse); !bytes.Equal(response, want) {
+		t.Fatalf("registration_response = %x, want %x", response, want)
+	}
+
+	record, err := ParseRecord(mustHex(t, vector.registrationUpload))
+	if err != nil {
+		t.Fatalf("ParseRecord: %v", err)
+	}
+	if got, want := record.Bytes(), mustHex(t, vector.registrationUpload); !bytes.Equal(got, want) {
+		t.Fatalf("record = %x, want %x", got, want)
+	}
+}
+
+func TestOPAQUEVectorLogin(t *testing.T) {
+	server := vectorServer(t)
+	fixRandom(t)
+
+	record, err := ParseRecord(mustHex(t, vector.registrationUpload))
+	if err != nil {
+		t.Fatalf("ParseRecord: %v", err)
+	}
+	ke2, state, err := server.GenerateKE2(record, mustHex(t, vector.credentialIdentifier), mustHex(t, vector.ke1))
+	if err != nil {
+		t.Fatalf("GenerateKE2: %v", err)
+	}
+	if want := mustHex(t, vector.ke2); !bytes.Equal(ke2, want) {
+		t.Fatalf("KE2 = %x, want %x", ke2, want)
+	}
+
+	sessionKey, err := state.Finish(mustHex(t, vector.ke3))
+	if err != nil {
+		t.Fatalf("Finish: %v", err)
+	}
+	if want := mustHex(t, vector.sessionKey); !bytes.Equal(sessionKey, want) {
+		t.Fatalf("session_key = %x, want %x", sessionKey, want)
+	}
+}
+
+func TestOPAQUERejectsWrongKE3(t *testing.T) {
+	server := vectorServer(t)
+	fixRandom(t)
+
+	record, err := ParseRecord(mustHex(t, vector.registrationUpload))
+	if err != nil {
+		t.Fatalf("ParseRecord: %v", err)
+	}
+	_, state, err := server.GenerateKE2(record, mustHex(t, vector.credentialIdentifier), mustHex(t, vector.ke1))
+	if err != nil {
+		t.Fatalf("GenerateKE2: %v", err)
+	}
+
+	ke3 := mustHex(t, vector.ke3)
+	ke3[0] ^= 1
+	for name, input := range map[string][]byte{"flipped bit": ke3, "truncated": ke3[:KE3Size-1], "empty": nil} {
+		if _, err := state.Finish(input); !errors.Is(err, ErrInvalidMessage) {
+			t.Errorf("%s: Finish error = %v, want ErrInvalidMessage", name, err)
+		}
+	}
+}
+
+func TestOPAQUERejectsMalformedMessages(t *testing.T) {
+	server := vectorServer(t)
+	credentialID := mustHex(t, vector.credentialIdentifier)
+	record, err := ParseRecord(mustHex(t, vector.registrationUpload))
+	if err != nil {
+		t.Fatalf("ParseRecord: %v", err)
+	}
+
+	if _, err := server.RegistrationResponse(make([]byte, elementSize), credentialID); !errors.Is(err, ErrInvalidMessage) {
+		t.Errorf("RegistrationResponse of a zero element: error = %v, want ErrInvalidMessage", err)
+	}
+	if _, err := ParseRecord(make([]byte, RecordSize-1)); !errors.Is(err, ErrInvalidMessage) {
+		t.Errorf("ParseRecord of a short record: error = %v, want ErrInvalidMessage", err)
+	}
+
+	ke1 := mustHex(t, vector.ke1)
+	invalidShare := append(append([]byte{}, ke1[:elementSize+NonceSize]...), make([]byte, elementSize)...)
+	for name, input := range map[string][]byte{"truncated": ke1[:KE1Size-1], "invalid key share": invalidShare} {
+		if _, _, err := server.GenerateKE2(record, credentialID, input); !errors.Is(err, ErrInvalidMessage) {
+			t.Errorf("%s KE1: error = %v, want ErrInvalidMessage", name, err)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package opaque
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math/big"
+)
+
+// oprfContext is the context string of the P256-SHA256 OPRF in base mode (RFC 9497 section 3.1)
+const oprfContext = "OPRFV1-\x00-P256-SHA256"
+
+// deriveKeyPair deterministically derives a key pair from seed and info (RFC 9497 section 3.2.1)
+func deriveKeyPair(seed, info []byte) (*big.Int, *element, error) {
+	deriveInput := append(append([]byte{}, seed...), lengthPrefixed(info)...)
+	dst := []byte("DeriveKeyPair" + oprfContext)
+	for counter := 0; counter < 256; counter++ {
+		sk := hashToScalar(append(deriveInput, byte(counter)), dst)
+		if sk.Sign() != 0 {
+			return sk, scalarBaseMult(sk), nil
+		}
+	}
+	return nil, nil, errors.New("failed to derive key pair")
+}
+
+// hashInput maps an OPRF input to the group
+func hashInput(input []byte) *element {
+	return hashToGroup(input, []byte("HashToGroup-"+oprfContext))
+}
+
+// blindEvaluate evaluates the OPRF with key on a blinded element sent by a client
+func blindEvaluate(key *big.Int, blinded *element) *element {
+	return scalarMult(blinded, key)
+}
+
+// finalize unblinds an evaluated element and hashes it to the OPRF output
+func finalize(input []byte, blind *big.Int, evaluated *element) []byte {
+	unblinded := scalarMult(evaluated, new(big.Int).ModInverse(blind, params.N)).bytes()
+
+	h := sha256.New()
+	h.Write(lengthPrefixed(input))
+	h.Write(lengthPrefixed(unblinded))
+	h.Write([]byte("Finalize"))
+	return h.Sum(nil)
+}
+
+// lengthPrefixed prefixes data with its length as a two byte big-endian integer
+func lengthPrefixed(data []byte) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(len(data))), data...)
+}
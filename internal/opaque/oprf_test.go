@@ -0,0 +1,93 @@
+package opaque
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/big"
+	"testing"
+)
+
+// mustHex decodes a hex test vector value
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("invalid hex %q: %v", s, err)
+	}
+	return b
+}
+
+// mustScalar decodes a hex test vector scalar
+func mustScalar(t *testing.T, s string) *big.Int {
+	t.Helper()
+	return new(big.Int).SetBytes(mustHex(t, s))
+}
+
+// P256-SHA256 OPRF mode test vectors (RFC 9497 appendix A.3.1)
+func TestOPRFVectors(t *testing.T) {
+	seed := mustHex(t, "a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3")
+	keyInfo := mustHex(t, "74657374206b6579")
+	skSm := mustHex(t, "159749d750713afe245d2d39ccfaae8381c53ce92d098a9375ee70739c7ac0bf")
+
+	key, _, err := deriveKeyPair(seed, keyInfo)
+	if err != nil {
+		t.Fatalf("deriveKeyPair: %v", err)
+	}
+	if got := scalarBytes(key); !bytes.Equal(got, skSm) {
+		t.Fatalf("skSm = %x, want %x", got, skSm)
+	}
+
+	vectors := []struct {
+		input, blind, blindedElement, evaluationElement, output string
+	}{
+		{
+			input:             "00",
+			blind:             "3338fa65ec36e0290022b48eb562889d89dbfa691d1cde91517fa222ed7ad364",
+			blindedElement:    "03723a1e5c09b8b9c18d1dcbca29e8007e95f14f4732d9346d490ffc195110368d",
+			evaluationElement: "030de02ffec47a1fd53efcdd1c6faf5bdc270912b8749e783c7ca75bb412958832",
+			output:            "a0b34de5fa4c5b6da07e72af73cc507cceeb48981b97b7285fc375345fe495dd",
+		},
+		{
+			input:             "5a5a5a5a5a5a5a5a5a5a5a5a5a5a5a5a5a",
+			blind:             "3338fa65ec36e0290022b48eb562889d89dbfa691d1cde91517fa222ed7ad364",
+			blindedElement:    "03cc1df781f1c2240a64d1c297b3f3d16262ef5d4cf102734882675c26231b0838",
+			evaluationElement: "03a0395fe3828f2476ffcd1f4fe540e5a8489322d398be3c4e5a869db7fcb7c52c",
+			output:            "c748ca6dd327f0ce85f4ae3a8cd6d4d5390bbb804c9e12dcf94f853fece3dcce",
+		},
+	}
+
+	for i, v := range vectors {
+		input := mustHex(t, v.input)
+		blind := mustScalar(t, v.blind)
+
+		blinded := scalarMult(hashInput(input), blind)
+		if got, want := blinded.bytes(), mustHex(t, v.blindedElement); !bytes.Equal(got, want) {
+			t.Errorf("vector %d: BlindedElement = %x, want %x", i+1, got, want)
+			continue
+		}
+
+		evaluated := blindEvaluate(key, blinded)
+		if got, want := evaluated.bytes(), mustHex(t, v.evaluationElement); !bytes.Equal(got, want) {
+			t.Errorf("vector %d: EvaluationElement = %x, want %x", i+1, got, want)
+			continue
+		}
+
+		if got, want := finalize(input, blind, evaluated), mustHex(t, v.output); !bytes.Equal(got, want) {
+			t.Errorf("vector %d: Output = %x, want %x", i+1, got, want)
+		}
+	}
+}
+
+func TestDecodeElementRejectsInvalidPoints(t *testing.T) {
+	inputs := map[string]string{
+		"empty":        "",
+		"too short":    "03723a1e5c09b8b9c18d1dcbca29e8007e95f14f4732d9346d490ffc19511036",
+		"uncompressed": "04723a1e5c09b8b9c18d1dcbca29e8007e95f14f4732d9346d490ffc195110368d",
+		"x beyond p":   "02ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff",
+	}
+	for name, input := range inputs {
+		if _, err := decodeElement(mustHex(t, input)); err == nil {
+			t.Errorf("%s: decodeElement accepted %q", name, input)
+		}
+	}
+}
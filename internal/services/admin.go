@@ -0,0 +1,95 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/database"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// adminTokenType is the type claim of admin tokens. They carry no user_id, so they are rejected
+// everywhere outside the admin API.
+const adminTokenType = "admin"
+
+// Admin tokens are issued from the command line for a limited time
+const (
+	DefaultAdminTokenTTL = 24 * time.Hour
+	MaxAdminTokenTTL     = 30 * 24 * time.Hour
+)
+
+var (
+	// ErrNotAdminToken is returned for valid tokens that don't grant admin access
+	ErrNotAdminToken = errors.New("not an admin token")
+	// ErrInvalidAdminTokenTTL is returned for admin token lifetimes outside (0, MaxAdminTokenTTL]
+	ErrInvalidAdminTokenTTL = errors.New("invalid admin token lifetime")
+)
+
+// IssueAdminToken issues an admin token to the operator name, valid for ttl
+func (s *AuthService) IssueAdminToken(name string, ttl time.Duration) (string, error) {
+	if name == "" {
+		return "", errors.New("admin token name is required")
+	}
+	if ttl <= 0 || ttl > MaxAdminTokenTTL {
+		return "", fmt.Errorf("%w: %s (maximum %s)", ErrInvalidAdminTokenTTL, ttl, MaxAdminTokenTTL)
+	}
+
+	now := s.clock.Now()
+	return s.signToken(jwt.MapClaims{
+		"sub":  name,
+		"type": adminTokenType,
+		"jti":  uuid.New().String(),
+		"exp":  now.Add(ttl).Unix(),
+		"iat":  now.Unix(),
+	})
+}
+
+// VerifyAdminToken validates an admin token and rejects revoked ones
+func (s *AuthService) VerifyAdminToken(ctx context.Context, tokenString string) (*types.AdminClaims, error) {
+	token, err := s.signer.Parse(tokenString, jwt.WithIssuer(s.issuer), jwt.WithAudience(s.issuer), jwt.WithTimeFunc(s.clock.Now))
+	if err != nil {
+		return nil, err
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid token claims")
+	}
+	if tokenType, _ := claims["type"].(string); tokenType != adminTokenType {
+		return nil, ErrNotAdminToken
+	}
+
+	result := &types.AdminClaims{}
+	result.Name, _ = claims["sub"].(string)
+	result.TokenID, _ = claims["jti"].(string)
+	if result.Name == "" || result.TokenID == "" {
+		return nil, errors.New("admin token lacks sub or jti")
+	}
+	if iat, err := claims.GetIssuedAt(); err == nil && iat != nil {
+		result.IssuedAt = iat.Time
+	}
+	if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+		result.ExpiresAt = exp.Time
+	}
+
+	_, err = s.db.Get(ctx, revokedTokenKey(result.TokenID))
+	if err == nil {
+		return nil, errors.New("token has been revoked")
+	}
+	if !database.IsNotFound(err) {
+		return nil, fmt.Errorf("failed to check token revocation: %w", err)
+	}
+	return result, nil
+}
+
+// RevokeAdminToken denylists an admin token until it would have expired anyway
+func (s *AuthService) RevokeAdminToken(ctx context.Context, claims *types.AdminClaims) error {
+	ttl := int64(claims.ExpiresAt.Sub(s.clock.Now()).Seconds()) + 1
+	if ttl <= 0 {
+		return nil
+	}
+	return s.db.Set(ctx, revokedTokenKey(claims.TokenID), claims.Name, ttl)
+}
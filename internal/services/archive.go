@@ -0,0 +1,401 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/blobstore"
+	"github.com/helioschat/sync/internal/clock"
+	"github.com/helioschat/sync/internal/database"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// ArchiveService moves cold threads to a cheaper object store and restores them on access
+type ArchiveService struct {
+	db          database.Backend
+	store       blobstore.Store
+	afterMonths int
+	jobs        *JobCoordinator
+	clock       clock.Clock
+
+	mu      sync.Mutex
+	lastErr error // error of the most recent archival run, nil if it succeeded
+}
+
+// archiveStub is stored in Redis in place of an archived thread's messages
+type archiveStub struct {
+	UserID     uuid.UUID `json:"user_id"`
+	ObjectKey  string    `json:"object_key"`
+	ArchivedAt time.Time `json:"archived_at"`
+}
+
+// archivedThread is the object written to the archival store
+type archivedThread struct {
+	Thread   json.RawMessage   `json:"thread"`
+	Messages map[string]string `json:"messages"` // message ID -> stored message JSON
+}
+
+func NewArchiveService(db database.Backend, store blobstore.Store, afterMonths int, jobs *JobCoordinator, clock clock.Clock) *ArchiveService {
+	a := &ArchiveService{
+		db:          db,
+		store:       store,
+		afterMonths: afterMonths,
+		jobs:        jobs,
+		clock:       clock,
+	}
+	jobs.OnRecover(OpThreadArchival, a.recoverArchival)
+	return a
+}
+
+// Run archives cold threads every interval until the process exits. Only one instance archives at a time.
+func (a *ArchiveService) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		archived, err := a.runArchival(context.Background(), interval)
+		a.mu.Lock()
+		a.lastErr = err
+		a.mu.Unlock()
+		if err != nil {
+			fmt.Printf("Warning: thread archival run failed: %v\n", err)
+		} else if archived > 0 {
+			fmt.Printf("Archived %d cold threads\n", archived)
+		}
+		<-ticker.C
+	}
+}
+
+// LastRunError returns the error of the most recent scheduled archival run, or nil if it succeeded
+func (a *ArchiveService) LastRunError() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.lastErr
+}
+
+func (a *ArchiveService) runArchival(ctx context.Context, interval time.Duration) (int, error) {
+	acquired, err := a.jobs.AcquireLease(ctx, "archival", interval)
+	if err != nil || !acquired {
+		return 0, err
+	}
+	defer a.jobs.ReleaseLease(ctx, "archival")
+	return a.ArchiveColdThreads(ctx)
+}
+
+// ArchiveColdThreads archives every thread that has not been touched for the configured number of months
+func (a *ArchiveService) ArchiveColdThreads(ctx context.Context) (int, error) {
+	cutoff := a.clock.Now().AddDate(0, -a.afterMonths, 0)
+
+	indexKeys, err := a.db.Keys(ctx, "timestamps:threads:*")
+	if err != nil {
+		return 0, fmt.Errorf("failed to get thread timestamp indexes: %w", err)
+	}
+
+	archived := 0
+	for _, indexKey := range indexKeys {
+		userID, err := uuid.Parse(strings.TrimPrefix(indexKey, "timestamps:threads:"))
+		if err != nil {
+			continue
+		}
+
+		// Thread versions are millisecond timestamps of the last change
+		threadIDs, err := a.db.ZRangeByScore(ctx, indexKey, "-inf", fmt.Sprintf("%d", cutoff.UnixMilli()))
+		if err != nil {
+			fmt.Printf("Warning: failed to get cold threads for user %s: %v\n", userID, err)
+			continue
+		}
+
+		for _, threadIDStr := range threadIDs {
+			threadID, err := uuid.Parse(threadIDStr)
+			if err != nil {
+				continue
+			}
+
+			ok, err := a.archiveThread(ctx, userID, threadID)
+			if err != nil {
+				fmt.Printf("Warning: failed to archive thread %s: %v\n", threadID, err)
+				continue
+			}
+			if ok {
+				archived++
+			}
+		}
+	}
+
+	return archived, nil
+}
+
+// errArchiveChanged aborts the archival of a thread written to while it was uploaded
+var errArchiveChanged = errors.New("thread changed during archival")
+
+// archiveThread uploads a thread and its messages to the archival store and replaces the messages
+// in Redis with a stub. It returns false if there was nothing to do. The stub is only saved if the
+// thread and its messages are still what was uploaded, so writes made meanwhile aren't lost; the
+// thread is archived on a later run instead.
+func (a *ArchiveService) archiveThread(ctx context.Context, userID, threadID uuid.UUID) (bool, error) {
+	stubKey := fmt.Sprintf("archived_threads:%s", threadID.String())
+	if _, err := a.db.Get(ctx, stubKey); err == nil {
+		return false, nil // already archived
+	}
+
+	threadKey := fmt.Sprintf("threads:%s:%s", userID.String(), threadID.String())
+	threadData, err := a.db.Get(ctx, threadKey)
+	if err != nil {
+		return false, nil // thread vanished since it was indexed
+	}
+
+	messages, err := a.db.HGetAll(ctx, messagesKey(threadID.String()))
+	if err != nil {
+		return false, fmt.Errorf("failed to get messages: %w", err)
+	}
+
+	bundle := archivedThread{
+		Thread:   json.RawMessage(threadData),
+		Messages: messages,
+	}
+
+	payload, err := json.Marshal(bundle)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal archive bundle: %w", err)
+	}
+
+	op := &PendingOperation{Kind: OpThreadArchival, UserID: userID, ThreadID: threadID.String()}
+	if err := a.jobs.Begin(ctx, op); err != nil {
+		return false, err
+	}
+	defer a.jobs.Finish(ctx, op)
+
+	objectKey := archiveObjectKey(userID, threadID.String())
+	if err := a.store.Put(ctx, objectKey, payload); err != nil {
+		return false, err
+	}
+
+	stub, err := json.Marshal(archiveStub{
+		UserID:     userID,
+		ObjectKey:  objectKey,
+		ArchivedAt: a.clock.Now(),
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal archive stub: %w", err)
+	}
+
+	err = a.db.Atomic(ctx, func(tx database.Backend) error {
+		if _, err := tx.Get(ctx, stubKey); err == nil {
+			return errArchiveChanged
+		} else if !database.IsNotFound(err) {
+			return fmt.Errorf("failed to get archive stub: %w", err)
+		}
+		current, err := tx.Get(ctx, threadKey)
+		if err != nil {
+			if database.IsNotFound(err) {
+				return errArchiveChanged
+			}
+			return fmt.Errorf("failed to get thread: %w", err)
+		}
+		currentMessages, err := tx.HGetAll(ctx, messagesKey(threadID.String()))
+		if err != nil {
+			return fmt.Errorf("failed to get messages: %w", err)
+		}
+		if current != threadData || !sameMessages(currentMessages, messages) {
+			return errArchiveChanged
+		}
+
+		if err := tx.Set(ctx, stubKey, string(stub), 0); err != nil {
+			return fmt.Errorf("failed to save archive stub: %w", err)
+		}
+		if err := setArchivedFlag(ctx, tx, threadKey, true); err != nil {
+			return err
+		}
+		if err := tx.Del(ctx, messagesKey(threadID.String())); err != nil {
+			return fmt.Errorf("failed to delete archived messages: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		if err := a.store.Delete(ctx, objectKey); err != nil {
+			fmt.Printf("Warning: failed to delete archived object %s: %v\n", objectKey, err)
+		}
+		if errors.Is(err, errArchiveChanged) || errors.Is(err, database.ErrConcurrentUpdate) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// sameMessages reports whether two snapshots of a thread's messages hold the same messages
+func sameMessages(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for id, data := range a {
+		if other, ok := b[id]; !ok || other != data {
+			return false
+		}
+	}
+	return true
+}
+
+// setArchivedFlag sets whether the stored thread at threadKey is flagged as held remotely, so
+// listings can show it, keeping the rest of the thread as it is
+func setArchivedFlag(ctx context.Context, tx database.Backend, threadKey string, archived bool) error {
+	threadData, err := tx.Get(ctx, threadKey)
+	if err != nil {
+		return fmt.Errorf("failed to get thread: %w", err)
+	}
+	var thread types.Thread
+	if err := json.Unmarshal([]byte(threadData), &thread); err != nil {
+		return fmt.Errorf("failed to unmarshal thread: %w", err)
+	}
+	thread.ArchivedRemote = archived
+	data, err := json.Marshal(thread)
+	if err != nil {
+		return fmt.Errorf("failed to marshal thread: %w", err)
+	}
+	if err := tx.Set(ctx, threadKey, string(data), 0); err != nil {
+		return fmt.Errorf("failed to flag thread as archived: %w", err)
+	}
+	return nil
+}
+
+// recoverArchival deletes the object an interrupted archival may have uploaded, unless its stub
+// was saved; the stub is saved together with the flag and the deletion of the messages, so the
+// archival is then complete. The thread is archived again on a later run.
+func (a *ArchiveService) recoverArchival(ctx context.Context, op *PendingOperation) error {
+	stubKey := fmt.Sprintf("archived_threads:%s", op.ThreadID)
+	if _, err := a.db.Get(ctx, stubKey); err != nil {
+		if !database.IsNotFound(err) {
+			return fmt.Errorf("failed to get archive stub: %w", err)
+		}
+		return a.store.Delete(ctx, archiveObjectKey(op.UserID, op.ThreadID))
+	}
+	return nil
+}
+
+func archiveObjectKey(userID uuid.UUID, threadID string) string {
+	return fmt.Sprintf("threads/%s/%s.json", userID.String(), threadID)
+}
+
+// Rehydrate restores an archived thread's messages into Redis.
+// It is a no-op for threads that are not archived.
+func (a *ArchiveService) Rehydrate(ctx context.Context, threadID string) error {
+	stubKey := fmt.Sprintf("archived_threads:%s", threadID)
+	stubData, err := a.db.Get(ctx, stubKey)
+	if err != nil {
+		if database.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to get archive stub: %w", err)
+	}
+
+	var stub archiveStub
+	if err := json.Unmarshal([]byte(stubData), &stub); err != nil {
+		return fmt.Errorf("failed to unmarshal archive stub: %w", err)
+	}
+
+	payload, err := a.store.Get(ctx, stub.ObjectKey)
+	if err != nil {
+		return fmt.Errorf("failed to fetch archived thread: %w", err)
+	}
+
+	var bundle archivedThread
+	if err := json.Unmarshal(payload, &bundle); err != nil {
+		return fmt.Errorf("failed to unmarshal archived thread: %w", err)
+	}
+
+	// Messages written since archival are newer than their archived copies. The stub is only
+	// deleted by the restore that brought the messages back, which deletes the object too.
+	restored := false
+	err = a.db.Atomic(ctx, func(tx database.Backend) error {
+		restored = false
+		if current, err := tx.Get(ctx, stubKey); database.IsNotFound(err) || (err == nil && current != stubData) {
+			return nil // restored meanwhile
+		} else if err != nil {
+			return fmt.Errorf("failed to get archive stub: %w", err)
+		}
+
+		for messageID, data := range bundle.Messages {
+			if _, err := tx.HSetNX(ctx, messagesKey(threadID), messageID, data); err != nil {
+				return fmt.Errorf("failed to restore message: %w", err)
+			}
+		}
+
+		// Clear the archived flag, keeping any thread changes made since archival
+		threadKey := fmt.Sprintf("threads:%s:%s", stub.UserID.String(), threadID)
+		if err := setArchivedFlag(ctx, tx, threadKey, false); err != nil && !database.IsNotFound(err) {
+			return err
+		}
+
+		if err := tx.Del(ctx, stubKey); err != nil {
+			return fmt.Errorf("failed to delete archive stub: %w", err)
+		}
+		restored = true
+		return nil
+	})
+	if err != nil || !restored {
+		return err
+	}
+
+	if err := a.store.Delete(ctx, stub.ObjectKey); err != nil {
+		fmt.Printf("Warning: failed to delete archived object %s: %v\n", stub.ObjectKey, err)
+	}
+
+	return nil
+}
+
+// ArchivedMessages returns the stored messages of an archived thread by message ID without
+// restoring them, and nothing for threads that are not archived
+func (a *ArchiveService) ArchivedMessages(ctx context.Context, threadID string) (map[string]string, error) {
+	stubData, err := a.db.Get(ctx, fmt.Sprintf("archived_threads:%s", threadID))
+	if err != nil {
+		if database.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get archive stub: %w", err)
+	}
+
+	var stub archiveStub
+	if err := json.Unmarshal([]byte(stubData), &stub); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal archive stub: %w", err)
+	}
+
+	payload, err := a.store.Get(ctx, stub.ObjectKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch archived thread: %w", err)
+	}
+
+	var bundle archivedThread
+	if err := json.Unmarshal(payload, &bundle); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal archived thread: %w", err)
+	}
+	return bundle.Messages, nil
+}
+
+// Discard removes an archived thread from the archival store without restoring it
+func (a *ArchiveService) Discard(ctx context.Context, threadID string) error {
+	stubKey := fmt.Sprintf("archived_threads:%s", threadID)
+	stubData, err := a.db.Get(ctx, stubKey)
+	if err != nil {
+		if database.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to get archive stub: %w", err)
+	}
+
+	var stub archiveStub
+	if err := json.Unmarshal([]byte(stubData), &stub); err != nil {
+		return fmt.Errorf("failed to unmarshal archive stub: %w", err)
+	}
+
+	if err := a.store.Delete(ctx, stub.ObjectKey); err != nil {
+		return err
+	}
+
+	return a.db.Del(ctx, stubKey)
+}
@@ -0,0 +1,137 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/blobstore"
+	"github.com/helioschat/sync/internal/clock"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// hookStore runs beforePut before each upload, standing in for writes that land while it is under way
+type hookStore struct {
+	blobstore.Store
+	beforePut func()
+}
+
+func (h hookStore) Put(ctx context.Context, key string, data []byte) error {
+	if h.beforePut != nil {
+		h.beforePut()
+	}
+	return h.Store.Put(ctx, key, data)
+}
+
+// newArchivedSync returns a sync service archiving to a temporary directory, and a thread of a new
+// user holding message "a"
+func newArchivedSync(t *testing.T) (*SyncService, *ArchiveService, *hookStore, uuid.UUID, uuid.UUID) {
+	t.Helper()
+	db := newBoltBackend(t)
+	fs, err := blobstore.NewFSStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFSStore: %v", err)
+	}
+	store := &hookStore{Store: fs}
+	archive := NewArchiveService(db, store, 1, NewJobCoordinator(db, clock.System), clock.System)
+	s := NewSyncService(db, archive, types.Quotas{}, nil, clock.System)
+
+	ctx := context.Background()
+	userID, threadID := uuid.New(), uuid.Must(uuid.NewV7())
+	if _, _, err := s.UpsertThread(ctx, &types.Thread{ID: threadID, UserID: userID, Version: 1}, ""); err != nil {
+		t.Fatalf("UpsertThread: %v", err)
+	}
+	if err := s.CreateMessage(ctx, userID, threadID.String(), &types.Message{ID: "a", Role: "user", Content: "c"}, ""); err != nil {
+		t.Fatalf("CreateMessage: %v", err)
+	}
+	return s, archive, store, userID, threadID
+}
+
+// storedMessageIDs returns the IDs of the messages stored for a thread, without restoring it
+func storedMessageIDs(t *testing.T, s *SyncService, threadID uuid.UUID) map[string]bool {
+	t.Helper()
+	messages, err := s.db.HGetAll(context.Background(), messagesKey(threadID.String()))
+	if err != nil {
+		t.Fatalf("HGetAll: %v", err)
+	}
+	ids := make(map[string]bool, len(messages))
+	for id := range messages {
+		ids[id] = true
+	}
+	return ids
+}
+
+func TestArchiveThreadKeepsMessagesWrittenDuringUpload(t *testing.T) {
+	ctx := context.Background()
+	s, archive, store, userID, threadID := newArchivedSync(t)
+
+	store.beforePut = func() {
+		if err := s.db.HSet(ctx, messagesKey(threadID.String()), "b", `{"id":"b"}`); err != nil {
+			t.Errorf("HSet: %v", err)
+		}
+	}
+	archived, err := archive.archiveThread(ctx, userID, threadID)
+	if err != nil {
+		t.Fatalf("archiveThread: %v", err)
+	}
+	if archived {
+		t.Errorf("archiveThread archived a thread written to during its upload")
+	}
+
+	if ids := storedMessageIDs(t, s, threadID); !ids["a"] || !ids["b"] {
+		t.Errorf("stored messages = %v, want a and b", ids)
+	}
+	if stored, err := archive.ArchivedMessages(ctx, threadID.String()); err != nil || stored != nil {
+		t.Errorf("ArchivedMessages = %v, %v, want the thread not archived", stored, err)
+	}
+	if _, err := store.Get(ctx, archiveObjectKey(userID, threadID.String())); err == nil {
+		t.Errorf("uploaded object kept")
+	}
+
+	// The next run archives it
+	store.beforePut = nil
+	if archived, err := archive.archiveThread(ctx, userID, threadID); err != nil || !archived {
+		t.Fatalf("archiveThread = %v, %v, want the thread archived", archived, err)
+	}
+	if ids := storedMessageIDs(t, s, threadID); len(ids) != 0 {
+		t.Errorf("stored messages = %v, want none", ids)
+	}
+}
+
+func TestRehydrateKeepsMessagesWrittenWhileArchived(t *testing.T) {
+	ctx := context.Background()
+	s, archive, _, userID, threadID := newArchivedSync(t)
+
+	if archived, err := archive.archiveThread(ctx, userID, threadID); err != nil || !archived {
+		t.Fatalf("archiveThread = %v, %v, want the thread archived", archived, err)
+	}
+	thread, err := s.getThread(ctx, userID, threadID)
+	if err != nil {
+		t.Fatalf("getThread: %v", err)
+	}
+	if !thread.ArchivedRemote {
+		t.Errorf("archived thread not flagged")
+	}
+
+	// A write that found the thread restored just before it was archived
+	if err := s.db.HSet(ctx, messagesKey(threadID.String()), "a", `{"id":"a","content":"newer"}`); err != nil {
+		t.Fatalf("HSet: %v", err)
+	}
+	if err := archive.Rehydrate(ctx, threadID.String()); err != nil {
+		t.Fatalf("Rehydrate: %v", err)
+	}
+
+	data, err := s.db.HGet(ctx, messagesKey(threadID.String()), "a")
+	if err != nil {
+		t.Fatalf("HGet: %v", err)
+	}
+	if data != `{"id":"a","content":"newer"}` {
+		t.Errorf("message a = %s, want the one written while archived", data)
+	}
+	if thread, err = s.getThread(ctx, userID, threadID); err != nil {
+		t.Fatalf("getThread: %v", err)
+	}
+	if thread.ArchivedRemote {
+		t.Errorf("restored thread still flagged")
+	}
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// asnRange is an address range announced by one autonomous system
+type asnRange struct {
+	start, end net.IP // 16-byte form
+	asn        uint32
+}
+
+// ASNTable maps addresses to the autonomous system announcing them
+type ASNTable struct {
+	ranges []asnRange // sorted by start, non-overlapping
+}
+
+// LoadASNTable reads a tab-separated table of "range_start range_end AS_number ..." lines, the format of
+// the freely available iptoasn.com ip2asn-combined.tsv. Ranges of AS 0 (not routed) are skipped.
+func LoadASNTable(r io.Reader) (*ASNTable, error) {
+	table := &ASNTable{}
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) < 3 {
+			continue
+		}
+
+		start, end := net.ParseIP(fields[0]), net.ParseIP(fields[1])
+		if start == nil || end == nil {
+			return nil, fmt.Errorf("line %d: invalid address range", line)
+		}
+		asn, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid AS number: %w", line, err)
+		}
+		if asn == 0 {
+			continue
+		}
+
+		table.ranges = append(table.ranges, asnRange{start: start.To16(), end: end.To16(), asn: uint32(asn)})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(table.ranges, func(i, j int) bool {
+		return bytes.Compare(table.ranges[i].start, table.ranges[j].start) < 0
+	})
+	return table, nil
+}
+
+// Lookup returns the AS number announcing ip, or false if it is not in the table
+func (t *ASNTable) Lookup(ip net.IP) (uint32, bool) {
+	ip = ip.To16()
+	if ip == nil {
+		return 0, false
+	}
+
+	// Last range starting at or before ip
+	i := sort.Search(len(t.ranges), func(i int) bool {
+		return bytes.Compare(t.ranges[i].start, ip) > 0
+	}) - 1
+	if i < 0 || bytes.Compare(ip, t.ranges[i].end) > 0 {
+		return 0, false
+	}
+	return t.ranges[i].asn, true
+}
+
+// Len returns the number of ranges in the table
+func (t *ASNTable) Len() int {
+	return len(t.ranges)
+}
@@ -0,0 +1,190 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AttachmentCollector deletes attachments no message references anymore. Messages name the
+// attachments they reference in plaintext attachment_refs; attachments are uploaded before the
+// message referencing them, so only those older than a grace period are collected.
+type AttachmentCollector struct {
+	sync  *SyncService
+	jobs  *JobCoordinator
+	grace time.Duration
+
+	mu      sync.Mutex
+	lastErr error // error of the most recent collection run, nil if it succeeded
+}
+
+func NewAttachmentCollector(sync *SyncService, jobs *JobCoordinator, grace time.Duration) *AttachmentCollector {
+	return &AttachmentCollector{
+		sync:  sync,
+		jobs:  jobs,
+		grace: grace,
+	}
+}
+
+// Run collects orphaned attachments every interval until the process exits. Only one instance collects at a time.
+func (a *AttachmentCollector) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		collected, err := a.runCollection(context.Background(), interval)
+		a.mu.Lock()
+		a.lastErr = err
+		a.mu.Unlock()
+		if err != nil {
+			fmt.Printf("Warning: attachment collection run failed: %v\n", err)
+		} else if collected > 0 {
+			fmt.Printf("Deleted %d orphaned attachments\n", collected)
+		}
+		<-ticker.C
+	}
+}
+
+// LastRunError returns the error of the most recent scheduled collection run, or nil if it succeeded
+func (a *AttachmentCollector) LastRunError() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.lastErr
+}
+
+func (a *AttachmentCollector) runCollection(ctx context.Context, interval time.Duration) (int, error) {
+	acquired, err := a.jobs.AcquireLease(ctx, "attachment_gc", interval)
+	if err != nil || !acquired {
+		return 0, err
+	}
+	defer a.jobs.ReleaseLease(ctx, "attachment_gc")
+	return a.CollectOrphans(ctx)
+}
+
+// CollectOrphans deletes every attachment older than the grace period that none of its owner's
+// messages references, returning how many were deleted
+func (a *AttachmentCollector) CollectOrphans(ctx context.Context) (int, error) {
+	if !a.sync.AttachmentsEnabled() {
+		return 0, nil
+	}
+
+	keys, err := a.sync.db.Keys(ctx, "attachments:*")
+	if err != nil {
+		return 0, fmt.Errorf("failed to get attachment indexes: %w", err)
+	}
+
+	cutoff := a.sync.clock.Now().Add(-a.grace)
+	collected := 0
+	for _, key := range keys {
+		userID, err := uuid.Parse(strings.TrimPrefix(key, "attachments:"))
+		if err != nil {
+			continue
+		}
+
+		attachments, err := a.sync.GetAttachments(ctx, userID)
+		if err != nil {
+			return collected, err
+		}
+		var candidates []uuid.UUID
+		for _, attachment := range attachments {
+			if attachment.CreatedAt.Before(cutoff) {
+				candidates = append(candidates, attachment.ID)
+			}
+		}
+		if len(candidates) == 0 {
+			continue
+		}
+
+		referenced, err := a.sync.referencedAttachments(ctx, userID)
+		if err != nil {
+			fmt.Printf("Warning: failed to collect attachments of user %s: %v\n", userID, err)
+			continue
+		}
+		for _, attachmentID := range candidates {
+			if referenced[attachmentID] {
+				continue
+			}
+			if err := a.sync.DeleteAttachment(ctx, userID, attachmentID, ""); err != nil {
+				fmt.Printf("Warning: failed to delete orphaned attachment %s: %v\n", attachmentID, err)
+				continue
+			}
+			collected++
+		}
+	}
+
+	return collected, nil
+}
+
+// referencedAttachments returns the IDs of the attachments a user's messages and drafts reference,
+// including the messages of archived threads. Branches only inherit messages of the user's own
+// threads, so those are all there is to read.
+func (s *SyncService) referencedAttachments(ctx context.Context, userID uuid.UUID) (map[uuid.UUID]bool, error) {
+	indexed, err := s.db.SMembers(ctx, threadIndexKey(userID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get thread index: %w", err)
+	}
+	scored, err := s.db.ZRangeByScore(ctx, fmt.Sprintf("timestamps:threads:%s", userID.String()), "-inf", "+inf")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get thread timestamps: %w", err)
+	}
+
+	referenced := make(map[uuid.UUID]bool)
+	seen := make(map[string]bool)
+	for _, threadID := range append(indexed, scored...) {
+		if seen[threadID] {
+			continue
+		}
+		seen[threadID] = true
+
+		owns, err := s.ownsThread(ctx, userID, threadID)
+		if err != nil {
+			return nil, err
+		}
+		if !owns {
+			continue
+		}
+
+		messages, err := s.db.HGetAll(ctx, messagesKey(threadID))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get messages: %w", err)
+		}
+		if s.archive != nil {
+			archived, err := s.archive.ArchivedMessages(ctx, threadID)
+			if err != nil {
+				return nil, err
+			}
+			for messageID, data := range archived {
+				messages[messageID] = data
+			}
+		}
+
+		// A message that can't be read might reference anything, so nothing of the user's is collected
+		for messageID, data := range messages {
+			var refs struct {
+				AttachmentRefs []uuid.UUID `json:"attachment_refs"`
+			}
+			if err := json.Unmarshal([]byte(data), &refs); err != nil {
+				return nil, fmt.Errorf("failed to read message %s of thread %s: %w", messageID, threadID, err)
+			}
+			for _, attachmentID := range refs.AttachmentRefs {
+				referenced[attachmentID] = true
+			}
+		}
+	}
+
+	drafts, err := s.GetDrafts(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, draft := range drafts {
+		for _, attachmentID := range draft.AttachmentRefs {
+			referenced[attachmentID] = true
+		}
+	}
+	return referenced, nil
+}
@@ -0,0 +1,474 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/blobstore"
+	"github.com/helioschat/sync/internal/database"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// Attachments are blobs the client encrypts before uploading, referenced by ID from the encrypted
+// Message.AttachmentIds. The server can't tell which messages reference an attachment, so they are
+// managed on their own: uploaded before the message referencing them and deleted by the client.
+// The blobs live in the attachment store; their metadata in a hash per user.
+
+var (
+	// ErrAttachmentsDisabled is returned when the instance has no attachment store configured
+	ErrAttachmentsDisabled = errors.New("attachment storage is disabled")
+	// ErrAttachmentNotFound is returned when an attachment does not exist
+	ErrAttachmentNotFound = errors.New("attachment not found")
+	// ErrAttachmentExists is returned when uploading different content under an existing attachment ID
+	ErrAttachmentExists = errors.New("attachment already exists with different content")
+	// ErrAttachmentTooLarge is returned when an upload exceeds the instance's size limit
+	ErrAttachmentTooLarge = errors.New("attachment too large")
+	// ErrAttachmentQuota is returned when an upload would take a user past their attachment storage quota
+	ErrAttachmentQuota = errors.New("attachment storage quota exceeded")
+	// ErrAttachmentUploading is returned when another upload of the same attachment ID is still running
+	ErrAttachmentUploading = errors.New("attachment is being uploaded")
+)
+
+// An upload claims its attachment ID in the metadata hash before writing the blob, so concurrent
+// uploads of one ID can't overwrite each other's blob. The claim holds attachmentClaimPrefix and
+// the claim time until the metadata replaces it; claims older than attachmentClaimTimeout were
+// left by failed uploads and may be taken over.
+const (
+	attachmentClaimPrefix  = "pending:"
+	attachmentClaimTimeout = 10 * time.Minute
+)
+
+// isAttachmentClaim reports whether a metadata hash value is an upload's claim rather than metadata
+func isAttachmentClaim(data string) bool {
+	return strings.HasPrefix(data, attachmentClaimPrefix)
+}
+
+// attachmentsKey returns the hash of a user's attachment metadata, keyed by attachment ID
+func attachmentsKey(userID uuid.UUID) string {
+	return fmt.Sprintf("attachments:%s", userID.String())
+}
+
+// attachmentObjectKey returns the key of an attachment's blob in the attachment store
+func attachmentObjectKey(userID, attachmentID uuid.UUID) string {
+	return fmt.Sprintf("attachments/%s/%s", userID.String(), attachmentID.String())
+}
+
+// UseAttachmentStore enables attachments, storing their blobs in store. Uploads larger than
+// maxBytes are rejected (0 means no limit).
+func (s *SyncService) UseAttachmentStore(store blobstore.Store, maxBytes int64) {
+	s.attachments = store
+	s.attachmentMaxBytes = maxBytes
+}
+
+// AttachmentsEnabled reports whether the instance stores attachments
+func (s *SyncService) AttachmentsEnabled() bool {
+	return s.attachments != nil
+}
+
+// AttachmentMaxBytes returns the size limit of attachment uploads, 0 for none
+func (s *SyncService) AttachmentMaxBytes() int64 {
+	return s.attachmentMaxBytes
+}
+
+// GetAttachments returns the metadata of a user's attachments ordered by ID
+func (s *SyncService) GetAttachments(ctx context.Context, userID uuid.UUID) ([]types.Attachment, error) {
+	if s.attachments == nil {
+		return nil, ErrAttachmentsDisabled
+	}
+
+	entries, err := s.db.HGetAll(ctx, attachmentsKey(userID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get attachments: %w", err)
+	}
+
+	attachments := []types.Attachment{}
+	for attachmentID, data := range entries {
+		if isAttachmentClaim(data) {
+			continue
+		}
+		var attachment types.Attachment
+		if err := json.Unmarshal([]byte(data), &attachment); err != nil {
+			s.quarantine(ctx, QuarantinedRecord{Resource: "attachment", UserID: userID.String(), Key: attachmentsKey(userID), Field: attachmentID}, err)
+			continue
+		}
+		attachments = append(attachments, attachment)
+	}
+
+	sort.Slice(attachments, func(i, j int) bool {
+		return attachments[i].ID.String() < attachments[j].ID.String()
+	})
+
+	return attachments, nil
+}
+
+func (s *SyncService) getAttachment(ctx context.Context, userID, attachmentID uuid.UUID) (*types.Attachment, error) {
+	data, err := s.db.HGet(ctx, attachmentsKey(userID), attachmentID.String())
+	if database.IsNotFound(err) {
+		return nil, ErrAttachmentNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get attachment: %w", err)
+	}
+	if isAttachmentClaim(data) {
+		return nil, ErrAttachmentNotFound
+	}
+
+	var attachment types.Attachment
+	if err := json.Unmarshal([]byte(data), &attachment); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal attachment: %w", err)
+	}
+	return &attachment, nil
+}
+
+// attachmentUsage returns how many attachments a user stores and their total size in bytes
+func (s *SyncService) attachmentUsage(ctx context.Context, userID uuid.UUID) (int64, int64, error) {
+	entries, err := s.db.HGetAll(ctx, attachmentsKey(userID))
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get attachments: %w", err)
+	}
+	var count, total int64
+	for _, data := range entries {
+		if isAttachmentClaim(data) {
+			continue
+		}
+		count++
+		var attachment types.Attachment
+		if err := json.Unmarshal([]byte(data), &attachment); err == nil {
+			total += attachment.Size
+		}
+	}
+	return count, total, nil
+}
+
+// attachmentBytesKey returns the counter of the bytes a user's attachments take
+func attachmentBytesKey(userID uuid.UUID) string {
+	return fmt.Sprintf("attachment_bytes:%s", userID.String())
+}
+
+// startAttachmentBytes makes sure a user's attachment byte counter exists. Counters missing from
+// before they were kept start from the stored attachments, so it has to be called before the
+// metadata changes.
+func (s *SyncService) startAttachmentBytes(ctx context.Context, userID uuid.UUID) error {
+	_, err := s.db.Get(ctx, attachmentBytesKey(userID))
+	if err == nil {
+		return nil
+	}
+	if !database.IsNotFound(err) {
+		return fmt.Errorf("failed to get attachment usage: %w", err)
+	}
+
+	_, used, err := s.attachmentUsage(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if _, err := s.db.SetNX(ctx, attachmentBytesKey(userID), used, 0); err != nil {
+		return fmt.Errorf("failed to start attachment usage counter: %w", err)
+	}
+	return nil
+}
+
+// addAttachmentBytes adds delta to a user's attachment byte counter and returns the new total
+func (s *SyncService) addAttachmentBytes(ctx context.Context, userID uuid.UUID, delta int64) (int64, error) {
+	total, err := s.db.IncrBy(ctx, attachmentBytesKey(userID), delta)
+	if err != nil {
+		return 0, fmt.Errorf("failed to update attachment usage: %w", err)
+	}
+	return total, nil
+}
+
+// reserveAttachmentBytes counts an upload of size bytes towards a user's usage, refusing it if it
+// would take them past their quota. Uploads reserve their bytes before storing anything, so
+// concurrent uploads can't all fit under the quota on their own and exceed it together.
+func (s *SyncService) reserveAttachmentBytes(ctx context.Context, userID uuid.UUID, size int64) error {
+	if err := s.startAttachmentBytes(ctx, userID); err != nil {
+		return err
+	}
+	used, err := s.addAttachmentBytes(ctx, userID, size)
+	if err != nil {
+		return err
+	}
+	if limit := s.quotas.AttachmentBytes; limit > 0 && used > limit {
+		s.releaseAttachmentBytes(ctx, userID, size)
+		return fmt.Errorf("%w: %d of %d bytes used, the upload needs %d more", ErrAttachmentQuota, used-size, limit, size)
+	}
+	return nil
+}
+
+// releaseAttachmentBytes takes size bytes off a user's usage, for deleted attachments and
+// reservations of uploads that failed
+func (s *SyncService) releaseAttachmentBytes(ctx context.Context, userID uuid.UUID, size int64) {
+	if _, err := s.addAttachmentBytes(ctx, userID, -size); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
+}
+
+func (s *SyncService) saveAttachment(ctx context.Context, userID uuid.UUID, attachment *types.Attachment) error {
+	data, err := json.Marshal(attachment)
+	if err != nil {
+		return fmt.Errorf("failed to marshal attachment: %w", err)
+	}
+	if err := s.db.HSet(ctx, attachmentsKey(userID), attachment.ID.String(), string(data)); err != nil {
+		return fmt.Errorf("failed to save attachment: %w", err)
+	}
+	return nil
+}
+
+// GetAttachment returns an attachment's metadata and its encrypted blob
+func (s *SyncService) GetAttachment(ctx context.Context, userID, attachmentID uuid.UUID) (*types.Attachment, []byte, error) {
+	if s.attachments == nil {
+		return nil, nil, ErrAttachmentsDisabled
+	}
+
+	attachment, err := s.getAttachment(ctx, userID, attachmentID)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := s.attachments.Get(ctx, attachmentObjectKey(userID, attachmentID))
+	if errors.Is(err, blobstore.ErrNotFound) {
+		return nil, nil, ErrAttachmentNotFound
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read attachment: %w", err)
+	}
+	return attachment, data, nil
+}
+
+// PutAttachment stores an encrypted blob under attachmentID. Attachments are immutable: uploading
+// the same content again is accepted, different content under the same ID is ErrAttachmentExists.
+// It reports whether the attachment was created.
+func (s *SyncService) PutAttachment(ctx context.Context, userID, attachmentID uuid.UUID, data []byte, encV int, machineID string) (*types.Attachment, bool, error) {
+	if s.attachments == nil {
+		return nil, false, ErrAttachmentsDisabled
+	}
+	if s.attachmentMaxBytes > 0 && int64(len(data)) > s.attachmentMaxBytes {
+		return nil, false, fmt.Errorf("%w: %d bytes, at most %d allowed", ErrAttachmentTooLarge, len(data), s.attachmentMaxBytes)
+	}
+
+	sum := sha256.Sum256(data)
+	digest := hex.EncodeToString(sum[:])
+
+	existing, err := s.claimAttachment(ctx, userID, attachmentID)
+	if err != nil {
+		return nil, false, err
+	}
+	if existing != nil {
+		if existing.SHA256 != digest {
+			return nil, false, fmt.Errorf("%w: %s", ErrAttachmentExists, attachmentID)
+		}
+		return existing, false, nil
+	}
+	size := int64(len(data))
+	if err := s.reserveAttachmentBytes(ctx, userID, size); err != nil {
+		s.releaseAttachmentClaim(ctx, userID, attachmentID)
+		return nil, false, err
+	}
+
+	// The blob goes first, so metadata never announces an attachment that can't be downloaded
+	if err := s.attachments.Put(ctx, attachmentObjectKey(userID, attachmentID), data); err != nil {
+		s.releaseAttachmentBytes(ctx, userID, size)
+		s.releaseAttachmentClaim(ctx, userID, attachmentID)
+		return nil, false, fmt.Errorf("failed to store attachment: %w", err)
+	}
+
+	now := s.clock.Now()
+	attachment := &types.Attachment{
+		ID:        attachmentID,
+		Size:      size,
+		SHA256:    digest,
+		EncV:      encV,
+		CreatedAt: now,
+	}
+	if err := s.saveAttachment(ctx, userID, attachment); err != nil {
+		s.releaseAttachmentBytes(ctx, userID, size)
+		s.releaseAttachmentClaim(ctx, userID, attachmentID)
+		return nil, false, err
+	}
+
+	s.recordChange(ctx, changeRecord{
+		Resource:   "attachment",
+		Operation:  "update",
+		ResourceID: attachmentID.String(),
+		UserID:     userID,
+		MachineID:  machineID,
+		Timestamp:  now,
+	})
+
+	return attachment, true, nil
+}
+
+// claimAttachment claims attachmentID for an upload. It returns the attachment if it exists already,
+// and ErrAttachmentUploading if another upload holds the claim.
+func (s *SyncService) claimAttachment(ctx context.Context, userID, attachmentID uuid.UUID) (*types.Attachment, error) {
+	claim := attachmentClaimPrefix + strconv.FormatInt(s.clock.Now().UnixMilli(), 10)
+	claimed, err := s.db.HSetNX(ctx, attachmentsKey(userID), attachmentID.String(), claim)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim attachment: %w", err)
+	}
+	if claimed {
+		return nil, nil
+	}
+
+	data, err := s.db.HGet(ctx, attachmentsKey(userID), attachmentID.String())
+	if database.IsNotFound(err) {
+		// Deleted in the meantime
+		return s.claimAttachment(ctx, userID, attachmentID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get attachment: %w", err)
+	}
+	if !isAttachmentClaim(data) {
+		var attachment types.Attachment
+		if err := json.Unmarshal([]byte(data), &attachment); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal attachment: %w", err)
+		}
+		return &attachment, nil
+	}
+
+	claimedAt, _ := strconv.ParseInt(strings.TrimPrefix(data, attachmentClaimPrefix), 10, 64)
+	if s.clock.Now().Sub(time.UnixMilli(claimedAt)) < attachmentClaimTimeout {
+		return nil, fmt.Errorf("%w: %s", ErrAttachmentUploading, attachmentID)
+	}
+	if err := s.db.HSet(ctx, attachmentsKey(userID), attachmentID.String(), claim); err != nil {
+		return nil, fmt.Errorf("failed to claim attachment: %w", err)
+	}
+	return nil, nil
+}
+
+// releaseAttachmentClaim gives up the claim of an upload that failed
+func (s *SyncService) releaseAttachmentClaim(ctx context.Context, userID, attachmentID uuid.UUID) {
+	if _, err := s.db.HDel(ctx, attachmentsKey(userID), attachmentID.String()); err != nil {
+		fmt.Printf("Warning: failed to release claim of attachment %s: %v\n", attachmentID, err)
+	}
+}
+
+// DeleteAttachment deletes an attachment's blob and metadata
+func (s *SyncService) DeleteAttachment(ctx context.Context, userID, attachmentID uuid.UUID, machineID string) error {
+	if s.attachments == nil {
+		return ErrAttachmentsDisabled
+	}
+	attachment, err := s.getAttachment(ctx, userID, attachmentID)
+	if err != nil {
+		return err
+	}
+	if err := s.startAttachmentBytes(ctx, userID); err != nil {
+		return err
+	}
+
+	// The metadata goes last, so a failed deletion can be retried
+	if err := s.attachments.Delete(ctx, attachmentObjectKey(userID, attachmentID)); err != nil {
+		return fmt.Errorf("failed to delete attachment: %w", err)
+	}
+	removed, err := s.db.HDel(ctx, attachmentsKey(userID), attachmentID.String())
+	if err != nil {
+		return fmt.Errorf("failed to delete attachment: %w", err)
+	}
+	if removed == 0 {
+		// A concurrent deletion got there first and released the bytes
+		return nil
+	}
+	s.releaseAttachmentBytes(ctx, userID, attachment.Size)
+
+	s.recordChange(ctx, changeRecord{
+		Resource:   "attachment",
+		Operation:  "delete",
+		ResourceID: attachmentID.String(),
+		UserID:     userID,
+		MachineID:  machineID,
+		Timestamp:  s.clock.Now(),
+	})
+
+	return nil
+}
+
+// purgeAttachments deletes the blobs of all of a user's attachments, returning how many there were.
+// Their metadata is deleted with the rest of the user's keys.
+func (s *SyncService) purgeAttachments(ctx context.Context, userID uuid.UUID) (int, error) {
+	if s.attachments == nil {
+		return 0, nil
+	}
+	entries, err := s.db.HGetAll(ctx, attachmentsKey(userID))
+	if err != nil {
+		return 0, fmt.Errorf("failed to get attachments: %w", err)
+	}
+	for id := range entries {
+		attachmentID, err := uuid.Parse(id)
+		if err != nil {
+			continue
+		}
+		if err := s.attachments.Delete(ctx, attachmentObjectKey(userID, attachmentID)); err != nil {
+			return 0, fmt.Errorf("failed to delete attachment %s: %w", attachmentID, err)
+		}
+	}
+	return len(entries), nil
+}
+
+// mergeAttachments copies source's attachments to target. An attachment ID both hold is only a
+// conflict if the contents differ; attachments are immutable, so the target's copy is kept.
+func (s *SyncService) mergeAttachments(ctx context.Context, sourceID, targetID uuid.UUID, dryRun bool, report *types.MergeReport) error {
+	if s.attachments == nil {
+		return nil
+	}
+	attachments, err := s.GetAttachments(ctx, sourceID)
+	if err != nil {
+		return err
+	}
+
+	if !dryRun {
+		if err := s.startAttachmentBytes(ctx, targetID); err != nil {
+			return err
+		}
+	}
+
+	now := s.clock.Now()
+	for _, attachment := range attachments {
+		existing, err := s.getAttachment(ctx, targetID, attachment.ID)
+		if err == nil {
+			if existing.SHA256 != attachment.SHA256 {
+				addConflict(report, "attachment", attachment.ID.String(), false)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrAttachmentNotFound) {
+			return err
+		}
+		report.Attachments++
+		if dryRun {
+			continue
+		}
+
+		data, err := s.attachments.Get(ctx, attachmentObjectKey(sourceID, attachment.ID))
+		if errors.Is(err, blobstore.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read attachment %s: %w", attachment.ID, err)
+		}
+		if err := s.attachments.Put(ctx, attachmentObjectKey(targetID, attachment.ID), data); err != nil {
+			return fmt.Errorf("failed to copy attachment %s: %w", attachment.ID, err)
+		}
+		if err := s.saveAttachment(ctx, targetID, &attachment); err != nil {
+			return err
+		}
+		// Merged attachments count towards the target's usage but aren't held to its quota
+		if _, err := s.addAttachmentBytes(ctx, targetID, attachment.Size); err != nil {
+			return err
+		}
+		s.recordChange(ctx, changeRecord{
+			Resource:   "attachment",
+			Operation:  "update",
+			ResourceID: attachment.ID.String(),
+			UserID:     targetID,
+			Timestamp:  now,
+		})
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/blobstore"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// newAttachmentSync returns a sync service storing attachments in a temporary directory
+func newAttachmentSync(t *testing.T, quota int64) (*SyncService, blobstore.Store) {
+	t.Helper()
+	s, _ := newTestSync(t, types.Quotas{AttachmentBytes: quota})
+	store, err := blobstore.NewFSStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFSStore: %v", err)
+	}
+	s.UseAttachmentStore(store, 0)
+	return s, store
+}
+
+// barrierStore holds deletions until as many as the barrier counts have started, so concurrent
+// deletions of one attachment all get past its lookup
+type barrierStore struct {
+	blobstore.Store
+	barrier *sync.WaitGroup
+}
+
+func (b barrierStore) Delete(ctx context.Context, key string) error {
+	b.barrier.Done()
+	b.barrier.Wait()
+	return b.Store.Delete(ctx, key)
+}
+
+// attachmentBytes returns the user's attachment byte counter
+func attachmentBytes(t *testing.T, s *SyncService, userID uuid.UUID) int64 {
+	t.Helper()
+	used, err := s.addAttachmentBytes(context.Background(), userID, 0)
+	if err != nil {
+		t.Fatalf("addAttachmentBytes: %v", err)
+	}
+	return used
+}
+
+func TestPutAttachmentEnforcesQuotaUnderConcurrency(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newAttachmentSync(t, 10)
+	userID := uuid.New()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	stored := 0
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := s.PutAttachment(ctx, userID, uuid.New(), []byte("abc"), 1, "")
+			switch {
+			case err == nil:
+				mu.Lock()
+				stored++
+				mu.Unlock()
+			case !errors.Is(err, ErrAttachmentQuota):
+				t.Errorf("PutAttachment: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if stored != 3 {
+		t.Errorf("stored %d attachments, want 3", stored)
+	}
+	if used := attachmentBytes(t, s, userID); used != 9 {
+		t.Errorf("counter = %d, want 9", used)
+	}
+}
+
+func TestDeleteAttachmentReleasesBytesOnce(t *testing.T) {
+	ctx := context.Background()
+	s, store := newAttachmentSync(t, 100)
+	userID, attachmentID := uuid.New(), uuid.New()
+
+	if _, _, err := s.PutAttachment(ctx, userID, attachmentID, []byte("abcde"), 1, ""); err != nil {
+		t.Fatalf("PutAttachment: %v", err)
+	}
+	if _, _, err := s.PutAttachment(ctx, userID, uuid.New(), []byte("abc"), 1, ""); err != nil {
+		t.Fatalf("PutAttachment: %v", err)
+	}
+
+	const deletions = 4
+	var barrier sync.WaitGroup
+	barrier.Add(deletions)
+	s.UseAttachmentStore(barrierStore{Store: store, barrier: &barrier}, 0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < deletions; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.DeleteAttachment(ctx, userID, attachmentID, ""); err != nil && !errors.Is(err, ErrAttachmentNotFound) {
+				t.Errorf("DeleteAttachment: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if used := attachmentBytes(t, s, userID); used != 3 {
+		t.Errorf("counter = %d, want 3", used)
+	}
+}
+
+func TestAttachmentCounterStartsFromStoredAttachments(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newAttachmentSync(t, 10)
+	userID := uuid.New()
+
+	if _, _, err := s.PutAttachment(ctx, userID, uuid.New(), []byte("abcdef"), 1, ""); err != nil {
+		t.Fatalf("PutAttachment: %v", err)
+	}
+	// Attachments stored before the counter existed
+	if err := s.db.Del(ctx, attachmentBytesKey(userID)); err != nil {
+		t.Fatalf("Del: %v", err)
+	}
+
+	if _, _, err := s.PutAttachment(ctx, userID, uuid.New(), []byte("abcde"), 1, ""); !errors.Is(err, ErrAttachmentQuota) {
+		t.Errorf("PutAttachment past the quota: error = %v, want ErrAttachmentQuota", err)
+	}
+	if used := attachmentBytes(t, s, userID); used != 6 {
+		t.Errorf("counter = %d, want 6", used)
+	}
+}
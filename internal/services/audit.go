@@ -0,0 +1,57 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/helioschat/sync/internal/database"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// AuditService records authorization decisions as structured logs and, optionally, in Redis
+type AuditService struct {
+	db         database.Backend
+	logger     *slog.Logger
+	store      bool
+	maxEntries int64
+}
+
+func NewAuditService(db database.Backend, store bool, maxEntries int64) *AuditService {
+	return &AuditService{
+		db:         db,
+		logger:     slog.New(slog.NewJSONHandler(os.Stdout, nil)).With("component", "audit"),
+		store:      store,
+		maxEntries: maxEntries,
+	}
+}
+
+// Record logs an authorization decision and appends it to the audit stream if storage is enabled
+func (s *AuditService) Record(ctx context.Context, entry types.AuditEntry) {
+	s.logger.Info("authorization",
+		"user_id", entry.UserID,
+		"machine_id", entry.MachineID,
+		"method", entry.Method,
+		"route", entry.Route,
+		"resource_id", entry.ResourceID,
+		"decision", entry.Decision,
+		"status", entry.Status,
+		"client_ip", entry.ClientIP,
+	)
+
+	if !s.store {
+		return
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		fmt.Printf("Warning: failed to marshal audit entry: %v\n", err)
+		return
+	}
+
+	if _, err := s.db.XAdd(ctx, "audit_log", s.maxEntries, map[string]interface{}{"entry": string(data)}); err != nil {
+		fmt.Printf("Warning: failed to store audit entry: %v\n", err)
+	}
+}
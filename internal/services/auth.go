@@ -1,183 +1,590 @@
 package services
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/subtle"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"slices"
+	"sort"
+	"strconv"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/clock"
 	"github.com/helioschat/sync/internal/database"
 	"github.com/helioschat/sync/internal/types"
 	"golang.org/x/crypto/argon2"
 )
 
 const (
-	// Argon2id parameters
-	argon2Time    = 1
-	argon2Memory  = 64 * 1024 // 64MB
-	argon2Threads = 4
+	// Guest token lifetimes
+	guestTokenDefaultTTL = 1 * time.Hour
+	guestTokenMaxTTL     = 24 * time.Hour
+
+	accessTokenTTL = 1 * time.Hour
+
+	// Longest-lived token type; state that must outlive every issued token is kept this long
+	refreshTokenTTL = 7 * 24 * time.Hour
+
+	// Argon2id output and salt sizes; the cost parameters are configurable
 	argon2KeyLen  = 32
 	argon2SaltLen = 16
 )
 
+// legacyArgon2Params are the parameters of hashes stored before the parameters were recorded in the wallet
+var legacyArgon2Params = types.Argon2Params{Time: 1, Memory: 64 * 1024, Threads: 4}
+
+// ErrInvalidCredentials is returned by Login for unknown wallets and wrong passphrases alike, so
+// logins don't reveal which UIDs are in use
+var ErrInvalidCredentials = errors.New("invalid wallet ID or passphrase")
+
+// Salt and hash checked against when there is no wallet to check a secret against
+var (
+	dummySalt = base64.StdEncoding.EncodeToString(make([]byte, argon2SaltLen))
+	dummyHash = base64.StdEncoding.EncodeToString(make([]byte, argon2KeyLen))
+)
+
 type AuthService struct {
-	jwtSecret []byte
-	db        *database.RedisClient // Add Redis client for storing user data
+	signer *TokenSigner
+	issuer string           // public URL of this instance, used as iss and aud of every token
+	db     database.Backend // Add Redis client for storing user data
+
+	machinePolicy MachinePolicy
+
+	hashParams       types.Argon2Params // parameters of new hashes; older hashes are upgraded at login
+	lockout          LockoutPolicy
+	passphrasePolicy *PassphrasePolicy // checked whenever a passphrase is chosen; nil accepts any
+	sealer           *MetadataSealer   // nil stores machine and session records in the clear
+	totpSealer       *MetadataSealer   // encrypts TOTP secrets; nil disables TOTP enrollment
+	opaqueOnly       bool              // wallets registered for OPAQUE can't log in with their passphrase
+
+	clock clock.Clock
 }
 
-func NewAuthService(jwtSecret string, db *database.RedisClient) *AuthService {
+func NewAuthService(signer *TokenSigner, db database.Backend, machinePolicy MachinePolicy, issuer string, hashParams types.Argon2Params, lockout LockoutPolicy, passphrasePolicy *PassphrasePolicy, sealer, totpSealer *MetadataSealer, clock clock.Clock) *AuthService {
 	return &AuthService{
-		jwtSecret: []byte(jwtSecret),
-		db:        db,
+		signer:           signer,
+		issuer:           issuer,
+		db:               db,
+		machinePolicy:    machinePolicy,
+		hashParams:       hashParams,
+		lockout:          lockout,
+		passphrasePolicy: passphrasePolicy,
+		sealer:           sealer,
+		totpSealer:       totpSealer,
+		clock:            clock,
+	}
+}
+
+// ValidateArgon2Params rejects parameters Argon2id can't run with or that are too weak to protect a wallet
+func ValidateArgon2Params(params types.Argon2Params) error {
+	if params.Time < 1 {
+		return errors.New("argon2 time must be at least 1")
+	}
+	if params.Memory < 8*1024 {
+		return errors.New("argon2 memory must be at least 8192 KiB")
+	}
+	if params.Threads < 1 {
+		return errors.New("argon2 threads must be at least 1")
 	}
+	return nil
 }
 
-// GenerateWallet creates a new wallet with a secure passphrase hash and salt
-func (s *AuthService) GenerateWallet(passphrase string) (*types.Wallet, error) {
+// GenerateWallet creates a new wallet with a secure passphrase hash and salt.
+// When withRecoveryCode is set, a recovery code is issued as well; it is only ever returned here.
+func (s *AuthService) GenerateWallet(ctx context.Context, passphrase string, withRecoveryCode bool) (*types.Wallet, string, error) {
 	if passphrase == "" {
-		return nil, errors.New("passphrase cannot be empty")
+		return nil, "", errors.New("passphrase cannot be empty")
+	}
+	if err := s.passphrasePolicy.Check(passphrase); err != nil {
+		return nil, "", err
 	}
 
 	uid := uuid.New()
 
-	// Generate salt
-	salt := make([]byte, argon2SaltLen)
-	if _, err := rand.Read(salt); err != nil {
-		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	wallet := &types.Wallet{
+		UID:       uid,
+		CreatedAt: s.clock.Now(),
 	}
 
-	// Hash passphrase with Argon2id
-	hashedPassphrase := argon2.IDKey([]byte(passphrase), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+	// Hash passphrase with Argon2id and a fresh salt
+	if err := s.setPassphrase(wallet, passphrase); err != nil {
+		return nil, "", err
+	}
 
-	wallet := &types.Wallet{
-		UID:              uid,
-		Salt:             base64.StdEncoding.EncodeToString(salt),
-		HashedPassphrase: base64.StdEncoding.EncodeToString(hashedPassphrase),
-		CreatedAt:        time.Now(),
+	var recoveryCode string
+	if withRecoveryCode {
+		code, err := s.setRecoveryCode(wallet)
+		if err != nil {
+			return nil, "", err
+		}
+		recoveryCode = code
 	}
 
 	// Store wallet details (UID, salt, hashed passphrase) in Redis
-	walletKey := fmt.Sprintf("wallet:%s", uid.String())
-	walletData, err := types.WalletToJSON(wallet) // Assuming you have a helper to marshal
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal wallet: %w", err)
-	}
-	if err := s.db.Set(walletKey, string(walletData), 0); err != nil {
-		return nil, fmt.Errorf("failed to save wallet: %w", err)
+	if err := s.saveWallet(ctx, wallet); err != nil {
+		return nil, "", err
 	}
 
 	// Return only UID and CreatedAt to the client, not the salt or hash
-	return &types.Wallet{UID: uid, CreatedAt: wallet.CreatedAt}, nil
+	return &types.Wallet{UID: uid, CreatedAt: wallet.CreatedAt}, recoveryCode, nil
 }
 
-// Login authenticates a user with their passphrase
-func (s *AuthService) Login(userID uuid.UUID, passphrase string) (*types.AuthTokens, error) {
+// Login authenticates a user with their passphrase, and totpCode if the wallet has TOTP enabled.
+// Repeated failures for the wallet or from clientIP (empty for logins not made over the API) lock
+// further attempts out.
+func (s *AuthService) Login(ctx context.Context, userID uuid.UUID, passphrase, totpCode string, machineID string, clientIP string) (*types.AuthTokens, error) {
 	if passphrase == "" {
 		return nil, errors.New("passphrase is required")
 	}
 
+	if err := s.checkLoginLockout(ctx, userID, clientIP); err != nil {
+		return nil, err
+	}
+
 	// Retrieve wallet details from Redis
-	walletKey := fmt.Sprintf("wallet:%s", userID.String())
-	data, err := s.db.Get(walletKey)
+	storedWallet, err := s.getWallet(ctx, userID)
 	if err != nil {
-		return nil, fmt.Errorf("user not found or failed to retrieve wallet: %w", err)
+		if database.IsNotFound(err) {
+			s.recordLoginFailure(ctx, userID, clientIP)
+			if s.provesMerged(ctx, userID, passphrase) {
+				return nil, ErrWalletMerged
+			}
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
 	}
 
-	var storedWallet types.Wallet
-	if err := types.WalletFromJSON([]byte(data), &storedWallet); err != nil { // Assuming you have a helper to unmarshal
-		return nil, fmt.Errorf("failed to unmarshal wallet data: %w", err)
+	if err := checkPassphrase(storedWallet, passphrase); err != nil {
+		s.recordLoginFailure(ctx, userID, clientIP)
+		return nil, ErrInvalidCredentials
+	}
+	if s.opaqueOnly {
+		registered, err := s.hasOpaqueRecord(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		if registered {
+			return nil, ErrOpaqueRequired
+		}
+	}
+	if err := s.verifyTOTP(ctx, storedWallet, totpCode); err != nil {
+		if errors.Is(err, ErrInvalidTOTP) {
+			s.recordLoginFailure(ctx, userID, clientIP)
+		}
+		return nil, err
 	}
+	s.clearLoginFailures(ctx, userID)
 
-	salt, err := base64.StdEncoding.DecodeString(storedWallet.Salt)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode salt: %w", err)
+	// Expired demo wallets may linger until the next cleanup run
+	if storedWallet.ExpiresAt != nil && storedWallet.ExpiresAt.Before(s.clock.Now()) {
+		return nil, errors.New("wallet has expired")
+	}
+
+	// A signed-out machine can't log itself back in
+	if machineID != "" {
+		if err := s.checkMachineTokens(ctx, userID, machineID); err != nil {
+			return nil, err
+		}
 	}
 
-	storedHashedPassphrase, err := base64.StdEncoding.DecodeString(storedWallet.HashedPassphrase)
+	tokens, err := s.issueLoginTokens(ctx, userID, machineID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode stored hash: %w", err)
+		return nil, err
 	}
 
-	// Hash the provided passphrase with the stored salt
-	currentHashedPassphrase := argon2.IDKey([]byte(passphrase), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+	// Shown on the account screen; a failure must not block the login
+	now := s.clock.Now()
+	storedWallet.LastLoginAt = &now
 
-	// Compare the hashes in constant time
-	if subtle.ConstantTimeCompare(currentHashedPassphrase, storedHashedPassphrase) != 1 {
-		return nil, errors.New("invalid passphrase")
+	// The passphrase is only ever known here, so this is where hashes move to changed parameters
+	if argon2ParamsOf(storedWallet.PassphraseParams) != s.hashParams {
+		if err := s.setPassphrase(storedWallet, passphrase); err != nil {
+			fmt.Printf("Warning: failed to rehash passphrase: %v\n", err)
+		}
+	}
+
+	if err := s.saveWallet(ctx, storedWallet); err != nil {
+		fmt.Printf("Warning: failed to record last login: %v\n", err)
 	}
 
-	// Generate tokens
-	accessToken, err := s.generateAccessToken(userID)
+	return tokens, nil
+}
+
+// issueLoginTokens starts a login session for an authenticated user and issues its tokens
+func (s *AuthService) issueLoginTokens(ctx context.Context, userID uuid.UUID, machineID string) (*types.AuthTokens, error) {
+	accessToken, err := s.generateAccessToken(userID, machineID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate access token: %w", err)
 	}
 
-	refreshToken, err := s.generateRefreshToken(userID)
+	session, err := s.startSession(ctx, userID, machineID)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := s.generateRefreshToken(userID, machineID, session.ID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate refresh token: %w", err)
 	}
 
-	tokens := &types.AuthTokens{
+	return &types.AuthTokens{
 		AccessToken:  accessToken,
 		RefreshToken: refreshToken,
-		ExpiresAt:    time.Now().Add(24 * time.Hour), // 24 hours
+		ExpiresAt:    s.clock.Now().Add(24 * time.Hour), // 24 hours
+	}, nil
+}
+
+// GetWalletInfo returns the account summary of a wallet. Storage usage is filled in by the caller.
+func (s *AuthService) GetWalletInfo(ctx context.Context, userID uuid.UUID) (*types.WalletInfo, error) {
+	wallet, err := s.getWallet(ctx, userID)
+	if err != nil {
+		return nil, err
 	}
 
-	return tokens, nil
+	machines, err := s.GetMachines(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	active := 0
+	for _, machine := range machines {
+		if machine.Active {
+			active++
+		}
+	}
+
+	plan := wallet.Plan
+	if plan == "" {
+		plan = types.DefaultPlan
+	}
+
+	return &types.WalletInfo{
+		UID:         wallet.UID,
+		CreatedAt:   wallet.CreatedAt,
+		LastLoginAt: wallet.LastLoginAt,
+		ExpiresAt:   wallet.ExpiresAt,
+		Machines:    active,
+		Plan:        plan,
+		Security: types.WalletSecurity{
+			TOTPEnabled:    wallet.TOTPSecret != "",
+			RecoveryKeySet: wallet.HashedRecoveryKey != "",
+		},
+	}, nil
+}
+
+// getWallet loads a wallet including its salts and hashes
+func (s *AuthService) getWallet(ctx context.Context, userID uuid.UUID) (*types.Wallet, error) {
+	walletKey := fmt.Sprintf("wallet:%s", userID.String())
+	data, err := s.db.Get(ctx, walletKey)
+	if err != nil {
+		return nil, fmt.Errorf("user not found or failed to retrieve wallet: %w", err)
+	}
+
+	var wallet types.Wallet
+	if err := types.WalletFromJSON([]byte(data), &wallet); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal wallet data: %w", err)
+	}
+
+	return &wallet, nil
+}
+
+func (s *AuthService) saveWallet(ctx context.Context, wallet *types.Wallet) error {
+	walletKey := fmt.Sprintf("wallet:%s", wallet.UID.String())
+	walletData, err := types.WalletToJSON(wallet)
+	if err != nil {
+		return fmt.Errorf("failed to marshal wallet: %w", err)
+	}
+	if err := s.db.Set(ctx, walletKey, string(walletData), 0); err != nil {
+		return fmt.Errorf("failed to save wallet: %w", err)
+	}
+	return nil
+}
+
+// setPassphrase hashes passphrase with the configured parameters and stores the hash, salt and parameters in the wallet
+func (s *AuthService) setPassphrase(wallet *types.Wallet, passphrase string) error {
+	salt, hash, err := hashSecret(passphrase, s.hashParams)
+	if err != nil {
+		return err
+	}
+
+	params := s.hashParams
+	wallet.Salt = salt
+	wallet.HashedPassphrase = hash
+	wallet.PassphraseParams = &params
+	return nil
+}
+
+// checkPassphrase verifies passphrase against the wallet's hash, using the parameters it was hashed with
+func checkPassphrase(wallet *types.Wallet, passphrase string) error {
+	return verifySecret(passphrase, wallet.Salt, wallet.HashedPassphrase, argon2ParamsOf(wallet.PassphraseParams))
+}
+
+// burnSecretCheck takes as long as checking secret against a wallet's hash, for the requests that
+// have no wallet to check against: answering those faster would tell which wallets exist
+func (s *AuthService) burnSecretCheck(secret string) {
+	_ = verifySecret(secret, dummySalt, dummyHash, s.hashParams)
+}
+
+// argon2ParamsOf returns the parameters recorded with a hash, or the legacy ones if none were recorded
+func argon2ParamsOf(params *types.Argon2Params) types.Argon2Params {
+	if params == nil {
+		return legacyArgon2Params
+	}
+	return *params
+}
+
+// hashSecret hashes a passphrase or recovery code with Argon2id and a fresh salt.
+// Both are returned base64 encoded, as stored in the wallet.
+func hashSecret(secret string, params types.Argon2Params) (salt string, hash string, err error) {
+	rawSalt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(rawSalt); err != nil {
+		return "", "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	rawHash := argon2.IDKey([]byte(secret), rawSalt, params.Time, params.Memory, params.Threads, argon2KeyLen)
+	return base64.StdEncoding.EncodeToString(rawSalt), base64.StdEncoding.EncodeToString(rawHash), nil
+}
+
+// verifySecret checks a passphrase or recovery code against its stored salt and hash in constant time
+func verifySecret(secret, salt, hash string, params types.Argon2Params) error {
+	rawSalt, err := base64.StdEncoding.DecodeString(salt)
+	if err != nil {
+		return fmt.Errorf("failed to decode salt: %w", err)
+	}
+
+	storedHash, err := base64.StdEncoding.DecodeString(hash)
+	if err != nil {
+		return fmt.Errorf("failed to decode stored hash: %w", err)
+	}
+
+	currentHash := argon2.IDKey([]byte(secret), rawSalt, params.Time, params.Memory, params.Threads, argon2KeyLen)
+	if subtle.ConstantTimeCompare(currentHash, storedHash) != 1 {
+		return errors.New("secret does not match")
+	}
+	return nil
 }
 
 // ValidateToken validates a JWT token and returns the user ID
 func (s *AuthService) ValidateToken(tokenString string) (uuid.UUID, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return s.jwtSecret, nil
-	})
-
+	claims, err := s.ParseToken(tokenString)
 	if err != nil {
 		return uuid.Nil, err
 	}
 
+	return claims.UserID, nil
+}
+
+// ParseToken validates a JWT token and returns its claims
+func (s *AuthService) ParseToken(tokenString string) (*types.TokenClaims, error) {
+	// Tokens minted by another instance sharing the secret carry a different issuer and audience
+	token, err := s.signer.Parse(tokenString, jwt.WithIssuer(s.issuer), jwt.WithAudience(s.issuer), jwt.WithTimeFunc(s.clock.Now))
+
+	if err != nil {
+		return nil, err
+	}
+
 	if !token.Valid {
-		return uuid.Nil, errors.New("invalid token")
+		return nil, errors.New("invalid token")
 	}
 
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok {
-		return uuid.Nil, errors.New("invalid token claims")
+		return nil, errors.New("invalid token claims")
 	}
 
 	userIDStr, ok := claims["user_id"].(string)
 	if !ok {
-		return uuid.Nil, errors.New("user_id not found in token")
+		return nil, errors.New("user_id not found in token")
 	}
 
 	userID, err := uuid.Parse(userIDStr)
 	if err != nil {
-		return uuid.Nil, fmt.Errorf("invalid user_id format: %w", err)
+		return nil, fmt.Errorf("invalid user_id format: %w", err)
+	}
+
+	result := &types.TokenClaims{UserID: userID}
+	result.Type, _ = claims["type"].(string)
+	result.TokenID, _ = claims["jti"].(string)
+	result.MachineID, _ = claims["machine_id"].(string)
+	result.SessionID, _ = claims["sid"].(string)
+	if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+		result.ExpiresAt = exp.Time
+	}
+
+	if result.Type == "guest" {
+		if resources, ok := claims["resources"].([]interface{}); ok {
+			for _, resource := range resources {
+				if r, ok := resource.(string); ok {
+					result.Resources = append(result.Resources, r)
+				}
+			}
+		}
+		result.MetadataOnly, _ = claims["metadata_only"].(bool)
+	}
+
+	if result.Type == "scoped" {
+		result.Scopes = []string{}
+		if scopes, ok := claims["scopes"].([]interface{}); ok {
+			for _, scope := range scopes {
+				if sc, ok := scope.(string); ok {
+					result.Scopes = append(result.Scopes, sc)
+				}
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// VerifyToken validates a JWT token like ParseToken and additionally rejects revoked tokens
+func (s *AuthService) VerifyToken(ctx context.Context, tokenString string) (*types.TokenClaims, error) {
+	claims, err := s.ParseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.CheckClaims(ctx, claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// CheckClaims checks that verified claims are still good: that the token hasn't been revoked,
+// its wallet deleted or its machine deactivated since. Long-lived connections call it again
+// while they stay open.
+func (s *AuthService) CheckClaims(ctx context.Context, claims *types.TokenClaims) error {
+	revoked, err := s.isRevoked(ctx, claims)
+	if err != nil {
+		return fmt.Errorf("failed to check token revocation: %w", err)
+	}
+	if revoked {
+		return errors.New("token has been revoked")
+	}
+
+	if claims.MachineID != "" {
+		if err := s.checkMachineTokens(ctx, claims.UserID, claims.MachineID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// revokedTokenKey returns the denylist entry of a revoked token
+func revokedTokenKey(tokenID string) string {
+	return fmt.Sprintf("revoked_tokens:%s", tokenID)
+}
+
+// RevokeToken denylists a token until it would have expired anyway.
+// Tokens issued before token IDs were introduced cannot be revoked and are ignored.
+func (s *AuthService) RevokeToken(ctx context.Context, claims *types.TokenClaims) error {
+	if claims.TokenID == "" {
+		return nil
+	}
+
+	ttl := int64(claims.ExpiresAt.Sub(s.clock.Now()).Seconds()) + 1
+	if claims.ExpiresAt.IsZero() || ttl <= 0 {
+		return nil
+	}
+
+	return s.db.Set(ctx, revokedTokenKey(claims.TokenID), claims.UserID.String(), ttl)
+}
+
+// isRevoked reports whether the token was revoked individually or its wallet was deleted
+func (s *AuthService) isRevoked(ctx context.Context, claims *types.TokenClaims) (bool, error) {
+	keys := []string{deletedWalletKey(claims.UserID)}
+	if claims.TokenID != "" {
+		keys = append(keys, revokedTokenKey(claims.TokenID))
+	}
+
+	values, err := s.db.MGet(ctx, keys...)
+	if err != nil {
+		return false, err
+	}
+	for _, value := range values {
+		if value != nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Logout revokes the presented access token and, if given, the refresh token of the same session
+func (s *AuthService) Logout(ctx context.Context, accessClaims *types.TokenClaims, refreshToken string) error {
+	if err := s.RevokeToken(ctx, accessClaims); err != nil {
+		return fmt.Errorf("failed to revoke access token: %w", err)
 	}
 
-	return userID, nil
+	if refreshToken == "" {
+		return nil
+	}
+
+	refreshClaims, err := s.ParseToken(refreshToken)
+	if err != nil {
+		return fmt.Errorf("invalid refresh token: %w", err)
+	}
+	if refreshClaims.Type != "refresh" {
+		return errors.New("invalid refresh token: not a refresh token")
+	}
+	if refreshClaims.UserID != accessClaims.UserID {
+		return errors.New("refresh token belongs to a different user")
+	}
+
+	if err := s.RevokeToken(ctx, refreshClaims); err != nil {
+		return fmt.Errorf("failed to revoke refresh token: %w", err)
+	}
+
+	// Earlier refresh tokens of the session must not outlive the logout either
+	if refreshClaims.SessionID != "" {
+		if err := s.endSession(ctx, refreshClaims.UserID, refreshClaims.SessionID); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // RefreshToken generates new tokens from a refresh token
-func (s *AuthService) RefreshToken(refreshToken string) (*types.AuthTokens, error) {
-	userID, err := s.ValidateToken(refreshToken)
+func (s *AuthService) RefreshToken(ctx context.Context, refreshToken string) (*types.AuthTokens, error) {
+	claims, err := s.VerifyToken(ctx, refreshToken)
+	if err != nil {
+		return nil, fmt.Errorf("invalid refresh token: %w", err)
+	}
+
+	// Access, guest and scoped tokens are short-lived or limited and must never be exchanged for a
+	// week-long refresh token. Every refresh token ever issued carries its type.
+	if claims.Type != "refresh" {
+		return nil, fmt.Errorf("invalid refresh token: %q tokens cannot be refreshed", claims.Type)
+	}
+	userID := claims.UserID
+
+	// Refresh tokens are single use: a stolen one stops working once either holder exchanges it
+	if err := s.RevokeToken(ctx, claims); err != nil {
+		return nil, fmt.Errorf("failed to revoke refresh token: %w", err)
+	}
+
+	// Refresh tokens issued before sessions were tracked start a new session
+	var session *types.Session
+	if claims.SessionID != "" {
+		session, err = s.touchSession(ctx, userID, claims.SessionID)
+	} else {
+		session, err = s.startSession(ctx, userID, claims.MachineID)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("invalid refresh token: %w", err)
 	}
 
-	accessToken, err := s.generateAccessToken(userID)
+	accessToken, err := s.generateAccessToken(userID, claims.MachineID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate access token: %w", err)
 	}
 
-	newRefreshToken, err := s.generateRefreshToken(userID)
+	newRefreshToken, err := s.generateRefreshToken(userID, claims.MachineID, session.ID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate refresh token: %w", err)
 	}
@@ -185,32 +592,202 @@ func (s *AuthService) RefreshToken(refreshToken string) (*types.AuthTokens, erro
 	tokens := &types.AuthTokens{
 		AccessToken:  accessToken,
 		RefreshToken: newRefreshToken,
-		ExpiresAt:    time.Now().Add(24 * time.Hour),
+		ExpiresAt:    s.clock.Now().Add(24 * time.Hour),
 	}
 
 	return tokens, nil
 }
 
-func (s *AuthService) generateAccessToken(userID uuid.UUID) (string, error) {
+// generateAccessToken issues a token, bound to machineID if the client identified its machine at login
+func (s *AuthService) generateAccessToken(userID uuid.UUID, machineID string) (string, error) {
 	claims := jwt.MapClaims{
 		"user_id": userID.String(),
 		"type":    "access",
-		"exp":     time.Now().Add(1 * time.Hour).Unix(), // 1 hour
-		"iat":     time.Now().Unix(),
+		"jti":     uuid.New().String(),
+		"exp":     s.clock.Now().Add(accessTokenTTL).Unix(),
+		"iat":     s.clock.Now().Unix(),
+	}
+	if machineID != "" {
+		claims["machine_id"] = machineID
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(s.jwtSecret)
+	return s.signToken(claims)
 }
 
-func (s *AuthService) generateRefreshToken(userID uuid.UUID) (string, error) {
+// generateRefreshToken issues a token of the given session, bound to machineID if the client identified its machine at login
+func (s *AuthService) generateRefreshToken(userID uuid.UUID, machineID string, sessionID string) (string, error) {
 	claims := jwt.MapClaims{
 		"user_id": userID.String(),
 		"type":    "refresh",
-		"exp":     time.Now().Add(7 * 24 * time.Hour).Unix(), // 7 days
-		"iat":     time.Now().Unix(),
+		"jti":     uuid.New().String(),
+		"sid":     sessionID,
+		"exp":     s.clock.Now().Add(refreshTokenTTL).Unix(), // 7 days
+		"iat":     s.clock.Now().Unix(),
+	}
+	if machineID != "" {
+		claims["machine_id"] = machineID
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(s.jwtSecret)
+	return s.signToken(claims)
+}
+
+// signToken binds claims to this instance and signs them
+func (s *AuthService) signToken(claims jwt.MapClaims) (string, error) {
+	claims["iss"] = s.issuer
+	claims["aud"] = s.issuer
+
+	return s.signer.Sign(claims)
+}
+
+// JWKS returns the public keys other services can verify this instance's tokens with
+func (s *AuthService) JWKS() types.JWKS {
+	return s.signer.JWKS()
+}
+
+// GuestResources are the resources a guest token can be scoped to
+var GuestResources = []string{"threads", "messages", "settings", "memories", "folders", "changes"}
+
+// CreateGuestToken mints a short-lived, read-only token scoped to the requested resources
+func (s *AuthService) CreateGuestToken(ctx context.Context, userID uuid.UUID, req types.GuestTokenRequest) (*types.GuestToken, error) {
+	resources := req.Resources
+	if len(resources) == 0 {
+		resources = GuestResources
+	}
+	for _, resource := range resources {
+		if !slices.Contains(GuestResources, resource) {
+			return nil, fmt.Errorf("unknown resource %q", resource)
+		}
+	}
+
+	ttl := guestTokenDefaultTTL
+	if req.TTLMinutes > 0 {
+		ttl = time.Duration(req.TTLMinutes) * time.Minute
+	}
+	if ttl > guestTokenMaxTTL {
+		return nil, fmt.Errorf("guest tokens can be valid for at most %s", guestTokenMaxTTL)
+	}
+
+	now := s.clock.Now()
+	info := types.GuestTokenInfo{
+		TokenID:      uuid.New().String(),
+		Resources:    resources,
+		MetadataOnly: !req.IncludePayloads,
+		IssuedAt:     now,
+		ExpiresAt:    now.Add(ttl),
+	}
+
+	claims := jwt.MapClaims{
+		"user_id":       userID.String(),
+		"type":          "guest",
+		"jti":           info.TokenID,
+		"resources":     info.Resources,
+		"metadata_only": info.MetadataOnly,
+		"exp":           info.ExpiresAt.Unix(),
+		"iat":           now.Unix(),
+	}
+
+	signed, err := s.signToken(claims)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign guest token: %w", err)
+	}
+
+	// Keep a record of minted guest tokens for the user's audit trail
+	data, err := json.Marshal(info)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal guest token info: %w", err)
+	}
+	key := fmt.Sprintf("guest_tokens:%s", userID.String())
+	if err := s.db.HSet(ctx, key, info.TokenID, string(data)); err != nil {
+		return nil, fmt.Errorf("failed to record guest token: %w", err)
+	}
+
+	return &types.GuestToken{Token: signed, GuestTokenInfo: info}, nil
+}
+
+// ListGuestTokens returns the guest tokens a user has minted that have not yet expired
+func (s *AuthService) ListGuestTokens(ctx context.Context, userID uuid.UUID) ([]types.GuestTokenInfo, error) {
+	key := fmt.Sprintf("guest_tokens:%s", userID.String())
+	entries, err := s.db.HGetAll(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get guest tokens: %w", err)
+	}
+
+	now := s.clock.Now()
+	tokens := []types.GuestTokenInfo{}
+	for tokenID, data := range entries {
+		var info types.GuestTokenInfo
+		if err := json.Unmarshal([]byte(data), &info); err != nil {
+			continue
+		}
+
+		// Drop expired records as we go
+		if info.ExpiresAt.Before(now) {
+			if _, err := s.db.HDel(ctx, key, tokenID); err != nil {
+				fmt.Printf("Warning: failed to prune expired guest token: %v\n", err)
+			}
+			continue
+		}
+
+		tokens = append(tokens, info)
+	}
+
+	if len(tokens) > 0 {
+		keys := make([]string, 0, 2*len(tokens))
+		for _, info := range tokens {
+			keys = append(keys, guestTokenViewsKey(info.TokenID), guestTokenSeenKey(info.TokenID))
+		}
+		values, err := s.db.MGet(ctx, keys...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get guest token views: %w", err)
+		}
+		for i := range tokens {
+			if views, ok := values[2*i].(string); ok {
+				tokens[i].Views, _ = strconv.ParseInt(views, 10, 64)
+			}
+			if seen, ok := values[2*i+1].(string); ok {
+				if ms, err := strconv.ParseInt(seen, 10, 64); err == nil {
+					lastAccess := time.UnixMilli(ms).UTC()
+					tokens[i].LastAccessAt = &lastAccess
+				}
+			}
+		}
+	}
+
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].IssuedAt.Before(tokens[j].IssuedAt)
+	})
+
+	return tokens, nil
+}
+
+// guestTokenViewsKey counts the requests made with a guest token
+func guestTokenViewsKey(tokenID string) string {
+	return fmt.Sprintf("guest_token_views:%s", tokenID)
+}
+
+// guestTokenSeenKey holds the unix ms time of the last request made with a guest token
+func guestTokenSeenKey(tokenID string) string {
+	return fmt.Sprintf("guest_token_seen:%s", tokenID)
+}
+
+// RecordGuestAccess counts a request made with a guest token, so the user who shared it can see
+// how often and when it was last used. The counters expire with the token; nothing about the
+// requester is stored.
+func (s *AuthService) RecordGuestAccess(ctx context.Context, claims *types.TokenClaims) error {
+	ttl := int64(claims.ExpiresAt.Sub(s.clock.Now()).Seconds()) + 1
+	if claims.TokenID == "" || ttl <= 1 {
+		return nil
+	}
+
+	// The counter is created with the token's lifetime, which incrementing it keeps
+	if _, err := s.db.SetNX(ctx, guestTokenViewsKey(claims.TokenID), 0, ttl); err != nil {
+		return fmt.Errorf("failed to count guest token view: %w", err)
+	}
+	if _, err := s.db.Incr(ctx, guestTokenViewsKey(claims.TokenID)); err != nil {
+		return fmt.Errorf("failed to count guest token view: %w", err)
+	}
+	if err := s.db.Set(ctx, guestTokenSeenKey(claims.TokenID), s.clock.Now().UnixMilli(), ttl); err != nil {
+		return fmt.Errorf("failed to record guest token access: %w", err)
+	}
+	return nil
 }
@@ -0,0 +1,211 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/database"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// Sync batches are applied in one storage transaction, through the same service methods as single
+// writes: every operation runs against the stored state as the batch's earlier operations leave
+// it, and the batch's writes and change-log entries take effect together, or not at all if one
+// operation fails. Readers and the change feed never see part of a batch. Threads are restored
+// from the archival store before the transaction, since that store can't take part in it.
+
+// ErrInvalidBatchOperation is returned for batch operations of unknown kind or with missing or invalid IDs and payloads
+var ErrInvalidBatchOperation = errors.New("invalid batch operation")
+
+// errBatchDryRun rolls back the transaction of a dry run
+var errBatchDryRun = errors.New("batch dry run")
+
+// errBatchThreadArchived rolls back a batch touching a thread that was archived after it was restored
+var errBatchThreadArchived = errors.New("thread archived during batch")
+
+// batchAttempts bounds how often a batch is retried when its threads are archived while it runs
+const batchAttempts = 3
+
+// BatchError reports the operation a batch failed at. Nothing of the batch was kept.
+type BatchError struct {
+	Index int
+	Err   error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("batch operation %d: %v", e.Index, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// BatchApplier applies sync batches atomically
+type BatchApplier struct {
+	sync *SyncService
+}
+
+func NewBatchApplier(sync *SyncService) *BatchApplier {
+	return &BatchApplier{sync: sync}
+}
+
+// Apply applies ops to userID's data in order: all of them, or none and a *BatchError naming the
+// operation that failed. Messages created without an ID get one.
+func (b *BatchApplier) Apply(ctx context.Context, userID uuid.UUID, ops []types.BatchOperation, machineID string) (*types.BatchResult, error) {
+	return b.run(ctx, userID, ops, machineID, false)
+}
+
+// Validate runs ops like Apply and rolls them back, reporting what each operation would do, or a
+// *BatchError naming the operation that would fail. Messages created without an ID are reported
+// with one, which the batch gets again when applied.
+func (b *BatchApplier) Validate(ctx context.Context, userID uuid.UUID, ops []types.BatchOperation) (*types.BatchResult, error) {
+	result, err := b.run(ctx, userID, ops, "", true)
+	if err != nil {
+		return nil, err
+	}
+	result.DryRun = true
+	return result, nil
+}
+
+// run checks ops, restores their threads and applies them in a transaction, which a dry run rolls back
+func (b *BatchApplier) run(ctx context.Context, userID uuid.UUID, ops []types.BatchOperation, machineID string, dryRun bool) (*types.BatchResult, error) {
+	threadIDs := make(map[string]bool)
+	for i := range ops {
+		if err := checkBatchOperation(&ops[i]); err != nil {
+			return nil, &BatchError{Index: i, Err: err}
+		}
+		threadIDs[batchThreadID(&ops[i])] = true
+	}
+
+	for attempt := 1; ; attempt++ {
+		for threadID := range threadIDs {
+			if err := b.sync.rehydrate(ctx, threadID); err != nil {
+				return nil, err
+			}
+		}
+
+		var result *types.BatchResult
+		err := b.sync.atomically(ctx, func(tx *SyncService) error {
+			if err := tx.checkRestored(ctx, threadIDs); err != nil {
+				return err
+			}
+			result = &types.BatchResult{Results: make([]types.BatchOperationResult, 0, len(ops))}
+			for i := range ops {
+				applied, err := tx.applyBatchOperation(ctx, userID, &ops[i], machineID)
+				if err != nil {
+					return &BatchError{Index: i, Err: err}
+				}
+				applied.Index = i
+				result.Results = append(result.Results, applied)
+			}
+			if dryRun {
+				return errBatchDryRun
+			}
+			return nil
+		})
+		switch {
+		case err == nil, errors.Is(err, errBatchDryRun):
+			return result, nil
+		case !errors.Is(err, errBatchThreadArchived) || attempt == batchAttempts:
+			return nil, err
+		}
+	}
+}
+
+// checkRestored fails with errBatchThreadArchived if one of the threads is archived
+func (s *SyncService) checkRestored(ctx context.Context, threadIDs map[string]bool) error {
+	for threadID := range threadIDs {
+		if _, err := s.db.Get(ctx, fmt.Sprintf("archived_threads:%s", threadID)); err == nil {
+			return fmt.Errorf("%w: %s", errBatchThreadArchived, threadID)
+		} else if !database.IsNotFound(err) {
+			return fmt.Errorf("failed to get archive stub: %w", err)
+		}
+	}
+	return nil
+}
+
+// checkBatchOperation checks an operation's kind, IDs and payload, normalizing its thread ID and
+// giving messages created without an ID one
+func checkBatchOperation(op *types.BatchOperation) error {
+	if op.Op == types.BatchUpsertThread {
+		if op.Thread == nil || op.Thread.ID == uuid.Nil {
+			return fmt.Errorf("%w: %s needs a thread with an ID", ErrInvalidBatchOperation, op.Op)
+		}
+		return nil
+	}
+
+	threadID, err := uuid.Parse(op.ThreadID)
+	if err != nil {
+		return fmt.Errorf("%w: %s needs a valid thread_id", ErrInvalidBatchOperation, op.Op)
+	}
+	op.ThreadID = threadID.String()
+
+	switch op.Op {
+	case types.BatchDeleteThread:
+		return nil
+	case types.BatchCreateMessage:
+		if op.Message == nil {
+			return fmt.Errorf("%w: %s needs a message", ErrInvalidBatchOperation, op.Op)
+		}
+		if op.Message.ID == "" {
+			op.Message.ID = uuid.New().String()
+		}
+		if err := types.ValidateMessageID(op.Message.ID); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidBatchOperation, err)
+		}
+		return nil
+	case types.BatchDeleteMessage:
+		if err := types.ValidateMessageID(op.MessageID); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidBatchOperation, err)
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: unknown operation %q", ErrInvalidBatchOperation, op.Op)
+}
+
+// batchThreadID returns the thread a checked operation writes to
+func batchThreadID(op *types.BatchOperation) string {
+	if op.Op == types.BatchUpsertThread {
+		return op.Thread.ID.String()
+	}
+	return op.ThreadID
+}
+
+// applyBatchOperation applies a checked operation as its own endpoint would, for userID
+func (s *SyncService) applyBatchOperation(ctx context.Context, userID uuid.UUID, op *types.BatchOperation, machineID string) (types.BatchOperationResult, error) {
+	result := types.BatchOperationResult{Op: op.Op}
+	var err error
+	switch op.Op {
+	case types.BatchUpsertThread:
+		op.Thread.UserID = userID
+		result.ID = op.Thread.ID.String()
+		result.Created, result.Conflict, err = s.UpsertThread(ctx, op.Thread, machineID)
+	case types.BatchCreateMessage:
+		result.ID, result.ThreadID = op.Message.ID, op.ThreadID
+		if err = s.CheckThreadOwner(ctx, userID, op.ThreadID); err == nil {
+			result.Created, err = s.createMessage(ctx, userID, op.ThreadID, op.Message, machineID)
+		}
+	case types.BatchDeleteThread:
+		result.ID = op.ThreadID
+		err = s.DeleteThread(ctx, userID, uuid.MustParse(op.ThreadID), machineID)
+	case types.BatchDeleteMessage:
+		result.ID, result.ThreadID = op.MessageID, op.ThreadID
+		if err = s.CheckThreadOwner(ctx, userID, op.ThreadID); err == nil {
+			err = s.DeleteMessage(ctx, userID, op.ThreadID, op.MessageID, machineID)
+		}
+	}
+	return result, err
+}
+
+// optional turns a missing record into nil
+func optional(value string, err error) (*string, error) {
+	if database.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &value, nil
+}
@@ -0,0 +1,165 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// newThreadBatch returns a batch creating a thread with messages of the given IDs
+func newThreadBatch(threadID uuid.UUID, messageIDs ...string) []types.BatchOperation {
+	ops := []types.BatchOperation{{Op: types.BatchUpsertThread, Thread: &types.Thread{ID: threadID, Version: 1}}}
+	for _, id := range messageIDs {
+		ops = append(ops, types.BatchOperation{
+			Op:       types.BatchCreateMessage,
+			ThreadID: threadID.String(),
+			Message:  &types.Message{ID: id, Role: "user", Content: "c"},
+		})
+	}
+	return ops
+}
+
+// changeLogLength returns how many change-log entries the user has
+func changeLogLength(t *testing.T, s *SyncService, userID uuid.UUID) int64 {
+	t.Helper()
+	length, err := s.db.XLen(context.Background(), changeLogKey(userID))
+	if err != nil {
+		t.Fatalf("XLen: %v", err)
+	}
+	return length
+}
+
+func TestApplyBatchAppliesEveryOperation(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestSync(t, types.Quotas{})
+	batches := NewBatchApplier(s)
+	userID, threadID := uuid.New(), uuid.Must(uuid.NewV7())
+
+	ops := append(newThreadBatch(threadID, "a", "b"), types.BatchOperation{
+		Op: types.BatchDeleteMessage, ThreadID: threadID.String(), MessageID: "a",
+	})
+	result, err := batches.Apply(ctx, userID, ops, "")
+	if err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if len(result.Results) != len(ops) {
+		t.Fatalf("got %d results, want %d", len(result.Results), len(ops))
+	}
+	if !result.Results[0].Created || !result.Results[1].Created {
+		t.Errorf("results = %+v, want the thread and first message created", result.Results)
+	}
+
+	messages, err := s.GetMessages(ctx, threadID.String(), nil)
+	if err != nil {
+		t.Fatalf("GetMessages: %v", err)
+	}
+	if len(messages) != 1 || messages[0].ID != "b" {
+		t.Errorf("messages = %+v, want only b", messages)
+	}
+	if count := messageCount(t, s, threadID.String()); count != 1 {
+		t.Errorf("count = %d, want 1", count)
+	}
+	if length := changeLogLength(t, s, userID); length != int64(len(ops)) {
+		t.Errorf("change log holds %d entries, want %d", length, len(ops))
+	}
+}
+
+func TestApplyBatchKeepsNothingOfFailingBatch(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestSync(t, types.Quotas{MessagesPerThread: 2})
+	batches := NewBatchApplier(s)
+	userID, threadID := uuid.New(), uuid.Must(uuid.NewV7())
+
+	_, err := batches.Apply(ctx, userID, newThreadBatch(threadID, "a", "b", "c"), "")
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) || batchErr.Index != 3 || !errors.Is(err, ErrThreadMessageLimit) {
+		t.Fatalf("Apply: error = %v, want ErrThreadMessageLimit at operation 3", err)
+	}
+
+	threads, err := s.GetThreads(ctx, userID, nil)
+	if err != nil {
+		t.Fatalf("GetThreads: %v", err)
+	}
+	if len(threads) != 0 {
+		t.Errorf("got %d threads, want none", len(threads))
+	}
+	messages, err := s.GetMessages(ctx, threadID.String(), nil)
+	if err != nil {
+		t.Fatalf("GetMessages: %v", err)
+	}
+	if len(messages) != 0 {
+		t.Errorf("got %d messages, want none", len(messages))
+	}
+	if count := messageCount(t, s, threadID.String()); count != 0 {
+		t.Errorf("count = %d, want 0", count)
+	}
+	if length := changeLogLength(t, s, userID); length != 0 {
+		t.Errorf("change log holds %d entries, want none", length)
+	}
+
+	// The thread is free to be created by a batch that fits
+	if _, err := batches.Apply(ctx, userID, newThreadBatch(threadID, "a", "b"), ""); err != nil {
+		t.Fatalf("Apply of a batch within the limit: %v", err)
+	}
+	if count := messageCount(t, s, threadID.String()); count != 2 {
+		t.Errorf("count = %d, want 2", count)
+	}
+}
+
+func TestApplyBatchLeavesEarlierStateOfFailingBatch(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestSync(t, types.Quotas{})
+	batches := NewBatchApplier(s)
+	userID, threadID := uuid.New(), uuid.Must(uuid.NewV7())
+
+	if _, err := batches.Apply(ctx, userID, newThreadBatch(threadID, "a"), ""); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	ops := []types.BatchOperation{
+		{Op: types.BatchDeleteMessage, ThreadID: threadID.String(), MessageID: "a"},
+		{Op: types.BatchCreateMessage, ThreadID: uuid.NewString(), Message: &types.Message{ID: "b", Role: "user", Content: "c"}},
+	}
+	if _, err := batches.Apply(ctx, userID, ops, ""); !errors.Is(err, ErrThreadNotFound) {
+		t.Fatalf("Apply: error = %v, want ErrThreadNotFound", err)
+	}
+
+	messages, err := s.GetMessages(ctx, threadID.String(), nil)
+	if err != nil {
+		t.Fatalf("GetMessages: %v", err)
+	}
+	if len(messages) != 1 || messages[0].ID != "a" {
+		t.Errorf("messages = %+v, want a kept", messages)
+	}
+	if count := messageCount(t, s, threadID.String()); count != 1 {
+		t.Errorf("count = %d, want 1", count)
+	}
+}
+
+func TestValidateBatchWritesNothing(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestSync(t, types.Quotas{})
+	batches := NewBatchApplier(s)
+	userID, threadID := uuid.New(), uuid.Must(uuid.NewV7())
+
+	result, err := batches.Validate(ctx, userID, newThreadBatch(threadID, "a", ""))
+	if err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if !result.DryRun || len(result.Results) != 3 || !result.Results[2].Created || result.Results[2].ID == "" {
+		t.Errorf("result = %+v, want a dry run creating the thread and two messages", result)
+	}
+
+	threads, err := s.GetThreads(ctx, userID, nil)
+	if err != nil {
+		t.Fatalf("GetThreads: %v", err)
+	}
+	if len(threads) != 0 {
+		t.Errorf("got %d threads, want none", len(threads))
+	}
+	if length := changeLogLength(t, s, userID); length != 0 {
+		t.Errorf("change log holds %d entries, want none", length)
+	}
+}
@@ -0,0 +1,122 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/database"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// Bounds of a benchmark request, small enough that it can't be used to load the server
+const (
+	defaultBenchmarkPayloadBytes = 1024
+	maxBenchmarkPayloadBytes     = 64 * 1024
+	defaultBenchmarkIterations   = 5
+	maxBenchmarkIterations       = 20
+)
+
+// ErrInvalidBenchmark is returned for benchmark requests outside the allowed bounds
+var ErrInvalidBenchmark = errors.New("invalid benchmark request")
+
+// Benchmark measures storage latency with synthetic records that are deleted again afterwards.
+// The report's total time lets clients separate network latency from server time.
+func (s *SyncService) Benchmark(ctx context.Context, userID uuid.UUID, req types.BenchmarkRequest) (*types.BenchmarkReport, error) {
+	if req.PayloadBytes < 0 || req.Iterations < 0 {
+		return nil, fmt.Errorf("%w: payload_bytes and iterations must not be negative", ErrInvalidBenchmark)
+	}
+	payloadBytes := req.PayloadBytes
+	if payloadBytes == 0 {
+		payloadBytes = defaultBenchmarkPayloadBytes
+	}
+	if payloadBytes > maxBenchmarkPayloadBytes {
+		return nil, fmt.Errorf("%w: payload_bytes must be at most %d", ErrInvalidBenchmark, maxBenchmarkPayloadBytes)
+	}
+	iterations := req.Iterations
+	if iterations == 0 {
+		iterations = defaultBenchmarkIterations
+	}
+	if iterations > maxBenchmarkIterations {
+		return nil, fmt.Errorf("%w: iterations must be at most %d", ErrInvalidBenchmark, maxBenchmarkIterations)
+	}
+
+	// Random data so compression can't make the payload cheaper than a real encrypted record
+	raw := make([]byte, payloadBytes*3/4)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, fmt.Errorf("failed to generate payload: %w", err)
+	}
+	payload := base64.StdEncoding.EncodeToString(raw)
+
+	// Latencies are real elapsed time, so they are measured on the wall clock rather than s.clock
+	start := time.Now()
+	var roundTrip, write, read []time.Duration
+	for i := 0; i < iterations; i++ {
+		key := fmt.Sprintf("benchmark:%s:%s", userID.String(), uuid.New().String())
+
+		// A lookup of a missing key measures the bare storage round trip
+		t := time.Now()
+		if _, err := s.db.Get(ctx, key); err != nil && !database.IsNotFound(err) {
+			return nil, fmt.Errorf("round trip failed: %w", err)
+		}
+		roundTrip = append(roundTrip, time.Since(t))
+
+		// Expire the record in case the delete below never happens
+		t = time.Now()
+		if err := s.db.Set(ctx, key, payload, 60); err != nil {
+			return nil, fmt.Errorf("write failed: %w", err)
+		}
+		write = append(write, time.Since(t))
+
+		t = time.Now()
+		if _, err := s.db.Get(ctx, key); err != nil {
+			return nil, fmt.Errorf("read failed: %w", err)
+		}
+		read = append(read, time.Since(t))
+
+		if err := s.db.Del(ctx, key); err != nil {
+			fmt.Printf("Warning: failed to delete benchmark record %s: %v\n", key, err)
+		}
+	}
+
+	return &types.BenchmarkReport{
+		PayloadBytes: len(payload),
+		Iterations:   iterations,
+		RoundTrip:    latencyStats(roundTrip),
+		Write:        latencyStats(write),
+		Read:         latencyStats(read),
+		TotalMs:      durationMs(time.Since(start)),
+		MeasuredAt:   start,
+	}, nil
+}
+
+func latencyStats(samples []time.Duration) types.LatencyStats {
+	if len(samples) == 0 {
+		return types.LatencyStats{}
+	}
+
+	min, max, sum := samples[0], samples[0], time.Duration(0)
+	for _, sample := range samples {
+		if sample < min {
+			min = sample
+		}
+		if sample > max {
+			max = sample
+		}
+		sum += sample
+	}
+
+	return types.LatencyStats{
+		MinMs: durationMs(min),
+		AvgMs: durationMs(sum / time.Duration(len(samples))),
+		MaxMs: durationMs(max),
+	}
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
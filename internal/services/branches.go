@@ -0,0 +1,268 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/database"
+	"github.com/helioschat/sync/internal/types"
+)
+
+var (
+	// ErrThreadNotFound is returned when branching a thread the user doesn't have
+	ErrThreadNotFound = errors.New("thread not found")
+	// ErrThreadExists is returned when branching into a thread ID that is already in use
+	ErrThreadExists = errors.New("thread already exists")
+	// ErrBranchPointNotFound is returned when the message a branch should end at isn't in the source thread
+	ErrBranchPointNotFound = errors.New("branch point not found in source thread")
+)
+
+// Branches share the messages they inherit with the thread they were branched from instead of
+// copying them. A branch records which thread holds each inherited message; the holding thread
+// records which branches reference it, and copies a message into them before it changes or goes
+// away (copy-on-write), so a branch never loses or sees later edits of what it inherited.
+
+// threadRefsKey returns the hash of a branch's inherited messages: message ID → ID of the thread holding it
+func threadRefsKey(threadID string) string {
+	return fmt.Sprintf("thread_refs:%s", threadID)
+}
+
+// threadBranchesKey returns the set of branches referencing messages held by a thread
+func threadBranchesKey(threadID string) string {
+	return fmt.Sprintf("thread_branches:%s", threadID)
+}
+
+// BranchThread creates branch as a new thread holding the messages of source up to and including
+// upToMessageID, in message ID order. The messages are referenced, not copied. It returns how many
+// messages the branch inherited.
+func (s *SyncService) BranchThread(ctx context.Context, userID, sourceID uuid.UUID, branch *types.Thread, upToMessageID, machineID string) (int, error) {
+	if _, err := s.getThread(ctx, userID, sourceID); err != nil {
+		if database.IsNotFound(err) {
+			return 0, ErrThreadNotFound
+		}
+		return 0, err
+	}
+	if err := s.CheckThreadOwner(ctx, userID, sourceID.String()); err != nil {
+		return 0, err
+	}
+	if _, err := s.getThread(ctx, userID, branch.ID); err == nil {
+		return 0, fmt.Errorf("%w: %s", ErrThreadExists, branch.ID)
+	} else if !database.IsNotFound(err) {
+		return 0, err
+	}
+
+	source := sourceID.String()
+	if err := s.rehydrate(ctx, source); err != nil {
+		return 0, err
+	}
+	holders, err := s.messageHolders(ctx, source)
+	if err != nil {
+		return 0, err
+	}
+	if _, ok := holders[upToMessageID]; !ok {
+		return 0, fmt.Errorf("%w: %s", ErrBranchPointNotFound, upToMessageID)
+	}
+
+	// The holders learn about the branch first, so they copy before changing anything it references
+	inherited := make(map[string]string)
+	referenced := make(map[string]bool)
+	for messageID, holder := range holders {
+		if messageID > upToMessageID {
+			continue
+		}
+		inherited[messageID] = holder
+		if !referenced[holder] {
+			referenced[holder] = true
+			if err := s.db.SAdd(ctx, threadBranchesKey(holder), branch.ID.String()); err != nil {
+				return 0, fmt.Errorf("failed to register branch: %w", err)
+			}
+		}
+	}
+	for messageID, holder := range inherited {
+		if err := s.db.HSet(ctx, threadRefsKey(branch.ID.String()), messageID, holder); err != nil {
+			return 0, fmt.Errorf("failed to reference message %s: %w", messageID, err)
+		}
+	}
+	if err := s.recountMessages(ctx, branch.ID.String()); err != nil {
+		return 0, err
+	}
+
+	branch.UserID = userID
+	branch.ArchivedRemote, branch.Archived = false, false
+	if err := s.checkThreadTombstone(ctx, branch); err != nil {
+		return 0, err
+	}
+	if err := s.claimThread(ctx, userID, branch.ID.String()); err != nil {
+		return 0, err
+	}
+	if err := s.saveThread(ctx, branch); err != nil {
+		return 0, err
+	}
+	if err := s.clearThreadTombstone(ctx, userID, branch.ID); err != nil {
+		return 0, err
+	}
+
+	now := s.clock.Now()
+	if err := s.recordThreadMeta(ctx, branch, machineID, now); err != nil {
+		fmt.Printf("Warning: failed to record meta-history for thread %s: %v\n", branch.ID, err)
+	}
+
+	s.recordChange(ctx, changeRecord{
+		Resource:   "thread",
+		Operation:  "update",
+		ResourceID: branch.ID.String(),
+		UserID:     userID,
+		MachineID:  machineID,
+		Timestamp:  now,
+	})
+
+	return len(inherited), nil
+}
+
+// messageHolders returns the IDs of a thread's messages, each with the ID of the thread holding it
+func (s *SyncService) messageHolders(ctx context.Context, threadID string) (map[string]string, error) {
+	holders, err := s.db.HGetAll(ctx, threadRefsKey(threadID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get inherited messages: %w", err)
+	}
+	own, err := s.db.HGetAll(ctx, messagesKey(threadID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get messages: %w", err)
+	}
+	for messageID := range own {
+		holders[messageID] = threadID
+	}
+	return holders, nil
+}
+
+// loadInheritedMessages returns the raw messages a branch references, keyed by message ID
+func (s *SyncService) loadInheritedMessages(ctx context.Context, threadID string) (map[string]string, error) {
+	refs, err := s.db.HGetAll(ctx, threadRefsKey(threadID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get inherited messages: %w", err)
+	}
+
+	byHolder := make(map[string][]string)
+	for messageID, holder := range refs {
+		byHolder[holder] = append(byHolder[holder], messageID)
+	}
+
+	inherited := make(map[string]string, len(refs))
+	for holder, messageIDs := range byHolder {
+		if err := s.rehydrate(ctx, holder); err != nil {
+			return nil, err
+		}
+		values, err := s.db.HMGet(ctx, messagesKey(holder), messageIDs...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get inherited messages: %w", err)
+		}
+		for i, value := range values {
+			if data, ok := value.(string); ok {
+				inherited[messageIDs[i]] = data
+			}
+		}
+	}
+	return inherited, nil
+}
+
+// getInheritedMessage returns the raw message a branch references under messageID
+func (s *SyncService) getInheritedMessage(ctx context.Context, threadID, messageID string) (string, error) {
+	holder, err := s.db.HGet(ctx, threadRefsKey(threadID), messageID)
+	if err != nil {
+		return "", err
+	}
+	if err := s.rehydrate(ctx, holder); err != nil {
+		return "", err
+	}
+	return s.db.HGet(ctx, messagesKey(holder), messageID)
+}
+
+// detachBranches copies messages held by threadID into the branches referencing them, before they
+// change or are deleted. Without messageIDs, every referenced message is copied and the thread
+// stops holding messages for anyone.
+func (s *SyncService) detachBranches(ctx context.Context, userID uuid.UUID, threadID string, messageIDs ...string) error {
+	branches, err := s.db.SMembers(ctx, threadBranchesKey(threadID))
+	if err != nil {
+		return fmt.Errorf("failed to get branches: %w", err)
+	}
+	if len(branches) == 0 {
+		return nil
+	}
+	if err := s.rehydrate(ctx, threadID); err != nil {
+		return err
+	}
+
+	for _, branch := range branches {
+		refs, err := s.db.HGetAll(ctx, threadRefsKey(branch))
+		if err != nil {
+			return fmt.Errorf("failed to get inherited messages of branch %s: %w", branch, err)
+		}
+
+		var copied []string
+		if len(messageIDs) == 0 {
+			for messageID, holder := range refs {
+				if holder == threadID {
+					copied = append(copied, messageID)
+				}
+			}
+		} else {
+			for _, messageID := range messageIDs {
+				if refs[messageID] == threadID {
+					copied = append(copied, messageID)
+				}
+			}
+		}
+		sort.Strings(copied)
+
+		for _, messageID := range copied {
+			data, err := s.db.HGet(ctx, messagesKey(threadID), messageID)
+			if err != nil && !database.IsNotFound(err) {
+				return fmt.Errorf("failed to get message %s: %w", messageID, err)
+			}
+			if err == nil {
+				if err := s.db.HSet(ctx, messagesKey(branch), messageID, data); err != nil {
+					return fmt.Errorf("failed to copy message %s into branch %s: %w", messageID, branch, err)
+				}
+				score := float64(s.clock.Now().UnixMilli())
+				if err := s.db.ZAdd(ctx, messageIndexKey(userID), score, messageIndexMember(branch, messageID)); err != nil {
+					return fmt.Errorf("failed to update message index: %w", err)
+				}
+			}
+			if _, err := s.db.HDel(ctx, threadRefsKey(branch), messageID); err != nil {
+				return fmt.Errorf("failed to drop reference to message %s: %w", messageID, err)
+			}
+		}
+	}
+
+	if len(messageIDs) == 0 {
+		if err := s.db.Del(ctx, threadBranchesKey(threadID)); err != nil {
+			return fmt.Errorf("failed to delete branches: %w", err)
+		}
+	}
+	return nil
+}
+
+// releaseReferences drops a deleted branch's references, and its registration with the threads holding them
+func (s *SyncService) releaseReferences(ctx context.Context, threadID string) error {
+	refs, err := s.db.HGetAll(ctx, threadRefsKey(threadID))
+	if err != nil {
+		return fmt.Errorf("failed to get inherited messages: %w", err)
+	}
+	released := make(map[string]bool)
+	for _, holder := range refs {
+		if released[holder] {
+			continue
+		}
+		released[holder] = true
+		if err := s.db.SRem(ctx, threadBranchesKey(holder), threadID); err != nil {
+			return fmt.Errorf("failed to unregister branch: %w", err)
+		}
+	}
+	if err := s.db.Del(ctx, threadRefsKey(threadID)); err != nil {
+		return fmt.Errorf("failed to delete inherited messages: %w", err)
+	}
+	return nil
+}
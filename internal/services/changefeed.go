@@ -0,0 +1,228 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/database"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// changeSubscriptionBuffer is how many events a subscriber may fall behind before it is dropped
+const changeSubscriptionBuffer = 256
+
+// ChangeFeed pushes the changes recorded in users' change logs to subscribed devices as they
+// happen. It tails the change log rather than the writes themselves, so subscribers get the same
+// operations as changes-since, whichever instance took the write. A user's log is read when a
+// change is published on their channel; a missed publication is made up for by the next.
+type ChangeFeed struct {
+	sync *SyncService
+
+	mu    sync.Mutex
+	tails map[uuid.UUID]*changeTail
+}
+
+// changeTail reads one user's change log for its subscribers
+type changeTail struct {
+	subscribers map[*ChangeSubscription]struct{}
+	next        string                // stream ID the next read starts at
+	changes     database.Subscription // to the user's change channel
+	stop        chan struct{}
+}
+
+// ChangeSubscription receives the changes of a user. Events is closed when the subscription is
+// closed, or when the subscriber fell too far behind and has to resync.
+type ChangeSubscription struct {
+	Events <-chan types.SyncEvent
+
+	events chan types.SyncEvent
+	feed   *ChangeFeed
+	userID uuid.UUID
+	closed bool
+}
+
+// NewChangeFeed creates the feed of sync's change logs
+func NewChangeFeed(sync *SyncService) *ChangeFeed {
+	feed := &ChangeFeed{sync: sync, tails: map[uuid.UUID]*changeTail{}}
+	sync.feed = feed
+	return feed
+}
+
+// Subscribe starts receiving the changes of userID recorded from now on
+func (f *ChangeFeed) Subscribe(ctx context.Context, userID uuid.UUID) (*ChangeSubscription, error) {
+	// Where a new tail starts reading, after the changes published before it subscribed. Both take
+	// a round trip, so they are done before locking.
+	changes, err := f.sync.db.Subscribe(ctx, changeChannel(userID))
+	if err != nil {
+		return nil, err
+	}
+	position, _, err := f.sync.changeLogPosition(ctx, userID)
+	if err != nil {
+		changes.Close()
+		return nil, err
+	}
+	next := nextStreamID(position)
+
+	events := make(chan types.SyncEvent, changeSubscriptionBuffer)
+	sub := &ChangeSubscription{Events: events, events: events, feed: f, userID: userID}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	tail, ok := f.tails[userID]
+	if ok {
+		changes.Close()
+	} else {
+		tail = &changeTail{
+			subscribers: map[*ChangeSubscription]struct{}{},
+			next:        next,
+			changes:     changes,
+			stop:        make(chan struct{}),
+		}
+		f.tails[userID] = tail
+		go f.run(userID, tail)
+	}
+	tail.subscribers[sub] = struct{}{}
+	return sub, nil
+}
+
+// Close stops the subscription. The user's tail stops with its last subscriber.
+func (s *ChangeSubscription) Close() {
+	s.feed.mu.Lock()
+	defer s.feed.mu.Unlock()
+	s.feed.unsubscribe(s)
+}
+
+// unsubscribe removes sub from its tail; f.mu must be held
+func (f *ChangeFeed) unsubscribe(sub *ChangeSubscription) {
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	close(sub.events)
+
+	tail, ok := f.tails[sub.userID]
+	if !ok {
+		return
+	}
+	delete(tail.subscribers, sub)
+	if len(tail.subscribers) == 0 {
+		close(tail.stop)
+		delete(f.tails, sub.userID)
+	}
+}
+
+// Subscribers returns the number of open subscriptions
+func (f *ChangeFeed) Subscribers() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	count := 0
+	for _, tail := range f.tails {
+		count += len(tail.subscribers)
+	}
+	return count
+}
+
+func (f *ChangeFeed) run(userID uuid.UUID, tail *changeTail) {
+	defer tail.changes.Close()
+
+	for {
+		select {
+		case <-tail.stop:
+			return
+		case _, ok := <-tail.changes.Messages():
+			if !ok {
+				return
+			}
+		}
+		if err := f.read(userID, tail); err != nil {
+			fmt.Printf("Warning: failed to read change log of user %s for the change feed: %v\n", userID, err)
+		}
+	}
+}
+
+// read delivers the change-log entries after the tail's position to its subscribers. The position
+// only moves past entries that were delivered, so a failed read is retried by the next.
+func (f *ChangeFeed) read(userID uuid.UUID, tail *changeTail) error {
+	ctx := context.Background()
+	entries, err := f.sync.db.XRange(ctx, changeLogKey(userID), tail.next, "+", 0)
+	if err != nil || len(entries) == 0 {
+		return err
+	}
+	ops, _, err := f.sync.changeOperations(ctx, userID, entries)
+	if err != nil {
+		return err
+	}
+	last := entries[len(entries)-1].ID
+	tail.next = nextStreamID(last)
+	at, cursor := streamIDTime(last), syncCursor{Entry: last}.encode()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for sub := range tail.subscribers {
+		for i := range ops {
+			select {
+			case sub.events <- types.SyncEvent{Type: types.SyncEventChange, Operation: &ops[i], SyncCursor: cursor, SyncTimestamp: at}:
+			default:
+				// Too far behind to catch up event by event; the client resyncs through changes-since
+				f.unsubscribe(sub)
+			}
+			if sub.closed {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// ChangesWaitMax caps how long a changes-since request may be held open waiting for changes
+const ChangesWaitMax = time.Minute
+
+// WaitForChanges is GetChanges for long polling: while there is nothing new after since, the
+// request is held open until a change is recorded or wait elapses. Full syncs and instances
+// without a change feed answer at once.
+func (s *SyncService) WaitForChanges(ctx context.Context, userID uuid.UUID, since string, wait time.Duration) (*types.ChangesSinceResponse, error) {
+	if s.feed == nil || wait <= 0 {
+		return s.GetChanges(ctx, userID, since)
+	}
+
+	// Subscribe before reading, so a change recorded in between still ends the wait
+	sub, err := s.feed.Subscribe(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer sub.Close()
+
+	response, err := s.GetChanges(ctx, userID, since)
+	if err != nil || hasChanges(response) {
+		return response, err
+	}
+
+	timer := time.NewTimer(min(wait, ChangesWaitMax))
+	defer timer.Stop()
+	select {
+	case <-sub.Events:
+	case <-timer.C:
+		return response, nil
+	case <-ctx.Done():
+		return response, nil
+	}
+	return s.GetChanges(ctx, userID, since)
+}
+
+// hasChanges reports whether a changes-since response has anything for the client besides the
+// migration announcement every response repeats
+func hasChanges(response *types.ChangesSinceResponse) bool {
+	if response.FullThreads != nil || response.FullMemories != nil || response.FullFolders != nil || response.FullDrafts != nil ||
+		response.FullReadStates != nil {
+		return true
+	}
+	for _, op := range response.Operations {
+		if op.Resource != types.ResourceInstance {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,138 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/clock"
+	"github.com/helioschat/sync/internal/database"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// flakyReadBackend fails the first thread read after a change-log read once armed, like a feed
+// read hitting a storage error halfway, and then closes failed
+type flakyReadBackend struct {
+	database.Backend
+	failed chan struct{}
+
+	mu      sync.Mutex
+	armed   bool
+	failing bool
+}
+
+func (f *flakyReadBackend) arm() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.armed = true
+}
+
+func (f *flakyReadBackend) XRange(ctx context.Context, stream, start, end string, count int64) ([]database.StreamEntry, error) {
+	f.mu.Lock()
+	f.failing, f.armed = f.armed, false
+	f.mu.Unlock()
+	return f.Backend.XRange(ctx, stream, start, end, count)
+}
+
+func (f *flakyReadBackend) Get(ctx context.Context, key string) (string, error) {
+	f.mu.Lock()
+	fail := f.failing && strings.HasPrefix(key, "threads:")
+	if fail {
+		f.failing = false
+	}
+	f.mu.Unlock()
+	if fail {
+		close(f.failed)
+		return "", errors.New("read failed")
+	}
+	return f.Backend.Get(ctx, key)
+}
+
+// nextChange returns the next operation of sub, failing the test if none comes
+func nextChange(t *testing.T, sub *ChangeSubscription) *types.ChangeOperation {
+	t.Helper()
+	select {
+	case event, ok := <-sub.Events:
+		if !ok {
+			t.Fatalf("subscription closed")
+		}
+		return event.Operation
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no change delivered")
+	}
+	return nil
+}
+
+// upsertTestThread creates a thread of userID
+func upsertTestThread(t *testing.T, s *SyncService, userID uuid.UUID) uuid.UUID {
+	t.Helper()
+	threadID := uuid.Must(uuid.NewV7())
+	if _, _, err := s.UpsertThread(context.Background(), &types.Thread{ID: threadID, UserID: userID, Version: 1}, ""); err != nil {
+		t.Fatalf("UpsertThread: %v", err)
+	}
+	return threadID
+}
+
+func TestChangeFeedDeliversPublishedChanges(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestSync(t, types.Quotas{})
+	feed := NewChangeFeed(s)
+	userID := uuid.New()
+
+	sub, err := feed.Subscribe(ctx, userID)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	defer sub.Close()
+
+	threadID := upsertTestThread(t, s, userID)
+	if op := nextChange(t, sub); op.Resource != "thread" || op.ID != threadID.String() {
+		t.Errorf("operation = %+v, want the thread", op)
+	}
+
+	// A batch is announced once it is applied
+	batchThreadID := uuid.Must(uuid.NewV7())
+	if _, err := NewBatchApplier(s).Apply(ctx, userID, newThreadBatch(batchThreadID, "a"), ""); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if op := nextChange(t, sub); op.ID != batchThreadID.String() {
+		t.Errorf("operation = %+v, want the batch's thread", op)
+	}
+	if op := nextChange(t, sub); op.Resource != "message" || op.ID != "a" {
+		t.Errorf("operation = %+v, want the batch's message", op)
+	}
+}
+
+func TestChangeFeedRetriesFailedReads(t *testing.T) {
+	ctx := context.Background()
+	db := &flakyReadBackend{Backend: newBoltBackend(t), failed: make(chan struct{})}
+	s := NewSyncService(db, nil, types.Quotas{}, nil, clock.System)
+	feed := NewChangeFeed(s)
+	userID := uuid.New()
+
+	sub, err := feed.Subscribe(ctx, userID)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	defer sub.Close()
+
+	db.arm()
+	first := upsertTestThread(t, s, userID)
+	select {
+	case <-db.failed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("change log not read")
+	}
+	second := upsertTestThread(t, s, userID)
+
+	if op := nextChange(t, sub); op.ID != first.String() {
+		t.Errorf("first operation = %+v, want the thread whose read failed", op)
+	}
+	if op := nextChange(t, sub); op.ID != second.String() {
+		t.Errorf("second operation = %+v, want the second thread", op)
+	}
+}
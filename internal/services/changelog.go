@@ -0,0 +1,334 @@
+package services
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/database"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// changeLogMaxLen bounds each user's change stream. Clients that fall further behind get a full sync.
+const changeLogMaxLen = 10000
+
+// errChangeLogTruncated is returned when the requested changes are older than the change stream reaches
+var errChangeLogTruncated = errors.New("change log does not reach back far enough")
+
+// changeLogKey returns the stream recording every mutation of a user's data, in order
+func changeLogKey(userID uuid.UUID) string {
+	return fmt.Sprintf("changes:%s", userID.String())
+}
+
+// changeChannel returns the channel a user's recorded changes are announced on, to the change
+// feeds of every instance
+func changeChannel(userID uuid.UUID) string {
+	return fmt.Sprintf("changes:%s", userID.String())
+}
+
+// recordChange appends an accepted write to the user's change stream, ticks the vector clock of
+// the written resource, accounts for the write in the pipeline stats and announces it to the
+// change feeds
+func (s *SyncService) recordChange(ctx context.Context, record changeRecord) {
+	s.tickVectorClock(ctx, record)
+	err := s.appendChange(ctx, record)
+	s.trackChange(ctx, record, err)
+	if err != nil {
+		return
+	}
+	if err := s.db.Publish(ctx, changeChannel(record.UserID), ""); err != nil {
+		fmt.Printf("Warning: failed to announce change of user %s: %v\n", record.UserID, err)
+	}
+}
+
+// appendChange writes a change entry. The entry references the changed resource rather than
+// embedding it, so readers always get the current state of the resource.
+func (s *SyncService) appendChange(ctx context.Context, record changeRecord) error {
+	// Which thread a message belongs to and which device wrote it are sealed when configured
+	threadID, err := s.sealer.Seal(record.UserID, record.ThreadID)
+	if err != nil {
+		return err
+	}
+	machineID, err := s.sealer.Seal(record.UserID, record.MachineID)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.XAdd(ctx, changeLogKey(record.UserID), changeLogMaxLen, map[string]interface{}{
+		"resource":   record.Resource,
+		"operation":  record.Operation,
+		"id":         record.ResourceID,
+		"thread_id":  threadID,
+		"machine_id": machineID,
+		"timestamp":  record.Timestamp.UnixMilli(),
+	})
+	return err
+}
+
+// changeLogPosition returns the ID of the user's latest change-log entry and its time, to be
+// handed out as a sync cursor alongside a snapshot read after it. Without any entries the cursor
+// is where the log was trimmed to, or its start, and the time is now.
+func (s *SyncService) changeLogPosition(ctx context.Context, userID uuid.UUID) (string, time.Time, error) {
+	latest, err := s.db.XRevRange(ctx, changeLogKey(userID), "+", "-", 1)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to read change log: %w", err)
+	}
+	if len(latest) > 0 {
+		return latest[0].ID, streamIDTime(latest[0].ID), nil
+	}
+
+	trimmed, err := s.changeLogTrimmedTo(ctx, userID)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if trimmed == "" {
+		trimmed = "0-0"
+	}
+	return trimmed, s.clock.Now(), nil
+}
+
+// getChangesFromLog reads the user's change stream after the entry after. Repeated changes to the
+// same resource are collapsed into the latest one. It also returns the ID of the last entry read
+// and the number of malformed entries or unreadable records that were skipped.
+func (s *SyncService) getChangesFromLog(ctx context.Context, userID uuid.UUID, after string) ([]types.ChangeOperation, string, int, error) {
+	key := changeLogKey(userID)
+
+	// Once the stream is trimmed, older changes are gone and the client needs a full sync
+	length, err := s.db.XLen(ctx, key)
+	if err != nil {
+		return nil, "", 0, fmt.Errorf("failed to read change log length: %w", err)
+	}
+	if length >= changeLogMaxLen {
+		oldest, err := s.db.XRange(ctx, key, "-", "+", 1)
+		if err != nil {
+			return nil, "", 0, fmt.Errorf("failed to read change log: %w", err)
+		}
+		if len(oldest) > 0 && compareStreamIDs(oldest[0].ID, after) > 0 {
+			return nil, "", 0, errChangeLogTruncated
+		}
+	}
+
+	// Entries acknowledged by every device with a sync checkpoint may have been trimmed
+	trimmed, err := s.changeLogTrimmedTo(ctx, userID)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	if trimmed != "" && compareStreamIDs(after, trimmed) < 0 {
+		return nil, "", 0, errChangeLogTruncated
+	}
+
+	entries, err := s.db.XRange(ctx, key, nextStreamID(after), "+", 0)
+	if err != nil {
+		return nil, "", 0, fmt.Errorf("failed to read change log: %w", err)
+	}
+
+	ops, corrupted, err := s.changeOperations(ctx, userID, entries)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	last := after
+	if len(entries) > 0 {
+		last = entries[len(entries)-1].ID
+	}
+	return ops, last, corrupted, nil
+}
+
+// changeOperations turns change-log entries into operations carrying the current state of what
+// they changed, each resource once. It also returns the number of malformed entries or unreadable
+// records that were skipped.
+func (s *SyncService) changeOperations(ctx context.Context, userID uuid.UUID, entries []database.StreamEntry) ([]types.ChangeOperation, int, error) {
+	corrupted := 0
+	order := []string{}
+	last := map[string]types.ChangeOperation{}
+	threadIDs := map[string]string{}
+	for _, entry := range entries {
+		resource := streamValue(entry, "resource")
+		id := streamValue(entry, "id")
+		threadID, threadErr := s.sealer.Open(userID, streamValue(entry, "thread_id"))
+		machineID, machineErr := s.sealer.Open(userID, streamValue(entry, "machine_id"))
+		if resource == "" || id == "" || threadErr != nil || machineErr != nil {
+			fmt.Printf("Warning: skipping malformed change-log entry %s for user %s\n", entry.ID, userID)
+			corrupted++
+			continue
+		}
+
+		timestamp := streamIDTime(entry.ID)
+		if ms, err := strconv.ParseInt(streamValue(entry, "timestamp"), 10, 64); err == nil {
+			timestamp = time.UnixMilli(ms)
+		}
+
+		ref := resource + ":" + id
+		if _, seen := last[ref]; !seen {
+			order = append(order, ref)
+		}
+		last[ref] = types.ChangeOperation{
+			Resource:  resource,
+			Operation: streamValue(entry, "operation"),
+			ID:        id,
+			MachineID: machineID,
+			Timestamp: timestamp,
+		}
+		threadIDs[ref] = threadID
+	}
+
+	ops := make([]types.ChangeOperation, 0, len(order))
+	for _, ref := range order {
+		op := last[ref]
+		if clock, err := s.getVectorClock(ctx, userID, vectorClockField(op.Resource, threadIDs[ref], op.ID)); err == nil && len(clock) > 0 {
+			op.Clock = clock
+		}
+		if op.Operation != "delete" {
+			data, err := s.loadChangeData(ctx, userID, op.Resource, op.ID, threadIDs[ref])
+			if errors.Is(err, errUnreadableRecord) {
+				corrupted++
+				continue
+			}
+			if err != nil {
+				return nil, 0, err
+			}
+			op.Data = data
+		}
+		ops = append(ops, op)
+	}
+
+	return ops, corrupted, nil
+}
+
+// loadChangeData returns the current state of a changed resource, or nil if it no longer exists.
+// Records that can't be decoded are quarantined and reported as errUnreadableRecord.
+func (s *SyncService) loadChangeData(ctx context.Context, userID uuid.UUID, resource, id, threadID string) (interface{}, error) {
+	switch resource {
+	case "thread":
+		threadID, err := uuid.Parse(id)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid thread ID %q", errUnreadableRecord, id)
+		}
+		key := fmt.Sprintf("threads:%s:%s", userID.String(), threadID.String())
+		var thread types.Thread
+		return s.loadRecord(ctx, QuarantinedRecord{Resource: resource, UserID: userID.String(), Key: key}, &thread)
+	case "message":
+		// Messages are stored per thread ID; only those under the user's own thread IDs are theirs
+		if owns, err := s.ownsThread(ctx, userID, threadID); err != nil || !owns {
+			return nil, err
+		}
+		var message types.Message
+		return s.loadRecord(ctx, QuarantinedRecord{Resource: resource, UserID: userID.String(), Key: messagesKey(threadID), Field: id}, &message)
+	case "memory":
+		if _, err := uuid.Parse(id); err != nil {
+			return nil, fmt.Errorf("%w: invalid memory ID %q", errUnreadableRecord, id)
+		}
+		var memory types.Memory
+		return s.loadRecord(ctx, QuarantinedRecord{Resource: resource, UserID: userID.String(), Key: memoriesKey(userID), Field: id}, &memory)
+	case "folder":
+		if _, err := uuid.Parse(id); err != nil {
+			return nil, fmt.Errorf("%w: invalid folder ID %q", errUnreadableRecord, id)
+		}
+		var folder types.Folder
+		return s.loadRecord(ctx, QuarantinedRecord{Resource: resource, UserID: userID.String(), Key: foldersKey(userID), Field: id}, &folder)
+	case "draft":
+		if _, err := uuid.Parse(id); err != nil {
+			return nil, fmt.Errorf("%w: invalid thread ID %q", errUnreadableRecord, id)
+		}
+		var draft types.Draft
+		return s.loadRecord(ctx, QuarantinedRecord{Resource: resource, UserID: userID.String(), Key: draftsKey(userID), Field: id}, &draft)
+	case "read_state":
+		if _, err := uuid.Parse(id); err != nil {
+			return nil, fmt.Errorf("%w: invalid thread ID %q", errUnreadableRecord, id)
+		}
+		var state types.ReadState
+		return s.loadRecord(ctx, QuarantinedRecord{Resource: resource, UserID: userID.String(), Key: readStatesKey(userID), Field: id}, &state)
+	case "attachment":
+		if _, err := uuid.Parse(id); err != nil {
+			return nil, fmt.Errorf("%w: invalid attachment ID %q", errUnreadableRecord, id)
+		}
+		// An attachment being uploaded again after a delete doesn't exist yet
+		if data, err := s.db.HGet(ctx, attachmentsKey(userID), id); err == nil && isAttachmentClaim(data) {
+			return nil, nil
+		}
+		var attachment types.Attachment
+		return s.loadRecord(ctx, QuarantinedRecord{Resource: resource, UserID: userID.String(), Key: attachmentsKey(userID), Field: id}, &attachment)
+	default:
+		// Settings documents; GetSettings fails for anything else
+		if document, err := s.GetSettings(ctx, resource, userID); err == nil {
+			return document, nil
+		}
+	}
+	return nil, nil
+}
+
+// loadRecord decodes the record at ref into target. Missing records yield nil; records that
+// fail to decode are quarantined.
+func (s *SyncService) loadRecord(ctx context.Context, ref QuarantinedRecord, target interface{}) (interface{}, error) {
+	var data string
+	var err error
+	if ref.Field == "" {
+		data, err = s.db.Get(ctx, ref.Key)
+	} else {
+		data, err = s.db.HGet(ctx, ref.Key, ref.Field)
+	}
+	if err != nil {
+		if database.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to load %s %s: %w", ref.Resource, ref.Key, err)
+	}
+
+	if err := json.Unmarshal([]byte(data), target); err != nil {
+		s.quarantine(ctx, ref, err)
+		return nil, fmt.Errorf("%w: %v", errUnreadableRecord, err)
+	}
+	return target, nil
+}
+
+// streamIDTime returns the time encoded in a stream entry ID ("<ms>-<seq>")
+func streamIDTime(id string) time.Time {
+	msPart, _, _ := strings.Cut(id, "-")
+	ms, _ := strconv.ParseInt(msPart, 10, 64)
+	return time.UnixMilli(ms)
+}
+
+// parseStreamID splits a stream entry ID ("<ms>-<seq>") into its parts
+func parseStreamID(id string) (ms, seq uint64, ok bool) {
+	msPart, seqPart, found := strings.Cut(id, "-")
+	ms, msErr := strconv.ParseUint(msPart, 10, 64)
+	seq, seqErr := strconv.ParseUint(seqPart, 10, 64)
+	return ms, seq, found && msErr == nil && seqErr == nil
+}
+
+// compareStreamIDs orders two stream entry IDs like the stream does
+func compareStreamIDs(a, b string) int {
+	aMs, aSeq, _ := parseStreamID(a)
+	bMs, bSeq, _ := parseStreamID(b)
+	if c := cmp.Compare(aMs, bMs); c != 0 {
+		return c
+	}
+	return cmp.Compare(aSeq, bSeq)
+}
+
+// nextStreamID returns the lowest stream entry ID after id
+func nextStreamID(id string) string {
+	ms, seq, _ := parseStreamID(id)
+	if seq == math.MaxUint64 {
+		return fmt.Sprintf("%d-0", ms+1)
+	}
+	return fmt.Sprintf("%d-%d", ms, seq+1)
+}
+
+// lastStreamIDAt returns the highest stream entry ID of the millisecond of t, which a sync
+// timestamp stands for: it was read up to the end of its millisecond
+func lastStreamIDAt(t time.Time) string {
+	return fmt.Sprintf("%d-%d", t.UnixMilli(), uint64(math.MaxUint64))
+}
+
+// streamValue returns a stream entry field as a string
+func streamValue(entry database.StreamEntry, field string) string {
+	value, _ := entry.Values[field].(string)
+	return value
+}
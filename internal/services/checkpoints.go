@@ -0,0 +1,165 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/database"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// checkpointStaleAfter is how long a machine's checkpoint holds back trimming of the change log
+// without being updated. Machines gone for longer get a full sync when they return.
+const checkpointStaleAfter = 30 * 24 * time.Hour
+
+var (
+	// ErrCheckpointNotFound is returned when a machine hasn't stored a sync checkpoint
+	ErrCheckpointNotFound = errors.New("sync checkpoint not found")
+	// ErrInvalidCheckpoint is returned for checkpoints the change log can't have reached yet
+	ErrInvalidCheckpoint = errors.New("invalid sync checkpoint")
+)
+
+// syncCheckpointsKey returns the hash of the user's sync checkpoints, keyed by machine ID
+func syncCheckpointsKey(userID uuid.UUID) string {
+	return fmt.Sprintf("sync_checkpoints:%s", userID.String())
+}
+
+// changeLogTrimmedKey returns the key holding the ID of the last change-log entry trimmed for
+// having been acknowledged. Cursors before it can't be resumed from.
+func changeLogTrimmedKey(userID uuid.UUID) string {
+	return fmt.Sprintf("changes_trimmed:%s", userID.String())
+}
+
+// checkpointField returns the field of a machine's checkpoint: its ID in canonical form
+func checkpointField(machineID string) string {
+	if id, err := uuid.Parse(machineID); err == nil {
+		return id.String()
+	}
+	return machineID
+}
+
+// GetSyncCheckpoint returns the checkpoint machineID stored last
+func (s *SyncService) GetSyncCheckpoint(ctx context.Context, userID uuid.UUID, machineID string) (*types.SyncCheckpoint, error) {
+	data, err := s.db.HGet(ctx, syncCheckpointsKey(userID), checkpointField(machineID))
+	if database.IsNotFound(err) {
+		return nil, ErrCheckpointNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sync checkpoint: %w", err)
+	}
+
+	var checkpoint types.SyncCheckpoint
+	if err := json.Unmarshal([]byte(data), &checkpoint); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal sync checkpoint: %w", err)
+	}
+	return &checkpoint, nil
+}
+
+// SaveSyncCheckpoint stores the last sync cursor machineID applied, given as the cursor or, from
+// older clients, the sync timestamp of a changes-since response. Change-log entries every machine
+// with a recent checkpoint has applied are trimmed afterwards.
+func (s *SyncService) SaveSyncCheckpoint(ctx context.Context, userID uuid.UUID, machineID, cursor string, syncTimestamp time.Time) (*types.SyncCheckpoint, error) {
+	if cursor != "" {
+		decoded, err := decodeSyncCursor(cursor)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidCheckpoint, err)
+		}
+		syncTimestamp = streamIDTime(decoded.Entry)
+	}
+
+	now := s.clock.Now()
+	// A cursor of an empty change log is "0-0", so only timestamps have to be after the epoch
+	if (cursor == "" && syncTimestamp.UnixMilli() <= 0) || syncTimestamp.After(now) {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidCheckpoint, syncTimestamp.Format(time.RFC3339Nano))
+	}
+
+	checkpoint := &types.SyncCheckpoint{
+		MachineID:     checkpointField(machineID),
+		SyncCursor:    cursor,
+		SyncTimestamp: syncTimestamp.UTC(),
+		UpdatedAt:     now,
+	}
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal sync checkpoint: %w", err)
+	}
+	if err := s.db.HSet(ctx, syncCheckpointsKey(userID), checkpoint.MachineID, string(data)); err != nil {
+		return nil, fmt.Errorf("failed to save sync checkpoint: %w", err)
+	}
+
+	if err := s.trimAcknowledgedChanges(ctx, userID); err != nil {
+		fmt.Printf("Warning: failed to trim change log of user %s: %v\n", userID, err)
+	}
+	return checkpoint, nil
+}
+
+// checkpointEntry returns the last change-log entry a checkpoint acknowledges
+func checkpointEntry(checkpoint *types.SyncCheckpoint) string {
+	if cursor, err := decodeSyncCursor(checkpoint.SyncCursor); err == nil {
+		return cursor.Entry
+	}
+	return lastStreamIDAt(checkpoint.SyncTimestamp)
+}
+
+// dropSyncCheckpoint forgets a machine's checkpoint, so it no longer holds back trimming
+func dropSyncCheckpoint(ctx context.Context, db database.Backend, userID uuid.UUID, machineID string) error {
+	if _, err := db.HDel(ctx, syncCheckpointsKey(userID), machineID); err != nil {
+		return fmt.Errorf("failed to drop sync checkpoint: %w", err)
+	}
+	return nil
+}
+
+// trimAcknowledgedChanges removes the change-log entries up to the oldest recent checkpoint, which
+// every machine keeping a checkpoint has applied
+func (s *SyncService) trimAcknowledgedChanges(ctx context.Context, userID uuid.UUID) error {
+	entries, err := s.db.HGetAll(ctx, syncCheckpointsKey(userID))
+	if err != nil {
+		return fmt.Errorf("failed to get sync checkpoints: %w", err)
+	}
+
+	staleBefore := s.clock.Now().Add(-checkpointStaleAfter)
+	oldest := ""
+	for _, data := range entries {
+		var checkpoint types.SyncCheckpoint
+		if err := json.Unmarshal([]byte(data), &checkpoint); err != nil || checkpoint.UpdatedAt.Before(staleBefore) {
+			continue
+		}
+		if entry := checkpointEntry(&checkpoint); oldest == "" || compareStreamIDs(entry, oldest) < 0 {
+			oldest = entry
+		}
+	}
+	if oldest == "" {
+		return nil
+	}
+
+	// Mark the trim first, so a cursor before it gets a full sync rather than a gap
+	trimmed, err := s.changeLogTrimmedTo(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if trimmed != "" && compareStreamIDs(oldest, trimmed) <= 0 {
+		return nil
+	}
+	if err := s.db.Set(ctx, changeLogTrimmedKey(userID), oldest, 0); err != nil {
+		return fmt.Errorf("failed to mark change log trim: %w", err)
+	}
+
+	return s.db.XTrimMinID(ctx, changeLogKey(userID), nextStreamID(oldest))
+}
+
+// changeLogTrimmedTo returns the ID of the last change-log entry trimmed for having been
+// acknowledged, or "" if the log wasn't trimmed
+func (s *SyncService) changeLogTrimmedTo(ctx context.Context, userID uuid.UUID) (string, error) {
+	value, err := s.db.Get(ctx, changeLogTrimmedKey(userID))
+	if database.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get change log trim: %w", err)
+	}
+	return value, nil
+}
@@ -0,0 +1,76 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// A write whose version isn't newer than the stored document's conflicts with it. The instance's
+// strategy for the kind of document decides whether it is rejected with the stored document, or
+// applied over it and reported in the response.
+
+// SettingsConflictError reports a settings write whose version isn't newer than the stored
+// document, and carries the stored document so the client can merge without fetching it
+type SettingsConflictError struct {
+	Resource      string
+	Current       interface{}
+	ServerVersion int64
+	ClientVersion int64
+}
+
+func (e *SettingsConflictError) Error() string {
+	return fmt.Sprintf("%s: %s server version %d, client version %d", ErrVersionConflict, e.Resource, e.ServerVersion, e.ClientVersion)
+}
+
+func (e *SettingsConflictError) Unwrap() error {
+	return ErrVersionConflict
+}
+
+// ValidConflictStrategy reports whether strategy is a conflict strategy the services implement
+func ValidConflictStrategy(strategy string) bool {
+	return strategy == types.ConflictReject || strategy == types.ConflictLastWriterWins
+}
+
+// UseConflictStrategies sets how conflicting thread and settings writes are resolved. Empty
+// strategies keep the defaults: threads reject conflicts, settings are last writer wins.
+func (s *SyncService) UseConflictStrategies(strategies types.ConflictStrategies) {
+	if strategies.Threads != "" {
+		s.conflicts.Threads = strategies.Threads
+	}
+	if strategies.Settings != "" {
+		s.conflicts.Settings = strategies.Settings
+	}
+}
+
+// ConflictStrategies returns the conflict strategies in effect
+func (s *SyncService) ConflictStrategies() types.ConflictStrategies {
+	return s.conflicts
+}
+
+// resolveConflict decides a write of clientVersion over a stored document at storedVersion. A
+// write that doesn't conflict gets neither a resolution nor a rejection.
+func resolveConflict(strategy string, storedVersion, clientVersion int64) (resolution *types.ConflictResolution, reject bool) {
+	if clientVersion > storedVersion {
+		return nil, false
+	}
+	if strategy != types.ConflictLastWriterWins {
+		return nil, true
+	}
+	return &types.ConflictResolution{Strategy: strategy, ServerVersion: storedVersion, ClientVersion: clientVersion}, false
+}
+
+// checkSettingsConflict checks a write of a settings document against the stored one
+func (s *SyncService) checkSettingsConflict(ctx context.Context, resource string, userID uuid.UUID, version int64) (*types.ConflictResolution, error) {
+	stored, err := s.GetStoredDocument(ctx, userID, resource, "")
+	if err != nil || stored == nil {
+		return nil, err
+	}
+	resolution, reject := resolveConflict(s.conflicts.Settings, stored.Version, version)
+	if reject {
+		return nil, &SettingsConflictError{Resource: resource, Current: stored.Data, ServerVersion: stored.Version, ClientVersion: version}
+	}
+	return resolution, nil
+}
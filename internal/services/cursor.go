@@ -0,0 +1,100 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/helioschat/sync/internal/types"
+)
+
+// ErrInvalidCursor is returned for page cursors the server didn't issue
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// pageCursor is the position of the last record of a page. Clients get it as an opaque token.
+type pageCursor struct {
+	Score int64  `json:"s,omitempty"` // the record's score in the index the pages are read from
+	ID    string `json:"id"`
+}
+
+func (c pageCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodePageCursor(token string) (pageCursor, error) {
+	var cursor pageCursor
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err == nil {
+		err = json.Unmarshal(data, &cursor)
+	}
+	if err != nil || cursor.ID == "" {
+		return pageCursor{}, fmt.Errorf("%w: %q", ErrInvalidCursor, token)
+	}
+	return cursor, nil
+}
+
+// nextThreadCursor returns the cursor of the page following threads, scored by version like the
+// thread timestamp index, or "" if it is the last page
+func nextThreadCursor(threads []types.Thread, hasMore bool) string {
+	if !hasMore || len(threads) == 0 {
+		return ""
+	}
+	last := threads[len(threads)-1]
+	return pageCursor{Score: last.Version, ID: last.ID.String()}.encode()
+}
+
+// nextMessageCursor returns the cursor of the page following messages, which are in ID order, or
+// "" if it is the last page
+func nextMessageCursor(messages []types.Message, hasMore bool) string {
+	if !hasMore || len(messages) == 0 {
+		return ""
+	}
+	return pageCursor{ID: messages[len(messages)-1].ID}.encode()
+}
+
+// syncCursor is the position in a user's change log a sync resumes after. Clients get it as an
+// opaque token; unlike a timestamp it tells apart changes recorded in the same millisecond.
+type syncCursor struct {
+	Entry string `json:"e"` // ID of the last change-log entry read, "<ms>-<seq>"
+}
+
+func (c syncCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeSyncCursor(token string) (syncCursor, error) {
+	var cursor syncCursor
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err == nil {
+		err = json.Unmarshal(data, &cursor)
+	}
+	if _, _, ok := parseStreamID(cursor.Entry); err != nil || !ok {
+		return syncCursor{}, fmt.Errorf("%w: %q", ErrInvalidCursor, token)
+	}
+	return cursor, nil
+}
+
+// changeLogEntryAfter returns the change-log entry a sync resumes after: since is a cursor issued
+// with an earlier sync, or the sync timestamp of one in unix milliseconds. full reports whether
+// since asks for a full sync instead.
+func changeLogEntryAfter(since string) (entry string, full bool, err error) {
+	if ms, err := strconv.ParseInt(since, 10, 64); err == nil {
+		if ms <= 0 {
+			return "", true, nil
+		}
+		return lastStreamIDAt(time.UnixMilli(ms)), false, nil
+	}
+	if since == "" {
+		return "", true, nil
+	}
+	cursor, err := decodeSyncCursor(since)
+	if err != nil {
+		return "", false, err
+	}
+	return cursor.Entry, false, nil
+}
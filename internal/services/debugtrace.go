@@ -0,0 +1,149 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/clock"
+	"github.com/helioschat/sync/internal/database"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// Debug tracing is switched on by the user for a limited time; only the latest entries are kept
+const (
+	debugTraceMaxDuration = 24 * time.Hour
+	debugTraceMaxEntries  = 500
+)
+
+// ErrInvalidTraceDuration is returned when tracing is requested for no time or longer than allowed
+var ErrInvalidTraceDuration = fmt.Errorf("debug tracing can be enabled for up to %s", debugTraceMaxDuration)
+
+func debugTraceKey(userID uuid.UUID) string {
+	return fmt.Sprintf("debug_trace:%s", userID.String())
+}
+
+// debugTraceUntilKey holds the end of the tracing window and expires with it
+func debugTraceUntilKey(userID uuid.UUID) string {
+	return fmt.Sprintf("debug_trace_until:%s", userID.String())
+}
+
+// DebugTracer records redacted request envelopes of users who opted into debug tracing, so support
+// can see what each device sent and received when devices diverge. A nil tracer records nothing.
+type DebugTracer struct {
+	db    database.Backend
+	clock clock.Clock
+}
+
+func NewDebugTracer(db database.Backend, clock clock.Clock) *DebugTracer {
+	return &DebugTracer{
+		db:    db,
+		clock: clock,
+	}
+}
+
+// Enable starts a new trace for duration, discarding the previous one
+func (t *DebugTracer) Enable(ctx context.Context, userID uuid.UUID, duration time.Duration) (time.Time, error) {
+	if duration <= 0 || duration > debugTraceMaxDuration {
+		return time.Time{}, ErrInvalidTraceDuration
+	}
+
+	until := t.clock.Now().Add(duration)
+	if err := t.db.Del(ctx, debugTraceKey(userID)); err != nil {
+		return time.Time{}, fmt.Errorf("failed to reset debug trace: %w", err)
+	}
+	if err := t.db.Set(ctx, debugTraceUntilKey(userID), until.UnixMilli(), int64(duration.Seconds())); err != nil {
+		return time.Time{}, fmt.Errorf("failed to enable debug tracing: %w", err)
+	}
+	return until, nil
+}
+
+// Disable stops tracing and deletes the recorded entries
+func (t *DebugTracer) Disable(ctx context.Context, userID uuid.UUID) error {
+	for _, key := range []string{debugTraceUntilKey(userID), debugTraceKey(userID)} {
+		if err := t.db.Del(ctx, key); err != nil {
+			return fmt.Errorf("failed to disable debug tracing: %w", err)
+		}
+	}
+	return nil
+}
+
+// enabledUntil returns the end of the user's tracing window, or nil if tracing is off
+func (t *DebugTracer) enabledUntil(ctx context.Context, userID uuid.UUID) (*time.Time, error) {
+	value, err := t.db.Get(ctx, debugTraceUntilKey(userID))
+	if err != nil {
+		if database.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	ms, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid debug trace window")
+	}
+	until := time.UnixMilli(ms)
+	if !until.After(t.clock.Now()) {
+		return nil, nil
+	}
+	return &until, nil
+}
+
+// Enabled reports whether requests of the user are traced. Failures to tell only skip tracing.
+func (t *DebugTracer) Enabled(ctx context.Context, userID uuid.UUID) bool {
+	if t == nil {
+		return false
+	}
+	until, err := t.enabledUntil(ctx, userID)
+	if err != nil {
+		fmt.Printf("Warning: failed to check debug tracing: %v\n", err)
+		return false
+	}
+	return until != nil
+}
+
+// Record appends an entry to the user's trace. Losing an entry must not fail the traced request.
+func (t *DebugTracer) Record(ctx context.Context, userID uuid.UUID, entry types.TraceEntry) {
+	entry.Timestamp = t.clock.Now()
+	data, err := json.Marshal(entry)
+	if err != nil {
+		fmt.Printf("Warning: failed to marshal trace entry: %v\n", err)
+		return
+	}
+
+	key := debugTraceKey(userID)
+	if err := t.db.LPush(ctx, key, string(data)); err != nil {
+		fmt.Printf("Warning: failed to record trace entry: %v\n", err)
+		return
+	}
+	if err := t.db.LTrim(ctx, key, 0, debugTraceMaxEntries-1); err != nil {
+		fmt.Printf("Warning: failed to trim debug trace: %v\n", err)
+	}
+}
+
+// Trace returns the tracing state and the recorded entries, newest first. Entries are kept after
+// the window ends until tracing is disabled, enabled again or the wallet is deleted.
+func (t *DebugTracer) Trace(ctx context.Context, userID uuid.UUID) (*types.DebugTrace, error) {
+	until, err := t.enabledUntil(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check debug tracing: %w", err)
+	}
+
+	values, err := t.db.LRange(ctx, debugTraceKey(userID), 0, -1)
+	if err != nil && !database.IsNotFound(err) {
+		return nil, fmt.Errorf("failed to get debug trace: %w", err)
+	}
+
+	trace := &types.DebugTrace{EnabledUntil: until, Entries: []types.TraceEntry{}}
+	for _, value := range values {
+		var entry types.TraceEntry
+		if err := json.Unmarshal([]byte(value), &entry); err != nil {
+			continue
+		}
+		trace.Entries = append(trace.Entries, entry)
+	}
+	return trace, nil
+}
@@ -0,0 +1,136 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// demoWalletsKey is a sorted set of demo wallet IDs scored by their expiry (unix seconds)
+const demoWalletsKey = "demo_wallets"
+
+// Demo passphrases are random, so nobody can guess their way into another visitor's demo
+const demoPassphraseBytes = 20
+
+// DemoService creates auto-expiring demo wallets and erases them, with all their data, once they expire
+type DemoService struct {
+	auth   *AuthService
+	eraser *AccountEraser
+	jobs   *JobCoordinator
+	ttl    time.Duration
+}
+
+func NewDemoService(auth *AuthService, eraser *AccountEraser, jobs *JobCoordinator, ttl time.Duration) *DemoService {
+	return &DemoService{
+		auth:   auth,
+		eraser: eraser,
+		jobs:   jobs,
+		ttl:    ttl,
+	}
+}
+
+// CreateDemoWallet creates a wallet with a generated passphrase that expires after the configured
+// lifetime, and logs it in
+func (d *DemoService) CreateDemoWallet(ctx context.Context) (*types.DemoWallet, error) {
+	raw := make([]byte, demoPassphraseBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, fmt.Errorf("failed to generate passphrase: %w", err)
+	}
+	passphrase := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(raw)
+
+	now := d.auth.clock.Now()
+	expiresAt := now.Add(d.ttl)
+	wallet := &types.Wallet{
+		UID:       uuid.New(),
+		CreatedAt: now,
+		ExpiresAt: &expiresAt,
+	}
+	if err := d.auth.setPassphrase(wallet, passphrase); err != nil {
+		return nil, err
+	}
+
+	// Index the wallet first, so a failure after saving it can't leave a wallet that is never cleaned up
+	if err := d.auth.db.ZAdd(ctx, demoWalletsKey, float64(expiresAt.Unix()), wallet.UID.String()); err != nil {
+		return nil, fmt.Errorf("failed to index demo wallet: %w", err)
+	}
+	if err := d.auth.saveWallet(ctx, wallet); err != nil {
+		return nil, err
+	}
+
+	tokens, err := d.auth.Login(ctx, wallet.UID, passphrase, "", "", "")
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.DemoWallet{
+		UID:        wallet.UID,
+		Passphrase: passphrase,
+		CreatedAt:  wallet.CreatedAt,
+		ExpiresAt:  expiresAt,
+		Tokens:     tokens,
+	}, nil
+}
+
+// Run erases expired demo wallets every interval until the process exits. Only one instance
+// cleans up at a time.
+func (d *DemoService) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		erased, err := d.runCleanup(context.Background(), interval)
+		if err != nil {
+			fmt.Printf("Warning: demo wallet cleanup failed: %v\n", err)
+		} else if erased > 0 {
+			fmt.Printf("Erased %d expired demo wallets\n", erased)
+		}
+		<-ticker.C
+	}
+}
+
+func (d *DemoService) runCleanup(ctx context.Context, interval time.Duration) (int, error) {
+	acquired, err := d.jobs.AcquireLease(ctx, "demo_cleanup", interval)
+	if err != nil || !acquired {
+		return 0, err
+	}
+	defer d.jobs.ReleaseLease(ctx, "demo_cleanup")
+	return d.EraseExpiredDemoWallets(ctx)
+}
+
+// EraseExpiredDemoWallets deletes every expired demo wallet together with all of its synced data
+func (d *DemoService) EraseExpiredDemoWallets(ctx context.Context) (int, error) {
+	expired, err := d.auth.db.ZRangeByScore(ctx, demoWalletsKey, "-inf", strconv.FormatInt(d.auth.clock.Now().Unix(), 10))
+	if err != nil {
+		return 0, fmt.Errorf("failed to get expired demo wallets: %w", err)
+	}
+
+	erased := 0
+	for _, member := range expired {
+		userID, err := uuid.Parse(member)
+		if err != nil {
+			fmt.Printf("Warning: dropping invalid demo wallet ID %q\n", member)
+			if err := d.auth.db.ZRem(ctx, demoWalletsKey, member); err != nil {
+				return erased, fmt.Errorf("failed to unindex demo wallet: %w", err)
+			}
+			continue
+		}
+
+		// A failed erasure stays indexed and is retried on the next run
+		if _, err := d.eraser.Erase(ctx, userID); err != nil {
+			fmt.Printf("Warning: failed to erase demo wallet %s: %v\n", userID, err)
+			continue
+		}
+		if err := d.auth.db.ZRem(ctx, demoWalletsKey, member); err != nil {
+			return erased, fmt.Errorf("failed to unindex demo wallet: %w", err)
+		}
+		erased++
+	}
+
+	return erased, nil
+}
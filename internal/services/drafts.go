@@ -0,0 +1,125 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/database"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// Drafts are written as often as the user types, so they skip what makes other writes safe to
+// repeat: there are no versions, the last write wins, and nothing is kept once a draft is deleted
+// or expires. They are left out of exports, imports and merges.
+
+// ErrDraftNotFound is returned when a thread has no draft
+var ErrDraftNotFound = errors.New("draft not found")
+
+// defaultDraftTTL is how long a draft is kept after its last write unless configured otherwise
+const defaultDraftTTL = 72 * time.Hour
+
+// draftsKey returns the hash holding all of a user's drafts, keyed by thread ID
+func draftsKey(userID uuid.UUID) string {
+	return fmt.Sprintf("drafts:%s", userID.String())
+}
+
+// UseDraftTTL sets how long drafts are kept after their last write. Non-positive values keep the default.
+func (s *SyncService) UseDraftTTL(ttl time.Duration) {
+	if ttl > 0 {
+		s.draftTTL = ttl
+	}
+}
+
+// GetDrafts returns a user's unexpired drafts ordered by thread ID. Expired drafts are deleted.
+func (s *SyncService) GetDrafts(ctx context.Context, userID uuid.UUID) ([]types.Draft, error) {
+	entries, err := s.db.HGetAll(ctx, draftsKey(userID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get drafts: %w", err)
+	}
+
+	now := s.clock.Now()
+	drafts := []types.Draft{}
+	var expired []string
+	for threadID, data := range entries {
+		var draft types.Draft
+		if err := json.Unmarshal([]byte(data), &draft); err != nil {
+			s.quarantine(ctx, QuarantinedRecord{Resource: "draft", UserID: userID.String(), Key: draftsKey(userID), Field: threadID}, err)
+			continue
+		}
+
+		if !now.Before(draft.ExpiresAt) {
+			expired = append(expired, threadID)
+			continue
+		}
+
+		drafts = append(drafts, draft)
+	}
+
+	if len(expired) > 0 {
+		if _, err := s.db.HDel(ctx, draftsKey(userID), expired...); err != nil {
+			fmt.Printf("Warning: failed to delete expired drafts: %v\n", err)
+		}
+	}
+
+	sort.Slice(drafts, func(i, j int) bool {
+		return drafts[i].ThreadID.String() < drafts[j].ThreadID.String()
+	})
+
+	return drafts, nil
+}
+
+// UpsertDraft saves the draft of a thread, replacing the one stored, and restarts its expiry
+func (s *SyncService) UpsertDraft(ctx context.Context, userID uuid.UUID, draft *types.Draft, machineID string) error {
+	now := s.clock.Now()
+	draft.UpdatedAt = now
+	draft.ExpiresAt = now.Add(s.draftTTL)
+
+	data, err := json.Marshal(draft)
+	if err != nil {
+		return fmt.Errorf("failed to marshal draft: %w", err)
+	}
+	if err := s.db.HSet(ctx, draftsKey(userID), draft.ThreadID.String(), string(data)); err != nil {
+		return fmt.Errorf("failed to save draft: %w", err)
+	}
+
+	s.recordChange(ctx, changeRecord{
+		Resource:   "draft",
+		Operation:  "update",
+		ResourceID: draft.ThreadID.String(),
+		UserID:     userID,
+		MachineID:  machineID,
+		Timestamp:  now,
+	})
+
+	return nil
+}
+
+// DeleteDraft deletes the draft of a thread, typically once its message was sent
+func (s *SyncService) DeleteDraft(ctx context.Context, userID, threadID uuid.UUID, machineID string) error {
+	if _, err := s.db.HGet(ctx, draftsKey(userID), threadID.String()); err != nil {
+		if database.IsNotFound(err) {
+			return ErrDraftNotFound
+		}
+		return fmt.Errorf("failed to get draft: %w", err)
+	}
+
+	if _, err := s.db.HDel(ctx, draftsKey(userID), threadID.String()); err != nil {
+		return fmt.Errorf("failed to delete draft: %w", err)
+	}
+
+	s.recordChange(ctx, changeRecord{
+		Resource:   "draft",
+		Operation:  "delete",
+		ResourceID: threadID.String(),
+		UserID:     userID,
+		MachineID:  machineID,
+		Timestamp:  s.clock.Now(),
+	})
+
+	return nil
+}
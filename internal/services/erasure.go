@@ -0,0 +1,229 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// deletedWalletKey marks an erased wallet so tokens issued before the erasure stop working
+func deletedWalletKey(userID uuid.UUID) string {
+	return fmt.Sprintf("deleted_wallets:%s", userID.String())
+}
+
+// VerifyPassphrase confirms a sensitive operation with the wallet's passphrase
+func (s *AuthService) VerifyPassphrase(ctx context.Context, userID uuid.UUID, passphrase string) error {
+	wallet, err := s.getWallet(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if err := checkPassphrase(wallet, passphrase); err != nil {
+		return errors.New("invalid passphrase")
+	}
+	return nil
+}
+
+// DeleteWallet erases the wallet and its authentication data: registered machines, their signing secrets,
+// sessions, passkeys, and guest and scoped tokens. All tokens issued to the wallet are rejected from now on. Synced data is
+// purged by SyncService.PurgeUserData.
+func (s *AuthService) DeleteWallet(ctx context.Context, userID uuid.UUID, receipt *types.DeletionReceipt) error {
+	machines, err := s.GetMachines(ctx, userID)
+	if err != nil {
+		return err
+	}
+	receipt.Machines = len(machines)
+
+	if err := s.deletePasskeys(ctx, userID); err != nil {
+		return err
+	}
+
+	for _, key := range []string{
+		machinesKey(userID),
+		machineSecretsKey(userID),
+		sessionsKey(userID),
+		fmt.Sprintf("guest_tokens:%s", userID.String()),
+		scopedTokensKey(userID),
+		opaqueRecordKey(userID),
+		fmt.Sprintf("wallet:%s", userID.String()),
+	} {
+		if err := s.db.Del(ctx, key); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", key, err)
+		}
+	}
+
+	// Outstanding tokens must not be able to recreate data under the erased ID. The marker
+	// only has to outlive the longest-lived token issued before the erasure.
+	if err := s.db.Set(ctx, deletedWalletKey(userID), receipt.ReceiptID.String(), int64(refreshTokenTTL.Seconds())); err != nil {
+		return fmt.Errorf("failed to mark wallet as deleted: %w", err)
+	}
+
+	return nil
+}
+
+// PurgeUserData irreversibly deletes everything synced under a user: threads, messages (including
+// archived ones), thread meta-history and tombstones, settings, memories, folders, drafts, read states, attachments, the change log and
+// quarantine entries. The deleted record counts are added to receipt.
+func (s *SyncService) PurgeUserData(ctx context.Context, userID uuid.UUID, receipt *types.DeletionReceipt) error {
+	usage, err := s.GetUsage(ctx, userID)
+	if err != nil {
+		return err
+	}
+	receipt.Threads = usage.Threads
+	receipt.Messages = usage.Messages
+	receipt.Memories = usage.Memories
+
+	attachments, err := s.purgeAttachments(ctx, userID)
+	if err != nil {
+		return err
+	}
+	receipt.Attachments = attachments
+
+	// Both thread indexes, in case one of them missed a thread
+	indexed, err := s.db.SMembers(ctx, threadIndexKey(userID))
+	if err != nil {
+		return fmt.Errorf("failed to get thread index: %w", err)
+	}
+	timestampKey := fmt.Sprintf("timestamps:threads:%s", userID.String())
+	scored, err := s.db.ZRangeByScore(ctx, timestampKey, "-inf", "+inf")
+	if err != nil {
+		return fmt.Errorf("failed to get thread timestamps: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	for _, threadID := range append(indexed, scored...) {
+		if seen[threadID] {
+			continue
+		}
+		seen[threadID] = true
+
+		if s.archive != nil {
+			if err := s.archive.Discard(ctx, threadID); err != nil {
+				return fmt.Errorf("failed to delete archived thread %s: %w", threadID, err)
+			}
+		}
+
+		// Only the messages of the user's own thread IDs are theirs to delete
+		owns, err := s.ownsThread(ctx, userID, threadID)
+		if err != nil {
+			return err
+		}
+		keys := []string{fmt.Sprintf("threads:%s:%s", userID.String(), threadID)}
+		if owns {
+			keys = append(keys, messagesKey(threadID), messageVersionsKey(threadID), messageCountKey(threadID), threadRefsKey(threadID), threadBranchesKey(threadID), threadOwnerKey(threadID))
+		}
+		if id, err := uuid.Parse(threadID); err == nil {
+			keys = append(keys, threadMetaKey(id))
+		}
+		for _, key := range keys {
+			if err := s.db.Del(ctx, key); err != nil {
+				return fmt.Errorf("failed to delete %s: %w", key, err)
+			}
+		}
+	}
+
+	for _, key := range []string{
+		timestampKey,
+		threadIndexKey(userID),
+		archivedThreadsKey(userID),
+		threadTombstonesKey(userID),
+		messageIndexKey(userID),
+		settingsRevisionsKey(userID),
+		memoriesKey(userID),
+		foldersKey(userID),
+		draftsKey(userID),
+		readStatesKey(userID),
+		attachmentsKey(userID),
+		attachmentBytesKey(userID),
+		changeLogKey(userID),
+		vectorClocksKey(userID),
+		syncCheckpointsKey(userID),
+		changeLogTrimmedKey(userID),
+		debugTraceKey(userID),
+		debugTraceUntilKey(userID),
+	} {
+		if err := s.db.Del(ctx, key); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", key, err)
+		}
+	}
+	for _, settings := range SettingsResources {
+		if err := s.db.Del(ctx, settingsKey(settings.Name, userID)); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", settings.Name, err)
+		}
+	}
+
+	quarantined, err := s.GetQuarantinedRecords(ctx)
+	if err != nil {
+		return err
+	}
+	for _, record := range quarantined {
+		if record.UserID != userID.String() {
+			continue
+		}
+		if _, err := s.db.HDel(ctx, quarantineKey, record.id()); err != nil {
+			return fmt.Errorf("failed to delete quarantine entry: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// AccountEraser erases accounts: synced data first, so a failed purge leaves a wallet the deletion can be
+// retried with, then the wallet. Erasures are recorded as pending operations, so one interrupted by a crash
+// is finished by the next instance instead of leaving a half-deleted account behind.
+type AccountEraser struct {
+	auth *AuthService
+	sync *SyncService
+	jobs *JobCoordinator
+}
+
+func NewAccountEraser(auth *AuthService, sync *SyncService, jobs *JobCoordinator) *AccountEraser {
+	e := &AccountEraser{
+		auth: auth,
+		sync: sync,
+		jobs: jobs,
+	}
+	jobs.OnRecover(OpWalletErasure, func(ctx context.Context, op *PendingOperation) error {
+		return e.erase(ctx, op.UserID, op.Receipt)
+	})
+	return e
+}
+
+// Erase deletes everything synced under a user and then their wallet, and returns the deletion receipt
+func (e *AccountEraser) Erase(ctx context.Context, userID uuid.UUID) (*types.DeletionReceipt, error) {
+	receipt := NewDeletionReceipt(userID, e.auth.clock.Now())
+	op := &PendingOperation{Kind: OpWalletErasure, UserID: userID, Receipt: receipt}
+	if err := e.jobs.Begin(ctx, op); err != nil {
+		return nil, err
+	}
+
+	err := e.erase(ctx, userID, receipt)
+	// A failed erasure is the user's to retry, not the recovery's to finish
+	e.jobs.Finish(ctx, op)
+	if err != nil {
+		return nil, err
+	}
+	return receipt, nil
+}
+
+func (e *AccountEraser) erase(ctx context.Context, userID uuid.UUID, receipt *types.DeletionReceipt) error {
+	if err := e.sync.PurgeUserData(ctx, userID, receipt); err != nil {
+		return fmt.Errorf("failed to delete account data: %w", err)
+	}
+	if err := e.auth.DeleteWallet(ctx, userID, receipt); err != nil {
+		return fmt.Errorf("failed to delete wallet: %w", err)
+	}
+	return nil
+}
+
+// NewDeletionReceipt starts the receipt of an account erasure
+func NewDeletionReceipt(userID uuid.UUID, deletedAt time.Time) *types.DeletionReceipt {
+	return &types.DeletionReceipt{
+		ReceiptID: uuid.New(),
+		UserID:    userID,
+		DeletedAt: deletedAt,
+	}
+}
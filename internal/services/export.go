@@ -0,0 +1,167 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/database"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// FinalExportTTL is how long a staged final export can be downloaded after the wallet was deleted
+const FinalExportTTL = 24 * time.Hour
+
+var (
+	// ErrDeletionNotAcknowledged is returned for wallet deletions that didn't acknowledge the loss of all synced data
+	ErrDeletionNotAcknowledged = errors.New("deletion of all synced data was not acknowledged")
+	// ErrInvalidExportMode is returned for final export modes other than inline and staged
+	ErrInvalidExportMode = errors.New("invalid export mode")
+	// ErrExportNotFound is returned for staged exports that expired or never existed
+	ErrExportNotFound = errors.New("export not found or expired")
+)
+
+// finalExportKey returns where a staged export is kept. Only a hash of the download token is
+// stored, so a storage dump doesn't hand out download links.
+func finalExportKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "final_exports:" + hex.EncodeToString(sum[:])
+}
+
+// ExportUserData collects everything synced under a user, including archived threads. Records that
+// fail to decode are quarantined and left out as in the sync endpoints, but storage errors fail
+// the export instead of returning a partial one, since it may be the last copy.
+func (s *SyncService) ExportUserData(ctx context.Context, userID uuid.UUID) (*types.AccountExport, error) {
+	export := &types.AccountExport{
+		UID:        userID,
+		ExportedAt: s.clock.Now(),
+		Threads:    []types.ExportedThread{},
+	}
+
+	threads, err := s.GetThreads(ctx, userID, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, thread := range threads {
+		owns, err := s.ownsThread(ctx, userID, thread.ID.String())
+		if err != nil {
+			return nil, err
+		}
+		var messages []types.Message
+		if owns {
+			messages, err = s.GetMessages(ctx, thread.ID.String(), nil)
+			if err != nil {
+				return nil, fmt.Errorf("failed to export messages of thread %s: %w", thread.ID, err)
+			}
+		}
+		if messages == nil {
+			messages = []types.Message{}
+		}
+		export.Threads = append(export.Threads, types.ExportedThread{Thread: thread, Messages: messages})
+	}
+
+	for _, settings := range SettingsResources {
+		document, err := s.GetSettings(ctx, settings.Name, userID)
+		if database.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		// Cleared documents are left out like deleted threads and memories
+		if deleted := document.Fields().Deleted; deleted != nil && *deleted {
+			continue
+		}
+		settings.set(&export.SettingsBundle, document)
+	}
+
+	if export.Memories, err = s.GetMemories(ctx, userID, false); err != nil {
+		return nil, err
+	}
+	if export.Memories == nil {
+		export.Memories = []types.Memory{}
+	}
+	if export.Folders, err = s.GetFolders(ctx, userID, false); err != nil {
+		return nil, err
+	}
+
+	return export, nil
+}
+
+// StageExport keeps export for download for FinalExportTTL and returns its download token
+func (s *SyncService) StageExport(ctx context.Context, export *types.AccountExport) (string, time.Time, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to generate export token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+
+	data, err := json.Marshal(export)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to marshal export: %w", err)
+	}
+	if err := s.db.Set(ctx, finalExportKey(token), string(data), int64(FinalExportTTL.Seconds())); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to stage export: %w", err)
+	}
+	return token, s.clock.Now().Add(FinalExportTTL), nil
+}
+
+// GetStagedExport returns a staged export by its download token
+func (s *SyncService) GetStagedExport(ctx context.Context, token string) (*types.AccountExport, error) {
+	data, err := s.db.Get(ctx, finalExportKey(token))
+	if database.IsNotFound(err) {
+		return nil, ErrExportNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var export types.AccountExport
+	if err := json.Unmarshal([]byte(data), &export); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal export: %w", err)
+	}
+	return &export, nil
+}
+
+// EraseWithExport erases a wallet like Erase after the user acknowledged the loss of their synced
+// data, optionally exporting it first. The wallet is only erased once the export succeeded.
+func (e *AccountEraser) EraseWithExport(ctx context.Context, userID uuid.UUID, acknowledged bool, exportMode string) (*types.WalletDeletion, error) {
+	if !acknowledged {
+		return nil, ErrDeletionNotAcknowledged
+	}
+	if exportMode != types.ExportNone && exportMode != types.ExportInline && exportMode != types.ExportStaged {
+		return nil, fmt.Errorf("%w: %q (supported: %s, %s)", ErrInvalidExportMode, exportMode, types.ExportInline, types.ExportStaged)
+	}
+
+	deletion := &types.WalletDeletion{}
+	if exportMode != types.ExportNone {
+		export, err := e.sync.ExportUserData(ctx, userID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to export account data: %w", err)
+		}
+		if exportMode == types.ExportInline {
+			deletion.Export = export
+		} else {
+			token, expiresAt, err := e.sync.StageExport(ctx, export)
+			if err != nil {
+				return nil, err
+			}
+			deletion.ExportURL = "/api/v1/auth/final-export/" + token
+			deletion.ExportExpiresAt = &expiresAt
+		}
+	}
+
+	receipt, err := e.Erase(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	deletion.DeletionReceipt = receipt
+	return deletion, nil
+}
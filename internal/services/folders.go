@@ -0,0 +1,229 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/database"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// ErrFolderNotFound is returned when a folder does not exist
+var ErrFolderNotFound = errors.New("folder not found")
+
+// foldersKey returns the hash holding all of a user's folders, keyed by folder ID
+func foldersKey(userID uuid.UUID) string {
+	return fmt.Sprintf("folders:%s", userID.String())
+}
+
+// GetFolders returns a user's folders ordered by position, then ID. Tombstones are only included if requested.
+func (s *SyncService) GetFolders(ctx context.Context, userID uuid.UUID, includeDeleted bool) ([]types.Folder, error) {
+	entries, err := s.db.HGetAll(ctx, foldersKey(userID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get folders: %w", err)
+	}
+
+	folders := []types.Folder{}
+	for folderID, data := range entries {
+		var folder types.Folder
+		if err := json.Unmarshal([]byte(data), &folder); err != nil {
+			s.quarantine(ctx, QuarantinedRecord{Resource: "folder", UserID: userID.String(), Key: foldersKey(userID), Field: folderID}, err)
+			continue
+		}
+
+		if folder.Deleted && !includeDeleted {
+			continue
+		}
+
+		folders = append(folders, folder)
+	}
+
+	sort.Slice(folders, func(i, j int) bool {
+		if folders[i].Position != folders[j].Position {
+			return folders[i].Position < folders[j].Position
+		}
+		return folders[i].ID.String() < folders[j].ID.String()
+	})
+
+	return folders, nil
+}
+
+func (s *SyncService) getFolder(ctx context.Context, userID, folderID uuid.UUID) (*types.Folder, error) {
+	data, err := s.db.HGet(ctx, foldersKey(userID), folderID.String())
+	if err != nil {
+		return nil, err
+	}
+
+	var folder types.Folder
+	if err := json.Unmarshal([]byte(data), &folder); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal folder: %w", err)
+	}
+
+	return &folder, nil
+}
+
+func (s *SyncService) saveFolder(ctx context.Context, userID uuid.UUID, folder *types.Folder) error {
+	data, err := json.Marshal(folder)
+	if err != nil {
+		return fmt.Errorf("failed to marshal folder: %w", err)
+	}
+
+	if err := s.db.HSet(ctx, foldersKey(userID), folder.ID.String(), string(data)); err != nil {
+		return fmt.Errorf("failed to save folder: %w", err)
+	}
+
+	return nil
+}
+
+// UpsertFolder creates or updates a folder. Writing over a tombstone revives the folder.
+func (s *SyncService) UpsertFolder(ctx context.Context, userID uuid.UUID, folder *types.Folder, machineID string) (bool, error) {
+	existing, err := s.getFolder(ctx, userID, folder.ID)
+	if err != nil && !database.IsNotFound(err) {
+		return false, err
+	}
+	isCreating := existing == nil
+
+	now := s.clock.Now()
+	folder.Deleted = false
+	folder.UpdatedAt = now
+	folder.CreatedAt = now
+	if folder.ThreadIDs == nil {
+		folder.ThreadIDs = []uuid.UUID{}
+	}
+
+	if !isCreating {
+		if folder.Version <= existing.Version {
+			return false, fmt.Errorf("%w: server version %d, client version %d", ErrVersionConflict, existing.Version, folder.Version)
+		}
+		folder.CreatedAt = existing.CreatedAt
+	}
+
+	if err := s.saveFolder(ctx, userID, folder); err != nil {
+		return false, err
+	}
+
+	s.recordChange(ctx, changeRecord{
+		Resource:   "folder",
+		Operation:  "update",
+		ResourceID: folder.ID.String(),
+		UserID:     userID,
+		MachineID:  machineID,
+		Timestamp:  now,
+	})
+
+	return isCreating, nil
+}
+
+// DeleteFolder replaces a folder with a tombstone so the deletion propagates to other devices.
+// The folder's threads are not deleted with it.
+func (s *SyncService) DeleteFolder(ctx context.Context, userID, folderID uuid.UUID, machineID string) error {
+	existing, err := s.getFolder(ctx, userID, folderID)
+	if err != nil {
+		if database.IsNotFound(err) {
+			return ErrFolderNotFound
+		}
+		return err
+	}
+
+	if existing.Deleted {
+		return nil
+	}
+
+	now := s.clock.Now()
+	tombstone := &types.Folder{
+		ID:        folderID,
+		ThreadIDs: []uuid.UUID{},
+		Version:   now.UnixMilli(),
+		Deleted:   true,
+		UpdatedAt: now,
+		CreatedAt: existing.CreatedAt,
+	}
+
+	if err := s.saveFolder(ctx, userID, tombstone); err != nil {
+		return err
+	}
+
+	s.recordChange(ctx, changeRecord{
+		Resource:   "folder",
+		Operation:  "delete",
+		ResourceID: folderID.String(),
+		UserID:     userID,
+		MachineID:  machineID,
+		Timestamp:  now,
+	})
+
+	return nil
+}
+
+// mergeFolders copies source's folders to target. Threads keep their IDs in a merge, so the
+// folders' members carry over as they are.
+func (s *SyncService) mergeFolders(ctx context.Context, sourceID, targetID uuid.UUID, policy string, dryRun bool, report *types.MergeReport) error {
+	folders, err := s.GetFolders(ctx, sourceID, false)
+	if err != nil {
+		return err
+	}
+
+	for _, folder := range folders {
+		existing, err := s.getFolder(ctx, targetID, folder.ID)
+		if err != nil && !database.IsNotFound(err) {
+			return err
+		}
+
+		// A target tombstone doesn't conflict: the folder was deleted there, not edited
+		replace := existing == nil || existing.Deleted
+		if !replace {
+			replace = keepSource(policy, folder.Version > existing.Version)
+			addConflict(report, "folder", folder.ID.String(), replace)
+		} else {
+			report.Folders++
+		}
+		if dryRun || !replace {
+			continue
+		}
+
+		now := s.clock.Now()
+		if existing != nil && folder.Version <= existing.Version {
+			folder.Version = existing.Version + 1
+		}
+		folder.UpdatedAt = now
+		if err := s.saveFolder(ctx, targetID, &folder); err != nil {
+			return err
+		}
+		s.recordChange(ctx, changeRecord{
+			Resource:   "folder",
+			Operation:  "update",
+			ResourceID: folder.ID.String(),
+			UserID:     targetID,
+			Timestamp:  now,
+		})
+	}
+
+	return nil
+}
+
+// purgeFolderTombstones removes the folders deleted before the cutoff
+func (s *SyncService) purgeFolderTombstones(ctx context.Context, userID uuid.UUID, report *types.PurgeReport) error {
+	folders, err := s.GetFolders(ctx, userID, true)
+	if err != nil {
+		return err
+	}
+
+	var expired []string
+	for _, folder := range folders {
+		if folder.Deleted && folder.UpdatedAt.Before(report.Cutoff) {
+			expired = append(expired, folder.ID.String())
+		}
+	}
+	if len(expired) == 0 {
+		return nil
+	}
+	if _, err := s.db.HDel(ctx, foldersKey(userID), expired...); err != nil {
+		return fmt.Errorf("failed to purge folder tombstones: %w", err)
+	}
+	report.FolderTombstones = len(expired)
+	return nil
+}
@@ -0,0 +1,370 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/database"
+	"github.com/helioschat/sync/internal/jsonstream"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// ImportOptions controls how ImportUserData reads a document
+type ImportOptions struct {
+	MachineID    string                 // the import's writes are attributed to
+	MaxItemBytes int64                  // largest single item accepted, 0 for no limit
+	Encryption   types.EncryptionPolicy // envelope versions accepted for imported payloads
+}
+
+// errThreadNotImported fails the messages of a thread that couldn't be imported itself
+var errThreadNotImported = errors.New("thread was not imported")
+
+// ImportUserData imports a document in the AccountExport format into a user's data. The document
+// is read item by item — a thread's fields, each of its messages, each settings document, memory
+// and folder — so memory use is bounded by the largest item, not the document. Each item is written
+// as soon as it is read and passed to report; items failing on their own are reported and skipped,
+// while an unreadable document stops the import with an error, keeping what was written so far.
+// Existing data is never overwritten with older versions, so repeating an import is harmless.
+func (s *SyncService) ImportUserData(ctx context.Context, userID uuid.UUID, body io.Reader, opts ImportOptions, report func(types.ImportItemResult)) (*types.ImportSummary, error) {
+	imp := &importer{
+		s:       s,
+		ctx:     ctx,
+		userID:  userID,
+		opts:    opts,
+		dec:     jsonstream.NewDecoder(body, opts.MaxItemBytes),
+		summary: &types.ImportSummary{},
+		report:  report,
+	}
+
+	err := imp.dec.Object(func(key string) error {
+		switch key {
+		case "threads":
+			return imp.dec.Array(func(int) error { return imp.thread() })
+		case "memories":
+			return imp.dec.Array(func(int) error { return imp.memory() })
+		case "folders":
+			return imp.dec.Array(func(int) error { return imp.folder() })
+		default:
+			if settings, ok := lookupSettings(key); ok {
+				return imp.settings(settings)
+			}
+			// uid, exported_at and anything newer exports may carry
+			return imp.dec.Skip()
+		}
+	})
+	imp.summary.Bytes = imp.dec.Offset()
+	return imp.summary, err
+}
+
+// importer carries the state of one ImportUserData call
+type importer struct {
+	s       *SyncService
+	ctx     context.Context
+	userID  uuid.UUID
+	opts    ImportOptions
+	dec     *jsonstream.Decoder
+	summary *types.ImportSummary
+	report  func(types.ImportItemResult)
+}
+
+func (imp *importer) add(result types.ImportItemResult) {
+	imp.summary.Add(result)
+	if imp.report != nil {
+		imp.report(result)
+	}
+}
+
+// decodeItem decodes the next value into v. Values of the wrong shape fail only their item
+// (itemErr); anything else means the document can't be read further (err).
+func (imp *importer) decodeItem(v interface{}) (itemErr, err error) {
+	if err := imp.ctx.Err(); err != nil {
+		return nil, err
+	}
+	err = imp.dec.Decode(v)
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return err, nil
+	}
+	return nil, err
+}
+
+// thread imports a thread and then its messages. The thread's fields are collected until its
+// messages start, so they have to come first, as they do in exports.
+func (imp *importer) thread() error {
+	fields := make(map[string]json.RawMessage)
+	var size int64
+	var threadID string
+	var threadErr error
+	imported := false
+
+	importThread := func() {
+		imported = true
+		data, err := json.Marshal(fields)
+		fields = nil
+		if err != nil {
+			threadErr = err
+			imp.add(types.ImportItemResult{Resource: "thread", Status: types.ImportFailed, Error: err.Error()})
+			return
+		}
+		var thread types.Thread
+		if err := json.Unmarshal(data, &thread); err != nil {
+			threadErr = err
+			imp.add(types.ImportItemResult{Resource: "thread", Status: types.ImportFailed, Error: err.Error()})
+			return
+		}
+		threadID = thread.ID.String()
+		result := imp.importThread(&thread)
+		if result.Status == types.ImportFailed {
+			threadErr = errThreadNotImported
+		}
+		imp.add(result)
+	}
+
+	err := imp.dec.Object(func(key string) error {
+		if key == "messages" {
+			if !imported {
+				importThread()
+			}
+			return imp.dec.Array(func(int) error { return imp.message(threadID, threadErr) })
+		}
+		if imported {
+			return fmt.Errorf("invalid import: thread field %q follows the thread's messages", key)
+		}
+
+		var raw json.RawMessage
+		if err := imp.dec.Decode(&raw); err != nil {
+			return err
+		}
+		size += int64(len(raw))
+		if imp.opts.MaxItemBytes > 0 && size > imp.opts.MaxItemBytes {
+			return fmt.Errorf("%w: items may be at most %d bytes", jsonstream.ErrItemTooLarge, imp.opts.MaxItemBytes)
+		}
+		fields[key] = raw
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if !imported {
+		importThread()
+	}
+	return nil
+}
+
+func (imp *importer) importThread(thread *types.Thread) types.ImportItemResult {
+	result := types.ImportItemResult{Resource: "thread", ID: thread.ID.String()}
+	if thread.ID == uuid.Nil {
+		result.Status, result.Error = types.ImportFailed, "thread ID is missing"
+		return result
+	}
+	if err := imp.opts.Encryption.Validate(&thread.EncV); err != nil {
+		result.Status, result.Error = types.ImportFailed, err.Error()
+		return result
+	}
+
+	thread.UserID = imp.userID
+	existing, err := imp.s.getThread(imp.ctx, imp.userID, thread.ID)
+	if err != nil && !database.IsNotFound(err) {
+		result.Status, result.Error = types.ImportFailed, err.Error()
+		return result
+	}
+
+	// Imports restore older copies, so they never overwrite a newer thread whatever the strategy
+	created, _, err := imp.s.upsertThread(imp.ctx, thread, imp.opts.MachineID, types.ConflictReject)
+	switch {
+	case errors.Is(err, ErrVersionConflict) && existing != nil && existing.Version == thread.Version:
+		result.Status = types.ImportUnchanged
+	case errors.Is(err, ErrVersionConflict), errors.Is(err, ErrThreadDeleted):
+		result.Status, result.Error = types.ImportConflict, err.Error()
+	case err != nil:
+		result.Status, result.Error = types.ImportFailed, err.Error()
+	case created:
+		result.Status = types.ImportCreated
+	default:
+		result.Status = types.ImportUpdated
+	}
+	return result
+}
+
+// message imports the next message into threadID, or only reports it if its thread failed with threadErr
+func (imp *importer) message(threadID string, threadErr error) error {
+	var message types.Message
+	itemErr, err := imp.decodeItem(&message)
+	if err != nil {
+		return err
+	}
+	result := types.ImportItemResult{Resource: "message", ID: message.ID, ThreadID: threadID}
+	if itemErr == nil {
+		itemErr = threadErr
+	}
+	if itemErr == nil {
+		itemErr = types.ValidateMessageID(message.ID)
+	}
+	if itemErr == nil {
+		itemErr = imp.opts.Encryption.Validate(&message.EncV)
+	}
+	if itemErr != nil {
+		result.Status, result.Error = types.ImportFailed, itemErr.Error()
+		imp.add(result)
+		return nil
+	}
+
+	created, err := imp.s.createMessage(imp.ctx, imp.userID, threadID, &message, imp.opts.MachineID)
+	switch {
+	case errors.Is(err, ErrMessageIDTaken):
+		result.Status, result.Error = types.ImportConflict, err.Error()
+	case err != nil:
+		result.Status, result.Error = types.ImportFailed, err.Error()
+	case created:
+		result.Status = types.ImportCreated
+	default:
+		result.Status = types.ImportUnchanged
+	}
+	imp.add(result)
+	return nil
+}
+
+func (imp *importer) memory() error {
+	var memory types.Memory
+	itemErr, err := imp.decodeItem(&memory)
+	if err != nil {
+		return err
+	}
+	result := types.ImportItemResult{Resource: "memory", ID: memory.ID.String()}
+	if itemErr == nil && memory.ID == uuid.Nil {
+		itemErr = errors.New("memory ID is missing")
+	}
+	if itemErr == nil {
+		itemErr = imp.opts.Encryption.Validate(&memory.EncV)
+	}
+	if itemErr != nil {
+		result.Status, result.Error = types.ImportFailed, itemErr.Error()
+		imp.add(result)
+		return nil
+	}
+
+	existing, err := imp.s.getMemory(imp.ctx, imp.userID, memory.ID)
+	if err != nil && !database.IsNotFound(err) {
+		result.Status, result.Error = types.ImportFailed, err.Error()
+		imp.add(result)
+		return nil
+	}
+
+	created, err := imp.s.UpsertMemory(imp.ctx, imp.userID, &memory, imp.opts.MachineID)
+	switch {
+	case errors.Is(err, ErrVersionConflict) && existing != nil && existing.Version == memory.Version:
+		result.Status = types.ImportUnchanged
+	case errors.Is(err, ErrVersionConflict):
+		result.Status, result.Error = types.ImportConflict, err.Error()
+	case err != nil:
+		result.Status, result.Error = types.ImportFailed, err.Error()
+	case created:
+		result.Status = types.ImportCreated
+	default:
+		result.Status = types.ImportUpdated
+	}
+	imp.add(result)
+	return nil
+}
+
+func (imp *importer) folder() error {
+	var folder types.Folder
+	itemErr, err := imp.decodeItem(&folder)
+	if err != nil {
+		return err
+	}
+	result := types.ImportItemResult{Resource: "folder", ID: folder.ID.String()}
+	if itemErr == nil && folder.ID == uuid.Nil {
+		itemErr = errors.New("folder ID is missing")
+	}
+	if itemErr == nil {
+		itemErr = imp.opts.Encryption.Validate(&folder.EncV)
+	}
+	if itemErr != nil {
+		result.Status, result.Error = types.ImportFailed, itemErr.Error()
+		imp.add(result)
+		return nil
+	}
+
+	existing, err := imp.s.getFolder(imp.ctx, imp.userID, folder.ID)
+	if err != nil && !database.IsNotFound(err) {
+		result.Status, result.Error = types.ImportFailed, err.Error()
+		imp.add(result)
+		return nil
+	}
+
+	created, err := imp.s.UpsertFolder(imp.ctx, imp.userID, &folder, imp.opts.MachineID)
+	switch {
+	case errors.Is(err, ErrVersionConflict) && existing != nil && existing.Version == folder.Version:
+		result.Status = types.ImportUnchanged
+	case errors.Is(err, ErrVersionConflict):
+		result.Status, result.Error = types.ImportConflict, err.Error()
+	case err != nil:
+		result.Status, result.Error = types.ImportFailed, err.Error()
+	case created:
+		result.Status = types.ImportCreated
+	default:
+		result.Status = types.ImportUpdated
+	}
+	imp.add(result)
+	return nil
+}
+
+// settings imports a settings document, unless the stored one is at least as new
+func (imp *importer) settings(settings SettingsResource) error {
+	var raw json.RawMessage
+	itemErr, err := imp.decodeItem(&raw)
+	if err != nil {
+		return err
+	}
+	if itemErr == nil && bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+		return nil
+	}
+
+	document := settings.New()
+	fields := document.Fields()
+	result := types.ImportItemResult{Resource: settings.Name}
+	if itemErr == nil {
+		itemErr = json.Unmarshal(raw, document)
+	}
+	if itemErr == nil {
+		itemErr = imp.opts.Encryption.Validate(fields.EncV)
+	}
+	if itemErr != nil {
+		result.Status, result.Error = types.ImportFailed, itemErr.Error()
+		imp.add(result)
+		return nil
+	}
+	*fields.UserID = imp.userID
+
+	stored, err := imp.s.GetSettings(imp.ctx, settings.Name, imp.userID)
+	exists := err == nil
+	var current int64
+	if exists {
+		current = *stored.Fields().Version
+	}
+	switch {
+	case err != nil && !database.IsNotFound(err):
+		result.Status, result.Error = types.ImportFailed, err.Error()
+	case exists && current == *fields.Version:
+		result.Status = types.ImportUnchanged
+	case exists && current > *fields.Version:
+		result.Status = types.ImportConflict
+		result.Error = fmt.Sprintf("%v: server version %d, imported version %d", ErrVersionConflict, current, *fields.Version)
+	default:
+		if _, err := imp.s.UpdateSettings(imp.ctx, settings.Name, document, imp.opts.MachineID); err != nil {
+			result.Status, result.Error = types.ImportFailed, err.Error()
+		} else if exists {
+			result.Status = types.ImportUpdated
+		} else {
+			result.Status = types.ImportCreated
+		}
+	}
+	imp.add(result)
+	return nil
+}
@@ -0,0 +1,277 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/clock"
+	"github.com/helioschat/sync/internal/database"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// instancesKey is a sorted set of server instance IDs scored by their last heartbeat (unix ms)
+const instancesKey = "instances"
+
+// pendingOperationsKey is a hash of the multi-step operations in flight, by operation ID
+const pendingOperationsKey = "pending_operations"
+
+// An instance that hasn't sent a heartbeat for instanceTimeout is considered dead
+const (
+	instanceHeartbeat = 15 * time.Second
+	instanceTimeout   = time.Minute
+)
+
+// Kinds of pending operations
+const (
+	OpWalletErasure  = "wallet_erasure"
+	OpThreadArchival = "thread_archival"
+	OpWalletRotation = "wallet_rotation"
+)
+
+func leaseKey(name string) string {
+	return "leases:" + name
+}
+
+// PendingOperation records a multi-step operation until it completes, so one interrupted by a crash
+// can be finished or rolled back by another instance
+type PendingOperation struct {
+	ID        string                 `json:"id"`
+	Kind      string                 `json:"kind"`
+	Instance  string                 `json:"instance"`
+	UserID    uuid.UUID              `json:"user_id"`
+	TargetID  uuid.UUID              `json:"target_id"` // wallet rotations: the new UID
+	ThreadID  string                 `json:"thread_id,omitempty"`
+	Receipt   *types.DeletionReceipt `json:"receipt,omitempty"`
+	StartedAt time.Time              `json:"started_at"`
+}
+
+// JobCoordinator tracks the server instances sharing the storage, the leases background jobs hold
+// while they run and the multi-step operations in flight. Leftovers of instances that died are
+// released or recovered by Recover. A nil coordinator grants every lease and records nothing.
+type JobCoordinator struct {
+	db         database.Backend
+	instanceID string
+	clock      clock.Clock
+
+	mu         sync.Mutex
+	recoveries map[string]func(ctx context.Context, op *PendingOperation) error
+}
+
+func NewJobCoordinator(db database.Backend, clock clock.Clock) *JobCoordinator {
+	return &JobCoordinator{
+		db:         db,
+		instanceID: uuid.New().String(),
+		clock:      clock,
+		recoveries: make(map[string]func(ctx context.Context, op *PendingOperation) error),
+	}
+}
+
+// InstanceID identifies this server process
+func (j *JobCoordinator) InstanceID() string {
+	return j.instanceID
+}
+
+// Heartbeat marks this instance as alive
+func (j *JobCoordinator) Heartbeat(ctx context.Context) error {
+	if err := j.db.ZAdd(ctx, instancesKey, float64(j.clock.Now().UnixMilli()), j.instanceID); err != nil {
+		return fmt.Errorf("failed to record instance heartbeat: %w", err)
+	}
+	return nil
+}
+
+// Run sends heartbeats until the process exits. Every pass also recovers the leftovers of instances
+// that died since the last one, so an instance that restarted before its predecessor timed out
+// still gets them cleaned up.
+func (j *JobCoordinator) Run() {
+	ticker := time.NewTicker(instanceHeartbeat)
+	defer ticker.Stop()
+
+	for {
+		<-ticker.C
+		ctx := context.Background()
+		if err := j.Heartbeat(ctx); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+			continue
+		}
+		if _, _, err := j.Recover(ctx); err != nil {
+			fmt.Printf("Warning: recovery of dead instances failed: %v\n", err)
+		}
+	}
+}
+
+// OnRecover registers how to recover pending operations of kind left behind by a dead instance
+func (j *JobCoordinator) OnRecover(kind string, recoverOp func(ctx context.Context, op *PendingOperation) error) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.recoveries[kind] = recoverOp
+}
+
+// AcquireLease takes the named lease for ttl, reporting false if another instance holds it
+func (j *JobCoordinator) AcquireLease(ctx context.Context, name string, ttl time.Duration) (bool, error) {
+	if j == nil {
+		return true, nil
+	}
+	acquired, err := j.db.SetNX(ctx, leaseKey(name), j.instanceID, int64(ttl.Seconds()))
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire lease %s: %w", name, err)
+	}
+	return acquired, nil
+}
+
+// ReleaseLease gives up the named lease if this instance still holds it
+func (j *JobCoordinator) ReleaseLease(ctx context.Context, name string) {
+	if j == nil {
+		return
+	}
+	// Not atomic, but a lease that expires in between is only released early
+	owner, err := j.db.Get(ctx, leaseKey(name))
+	if err != nil || owner != j.instanceID {
+		return
+	}
+	if err := j.db.Del(ctx, leaseKey(name)); err != nil {
+		fmt.Printf("Warning: failed to release lease %s: %v\n", name, err)
+	}
+}
+
+// Begin records op as in flight on this instance
+func (j *JobCoordinator) Begin(ctx context.Context, op *PendingOperation) error {
+	if j == nil {
+		return nil
+	}
+	op.ID = uuid.New().String()
+	op.Instance = j.instanceID
+	op.StartedAt = j.clock.Now()
+	return j.savePendingOperation(ctx, op)
+}
+
+// Finish forgets a completed operation. Failing to forget it is only logged: recovering a completed
+// operation is a no-op.
+func (j *JobCoordinator) Finish(ctx context.Context, op *PendingOperation) {
+	if j == nil || op.ID == "" {
+		return
+	}
+	if _, err := j.db.HDel(ctx, pendingOperationsKey, op.ID); err != nil {
+		fmt.Printf("Warning: failed to clear pending operation %s: %v\n", op.ID, err)
+	}
+}
+
+// Recover releases the leases held by dead instances and recovers the operations they left
+// in flight. Operations that fail to recover are kept and retried on the next pass.
+func (j *JobCoordinator) Recover(ctx context.Context) (released, recovered int, err error) {
+	if j == nil {
+		return 0, 0, nil
+	}
+
+	// Only one instance recovers at a time, so an operation is never recovered twice concurrently
+	acquired, err := j.AcquireLease(ctx, "recovery", instanceTimeout)
+	if err != nil || !acquired {
+		return 0, 0, err
+	}
+	defer j.ReleaseLease(ctx, "recovery")
+
+	live, err := j.liveInstances(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	leases, err := j.db.Keys(ctx, leaseKey("*"))
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to list leases: %w", err)
+	}
+	for _, key := range leases {
+		owner, err := j.db.Get(ctx, key)
+		if err != nil {
+			continue // expired in the meantime
+		}
+		if live[owner] {
+			continue
+		}
+		if err := j.db.Del(ctx, key); err != nil {
+			return released, recovered, fmt.Errorf("failed to release %s: %w", key, err)
+		}
+		released++
+	}
+
+	pending, err := j.db.HGetAll(ctx, pendingOperationsKey)
+	if err != nil {
+		return released, recovered, fmt.Errorf("failed to list pending operations: %w", err)
+	}
+	for id, data := range pending {
+		op := &PendingOperation{}
+		if err := json.Unmarshal([]byte(data), op); err != nil {
+			fmt.Printf("Warning: dropping unreadable pending operation %s\n", id)
+			if _, err := j.db.HDel(ctx, pendingOperationsKey, id); err != nil {
+				return released, recovered, fmt.Errorf("failed to drop pending operation: %w", err)
+			}
+			continue
+		}
+		if live[op.Instance] {
+			continue
+		}
+
+		j.mu.Lock()
+		recoverOp, ok := j.recoveries[op.Kind]
+		j.mu.Unlock()
+		if !ok {
+			// Not recoverable by this instance, e.g. archival is disabled here
+			continue
+		}
+		if err := recoverOp(ctx, op); err != nil {
+			fmt.Printf("Warning: failed to recover %s operation %s: %v\n", op.Kind, id, err)
+			continue
+		}
+		if _, err := j.db.HDel(ctx, pendingOperationsKey, id); err != nil {
+			return released, recovered, fmt.Errorf("failed to clear pending operation: %w", err)
+		}
+		recovered++
+	}
+
+	return released, recovered, nil
+}
+
+// liveInstances returns the instances that sent a heartbeat recently, and forgets the others
+func (j *JobCoordinator) liveInstances(ctx context.Context) (map[string]bool, error) {
+	cutoff := strconv.FormatInt(j.clock.Now().Add(-instanceTimeout).UnixMilli(), 10)
+	instances, err := j.db.ZRangeByScore(ctx, instancesKey, cutoff, "+inf")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list live instances: %w", err)
+	}
+	live := map[string]bool{j.instanceID: true}
+	for _, id := range instances {
+		live[id] = true
+	}
+
+	dead, err := j.db.ZRangeByScore(ctx, instancesKey, "-inf", "("+cutoff)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list dead instances: %w", err)
+	}
+	if len(dead) > 0 {
+		members := make([]interface{}, len(dead))
+		for i, id := range dead {
+			members[i] = id
+		}
+		if err := j.db.ZRem(ctx, instancesKey, members...); err != nil {
+			return nil, fmt.Errorf("failed to forget dead instances: %w", err)
+		}
+	}
+	return live, nil
+}
+
+func (j *JobCoordinator) savePendingOperation(ctx context.Context, op *PendingOperation) error {
+	data, err := json.Marshal(op)
+	if err != nil {
+		return fmt.Errorf("failed to marshal pending operation: %w", err)
+	}
+	if err := j.db.HSet(ctx, pendingOperationsKey, op.ID, string(data)); err != nil {
+		return fmt.Errorf("failed to record pending operation: %w", err)
+	}
+	return nil
+}
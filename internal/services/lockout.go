@@ -0,0 +1,143 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/database"
+)
+
+// ErrLoginLocked is returned for logins to a wallet, or from an address, with too many recent failures
+var ErrLoginLocked = errors.New("too many failed logins")
+
+// LockoutPolicy decides when repeated login failures lock a wallet or client address out.
+// Each failure past the threshold doubles the lockout, up to MaxLockout.
+type LockoutPolicy struct {
+	WalletThreshold int           // failures per wallet before it is locked (0 disables lockouts)
+	IPThreshold     int           // failures per client address, across wallets, before it is locked
+	BaseLockout     time.Duration // lockout after the threshold is reached
+	MaxLockout      time.Duration
+	FailureWindow   time.Duration // failures are forgotten after this long without another one
+}
+
+// LockedError reports how long a login is locked out for
+type LockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("%s, try again in %s", ErrLoginLocked, e.RetryAfter.Round(time.Second))
+}
+
+func (e *LockedError) Unwrap() error {
+	return ErrLoginLocked
+}
+
+// loginFailures is the failure state stored per wallet and per client address
+type loginFailures struct {
+	Failures    int       `json:"failures"`
+	LockedUntil time.Time `json:"locked_until"`
+}
+
+func walletFailuresKey(userID uuid.UUID) string {
+	return fmt.Sprintf("login_failures:wallet:%s", userID.String())
+}
+
+func ipFailuresKey(clientIP string) string {
+	return fmt.Sprintf("login_failures:ip:%s", clientIP)
+}
+
+// checkLoginLockout returns a *LockedError if the wallet or the client address is locked out
+func (s *AuthService) checkLoginLockout(ctx context.Context, userID uuid.UUID, clientIP string) error {
+	if s.lockout.WalletThreshold <= 0 {
+		return nil
+	}
+
+	now := s.clock.Now()
+	for _, key := range s.failureKeys(userID, clientIP) {
+		state, err := s.getLoginFailures(ctx, key)
+		if err != nil {
+			return err
+		}
+		if state.LockedUntil.After(now) {
+			return &LockedError{RetryAfter: state.LockedUntil.Sub(now)}
+		}
+	}
+	return nil
+}
+
+// recordLoginFailure counts a failed login against the wallet and the client address
+func (s *AuthService) recordLoginFailure(ctx context.Context, userID uuid.UUID, clientIP string) {
+	if s.lockout.WalletThreshold <= 0 {
+		return
+	}
+
+	thresholds := []int{s.lockout.WalletThreshold, s.lockout.IPThreshold}
+	for i, key := range s.failureKeys(userID, clientIP) {
+		state, err := s.getLoginFailures(ctx, key)
+		if err != nil {
+			fmt.Printf("Warning: failed to read login failures: %v\n", err)
+			continue
+		}
+
+		state.Failures++
+		lockout := time.Duration(0)
+		if thresholds[i] > 0 && state.Failures >= thresholds[i] {
+			lockout = s.lockout.BaseLockout
+			for n := thresholds[i]; n < state.Failures && lockout < s.lockout.MaxLockout; n++ {
+				lockout *= 2
+			}
+			if lockout > s.lockout.MaxLockout {
+				lockout = s.lockout.MaxLockout
+			}
+			state.LockedUntil = s.clock.Now().Add(lockout)
+		}
+
+		data, err := json.Marshal(state)
+		if err != nil {
+			continue
+		}
+		ttl := int64((s.lockout.FailureWindow + lockout).Seconds())
+		if err := s.db.Set(ctx, key, string(data), ttl); err != nil {
+			fmt.Printf("Warning: failed to record login failure: %v\n", err)
+		}
+	}
+}
+
+// clearLoginFailures forgets the failures of a wallet after a successful login. The client address keeps
+// its count, so a valid login to one wallet doesn't reset guessing against others.
+func (s *AuthService) clearLoginFailures(ctx context.Context, userID uuid.UUID) {
+	if s.lockout.WalletThreshold <= 0 {
+		return
+	}
+	if err := s.db.Del(ctx, walletFailuresKey(userID)); err != nil {
+		fmt.Printf("Warning: failed to clear login failures: %v\n", err)
+	}
+}
+
+func (s *AuthService) failureKeys(userID uuid.UUID, clientIP string) []string {
+	keys := []string{walletFailuresKey(userID)}
+	if clientIP != "" {
+		keys = append(keys, ipFailuresKey(clientIP))
+	}
+	return keys
+}
+
+func (s *AuthService) getLoginFailures(ctx context.Context, key string) (*loginFailures, error) {
+	state := &loginFailures{}
+	data, err := s.db.Get(ctx, key)
+	if err != nil {
+		if database.IsNotFound(err) {
+			return state, nil
+		}
+		return nil, fmt.Errorf("failed to get login failures: %w", err)
+	}
+	if err := json.Unmarshal([]byte(data), state); err != nil {
+		return &loginFailures{}, nil
+	}
+	return state, nil
+}
@@ -0,0 +1,280 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/database"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// Maximum length of a machine's display name
+const maxMachineNameLength = 100
+
+var (
+	// ErrMachineNotFound is returned when a machine is not registered to the wallet
+	ErrMachineNotFound = errors.New("machine not found")
+	// ErrMachineDeactivated is returned for writes and re-registrations from a deactivated machine
+	ErrMachineDeactivated = errors.New("machine has been deactivated")
+	// ErrMachineNotRegistered is returned for writes from unknown machines when registration is required
+	ErrMachineNotRegistered = errors.New("machine is not registered")
+	// ErrPassphraseConfirmation is returned when an operation confirmed with the wallet's passphrase,
+	// such as issuing a signing secret, lacks it or the passphrase is wrong
+	ErrPassphraseConfirmation = errors.New("passphrase confirmation required")
+)
+
+// MachinePolicy decides what is asked of the machines writing to a wallet
+type MachinePolicy struct {
+	RequireRegistration bool // refuse writes from machines missing from the registry
+	RequireSignatures   bool // refuse writes not signed with the machine's signing secret
+}
+
+// machinesKey returns the hash of a wallet's registered machines, keyed by machine ID
+func machinesKey(userID uuid.UUID) string {
+	return fmt.Sprintf("machines:%s", userID.String())
+}
+
+// RegisterMachine adds a machine to the wallet, or updates its name and platform if it is already registered.
+// Machines without a request signing secret are issued one; when signed writes are required this has to
+// be confirmed with the wallet's passphrase.
+func (s *AuthService) RegisterMachine(ctx context.Context, userID uuid.UUID, req types.MachineRegisterRequest) (*types.MachineRegistration, bool, error) {
+	machineID, err := uuid.Parse(req.ID)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid machine ID: %w", err)
+	}
+	if err := types.ValidateUUIDv7(machineID); err != nil {
+		return nil, false, fmt.Errorf("invalid machine ID: %w", err)
+	}
+	if len(req.Name) > maxMachineNameLength {
+		return nil, false, fmt.Errorf("machine name must be at most %d characters", maxMachineNameLength)
+	}
+
+	existing, err := s.getMachine(ctx, userID, machineID)
+	if err != nil && !errors.Is(err, ErrMachineNotFound) {
+		return nil, false, err
+	}
+
+	now := s.clock.Now()
+	machine := &types.Machine{
+		ID:        machineID,
+		Name:      req.Name,
+		Platform:  req.Platform,
+		Active:    true,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if existing != nil {
+		// A deactivated device must not be able to let itself back in
+		if !existing.Active {
+			return nil, false, ErrMachineDeactivated
+		}
+		machine.CreatedAt = existing.CreatedAt
+	}
+
+	hasSecret, err := s.hasSigningSecret(ctx, userID, machineID)
+	if err != nil {
+		return nil, false, err
+	}
+	if !hasSecret && s.machinePolicy.RequireSignatures {
+		if req.Passphrase == "" {
+			return nil, false, ErrPassphraseConfirmation
+		}
+		if err := s.VerifyPassphrase(ctx, userID, req.Passphrase); err != nil {
+			return nil, false, fmt.Errorf("%w: %v", ErrPassphraseConfirmation, err)
+		}
+	}
+
+	if err := s.saveMachine(ctx, userID, machine); err != nil {
+		return nil, false, err
+	}
+
+	registration := &types.MachineRegistration{Machine: *machine}
+	if !hasSecret {
+		if registration.SigningSecret, err = s.issueSigningSecret(ctx, userID, machineID); err != nil {
+			return nil, false, err
+		}
+	}
+
+	return registration, existing == nil, nil
+}
+
+// GetMachines lists the wallet's machines, oldest registration first
+func (s *AuthService) GetMachines(ctx context.Context, userID uuid.UUID) ([]types.Machine, error) {
+	entries, err := s.db.HGetAll(ctx, machinesKey(userID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get machines: %w", err)
+	}
+
+	machines := []types.Machine{}
+	for _, data := range entries {
+		data, err := s.sealer.Open(userID, data)
+		if err != nil {
+			continue
+		}
+		var machine types.Machine
+		if err := json.Unmarshal([]byte(data), &machine); err != nil {
+			continue
+		}
+		machines = append(machines, machine)
+	}
+
+	sort.Slice(machines, func(i, j int) bool {
+		return machines[i].CreatedAt.Before(machines[j].CreatedAt)
+	})
+
+	return machines, nil
+}
+
+// RenameMachine changes the display name of a registered machine
+func (s *AuthService) RenameMachine(ctx context.Context, userID, machineID uuid.UUID, name string) (*types.Machine, error) {
+	if len(name) > maxMachineNameLength {
+		return nil, fmt.Errorf("machine name must be at most %d characters", maxMachineNameLength)
+	}
+
+	machine, err := s.getMachine(ctx, userID, machineID)
+	if err != nil {
+		return nil, err
+	}
+
+	machine.Name = name
+	machine.UpdatedAt = s.clock.Now()
+	if err := s.saveMachine(ctx, userID, machine); err != nil {
+		return nil, err
+	}
+
+	return machine, nil
+}
+
+// DeactivateMachine permanently blocks writes from a machine and invalidates the tokens issued to it.
+// The record is kept so the ID can't be re-registered.
+func (s *AuthService) DeactivateMachine(ctx context.Context, userID, machineID uuid.UUID) (*types.Machine, error) {
+	machine, err := s.getMachine(ctx, userID, machineID)
+	if err != nil {
+		return nil, err
+	}
+	if !machine.Active {
+		return machine, nil
+	}
+
+	now := s.clock.Now()
+	machine.Active = false
+	machine.UpdatedAt = now
+	machine.DeactivatedAt = &now
+	if err := s.saveMachine(ctx, userID, machine); err != nil {
+		return nil, err
+	}
+	if err := s.sealer.HDel(ctx, s.db, machineSecretsKey(userID), userID, machineID.String()); err != nil {
+		return nil, fmt.Errorf("failed to revoke signing secret: %w", err)
+	}
+	if err := dropSyncCheckpoint(ctx, s.db, userID, machineID.String()); err != nil {
+		return nil, err
+	}
+
+	return machine, nil
+}
+
+// CheckMachine decides whether a write from machineID is accepted. Deactivated machines are always
+// refused; unknown or missing machine IDs only when the instance requires registered machines.
+func (s *AuthService) CheckMachine(ctx context.Context, userID uuid.UUID, machineID string) error {
+	id, err := uuid.Parse(machineID)
+	if err != nil {
+		if s.machinePolicy.RequireRegistration {
+			return ErrMachineNotRegistered
+		}
+		return nil
+	}
+
+	machine, err := s.getMachine(ctx, userID, id)
+	if err != nil {
+		if !errors.Is(err, ErrMachineNotFound) {
+			return err
+		}
+		if s.machinePolicy.RequireRegistration {
+			return ErrMachineNotRegistered
+		}
+		return nil
+	}
+
+	if !machine.Active {
+		return ErrMachineDeactivated
+	}
+	return nil
+}
+
+// SignOutMachine cuts a lost or stolen machine off without changing the passphrase. Machines missing
+// from the registry get a deactivated record, so tokens that were bound to them at login are rejected too.
+func (s *AuthService) SignOutMachine(ctx context.Context, userID, machineID uuid.UUID) (*types.Machine, error) {
+	machine, err := s.DeactivateMachine(ctx, userID, machineID)
+	if !errors.Is(err, ErrMachineNotFound) {
+		return machine, err
+	}
+
+	now := s.clock.Now()
+	machine = &types.Machine{
+		ID:            machineID,
+		Active:        false,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+		DeactivatedAt: &now,
+	}
+	if err := s.saveMachine(ctx, userID, machine); err != nil {
+		return nil, err
+	}
+
+	return machine, nil
+}
+
+// checkMachineTokens rejects logins and tokens bound to a deactivated machine
+func (s *AuthService) checkMachineTokens(ctx context.Context, userID uuid.UUID, machineID string) error {
+	id, err := uuid.Parse(machineID)
+	if err != nil {
+		return fmt.Errorf("invalid machine ID: %w", err)
+	}
+
+	machine, err := s.getMachine(ctx, userID, id)
+	if err != nil {
+		if errors.Is(err, ErrMachineNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	if !machine.Active {
+		return ErrMachineDeactivated
+	}
+	return nil
+}
+
+func (s *AuthService) getMachine(ctx context.Context, userID, machineID uuid.UUID) (*types.Machine, error) {
+	data, err := s.sealer.HGet(ctx, s.db, machinesKey(userID), userID, machineID.String())
+	if err != nil {
+		if database.IsNotFound(err) {
+			return nil, ErrMachineNotFound
+		}
+		return nil, fmt.Errorf("failed to get machine: %w", err)
+	}
+
+	var machine types.Machine
+	if err := json.Unmarshal([]byte(data), &machine); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal machine: %w", err)
+	}
+
+	return &machine, nil
+}
+
+func (s *AuthService) saveMachine(ctx context.Context, userID uuid.UUID, machine *types.Machine) error {
+	data, err := json.Marshal(machine)
+	if err != nil {
+		return fmt.Errorf("failed to marshal machine: %w", err)
+	}
+
+	if err := s.sealer.HSet(ctx, s.db, machinesKey(userID), userID, machine.ID.String(), string(data)); err != nil {
+		return fmt.Errorf("failed to save machine: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/database"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// ErrVersionConflict is returned when a write carries a version that is not newer than the stored one
+var ErrVersionConflict = errors.New("version conflict")
+
+// ErrMemoryNotFound is returned when a memory does not exist
+var ErrMemoryNotFound = errors.New("memory not found")
+
+// memoriesKey returns the hash holding all of a user's memories, keyed by memory ID
+func memoriesKey(userID uuid.UUID) string {
+	return fmt.Sprintf("memories:%s", userID.String())
+}
+
+// GetMemories returns a user's memories ordered by ID. Tombstones are only included if requested.
+func (s *SyncService) GetMemories(ctx context.Context, userID uuid.UUID, includeDeleted bool) ([]types.Memory, error) {
+	entries, err := s.db.HGetAll(ctx, memoriesKey(userID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get memories: %w", err)
+	}
+
+	memories := []types.Memory{}
+	for memoryID, data := range entries {
+		var memory types.Memory
+		if err := json.Unmarshal([]byte(data), &memory); err != nil {
+			s.quarantine(ctx, QuarantinedRecord{Resource: "memory", UserID: userID.String(), Key: memoriesKey(userID), Field: memoryID}, err)
+			continue
+		}
+
+		if memory.Deleted && !includeDeleted {
+			continue
+		}
+
+		memories = append(memories, memory)
+	}
+
+	sort.Slice(memories, func(i, j int) bool {
+		return memories[i].ID.String() < memories[j].ID.String()
+	})
+
+	return memories, nil
+}
+
+func (s *SyncService) getMemory(ctx context.Context, userID, memoryID uuid.UUID) (*types.Memory, error) {
+	data, err := s.db.HGet(ctx, memoriesKey(userID), memoryID.String())
+	if err != nil {
+		return nil, err
+	}
+
+	var memory types.Memory
+	if err := json.Unmarshal([]byte(data), &memory); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal memory: %w", err)
+	}
+
+	return &memory, nil
+}
+
+func (s *SyncService) saveMemory(ctx context.Context, userID uuid.UUID, memory *types.Memory) error {
+	data, err := json.Marshal(memory)
+	if err != nil {
+		return fmt.Errorf("failed to marshal memory: %w", err)
+	}
+
+	if err := s.db.HSet(ctx, memoriesKey(userID), memory.ID.String(), string(data)); err != nil {
+		return fmt.Errorf("failed to save memory: %w", err)
+	}
+
+	return nil
+}
+
+// UpsertMemory creates or updates a memory. Writing over a tombstone revives the memory.
+func (s *SyncService) UpsertMemory(ctx context.Context, userID uuid.UUID, memory *types.Memory, machineID string) (bool, error) {
+	existing, err := s.getMemory(ctx, userID, memory.ID)
+	if err != nil && !database.IsNotFound(err) {
+		return false, err
+	}
+	isCreating := existing == nil
+
+	now := s.clock.Now()
+	memory.Deleted = false
+	memory.UpdatedAt = now
+	memory.CreatedAt = now
+
+	if !isCreating {
+		if memory.Version <= existing.Version {
+			return false, fmt.Errorf("%w: server version %d, client version %d", ErrVersionConflict, existing.Version, memory.Version)
+		}
+		memory.CreatedAt = existing.CreatedAt
+	}
+
+	if err := s.saveMemory(ctx, userID, memory); err != nil {
+		return false, err
+	}
+
+	s.recordChange(ctx, changeRecord{
+		Resource:   "memory",
+		Operation:  "update",
+		ResourceID: memory.ID.String(),
+		UserID:     userID,
+		MachineID:  machineID,
+		Timestamp:  now,
+	})
+
+	return isCreating, nil
+}
+
+// DeleteMemory replaces a memory with a tombstone so the deletion propagates to other devices
+func (s *SyncService) DeleteMemory(ctx context.Context, userID, memoryID uuid.UUID, machineID string) error {
+	existing, err := s.getMemory(ctx, userID, memoryID)
+	if err != nil {
+		if database.IsNotFound(err) {
+			return ErrMemoryNotFound
+		}
+		return err
+	}
+
+	if existing.Deleted {
+		return nil
+	}
+
+	now := s.clock.Now()
+	tombstone := &types.Memory{
+		ID:        memoryID,
+		Version:   now.UnixMilli(),
+		Deleted:   true,
+		UpdatedAt: now,
+		CreatedAt: existing.CreatedAt,
+	}
+
+	if err := s.saveMemory(ctx, userID, tombstone); err != nil {
+		return err
+	}
+
+	s.recordChange(ctx, changeRecord{
+		Resource:   "memory",
+		Operation:  "delete",
+		ResourceID: memoryID.String(),
+		UserID:     userID,
+		MachineID:  machineID,
+		Timestamp:  now,
+	})
+
+	return nil
+}
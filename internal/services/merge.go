@@ -0,0 +1,442 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/database"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// Conflict policies of an account merge, deciding which wallet's copy of a record both hold is kept
+const (
+	MergeKeepTarget = "keep_target"
+	MergeKeepSource = "keep_source"
+	MergeNewest     = "newest" // by the records' version, or their last server-side change for settings
+)
+
+var (
+	// ErrInvalidMergePolicy is returned for an unknown conflict policy
+	ErrInvalidMergePolicy = errors.New("invalid merge conflict policy")
+	// ErrMergeIntoSelf is returned when the source and target of a merge are the same wallet
+	ErrMergeIntoSelf = errors.New("cannot merge a wallet into itself")
+	// ErrWalletMerged is returned when logging into a wallet that was merged into another one with
+	// its passphrase
+	ErrWalletMerged = errors.New("wallet was merged into another wallet")
+)
+
+// mergedWalletKey is the tombstone left by a merged wallet, a mergedWallet
+func mergedWalletKey(userID uuid.UUID) string {
+	return fmt.Sprintf("merged_wallets:%s", userID.String())
+}
+
+// mergedWallet is the tombstone of a merged wallet: the receipt of its erasure, and its passphrase
+// hash so only logins that know the passphrase are told about the merge. Tombstones written before
+// the hash was kept hold the receipt ID alone.
+type mergedWallet struct {
+	ReceiptID        string              `json:"receipt_id"`
+	Salt             string              `json:"salt"`
+	HashedPassphrase string              `json:"hashed_passphrase"`
+	PassphraseParams *types.Argon2Params `json:"passphrase_params,omitempty"`
+}
+
+// MergeUserData moves everything synced under source to target: threads with their messages,
+// settings, memories, folders and attachments. Records both wallets hold are resolved with policy. Moved records are
+// written to target's change log so its devices pick them up. On a dry run nothing is written.
+//
+// Records are moved as stored: clients whose encryption keys differ between the wallets have
+// to re-encrypt the moved records after the merge.
+func (s *SyncService) MergeUserData(ctx context.Context, sourceID, targetID uuid.UUID, policy string, dryRun bool) (*types.MergeReport, error) {
+	report := &types.MergeReport{
+		SourceUID:      sourceID,
+		TargetUID:      targetID,
+		ConflictPolicy: policy,
+		DryRun:         dryRun,
+		Conflicts:      []types.MergeConflict{},
+	}
+
+	if err := s.mergeThreads(ctx, sourceID, targetID, policy, dryRun, report); err != nil {
+		return nil, err
+	}
+	if err := s.mergeSettings(ctx, sourceID, targetID, policy, dryRun, report); err != nil {
+		return nil, err
+	}
+	if err := s.mergeMemories(ctx, sourceID, targetID, policy, dryRun, report); err != nil {
+		return nil, err
+	}
+	if err := s.mergeFolders(ctx, sourceID, targetID, policy, dryRun, report); err != nil {
+		return nil, err
+	}
+	if err := s.mergeAttachments(ctx, sourceID, targetID, dryRun, report); err != nil {
+		return nil, err
+	}
+
+	usage, err := s.GetUsage(ctx, targetID)
+	if err != nil {
+		return nil, err
+	}
+	if dryRun {
+		usage.Threads += int64(report.Threads)
+		usage.Messages += int64(report.Messages)
+		usage.Memories += int64(report.Memories)
+	}
+	report.Usage = *usage
+	report.Warnings = quotaWarnings(*usage, s.quotas)
+
+	return report, nil
+}
+
+// keepSource resolves a conflict, reporting whether the source's copy replaces the target's
+func keepSource(policy string, sourceNewer bool) bool {
+	switch policy {
+	case MergeKeepSource:
+		return true
+	case MergeNewest:
+		return sourceNewer
+	}
+	return false
+}
+
+// addConflict reports a record both wallets hold and whose copy was kept
+func addConflict(report *types.MergeReport, resource, id string, source bool) {
+	kept := "target"
+	if source {
+		kept = "source"
+	}
+	report.Conflicts = append(report.Conflicts, types.MergeConflict{Resource: resource, ID: id, Kept: kept})
+}
+
+func (s *SyncService) mergeThreads(ctx context.Context, sourceID, targetID uuid.UUID, policy string, dryRun bool, report *types.MergeReport) error {
+	threadIDs, err := s.db.SMembers(ctx, threadIndexKey(sourceID))
+	if err != nil {
+		return fmt.Errorf("failed to get thread index: %w", err)
+	}
+
+	// Messages are stored per thread; only the per-user index has to move with the thread
+	indexed, err := s.db.ZRangeByScore(ctx, messageIndexKey(sourceID), "-inf", "+inf")
+	if err != nil {
+		return fmt.Errorf("failed to get message index: %w", err)
+	}
+	threadMessages := make(map[string][]string)
+	for _, member := range indexed {
+		threadID, messageID, ok := strings.Cut(member, ":")
+		if ok {
+			threadMessages[threadID] = append(threadMessages[threadID], messageID)
+		}
+	}
+
+	for _, id := range threadIDs {
+		threadID, err := uuid.Parse(id)
+		if err != nil {
+			continue
+		}
+
+		if !dryRun {
+			if err := s.rehydrate(ctx, id); err != nil {
+				return err
+			}
+		}
+		thread, err := s.getThread(ctx, sourceID, threadID)
+		if err != nil {
+			if database.IsNotFound(err) {
+				continue // deleted since it was indexed
+			}
+			return err
+		}
+
+		existing, err := s.getThread(ctx, targetID, threadID)
+		if err != nil && !database.IsNotFound(err) {
+			return err
+		}
+		replace := existing == nil
+		if existing != nil {
+			replace = keepSource(policy, thread.Version > existing.Version)
+			addConflict(report, "thread", id, replace)
+		} else {
+			report.Threads++
+		}
+		report.Messages += len(threadMessages[id])
+
+		if dryRun {
+			continue
+		}
+
+		now := s.clock.Now()
+		if replace {
+			thread.UserID = targetID
+			if existing != nil && thread.Version <= existing.Version {
+				// Devices of the target only accept a newer version
+				thread.Version = existing.Version + 1
+			}
+			if err := s.saveThread(ctx, thread); err != nil {
+				return err
+			}
+			if err := s.clearThreadTombstone(ctx, targetID, threadID); err != nil {
+				return err
+			}
+			s.recordChange(ctx, changeRecord{
+				Resource:   "thread",
+				Operation:  "update",
+				ResourceID: id,
+				UserID:     targetID,
+				Timestamp:  now,
+			})
+		}
+
+		// The thread's messages go with it, if they were the source's
+		owns, err := s.ownsThread(ctx, sourceID, id)
+		if err != nil {
+			return err
+		}
+		if owns {
+			if err := s.db.Set(ctx, threadOwnerKey(id), targetID.String(), 0); err != nil {
+				return fmt.Errorf("failed to transfer thread: %w", err)
+			}
+		}
+		for _, messageID := range threadMessages[id] {
+			if err := s.db.ZAdd(ctx, messageIndexKey(targetID), float64(now.UnixMilli()), messageIndexMember(id, messageID)); err != nil {
+				return fmt.Errorf("failed to update message index: %w", err)
+			}
+			if err := s.db.ZRem(ctx, messageIndexKey(sourceID), messageIndexMember(id, messageID)); err != nil {
+				return fmt.Errorf("failed to remove from message index: %w", err)
+			}
+			s.recordChange(ctx, changeRecord{
+				Resource:   "message",
+				Operation:  "create",
+				ResourceID: messageID,
+				ThreadID:   id,
+				UserID:     targetID,
+				Timestamp:  now,
+			})
+		}
+
+		// Drop the source's record of the thread, leaving the shared messages and meta-history alone
+		if err := s.db.Del(ctx, fmt.Sprintf("threads:%s:%s", sourceID.String(), id)); err != nil {
+			return fmt.Errorf("failed to delete thread: %w", err)
+		}
+		if err := s.db.ZRem(ctx, fmt.Sprintf("timestamps:threads:%s", sourceID.String()), id); err != nil {
+			return fmt.Errorf("failed to remove from timestamp index: %w", err)
+		}
+		if err := s.db.SRem(ctx, threadIndexKey(sourceID), id); err != nil {
+			return fmt.Errorf("failed to remove from thread index: %w", err)
+		}
+		if err := s.db.SRem(ctx, archivedThreadsKey(sourceID), id); err != nil {
+			return fmt.Errorf("failed to remove from archived threads: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (s *SyncService) mergeSettings(ctx context.Context, sourceID, targetID uuid.UUID, policy string, dryRun bool, report *types.MergeReport) error {
+	for _, settings := range SettingsResources {
+		resource := settings.Name
+		source, err := s.loadSettingsDocument(ctx, resource, sourceID)
+		if err != nil {
+			return err
+		}
+		if source == nil || source["deleted"] == true {
+			continue
+		}
+		target, err := s.loadSettingsDocument(ctx, resource, targetID)
+		if err != nil {
+			return err
+		}
+
+		replace := target == nil
+		if target != nil {
+			replace = keepSource(policy, documentUpdatedAt(source).After(documentUpdatedAt(target)))
+			addConflict(report, resource, "", replace)
+		} else {
+			report.Settings++
+		}
+		if dryRun || !replace {
+			continue
+		}
+
+		now := s.clock.Now()
+		source["user_id"] = targetID
+		source["updated_at"] = now
+		data, err := json.Marshal(source)
+		if err != nil {
+			return fmt.Errorf("failed to marshal %s: %w", resource, err)
+		}
+		if err := s.db.Set(ctx, settingsKey(resource, targetID), string(data), 0); err != nil {
+			return fmt.Errorf("failed to save %s: %w", resource, err)
+		}
+
+		s.recordChange(ctx, changeRecord{
+			Resource:   resource,
+			Operation:  "update",
+			ResourceID: targetID.String(),
+			UserID:     targetID,
+			Timestamp:  now,
+		})
+		if err := s.markSettingsChanged(ctx, targetID, resource, now); err != nil {
+			fmt.Printf("Warning: failed to update settings revision: %v\n", err)
+		}
+	}
+
+	return nil
+}
+
+// loadSettingsDocument returns a settings document as generic JSON, or nil if the user has none
+func (s *SyncService) loadSettingsDocument(ctx context.Context, resource string, userID uuid.UUID) (map[string]interface{}, error) {
+	data, err := s.db.Get(ctx, settingsKey(resource, userID))
+	if err != nil {
+		if database.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get %s: %w", resource, err)
+	}
+
+	var document map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &document); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal %s: %w", resource, err)
+	}
+	return document, nil
+}
+
+// documentUpdatedAt returns the last server-side change of a settings document
+func documentUpdatedAt(document map[string]interface{}) time.Time {
+	value, _ := document["updated_at"].(string)
+	updatedAt, _ := time.Parse(time.RFC3339Nano, value)
+	return updatedAt
+}
+
+func (s *SyncService) mergeMemories(ctx context.Context, sourceID, targetID uuid.UUID, policy string, dryRun bool, report *types.MergeReport) error {
+	memories, err := s.GetMemories(ctx, sourceID, false)
+	if err != nil {
+		return err
+	}
+
+	for _, memory := range memories {
+		existing, err := s.getMemory(ctx, targetID, memory.ID)
+		if err != nil && !database.IsNotFound(err) {
+			return err
+		}
+
+		// A target tombstone doesn't conflict: the memory was deleted there, not edited
+		replace := existing == nil || existing.Deleted
+		if !replace {
+			replace = keepSource(policy, memory.Version > existing.Version)
+			addConflict(report, "memory", memory.ID.String(), replace)
+		} else {
+			report.Memories++
+		}
+		if dryRun || !replace {
+			continue
+		}
+
+		now := s.clock.Now()
+		if existing != nil && memory.Version <= existing.Version {
+			memory.Version = existing.Version + 1
+		}
+		memory.UpdatedAt = now
+		if err := s.saveMemory(ctx, targetID, &memory); err != nil {
+			return err
+		}
+		s.recordChange(ctx, changeRecord{
+			Resource:   "memory",
+			Operation:  "update",
+			ResourceID: memory.ID.String(),
+			UserID:     targetID,
+			Timestamp:  now,
+		})
+	}
+
+	return nil
+}
+
+// AccountMerger merges a wallet into another one, for users who accidentally created two accounts
+// on different devices. The source's data is moved to the target and the source wallet is erased,
+// leaving a tombstone so logging into it with its passphrase explains where the data went.
+type AccountMerger struct {
+	auth   *AuthService
+	sync   *SyncService
+	eraser *AccountEraser
+	jobs   *JobCoordinator
+}
+
+func NewAccountMerger(auth *AuthService, sync *SyncService, eraser *AccountEraser, jobs *JobCoordinator) *AccountMerger {
+	m := &AccountMerger{
+		auth:   auth,
+		sync:   sync,
+		eraser: eraser,
+		jobs:   jobs,
+	}
+	jobs.OnRecover(OpWalletRotation, m.recoverRotation)
+	return m
+}
+
+// Merge moves source's data into target and erases source. An empty policy keeps the target's
+// copy of conflicting records. A merge that fails halfway can be run again to finish it.
+func (m *AccountMerger) Merge(ctx context.Context, sourceID, targetID uuid.UUID, policy string, dryRun bool) (*types.MergeReport, error) {
+	if policy == "" {
+		policy = MergeKeepTarget
+	}
+	if policy != MergeKeepTarget && policy != MergeKeepSource && policy != MergeNewest {
+		return nil, fmt.Errorf("%w: %q (supported: %s, %s, %s)", ErrInvalidMergePolicy, policy, MergeKeepTarget, MergeKeepSource, MergeNewest)
+	}
+	if sourceID == targetID {
+		return nil, ErrMergeIntoSelf
+	}
+	source, err := m.auth.getWallet(ctx, sourceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get wallet %s: %w", sourceID, err)
+	}
+	if _, err := m.auth.getWallet(ctx, targetID); err != nil {
+		return nil, fmt.Errorf("failed to get wallet %s: %w", targetID, err)
+	}
+
+	report, err := m.sync.MergeUserData(ctx, sourceID, targetID, policy, dryRun)
+	if err != nil {
+		return nil, fmt.Errorf("failed to merge account data: %w", err)
+	}
+	if dryRun {
+		return report, nil
+	}
+
+	receipt, err := m.eraser.Erase(ctx, sourceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to erase merged wallet: %w", err)
+	}
+	tombstone, err := json.Marshal(mergedWallet{
+		ReceiptID:        receipt.ReceiptID.String(),
+		Salt:             source.Salt,
+		HashedPassphrase: source.HashedPassphrase,
+		PassphraseParams: source.PassphraseParams,
+	})
+	if err == nil {
+		err = m.auth.db.Set(ctx, mergedWalletKey(sourceID), string(tombstone), 0)
+	}
+	if err != nil {
+		fmt.Printf("Warning: failed to record merge tombstone of wallet %s: %v\n", sourceID, err)
+	}
+
+	mergedAt := receipt.DeletedAt
+	report.MergedAt = &mergedAt
+	report.Receipt = receipt
+	return report, nil
+}
+
+// provesMerged reports whether a wallet was merged into another one and passphrase was its
+// passphrase. It takes as long as a passphrase check whatever the answer, so wallets that were
+// merged can't be told apart from ones that never existed.
+func (s *AuthService) provesMerged(ctx context.Context, userID uuid.UUID, passphrase string) bool {
+	var tombstone mergedWallet
+	data, err := s.db.Get(ctx, mergedWalletKey(userID))
+	if err == nil {
+		err = json.Unmarshal([]byte(data), &tombstone)
+	}
+	if err != nil || tombstone.HashedPassphrase == "" {
+		s.burnSecretCheck(passphrase)
+		return false
+	}
+	return verifySecret(passphrase, tombstone.Salt, tombstone.HashedPassphrase, argon2ParamsOf(tombstone.PassphraseParams)) == nil
+}
@@ -0,0 +1,175 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/database"
+)
+
+// A thread's message count is client-encrypted like the rest of it, so the server counts the
+// messages each thread holds or inherits itself, in plaintext: for thread listings and the
+// per-thread message quota. Counters are stored by thread ID like the messages. A missing counter,
+// say of a thread archived before counters existed, is counted again when the thread is written.
+
+// ErrThreadMessageLimit is returned when creating a message in a thread holding as many messages as the quota allows
+var ErrThreadMessageLimit = errors.New("thread message limit reached")
+
+// messageCountKey returns the counter of the messages a thread holds or inherits
+func messageCountKey(threadID string) string {
+	return fmt.Sprintf("message_count:%s", threadID)
+}
+
+// storedMessageCount returns a thread's counter, counting its messages without storing the count
+// if there is none. The thread's messages have to be rehydrated.
+func (s *SyncService) storedMessageCount(ctx context.Context, threadID string) (int64, bool, error) {
+	data, err := s.db.Get(ctx, messageCountKey(threadID))
+	if err == nil {
+		if count, err := strconv.ParseInt(data, 10, 64); err == nil {
+			return count, true, nil
+		}
+	} else if !database.IsNotFound(err) {
+		return 0, false, fmt.Errorf("failed to get message count: %w", err)
+	}
+
+	messageIDs, err := s.threadMessageIDs(ctx, threadID)
+	if err != nil {
+		return 0, false, err
+	}
+	return int64(len(messageIDs)), false, nil
+}
+
+// prepareMessageCount makes sure a thread has a counter before one of its messages is created or
+// deleted. The thread's messages have to be rehydrated.
+func (s *SyncService) prepareMessageCount(ctx context.Context, threadID string) error {
+	count, stored, err := s.storedMessageCount(ctx, threadID)
+	if err != nil || stored {
+		return err
+	}
+	if _, err := s.db.SetNX(ctx, messageCountKey(threadID), count, 0); err != nil {
+		return fmt.Errorf("failed to store message count: %w", err)
+	}
+	return nil
+}
+
+func (s *SyncService) checkThreadMessageLimit(count int64) error {
+	if limit := s.quotas.MessagesPerThread; limit > 0 && count >= limit {
+		return fmt.Errorf("%w: the thread holds %d of %d messages", ErrThreadMessageLimit, count, limit)
+	}
+	return nil
+}
+
+// reserveMessage counts a message about to be stored towards its thread's quota, refusing it if the
+// thread is full. The counter goes up before the check, so concurrent creates can't all fit under
+// the quota on their own and exceed it together.
+func (s *SyncService) reserveMessage(ctx context.Context, threadID string) error {
+	if err := s.prepareMessageCount(ctx, threadID); err != nil {
+		return err
+	}
+	count, err := s.db.IncrBy(ctx, messageCountKey(threadID), 1)
+	if err != nil {
+		return fmt.Errorf("failed to count message: %w", err)
+	}
+	if err := s.checkThreadMessageLimit(count - 1); err != nil {
+		s.adjustMessageCount(ctx, threadID, -1)
+		return err
+	}
+	return nil
+}
+
+// adjustMessageCount adds delta to a thread's counter after prepareMessageCount
+func (s *SyncService) adjustMessageCount(ctx context.Context, threadID string, delta int64) {
+	if _, err := s.db.IncrBy(ctx, messageCountKey(threadID), delta); err != nil {
+		fmt.Printf("Warning: failed to count messages of thread %s: %v\n", threadID, err)
+	}
+}
+
+// recountMessages sets a thread's counter to the messages it holds or inherits
+func (s *SyncService) recountMessages(ctx context.Context, threadID string) error {
+	messageIDs, err := s.threadMessageIDs(ctx, threadID)
+	if err != nil {
+		return err
+	}
+	if err := s.db.Set(ctx, messageCountKey(threadID), len(messageIDs), 0); err != nil {
+		return fmt.Errorf("failed to store message count: %w", err)
+	}
+	return nil
+}
+
+// GetMessageCounts returns the message counts of threads by thread ID. Threads without a counter
+// are left out.
+func (s *SyncService) GetMessageCounts(ctx context.Context, threadIDs []string) (map[string]int64, error) {
+	counts := make(map[string]int64, len(threadIDs))
+	if len(threadIDs) == 0 {
+		return counts, nil
+	}
+
+	keys := make([]string, len(threadIDs))
+	for i, threadID := range threadIDs {
+		keys[i] = messageCountKey(threadID)
+	}
+	values, err := s.db.MGet(ctx, keys...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get message counts: %w", err)
+	}
+	for i, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			continue
+		}
+		if count, err := strconv.ParseInt(data, 10, 64); err == nil {
+			counts[threadIDs[i]] = count
+		}
+	}
+	return counts, nil
+}
+
+// BuildMessageCounts counts the messages of existing threads. Archived threads are left to be
+// counted when they are restored. It only runs once; later runs are skipped via a marker key.
+func (s *SyncService) BuildMessageCounts(ctx context.Context) (int, error) {
+	const markerKey = "migrations:message_counts"
+	if _, err := s.db.Get(ctx, markerKey); err == nil {
+		return 0, nil
+	}
+
+	indexKeys, err := s.db.Keys(ctx, "threads_index:*")
+	if err != nil {
+		return 0, fmt.Errorf("failed to get thread indexes: %w", err)
+	}
+
+	counted := 0
+	for _, indexKey := range indexKeys {
+		userID, err := uuid.Parse(strings.TrimPrefix(indexKey, "threads_index:"))
+		if err != nil {
+			continue
+		}
+
+		threadIDs, err := s.db.SMembers(ctx, indexKey)
+		if err != nil {
+			return counted, fmt.Errorf("failed to get threads of user %s: %w", userID, err)
+		}
+
+		for _, threadID := range threadIDs {
+			if _, err := s.db.Get(ctx, fmt.Sprintf("archived_threads:%s", threadID)); err == nil {
+				continue
+			} else if !database.IsNotFound(err) {
+				return counted, fmt.Errorf("failed to get archive stub: %w", err)
+			}
+			if err := s.recountMessages(ctx, threadID); err != nil {
+				return counted, err
+			}
+			counted++
+		}
+	}
+
+	if err := s.db.Set(ctx, markerKey, s.clock.Now().Format(time.RFC3339), 0); err != nil {
+		return counted, fmt.Errorf("failed to store migration marker: %w", err)
+	}
+
+	return counted, nil
+}
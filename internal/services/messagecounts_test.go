@@ -0,0 +1,125 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// newCountedThread returns a sync service with a per-thread message quota and a thread of a new user
+func newCountedThread(t *testing.T, limit int64) (*SyncService, uuid.UUID, string) {
+	t.Helper()
+	s, _ := newTestSync(t, types.Quotas{MessagesPerThread: limit})
+	userID, threadID := uuid.New(), uuid.Must(uuid.NewV7())
+	if _, _, err := s.UpsertThread(context.Background(), &types.Thread{ID: threadID, UserID: userID, Version: 1}, ""); err != nil {
+		t.Fatalf("UpsertThread: %v", err)
+	}
+	return s, userID, threadID.String()
+}
+
+// messageCount returns a thread's counter
+func messageCount(t *testing.T, s *SyncService, threadID string) int64 {
+	t.Helper()
+	counts, err := s.GetMessageCounts(context.Background(), []string{threadID})
+	if err != nil {
+		t.Fatalf("GetMessageCounts: %v", err)
+	}
+	return counts[threadID]
+}
+
+func TestCreateMessageEnforcesLimitUnderConcurrency(t *testing.T) {
+	ctx := context.Background()
+	s, userID, threadID := newCountedThread(t, 3)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	created := 0
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			message := &types.Message{ID: fmt.Sprintf("m%d", i), Role: "user", Content: "c"}
+			err := s.CreateMessage(ctx, userID, threadID, message, "")
+			switch {
+			case err == nil:
+				mu.Lock()
+				created++
+				mu.Unlock()
+			case !errors.Is(err, ErrThreadMessageLimit):
+				t.Errorf("CreateMessage: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if created != 3 {
+		t.Errorf("created %d messages, want 3", created)
+	}
+	if count := messageCount(t, s, threadID); count != 3 {
+		t.Errorf("count = %d, want 3", count)
+	}
+}
+
+func TestCreateMessageCountsConcurrentCreatesOfOneIDOnce(t *testing.T) {
+	ctx := context.Background()
+	s, userID, threadID := newCountedThread(t, 0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			message := &types.Message{ID: "m", Role: "user", Content: "c"}
+			if err := s.CreateMessage(ctx, userID, threadID, message, ""); err != nil {
+				t.Errorf("CreateMessage: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if count := messageCount(t, s, threadID); count != 1 {
+		t.Errorf("count = %d, want 1", count)
+	}
+	if err := s.DeleteMessage(ctx, userID, threadID, "m", ""); err != nil {
+		t.Fatalf("DeleteMessage: %v", err)
+	}
+	if count := messageCount(t, s, threadID); count != 0 {
+		t.Errorf("count after delete = %d, want 0", count)
+	}
+}
+
+func TestUpdateMessageOfUnknownIDCountsTowardsLimit(t *testing.T) {
+	ctx := context.Background()
+	s, userID, threadID := newCountedThread(t, 2)
+
+	if err := s.CreateMessage(ctx, userID, threadID, &types.Message{ID: "a", Role: "user", Content: "c"}, ""); err != nil {
+		t.Fatalf("CreateMessage: %v", err)
+	}
+	if err := s.UpdateMessage(ctx, userID, threadID, &types.Message{ID: "b", Role: "user", Content: "c"}, 1, ""); err != nil {
+		t.Fatalf("UpdateMessage of a new ID: %v", err)
+	}
+	if count := messageCount(t, s, threadID); count != 2 {
+		t.Errorf("count = %d, want 2", count)
+	}
+
+	if err := s.UpdateMessage(ctx, userID, threadID, &types.Message{ID: "c", Role: "user", Content: "c"}, 1, ""); !errors.Is(err, ErrThreadMessageLimit) {
+		t.Errorf("UpdateMessage of a new ID in a full thread: error = %v, want ErrThreadMessageLimit", err)
+	}
+	if err := s.UpdateMessage(ctx, userID, threadID, &types.Message{ID: "b", Role: "user", Content: "edited"}, 2, ""); err != nil {
+		t.Errorf("UpdateMessage of an existing message in a full thread: %v", err)
+	}
+
+	for _, id := range []string{"a", "b", "b"} {
+		if err := s.DeleteMessage(ctx, userID, threadID, id, ""); err != nil {
+			t.Fatalf("DeleteMessage: %v", err)
+		}
+	}
+	if count := messageCount(t, s, threadID); count != 0 {
+		t.Errorf("count after deletes = %d, want 0", count)
+	}
+}
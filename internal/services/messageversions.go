@@ -0,0 +1,80 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/helioschat/sync/internal/database"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// Message versions live inside the encrypted message, so the server keeps the plaintext version
+// clients send with each update in a side index, and rejects updates that aren't newer.
+
+// messageVersionsKey returns the hash holding the last update version of each message of a thread
+func messageVersionsKey(threadID string) string {
+	return fmt.Sprintf("message_versions:%s", threadID)
+}
+
+// MessageConflictError reports a message update whose version isn't newer than the stored one, and
+// carries the stored message so the client can merge without fetching it
+type MessageConflictError struct {
+	Current       *types.Message
+	ServerVersion int64
+	ClientVersion int64
+}
+
+func (e *MessageConflictError) Error() string {
+	return fmt.Sprintf("%s: server version %d, client version %d", ErrVersionConflict, e.ServerVersion, e.ClientVersion)
+}
+
+func (e *MessageConflictError) Unwrap() error {
+	return ErrVersionConflict
+}
+
+// checkMessageVersion rejects an update of messageID with a version not newer than the stored one.
+// Updates without a version, from clients predating the index, and messages never updated with one
+// pass.
+func (s *SyncService) checkMessageVersion(ctx context.Context, threadID, messageID string, version int64) error {
+	if version <= 0 {
+		return nil
+	}
+
+	stored, err := s.db.HGet(ctx, messageVersionsKey(threadID), messageID)
+	if database.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get message version: %w", err)
+	}
+	serverVersion, err := strconv.ParseInt(stored, 10, 64)
+	if err != nil || version > serverVersion {
+		return nil
+	}
+
+	conflict := &MessageConflictError{ServerVersion: serverVersion, ClientVersion: version}
+	data, err := s.db.HGet(ctx, messagesKey(threadID), messageID)
+	if database.IsNotFound(err) {
+		data, err = s.getInheritedMessage(ctx, threadID, messageID)
+	}
+	if err == nil {
+		var current types.Message
+		if json.Unmarshal([]byte(data), &current) == nil {
+			conflict.Current = &current
+		}
+	}
+	return conflict
+}
+
+// saveMessageVersion records the version of the last update of messageID
+func (s *SyncService) saveMessageVersion(ctx context.Context, threadID, messageID string, version int64) error {
+	if version <= 0 {
+		return nil
+	}
+	if err := s.db.HSet(ctx, messageVersionsKey(threadID), messageID, strconv.FormatInt(version, 10)); err != nil {
+		return fmt.Errorf("failed to save message version: %w", err)
+	}
+	return nil
+}
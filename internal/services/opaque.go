@@ -0,0 +1,328 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/database"
+	"github.com/helioschat/sync/internal/opaque"
+	"github.com/helioschat/sync/internal/types"
+)
+
+const (
+	// A login has to be finished within this time of being started
+	opaqueLoginTTL = 5 * time.Minute
+
+	// Context string every OPAQUE key exchange with this server is bound to
+	opaqueContext = "HeliosSync-OPAQUE-v1"
+)
+
+var (
+	// ErrOpaqueNotRegistered is returned when a wallet has no OPAQUE registration to delete
+	ErrOpaqueNotRegistered = errors.New("no OPAQUE registration")
+	// ErrInvalidOpaqueMessage is returned for malformed registration and login messages
+	ErrInvalidOpaqueMessage = errors.New("invalid OPAQUE message")
+	// ErrOpaqueLoginFailed is returned when a client's KE3 doesn't prove knowledge of the passphrase
+	ErrOpaqueLoginFailed = errors.New("OPAQUE login failed")
+	// ErrOpaqueSignupNotFound is returned when finishing a wallet creation that wasn't started or expired
+	ErrOpaqueSignupNotFound = errors.New("unknown or expired OPAQUE signup")
+	// ErrOpaqueRequired is returned for passphrase logins of wallets that have to log in with OPAQUE
+	ErrOpaqueRequired = errors.New("wallet logs in with OPAQUE only")
+)
+
+// OpaqueService logs wallets in with OPAQUE, a password-authenticated key exchange: the client
+// proves it knows the passphrase without sending it or anything derived from it the server could
+// test guesses against offline. A wallet registers for it while logged in; the passphrase login
+// keeps working alongside.
+type OpaqueService struct {
+	auth   *AuthService
+	server *opaque.Server
+}
+
+// NewOpaqueService derives the OPAQUE server keys from secret, opaque.ServerKeySize random bytes.
+// Changing the secret invalidates every registration.
+func NewOpaqueService(auth *AuthService, secret []byte) (*OpaqueService, error) {
+	server, err := opaque.NewServer(secret, opaqueContext)
+	if err != nil {
+		return nil, err
+	}
+	return &OpaqueService{
+		auth:   auth,
+		server: server,
+	}, nil
+}
+
+// opaqueLogin is what the server remembers between a login's KE2 and the client's KE3
+type opaqueLogin struct {
+	UserID            uuid.UUID `json:"user_id"`
+	ExpectedClientMAC []byte    `json:"expected_client_mac"`
+}
+
+// opaqueRecordKey holds the registration record of a wallet
+func opaqueRecordKey(userID uuid.UUID) string {
+	return fmt.Sprintf("opaque_records:%s", userID.String())
+}
+
+func opaqueLoginKey(loginID string) string {
+	return "opaque_logins:" + loginID
+}
+
+// opaqueSignupKey marks a UID handed out for a wallet creation that hasn't finished yet
+func opaqueSignupKey(userID uuid.UUID) string {
+	return fmt.Sprintf("opaque_signups:%s", userID.String())
+}
+
+// RequireOpaqueLogin refuses passphrase logins of wallets registered for OPAQUE, so their passphrase
+// doesn't have to be sent to the server ever again
+func (s *AuthService) RequireOpaqueLogin() {
+	s.opaqueOnly = true
+}
+
+// BeginSignup starts creating a wallet whose passphrase the server never sees: it picks the UID and
+// evaluates the OPRF on the client's blinded passphrase for it. The passphrase policy can't be
+// checked server-side; clients have to enforce it.
+func (o *OpaqueService) BeginSignup(ctx context.Context, request []byte) (*types.OpaqueSignupChallenge, error) {
+	userID := uuid.New()
+	response, err := o.server.RegistrationResponse(request, userID[:])
+	if err != nil {
+		return nil, opaqueError(err)
+	}
+	if err := o.auth.db.Set(ctx, opaqueSignupKey(userID), "1", int64(opaqueLoginTTL.Seconds())); err != nil {
+		return nil, fmt.Errorf("failed to save signup: %w", err)
+	}
+	return &types.OpaqueSignupChallenge{
+		UserID:               userID,
+		RegistrationResponse: response,
+		ServerPublicKey:      o.server.PublicKey(),
+		ExpiresAt:            o.auth.clock.Now().Add(opaqueLoginTTL),
+	}, nil
+}
+
+// FinishSignup creates the wallet started by BeginSignup with the client's registration record. The
+// wallet has no passphrase hash: it logs in with OPAQUE, or with its recovery code if it asked for one.
+func (o *OpaqueService) FinishSignup(ctx context.Context, userID uuid.UUID, record []byte, withRecoveryCode bool) (*types.Wallet, string, error) {
+	if _, err := opaque.ParseRecord(record); err != nil {
+		return nil, "", opaqueError(err)
+	}
+	// Read and deleted in one step, so two requests can't both finish the same signup
+	if _, err := o.auth.db.GetDel(ctx, opaqueSignupKey(userID)); err != nil {
+		if database.IsNotFound(err) {
+			return nil, "", ErrOpaqueSignupNotFound
+		}
+		return nil, "", fmt.Errorf("failed to consume signup: %w", err)
+	}
+
+	wallet := &types.Wallet{
+		UID:       userID,
+		CreatedAt: o.auth.clock.Now(),
+	}
+	var recoveryCode string
+	if withRecoveryCode {
+		code, err := o.auth.setRecoveryCode(wallet)
+		if err != nil {
+			return nil, "", err
+		}
+		recoveryCode = code
+	}
+
+	// The record goes first: a wallet without one couldn't log in at all
+	if err := o.FinishRegistration(ctx, userID, record); err != nil {
+		return nil, "", err
+	}
+	if err := o.auth.saveWallet(ctx, wallet); err != nil {
+		return nil, "", err
+	}
+
+	return &types.Wallet{UID: userID, CreatedAt: wallet.CreatedAt}, recoveryCode, nil
+}
+
+// BeginRegistration evaluates the OPRF on the client's blinded passphrase. It keeps no state: the
+// client finishes the registration by uploading its record.
+func (o *OpaqueService) BeginRegistration(userID uuid.UUID, request []byte) (*types.OpaqueRegistrationResponse, error) {
+	response, err := o.server.RegistrationResponse(request, userID[:])
+	if err != nil {
+		return nil, opaqueError(err)
+	}
+	return &types.OpaqueRegistrationResponse{
+		RegistrationResponse: response,
+		ServerPublicKey:      o.server.PublicKey(),
+	}, nil
+}
+
+// FinishRegistration stores the record the client uploads, replacing an earlier registration
+func (o *OpaqueService) FinishRegistration(ctx context.Context, userID uuid.UUID, record []byte) error {
+	if _, err := opaque.ParseRecord(record); err != nil {
+		return opaqueError(err)
+	}
+	if err := o.auth.db.Set(ctx, opaqueRecordKey(userID), base64.StdEncoding.EncodeToString(record), 0); err != nil {
+		return fmt.Errorf("failed to save OPAQUE record: %w", err)
+	}
+	return nil
+}
+
+// DeleteRegistration removes a wallet's OPAQUE registration; it then logs in with its passphrase only
+func (o *OpaqueService) DeleteRegistration(ctx context.Context, userID uuid.UUID) error {
+	if _, err := o.loadRecord(ctx, userID); err != nil {
+		return err
+	}
+	return o.auth.deleteOpaqueRecord(ctx, userID)
+}
+
+// BeginLogin answers a client's KE1. Wallets that don't exist or never registered get an answer
+// indistinguishable from a registered wallet's, so logins don't reveal which UIDs are in use.
+func (o *OpaqueService) BeginLogin(ctx context.Context, userID uuid.UUID, ke1 []byte, clientIP string) (*types.OpaqueLoginChallenge, error) {
+	if err := o.auth.checkLoginLockout(ctx, userID, clientIP); err != nil {
+		return nil, err
+	}
+
+	record, err := o.loadRecord(ctx, userID)
+	if errors.Is(err, ErrOpaqueNotRegistered) {
+		record, err = o.server.FakeRecord(userID[:])
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ke2, state, err := o.server.GenerateKE2(record, userID[:], ke1)
+	if err != nil {
+		return nil, opaqueError(err)
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, fmt.Errorf("failed to generate login ID: %w", err)
+	}
+	loginID := base64.RawURLEncoding.EncodeToString(raw)
+
+	data, err := json.Marshal(opaqueLogin{UserID: userID, ExpectedClientMAC: state.ExpectedClientMAC})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal login: %w", err)
+	}
+	if err := o.auth.db.Set(ctx, opaqueLoginKey(loginID), string(data), int64(opaqueLoginTTL.Seconds())); err != nil {
+		return nil, fmt.Errorf("failed to save login: %w", err)
+	}
+
+	return &types.OpaqueLoginChallenge{
+		LoginID:   loginID,
+		KE2:       ke2,
+		ExpiresAt: o.auth.clock.Now().Add(opaqueLoginTTL),
+	}, nil
+}
+
+// FinishLogin verifies the client's KE3 and logs the wallet in with the same tokens as a passphrase
+// login. Failures count towards the login lockout, and wallets with TOTP enabled need a code as well.
+func (o *OpaqueService) FinishLogin(ctx context.Context, loginID string, ke3 []byte, totpCode, machineID, clientIP string) (uuid.UUID, *types.AuthTokens, error) {
+	// A KE3 is only ever checked once, so it can't be guessed at. The login is read and deleted in
+	// one step, so concurrent requests with the same login ID don't each get a try.
+	data, err := o.auth.db.GetDel(ctx, opaqueLoginKey(loginID))
+	if err != nil {
+		if database.IsNotFound(err) {
+			return uuid.Nil, nil, fmt.Errorf("%w: unknown or expired login", ErrOpaqueLoginFailed)
+		}
+		return uuid.Nil, nil, fmt.Errorf("failed to consume login: %w", err)
+	}
+
+	var login opaqueLogin
+	if err := json.Unmarshal([]byte(data), &login); err != nil {
+		return uuid.Nil, nil, fmt.Errorf("failed to unmarshal login: %w", err)
+	}
+	userID := login.UserID
+
+	if err := o.auth.checkLoginLockout(ctx, userID, clientIP); err != nil {
+		return uuid.Nil, nil, err
+	}
+
+	state := &opaque.LoginState{ExpectedClientMAC: login.ExpectedClientMAC}
+	if _, err := state.Finish(ke3); err != nil {
+		o.auth.recordLoginFailure(ctx, userID, clientIP)
+		return uuid.Nil, nil, ErrOpaqueLoginFailed
+	}
+
+	wallet, err := o.auth.getWallet(ctx, userID)
+	if err != nil {
+		if database.IsNotFound(err) {
+			o.auth.recordLoginFailure(ctx, userID, clientIP)
+			return uuid.Nil, nil, ErrOpaqueLoginFailed
+		}
+		return uuid.Nil, nil, err
+	}
+	if err := o.auth.verifyTOTP(ctx, wallet, totpCode); err != nil {
+		if errors.Is(err, ErrInvalidTOTP) {
+			o.auth.recordLoginFailure(ctx, userID, clientIP)
+		}
+		return uuid.Nil, nil, err
+	}
+	o.auth.clearLoginFailures(ctx, userID)
+
+	if wallet.ExpiresAt != nil && wallet.ExpiresAt.Before(o.auth.clock.Now()) {
+		return uuid.Nil, nil, errors.New("wallet has expired")
+	}
+	if machineID != "" {
+		if err := o.auth.checkMachineTokens(ctx, userID, machineID); err != nil {
+			return uuid.Nil, nil, err
+		}
+	}
+
+	tokens, err := o.auth.issueLoginTokens(ctx, userID, machineID)
+	if err != nil {
+		return uuid.Nil, nil, err
+	}
+
+	// Shown on the account screen; a failure must not block the login
+	now := o.auth.clock.Now()
+	wallet.LastLoginAt = &now
+	if err := o.auth.saveWallet(ctx, wallet); err != nil {
+		fmt.Printf("Warning: failed to record last login: %v\n", err)
+	}
+
+	return userID, tokens, nil
+}
+
+func (o *OpaqueService) loadRecord(ctx context.Context, userID uuid.UUID) (*opaque.Record, error) {
+	data, err := o.auth.db.Get(ctx, opaqueRecordKey(userID))
+	if err != nil {
+		if database.IsNotFound(err) {
+			return nil, ErrOpaqueNotRegistered
+		}
+		return nil, fmt.Errorf("failed to get OPAQUE record: %w", err)
+	}
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OPAQUE record: %w", err)
+	}
+	return opaque.ParseRecord(raw)
+}
+
+// hasOpaqueRecord reports whether a wallet is registered for OPAQUE
+func (s *AuthService) hasOpaqueRecord(ctx context.Context, userID uuid.UUID) (bool, error) {
+	if _, err := s.db.Get(ctx, opaqueRecordKey(userID)); err != nil {
+		if database.IsNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to get OPAQUE record: %w", err)
+	}
+	return true, nil
+}
+
+// deleteOpaqueRecord drops a wallet's OPAQUE registration. A registration proves the passphrase it
+// was made with, so it has to go whenever the passphrase changes.
+func (s *AuthService) deleteOpaqueRecord(ctx context.Context, userID uuid.UUID) error {
+	if err := s.db.Del(ctx, opaqueRecordKey(userID)); err != nil {
+		return fmt.Errorf("failed to delete OPAQUE record: %w", err)
+	}
+	return nil
+}
+
+// opaqueError maps protocol errors of malformed messages to ErrInvalidOpaqueMessage
+func opaqueError(err error) error {
+	if errors.Is(err, opaque.ErrInvalidMessage) {
+		return fmt.Errorf("%w: %v", ErrInvalidOpaqueMessage, err)
+	}
+	return err
+}
@@ -0,0 +1,151 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/database"
+)
+
+// Threads are stored per user, but their messages are stored per thread ID. A thread ID therefore
+// belongs to the first user to create it: nobody else can create a thread under it, and messages
+// are only read and written under thread IDs the user owns, so one tenant's sync never reaches
+// another tenant's messages.
+
+// threadOwnerKey returns the ID of the user a thread ID belongs to
+func threadOwnerKey(threadID string) string {
+	return fmt.Sprintf("thread_owner:%s", threadID)
+}
+
+// claimThread makes threadID userID's, unless another user already owns it
+func (s *SyncService) claimThread(ctx context.Context, userID uuid.UUID, threadID string) error {
+	claimed, err := s.db.SetNX(ctx, threadOwnerKey(threadID), userID.String(), 0)
+	if err != nil {
+		return fmt.Errorf("failed to claim thread ID: %w", err)
+	}
+	if claimed {
+		return nil
+	}
+	owner, err := s.db.Get(ctx, threadOwnerKey(threadID))
+	if err != nil {
+		return fmt.Errorf("failed to get thread owner: %w", err)
+	}
+	if owner != userID.String() {
+		return fmt.Errorf("%w: %s", ErrThreadExists, threadID)
+	}
+	return nil
+}
+
+// ownsThread reports whether threadID belongs to userID. Thread IDs without a recorded owner,
+// from before ownership was recorded, belong to the user that has the thread.
+func (s *SyncService) ownsThread(ctx context.Context, userID uuid.UUID, threadID string) (bool, error) {
+	owner, err := s.db.Get(ctx, threadOwnerKey(threadID))
+	if err == nil {
+		return owner == userID.String(), nil
+	}
+	if !database.IsNotFound(err) {
+		return false, fmt.Errorf("failed to get thread owner: %w", err)
+	}
+
+	id, err := uuid.Parse(threadID)
+	if err != nil {
+		return false, nil
+	}
+	if _, err := s.getThread(ctx, userID, id); err != nil {
+		if database.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// CheckThreadOwner returns ErrThreadNotFound unless threadID is one of userID's threads. Message
+// reads and writes are checked with it.
+func (s *SyncService) CheckThreadOwner(ctx context.Context, userID uuid.UUID, threadID string) error {
+	owns, err := s.ownsThread(ctx, userID, threadID)
+	if err != nil {
+		return err
+	}
+	if !owns {
+		return ErrThreadNotFound
+	}
+	return nil
+}
+
+// CheckThreadOwners is CheckThreadOwner for several threads at once: it returns ErrThreadNotFound
+// unless all of threadIDs are userID's threads. The owners are read in one round trip.
+func (s *SyncService) CheckThreadOwners(ctx context.Context, userID uuid.UUID, threadIDs []string) error {
+	keys := make([]string, len(threadIDs))
+	for i, threadID := range threadIDs {
+		keys[i] = threadOwnerKey(threadID)
+	}
+	owners, err := s.db.MGet(ctx, keys...)
+	if err != nil {
+		return fmt.Errorf("failed to get thread owners: %w", err)
+	}
+
+	for i, owner := range owners {
+		if owner != nil {
+			if owner != userID.String() {
+				return ErrThreadNotFound
+			}
+			continue
+		}
+		// Only threads from before ownership was recorded take a lookup of their own
+		if err := s.CheckThreadOwner(ctx, userID, threadIDs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BuildThreadOwners records the owners of existing thread IDs from the per-user thread indexes.
+// Should two users have the same thread ID, the first one indexed keeps it. Later runs are
+// skipped via a marker key.
+func (s *SyncService) BuildThreadOwners(ctx context.Context) (int, error) {
+	const markerKey = "migrations:thread_owners"
+	if _, err := s.db.Get(ctx, markerKey); err == nil {
+		return 0, nil
+	}
+
+	indexKeys, err := s.db.Keys(ctx, "threads_index:*")
+	if err != nil {
+		return 0, fmt.Errorf("failed to get thread indexes: %w", err)
+	}
+
+	claimed := 0
+	for _, indexKey := range indexKeys {
+		userID, err := uuid.Parse(strings.TrimPrefix(indexKey, "threads_index:"))
+		if err != nil {
+			continue
+		}
+
+		threadIDs, err := s.db.SMembers(ctx, indexKey)
+		if err != nil {
+			return claimed, fmt.Errorf("failed to get threads of user %s: %w", userID, err)
+		}
+
+		for _, threadID := range threadIDs {
+			err := s.claimThread(ctx, userID, threadID)
+			if errors.Is(err, ErrThreadExists) {
+				fmt.Printf("Warning: thread ID %s is used by more than one user; user %s lost access to its messages\n", threadID, userID)
+				continue
+			}
+			if err != nil {
+				return claimed, fmt.Errorf("failed to claim thread %s: %w", threadID, err)
+			}
+			claimed++
+		}
+	}
+
+	if err := s.db.Set(ctx, markerKey, s.clock.Now().Format(time.RFC3339), 0); err != nil {
+		return claimed, fmt.Errorf("failed to store migration marker: %w", err)
+	}
+
+	return claimed, nil
+}
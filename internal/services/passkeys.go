@@ -0,0 +1,467 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/database"
+	"github.com/helioschat/sync/internal/types"
+	"github.com/helioschat/sync/internal/webauthn"
+)
+
+const (
+	// A registration or login has to be completed within this time of being started
+	passkeyChallengeTTL = 5 * time.Minute
+
+	passkeyChallengeBytes = 32
+	passkeyMaxPerWallet   = 20
+	passkeyMaxNameLength  = 64
+)
+
+// Ceremonies a passkey challenge is issued for
+const (
+	passkeyRegistration = "registration"
+	passkeyLogin        = "login"
+)
+
+var (
+	// ErrPasskeyNotFound is returned for credential IDs not registered to the wallet
+	ErrPasskeyNotFound = errors.New("passkey not found")
+	// ErrInvalidPasskey is returned for registrations and logins that don't verify
+	ErrInvalidPasskey = errors.New("invalid passkey response")
+	// ErrTooManyPasskeys is returned when a wallet already has passkeyMaxPerWallet passkeys
+	ErrTooManyPasskeys = errors.New("too many passkeys registered")
+)
+
+// PasskeyPolicy configures the WebAuthn relying party passkeys are registered with
+type PasskeyPolicy struct {
+	RPID    string   // domain of the client app the passkeys are bound to
+	RPName  string   // name the authenticator shows
+	Origins []string // origins the client app is served from
+}
+
+// PasskeyService registers WebAuthn passkeys to wallets and logs wallets in with them, as an
+// alternative to the passphrase. A passkey login issues the same tokens as a passphrase login.
+type PasskeyService struct {
+	auth   *AuthService
+	policy PasskeyPolicy
+}
+
+func NewPasskeyService(auth *AuthService, policy PasskeyPolicy) *PasskeyService {
+	return &PasskeyService{
+		auth:   auth,
+		policy: policy,
+	}
+}
+
+// storedPasskey is a passkey with the public key its logins are verified with
+type storedPasskey struct {
+	types.Passkey
+	PublicKey []byte `json:"public_key"` // COSE_Key
+	SignCount uint32 `json:"sign_count"`
+}
+
+// passkeyChallenge is a challenge waiting for the response to the ceremony it was issued for
+type passkeyChallenge struct {
+	Ceremony string     `json:"ceremony"`
+	UserID   *uuid.UUID `json:"user_id,omitempty"` // unset for logins with a discoverable passkey
+}
+
+// passkeysKey returns the hash of a wallet's passkeys, keyed by credential ID
+func passkeysKey(userID uuid.UUID) string {
+	return fmt.Sprintf("passkeys:%s", userID.String())
+}
+
+// passkeyOwnerKey maps a credential ID to the wallet it is registered to, so logins don't need a UID
+func passkeyOwnerKey(credentialID string) string {
+	return "passkey_owners:" + credentialID
+}
+
+func passkeyChallengeKey(challenge string) string {
+	return "passkey_challenges:" + challenge
+}
+
+// decodeBase64URL decodes a WebAuthn binary value, tolerating padding
+func decodeBase64URL(value string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+}
+
+// BeginRegistration starts registering a passkey to a wallet
+func (p *PasskeyService) BeginRegistration(ctx context.Context, userID uuid.UUID) (*types.PasskeyCreationOptions, error) {
+	passkeys, err := p.loadPasskeys(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(passkeys) >= passkeyMaxPerWallet {
+		return nil, ErrTooManyPasskeys
+	}
+
+	challenge, err := p.issueChallenge(ctx, passkeyChallenge{Ceremony: passkeyRegistration, UserID: &userID})
+	if err != nil {
+		return nil, err
+	}
+
+	options := &types.PasskeyCreationOptions{
+		Challenge: challenge,
+		RP:        types.PasskeyRelyingParty{ID: p.policy.RPID, Name: p.policy.RPName},
+		User: types.PasskeyUser{
+			ID:          base64.RawURLEncoding.EncodeToString(userID[:]),
+			Name:        userID.String(),
+			DisplayName: p.policy.RPName + " wallet",
+		},
+		Timeout:            passkeyChallengeTTL.Milliseconds(),
+		ExcludeCredentials: []types.PasskeyDescriptor{},
+		AuthenticatorSelection: types.PasskeyAuthenticatorSelection{
+			ResidentKey:      "preferred",
+			UserVerification: "required",
+		},
+		Attestation: "none",
+	}
+	for _, alg := range webauthn.SupportedAlgorithms {
+		options.PubKeyCredParams = append(options.PubKeyCredParams, types.PasskeyCredentialParameter{Type: "public-key", Alg: alg})
+	}
+	for _, passkey := range passkeys {
+		options.ExcludeCredentials = append(options.ExcludeCredentials, types.PasskeyDescriptor{Type: "public-key", ID: passkey.ID})
+	}
+	return options, nil
+}
+
+// FinishRegistration verifies the browser's response to BeginRegistration and stores the new passkey
+func (p *PasskeyService) FinishRegistration(ctx context.Context, userID uuid.UUID, name string, credential *types.PasskeyCredential) (*types.Passkey, error) {
+	clientData, err := decodeBase64URL(credential.Response.ClientDataJSON)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed client data", ErrInvalidPasskey)
+	}
+	challenge, err := p.consumeChallenge(ctx, clientData, passkeyRegistration)
+	if err != nil {
+		return nil, err
+	}
+	if challenge.UserID == nil || *challenge.UserID != userID {
+		return nil, fmt.Errorf("%w: challenge was issued to another wallet", ErrInvalidPasskey)
+	}
+
+	attestation, err := decodeBase64URL(credential.Response.AttestationObject)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed attestation object", ErrInvalidPasskey)
+	}
+	authData, err := webauthn.ParseAttestationObject(attestation)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPasskey, err)
+	}
+	if err := authData.Verify(p.policy.RPID, true); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPasskey, err)
+	}
+
+	credentialID := base64.RawURLEncoding.EncodeToString(authData.CredentialID)
+	if _, err := p.auth.db.Get(ctx, passkeyOwnerKey(credentialID)); err == nil {
+		return nil, fmt.Errorf("%w: passkey is already registered", ErrInvalidPasskey)
+	} else if !database.IsNotFound(err) {
+		return nil, fmt.Errorf("failed to look up passkey: %w", err)
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = "Passkey"
+	}
+	if len(name) > passkeyMaxNameLength {
+		name = name[:passkeyMaxNameLength]
+	}
+
+	passkey := &storedPasskey{
+		Passkey: types.Passkey{
+			ID:        credentialID,
+			Name:      name,
+			CreatedAt: p.auth.clock.Now(),
+		},
+		PublicKey: authData.PublicKey,
+		SignCount: authData.SignCount,
+	}
+	if err := p.savePasskey(ctx, userID, passkey); err != nil {
+		return nil, err
+	}
+	if err := p.auth.db.Set(ctx, passkeyOwnerKey(credentialID), userID.String(), 0); err != nil {
+		return nil, fmt.Errorf("failed to save passkey owner: %w", err)
+	}
+	return &passkey.Passkey, nil
+}
+
+// BeginLogin starts a passkey login. Without a user ID, the user picks any passkey they have for the client app.
+func (p *PasskeyService) BeginLogin(ctx context.Context, userID *uuid.UUID) (*types.PasskeyRequestOptions, error) {
+	challenge, err := p.issueChallenge(ctx, passkeyChallenge{Ceremony: passkeyLogin, UserID: userID})
+	if err != nil {
+		return nil, err
+	}
+
+	options := &types.PasskeyRequestOptions{
+		Challenge:        challenge,
+		RPID:             p.policy.RPID,
+		Timeout:          passkeyChallengeTTL.Milliseconds(),
+		UserVerification: "required",
+	}
+	if userID != nil {
+		passkeys, err := p.loadPasskeys(ctx, *userID)
+		if err != nil {
+			return nil, err
+		}
+		for _, passkey := range passkeys {
+			options.AllowCredentials = append(options.AllowCredentials, types.PasskeyDescriptor{Type: "public-key", ID: passkey.ID})
+		}
+	}
+	return options, nil
+}
+
+// FinishLogin verifies the browser's response to BeginLogin and logs the passkey's wallet in. Failures
+// count towards the login lockout like wrong passphrases. Passkeys require user verification, so
+// TOTP isn't asked for on top.
+func (p *PasskeyService) FinishLogin(ctx context.Context, credential *types.PasskeyCredential, machineID, clientIP string) (uuid.UUID, *types.AuthTokens, error) {
+	clientData, err := decodeBase64URL(credential.Response.ClientDataJSON)
+	if err != nil {
+		return uuid.Nil, nil, fmt.Errorf("%w: malformed client data", ErrInvalidPasskey)
+	}
+	challenge, err := p.consumeChallenge(ctx, clientData, passkeyLogin)
+	if err != nil {
+		return uuid.Nil, nil, err
+	}
+
+	credentialID := strings.TrimRight(credential.ID, "=")
+	owner, err := p.auth.db.Get(ctx, passkeyOwnerKey(credentialID))
+	if err != nil {
+		if database.IsNotFound(err) {
+			return uuid.Nil, nil, fmt.Errorf("%w: unknown passkey", ErrInvalidPasskey)
+		}
+		return uuid.Nil, nil, fmt.Errorf("failed to look up passkey: %w", err)
+	}
+	userID, err := uuid.Parse(owner)
+	if err != nil {
+		return uuid.Nil, nil, fmt.Errorf("invalid passkey owner: %w", err)
+	}
+	if challenge.UserID != nil && *challenge.UserID != userID {
+		return uuid.Nil, nil, fmt.Errorf("%w: passkey belongs to another wallet", ErrInvalidPasskey)
+	}
+	if handle := credential.Response.UserHandle; handle != "" && handle != base64.RawURLEncoding.EncodeToString(userID[:]) {
+		return uuid.Nil, nil, fmt.Errorf("%w: user handle mismatch", ErrInvalidPasskey)
+	}
+
+	if err := p.auth.checkLoginLockout(ctx, userID, clientIP); err != nil {
+		return uuid.Nil, nil, err
+	}
+
+	passkey, err := p.getPasskey(ctx, userID, credentialID)
+	if err != nil {
+		return uuid.Nil, nil, err
+	}
+	if err := p.verifyAssertion(passkey, clientData, credential); err != nil {
+		p.auth.recordLoginFailure(ctx, userID, clientIP)
+		return uuid.Nil, nil, err
+	}
+	p.auth.clearLoginFailures(ctx, userID)
+
+	wallet, err := p.auth.getWallet(ctx, userID)
+	if err != nil {
+		return uuid.Nil, nil, err
+	}
+	if wallet.ExpiresAt != nil && wallet.ExpiresAt.Before(p.auth.clock.Now()) {
+		return uuid.Nil, nil, errors.New("wallet has expired")
+	}
+	if machineID != "" {
+		if err := p.auth.checkMachineTokens(ctx, userID, machineID); err != nil {
+			return uuid.Nil, nil, err
+		}
+	}
+
+	tokens, err := p.auth.issueLoginTokens(ctx, userID, machineID)
+	if err != nil {
+		return uuid.Nil, nil, err
+	}
+
+	// Shown on the account and passkey screens; failures must not block the login
+	now := p.auth.clock.Now()
+	passkey.LastUsedAt = &now
+	if err := p.savePasskey(ctx, userID, passkey); err != nil {
+		fmt.Printf("Warning: failed to record passkey use: %v\n", err)
+	}
+	wallet.LastLoginAt = &now
+	if err := p.auth.saveWallet(ctx, wallet); err != nil {
+		fmt.Printf("Warning: failed to record last login: %v\n", err)
+	}
+
+	return userID, tokens, nil
+}
+
+// verifyAssertion checks a login response against the stored passkey and advances its signature counter
+func (p *PasskeyService) verifyAssertion(passkey *storedPasskey, clientData []byte, credential *types.PasskeyCredential) error {
+	rawAuthData, err := decodeBase64URL(credential.Response.AuthenticatorData)
+	if err != nil {
+		return fmt.Errorf("%w: malformed authenticator data", ErrInvalidPasskey)
+	}
+	signature, err := decodeBase64URL(credential.Response.Signature)
+	if err != nil {
+		return fmt.Errorf("%w: malformed signature", ErrInvalidPasskey)
+	}
+
+	authData, err := webauthn.ParseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidPasskey, err)
+	}
+	if err := authData.Verify(p.policy.RPID, true); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidPasskey, err)
+	}
+	if err := webauthn.VerifySignature(passkey.PublicKey, rawAuthData, clientData, signature); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidPasskey, err)
+	}
+
+	// Authenticators that count signatures never repeat a count; a repeat means the key was cloned
+	if (authData.SignCount != 0 || passkey.SignCount != 0) && authData.SignCount <= passkey.SignCount {
+		return fmt.Errorf("%w: signature counter went backwards", ErrInvalidPasskey)
+	}
+	passkey.SignCount = authData.SignCount
+	return nil
+}
+
+// ListPasskeys returns the passkeys registered to a wallet, oldest first
+func (p *PasskeyService) ListPasskeys(ctx context.Context, userID uuid.UUID) ([]types.Passkey, error) {
+	passkeys, err := p.loadPasskeys(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]types.Passkey, 0, len(passkeys))
+	for _, passkey := range passkeys {
+		list = append(list, passkey.Passkey)
+	}
+	return list, nil
+}
+
+// DeletePasskey removes a passkey from a wallet; it can't be used to log in anymore
+func (p *PasskeyService) DeletePasskey(ctx context.Context, userID uuid.UUID, credentialID string) error {
+	if _, err := p.getPasskey(ctx, userID, credentialID); err != nil {
+		return err
+	}
+	if _, err := p.auth.db.HDel(ctx, passkeysKey(userID), credentialID); err != nil {
+		return fmt.Errorf("failed to delete passkey: %w", err)
+	}
+	if err := p.auth.db.Del(ctx, passkeyOwnerKey(credentialID)); err != nil {
+		return fmt.Errorf("failed to delete passkey owner: %w", err)
+	}
+	return nil
+}
+
+// issueChallenge generates a random challenge and remembers what it was issued for
+func (p *PasskeyService) issueChallenge(ctx context.Context, challenge passkeyChallenge) (string, error) {
+	raw := make([]byte, passkeyChallengeBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("failed to generate challenge: %w", err)
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(raw)
+
+	data, err := json.Marshal(challenge)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal challenge: %w", err)
+	}
+	if err := p.auth.db.Set(ctx, passkeyChallengeKey(encoded), string(data), int64(passkeyChallengeTTL.Seconds())); err != nil {
+		return "", fmt.Errorf("failed to save challenge: %w", err)
+	}
+	return encoded, nil
+}
+
+// consumeChallenge verifies client data against the challenge it answers, which can't be answered again
+func (p *PasskeyService) consumeChallenge(ctx context.Context, clientData []byte, ceremony string) (*passkeyChallenge, error) {
+	encoded, err := webauthn.ChallengeOf(clientData)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPasskey, err)
+	}
+
+	// Read and deleted in one step, so two concurrent answers can't both use the challenge
+	data, err := p.auth.db.GetDel(ctx, passkeyChallengeKey(encoded))
+	if err != nil {
+		if database.IsNotFound(err) {
+			return nil, fmt.Errorf("%w: unknown or expired challenge", ErrInvalidPasskey)
+		}
+		return nil, fmt.Errorf("failed to consume challenge: %w", err)
+	}
+
+	var challenge passkeyChallenge
+	if err := json.Unmarshal([]byte(data), &challenge); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal challenge: %w", err)
+	}
+	if challenge.Ceremony != ceremony {
+		return nil, fmt.Errorf("%w: challenge was issued for a %s", ErrInvalidPasskey, challenge.Ceremony)
+	}
+
+	clientType := webauthn.TypeGet
+	if ceremony == passkeyRegistration {
+		clientType = webauthn.TypeCreate
+	}
+	if err := webauthn.VerifyClientData(clientData, clientType, encoded, p.policy.Origins); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPasskey, err)
+	}
+	return &challenge, nil
+}
+
+func (p *PasskeyService) loadPasskeys(ctx context.Context, userID uuid.UUID) ([]*storedPasskey, error) {
+	entries, err := p.auth.db.HGetAll(ctx, passkeysKey(userID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get passkeys: %w", err)
+	}
+
+	passkeys := make([]*storedPasskey, 0, len(entries))
+	for _, data := range entries {
+		var passkey storedPasskey
+		if err := json.Unmarshal([]byte(data), &passkey); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal passkey: %w", err)
+		}
+		passkeys = append(passkeys, &passkey)
+	}
+	sort.Slice(passkeys, func(i, j int) bool {
+		return passkeys[i].CreatedAt.Before(passkeys[j].CreatedAt)
+	})
+	return passkeys, nil
+}
+
+func (p *PasskeyService) getPasskey(ctx context.Context, userID uuid.UUID, credentialID string) (*storedPasskey, error) {
+	data, err := p.auth.db.HGet(ctx, passkeysKey(userID), credentialID)
+	if err != nil {
+		if database.IsNotFound(err) {
+			return nil, ErrPasskeyNotFound
+		}
+		return nil, fmt.Errorf("failed to get passkey: %w", err)
+	}
+
+	var passkey storedPasskey
+	if err := json.Unmarshal([]byte(data), &passkey); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal passkey: %w", err)
+	}
+	return &passkey, nil
+}
+
+func (p *PasskeyService) savePasskey(ctx context.Context, userID uuid.UUID, passkey *storedPasskey) error {
+	data, err := json.Marshal(passkey)
+	if err != nil {
+		return fmt.Errorf("failed to marshal passkey: %w", err)
+	}
+	if err := p.auth.db.HSet(ctx, passkeysKey(userID), passkey.ID, string(data)); err != nil {
+		return fmt.Errorf("failed to save passkey: %w", err)
+	}
+	return nil
+}
+
+// deletePasskeys removes all of a wallet's passkeys and their owner entries
+func (s *AuthService) deletePasskeys(ctx context.Context, userID uuid.UUID) error {
+	credentialIDs, err := s.db.HGetAll(ctx, passkeysKey(userID))
+	if err != nil {
+		return fmt.Errorf("failed to get passkeys: %w", err)
+	}
+	for credentialID := range credentialIDs {
+		if err := s.db.Del(ctx, passkeyOwnerKey(credentialID)); err != nil {
+			return fmt.Errorf("failed to delete passkey owner: %w", err)
+		}
+	}
+	return s.db.Del(ctx, passkeysKey(userID))
+}
@@ -0,0 +1,176 @@
+package services
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/helioschat/sync/internal/types"
+)
+
+// ErrWeakPassphrase is returned when a new passphrase violates the passphrase policy
+var ErrWeakPassphrase = errors.New("passphrase does not meet the passphrase policy")
+
+// Passphrase policy rules, as reported in violations
+const (
+	RuleMinLength  = "min_length"
+	RuleMinEntropy = "min_entropy"
+	RuleDenyList   = "deny_list"
+)
+
+// commonPassphrases are refused regardless of the configured deny list
+var commonPassphrases = []string{
+	"password", "passphrase", "123456", "12345678", "123456789", "1234567890", "qwerty", "qwertyuiop",
+	"abc123", "letmein", "iloveyou", "admin", "welcome", "monkey", "dragon", "football", "baseball",
+	"sunshine", "princess", "trustno1", "changeme", "secret", "master", "superman", "whatever",
+	"correcthorsebatterystaple", "helios", "helioschat", "heliossync",
+}
+
+// PolicyError lists every rule a passphrase violates
+type PolicyError struct {
+	Violations []types.PolicyViolation
+}
+
+func (e *PolicyError) Error() string {
+	messages := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		messages[i] = v.Message
+	}
+	return fmt.Sprintf("%s: %s", ErrWeakPassphrase, strings.Join(messages, "; "))
+}
+
+func (e *PolicyError) Unwrap() error {
+	return ErrWeakPassphrase
+}
+
+// PassphrasePolicy is enforced whenever a passphrase is chosen: at wallet generation and passphrase reset
+type PassphrasePolicy struct {
+	MinLength      int     // in characters
+	MinEntropyBits float64 // estimated guessing entropy
+	denied         map[string]bool
+}
+
+// NewPassphrasePolicy creates a policy denying the built-in common passphrases and those read from
+// denyList (one per line, may be nil)
+func NewPassphrasePolicy(minLength int, minEntropyBits float64, denyList io.Reader) (*PassphrasePolicy, error) {
+	policy := &PassphrasePolicy{
+		MinLength:      minLength,
+		MinEntropyBits: minEntropyBits,
+		denied:         make(map[string]bool),
+	}
+	for _, phrase := range commonPassphrases {
+		policy.denied[normalizePassphrase(phrase)] = true
+	}
+
+	if denyList != nil {
+		scanner := bufio.NewScanner(denyList)
+		for scanner.Scan() {
+			if phrase := normalizePassphrase(scanner.Text()); phrase != "" {
+				policy.denied[phrase] = true
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read passphrase deny list: %w", err)
+		}
+	}
+
+	return policy, nil
+}
+
+// Check returns a *PolicyError listing the violated rules, or nil if passphrase is acceptable
+func (p *PassphrasePolicy) Check(passphrase string) error {
+	if p == nil {
+		return nil
+	}
+
+	var violations []types.PolicyViolation
+	if length := utf8.RuneCountInString(passphrase); length < p.MinLength {
+		violations = append(violations, types.PolicyViolation{
+			Rule:    RuleMinLength,
+			Message: fmt.Sprintf("passphrase must be at least %d characters long", p.MinLength),
+		})
+	}
+
+	// Common phrases with digits or symbols tacked on are barely harder to guess
+	normalized := normalizePassphrase(passphrase)
+	if p.denied[normalized] || p.denied[strings.TrimRightFunc(normalized, unicode.IsDigit)] {
+		violations = append(violations, types.PolicyViolation{
+			Rule:    RuleDenyList,
+			Message: "passphrase is too common",
+		})
+	} else if entropy := estimateEntropy(passphrase); entropy < p.MinEntropyBits {
+		violations = append(violations, types.PolicyViolation{
+			Rule:    RuleMinEntropy,
+			Message: fmt.Sprintf("passphrase is too predictable (about %.0f bits, at least %.0f required)", entropy, p.MinEntropyBits),
+		})
+	}
+
+	if len(violations) > 0 {
+		return &PolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// normalizePassphrase lowercases and drops whitespace and punctuation, so "Pass word!" matches "password"
+func normalizePassphrase(passphrase string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || unicode.IsPunct(r) || unicode.IsSymbol(r) {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, passphrase)
+}
+
+// estimateEntropy estimates guessing entropy in bits in the spirit of zxcvbn: every character is worth
+// the size of the character classes used, except repeats and runs (aaa, abc, 321), which an attacker
+// guesses almost for free and only count one bit each.
+func estimateEntropy(passphrase string) float64 {
+	var lower, upper, digit, symbol, other bool
+	for _, r := range passphrase {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < utf8.RuneSelf:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+
+	pool := 0
+	for _, class := range []struct {
+		used bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if class.used {
+			pool += class.size
+		}
+	}
+	if pool == 0 {
+		return 0
+	}
+	bitsPerChar := math.Log2(float64(pool))
+
+	entropy := 0.0
+	var prev, prevStep rune
+	for i, r := range []rune(strings.ToLower(passphrase)) {
+		step := r - prev
+		predictable := i > 0 && (step == 0 || ((step == 1 || step == -1) && step == prevStep))
+		if predictable {
+			entropy++
+		} else {
+			entropy += bitsPerChar
+		}
+		prev, prevStep = r, step
+	}
+	return entropy
+}
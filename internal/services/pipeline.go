@@ -0,0 +1,161 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/database"
+)
+
+// Keys used for change pipeline accounting
+const (
+	pipelineAcceptedKey = "pipeline:accepted_writes"
+	pipelineRecordedKey = "pipeline:recorded_changes"
+	pipelineMissedKey   = "pipeline:missed" // list of changeRecord JSON, newest first
+)
+
+// changeRecord describes an accepted write whose change-log entry has to exist
+type changeRecord struct {
+	Resource   string    `json:"resource"`
+	Operation  string    `json:"operation"`
+	ResourceID string    `json:"resource_id"`
+	ThreadID   string    `json:"thread_id,omitempty"`
+	UserID     uuid.UUID `json:"user_id"`
+	MachineID  string    `json:"machine_id,omitempty"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// trackChange accounts for an accepted write. If its change-log entry could not be
+// written, the change is queued so RepairMissedChanges can recreate it later.
+func (s *SyncService) trackChange(ctx context.Context, record changeRecord, changeErr error) {
+	if _, err := s.db.Incr(ctx, pipelineAcceptedKey); err != nil {
+		fmt.Printf("Warning: failed to count accepted write: %v\n", err)
+	}
+
+	if changeErr == nil {
+		if _, err := s.db.Incr(ctx, pipelineRecordedKey); err != nil {
+			fmt.Printf("Warning: failed to count recorded change: %v\n", err)
+		}
+		return
+	}
+
+	// Log error but don't fail the operation
+	fmt.Printf("Warning: failed to record %s %s change for %s: %v\n", record.Resource, record.Operation, record.ResourceID, changeErr)
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		fmt.Printf("Warning: failed to marshal missed change: %v\n", err)
+		return
+	}
+	if err := s.db.LPush(ctx, pipelineMissedKey, string(data)); err != nil {
+		fmt.Printf("Warning: failed to queue missed change for repair: %v\n", err)
+	}
+}
+
+// PipelineStats summarizes the health of the change pipeline
+type PipelineStats struct {
+	AcceptedWrites  int64         // writes accepted by the service
+	RecordedChanges int64         // change-log entries written for them, including repairs
+	MissedChanges   int64         // changes waiting for repair
+	Lag             time.Duration // age of the oldest change waiting for repair
+}
+
+// PipelineStats returns the change pipeline counters
+func (s *SyncService) PipelineStats(ctx context.Context) (*PipelineStats, error) {
+	accepted, err := s.readCounter(ctx, pipelineAcceptedKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read accepted writes: %w", err)
+	}
+
+	recorded, err := s.readCounter(ctx, pipelineRecordedKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read recorded changes: %w", err)
+	}
+
+	missed, err := s.db.LLen(ctx, pipelineMissedKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read missed changes: %w", err)
+	}
+
+	stats := &PipelineStats{
+		AcceptedWrites:  accepted,
+		RecordedChanges: recorded,
+		MissedChanges:   missed,
+	}
+
+	if missed > 0 {
+		oldest, err := s.db.LIndex(ctx, pipelineMissedKey, -1)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read oldest missed change: %w", err)
+		}
+
+		var record changeRecord
+		if err := json.Unmarshal([]byte(oldest), &record); err == nil {
+			stats.Lag = s.clock.Now().Sub(record.Timestamp)
+		}
+	}
+
+	return stats, nil
+}
+
+func (s *SyncService) readCounter(ctx context.Context, key string) (int64, error) {
+	value, err := s.db.Get(ctx, key)
+	if err != nil {
+		if database.IsNotFound(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// RepairMissedChanges recreates the change-log entries of queued missed changes, oldest first.
+// Changes that still fail are put back on the queue.
+func (s *SyncService) RepairMissedChanges(ctx context.Context) (repaired int, failed int, err error) {
+	pending, err := s.db.LLen(ctx, pipelineMissedKey)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read missed changes: %w", err)
+	}
+
+	for i := int64(0); i < pending; i++ {
+		data, err := s.db.RPop(ctx, pipelineMissedKey)
+		if err != nil {
+			if database.IsNotFound(err) {
+				break
+			}
+			return repaired, failed, fmt.Errorf("failed to pop missed change: %w", err)
+		}
+
+		var record changeRecord
+		if err := json.Unmarshal([]byte(data), &record); err != nil {
+			fmt.Printf("Warning: dropping unreadable missed change: %v\n", err)
+			failed++
+			continue
+		}
+
+		if err := s.replayChange(ctx, record); err != nil {
+			fmt.Printf("Warning: failed to repair %s %s change for %s: %v\n", record.Resource, record.Operation, record.ResourceID, err)
+			if err := s.db.LPush(ctx, pipelineMissedKey, data); err != nil {
+				return repaired, failed, fmt.Errorf("failed to requeue missed change: %w", err)
+			}
+			failed++
+			continue
+		}
+
+		if _, err := s.db.Incr(ctx, pipelineRecordedKey); err != nil {
+			fmt.Printf("Warning: failed to count recorded change: %v\n", err)
+		}
+		repaired++
+	}
+
+	return repaired, failed, nil
+}
+
+// replayChange writes the change-log entry for a previously accepted write
+func (s *SyncService) replayChange(ctx context.Context, record changeRecord) error {
+	return s.appendChange(ctx, record)
+}
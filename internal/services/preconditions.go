@@ -0,0 +1,73 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/database"
+)
+
+// StoredDocument is the stored copy of a document a write would replace. Conditional writes are
+// checked against it, and it is returned to clients whose write conflicts with it.
+type StoredDocument struct {
+	Data      interface{}
+	Version   int64
+	UpdatedAt time.Time // server time of the last write; zero for threads, whose updated_at is client-encrypted
+}
+
+// GetStoredDocument returns the stored copy of one of the user's documents: a thread, memory or folder by
+// ID, or a settings document by resource name. It returns nil if there is none.
+func (s *SyncService) GetStoredDocument(ctx context.Context, userID uuid.UUID, resource, id string) (*StoredDocument, error) {
+	switch resource {
+	case "thread":
+		threadID, err := uuid.Parse(id)
+		if err != nil {
+			return nil, nil
+		}
+		thread, err := s.getThread(ctx, userID, threadID)
+		if err != nil {
+			return notStored(resource, err)
+		}
+		return &StoredDocument{Data: thread, Version: thread.Version}, nil
+	case "memory":
+		memoryID, err := uuid.Parse(id)
+		if err != nil {
+			return nil, nil
+		}
+		memory, err := s.getMemory(ctx, userID, memoryID)
+		if err != nil {
+			return notStored(resource, err)
+		}
+		return &StoredDocument{Data: memory, Version: memory.Version, UpdatedAt: memory.UpdatedAt}, nil
+	case "folder":
+		folderID, err := uuid.Parse(id)
+		if err != nil {
+			return nil, nil
+		}
+		folder, err := s.getFolder(ctx, userID, folderID)
+		if err != nil {
+			return notStored(resource, err)
+		}
+		return &StoredDocument{Data: folder, Version: folder.Version, UpdatedAt: folder.UpdatedAt}, nil
+	default:
+		if _, ok := lookupSettings(resource); !ok {
+			return nil, fmt.Errorf("unknown resource %q", resource)
+		}
+		document, err := s.GetSettings(ctx, resource, userID)
+		if err != nil {
+			return notStored(resource, err)
+		}
+		fields := document.Fields()
+		return &StoredDocument{Data: document, Version: *fields.Version, UpdatedAt: *fields.UpdatedAt}, nil
+	}
+}
+
+// notStored turns a missing document into nil
+func notStored(resource string, err error) (*StoredDocument, error) {
+	if database.IsNotFound(err) {
+		return nil, nil
+	}
+	return nil, fmt.Errorf("failed to get stored %s: %w", resource, err)
+}
@@ -0,0 +1,122 @@
+package services
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// conflictRules states how each resource's writes are reconciled. Keep them next to the code they
+// describe: a change to a write path's conflict handling must update its rule here.
+var conflictRules = []types.ProtocolRule{
+	{Resource: "thread", Rule: "Version is the client's last-modified time in unix milliseconds. A write whose version is not greater than the stored version is a conflict, resolved by the instance's thread conflict strategy. A deleted thread only comes back through a write newer than its tombstone; older writes are rejected with 409."},
+	{Resource: "message", Rule: "Message payloads are encrypted, so updates carry a plaintext version next to the data. An update whose version is not greater than the last update's is rejected with 409, and the stored message is returned in error.current. Updates without a version are last write wins."},
+	{Resource: "message_count", Rule: "The server counts the messages each thread holds or inherits, since the thread's own count is encrypted. Thread listings report the counts in message_counts by thread ID; threads archived before counting started are missing until they are written. With limits.quota_messages_per_thread, creating a message in a full thread is refused with 403."},
+	{Resource: "message_id", Rule: "Message IDs are unique within their thread: up to 128 characters of A-Z, a-z, 0-9, '.', '_', '~' and '-', optionally namespaced by the client as \"<prefix>:<id>\". Creating an existing message with the same content is an idempotent retry; with other content it is rejected with 409."},
+	{Resource: "thread_id", Rule: "A thread ID belongs to the first user to create a thread under it: creating a thread under another user's ID is rejected with 409, and message requests for threads the user doesn't own are answered with 404. Create a thread before its messages."},
+	{Resource: "batch", Rule: "POST /api/v1/sync/batch applies thread upserts, message creates and thread and message deletes in order, all or none. Each operation follows its resource's rules as applied after the batch's earlier operations; the first one failing rejects the batch, naming its index, and nothing of it is kept. Other requests, changes-since and the sync socket see the whole batch or none of it. With ?dry_run=true the batch runs every check and reports what each operation would do without writing anything."},
+	{Resource: "memory", Rule: "A write whose version is not greater than the stored version is rejected with 409."},
+	{Resource: "draft", Rule: "PUT /api/v1/sync/drafts/{thread_id} saves the draft of a message being written in one of the user's threads: its client-encrypted content and attachments, and the attachment_refs keeping uploaded attachments from collection. Drafts have no version; the last write wins. A draft expires limits.draft_ttl_seconds after its last write, at expires_at, without a delete operation being reported. Writes and DELETE are reported as draft operations, and unexpired drafts come in full with full syncs. Drafts are not exported, imported or merged."},
+	{Resource: "read_state", Rule: "PUT /api/v1/sync/read-states writes the read states of up to read_state_batch_max threads at once (see the limits of GET /api/v1/capabilities): a client-encrypted last_read marker and a plaintext unread flag. Read states have no version; each carries read_at, the client time it was set, and one older than the stored state is skipped. The response lists the stored state of each thread after the write, in request order. Written states are reported as read_state operations, and all read states come in full with full syncs. Read states are not exported, imported or merged."},
+	{Resource: "folder", Rule: "PUT /api/v1/sync/folders/{id} writes a folder: its client-encrypted name, a plaintext position folders are listed by, lowest first, and the thread_ids it holds. A write whose version is not greater than the stored version is rejected with 409. Deleting a folder leaves its threads in place. Folders are reported as folder operations, and in full with full syncs and bootstrap."},
+	{Resource: "provider_instances, disabled_models, advanced_settings, tool_servers, prompt_library, tags, profile, custom_instructions, favorite_models", Rule: "Writes replace the whole document. A write whose version is not greater than the stored version is a conflict, resolved by the instance's settings conflict strategy. settings_revisions tells clients which documents changed since they last read them. All but the profile can be deleted; they are then kept as a tombstone with deleted set, and writes whose version is not greater than the tombstone's are rejected with 409."},
+	{Resource: "vector_clock", Rule: "Every accepted write counts one for the writing machine ID in the written resource's clock; writes the server makes itself count for \"server\". Changes-since operations carry the resource's clock. A clock that isn't greater than or equal to the one a device last saw in every entry means the device's edit and the reported one are concurrent."},
+	{Resource: "archive", Rule: "PUT /api/v1/sync/threads/{id}/archive archives a thread and DELETE unarchives it; they are reported as archive and unarchive operations carrying the thread, whose archived field tells the state. Thread writes keep the stored state. GET /api/v1/sync/threads leaves archived threads out unless ?include_archived=true; full syncs, changes-since and bootstrap include them."},
+	{Resource: "attachment", Rule: "PUT /api/v1/sync/attachments/{id} uploads a client-encrypted blob as the raw request body, with its envelope version as ?enc_v=; reference the ID from the message's attachmentIds. Attachments are immutable: uploading the same content again answers 200, other content under the same ID 409. GET downloads the blob with its SHA-256 as ETag, GET /api/v1/sync/attachments lists metadata and DELETE removes one. Uploads and deletes are reported as attachment operations carrying the metadata. Unless features.attachments is set, the endpoints answer 501. With limits.quota_attachment_bytes, uploads that would take the user past it are refused with 413 attachment_quota_exceeded. Messages list the attachments they reference in plaintext attachment_refs; the server deletes attachments no message or draft references once they are older than a grace period, reported as deletes."},
+	{Resource: "purge", Rule: "POST /api/v1/sync/purge with older_than (a duration such as \"720h\") permanently removes thread, memory, folder and settings tombstones older than it and deletes archived threads last written before it, reported as deletes. Once a tombstone is purged, a device that was offline longer than older_than can upload the deleted record again."},
+	{Resource: "delete", Rule: "Deletes are reported as delete operations. Threads leave a tombstone versioned at their deletion, reported as a delete operation in full syncs too, and take their messages with them, each reported as a delete operation before the thread's; memories, folders and settings documents are kept as tombstones with deleted set. Other deletes remove the record, and a later write recreates it."},
+}
+
+const changesCursor = "GET /api/v1/sync/changes-since/{cursor} takes the sync_cursor of the previous response, an opaque token. " +
+	"0 requests a full sync; the sync_timestamp of a response, in unix milliseconds, is still accepted in place of its cursor. " +
+	"Otherwise the response lists the operations recorded after the cursor, each resource once with its latest state, " +
+	"and a new sync_cursor that resumes right after the last operation read. With ?wait=30s (at most 1m) a request finding nothing new " +
+	"is held open until a change is recorded or the wait elapses. Clients further behind than the change log reaches " +
+	"(limits.change_log_max_entries) get a full sync instead. A machine stores the sync_cursor it applied with PUT /api/v1/sync/checkpoint " +
+	"and reads it back with GET after losing its local state; entries every machine with a checkpoint updated in the last 30 days has applied " +
+	"are trimmed from the change log. GET /api/v1/sync/ws opens a WebSocket pushing the same operations as other machines write them, " +
+	"each with the sync_cursor resuming after it; a \"resync\" event means events were lost and changes-since has to be run again."
+
+// DescribeProtocol returns the parts of the protocol description owned by the services: conflict
+// rules, cursor semantics, limits, enforced requirements and examples generated from the API types
+func DescribeProtocol(auth *AuthService, sync *SyncService) *types.ProtocolDescription {
+	limits := map[string]int64{
+		"quota_threads":                sync.quotas.Threads,
+		"quota_messages":               sync.quotas.Messages,
+		"quota_memories":               sync.quotas.Memories,
+		"quota_messages_per_thread":    sync.quotas.MessagesPerThread,
+		"quota_attachment_bytes":       sync.quotas.AttachmentBytes,
+		"change_log_max_entries":       changeLogMaxLen,
+		"access_token_ttl_seconds":     int64(accessTokenTTL.Seconds()),
+		"refresh_token_ttl_seconds":    int64(refreshTokenTTL.Seconds()),
+		"guest_token_max_ttl_seconds":  int64(guestTokenMaxTTL.Seconds()),
+		"scoped_token_max_ttl_seconds": int64(scopedTokenMaxTTL.Seconds()),
+		"signature_max_skew_seconds":   int64(signatureMaxSkew.Seconds()),
+		"message_id_max_length":        types.MessageIDMaxLength,
+		"attachment_max_bytes":         sync.attachmentMaxBytes,
+		"draft_ttl_seconds":            int64(sync.draftTTL.Seconds()),
+	}
+	if auth.passphrasePolicy != nil {
+		limits["passphrase_min_length"] = int64(auth.passphrasePolicy.MinLength)
+	}
+
+	rules := append(slices.Clone(conflictRules), types.ProtocolRule{
+		Resource: "conflict_strategy",
+		Rule: fmt.Sprintf("Threads: %s; settings documents: %s. With %q a conflicting write is rejected with 409, and the stored document is returned in error.current. "+
+			"With %q it replaces the stored document, and the response's conflict field reports the version it overwrote; in batches, the operation's result does.",
+			sync.conflicts.Threads, sync.conflicts.Settings, types.ConflictReject, types.ConflictLastWriterWins),
+	})
+
+	return &types.ProtocolDescription{
+		Version:       types.ProtocolVersion,
+		ConflictRules: rules,
+		Cursor:        changesCursor,
+		Limits:        limits,
+		Requirements: map[string]bool{
+			"registered_machines": auth.machinePolicy.RequireRegistration,
+			"request_signatures":  auth.machinePolicy.RequireSignatures,
+		},
+		Examples: protocolExamples(),
+	}
+}
+
+// protocolExamples builds example payloads from the API types, so field names always match the wire format
+func protocolExamples() map[string]interface{} {
+	at := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	userID := uuid.MustParse("00000000-0000-4000-8000-000000000001")
+	threadID := uuid.MustParse("00000000-0000-4000-8000-000000000002")
+	machineID := "01942a1e-0000-7000-8000-000000000003"
+
+	thread := types.Thread{
+		ID:      threadID,
+		UserID:  userID,
+		Title:   "<client-encrypted>",
+		Model:   "<client-encrypted>",
+		Version: at.UnixMilli(),
+		EncV:    types.LegacyEncryptionVersion,
+	}
+
+	return map[string]interface{}{
+		"thread": thread,
+		"changes_since_response": types.ChangesSinceResponse{
+			Operations: []types.ChangeOperation{
+				{Resource: "thread", Operation: "update", ID: threadID.String(), MachineID: machineID, Data: thread, Timestamp: at, Clock: map[string]int64{machineID: 3}},
+				{Resource: "message", Operation: "delete", ID: "msg-1", MachineID: machineID, Timestamp: at.Add(time.Second), Clock: map[string]int64{machineID: 2}},
+			},
+			SyncCursor:    syncCursor{Entry: fmt.Sprintf("%d-0", at.Add(time.Second).UnixMilli())}.encode(),
+			SyncTimestamp: at.Add(time.Second),
+		},
+		"error_response": types.APIResponse{
+			Success: false,
+			Error: &types.APIError{
+				Code:      403,
+				ErrorCode: "insufficient_scope",
+				Message:   "Token scope does not permit this request",
+				Details:   "token lacks the write scope",
+			},
+		},
+	}
+}
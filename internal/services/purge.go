@@ -0,0 +1,169 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/database"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// ErrInvalidPurge is returned for purge requests without a positive age
+var ErrInvalidPurge = errors.New("invalid purge request")
+
+// Purge permanently removes the user's soft-deleted data older than req.OlderThan: thread, memory,
+// folder and settings tombstones, and archived threads not written since, which are deleted with their
+// messages like DeleteThread does. Devices offline for longer than the age can upload what a purged
+// tombstone kept out again.
+func (s *SyncService) Purge(ctx context.Context, userID uuid.UUID, req types.PurgeRequest, machineID string) (*types.PurgeReport, error) {
+	age, err := time.ParseDuration(req.OlderThan)
+	if err != nil || age <= 0 {
+		return nil, fmt.Errorf("%w: older_than must be a positive duration such as \"720h\", got %q", ErrInvalidPurge, req.OlderThan)
+	}
+
+	now := s.clock.Now()
+	report := &types.PurgeReport{OlderThan: age.String(), Cutoff: now.Add(-age)}
+
+	// Archived threads go first: deleting them writes tombstones, which are newer than the cutoff
+	if err := s.purgeArchivedThreads(ctx, userID, report, machineID); err != nil {
+		return nil, err
+	}
+	if err := s.purgeThreadTombstones(ctx, userID, report); err != nil {
+		return nil, err
+	}
+	if err := s.purgeMemoryTombstones(ctx, userID, report); err != nil {
+		return nil, err
+	}
+	if err := s.purgeFolderTombstones(ctx, userID, report); err != nil {
+		return nil, err
+	}
+	if err := s.purgeSettingsTombstones(ctx, userID, report); err != nil {
+		return nil, err
+	}
+
+	report.KeysFreed = report.ThreadTombstones + report.ArchivedThreads + report.Messages + report.MemoryTombstones + report.FolderTombstones + report.SettingsTombstones
+	report.PurgedAt = now
+	return report, nil
+}
+
+// purgeArchivedThreads deletes the archived threads whose version, their last write, is before the cutoff
+func (s *SyncService) purgeArchivedThreads(ctx context.Context, userID uuid.UUID, report *types.PurgeReport, machineID string) error {
+	archived, err := s.db.SMembers(ctx, archivedThreadsKey(userID))
+	if err != nil {
+		return fmt.Errorf("failed to get archived threads: %w", err)
+	}
+
+	for _, id := range archived {
+		threadID, err := uuid.Parse(id)
+		if err != nil {
+			continue
+		}
+		thread, err := s.getThread(ctx, userID, threadID)
+		if database.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !thread.Archived || !time.UnixMilli(thread.Version).Before(report.Cutoff) {
+			continue
+		}
+
+		// DeleteThread only deletes the messages of threads the user owns
+		var messageIDs []string
+		owns, err := s.ownsThread(ctx, userID, id)
+		if err != nil {
+			return err
+		}
+		if owns {
+			if messageIDs, err = s.threadMessageIDs(ctx, id); err != nil {
+				return err
+			}
+		}
+		if err := s.DeleteThread(ctx, userID, threadID, machineID); err != nil {
+			return err
+		}
+		report.ArchivedThreads++
+		report.Messages += len(messageIDs)
+	}
+	return nil
+}
+
+// purgeThreadTombstones forgets the deletions of threads deleted before the cutoff
+func (s *SyncService) purgeThreadTombstones(ctx context.Context, userID uuid.UUID, report *types.PurgeReport) error {
+	entries, err := s.db.HGetAll(ctx, threadTombstonesKey(userID))
+	if err != nil {
+		return fmt.Errorf("failed to get thread tombstones: %w", err)
+	}
+
+	var expired []string
+	for threadID, data := range entries {
+		var tombstone types.ThreadTombstone
+		if err := json.Unmarshal([]byte(data), &tombstone); err != nil {
+			continue
+		}
+		if tombstone.DeletedAt.Before(report.Cutoff) {
+			expired = append(expired, threadID)
+		}
+	}
+	if len(expired) == 0 {
+		return nil
+	}
+	if _, err := s.db.HDel(ctx, threadTombstonesKey(userID), expired...); err != nil {
+		return fmt.Errorf("failed to purge thread tombstones: %w", err)
+	}
+	report.ThreadTombstones = len(expired)
+	return nil
+}
+
+// purgeMemoryTombstones removes the memories deleted before the cutoff
+func (s *SyncService) purgeMemoryTombstones(ctx context.Context, userID uuid.UUID, report *types.PurgeReport) error {
+	memories, err := s.GetMemories(ctx, userID, true)
+	if err != nil {
+		return err
+	}
+
+	var expired []string
+	for _, memory := range memories {
+		if memory.Deleted && memory.UpdatedAt.Before(report.Cutoff) {
+			expired = append(expired, memory.ID.String())
+		}
+	}
+	if len(expired) == 0 {
+		return nil
+	}
+	if _, err := s.db.HDel(ctx, memoriesKey(userID), expired...); err != nil {
+		return fmt.Errorf("failed to purge memory tombstones: %w", err)
+	}
+	report.MemoryTombstones = len(expired)
+	return nil
+}
+
+// purgeSettingsTombstones removes the settings documents deleted before the cutoff
+func (s *SyncService) purgeSettingsTombstones(ctx context.Context, userID uuid.UUID, report *types.PurgeReport) error {
+	for _, settings := range SettingsResources {
+		resource := settings.Name
+		key := settingsKey(resource, userID)
+		data, err := optional(s.db.Get(ctx, key))
+		if err != nil {
+			return fmt.Errorf("failed to get %s: %w", resource, err)
+		}
+		if data == nil {
+			continue
+		}
+
+		var stored settingsTombstone
+		if err := json.Unmarshal([]byte(*data), &stored); err != nil || !stored.Deleted || !stored.UpdatedAt.Before(report.Cutoff) {
+			continue
+		}
+		if err := s.db.Del(ctx, key); err != nil {
+			return fmt.Errorf("failed to purge %s tombstone: %w", resource, err)
+		}
+		report.SettingsTombstones++
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/helioschat/sync/internal/database"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// errUnreadableRecord marks a stored record that failed to decode
+var errUnreadableRecord = errors.New("unreadable record")
+
+// quarantineKey is the hash of stored records that failed to decode, keyed by key and field
+const quarantineKey = "quarantine"
+
+// QuarantinedRecord points at a stored record that can't be decoded. The record itself is left
+// in place so an operator can inspect or fix it; list endpoints skip it and report it as corrupted.
+type QuarantinedRecord struct {
+	Resource   string    `json:"resource"` // "thread", "message", "memory", "folder", "draft" or "read_state"
+	UserID     string    `json:"user_id,omitempty"`
+	Key        string    `json:"key"`
+	Field      string    `json:"field,omitempty"` // hash field, empty for plain keys
+	Error      string    `json:"error"`
+	DetectedAt time.Time `json:"detected_at"`
+}
+
+func (r QuarantinedRecord) id() string {
+	return r.Key + "#" + r.Field
+}
+
+// quarantine records an unreadable record the first time it is seen
+func (s *SyncService) quarantine(ctx context.Context, record QuarantinedRecord, cause error) {
+	if _, err := s.db.HGet(ctx, quarantineKey, record.id()); err == nil {
+		return
+	}
+
+	record.Error = cause.Error()
+	record.DetectedAt = s.clock.Now()
+	fmt.Printf("Warning: quarantining unreadable %s %s %s: %v\n", record.Resource, record.Key, record.Field, cause)
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		fmt.Printf("Warning: failed to marshal quarantined record: %v\n", err)
+		return
+	}
+	if err := s.db.HSet(ctx, quarantineKey, record.id(), string(data)); err != nil {
+		fmt.Printf("Warning: failed to quarantine record: %v\n", err)
+	}
+}
+
+// GetQuarantinedRecords lists all quarantined records, oldest detection first
+func (s *SyncService) GetQuarantinedRecords(ctx context.Context) ([]QuarantinedRecord, error) {
+	entries, err := s.db.HGetAll(ctx, quarantineKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get quarantined records: %w", err)
+	}
+
+	records := make([]QuarantinedRecord, 0, len(entries))
+	for id, data := range entries {
+		var record QuarantinedRecord
+		if err := json.Unmarshal([]byte(data), &record); err != nil {
+			// Keep the entry visible even if its own metadata is damaged
+			key, field, _ := strings.Cut(id, "#")
+			record = QuarantinedRecord{Key: key, Field: field, Error: "unreadable quarantine entry"}
+		}
+		records = append(records, record)
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].DetectedAt.Before(records[j].DetectedAt)
+	})
+
+	return records, nil
+}
+
+// DropQuarantinedRecords deletes quarantined records that are still unreadable. Entries whose
+// record has since been fixed or removed are released from quarantine without touching the data.
+func (s *SyncService) DropQuarantinedRecords(ctx context.Context) (dropped int, released int, err error) {
+	records, err := s.GetQuarantinedRecords(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, record := range records {
+		var data string
+		var readErr error
+		if record.Field == "" {
+			data, readErr = s.db.Get(ctx, record.Key)
+		} else {
+			data, readErr = s.db.HGet(ctx, record.Key, record.Field)
+		}
+
+		switch {
+		case readErr != nil && !database.IsNotFound(readErr):
+			return dropped, released, fmt.Errorf("failed to read %s: %w", record.Key, readErr)
+		case readErr != nil || decodeRecord(record.Resource, data) == nil:
+			released++
+		default:
+			if err := s.dropRecord(ctx, record); err != nil {
+				return dropped, released, err
+			}
+			dropped++
+		}
+
+		if _, err := s.db.HDel(ctx, quarantineKey, record.id()); err != nil {
+			return dropped, released, fmt.Errorf("failed to release quarantine entry: %w", err)
+		}
+	}
+
+	return dropped, released, nil
+}
+
+// dropRecord deletes an unreadable record and its index entries
+func (s *SyncService) dropRecord(ctx context.Context, record QuarantinedRecord) error {
+	if record.Field != "" {
+		if _, err := s.db.HDel(ctx, record.Key, record.Field); err != nil {
+			return fmt.Errorf("failed to delete %s %s: %w", record.Key, record.Field, err)
+		}
+		return nil
+	}
+
+	if err := s.db.Del(ctx, record.Key); err != nil {
+		return fmt.Errorf("failed to delete %s: %w", record.Key, err)
+	}
+
+	// threads:{user}:{thread} is also referenced from the user's thread indexes
+	if parts := strings.SplitN(record.Key, ":", 3); record.Resource == "thread" && len(parts) == 3 {
+		if err := s.db.SRem(ctx, fmt.Sprintf("threads_index:%s", parts[1]), parts[2]); err != nil {
+			return fmt.Errorf("failed to unindex %s: %w", record.Key, err)
+		}
+		if err := s.db.ZRem(ctx, fmt.Sprintf("timestamps:threads:%s", parts[1]), parts[2]); err != nil {
+			return fmt.Errorf("failed to unindex %s: %w", record.Key, err)
+		}
+	}
+
+	return nil
+}
+
+// decodeRecord reports whether data decodes as the given resource
+func decodeRecord(resource, data string) error {
+	var target interface{}
+	switch resource {
+	case "thread":
+		target = &types.Thread{}
+	case "message":
+		target = &types.Message{}
+	case "memory":
+		target = &types.Memory{}
+	case "folder":
+		target = &types.Folder{}
+	case "draft":
+		target = &types.Draft{}
+	case "read_state":
+		target = &types.ReadState{}
+	default:
+		target = &map[string]interface{}{}
+	}
+	return json.Unmarshal([]byte(data), target)
+}
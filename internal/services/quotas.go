@@ -0,0 +1,97 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// Fractions of a limit at which writes start carrying warnings
+const (
+	quotaWarningRatio  = 0.80
+	quotaCriticalRatio = 0.95
+)
+
+// GetUsage counts the threads, messages, memories and attachment bytes a user stores
+func (s *SyncService) GetUsage(ctx context.Context, userID uuid.UUID) (*types.Usage, error) {
+	threads, err := s.db.ZCount(ctx, fmt.Sprintf("timestamps:threads:%s", userID.String()), "-inf", "+inf")
+	if err != nil {
+		return nil, fmt.Errorf("failed to count threads: %w", err)
+	}
+
+	messages, err := s.db.ZCount(ctx, messageIndexKey(userID), "-inf", "+inf")
+	if err != nil {
+		return nil, fmt.Errorf("failed to count messages: %w", err)
+	}
+
+	memories, err := s.GetMemories(ctx, userID, false)
+	if err != nil {
+		return nil, err
+	}
+
+	attachments, attachmentBytes, err := s.attachmentUsage(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.Usage{
+		Threads:         threads,
+		Messages:        messages,
+		Memories:        int64(len(memories)),
+		Attachments:     attachments,
+		AttachmentBytes: attachmentBytes,
+	}, nil
+}
+
+// GetUsageReport returns a user's usage together with the configured limits and any warnings
+func (s *SyncService) GetUsageReport(ctx context.Context, userID uuid.UUID) (*types.UsageResponse, error) {
+	usage, err := s.GetUsage(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.UsageResponse{
+		Usage:    *usage,
+		Quotas:   s.quotas,
+		Warnings: quotaWarnings(*usage, s.quotas),
+	}, nil
+}
+
+// QuotaWarnings returns the limits a user has crossed 80% or 95% of.
+// It returns nothing without querying storage when no limits are configured.
+func (s *SyncService) QuotaWarnings(ctx context.Context, userID uuid.UUID) ([]types.QuotaWarning, error) {
+	if s.quotas == (types.Quotas{}) {
+		return nil, nil
+	}
+
+	usage, err := s.GetUsage(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return quotaWarnings(*usage, s.quotas), nil
+}
+
+func quotaWarnings(usage types.Usage, quotas types.Quotas) []types.QuotaWarning {
+	warnings := []types.QuotaWarning{}
+	check := func(resource string, used, limit int64) {
+		if limit <= 0 {
+			return
+		}
+
+		ratio := float64(used) / float64(limit)
+		switch {
+		case ratio >= quotaCriticalRatio:
+			warnings = append(warnings, types.QuotaWarning{Resource: resource, Level: types.QuotaCriticalLevel, Used: used, Limit: limit})
+		case ratio >= quotaWarningRatio:
+			warnings = append(warnings, types.QuotaWarning{Resource: resource, Level: types.QuotaWarningLevel, Used: used, Limit: limit})
+		}
+	}
+
+	check("threads", usage.Threads, quotas.Threads)
+	check("messages", usage.Messages, quotas.Messages)
+	check("memories", usage.Memories, quotas.Memories)
+	check("attachment_bytes", usage.AttachmentBytes, quotas.AttachmentBytes)
+	return warnings
+}
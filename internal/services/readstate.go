@@ -0,0 +1,106 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// Read states are written whenever the user reads a thread, usually for several threads at once,
+// so they come in batches and carry no version. Writes from different devices are ordered by the
+// client's read_at instead: an older state never replaces a newer one. Like drafts, they are left
+// out of exports, imports and merges.
+
+// readStatesKey returns the hash holding all of a user's read states, keyed by thread ID
+func readStatesKey(userID uuid.UUID) string {
+	return fmt.Sprintf("read_states:%s", userID.String())
+}
+
+// GetReadStates returns a user's read states ordered by thread ID
+func (s *SyncService) GetReadStates(ctx context.Context, userID uuid.UUID) ([]types.ReadState, error) {
+	entries, err := s.db.HGetAll(ctx, readStatesKey(userID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get read states: %w", err)
+	}
+
+	states := []types.ReadState{}
+	for threadID, data := range entries {
+		var state types.ReadState
+		if err := json.Unmarshal([]byte(data), &state); err != nil {
+			s.quarantine(ctx, QuarantinedRecord{Resource: "read_state", UserID: userID.String(), Key: readStatesKey(userID), Field: threadID}, err)
+			continue
+		}
+		states = append(states, state)
+	}
+
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].ThreadID.String() < states[j].ThreadID.String()
+	})
+
+	return states, nil
+}
+
+// UpdateReadStates writes a batch of read states. A state set earlier on the client than the
+// stored one is skipped. The batch is compared and written in one atomic step, so concurrent
+// batches can't replace a newer state with an older one. It returns the stored state of each
+// thread after the batch, in request order, so the client learns which of its writes lost.
+func (s *SyncService) UpdateReadStates(ctx context.Context, userID uuid.UUID, states []types.ReadState, machineID string) ([]types.ReadState, error) {
+	fields := make([]string, len(states))
+	for i, state := range states {
+		fields[i] = state.ThreadID.String()
+	}
+
+	now := s.clock.Now()
+	var stored []types.ReadState
+	var written []string
+	err := s.db.HUpdate(ctx, readStatesKey(userID), fields, func(values []interface{}) (map[string]interface{}, error) {
+		stored = make([]types.ReadState, len(states))
+		written = written[:0]
+		changes := map[string]interface{}{}
+		latest := map[string]types.ReadState{} // by thread ID, so repeated threads compare against the batch too
+
+		for i, state := range states {
+			current, ok := latest[fields[i]]
+			if !ok {
+				// A stored state that doesn't parse is replaced
+				data, _ := values[i].(string)
+				ok = data != "" && json.Unmarshal([]byte(data), &current) == nil
+			}
+			if ok && current.ReadAt.After(state.ReadAt) {
+				stored[i] = current
+				continue
+			}
+
+			state.UpdatedAt = now
+			data, err := json.Marshal(state)
+			if err != nil {
+				return nil, fmt.Errorf("failed to marshal read state: %w", err)
+			}
+			changes[fields[i]] = string(data)
+			latest[fields[i]] = state
+			stored[i] = state
+			written = append(written, fields[i])
+		}
+		return changes, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to save read states: %w", err)
+	}
+
+	for _, threadID := range written {
+		s.recordChange(ctx, changeRecord{
+			Resource:   "read_state",
+			Operation:  "update",
+			ResourceID: threadID,
+			UserID:     userID,
+			MachineID:  machineID,
+			Timestamp:  now,
+		})
+	}
+
+	return stored, nil
+}
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// Recovery codes are 20 random bytes, shown as 8 groups of 4 base32 characters
+const (
+	recoveryCodeBytes     = 20
+	recoveryCodeGroupSize = 4
+)
+
+// ErrInvalidRecoveryCode is returned when a recovery code is missing or does not match
+var ErrInvalidRecoveryCode = errors.New("invalid recovery code")
+
+// setRecoveryCode generates a new recovery code and stores its hash in the wallet, replacing any previous one
+func (s *AuthService) setRecoveryCode(wallet *types.Wallet) (string, error) {
+	raw := make([]byte, recoveryCodeBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("failed to generate recovery code: %w", err)
+	}
+	code := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(raw)
+
+	salt, hash, err := hashSecret(code, s.hashParams)
+	if err != nil {
+		return "", err
+	}
+	params := s.hashParams
+	wallet.RecoverySalt = salt
+	wallet.HashedRecoveryKey = hash
+	wallet.RecoveryParams = &params
+
+	groups := make([]string, 0, len(code)/recoveryCodeGroupSize)
+	for i := 0; i < len(code); i += recoveryCodeGroupSize {
+		groups = append(groups, code[i:i+recoveryCodeGroupSize])
+	}
+	return strings.Join(groups, "-"), nil
+}
+
+// normalizeRecoveryCode accepts codes typed in lower case or without the separators
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToUpper(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// IssueRecoveryCode creates or replaces the recovery code of an existing wallet. The passphrase is
+// required so a stolen access token alone can't be turned into a permanent account takeover.
+func (s *AuthService) IssueRecoveryCode(ctx context.Context, userID uuid.UUID, passphrase string) (string, error) {
+	wallet, err := s.getWallet(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+
+	if err := checkPassphrase(wallet, passphrase); err != nil {
+		return "", errors.New("invalid passphrase")
+	}
+
+	code, err := s.setRecoveryCode(wallet)
+	if err != nil {
+		return "", err
+	}
+	if err := s.saveWallet(ctx, wallet); err != nil {
+		return "", err
+	}
+	if err := s.deleteOpaqueRecord(ctx, userID); err != nil {
+		return "", err
+	}
+
+	return code, nil
+}
+
+// ResetPassphrase sets a new passphrase using the wallet's recovery code, and totpCode if the wallet
+// has TOTP enabled. Recovery codes are single-use: a fresh one is issued and returned with every
+// successful reset.
+func (s *AuthService) ResetPassphrase(ctx context.Context, userID uuid.UUID, recoveryCode, totpCode, newPassphrase string) (string, error) {
+	if newPassphrase == "" {
+		return "", errors.New("passphrase cannot be empty")
+	}
+	if err := s.passphrasePolicy.Check(newPassphrase); err != nil {
+		return "", err
+	}
+
+	wallet, err := s.getWallet(ctx, userID)
+	if err != nil || wallet.HashedRecoveryKey == "" {
+		s.burnSecretCheck(recoveryCode)
+		return "", ErrInvalidRecoveryCode
+	}
+	if err := verifySecret(normalizeRecoveryCode(recoveryCode), wallet.RecoverySalt, wallet.HashedRecoveryKey, argon2ParamsOf(wallet.RecoveryParams)); err != nil {
+		return "", ErrInvalidRecoveryCode
+	}
+	if err := s.verifyTOTP(ctx, wallet, totpCode); err != nil {
+		return "", err
+	}
+
+	if err := s.setPassphrase(wallet, newPassphrase); err != nil {
+		return "", err
+	}
+
+	code, err := s.setRecoveryCode(wallet)
+	if err != nil {
+		return "", err
+	}
+	if err := s.saveWallet(ctx, wallet); err != nil {
+		return "", err
+	}
+	if err := s.deleteOpaqueRecord(ctx, userID); err != nil {
+		return "", err
+	}
+
+	return code, nil
+}
@@ -0,0 +1,193 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/clock"
+	"github.com/helioschat/sync/internal/database"
+)
+
+// Registration throttle scopes: a single address, its subnet, or the network operator (ASN) announcing it
+const (
+	ScopeIP     = "ip"
+	ScopeSubnet = "subnet"
+	ScopeASN    = "asn"
+)
+
+// Subnet sizes wallet creations are grouped by; a /48 is the usual IPv6 allocation to a single site
+const (
+	registrationSubnetV4 = 24
+	registrationSubnetV6 = 48
+)
+
+// ErrRegistrationThrottled is returned when a wallet creation would exceed a registration limit
+var ErrRegistrationThrottled = errors.New("too many wallets created from this network")
+
+// RegistrationRule caps wallet creations per scope within a sliding window
+type RegistrationRule struct {
+	Scope  string
+	Limit  int
+	Window time.Duration
+}
+
+// ThrottledError reports the rule a wallet creation was refused by
+type ThrottledError struct {
+	Rule       RegistrationRule
+	RetryAfter time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	return fmt.Sprintf("%s: at most %d per %s per %s", ErrRegistrationThrottled, e.Rule.Limit, e.Rule.Scope, e.Rule.Window)
+}
+
+func (e *ThrottledError) Unwrap() error {
+	return ErrRegistrationThrottled
+}
+
+// ParseRegistrationRules parses a comma-separated policy such as "ip:5/1h,subnet:20/1h,asn:100/24h"
+func ParseRegistrationRules(policy string) ([]RegistrationRule, error) {
+	var rules []RegistrationRule
+	for _, part := range strings.Split(policy, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		scope, rest, ok := strings.Cut(part, ":")
+		limit, window, ok2 := strings.Cut(rest, "/")
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("invalid registration rule %q, expected scope:limit/window", part)
+		}
+		if scope != ScopeIP && scope != ScopeSubnet && scope != ScopeASN {
+			return nil, fmt.Errorf("invalid registration rule %q: unknown scope %q (available: ip, subnet, asn)", part, scope)
+		}
+
+		rule := RegistrationRule{Scope: scope}
+		var err error
+		if rule.Limit, err = strconv.Atoi(limit); err != nil || rule.Limit < 1 {
+			return nil, fmt.Errorf("invalid registration rule %q: limit must be a positive number", part)
+		}
+		if rule.Window, err = time.ParseDuration(window); err != nil || rule.Window <= 0 {
+			return nil, fmt.Errorf("invalid registration rule %q: window must be a positive duration", part)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// RegistrationThrottle enforces registration rules with sliding windows kept in sorted sets,
+// so the limits hold across server instances sharing the storage
+type RegistrationThrottle struct {
+	db    database.Backend
+	rules []RegistrationRule
+	asns  *ASNTable // nil disables the asn rules
+	clock clock.Clock
+}
+
+func NewRegistrationThrottle(db database.Backend, rules []RegistrationRule, asns *ASNTable, clock clock.Clock) *RegistrationThrottle {
+	return &RegistrationThrottle{
+		db:    db,
+		rules: rules,
+		asns:  asns,
+		clock: clock,
+	}
+}
+
+// Check returns a *ThrottledError if creating a wallet from ip would exceed any rule
+func (t *RegistrationThrottle) Check(ctx context.Context, ip net.IP) error {
+	now := t.clock.Now()
+	for _, rule := range t.rules {
+		key, ok := t.key(rule.Scope, ip)
+		if !ok {
+			continue
+		}
+
+		// Members are "<unix ms>:<uuid>", so the oldest one tells when the window frees up
+		members, err := t.db.ZRangeByScore(ctx, key, strconv.FormatInt(now.Add(-rule.Window).UnixMilli(), 10), "+inf")
+		if err != nil {
+			return fmt.Errorf("failed to check registration limit: %w", err)
+		}
+		if len(members) < rule.Limit {
+			continue
+		}
+
+		retryAfter := rule.Window
+		if ms, _, found := strings.Cut(members[len(members)-rule.Limit], ":"); found {
+			if at, err := strconv.ParseInt(ms, 10, 64); err == nil {
+				retryAfter = time.UnixMilli(at).Add(rule.Window).Sub(now)
+			}
+		}
+		return &ThrottledError{Rule: rule, RetryAfter: retryAfter}
+	}
+	return nil
+}
+
+// Record counts a wallet creation from ip against every rule and forgets creations older than any window
+func (t *RegistrationThrottle) Record(ctx context.Context, ip net.IP) error {
+	now := t.clock.Now()
+	member := fmt.Sprintf("%d:%s", now.UnixMilli(), uuid.New().String())
+
+	longest := make(map[string]time.Duration)
+	for _, rule := range t.rules {
+		if rule.Window > longest[rule.Scope] {
+			longest[rule.Scope] = rule.Window
+		}
+	}
+
+	for scope, window := range longest {
+		key, ok := t.key(scope, ip)
+		if !ok {
+			continue
+		}
+		if err := t.db.ZAdd(ctx, key, float64(now.UnixMilli()), member); err != nil {
+			return fmt.Errorf("failed to record registration: %w", err)
+		}
+
+		expired, err := t.db.ZRangeByScore(ctx, key, "-inf", "("+strconv.FormatInt(now.Add(-window).UnixMilli(), 10))
+		if err != nil {
+			return fmt.Errorf("failed to prune registrations: %w", err)
+		}
+		if len(expired) > 0 {
+			members := make([]interface{}, len(expired))
+			for i, m := range expired {
+				members[i] = m
+			}
+			if err := t.db.ZRem(ctx, key, members...); err != nil {
+				return fmt.Errorf("failed to prune registrations: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// key returns the sorted set counting creations of ip's scope, or false if the scope can't be determined
+func (t *RegistrationThrottle) key(scope string, ip net.IP) (string, bool) {
+	switch scope {
+	case ScopeIP:
+		return "registrations:ip:" + ip.String(), true
+	case ScopeSubnet:
+		bits, size := registrationSubnetV6, 128
+		if ip.To4() != nil {
+			ip, bits, size = ip.To4(), registrationSubnetV4, 32
+		}
+		subnet := net.IPNet{IP: ip.Mask(net.CIDRMask(bits, size)), Mask: net.CIDRMask(bits, size)}
+		return "registrations:subnet:" + subnet.String(), true
+	case ScopeASN:
+		if t.asns == nil {
+			return "", false
+		}
+		asn, ok := t.asns.Lookup(ip)
+		if !ok {
+			return "", false
+		}
+		return fmt.Sprintf("registrations:asn:%d", asn), true
+	}
+	return "", false
+}
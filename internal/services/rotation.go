@@ -0,0 +1,155 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/database"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// RotateWallet moves a wallet whose UID may have leaked to a freshly generated UID, with a new salt
+// and hash for the same passphrase. Threads, messages, settings, memories, folders, attachments and
+// registered machines move with it; the previous UID is then erased like a deleted wallet, so its
+// tokens, sessions, guest and scoped tokens stop working. Passkeys and OPAQUE registrations are
+// bound to the UID they were registered for and have to be registered again. machineID is the
+// device asking, which gets tokens for the new UID.
+//
+// The rotation is recorded as a pending operation once the new wallet exists, so one interrupted
+// by a crash is finished by the next instance. Records are moved as stored, like in a merge.
+func (m *AccountMerger) RotateWallet(ctx context.Context, userID uuid.UUID, passphrase, machineID string) (*types.WalletRotation, error) {
+	wallet, err := m.auth.getWallet(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPassphrase(wallet, passphrase); err != nil {
+		return nil, ErrPassphraseConfirmation
+	}
+
+	rotated := *wallet
+	rotated.UID = uuid.New()
+	if err := m.auth.setPassphrase(&rotated, passphrase); err != nil {
+		return nil, err
+	}
+	if rotated.TOTPSecret, err = m.auth.resealTOTPSecret(wallet.UID, rotated.UID, wallet.TOTPSecret); err != nil {
+		return nil, err
+	}
+	if rotated.PendingTOTPSecret, err = m.auth.resealTOTPSecret(wallet.UID, rotated.UID, wallet.PendingTOTPSecret); err != nil {
+		return nil, err
+	}
+
+	receipt := NewDeletionReceipt(userID, m.auth.clock.Now())
+	op := &PendingOperation{Kind: OpWalletRotation, UserID: userID, TargetID: rotated.UID, Receipt: receipt}
+	if err := m.jobs.Begin(ctx, op); err != nil {
+		return nil, err
+	}
+	if err := m.auth.saveWallet(ctx, &rotated); err != nil {
+		m.jobs.Finish(ctx, op)
+		return nil, err
+	}
+
+	rotation, err := m.rotate(ctx, userID, rotated.UID, receipt)
+	if err != nil {
+		// The new wallet holds part of the data now: leave the operation to the recovery
+		return nil, fmt.Errorf("failed to rotate wallet: %w", err)
+	}
+	m.jobs.Finish(ctx, op)
+
+	if rotation.Tokens, err = m.auth.issueLoginTokens(ctx, rotated.UID, machineID); err != nil {
+		return nil, err
+	}
+	return rotation, nil
+}
+
+// rotate moves everything of previousID to the already saved wallet newID and erases previousID.
+// Running it again after an interruption finishes the rotation.
+func (m *AccountMerger) rotate(ctx context.Context, previousID, newID uuid.UUID, receipt *types.DeletionReceipt) (*types.WalletRotation, error) {
+	machines, err := m.auth.moveMachines(ctx, previousID, newID)
+	if err != nil {
+		return nil, err
+	}
+
+	report, err := m.sync.MergeUserData(ctx, previousID, newID, MergeKeepSource, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to move account data: %w", err)
+	}
+
+	// No merge tombstone: the previous UID must look like it never existed
+	if err := m.eraser.erase(ctx, previousID, receipt); err != nil {
+		return nil, err
+	}
+
+	return &types.WalletRotation{
+		UID:         newID,
+		PreviousUID: previousID,
+		Threads:     report.Threads,
+		Messages:    report.Messages,
+		Settings:    report.Settings,
+		Memories:    report.Memories,
+		Folders:     report.Folders,
+		Attachments: report.Attachments,
+		Machines:    machines,
+		RotatedAt:   receipt.DeletedAt,
+		Receipt:     receipt,
+	}, nil
+}
+
+// recoverRotation finishes a rotation left behind by a dead instance. If the new wallet was never
+// saved, the previous one is untouched and there is nothing to finish.
+func (m *AccountMerger) recoverRotation(ctx context.Context, op *PendingOperation) error {
+	if _, err := m.auth.getWallet(ctx, op.TargetID); err != nil {
+		if database.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	_, err := m.rotate(ctx, op.UserID, op.TargetID, op.Receipt)
+	return err
+}
+
+// moveMachines copies the registered machines of previousID and their signing secrets to newID,
+// so devices don't have to register again. They are deleted with the previous wallet.
+func (s *AuthService) moveMachines(ctx context.Context, previousID, newID uuid.UUID) (int, error) {
+	machines, err := s.GetMachines(ctx, previousID)
+	if err != nil {
+		return 0, err
+	}
+	for i := range machines {
+		machine := &machines[i]
+		if err := s.saveMachine(ctx, newID, machine); err != nil {
+			return 0, err
+		}
+
+		secret, err := s.sealer.HGet(ctx, s.db, machineSecretsKey(previousID), previousID, machine.ID.String())
+		if database.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to get signing secret: %w", err)
+		}
+		if err := s.sealer.HSet(ctx, s.db, machineSecretsKey(newID), newID, machine.ID.String(), secret); err != nil {
+			return 0, fmt.Errorf("failed to save signing secret: %w", err)
+		}
+	}
+	return len(machines), nil
+}
+
+// resealTOTPSecret re-encrypts a TOTP secret sealed for previousID for newID
+func (s *AuthService) resealTOTPSecret(previousID, newID uuid.UUID, sealed string) (string, error) {
+	if sealed == "" {
+		return "", nil
+	}
+	if s.totpSealer == nil {
+		return "", ErrTOTPUnavailable
+	}
+	encoded, err := s.totpSealer.Open(previousID, sealed)
+	if err != nil {
+		return "", fmt.Errorf("failed to open TOTP secret: %w", err)
+	}
+	resealed, err := s.totpSealer.Seal(newID, encoded)
+	if err != nil {
+		return "", fmt.Errorf("failed to seal TOTP secret: %w", err)
+	}
+	return resealed, nil
+}
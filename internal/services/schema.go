@@ -0,0 +1,166 @@
+package services
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// schemaTypes are the types published in the schema: the synced documents, what changes-since and
+// the sync socket return, and the request envelopes of the write endpoints
+var schemaTypes = []interface{}{
+	types.Thread{},
+	types.Message{},
+	types.Memory{},
+	types.Folder{},
+	types.Draft{},
+	types.ReadState{},
+	types.ProviderInstances{},
+	types.DisabledModels{},
+	types.AdvancedSettings{},
+	types.ToolServers{},
+	types.PromptLibrary{},
+	types.Tags{},
+	types.Profile{},
+	types.CustomInstructions{},
+	types.FavoriteModels{},
+	types.ChangeOperation{},
+	types.ChangesSinceResponse{},
+	types.BootstrapResponse{},
+	types.SyncEvent{},
+	types.SyncCheckpointRequest{},
+	types.ThreadUpdateRequest{},
+	types.BranchThreadRequest{},
+	types.MessageUpdateRequest{},
+	types.ProviderInstancesUpdateRequest{},
+	types.DisabledModelsUpdateRequest{},
+	types.AdvancedSettingsUpdateRequest{},
+	types.ToolServersUpdateRequest{},
+	types.PromptLibraryUpdateRequest{},
+	types.TagsUpdateRequest{},
+	types.ProfileUpdateRequest{},
+	types.CustomInstructionsUpdateRequest{},
+	types.FavoriteModelsUpdateRequest{},
+	types.MemoryUpdateRequest{},
+	types.FolderUpdateRequest{},
+	types.DraftUpdateRequest{},
+	types.ReadStateBatchRequest{},
+	types.BatchRequest{},
+	types.BatchResult{},
+	types.APIResponse{},
+}
+
+var syncSchema = sync.OnceValue(func() map[string]interface{} {
+	defs := map[string]interface{}{}
+	for _, value := range schemaTypes {
+		schemaOf(reflect.TypeOf(value), defs)
+	}
+	return map[string]interface{}{
+		"$schema":     "https://json-schema.org/draft/2020-12/schema",
+		"title":       "Helios sync API types",
+		"description": "Generated from the server's API types. Most string and JSON fields of the synced documents hold client-encrypted data the server never reads.",
+		"$defs":       defs,
+	}
+})
+
+// SyncSchema returns the JSON Schema of the sync API types. It is generated from the Go structs,
+// so the field names, types and required fields always match the wire format.
+func SyncSchema() map[string]interface{} {
+	return syncSchema()
+}
+
+var (
+	timeType = reflect.TypeOf(time.Time{})
+	uuidType = reflect.TypeOf(uuid.UUID{})
+)
+
+// schemaOf returns the schema of t. Named structs are added to defs once and referenced.
+func schemaOf(t reflect.Type, defs map[string]interface{}) map[string]interface{} {
+	switch t {
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case uuidType:
+		return map[string]interface{}{"type": "string", "format": "uuid"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return schemaOf(t.Elem(), defs)
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "contentEncoding": "base64"}
+		}
+		return map[string]interface{}{"type": "array", "items": schemaOf(t.Elem(), defs)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaOf(t.Elem(), defs)}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return structSchema(t, defs)
+		}
+		if _, ok := defs[t.Name()]; !ok {
+			defs[t.Name()] = true // placeholder, so recursive types end
+			defs[t.Name()] = structSchema(t, defs)
+		}
+		return map[string]interface{}{"$ref": "#/$defs/" + t.Name()}
+	}
+	// interface{}: any JSON value
+	return map[string]interface{}{}
+}
+
+// structSchema describes a struct as encoding/json writes it. Fields validated as required are
+// listed in required; the rest may be missing or, with omitempty, left out by the server.
+func structSchema(t reflect.Type, defs map[string]interface{}) map[string]interface{} {
+	properties := map[string]interface{}{}
+	required := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" && options == "" {
+			continue
+		}
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			embedded := structSchema(field.Type, defs)
+			for key, value := range embedded["properties"].(map[string]interface{}) {
+				properties[key] = value
+			}
+			if names, ok := embedded["required"].([]string); ok {
+				required = append(required, names...)
+			}
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		if strings.Contains(","+options+",", ",string,") {
+			properties[name] = map[string]interface{}{"type": "string"}
+		} else {
+			properties[name] = schemaOf(field.Type, defs)
+		}
+		if strings.Contains(field.Tag.Get("validate"), "required") || strings.Contains(field.Tag.Get("binding"), "required") {
+			required = append(required, name)
+		}
+	}
+
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/database"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// Scoped token lifetimes. They can't be refreshed, so they live longer than access tokens,
+// but never longer than refresh tokens.
+const (
+	scopedTokenDefaultTTL = 24 * time.Hour
+	scopedTokenMaxTTL     = refreshTokenTTL
+)
+
+// TokenScopes are the scopes a scoped token can be limited to
+var TokenScopes = []string{types.ScopeRead, types.ScopeWrite, types.ScopeAdmin}
+
+// ErrScopedTokenNotFound is returned when revoking a scoped token the wallet never minted or that expired
+var ErrScopedTokenNotFound = errors.New("scoped token not found")
+
+func scopedTokensKey(userID uuid.UUID) string {
+	return fmt.Sprintf("scoped_tokens:%s", userID.String())
+}
+
+// CreateScopedToken mints an access token limited to the requested scopes, e.g. a read-only token
+// for a dashboard or an export tool. Scoped tokens can't be refreshed.
+func (s *AuthService) CreateScopedToken(ctx context.Context, userID uuid.UUID, req types.ScopedTokenRequest) (*types.ScopedToken, error) {
+	if len(req.Scopes) == 0 {
+		return nil, errors.New("at least one scope is required")
+	}
+	scopes := []string{}
+	for _, scope := range req.Scopes {
+		if !slices.Contains(TokenScopes, scope) {
+			return nil, fmt.Errorf("unknown scope %q", scope)
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	ttl := scopedTokenDefaultTTL
+	if req.TTLMinutes > 0 {
+		ttl = time.Duration(req.TTLMinutes) * time.Minute
+	}
+	if ttl > scopedTokenMaxTTL {
+		return nil, fmt.Errorf("scoped tokens can be valid for at most %s", scopedTokenMaxTTL)
+	}
+
+	now := s.clock.Now()
+	info := types.ScopedTokenInfo{
+		TokenID:   uuid.New().String(),
+		Name:      req.Name,
+		Scopes:    scopes,
+		IssuedAt:  now,
+		ExpiresAt: now.Add(ttl),
+	}
+
+	claims := jwt.MapClaims{
+		"user_id": userID.String(),
+		"type":    "scoped",
+		"jti":     info.TokenID,
+		"scopes":  info.Scopes,
+		"exp":     info.ExpiresAt.Unix(),
+		"iat":     now.Unix(),
+	}
+
+	signed, err := s.signToken(claims)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign scoped token: %w", err)
+	}
+
+	// Keep a record so the wallet can list and revoke its scoped tokens
+	data, err := json.Marshal(info)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal scoped token info: %w", err)
+	}
+	if err := s.db.HSet(ctx, scopedTokensKey(userID), info.TokenID, string(data)); err != nil {
+		return nil, fmt.Errorf("failed to record scoped token: %w", err)
+	}
+
+	return &types.ScopedToken{Token: signed, ScopedTokenInfo: info}, nil
+}
+
+// ListScopedTokens returns the scoped tokens a wallet has minted that have not expired or been revoked
+func (s *AuthService) ListScopedTokens(ctx context.Context, userID uuid.UUID) ([]types.ScopedTokenInfo, error) {
+	key := scopedTokensKey(userID)
+	entries, err := s.db.HGetAll(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get scoped tokens: %w", err)
+	}
+
+	now := s.clock.Now()
+	tokens := []types.ScopedTokenInfo{}
+	for tokenID, data := range entries {
+		var info types.ScopedTokenInfo
+		if err := json.Unmarshal([]byte(data), &info); err != nil {
+			continue
+		}
+
+		// Drop expired records as we go
+		if info.ExpiresAt.Before(now) {
+			if _, err := s.db.HDel(ctx, key, tokenID); err != nil {
+				fmt.Printf("Warning: failed to prune expired scoped token: %v\n", err)
+			}
+			continue
+		}
+
+		tokens = append(tokens, info)
+	}
+
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].IssuedAt.Before(tokens[j].IssuedAt)
+	})
+
+	return tokens, nil
+}
+
+// RevokeScopedToken revokes a scoped token the wallet minted
+func (s *AuthService) RevokeScopedToken(ctx context.Context, userID uuid.UUID, tokenID string) error {
+	key := scopedTokensKey(userID)
+	data, err := s.db.HGet(ctx, key, tokenID)
+	if err != nil {
+		if database.IsNotFound(err) {
+			return ErrScopedTokenNotFound
+		}
+		return fmt.Errorf("failed to get scoped token: %w", err)
+	}
+
+	var info types.ScopedTokenInfo
+	if err := json.Unmarshal([]byte(data), &info); err != nil {
+		return fmt.Errorf("failed to unmarshal scoped token: %w", err)
+	}
+	if err := s.RevokeToken(ctx, &types.TokenClaims{UserID: userID, TokenID: info.TokenID, ExpiresAt: info.ExpiresAt}); err != nil {
+		return err
+	}
+	if _, err := s.db.HDel(ctx, key, tokenID); err != nil {
+		return fmt.Errorf("failed to forget scoped token: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/database"
+)
+
+// sealedPrefix marks values wrapped by a MetadataSealer; values without it were stored in the clear
+// before the mode was enabled and are still read
+const sealedPrefix = "sealed:v1:"
+
+// MetadataKeySize is the size of the master key metadata sealing is configured with
+const MetadataKeySize = 32
+
+// MetadataSealer wraps the structural metadata the server stores about users (machine records and
+// IDs, sessions, signing secrets, change-log linkage) with a per-user key derived from a master key,
+// so a storage dump doesn't reveal them. Hash field names are replaced with keyed hashes; the number
+// of fields stays visible. A nil sealer stores everything in the clear. A second sealer with its own
+// key encrypts TOTP secrets.
+type MetadataSealer struct {
+	masterKey []byte
+}
+
+// NewMetadataSealer creates a sealer from a MetadataKeySize byte master key
+func NewMetadataSealer(masterKey []byte) (*MetadataSealer, error) {
+	if len(masterKey) != MetadataKeySize {
+		return nil, fmt.Errorf("metadata key must be %d bytes, got %d", MetadataKeySize, len(masterKey))
+	}
+	return &MetadataSealer{masterKey: masterKey}, nil
+}
+
+// userKey derives the user's key, so one user's metadata can't be linked to another's by comparing values
+func (m *MetadataSealer) userKey(userID uuid.UUID) []byte {
+	mac := hmac.New(sha256.New, m.masterKey)
+	mac.Write([]byte("helios-metadata:" + userID.String()))
+	return mac.Sum(nil)
+}
+
+// Seal encrypts value for userID
+func (m *MetadataSealer) Seal(userID uuid.UUID, value string) (string, error) {
+	if m == nil || value == "" {
+		return value, nil
+	}
+
+	gcm, err := m.cipher(userID)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(value), userID[:])
+	return sealedPrefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a value sealed for userID. Values stored in the clear are returned as they are.
+func (m *MetadataSealer) Open(userID uuid.UUID, value string) (string, error) {
+	encoded, ok := strings.CutPrefix(value, sealedPrefix)
+	if !ok {
+		return value, nil
+	}
+	if m == nil {
+		return "", errors.New("value is sealed but metadata sealing is not configured")
+	}
+
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode sealed value: %w", err)
+	}
+	gcm, err := m.cipher(userID)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("sealed value is too short")
+	}
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], userID[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to open sealed value: %w", err)
+	}
+	return string(plain), nil
+}
+
+// Field returns the hash field name name is stored under for userID
+func (m *MetadataSealer) Field(userID uuid.UUID, name string) string {
+	if m == nil {
+		return name
+	}
+	mac := hmac.New(sha256.New, m.userKey(userID))
+	mac.Write([]byte(name))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (m *MetadataSealer) cipher(userID uuid.UUID) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(m.userKey(userID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// HGet reads a hash field stored with HSet, falling back to a field stored in the clear
+func (m *MetadataSealer) HGet(ctx context.Context, db database.Backend, key string, userID uuid.UUID, field string) (string, error) {
+	data, err := db.HGet(ctx, key, m.Field(userID, field))
+	if m != nil && database.IsNotFound(err) {
+		data, err = db.HGet(ctx, key, field)
+	}
+	if err != nil {
+		return "", err
+	}
+	return m.Open(userID, data)
+}
+
+// HSet seals value under the keyed field name, replacing a field stored in the clear
+func (m *MetadataSealer) HSet(ctx context.Context, db database.Backend, key string, userID uuid.UUID, field, value string) error {
+	sealed, err := m.Seal(userID, value)
+	if err != nil {
+		return err
+	}
+	if err := db.HSet(ctx, key, m.Field(userID, field), sealed); err != nil {
+		return err
+	}
+	if m != nil {
+		_, err = db.HDel(ctx, key, field)
+	}
+	return err
+}
+
+// HDel deletes a field stored with HSet, or in the clear
+func (m *MetadataSealer) HDel(ctx context.Context, db database.Backend, key string, userID uuid.UUID, field string) error {
+	fields := []string{field}
+	if m != nil {
+		fields = append(fields, m.Field(userID, field))
+	}
+	_, err := db.HDel(ctx, key, fields...)
+	return err
+}
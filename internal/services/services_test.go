@@ -0,0 +1,28 @@
+package services
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/helioschat/sync/internal/clock"
+	"github.com/helioschat/sync/internal/database"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// newBoltBackend returns an empty bolt database that is closed when the test ends
+func newBoltBackend(t *testing.T) *database.BoltStore {
+	t.Helper()
+	db, err := database.NewBoltStore(filepath.Join(t.TempDir(), "sync.db"), database.CompressionPolicy{})
+	if err != nil {
+		t.Fatalf("NewBoltStore: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// newTestSync returns a sync service with quotas on a fresh bolt database
+func newTestSync(t *testing.T, quotas types.Quotas) (*SyncService, *database.BoltStore) {
+	t.Helper()
+	db := newBoltBackend(t)
+	return NewSyncService(db, nil, quotas, nil, clock.System), db
+}
@@ -0,0 +1,142 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/database"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// ErrSessionEnded is returned when a refresh token belongs to a session that was logged out or has expired
+var ErrSessionEnded = errors.New("session has ended")
+
+// sessionsKey returns the hash of a wallet's login sessions, keyed by session ID
+func sessionsKey(userID uuid.UUID) string {
+	return fmt.Sprintf("sessions:%s", userID.String())
+}
+
+// startSession records a new login session. Every refresh token rotated from the login's refresh
+// token carries the same session ID.
+func (s *AuthService) startSession(ctx context.Context, userID uuid.UUID, machineID string) (*types.Session, error) {
+	now := s.clock.Now()
+	session := &types.Session{
+		ID:         uuid.New().String(),
+		MachineID:  machineID,
+		IssuedAt:   now,
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(refreshTokenTTL),
+	}
+
+	if err := s.saveSession(ctx, userID, session); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// touchSession records a refresh of the session and extends it to the lifetime of the new refresh token
+func (s *AuthService) touchSession(ctx context.Context, userID uuid.UUID, sessionID string) (*types.Session, error) {
+	data, err := s.sealer.HGet(ctx, s.db, sessionsKey(userID), userID, sessionID)
+	if err != nil {
+		if database.IsNotFound(err) {
+			return nil, ErrSessionEnded
+		}
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+
+	var session types.Session
+	if err := json.Unmarshal([]byte(data), &session); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal session: %w", err)
+	}
+
+	now := s.clock.Now()
+	if session.ExpiresAt.Before(now) {
+		return nil, ErrSessionEnded
+	}
+	session.LastUsedAt = now
+	session.ExpiresAt = now.Add(refreshTokenTTL)
+
+	if err := s.saveSession(ctx, userID, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// endSession forgets a session, so refresh tokens issued for it can no longer be exchanged
+func (s *AuthService) endSession(ctx context.Context, userID uuid.UUID, sessionID string) error {
+	if err := s.sealer.HDel(ctx, s.db, sessionsKey(userID), userID, sessionID); err != nil {
+		return fmt.Errorf("failed to end session: %w", err)
+	}
+	return nil
+}
+
+// ListSessions returns the wallet's sessions that can still be refreshed, most recently used first.
+// Sessions of signed-out machines are left out because their tokens are rejected.
+func (s *AuthService) ListSessions(ctx context.Context, userID uuid.UUID) ([]types.Session, error) {
+	key := sessionsKey(userID)
+	entries, err := s.db.HGetAll(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sessions: %w", err)
+	}
+
+	machines, err := s.GetMachines(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	deactivated := make(map[string]bool)
+	for _, machine := range machines {
+		if !machine.Active {
+			deactivated[machine.ID.String()] = true
+		}
+	}
+
+	now := s.clock.Now()
+	sessions := []types.Session{}
+	for field, data := range entries {
+		data, err := s.sealer.Open(userID, data)
+		if err != nil {
+			continue
+		}
+		var session types.Session
+		if err := json.Unmarshal([]byte(data), &session); err != nil {
+			continue
+		}
+
+		// Drop expired records as we go
+		if session.ExpiresAt.Before(now) {
+			if _, err := s.db.HDel(ctx, key, field); err != nil {
+				fmt.Printf("Warning: failed to prune expired session: %v\n", err)
+			}
+			continue
+		}
+
+		if session.MachineID != "" && deactivated[session.MachineID] {
+			continue
+		}
+
+		sessions = append(sessions, session)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt)
+	})
+
+	return sessions, nil
+}
+
+func (s *AuthService) saveSession(ctx context.Context, userID uuid.UUID, session *types.Session) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return fmt.Errorf("failed to marshal session: %w", err)
+	}
+
+	if err := s.sealer.HSet(ctx, s.db, sessionsKey(userID), userID, session.ID, string(data)); err != nil {
+		return fmt.Errorf("failed to save session: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,153 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// SettingsResource is a per-user settings document, stored under "<name>:<user ID>"
+type SettingsResource struct {
+	Name      string // resource name in change logs, revisions, imports and exports
+	Label     string // name of the document in messages
+	Deletable bool   // whether the document can be cleared, leaving a tombstone
+	New       func() types.SettingsDocument
+
+	set func(bundle *types.SettingsBundle, document types.SettingsDocument)
+}
+
+// SettingsResources are the per-user settings documents. The settings endpoints, imports, exports,
+// full syncs and conditional writes all go by this table.
+var SettingsResources = []SettingsResource{
+	settingsResource("provider_instances", "Provider instances", func(b *types.SettingsBundle) **types.ProviderInstances { return &b.ProviderInstances }),
+	settingsResource("disabled_models", "Disabled models", func(b *types.SettingsBundle) **types.DisabledModels { return &b.DisabledModels }),
+	settingsResource("advanced_settings", "Advanced settings", func(b *types.SettingsBundle) **types.AdvancedSettings { return &b.AdvancedSettings }),
+	settingsResource("tool_servers", "Tool servers", func(b *types.SettingsBundle) **types.ToolServers { return &b.ToolServers }),
+	settingsResource("prompt_library", "Prompt library", func(b *types.SettingsBundle) **types.PromptLibrary { return &b.PromptLibrary }),
+	settingsResource("tags", "Tags", func(b *types.SettingsBundle) **types.Tags { return &b.Tags }),
+	settingsResource("custom_instructions", "Custom instructions", func(b *types.SettingsBundle) **types.CustomInstructions { return &b.CustomInstructions }),
+	settingsResource("favorite_models", "Favorite models", func(b *types.SettingsBundle) **types.FavoriteModels { return &b.FavoriteModels }),
+	settingsResource("profile", "Profile", func(b *types.SettingsBundle) **types.Profile { return &b.Profile }),
+}
+
+// settingsResource describes the settings document T, kept in the field of settings bundles that
+// field returns. Documents with a deleted flag can be deleted.
+func settingsResource[T any, P interface {
+	*T
+	types.SettingsDocument
+}](name, label string, field func(*types.SettingsBundle) *P) SettingsResource {
+	return SettingsResource{
+		Name:      name,
+		Label:     label,
+		Deletable: P(new(T)).Fields().Deleted != nil,
+		New:       func() types.SettingsDocument { return P(new(T)) },
+		set: func(bundle *types.SettingsBundle, document types.SettingsDocument) {
+			*field(bundle) = document.(P)
+		},
+	}
+}
+
+// Path returns the route of the resource under /api/v1/sync
+func (r SettingsResource) Path() string {
+	return "/" + strings.ReplaceAll(r.Name, "_", "-")
+}
+
+// lookupSettings returns the settings resource called name
+func lookupSettings(name string) (SettingsResource, bool) {
+	for _, settings := range SettingsResources {
+		if settings.Name == name {
+			return settings, true
+		}
+	}
+	return SettingsResource{}, false
+}
+
+// settingsKey returns the key of a user's settings document
+func settingsKey(resource string, userID uuid.UUID) string {
+	return fmt.Sprintf("%s:%s", resource, userID.String())
+}
+
+// GetSettings returns the user's settings document of resource. Deleted documents are returned as
+// their tombstone.
+func (s *SyncService) GetSettings(ctx context.Context, resource string, userID uuid.UUID) (types.SettingsDocument, error) {
+	settings, ok := lookupSettings(resource)
+	if !ok {
+		return nil, fmt.Errorf("unknown resource %q", resource)
+	}
+
+	data, err := s.db.Get(ctx, settingsKey(resource, userID))
+	if err != nil {
+		return nil, err
+	}
+
+	document := settings.New()
+	if err := json.Unmarshal([]byte(data), document); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal %s: %w", resource, err)
+	}
+
+	return document, nil
+}
+
+// UpdateSettings replaces the user's settings document of resource with document
+func (s *SyncService) UpdateSettings(ctx context.Context, resource string, document types.SettingsDocument, machineID string) (*types.ConflictResolution, error) {
+	settings, ok := lookupSettings(resource)
+	if !ok {
+		return nil, fmt.Errorf("unknown resource %q", resource)
+	}
+	fields := document.Fields()
+
+	// A cleared document only comes back through writes made after it was cleared
+	if settings.Deletable {
+		if err := s.checkSettingsTombstone(ctx, resource, *fields.UserID, *fields.Version); err != nil {
+			return nil, err
+		}
+	}
+	resolution, err := s.checkSettingsConflict(ctx, resource, *fields.UserID, *fields.Version)
+	if err != nil {
+		return nil, err
+	}
+	if fields.Deleted != nil {
+		*fields.Deleted = false
+	}
+
+	now := s.clock.Now()
+	*fields.UpdatedAt = now
+
+	data, err := json.Marshal(document)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %s: %w", resource, err)
+	}
+
+	if err := s.db.Set(ctx, settingsKey(resource, *fields.UserID), string(data), 0); err != nil {
+		return nil, err
+	}
+
+	s.recordChange(ctx, changeRecord{
+		Resource:   resource,
+		Operation:  "update",
+		ResourceID: fields.UserID.String(),
+		UserID:     *fields.UserID,
+		MachineID:  machineID,
+		Timestamp:  now,
+	})
+
+	if err := s.markSettingsChanged(ctx, *fields.UserID, resource, now); err != nil {
+		fmt.Printf("Warning: failed to update settings revision: %v\n", err)
+	}
+
+	return resolution, nil
+}
+
+// loadSettings puts the user's settings documents, tombstones included, into bundle. Documents
+// that can't be read are left out.
+func (s *SyncService) loadSettings(ctx context.Context, userID uuid.UUID, bundle *types.SettingsBundle) {
+	for _, settings := range SettingsResources {
+		if document, err := s.GetSettings(ctx, settings.Name, userID); err == nil {
+			settings.set(bundle, document)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// importExport imports export into userID's data and returns the results by resource
+func importExport(t *testing.T, s *SyncService, userID uuid.UUID, export *types.AccountExport) map[string]types.ImportItemResult {
+	t.Helper()
+	data, err := json.Marshal(export)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	results := make(map[string]types.ImportItemResult)
+	opts := ImportOptions{Encryption: types.EncryptionPolicy{CurrentVersion: 1, SupportedVersions: []int{1}}}
+	_, err = s.ImportUserData(context.Background(), userID, bytes.NewReader(data), opts, func(result types.ImportItemResult) {
+		results[result.Resource] = result
+	})
+	if err != nil {
+		t.Fatalf("ImportUserData: %v", err)
+	}
+	return results
+}
+
+func TestSettingsExportAndImport(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestSync(t, types.Quotas{})
+	userID := uuid.New()
+
+	tags := &types.Tags{UserID: userID, Version: 1, Definitions: map[string]interface{}{"t1": "sealed"}}
+	if _, err := s.UpdateSettings(ctx, "tags", tags, ""); err != nil {
+		t.Fatalf("UpdateSettings tags: %v", err)
+	}
+	profile := &types.Profile{UserID: userID, Version: 3, DisplayName: "sealed"}
+	if _, err := s.UpdateSettings(ctx, "profile", profile, ""); err != nil {
+		t.Fatalf("UpdateSettings profile: %v", err)
+	}
+
+	export, err := s.ExportUserData(ctx, userID)
+	if err != nil {
+		t.Fatalf("ExportUserData: %v", err)
+	}
+	if export.Tags == nil || export.Tags.Definitions["t1"] != "sealed" {
+		t.Errorf("exported tags = %+v, want the stored tags", export.Tags)
+	}
+	if export.Profile == nil || export.Profile.DisplayName != "sealed" {
+		t.Errorf("exported profile = %+v, want the stored profile", export.Profile)
+	}
+
+	// Into another account, and again once they are there
+	otherID := uuid.New()
+	results := importExport(t, s, otherID, export)
+	if results["tags"].Status != types.ImportCreated || results["profile"].Status != types.ImportCreated {
+		t.Errorf("first import = %+v, want tags and profile created", results)
+	}
+	imported, err := s.GetSettings(ctx, "profile", otherID)
+	if err != nil {
+		t.Fatalf("GetSettings: %v", err)
+	}
+	if p := imported.(*types.Profile); p.UserID != otherID || p.DisplayName != "sealed" || p.Version != 3 {
+		t.Errorf("imported profile = %+v, want the exported one under the importing user", p)
+	}
+	results = importExport(t, s, otherID, export)
+	if results["tags"].Status != types.ImportUnchanged || results["profile"].Status != types.ImportUnchanged {
+		t.Errorf("repeated import = %+v, want tags and profile unchanged", results)
+	}
+}
+
+func TestSettingsTombstoneSurvivesExportAndImport(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestSync(t, types.Quotas{})
+	userID := uuid.New()
+
+	tags := &types.Tags{UserID: userID, Version: 1, Definitions: map[string]interface{}{"t1": "sealed"}}
+	if _, err := s.UpdateSettings(ctx, "tags", tags, ""); err != nil {
+		t.Fatalf("UpdateSettings: %v", err)
+	}
+	old, err := s.ExportUserData(ctx, userID)
+	if err != nil {
+		t.Fatalf("ExportUserData: %v", err)
+	}
+
+	if err := s.DeleteSettings(ctx, "tags", userID, ""); err != nil {
+		t.Fatalf("DeleteSettings: %v", err)
+	}
+	if err := s.DeleteSettings(ctx, "profile", userID, ""); err == nil {
+		t.Errorf("DeleteSettings of the profile succeeded, want it refused")
+	}
+
+	export, err := s.ExportUserData(ctx, userID)
+	if err != nil {
+		t.Fatalf("ExportUserData: %v", err)
+	}
+	if export.Tags != nil {
+		t.Errorf("exported tags = %+v, want cleared tags left out", export.Tags)
+	}
+
+	// An export taken before the deletion doesn't bring the tags back
+	if results := importExport(t, s, userID, old); results["tags"].Status != types.ImportConflict {
+		t.Errorf("import of older tags = %+v, want a conflict", results["tags"])
+	}
+	document, err := s.GetSettings(ctx, "tags", userID)
+	if err != nil {
+		t.Fatalf("GetSettings: %v", err)
+	}
+	if stored := document.(*types.Tags); !stored.Deleted || stored.Definitions != nil {
+		t.Errorf("tags after import = %+v, want the tombstone kept", stored)
+	}
+}
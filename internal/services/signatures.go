@@ -0,0 +1,116 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/database"
+)
+
+// Requests are signed with a timestamp and refused outside this window around the server time;
+// signatures seen within the window are remembered, so a captured request can't be replayed
+const signatureMaxSkew = 5 * time.Minute
+
+const signingSecretBytes = 32
+
+var (
+	// ErrSignatureRequired is returned for unsigned writes when the instance requires request signatures
+	ErrSignatureRequired = errors.New("request signature required")
+	// ErrInvalidSignature is returned for signatures that don't verify, are stale or were already used
+	ErrInvalidSignature = errors.New("invalid request signature")
+)
+
+// machineSecretsKey returns the hash of the signing secrets of a wallet's machines, keyed by machine ID.
+// Secrets are kept apart from the machine records so they never end up in machine listings.
+func machineSecretsKey(userID uuid.UUID) string {
+	return fmt.Sprintf("machine_secrets:%s", userID.String())
+}
+
+func usedSignatureKey(signature string) string {
+	return "used_signatures:" + signature
+}
+
+// SignRequest computes the X-Signature of a request: the hex HMAC-SHA256, keyed with the machine's
+// signing secret, of "<METHOD>\n<request URI>\n<X-Signature-Timestamp>\n<hex SHA-256 of the body>"
+func SignRequest(secret, method, requestURI, timestamp string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s", method, requestURI, timestamp, hex.EncodeToString(bodyHash[:]))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// RequiresSignatures reports whether every write must be signed
+func (s *AuthService) RequiresSignatures() bool {
+	return s.machinePolicy.RequireSignatures
+}
+
+// VerifyRequestSignature checks a request signed by machineID with SignRequest. timestamp is in unix seconds.
+func (s *AuthService) VerifyRequestSignature(ctx context.Context, userID uuid.UUID, machineID, timestamp, signature, method, requestURI string, body []byte) error {
+	if signature == "" || timestamp == "" || machineID == "" {
+		return ErrSignatureRequired
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: invalid timestamp", ErrInvalidSignature)
+	}
+	if skew := s.clock.Now().Sub(time.Unix(unix, 0)); skew > signatureMaxSkew || skew < -signatureMaxSkew {
+		return fmt.Errorf("%w: timestamp outside the accepted window", ErrInvalidSignature)
+	}
+
+	secret, err := s.sealer.HGet(ctx, s.db, machineSecretsKey(userID), userID, machineID)
+	if err != nil {
+		if database.IsNotFound(err) {
+			return fmt.Errorf("%w: machine has no signing secret", ErrInvalidSignature)
+		}
+		return fmt.Errorf("failed to get signing secret: %w", err)
+	}
+
+	expected := SignRequest(secret, method, requestURI, timestamp, body)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return ErrInvalidSignature
+	}
+
+	fresh, err := s.db.SetNX(ctx, usedSignatureKey(signature), machineID, int64((2 * signatureMaxSkew).Seconds()))
+	if err != nil {
+		return fmt.Errorf("failed to record signature: %w", err)
+	}
+	if !fresh {
+		return fmt.Errorf("%w: signature was already used", ErrInvalidSignature)
+	}
+	return nil
+}
+
+// issueSigningSecret generates and stores a machine's signing secret. It is only ever returned here.
+func (s *AuthService) issueSigningSecret(ctx context.Context, userID, machineID uuid.UUID) (string, error) {
+	raw := make([]byte, signingSecretBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("failed to generate signing secret: %w", err)
+	}
+	secret := base64.RawURLEncoding.EncodeToString(raw)
+
+	if err := s.sealer.HSet(ctx, s.db, machineSecretsKey(userID), userID, machineID.String(), secret); err != nil {
+		return "", fmt.Errorf("failed to save signing secret: %w", err)
+	}
+	return secret, nil
+}
+
+// hasSigningSecret reports whether a machine was issued a signing secret
+func (s *AuthService) hasSigningSecret(ctx context.Context, userID, machineID uuid.UUID) (bool, error) {
+	if _, err := s.sealer.HGet(ctx, s.db, machineSecretsKey(userID), userID, machineID.String()); err != nil {
+		if database.IsNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to get signing secret: %w", err)
+	}
+	return true, nil
+}
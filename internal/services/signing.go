@@ -0,0 +1,125 @@
+package services
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// TokenSigner signs and verifies tokens with either the shared HMAC secret or an asymmetric key pair.
+// With an asymmetric key, other services can verify tokens using the public key from the JWKS endpoint.
+type TokenSigner struct {
+	method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+	keyID     string // empty for HS256, whose key must never be published
+}
+
+// NewTokenSigner creates a signer for method "HS256", "RS256" or "EdDSA". HS256 uses secret;
+// the asymmetric methods use privateKeyPEM, a PKCS#8 (or, for RSA, PKCS#1) private key.
+func NewTokenSigner(method, secret string, privateKeyPEM []byte) (*TokenSigner, error) {
+	if method == jwt.SigningMethodHS256.Alg() {
+		return &TokenSigner{
+			method:    jwt.SigningMethodHS256,
+			signKey:   []byte(secret),
+			verifyKey: []byte(secret),
+		}, nil
+	}
+
+	block, _ := pem.Decode(privateKeyPEM)
+	if block == nil {
+		return nil, errors.New("private key is not PEM encoded")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		rsaKey, rsaErr := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if rsaErr != nil {
+			return nil, fmt.Errorf("failed to parse private key: %w", err)
+		}
+		key = rsaKey
+	}
+
+	signer := &TokenSigner{signKey: key}
+	switch method {
+	case jwt.SigningMethodRS256.Alg():
+		rsaKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("RS256 requires an RSA private key")
+		}
+		signer.method = jwt.SigningMethodRS256
+		signer.verifyKey = &rsaKey.PublicKey
+	case jwt.SigningMethodEdDSA.Alg():
+		edKey, ok := key.(ed25519.PrivateKey)
+		if !ok {
+			return nil, errors.New("EdDSA requires an Ed25519 private key")
+		}
+		signer.method = jwt.SigningMethodEdDSA
+		signer.verifyKey = edKey.Public()
+	default:
+		return nil, fmt.Errorf("unsupported signing method %q (available: HS256, RS256, EdDSA)", method)
+	}
+
+	// Derive the key ID from the public key so it changes whenever the key is rotated
+	der, err := x509.MarshalPKIXPublicKey(signer.verifyKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode public key: %w", err)
+	}
+	sum := sha256.Sum256(der)
+	signer.keyID = base64.RawURLEncoding.EncodeToString(sum[:12])
+
+	return signer, nil
+}
+
+// Sign signs claims, naming the key in the header so verifiers can pick it from the JWKS
+func (s *TokenSigner) Sign(claims jwt.MapClaims) (string, error) {
+	token := jwt.NewWithClaims(s.method, claims)
+	if s.keyID != "" {
+		token.Header["kid"] = s.keyID
+	}
+	return token.SignedString(s.signKey)
+}
+
+// Parse verifies a token's signature, refusing every algorithm but the configured one
+func (s *TokenSigner) Parse(tokenString string, options ...jwt.ParserOption) (*jwt.Token, error) {
+	options = append(options, jwt.WithValidMethods([]string{s.method.Alg()}))
+	return jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		return s.verifyKey, nil
+	}, options...)
+}
+
+// JWKS returns the public verification keys. It is empty for HS256.
+func (s *TokenSigner) JWKS() types.JWKS {
+	jwks := types.JWKS{Keys: []types.JWK{}}
+
+	switch key := s.verifyKey.(type) {
+	case *rsa.PublicKey:
+		jwks.Keys = append(jwks.Keys, types.JWK{
+			KeyType:   "RSA",
+			KeyID:     s.keyID,
+			Use:       "sig",
+			Algorithm: s.method.Alg(),
+			N:         base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		})
+	case ed25519.PublicKey:
+		jwks.Keys = append(jwks.Keys, types.JWK{
+			KeyType:   "OKP",
+			KeyID:     s.keyID,
+			Use:       "sig",
+			Algorithm: s.method.Alg(),
+			Curve:     "Ed25519",
+			X:         base64.RawURLEncoding.EncodeToString(key),
+		})
+	}
+
+	return jwks
+}
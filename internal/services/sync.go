@@ -1,42 +1,117 @@
 package services
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/blobstore"
+	"github.com/helioschat/sync/internal/clock"
 	"github.com/helioschat/sync/internal/database"
 	"github.com/helioschat/sync/internal/types"
 )
 
 type SyncService struct {
-	db *database.RedisClient
+	db      database.Backend
+	archive *ArchiveService // nil when archival is disabled
+	quotas  types.Quotas
+	sealer  *MetadataSealer // nil stores change-log linkage in the clear
+	clock   clock.Clock
+
+	migration *types.MigrationNotice // announced to every client syncing; nil while the instance stays
+	feed      *ChangeFeed            // nil when realtime sync is off
+	conflicts types.ConflictStrategies
+
+	attachments        blobstore.Store // nil when attachment storage is disabled
+	attachmentMaxBytes int64
+
+	draftTTL time.Duration // how long drafts are kept after their last write
 }
 
-func NewSyncService(db *database.RedisClient) *SyncService {
+func NewSyncService(db database.Backend, archive *ArchiveService, quotas types.Quotas, sealer *MetadataSealer, clock clock.Clock) *SyncService {
 	return &SyncService{
-		db: db,
+		db:      db,
+		archive: archive,
+		quotas:  quotas,
+		sealer:  sealer,
+		clock:   clock,
+		conflicts: types.ConflictStrategies{
+			Threads:  types.ConflictReject,
+			Settings: types.ConflictLastWriterWins,
+		},
+		draftTTL: defaultDraftTTL,
+	}
+}
+
+// AnnounceMigration makes every changes-since response carry a "migrate" operation with notice
+func (s *SyncService) AnnounceMigration(notice *types.MigrationNotice) {
+	s.migration = notice
+}
+
+// rehydrate restores a thread from the archival store before it is accessed
+func (s *SyncService) rehydrate(ctx context.Context, threadID string) error {
+	if s.archive == nil {
+		return nil
+	}
+	if err := s.archive.Rehydrate(ctx, threadID); err != nil {
+		return fmt.Errorf("failed to restore archived thread: %w", err)
 	}
+	return nil
+}
+
+// atomically runs fn against a copy of the service whose writes take effect together, or not at
+// all if fn fails. The copy doesn't restore archived threads, since the archival store can't take
+// part in the transaction.
+func (s *SyncService) atomically(ctx context.Context, fn func(tx *SyncService) error) error {
+	return s.db.Atomic(ctx, func(db database.Backend) error {
+		tx := *s
+		tx.db, tx.archive = db, nil
+		return fn(&tx)
+	})
 }
 
 // Thread operations
-func (s *SyncService) GetThreads(userID uuid.UUID, since *time.Time) ([]types.Thread, error) {
-	pattern := fmt.Sprintf("threads:%s:*", userID.String())
-	keys, err := s.db.Keys(pattern)
+// threadIndexKey returns the set holding the IDs of all of a user's threads
+func threadIndexKey(userID uuid.UUID) string {
+	return fmt.Sprintf("threads_index:%s", userID.String())
+}
+
+func (s *SyncService) GetThreads(ctx context.Context, userID uuid.UUID, since *time.Time) ([]types.Thread, error) {
+	threadIDs, err := s.db.SMembers(ctx, threadIndexKey(userID))
 	if err != nil {
-		return nil, fmt.Errorf("failed to get thread keys: %w", err)
+		return nil, fmt.Errorf("failed to get thread index: %w", err)
+	}
+
+	if len(threadIDs) == 0 {
+		return nil, nil
+	}
+
+	keys := make([]string, len(threadIDs))
+	for i, threadID := range threadIDs {
+		keys[i] = fmt.Sprintf("threads:%s:%s", userID.String(), threadID)
+	}
+
+	values, err := s.db.MGet(ctx, keys...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get threads: %w", err)
 	}
 
 	var threads []types.Thread
-	for _, key := range keys {
-		data, err := s.db.Get(key)
-		if err != nil {
+	for i, value := range values {
+		data, ok := value.(string)
+		if !ok {
 			continue
 		}
 
 		var thread types.Thread
 		if err := json.Unmarshal([]byte(data), &thread); err != nil {
+			s.quarantine(ctx, QuarantinedRecord{Resource: "thread", UserID: userID.String(), Key: keys[i]}, err)
 			continue
 		}
 
@@ -55,108 +130,385 @@ func (s *SyncService) GetThreads(userID uuid.UUID, since *time.Time) ([]types.Th
 	return threads, nil
 }
 
-// GetThreadsPaginated returns threads with pagination support
-func (s *SyncService) GetThreadsPaginated(userID uuid.UUID, offset, limit int, since *time.Time) (*types.PaginatedThreadsResponse, error) {
-	pattern := fmt.Sprintf("threads:%s:*", userID.String())
-	keys, err := s.db.Keys(pattern)
+// BuildThreadIndexes populates threads_index:{user} from existing thread keys.
+// It only scans the keyspace once; later runs are skipped via a marker key.
+func (s *SyncService) BuildThreadIndexes(ctx context.Context) (int, error) {
+	const markerKey = "migrations:threads_index"
+	if _, err := s.db.Get(ctx, markerKey); err == nil {
+		return 0, nil
+	}
+
+	keys, err := s.db.Keys(ctx, "threads:*:*")
 	if err != nil {
-		return nil, fmt.Errorf("failed to get thread keys: %w", err)
+		return 0, fmt.Errorf("failed to get thread keys: %w", err)
 	}
 
-	var allThreads []types.Thread
+	indexed := 0
 	for _, key := range keys {
-		data, err := s.db.Get(key)
-		if err != nil {
+		parts := strings.SplitN(key, ":", 3)
+		if len(parts) != 3 {
 			continue
 		}
 
-		var thread types.Thread
-		if err := json.Unmarshal([]byte(data), &thread); err != nil {
+		userID, err := uuid.Parse(parts[1])
+		if err != nil {
 			continue
 		}
 
-		// Filter by timestamp if provided
-		// Since UpdatedAt is encrypted, use Version (milliseconds timestamp) for filtering
-		if since != nil {
-			threadTimestamp := time.UnixMilli(thread.Version)
-			if !threadTimestamp.After(*since) {
-				continue
-			}
+		if err := s.db.SAdd(ctx, threadIndexKey(userID), parts[2]); err != nil {
+			return indexed, fmt.Errorf("failed to index thread %s: %w", key, err)
 		}
+		indexed++
+	}
 
-		allThreads = append(allThreads, thread)
+	if err := s.db.Set(ctx, markerKey, s.clock.Now().Format(time.RFC3339), 0); err != nil {
+		return indexed, fmt.Errorf("failed to store migration marker: %w", err)
 	}
 
-	total := len(allThreads)
+	return indexed, nil
+}
 
-	// Apply pagination
-	var paginatedThreads []types.Thread
-	if offset < total {
-		end := offset + limit
-		if end > total {
-			end = total
-		}
-		paginatedThreads = allThreads[offset:end]
+// GetThreadsPaginated returns threads with pagination support, most recently updated first.
+// Pages are read directly from the timestamps:threads:{user} sorted set so only the page's
+// thread bodies are loaded. Archived threads are left out unless includeArchived.
+func (s *SyncService) GetThreadsPaginated(ctx context.Context, userID uuid.UUID, offset, limit int, since *time.Time, includeArchived bool) (*types.PaginatedThreadsResponse, error) {
+	hidden, err := s.hiddenThreads(ctx, userID, includeArchived)
+	if err != nil {
+		return nil, err
+	}
+
+	// Since UpdatedAt is encrypted, the index is scored by Version (milliseconds timestamp)
+	min := "-inf"
+	if since != nil {
+		min = fmt.Sprintf("(%d", since.UnixMilli())
+	}
+
+	count, err := s.countThreadIndex(ctx, userID, min, "+inf", hidden)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count threads: %w", err)
+	}
+	total := int(count)
+
+	threadIDs, err := s.threadIndexRange(ctx, userID, min, "+inf", int64(offset), int64(limit), hidden)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get thread page: %w", err)
+	}
+
+	paginatedThreads, corrupted, err := s.loadThreadPage(ctx, userID, threadIDs)
+	if err != nil {
+		return nil, err
+	}
+	counts, err := s.GetMessageCounts(ctx, threadIDs)
+	if err != nil {
+		return nil, err
 	}
 
 	hasMore := offset+limit < total
 
 	return &types.PaginatedThreadsResponse{
-		Threads: paginatedThreads,
-		Total:   total,
-		Offset:  offset,
-		Limit:   limit,
-		HasMore: hasMore,
+		Threads:        paginatedThreads,
+		Total:          total,
+		Offset:         offset,
+		Limit:          limit,
+		HasMore:        hasMore,
+		NextCursor:     nextThreadCursor(paginatedThreads, hasMore),
+		CorruptedCount: corrupted,
+		MessageCounts:  counts,
 	}, nil
 }
 
-func (s *SyncService) UpsertThread(thread *types.Thread, machineID string) (bool, error) {
+// GetThreadsAfter returns the page of threads following cursor, most recently updated first; an
+// empty cursor starts with the most recent thread. Unlike offsets, cursors neither skip nor repeat
+// threads written between requests, except for threads moved to the front by an update. Archived
+// threads are left out unless includeArchived.
+func (s *SyncService) GetThreadsAfter(ctx context.Context, userID uuid.UUID, cursor string, limit int, since *time.Time, includeArchived bool) (*types.PaginatedThreadsResponse, error) {
+	timestampKey := fmt.Sprintf("timestamps:threads:%s", userID.String())
+	hidden, err := s.hiddenThreads(ctx, userID, includeArchived)
+	if err != nil {
+		return nil, err
+	}
+
+	min := "-inf"
+	if since != nil {
+		min = fmt.Sprintf("(%d", since.UnixMilli())
+	}
+
+	count, err := s.countThreadIndex(ctx, userID, min, "+inf", hidden)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count threads: %w", err)
+	}
+
+	max := "+inf"
+	var offset int64
+	if cursor != "" {
+		after, err := decodePageCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+		max = strconv.FormatInt(after.Score, 10)
+
+		// Threads of the same version are listed in reverse ID order; skip those up to the cursor's
+		tied, err := s.db.ZRangeByScore(ctx, timestampKey, max, max)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get thread page: %w", err)
+		}
+		for _, threadID := range tied {
+			if threadID >= after.ID && !hidden[threadID] {
+				offset++
+			}
+		}
+	}
+
+	// One more than the page tells whether there are more
+	threadIDs, err := s.threadIndexRange(ctx, userID, min, max, offset, int64(limit)+1, hidden)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get thread page: %w", err)
+	}
+	hasMore := len(threadIDs) > limit
+	if hasMore {
+		threadIDs = threadIDs[:limit]
+	}
+
+	threads, corrupted, err := s.loadThreadPage(ctx, userID, threadIDs)
+	if err != nil {
+		return nil, err
+	}
+	counts, err := s.GetMessageCounts(ctx, threadIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.PaginatedThreadsResponse{
+		Threads:        threads,
+		Total:          int(count),
+		Limit:          limit,
+		HasMore:        hasMore,
+		NextCursor:     nextThreadCursor(threads, hasMore),
+		CorruptedCount: corrupted,
+		MessageCounts:  counts,
+	}, nil
+}
+
+// loadThreadPage loads the threads of a page in order, quarantining unreadable ones
+func (s *SyncService) loadThreadPage(ctx context.Context, userID uuid.UUID, threadIDs []string) ([]types.Thread, int, error) {
+	if len(threadIDs) == 0 {
+		return nil, 0, nil
+	}
+
+	keys := make([]string, len(threadIDs))
+	for i, threadID := range threadIDs {
+		keys[i] = fmt.Sprintf("threads:%s:%s", userID.String(), threadID)
+	}
+
+	values, err := s.db.MGet(ctx, keys...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get threads: %w", err)
+	}
+
+	var threads []types.Thread
+	corrupted := 0
+	for i, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			continue
+		}
+
+		var thread types.Thread
+		if err := json.Unmarshal([]byte(data), &thread); err != nil {
+			s.quarantine(ctx, QuarantinedRecord{Resource: "thread", UserID: userID.String(), Key: keys[i]}, err)
+			corrupted++
+			continue
+		}
+
+		threads = append(threads, thread)
+	}
+
+	return threads, corrupted, nil
+}
+
+// ThreadConflictError reports a thread write whose version isn't newer than the stored thread, and
+// carries the stored thread so the client can merge without fetching it
+type ThreadConflictError struct {
+	Current       *types.Thread
+	ClientVersion int64
+}
+
+func (e *ThreadConflictError) Error() string {
+	return fmt.Sprintf("%s: server version %d, client version %d", ErrVersionConflict, e.Current.Version, e.ClientVersion)
+}
+
+func (e *ThreadConflictError) Unwrap() error {
+	return ErrVersionConflict
+}
+
+// UpsertThread creates or updates a thread. It reports whether the thread was created and, for
+// writes overwriting a newer thread under last writer wins, the conflict that was resolved.
+func (s *SyncService) UpsertThread(ctx context.Context, thread *types.Thread, machineID string) (bool, *types.ConflictResolution, error) {
+	return s.upsertThread(ctx, thread, machineID, s.conflicts.Threads)
+}
+
+func (s *SyncService) upsertThread(ctx context.Context, thread *types.Thread, machineID, strategy string) (bool, *types.ConflictResolution, error) {
+	// Archival state is server-managed; bring the thread back before it is overwritten
+	thread.ArchivedRemote = false
+	if err := s.rehydrate(ctx, thread.ID.String()); err != nil {
+		return false, nil, err
+	}
+
+	// A deleted thread only comes back through writes made after its deletion
+	if err := s.checkThreadTombstone(ctx, thread); err != nil {
+		return false, nil, err
+	}
+
 	// Check if thread already exists
-	existing, err := s.getThread(thread.UserID, thread.ID)
+	existing, err := s.getThread(ctx, thread.UserID, thread.ID)
 	isCreating := err != nil // If we can't get the thread, we're creating a new one
 
-	now := time.Now()
+	now := s.clock.Now()
 
+	var resolution *types.ConflictResolution
+	thread.Archived = false
 	if !isCreating {
 		// Updating existing thread - check for version conflicts
-		if thread.Version <= existing.Version {
-			return false, fmt.Errorf("version conflict: server version %d, client version %d", existing.Version, thread.Version)
+		var reject bool
+		if resolution, reject = resolveConflict(strategy, existing.Version, thread.Version); reject {
+			return false, nil, &ThreadConflictError{Current: existing, ClientVersion: thread.Version}
 		}
+		// Archiving has an endpoint of its own; writes keep the stored state
+		thread.Archived = existing.Archived
 	}
 
-	if err := s.saveThread(thread); err != nil {
-		return false, err
+	if err := s.claimThread(ctx, thread.UserID, thread.ID.String()); err != nil {
+		return false, nil, err
+	}
+	if err := s.saveThread(ctx, thread); err != nil {
+		return false, nil, err
+	}
+	if err := s.clearThreadTombstone(ctx, thread.UserID, thread.ID); err != nil {
+		return false, nil, err
 	}
 
-	// Store the machine ID for this change
-	if err := s.storeMachineIDForChange("thread", thread.ID, machineID, now); err != nil {
-		// Log error but don't fail the operation
-		fmt.Printf("Warning: failed to store machine ID for thread change: %v\n", err)
+	if err := s.recordThreadMeta(ctx, thread, machineID, now); err != nil {
+		fmt.Printf("Warning: failed to record meta-history for thread %s: %v\n", thread.ID, err)
 	}
 
-	return isCreating, nil
+	s.recordChange(ctx, changeRecord{
+		Resource:   "thread",
+		Operation:  "update",
+		ResourceID: thread.ID.String(),
+		UserID:     thread.UserID,
+		MachineID:  machineID,
+		Timestamp:  now,
+	})
+
+	return isCreating, resolution, nil
 }
 
-func (s *SyncService) DeleteThread(userID, threadID uuid.UUID) error {
+// DeleteThread deletes a thread and its messages, leaving a tombstone so other devices learn of
+// the deletion and can't upload their stale copies again. Each message gets a delete operation of
+// its own in the change log.
+func (s *SyncService) DeleteThread(ctx context.Context, userID, threadID uuid.UUID, machineID string) error {
 	key := fmt.Sprintf("threads:%s:%s", userID.String(), threadID.String())
+	now := s.clock.Now()
+
+	var version int64
+	existing, err := s.getThread(ctx, userID, threadID)
+	if err == nil {
+		version = existing.Version
+	} else if !database.IsNotFound(err) {
+		return err
+	}
+
+	// Messages, branches and archives are stored by thread ID alone; only its owner's delete touches them
+	owns, err := s.ownsThread(ctx, userID, threadID.String())
+	if err != nil {
+		return err
+	}
+
+	if err := s.writeThreadTombstone(ctx, userID, threadID, version, machineID, now); err != nil {
+		return err
+	}
+
+	var messageIDs []string
+	if owns {
+		// Archived messages are restored first, so their deletes are reported too
+		if err := s.rehydrate(ctx, threadID.String()); err != nil {
+			return err
+		}
+		if messageIDs, err = s.threadMessageIDs(ctx, threadID.String()); err != nil {
+			return err
+		}
+
+		// Branches keep the messages they share with the thread
+		if err := s.detachBranches(ctx, userID, threadID.String()); err != nil {
+			return err
+		}
+		if err := s.releaseReferences(ctx, threadID.String()); err != nil {
+			return err
+		}
+		if err := s.deleteThreadMessages(ctx, userID, threadID.String(), messageIDs); err != nil {
+			return err
+		}
+
+		if s.archive != nil {
+			if err := s.archive.Discard(ctx, threadID.String()); err != nil {
+				return fmt.Errorf("failed to delete archived thread: %w", err)
+			}
+		}
+	}
 
 	// Simply delete the key from Redis
-	if err := s.db.Del(key); err != nil {
+	if err := s.db.Del(ctx, key); err != nil {
 		return fmt.Errorf("failed to delete thread: %w", err)
 	}
 
 	// Remove from timestamp index
 	timestampKey := fmt.Sprintf("timestamps:threads:%s", userID.String())
-	if err := s.db.ZRem(timestampKey, threadID.String()); err != nil {
+	if err := s.db.ZRem(ctx, timestampKey, threadID.String()); err != nil {
 		return fmt.Errorf("failed to remove from timestamp index: %w", err)
 	}
 
+	// Remove from thread index
+	if err := s.db.SRem(ctx, threadIndexKey(userID), threadID.String()); err != nil {
+		return fmt.Errorf("failed to remove from thread index: %w", err)
+	}
+	if err := s.db.SRem(ctx, archivedThreadsKey(userID), threadID.String()); err != nil {
+		return fmt.Errorf("failed to remove from archived threads: %w", err)
+	}
+	if _, err := s.db.HDel(ctx, readStatesKey(userID), threadID.String()); err != nil {
+		return fmt.Errorf("failed to delete read state: %w", err)
+	}
+
+	if err := s.db.Del(ctx, threadMetaKey(threadID)); err != nil {
+		return fmt.Errorf("failed to delete thread meta-history: %w", err)
+	}
+
+	// The messages' deletes come first, so devices replaying the log in order never hold messages of a deleted thread
+	for _, messageID := range messageIDs {
+		s.recordChange(ctx, changeRecord{
+			Resource:   "message",
+			Operation:  "delete",
+			ResourceID: messageID,
+			ThreadID:   threadID.String(),
+			UserID:     userID,
+			MachineID:  machineID,
+			Timestamp:  now,
+		})
+	}
+	s.recordChange(ctx, changeRecord{
+		Resource:   "thread",
+		Operation:  "delete",
+		ResourceID: threadID.String(),
+		UserID:     userID,
+		MachineID:  machineID,
+		Timestamp:  now,
+	})
+
 	return nil
 }
 
-func (s *SyncService) getThread(userID, threadID uuid.UUID) (*types.Thread, error) {
+func (s *SyncService) getThread(ctx context.Context, userID, threadID uuid.UUID) (*types.Thread, error) {
 	key := fmt.Sprintf("threads:%s:%s", userID.String(), threadID.String())
-	data, err := s.db.Get(key)
+	data, err := s.db.Get(ctx, key)
 	if err != nil {
 		return nil, err
 	}
@@ -169,7 +521,7 @@ func (s *SyncService) getThread(userID, threadID uuid.UUID) (*types.Thread, erro
 	return &thread, nil
 }
 
-func (s *SyncService) saveThread(thread *types.Thread) error {
+func (s *SyncService) saveThread(ctx context.Context, thread *types.Thread) error {
 	key := fmt.Sprintf("threads:%s:%s", thread.UserID.String(), thread.ID.String())
 
 	data, err := json.Marshal(thread)
@@ -177,7 +529,7 @@ func (s *SyncService) saveThread(thread *types.Thread) error {
 		return fmt.Errorf("failed to marshal thread: %w", err)
 	}
 
-	if err := s.db.Set(key, string(data), 0); err != nil {
+	if err := s.db.Set(ctx, key, string(data), 0); err != nil {
 		return fmt.Errorf("failed to save thread: %w", err)
 	}
 
@@ -185,64 +537,217 @@ func (s *SyncService) saveThread(thread *types.Thread) error {
 	// Since UpdatedAt is now encrypted, we'll use Version (which is a timestamp in milliseconds)
 	timestampKey := fmt.Sprintf("timestamps:threads:%s", thread.UserID.String())
 	score := float64(thread.Version)
-	if err := s.db.ZAdd(timestampKey, score, thread.ID.String()); err != nil {
+	if err := s.db.ZAdd(ctx, timestampKey, score, thread.ID.String()); err != nil {
 		return fmt.Errorf("failed to update timestamp index: %w", err)
 	}
 
+	if err := s.db.SAdd(ctx, threadIndexKey(thread.UserID), thread.ID.String()); err != nil {
+		return fmt.Errorf("failed to update thread index: %w", err)
+	}
+
+	if thread.Archived {
+		err = s.db.SAdd(ctx, archivedThreadsKey(thread.UserID), thread.ID.String())
+	} else {
+		err = s.db.SRem(ctx, archivedThreadsKey(thread.UserID), thread.ID.String())
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update archived threads: %w", err)
+	}
+
 	return nil
 }
 
 // Message operations
-func (s *SyncService) GetMessages(threadID string, since *time.Time) ([]types.Message, error) {
-	pattern := fmt.Sprintf("messages:%s:*", threadID)
-	keys, err := s.db.Keys(pattern)
+
+// ErrMessageIDTaken is returned when creating a message whose ID another message of the thread has
+var ErrMessageIDTaken = errors.New("message ID already taken")
+
+// messagesKey returns the hash holding all messages of a thread, keyed by message ID
+func messagesKey(threadID string) string {
+	return fmt.Sprintf("messages:%s", threadID)
+}
+
+// loadThreadMessages returns all readable messages of a thread ordered by message ID, including
+// those a branch inherited, along with the number of unreadable ones that were quarantined
+func (s *SyncService) loadThreadMessages(ctx context.Context, threadID string) ([]types.Message, int, error) {
+	entries, err := s.db.HGetAll(ctx, messagesKey(threadID))
 	if err != nil {
-		return nil, fmt.Errorf("failed to get message keys: %w", err)
+		return nil, 0, fmt.Errorf("failed to get messages: %w", err)
+	}
+	inherited, err := s.loadInheritedMessages(ctx, threadID)
+	if err != nil {
+		return nil, 0, err
+	}
+	for messageID, data := range inherited {
+		if _, own := entries[messageID]; !own {
+			entries[messageID] = data
+		} else {
+			delete(inherited, messageID)
+		}
 	}
 
+	// Hash iteration order is random; sort for stable pagination
+	messageIDs := make([]string, 0, len(entries))
+	for messageID := range entries {
+		messageIDs = append(messageIDs, messageID)
+	}
+	sort.Strings(messageIDs)
+
 	var messages []types.Message
-	for _, key := range keys {
-		data, err := s.db.Get(key)
-		if err != nil {
+	corrupted := 0
+	for _, messageID := range messageIDs {
+		var message types.Message
+		if err := json.Unmarshal([]byte(entries[messageID]), &message); err != nil {
+			// Inherited records are quarantined when the thread holding them reads them
+			if _, ok := inherited[messageID]; !ok {
+				s.quarantine(ctx, QuarantinedRecord{Resource: "message", Key: messagesKey(threadID), Field: messageID}, err)
+			}
+			corrupted++
 			continue
 		}
 
-		var message types.Message
-		if err := json.Unmarshal([]byte(data), &message); err != nil {
+		messages = append(messages, message)
+	}
+
+	return messages, corrupted, nil
+}
+
+// messageIndexKey returns the sorted set of a user's messages scored by server receive time
+func messageIndexKey(userID uuid.UUID) string {
+	return fmt.Sprintf("messages_index:%s", userID.String())
+}
+
+// messageIndexMember identifies a message within the per-user message index
+func messageIndexMember(threadID, messageID string) string {
+	return threadID + ":" + messageID
+}
+
+// GetUserMessages returns all of a user's messages across threads, in server receive order
+func (s *SyncService) GetUserMessages(ctx context.Context, userID uuid.UUID) ([]types.Message, error) {
+	members, err := s.db.ZRangeByScore(ctx, messageIndexKey(userID), "-inf", "+inf")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get message index: %w", err)
+	}
+
+	// Group message IDs per thread hash, preserving receive order
+	var threadOrder []string
+	byThread := make(map[string][]string)
+	for _, member := range members {
+		threadID, messageID, found := strings.Cut(member, ":")
+		if !found {
 			continue
 		}
+		if _, seen := byThread[threadID]; !seen {
+			threadOrder = append(threadOrder, threadID)
+		}
+		byThread[threadID] = append(byThread[threadID], messageID)
+	}
 
-		// Since timestamps are now encrypted, we can't filter by time
-		// Client will need to handle filtering if needed
-		messages = append(messages, message)
+	var messages []types.Message
+	for _, threadID := range threadOrder {
+		// The index may still list messages of thread IDs that another user owns
+		owns, err := s.ownsThread(ctx, userID, threadID)
+		if err != nil {
+			return nil, err
+		}
+		if !owns {
+			continue
+		}
+
+		values, err := s.db.HMGet(ctx, messagesKey(threadID), byThread[threadID]...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get messages: %w", err)
+		}
+
+		for i, value := range values {
+			data, ok := value.(string)
+			if !ok {
+				continue // archived or removed
+			}
+
+			var message types.Message
+			if err := json.Unmarshal([]byte(data), &message); err != nil {
+				s.quarantine(ctx, QuarantinedRecord{Resource: "message", UserID: userID.String(), Key: messagesKey(threadID), Field: byThread[threadID][i]}, err)
+				continue
+			}
+
+			messages = append(messages, message)
+		}
 	}
 
 	return messages, nil
 }
 
-// GetMessagesPaginated returns messages with pagination support
-func (s *SyncService) GetMessagesPaginated(threadID string, offset, limit int, since *time.Time) (*types.PaginatedMessagesResponse, error) {
-	pattern := fmt.Sprintf("messages:%s:*", threadID)
-	keys, err := s.db.Keys(pattern)
+// BuildMessageIndexes populates messages_index:{user} from existing threads.
+// It only runs once; later runs are skipped via a marker key.
+func (s *SyncService) BuildMessageIndexes(ctx context.Context) (int, error) {
+	const markerKey = "migrations:messages_index"
+	if _, err := s.db.Get(ctx, markerKey); err == nil {
+		return 0, nil
+	}
+
+	indexKeys, err := s.db.Keys(ctx, "threads_index:*")
 	if err != nil {
-		return nil, fmt.Errorf("failed to get message keys: %w", err)
+		return 0, fmt.Errorf("failed to get thread indexes: %w", err)
 	}
 
-	var allMessages []types.Message
-	for _, key := range keys {
-		data, err := s.db.Get(key)
+	indexed := 0
+	score := float64(s.clock.Now().UnixMilli())
+	for _, indexKey := range indexKeys {
+		userID, err := uuid.Parse(strings.TrimPrefix(indexKey, "threads_index:"))
 		if err != nil {
 			continue
 		}
 
-		var message types.Message
-		if err := json.Unmarshal([]byte(data), &message); err != nil {
-			continue
+		threadIDs, err := s.db.SMembers(ctx, indexKey)
+		if err != nil {
+			return indexed, fmt.Errorf("failed to get threads of user %s: %w", userID, err)
+		}
+
+		for _, threadID := range threadIDs {
+			entries, err := s.db.HGetAll(ctx, messagesKey(threadID))
+			if err != nil {
+				return indexed, fmt.Errorf("failed to get messages of thread %s: %w", threadID, err)
+			}
+
+			for messageID := range entries {
+				if err := s.db.ZAdd(ctx, messageIndexKey(userID), score, messageIndexMember(threadID, messageID)); err != nil {
+					return indexed, fmt.Errorf("failed to index message %s: %w", messageID, err)
+				}
+				indexed++
+			}
 		}
+	}
+
+	if err := s.db.Set(ctx, markerKey, s.clock.Now().Format(time.RFC3339), 0); err != nil {
+		return indexed, fmt.Errorf("failed to store migration marker: %w", err)
+	}
+
+	return indexed, nil
+}
+
+func (s *SyncService) GetMessages(ctx context.Context, threadID string, since *time.Time) ([]types.Message, error) {
+	if err := s.rehydrate(ctx, threadID); err != nil {
+		return nil, err
+	}
+
+	// Since timestamps are now encrypted, we can't filter by time
+	// Client will need to handle filtering if needed
+	messages, _, err := s.loadThreadMessages(ctx, threadID)
+	return messages, err
+}
+
+// GetMessagesPaginated returns messages with pagination support
+func (s *SyncService) GetMessagesPaginated(ctx context.Context, threadID string, offset, limit int, since *time.Time) (*types.PaginatedMessagesResponse, error) {
+	if err := s.rehydrate(ctx, threadID); err != nil {
+		return nil, err
+	}
 
-		// Since timestamps are now encrypted, we can't filter by time
-		// Client will need to handle filtering if needed
-		allMessages = append(allMessages, message)
+	// Since timestamps are now encrypted, we can't filter by time
+	// Client will need to handle filtering if needed
+	allMessages, corrupted, err := s.loadThreadMessages(ctx, threadID)
+	if err != nil {
+		return nil, err
 	}
 
 	total := len(allMessages)
@@ -260,423 +765,491 @@ func (s *SyncService) GetMessagesPaginated(threadID string, offset, limit int, s
 	hasMore := offset+limit < total
 
 	return &types.PaginatedMessagesResponse{
-		Messages: paginatedMessages,
-		Total:    total,
-		Offset:   offset,
-		Limit:    limit,
-		HasMore:  hasMore,
+		Messages:       paginatedMessages,
+		Total:          total,
+		Offset:         offset,
+		Limit:          limit,
+		HasMore:        hasMore,
+		NextCursor:     nextMessageCursor(paginatedMessages, hasMore),
+		CorruptedCount: corrupted,
 	}, nil
 }
 
-func (s *SyncService) CreateMessage(threadID string, message *types.Message) error {
-	if message.ID == "" {
-		message.ID = uuid.New().String()
+// GetMessagesAfter returns the page of messages following cursor, in message ID order; an empty
+// cursor starts with the first message. Messages created or deleted between requests don't shift
+// the pages.
+func (s *SyncService) GetMessagesAfter(ctx context.Context, threadID string, cursor string, limit int) (*types.PaginatedMessagesResponse, error) {
+	var after pageCursor
+	if cursor != "" {
+		var err error
+		if after, err = decodePageCursor(cursor); err != nil {
+			return nil, err
+		}
 	}
 
-	if err := s.saveMessage(threadID, message); err != nil {
-		return err
+	if err := s.rehydrate(ctx, threadID); err != nil {
+		return nil, err
 	}
 
-	// Store the change tracking for new message
-	now := time.Now()
-	if err := s.storeMessageChange("message", message.ID, "create", now, threadID); err != nil {
-		// Log error but don't fail the operation
-		fmt.Printf("Warning: failed to store message change tracking: %v\n", err)
+	allMessages, corrupted, err := s.loadThreadMessages(ctx, threadID)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	start := 0
+	if cursor != "" {
+		start = sort.Search(len(allMessages), func(i int) bool { return allMessages[i].ID > after.ID })
+	}
+	end := min(start+limit, len(allMessages))
+	hasMore := end < len(allMessages)
+	page := allMessages[start:end]
+
+	return &types.PaginatedMessagesResponse{
+		Messages:       page,
+		Total:          len(allMessages),
+		Limit:          limit,
+		HasMore:        hasMore,
+		NextCursor:     nextMessageCursor(page, hasMore),
+		CorruptedCount: corrupted,
+	}, nil
 }
 
-func (s *SyncService) UpdateMessage(threadID string, message *types.Message, machineID string) error {
-	// Since version is now encrypted, we can't do version checking here
-	// Version checking would need to be done on the client side
+// CreateMessage adds a message to a thread, generating its ID if the client didn't choose one
+func (s *SyncService) CreateMessage(ctx context.Context, userID uuid.UUID, threadID string, message *types.Message, machineID string) error {
+	_, err := s.createMessage(ctx, userID, threadID, message, machineID)
+	return err
+}
 
-	if err := s.saveMessage(threadID, message); err != nil {
-		return err
+// createMessage is CreateMessage, reporting whether the message was new rather than a retry
+func (s *SyncService) createMessage(ctx context.Context, userID uuid.UUID, threadID string, message *types.Message, machineID string) (bool, error) {
+	if err := s.rehydrate(ctx, threadID); err != nil {
+		return false, err
 	}
 
-	// Store the machine ID for this change
-	now := time.Now()
-	if err := s.storeMachineIDForChange("message", uuid.MustParse(message.ID), machineID, now); err != nil {
-		// Log error but don't fail the operation
-		fmt.Printf("Warning: failed to store machine ID for message change: %v\n", err)
+	if message.ID == "" {
+		message.ID = uuid.New().String()
 	}
 
-	// Store the change tracking for updated message
-	if err := s.storeMessageChange("message", message.ID, "update", now, threadID); err != nil {
-		// Log error but don't fail the operation
-		fmt.Printf("Warning: failed to store message change tracking: %v\n", err)
+	// Message IDs are unique within their thread. Creating a message that already exists with the
+	// same content is a retry and changes nothing; with other content, the ID is taken.
+	data, err := json.Marshal(message)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal message: %w", err)
+	}
+	existing, err := s.db.HGet(ctx, messagesKey(threadID), message.ID)
+	if database.IsNotFound(err) {
+		existing, err = s.getInheritedMessage(ctx, threadID, message.ID)
+	}
+	if err == nil {
+		if existing == string(data) {
+			return false, nil
+		}
+		return false, fmt.Errorf("%w: %s", ErrMessageIDTaken, message.ID)
+	}
+	if !database.IsNotFound(err) {
+		return false, fmt.Errorf("failed to check message ID: %w", err)
 	}
 
-	return nil
-}
+	added, err := s.addMessage(ctx, userID, threadID, message)
+	if err != nil {
+		return false, err
+	}
+	if !added {
+		// A concurrent create of the same ID got there first
+		existing, err := s.db.HGet(ctx, messagesKey(threadID), message.ID)
+		if err != nil {
+			return false, fmt.Errorf("failed to check message ID: %w", err)
+		}
+		if existing == string(data) {
+			return false, nil
+		}
+		return false, fmt.Errorf("%w: %s", ErrMessageIDTaken, message.ID)
+	}
 
-func (s *SyncService) DeleteMessage(threadID, messageID string) error {
-	key := fmt.Sprintf("messages:%s:%s", threadID, messageID)
+	s.recordChange(ctx, changeRecord{
+		Resource:   "message",
+		Operation:  "create",
+		ResourceID: message.ID,
+		ThreadID:   threadID,
+		UserID:     userID,
+		MachineID:  machineID,
+		Timestamp:  s.clock.Now(),
+	})
+
+	return true, nil
+}
 
-	// Store the change tracking for deleted message before actually deleting it
-	now := time.Now()
-	if err := s.storeMessageChange("message", messageID, "delete", now, threadID); err != nil {
-		// Log error but don't fail the operation
-		fmt.Printf("Warning: failed to store message change tracking: %v\n", err)
+// UpdateMessage replaces a message. version is the plaintext version the client sent along; an
+// update not newer than the last one is rejected with a *MessageConflictError.
+func (s *SyncService) UpdateMessage(ctx context.Context, userID uuid.UUID, threadID string, message *types.Message, version int64, machineID string) error {
+	if err := s.rehydrate(ctx, threadID); err != nil {
+		return err
 	}
 
-	// Simply delete the key from Redis
-	if err := s.db.Del(key); err != nil {
-		return fmt.Errorf("failed to delete message: %w", err)
+	if err := s.checkMessageVersion(ctx, threadID, message.ID, version); err != nil {
+		return err
 	}
 
-	return nil
-}
-
-func (s *SyncService) saveMessage(threadID string, message *types.Message) error {
-	key := fmt.Sprintf("messages:%s:%s", threadID, message.ID)
+	// Branches keep the version they inherited; an inherited message edited in a branch becomes its own
+	if err := s.detachBranches(ctx, userID, threadID, message.ID); err != nil {
+		return err
+	}
 
-	data, err := json.Marshal(message)
+	// A message the thread neither holds nor inherits yet counts towards its quota like a created one
+	held, err := optional(s.db.HGet(ctx, messagesKey(threadID), message.ID))
 	if err != nil {
-		return fmt.Errorf("failed to marshal message: %w", err)
+		return fmt.Errorf("failed to get message: %w", err)
 	}
-
-	if err := s.db.Set(key, string(data), 0); err != nil {
-		return fmt.Errorf("failed to save message: %w", err)
+	inherited, err := optional(s.db.HGet(ctx, threadRefsKey(threadID), message.ID))
+	if err != nil {
+		return fmt.Errorf("failed to get inherited message: %w", err)
+	}
+	added := false
+	if held == nil && inherited == nil {
+		if added, err = s.addMessage(ctx, userID, threadID, message); err != nil {
+			return err
+		}
+	}
+	if !added {
+		if err := s.saveMessage(ctx, userID, threadID, message); err != nil {
+			return err
+		}
 	}
+	if _, err := s.db.HDel(ctx, threadRefsKey(threadID), message.ID); err != nil {
+		return fmt.Errorf("failed to drop reference to inherited message: %w", err)
+	}
+	if err := s.saveMessageVersion(ctx, threadID, message.ID, version); err != nil {
+		return err
+	}
+
+	s.recordChange(ctx, changeRecord{
+		Resource:   "message",
+		Operation:  "update",
+		ResourceID: message.ID,
+		ThreadID:   threadID,
+		UserID:     userID,
+		MachineID:  machineID,
+		Timestamp:  s.clock.Now(),
+	})
 
 	return nil
 }
 
-// User settings operations
-func (s *SyncService) GetProviderInstances(userID uuid.UUID) (*types.ProviderInstances, error) {
-	key := fmt.Sprintf("provider_instances:%s", userID.String())
-	data, err := s.db.Get(key)
-	if err != nil {
-		return nil, err
+func (s *SyncService) DeleteMessage(ctx context.Context, userID uuid.UUID, threadID, messageID, machineID string) error {
+	if err := s.rehydrate(ctx, threadID); err != nil {
+		return err
 	}
 
-	var providers types.ProviderInstances
-	if err := json.Unmarshal([]byte(data), &providers); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal provider instances: %w", err)
+	if err := s.detachBranches(ctx, userID, threadID, messageID); err != nil {
+		return err
 	}
 
-	return &providers, nil
-}
-
-func (s *SyncService) UpdateProviderInstances(providers *types.ProviderInstances, machineID string) error {
-	now := time.Now()
-	providers.UpdatedAt = now
-
-	key := fmt.Sprintf("provider_instances:%s", providers.UserID.String())
-	data, err := json.Marshal(providers)
+	// Only deleting a message the thread holds or inherits changes its count
+	held, err := optional(s.db.HGet(ctx, messagesKey(threadID), messageID))
+	if err != nil {
+		return fmt.Errorf("failed to get message: %w", err)
+	}
+	inherited, err := optional(s.db.HGet(ctx, threadRefsKey(threadID), messageID))
 	if err != nil {
-		return fmt.Errorf("failed to marshal provider instances: %w", err)
+		return fmt.Errorf("failed to get inherited message: %w", err)
+	}
+	if held != nil || inherited != nil {
+		if err := s.prepareMessageCount(ctx, threadID); err != nil {
+			return err
+		}
 	}
 
-	if err := s.db.Set(key, string(data), 0); err != nil {
-		return err
+	// Remove the message from the thread's hash, or the branch's reference to it. Of concurrent
+	// deletes, only the one that removed it counts it off.
+	removedHeld, err := s.db.HDel(ctx, messagesKey(threadID), messageID)
+	if err != nil {
+		return fmt.Errorf("failed to delete message: %w", err)
+	}
+	removedInherited, err := s.db.HDel(ctx, threadRefsKey(threadID), messageID)
+	if err != nil {
+		return fmt.Errorf("failed to delete message: %w", err)
+	}
+	if removedHeld+removedInherited > 0 {
+		s.adjustMessageCount(ctx, threadID, -1)
+	}
+	if _, err := s.db.HDel(ctx, messageVersionsKey(threadID), messageID); err != nil {
+		return fmt.Errorf("failed to delete message version: %w", err)
 	}
 
-	// Store the machine ID for this change
-	if err := s.storeMachineIDForChange("provider_instances", providers.UserID, machineID, now); err != nil {
-		// Log error but don't fail the operation
-		fmt.Printf("Warning: failed to store machine ID for provider instances change: %v\n", err)
+	if err := s.db.ZRem(ctx, messageIndexKey(userID), messageIndexMember(threadID, messageID)); err != nil {
+		return fmt.Errorf("failed to remove from message index: %w", err)
 	}
 
+	s.recordChange(ctx, changeRecord{
+		Resource:   "message",
+		Operation:  "delete",
+		ResourceID: messageID,
+		ThreadID:   threadID,
+		UserID:     userID,
+		MachineID:  machineID,
+		Timestamp:  s.clock.Now(),
+	})
+
 	return nil
 }
 
-func (s *SyncService) GetDisabledModels(userID uuid.UUID) (*types.DisabledModels, error) {
-	key := fmt.Sprintf("disabled_models:%s", userID.String())
-	data, err := s.db.Get(key)
+// threadMessageIDs returns the IDs of the messages a thread holds or inherits, in ID order
+func (s *SyncService) threadMessageIDs(ctx context.Context, threadID string) ([]string, error) {
+	own, err := s.db.HGetAll(ctx, messagesKey(threadID))
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to get messages: %w", err)
 	}
-
-	var models types.DisabledModels
-	if err := json.Unmarshal([]byte(data), &models); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal disabled models: %w", err)
+	inherited, err := s.db.HGetAll(ctx, threadRefsKey(threadID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get inherited messages: %w", err)
 	}
-
-	return &models, nil
+	messageIDs := make([]string, 0, len(own)+len(inherited))
+	for messageID := range own {
+		messageIDs = append(messageIDs, messageID)
+	}
+	for messageID := range inherited {
+		if _, ok := own[messageID]; !ok {
+			messageIDs = append(messageIDs, messageID)
+		}
+	}
+	sort.Strings(messageIDs)
+	return messageIDs, nil
 }
 
-func (s *SyncService) UpdateDisabledModels(models *types.DisabledModels, machineID string) error {
-	now := time.Now()
-	models.UpdatedAt = now
-
-	key := fmt.Sprintf("disabled_models:%s", models.UserID.String())
-	data, err := json.Marshal(models)
-	if err != nil {
-		return fmt.Errorf("failed to marshal disabled models: %w", err)
+// deleteThreadMessages deletes the messages of a thread along with their versions and index entries
+func (s *SyncService) deleteThreadMessages(ctx context.Context, userID uuid.UUID, threadID string, messageIDs []string) error {
+	if err := s.db.Del(ctx, messagesKey(threadID)); err != nil {
+		return fmt.Errorf("failed to delete messages: %w", err)
 	}
-
-	if err := s.db.Set(key, string(data), 0); err != nil {
-		return err
+	if err := s.db.Del(ctx, messageVersionsKey(threadID)); err != nil {
+		return fmt.Errorf("failed to delete message versions: %w", err)
 	}
-
-	// Store the machine ID for this change
-	if err := s.storeMachineIDForChange("disabled_models", models.UserID, machineID, now); err != nil {
-		// Log error but don't fail the operation
-		fmt.Printf("Warning: failed to store machine ID for disabled models change: %v\n", err)
+	if err := s.db.Del(ctx, messageCountKey(threadID)); err != nil {
+		return fmt.Errorf("failed to delete message count: %w", err)
+	}
+	if len(messageIDs) == 0 {
+		return nil
+	}
+	members := make([]interface{}, len(messageIDs))
+	for i, messageID := range messageIDs {
+		members[i] = messageIndexMember(threadID, messageID)
+	}
+	if err := s.db.ZRem(ctx, messageIndexKey(userID), members...); err != nil {
+		return fmt.Errorf("failed to remove from message index: %w", err)
 	}
-
 	return nil
 }
 
-func (s *SyncService) GetAdvancedSettings(userID uuid.UUID) (*types.AdvancedSettings, error) {
-	key := fmt.Sprintf("advanced_settings:%s", userID.String())
-	data, err := s.db.Get(key)
+// addMessage stores a message the thread neither holds nor inherits, counting it towards the
+// thread's quota. It claims the ID first and reports false, storing nothing, if a concurrent write
+// stored a message under it already.
+func (s *SyncService) addMessage(ctx context.Context, userID uuid.UUID, threadID string, message *types.Message) (bool, error) {
+	data, err := json.Marshal(message)
 	if err != nil {
-		return nil, err
+		return false, fmt.Errorf("failed to marshal message: %w", err)
 	}
-
-	var settings types.AdvancedSettings
-	if err := json.Unmarshal([]byte(data), &settings); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal advanced settings: %w", err)
+	if err := s.reserveMessage(ctx, threadID); err != nil {
+		return false, err
 	}
-
-	return &settings, nil
+	claimed, err := s.db.HSetNX(ctx, messagesKey(threadID), message.ID, string(data))
+	if err != nil || !claimed {
+		s.adjustMessageCount(ctx, threadID, -1)
+		if err != nil {
+			return false, fmt.Errorf("failed to save message: %w", err)
+		}
+		return false, nil
+	}
+	return true, s.saveMessage(ctx, userID, threadID, message)
 }
 
-func (s *SyncService) UpdateAdvancedSettings(settings *types.AdvancedSettings, machineID string) error {
-	now := time.Now()
-	settings.UpdatedAt = now
-
-	key := fmt.Sprintf("advanced_settings:%s", settings.UserID.String())
-	data, err := json.Marshal(settings)
+func (s *SyncService) saveMessage(ctx context.Context, userID uuid.UUID, threadID string, message *types.Message) error {
+	data, err := json.Marshal(message)
 	if err != nil {
-		return fmt.Errorf("failed to marshal advanced settings: %w", err)
+		return fmt.Errorf("failed to marshal message: %w", err)
 	}
 
-	if err := s.db.Set(key, string(data), 0); err != nil {
-		return err
+	if err := s.db.HSet(ctx, messagesKey(threadID), message.ID, string(data)); err != nil {
+		return fmt.Errorf("failed to save message: %w", err)
 	}
 
-	// Store the machine ID for this change
-	if err := s.storeMachineIDForChange("advanced_settings", settings.UserID, machineID, now); err != nil {
-		// Log error but don't fail the operation
-		fmt.Printf("Warning: failed to store machine ID for advanced settings change: %v\n", err)
+	// Index by server receive time so a user's messages can be listed without scanning
+	score := float64(s.clock.Now().UnixMilli())
+	if err := s.db.ZAdd(ctx, messageIndexKey(userID), score, messageIndexMember(threadID, message.ID)); err != nil {
+		return fmt.Errorf("failed to update message index: %w", err)
 	}
 
 	return nil
 }
 
-// GetChangesSince retrieves changes since the given timestamp
-func (s *SyncService) GetChangesSince(userID uuid.UUID, timestamp time.Time) (*types.ChangesSinceResponse, error) {
-	now := time.Now()
-	response := &types.ChangesSinceResponse{SyncTimestamp: now}
-
-	// Initial full sync if timestamp is zero
-	if timestamp.IsZero() {
-		fullThreads, _ := s.GetThreads(userID, nil)
-		// For messages, we need to get all messages across all threads
-		// Since messages are now encrypted, we'll get them by thread pattern
-		var fullMessages []types.Message
-		pattern := "messages:*"
-		keys, err := s.db.Keys(pattern)
-		if err == nil {
-			for _, key := range keys {
-				data, err := s.db.Get(key)
-				if err != nil {
-					continue
-				}
-				var message types.Message
-				if err := json.Unmarshal([]byte(data), &message); err != nil {
-					continue
-				}
-				fullMessages = append(fullMessages, message)
-			}
+// MigrateMessageLayout moves messages stored as one key per message
+// (messages:{threadID}:{messageID}) into the per-thread hashes. It is safe to run repeatedly.
+func (s *SyncService) MigrateMessageLayout(ctx context.Context) (int, error) {
+	keys, err := s.db.Keys(ctx, "messages:*:*")
+	if err != nil {
+		return 0, fmt.Errorf("failed to get legacy message keys: %w", err)
+	}
+
+	migrated := 0
+	for _, key := range keys {
+		parts := strings.SplitN(key, ":", 3)
+		if len(parts) != 3 {
+			continue
 		}
 
-		pi, _ := s.GetProviderInstances(userID)
-		if pi != nil {
-			response.ProviderInstances = pi
+		data, err := s.db.Get(ctx, key)
+		if err != nil {
+			continue
 		}
-		dm, _ := s.GetDisabledModels(userID)
-		if dm != nil {
-			response.DisabledModels = dm
+
+		if err := s.db.HSet(ctx, messagesKey(parts[1]), parts[2], data); err != nil {
+			return migrated, fmt.Errorf("failed to migrate message %s: %w", key, err)
 		}
-		as, _ := s.GetAdvancedSettings(userID)
-		if as != nil {
-			response.AdvancedSettings = as
+		if err := s.db.Del(ctx, key); err != nil {
+			return migrated, fmt.Errorf("failed to delete legacy message %s: %w", key, err)
 		}
-		response.FullThreads = fullThreads
-		response.FullMessages = fullMessages
-		return response, nil
-	}
-
-	// Incremental sync: build operations since timestamp
-	var ops []types.ChangeOperation
-
-	// Threads
-	threads, _ := s.GetThreads(userID, &timestamp)
-	for _, t := range threads {
-		// Since UpdatedAt is encrypted, use Version (which is milliseconds timestamp) to create time.Time
-		changeTimestamp := time.UnixMilli(t.Version)
-		machineID, _ := s.getMachineIDForChange("thread", t.ID, changeTimestamp)
-		ops = append(ops, types.ChangeOperation{
-			Resource:  "thread",
-			Operation: "update",
-			ID:        t.ID.String(),
-			MachineID: machineID,
-			Data:      t,
-			Timestamp: changeTimestamp,
-		})
-	}
-
-	// For messages, since everything is encrypted, we can't easily filter by timestamp
-	// We'll need to return all messages and let the client handle filtering
-	// This is a limitation of having encrypted timestamps
-
-	// Provider Instances
-	if pi, err := s.GetProviderInstances(userID); err == nil && pi != nil && pi.UpdatedAt.After(timestamp) {
-		machineID, _ := s.getMachineIDForChange("provider_instances", pi.UserID, pi.UpdatedAt)
-		ops = append(ops, types.ChangeOperation{
-			Resource:  "provider_instances",
-			Operation: "update",
-			ID:        pi.UserID.String(),
-			MachineID: machineID,
-			Data:      pi,
-			Timestamp: pi.UpdatedAt,
-		})
-	}
-
-	// Disabled Models
-	if dm, err := s.GetDisabledModels(userID); err == nil && dm != nil && dm.UpdatedAt.After(timestamp) {
-		machineID, _ := s.getMachineIDForChange("disabled_models", dm.UserID, dm.UpdatedAt)
-		ops = append(ops, types.ChangeOperation{
-			Resource:  "disabled_models",
-			Operation: "update",
-			ID:        dm.UserID.String(),
-			MachineID: machineID,
-			Data:      dm,
-			Timestamp: dm.UpdatedAt,
-		})
-	}
-
-	// Advanced Settings
-	if as, err := s.GetAdvancedSettings(userID); err == nil && as != nil && as.UpdatedAt.After(timestamp) {
-		machineID, _ := s.getMachineIDForChange("advanced_settings", as.UserID, as.UpdatedAt)
-		ops = append(ops, types.ChangeOperation{
-			Resource:  "advanced_settings",
-			Operation: "update",
-			ID:        as.UserID.String(),
-			MachineID: machineID,
-			Data:      as,
-			Timestamp: as.UpdatedAt,
-		})
+		migrated++
 	}
 
-	// Message changes
-	messageChanges, _ := s.getMessageChangesSince(timestamp)
-	ops = append(ops, messageChanges...)
-
-	response.Operations = ops
-	return response, nil
+	return migrated, nil
 }
 
-// storeMachineIDForChange stores the machine ID that made a specific change
-func (s *SyncService) storeMachineIDForChange(resourceType string, resourceID uuid.UUID, machineID string, timestamp time.Time) error {
-	key := fmt.Sprintf("machine_id:%s:%s:%d", resourceType, resourceID.String(), timestamp.UnixMilli())
-	return s.db.Set(key, machineID, 0) // Store permanently for now
+// settingsRevisionsKey returns the hash mapping each settings resource to the time of its last change
+func settingsRevisionsKey(userID uuid.UUID) string {
+	return fmt.Sprintf("settings_revisions:%s", userID.String())
 }
 
-// getMachineIDForChange retrieves the machine ID that made a specific change
-func (s *SyncService) getMachineIDForChange(resourceType string, resourceID uuid.UUID, timestamp time.Time) (string, error) {
-	key := fmt.Sprintf("machine_id:%s:%s:%d", resourceType, resourceID.String(), timestamp.UnixMilli())
-	return s.db.Get(key)
+// markSettingsChanged bumps the revision of a settings resource so clients know to refetch it
+func (s *SyncService) markSettingsChanged(ctx context.Context, userID uuid.UUID, resource string, now time.Time) error {
+	return s.db.HSet(ctx, settingsRevisionsKey(userID), resource, now.UnixMilli())
 }
 
-// storeMessageChange stores a message change for tracking in the changes-since endpoint
-func (s *SyncService) storeMessageChange(resourceType, messageID, operation string, timestamp time.Time, threadID string) error {
-	key := fmt.Sprintf("message_changes:%s:%d", messageID, timestamp.UnixMilli())
-	changeData := map[string]interface{}{
-		"resource":   resourceType,
-		"message_id": messageID,
-		"thread_id":  threadID,
-		"operation":  operation,
-		"timestamp":  timestamp.UnixMilli(),
+// GetSettingsRevisions returns the last change time (unix ms) of each settings resource.
+// Clients compare it with what they have cached instead of polling every settings endpoint.
+func (s *SyncService) GetSettingsRevisions(ctx context.Context, userID uuid.UUID) (types.SettingsRevisions, error) {
+	entries, err := s.db.HGetAll(ctx, settingsRevisionsKey(userID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get settings revisions: %w", err)
 	}
 
-	data, err := json.Marshal(changeData)
-	if err != nil {
-		return fmt.Errorf("failed to marshal message change: %w", err)
+	revisions := types.SettingsRevisions{}
+	for resource, value := range entries {
+		if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+			revisions[resource] = ms
+		}
 	}
+	return revisions, nil
+}
 
-	// Store with TTL of 30 days (2592000 seconds) to prevent infinite growth
-	return s.db.Set(key, string(data), 2592000)
+// GetChangesSince retrieves changes since the given sync timestamp. Timestamps can't tell apart
+// changes recorded in the same millisecond; use GetChanges with a sync cursor.
+func (s *SyncService) GetChangesSince(ctx context.Context, userID uuid.UUID, timestamp time.Time) (*types.ChangesSinceResponse, error) {
+	return s.GetChanges(ctx, userID, strconv.FormatInt(timestamp.UnixMilli(), 10))
 }
 
-// getMessageChangesSince retrieves message changes since the given timestamp
-func (s *SyncService) getMessageChangesSince(timestamp time.Time) ([]types.ChangeOperation, error) {
-	pattern := "message_changes:*"
-	keys, err := s.db.Keys(pattern)
+// GetChanges retrieves the changes after since: the sync cursor of an earlier response, or its
+// sync timestamp in unix milliseconds from older clients. 0 or an empty since get a full sync, as
+// do cursors older than the change log reaches.
+func (s *SyncService) GetChanges(ctx context.Context, userID uuid.UUID, since string) (*types.ChangesSinceResponse, error) {
+	after, full, err := changeLogEntryAfter(since)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get message change keys: %w", err)
+		return nil, err
 	}
 
+	response := &types.ChangesSinceResponse{}
 	var ops []types.ChangeOperation
-	for _, key := range keys {
-		data, err := s.db.Get(key)
-		if err != nil {
-			continue
-		}
-
-		var changeData map[string]interface{}
-		if err := json.Unmarshal([]byte(data), &changeData); err != nil {
-			continue
-		}
-
-		// Extract timestamp and check if it's after the requested timestamp
-		timestampMs, ok := changeData["timestamp"].(float64)
-		if !ok {
-			continue
-		}
-
-		changeTimestamp := time.UnixMilli(int64(timestampMs))
-		if !changeTimestamp.After(timestamp) {
-			continue
+	var corrupted int
+	if !full {
+		// Incremental sync: replay the change log after the cursor
+		ops, after, corrupted, err = s.getChangesFromLog(ctx, userID, after)
+		full = errors.Is(err, errChangeLogTruncated)
+		if err != nil && !full {
+			return nil, err
 		}
+	}
 
-		// Get the actual message data
-		messageID, ok := changeData["message_id"].(string)
-		if !ok {
-			continue
+	if full {
+		// The cursor is taken first so changes made while the snapshot is read are replayed later
+		entry, at, err := s.changeLogPosition(ctx, userID)
+		if err != nil {
+			return nil, err
 		}
+		response.SyncCursor = syncCursor{Entry: entry}.encode()
+		response.SyncTimestamp = at
+		s.fillFullSync(ctx, userID, response)
+		s.addMigration(response)
+		return response, nil
+	}
 
-		threadID, ok := changeData["thread_id"].(string)
-		if !ok {
-			continue
-		}
+	// The cursor follows the change log so the next request resumes right after the last entry read
+	response.Operations = ops
+	response.CorruptedCount = corrupted
+	response.SyncCursor = syncCursor{Entry: after}.encode()
+	response.SyncTimestamp = streamIDTime(after)
+	response.SettingsRevisions, _ = s.GetSettingsRevisions(ctx, userID)
+	s.addMigration(response)
+	return response, nil
+}
 
-		operation, ok := changeData["operation"].(string)
-		if !ok {
-			continue
-		}
+// addMigration appends the migration announcement, if any, to a changes-since response. It isn't
+// part of the change log, so every sync repeats it until the operator withdraws it.
+func (s *SyncService) addMigration(response *types.ChangesSinceResponse) {
+	if s.migration == nil {
+		return
+	}
+	response.Operations = append(response.Operations, types.ChangeOperation{
+		Resource:  types.ResourceInstance,
+		Operation: types.OperationMigrate,
+		ID:        s.migration.TargetURL,
+		Data:      s.migration,
+		Timestamp: s.clock.Now(),
+	})
+}
 
-		var messageData interface{}
-		if operation != "delete" {
-			// For non-delete operations, include the message data
-			messageKey := fmt.Sprintf("messages:%s:%s", threadID, messageID)
-			messageDataStr, err := s.db.Get(messageKey)
-			if err == nil {
-				var message types.Message
-				if err := json.Unmarshal([]byte(messageDataStr), &message); err == nil {
-					messageData = message
-				}
-			}
-		}
+// fillFullSync adds the complete state of the user's data to a changes-since response
+func (s *SyncService) fillFullSync(ctx context.Context, userID uuid.UUID, response *types.ChangesSinceResponse) {
+	fullThreads, _ := s.GetThreads(ctx, userID, nil)
+	// For messages, we need to get all of the user's messages across all threads
+	fullMessages, _ := s.GetUserMessages(ctx, userID)
+
+	s.loadSettings(ctx, userID, &response.SettingsBundle)
+	response.FullThreads = fullThreads
+	response.FullMessages = fullMessages
+	// Deleted threads as delete operations, so devices drop copies they still have
+	tombstones, _ := s.GetThreadTombstones(ctx, userID)
+	for _, tombstone := range tombstones {
+		response.Operations = append(response.Operations, types.ChangeOperation{
+			Resource:  "thread",
+			Operation: "delete",
+			ID:        tombstone.ThreadID.String(),
+			MachineID: tombstone.MachineID,
+			Timestamp: tombstone.DeletedAt,
+		})
+	}
+	response.FullMemories, _ = s.GetMemories(ctx, userID, false)
+	response.FullFolders, _ = s.GetFolders(ctx, userID, false)
+	response.FullDrafts, _ = s.GetDrafts(ctx, userID)
+	response.FullReadStates, _ = s.GetReadStates(ctx, userID)
+	response.SettingsRevisions, _ = s.GetSettingsRevisions(ctx, userID)
+}
 
-		// Get machine ID if available
-		machineID, _ := s.getMachineIDForChange("message", uuid.MustParse(messageID), changeTimestamp)
+// GetBootstrap returns what a client needs at startup: settings, the most recent threads and a sync cursor
+func (s *SyncService) GetBootstrap(ctx context.Context, userID uuid.UUID, threadLimit int) (*types.BootstrapResponse, error) {
+	// Take the cursor first so changes made while the snapshot is read are replayed later
+	entry, at, err := s.changeLogPosition(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	response := &types.BootstrapResponse{SyncCursor: syncCursor{Entry: entry}.encode(), SyncTimestamp: at}
 
-		ops = append(ops, types.ChangeOperation{
-			Resource:  "message",
-			Operation: operation,
-			ID:        messageID,
-			MachineID: machineID,
-			Data:      messageData,
-			Timestamp: changeTimestamp,
-		})
+	threads, err := s.GetThreadsPaginated(ctx, userID, 0, threadLimit, nil, true)
+	if err != nil {
+		return nil, err
 	}
+	response.Threads = threads
+	response.Folders, _ = s.GetFolders(ctx, userID, false)
+
+	s.loadSettings(ctx, userID, &response.SettingsBundle)
+	response.SettingsRevisions, _ = s.GetSettingsRevisions(ctx, userID)
 
-	return ops, nil
+	return response, nil
 }
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/database"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// Users archive threads to hide them from thread listings without deleting them. Unlike archival
+// to cold storage (ArchivedRemote), it is the user's choice and synced to their devices: archived
+// threads stay in full syncs and are listed with ?include_archived=true.
+
+// archivedThreadsKey returns the set of the user's archived thread IDs, which listings leave out
+func archivedThreadsKey(userID uuid.UUID) string {
+	return fmt.Sprintf("user_archived_threads:%s", userID.String())
+}
+
+// SetThreadArchived archives or unarchives one of the user's threads, and records it as an
+// "archive" or "unarchive" operation. Setting the state the thread is already in changes nothing.
+func (s *SyncService) SetThreadArchived(ctx context.Context, userID, threadID uuid.UUID, archived bool, machineID string) (*types.Thread, error) {
+	thread, err := s.getThread(ctx, userID, threadID)
+	if database.IsNotFound(err) {
+		return nil, ErrThreadNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if thread.Archived == archived {
+		return thread, nil
+	}
+
+	thread.Archived = archived
+	if err := s.saveThread(ctx, thread); err != nil {
+		return nil, err
+	}
+
+	operation := "archive"
+	if !archived {
+		operation = "unarchive"
+	}
+	s.recordChange(ctx, changeRecord{
+		Resource:   "thread",
+		Operation:  operation,
+		ResourceID: threadID.String(),
+		UserID:     userID,
+		MachineID:  machineID,
+		Timestamp:  s.clock.Now(),
+	})
+	return thread, nil
+}
+
+// hiddenThreads returns the IDs of the threads a listing leaves out: the user's archived threads,
+// unless includeArchived
+func (s *SyncService) hiddenThreads(ctx context.Context, userID uuid.UUID, includeArchived bool) (map[string]bool, error) {
+	if includeArchived {
+		return nil, nil
+	}
+	archived, err := s.db.SMembers(ctx, archivedThreadsKey(userID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get archived threads: %w", err)
+	}
+	hidden := make(map[string]bool, len(archived))
+	for _, threadID := range archived {
+		hidden[threadID] = true
+	}
+	return hidden, nil
+}
+
+// threadIndexRange returns the IDs of the thread timestamp index scored between min and max, newest
+// first, skipping offset of them and returning at most count (all for count <= 0). Hidden threads
+// are left out and don't count towards offset.
+func (s *SyncService) threadIndexRange(ctx context.Context, userID uuid.UUID, min, max string, offset, count int64, hidden map[string]bool) ([]string, error) {
+	timestampKey := fmt.Sprintf("timestamps:threads:%s", userID.String())
+	if len(hidden) == 0 {
+		if count <= 0 {
+			count = -1
+		}
+		return s.db.ZRevRangeByScore(ctx, timestampKey, min, max, offset, count)
+	}
+
+	all, err := s.db.ZRevRangeByScore(ctx, timestampKey, min, max, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	var threadIDs []string
+	for _, threadID := range all {
+		if hidden[threadID] {
+			continue
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		if count > 0 && int64(len(threadIDs)) >= count {
+			break
+		}
+		threadIDs = append(threadIDs, threadID)
+	}
+	return threadIDs, nil
+}
+
+// countThreadIndex counts the threads of the timestamp index scored between min and max, hidden
+// threads left out
+func (s *SyncService) countThreadIndex(ctx context.Context, userID uuid.UUID, min, max string, hidden map[string]bool) (int64, error) {
+	if len(hidden) == 0 {
+		return s.db.ZCount(ctx, fmt.Sprintf("timestamps:threads:%s", userID.String()), min, max)
+	}
+	threadIDs, err := s.threadIndexRange(ctx, userID, min, max, 0, 0, hidden)
+	return int64(len(threadIDs)), err
+}
@@ -0,0 +1,88 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// maxThreadMetaEntries bounds the meta-history kept per thread
+const maxThreadMetaEntries = 100
+
+// threadMetaKey returns the list holding a thread's meta-history, newest first
+func threadMetaKey(threadID uuid.UUID) string {
+	return fmt.Sprintf("thread_meta_history:%s", threadID.String())
+}
+
+// recordThreadMeta appends the thread's model/settings tokens to its meta-history
+// if they differ from the most recent entry. Only changes are kept so the log stays compact.
+func (s *SyncService) recordThreadMeta(ctx context.Context, thread *types.Thread, machineID string, now time.Time) error {
+	entry := types.ThreadMetaEntry{
+		Version:              thread.Version,
+		Timestamp:            now,
+		MachineID:            machineID,
+		ProviderInstanceId:   thread.ProviderInstanceId,
+		Model:                thread.Model,
+		WebSearchEnabled:     thread.WebSearchEnabled,
+		WebSearchContextSize: thread.WebSearchContextSize,
+		Settings:             thread.Settings,
+	}
+
+	key := threadMetaKey(thread.ID)
+	if latest, err := s.db.LIndex(ctx, key, 0); err == nil {
+		var previous types.ThreadMetaEntry
+		if err := json.Unmarshal([]byte(latest), &previous); err == nil && sameThreadMeta(previous, entry) {
+			return nil
+		}
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal thread meta entry: %w", err)
+	}
+
+	if err := s.db.LPush(ctx, key, string(data)); err != nil {
+		return fmt.Errorf("failed to record thread meta entry: %w", err)
+	}
+
+	return s.db.LTrim(ctx, key, 0, maxThreadMetaEntries-1)
+}
+
+// sameThreadMeta compares the encrypted tokens of two entries. Identical ciphertext means
+// the setting is unchanged; clients that re-encrypt unchanged values produce a new entry.
+func sameThreadMeta(a, b types.ThreadMetaEntry) bool {
+	return a.ProviderInstanceId == b.ProviderInstanceId &&
+		a.Model == b.Model &&
+		a.WebSearchEnabled == b.WebSearchEnabled &&
+		a.WebSearchContextSize == b.WebSearchContextSize &&
+		reflect.DeepEqual(a.Settings, b.Settings)
+}
+
+// GetThreadMetaHistory returns a thread's meta-history in chronological order
+func (s *SyncService) GetThreadMetaHistory(ctx context.Context, userID, threadID uuid.UUID) ([]types.ThreadMetaEntry, error) {
+	// Make sure the thread belongs to the user
+	if _, err := s.getThread(ctx, userID, threadID); err != nil {
+		return nil, err
+	}
+
+	items, err := s.db.LRange(ctx, threadMetaKey(threadID), 0, -1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get thread meta-history: %w", err)
+	}
+
+	history := make([]types.ThreadMetaEntry, 0, len(items))
+	for i := len(items) - 1; i >= 0; i-- {
+		var entry types.ThreadMetaEntry
+		if err := json.Unmarshal([]byte(items[i]), &entry); err != nil {
+			continue
+		}
+		history = append(history, entry)
+	}
+
+	return history, nil
+}
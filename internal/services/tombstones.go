@@ -0,0 +1,186 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/database"
+	"github.com/helioschat/sync/internal/types"
+)
+
+var (
+	// ErrThreadDeleted is returned when a thread is written over its deletion with a version that isn't newer
+	ErrThreadDeleted = errors.New("thread was deleted")
+	// ErrSettingsDeleted is returned when a settings document is written over its deletion with a version that isn't newer
+	ErrSettingsDeleted = errors.New("settings were deleted")
+	// ErrSettingsNotFound is returned when deleting a settings document the user doesn't have
+	ErrSettingsNotFound = errors.New("settings not found")
+)
+
+// threadTombstonesKey returns the hash of a user's deleted threads: thread ID → ThreadTombstone
+func threadTombstonesKey(userID uuid.UUID) string {
+	return fmt.Sprintf("thread_tombstones:%s", userID.String())
+}
+
+// writeThreadTombstone records the deletion of a thread last written with version. The tombstone
+// is versioned after both the thread and the deletion time, so only writes made after the
+// deletion can bring the thread back.
+func (s *SyncService) writeThreadTombstone(ctx context.Context, userID, threadID uuid.UUID, version int64, machineID string, now time.Time) error {
+	// The deleting device is sealed like in the change log
+	sealed, err := s.sealer.Seal(userID, machineID)
+	if err != nil {
+		return err
+	}
+	tombstone := types.ThreadTombstone{
+		ThreadID:  threadID,
+		Version:   max(version+1, now.UnixMilli()),
+		MachineID: sealed,
+		DeletedAt: now,
+	}
+	data, err := json.Marshal(tombstone)
+	if err != nil {
+		return fmt.Errorf("failed to marshal thread tombstone: %w", err)
+	}
+	if err := s.db.HSet(ctx, threadTombstonesKey(userID), threadID.String(), string(data)); err != nil {
+		return fmt.Errorf("failed to save thread tombstone: %w", err)
+	}
+	return nil
+}
+
+// checkThreadTombstone refuses writes of a deleted thread that aren't newer than its deletion
+func (s *SyncService) checkThreadTombstone(ctx context.Context, thread *types.Thread) error {
+	data, err := s.db.HGet(ctx, threadTombstonesKey(thread.UserID), thread.ID.String())
+	if database.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get thread tombstone: %w", err)
+	}
+	var tombstone types.ThreadTombstone
+	if err := json.Unmarshal([]byte(data), &tombstone); err != nil {
+		return fmt.Errorf("failed to unmarshal thread tombstone: %w", err)
+	}
+	if thread.Version <= tombstone.Version {
+		return fmt.Errorf("%w: deleted at version %d, client version %d", ErrThreadDeleted, tombstone.Version, thread.Version)
+	}
+	return nil
+}
+
+// clearThreadTombstone forgets the deletion of a thread that was written again
+func (s *SyncService) clearThreadTombstone(ctx context.Context, userID, threadID uuid.UUID) error {
+	if _, err := s.db.HDel(ctx, threadTombstonesKey(userID), threadID.String()); err != nil {
+		return fmt.Errorf("failed to clear thread tombstone: %w", err)
+	}
+	return nil
+}
+
+// GetThreadTombstones returns the user's deleted threads. Tombstones that can't be read are skipped.
+func (s *SyncService) GetThreadTombstones(ctx context.Context, userID uuid.UUID) ([]types.ThreadTombstone, error) {
+	entries, err := s.db.HGetAll(ctx, threadTombstonesKey(userID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get thread tombstones: %w", err)
+	}
+
+	tombstones := make([]types.ThreadTombstone, 0, len(entries))
+	for threadID, data := range entries {
+		var tombstone types.ThreadTombstone
+		if err := json.Unmarshal([]byte(data), &tombstone); err != nil {
+			fmt.Printf("Warning: skipping unreadable tombstone of thread %s: %v\n", threadID, err)
+			continue
+		}
+		if tombstone.MachineID, err = s.sealer.Open(userID, tombstone.MachineID); err != nil {
+			fmt.Printf("Warning: skipping unreadable tombstone of thread %s: %v\n", threadID, err)
+			continue
+		}
+		tombstones = append(tombstones, tombstone)
+	}
+	return tombstones, nil
+}
+
+// settingsTombstone replaces a deleted settings document. It has the fields all settings documents
+// share, so it reads as a document of its type with deleted set and no payload.
+type settingsTombstone struct {
+	UserID    uuid.UUID `json:"user_id"`
+	Version   int64     `json:"version"`
+	Deleted   bool      `json:"deleted,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// DeleteSettings clears the user's settings document of resource. It is replaced with a tombstone
+// versioned after both the document and the deletion time, so devices still holding the old
+// payload can't write it back.
+func (s *SyncService) DeleteSettings(ctx context.Context, resource string, userID uuid.UUID, machineID string) error {
+	if settings, ok := lookupSettings(resource); !ok || !settings.Deletable {
+		return fmt.Errorf("%s can't be deleted", resource)
+	}
+	key := settingsKey(resource, userID)
+	data, err := s.db.Get(ctx, key)
+	if err != nil {
+		if database.IsNotFound(err) {
+			return ErrSettingsNotFound
+		}
+		return err
+	}
+	var existing settingsTombstone
+	if err := json.Unmarshal([]byte(data), &existing); err != nil {
+		return fmt.Errorf("failed to unmarshal %s: %w", resource, err)
+	}
+	if existing.Deleted {
+		return nil
+	}
+
+	now := s.clock.Now()
+	tombstone := settingsTombstone{
+		UserID:    userID,
+		Version:   max(existing.Version+1, now.UnixMilli()),
+		Deleted:   true,
+		UpdatedAt: now,
+		CreatedAt: existing.CreatedAt,
+	}
+	encoded, err := json.Marshal(tombstone)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s tombstone: %w", resource, err)
+	}
+	if err := s.db.Set(ctx, key, string(encoded), 0); err != nil {
+		return err
+	}
+
+	s.recordChange(ctx, changeRecord{
+		Resource:   resource,
+		Operation:  "delete",
+		ResourceID: userID.String(),
+		UserID:     userID,
+		MachineID:  machineID,
+		Timestamp:  now,
+	})
+
+	if err := s.markSettingsChanged(ctx, userID, resource, now); err != nil {
+		fmt.Printf("Warning: failed to update settings revision: %v\n", err)
+	}
+
+	return nil
+}
+
+// checkSettingsTombstone refuses writes of a deleted settings document that aren't newer than its deletion
+func (s *SyncService) checkSettingsTombstone(ctx context.Context, resource string, userID uuid.UUID, version int64) error {
+	data, err := s.db.Get(ctx, settingsKey(resource, userID))
+	if database.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var stored settingsTombstone
+	if err := json.Unmarshal([]byte(data), &stored); err != nil {
+		return fmt.Errorf("failed to unmarshal %s: %w", resource, err)
+	}
+	if stored.Deleted && version <= stored.Version {
+		return fmt.Errorf("%w: deleted at version %d, client version %d", ErrSettingsDeleted, stored.Version, version)
+	}
+	return nil
+}
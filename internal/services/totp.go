@@ -0,0 +1,207 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// TOTP parameters (RFC 6238 defaults, which every authenticator app supports). Codes of the
+// neighbouring time steps are accepted to tolerate clock drift.
+const (
+	totpStep        = 30 * time.Second
+	totpDigits      = 6
+	totpModulus     = 1_000_000 // 10^totpDigits
+	totpSkewSteps   = 1
+	totpSecretBytes = 20
+	totpIssuer      = "Helios"
+)
+
+var (
+	// ErrTOTPRequired is returned when a wallet with TOTP enabled is accessed without a one-time code
+	ErrTOTPRequired = errors.New("one-time code required")
+	// ErrInvalidTOTP is returned for one-time codes that don't match or were already used
+	ErrInvalidTOTP = errors.New("invalid one-time code")
+	// ErrTOTPUnavailable is returned when enrolling on an instance without a TOTP encryption key
+	ErrTOTPUnavailable = errors.New("TOTP is not available on this instance")
+	// ErrTOTPAlreadyEnabled is returned when enrolling a wallet that already has TOTP enabled
+	ErrTOTPAlreadyEnabled = errors.New("TOTP is already enabled")
+	// ErrTOTPNotEnrolled is returned when confirming or disabling TOTP that was never set up
+	ErrTOTPNotEnrolled = errors.New("TOTP is not enrolled")
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func usedTOTPKey(userID uuid.UUID, counter uint64) string {
+	return fmt.Sprintf("used_totp:%s:%d", userID.String(), counter)
+}
+
+// totpCode computes the code of a time step (RFC 4226 HOTP with SHA-1)
+func totpCode(secret []byte, counter uint64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%totpModulus)
+}
+
+// matchTOTP returns the time step code matches, within the accepted skew
+func (s *AuthService) matchTOTP(secret []byte, code string) (uint64, bool) {
+	code = strings.ReplaceAll(code, " ", "")
+	now := uint64(s.clock.Now().Unix() / int64(totpStep.Seconds()))
+	for step := now - totpSkewSteps; step <= now+totpSkewSteps; step++ {
+		if hmac.Equal([]byte(totpCode(secret, step)), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// openTOTPSecret decrypts a TOTP secret stored in the wallet
+func (s *AuthService) openTOTPSecret(userID uuid.UUID, sealed string) ([]byte, error) {
+	if s.totpSealer == nil {
+		return nil, ErrTOTPUnavailable
+	}
+	encoded, err := s.totpSealer.Open(userID, sealed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open TOTP secret: %w", err)
+	}
+	return totpEncoding.DecodeString(encoded)
+}
+
+// verifyTOTP checks the one-time code of a wallet that has TOTP enabled. Every code is accepted once.
+func (s *AuthService) verifyTOTP(ctx context.Context, wallet *types.Wallet, code string) error {
+	if wallet.TOTPSecret == "" {
+		return nil
+	}
+	if code == "" {
+		return ErrTOTPRequired
+	}
+
+	secret, err := s.openTOTPSecret(wallet.UID, wallet.TOTPSecret)
+	if err != nil {
+		return err
+	}
+	step, ok := s.matchTOTP(secret, code)
+	if !ok {
+		return ErrInvalidTOTP
+	}
+
+	// A code stays valid for its whole window, so remember it until the window has passed
+	ttl := int64(((2*totpSkewSteps + 1) * totpStep).Seconds())
+	fresh, err := s.db.SetNX(ctx, usedTOTPKey(wallet.UID, step), "1", ttl)
+	if err != nil {
+		return fmt.Errorf("failed to record one-time code: %w", err)
+	}
+	if !fresh {
+		return ErrInvalidTOTP
+	}
+	return nil
+}
+
+// EnrollTOTP generates a TOTP secret for the wallet. TOTP is only enabled once ConfirmTOTP receives
+// a code generated from it, so a secret that never made it into an authenticator can't lock the user out.
+func (s *AuthService) EnrollTOTP(ctx context.Context, userID uuid.UUID, passphrase string) (*types.TOTPEnrollment, error) {
+	if s.totpSealer == nil {
+		return nil, ErrTOTPUnavailable
+	}
+
+	wallet, err := s.getWallet(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPassphrase(wallet, passphrase); err != nil {
+		return nil, ErrPassphraseConfirmation
+	}
+	if wallet.TOTPSecret != "" {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+
+	raw := make([]byte, totpSecretBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, fmt.Errorf("failed to generate TOTP secret: %w", err)
+	}
+	secret := totpEncoding.EncodeToString(raw)
+
+	sealed, err := s.totpSealer.Seal(userID, secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to seal TOTP secret: %w", err)
+	}
+	wallet.PendingTOTPSecret = sealed
+	if err := s.saveWallet(ctx, wallet); err != nil {
+		return nil, err
+	}
+
+	label := url.PathEscape(totpIssuer + ":" + userID.String())
+	query := url.Values{
+		"secret":    {secret},
+		"issuer":    {totpIssuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(totpDigits)},
+		"period":    {fmt.Sprint(int(totpStep.Seconds()))},
+	}
+	return &types.TOTPEnrollment{
+		Secret: secret,
+		URI:    "otpauth://totp/" + label + "?" + query.Encode(),
+	}, nil
+}
+
+// ConfirmTOTP enables TOTP with the enrolled secret once the user proves their authenticator produces its codes
+func (s *AuthService) ConfirmTOTP(ctx context.Context, userID uuid.UUID, code string) error {
+	wallet, err := s.getWallet(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if wallet.PendingTOTPSecret == "" {
+		return ErrTOTPNotEnrolled
+	}
+
+	secret, err := s.openTOTPSecret(userID, wallet.PendingTOTPSecret)
+	if err != nil {
+		return err
+	}
+	if _, ok := s.matchTOTP(secret, code); !ok {
+		return ErrInvalidTOTP
+	}
+
+	wallet.TOTPSecret = wallet.PendingTOTPSecret
+	wallet.PendingTOTPSecret = ""
+	return s.saveWallet(ctx, wallet)
+}
+
+// DisableTOTP turns the second factor off. Both factors are required, so neither a stolen access
+// token nor a leaked passphrase alone can remove it.
+func (s *AuthService) DisableTOTP(ctx context.Context, userID uuid.UUID, passphrase, code string) error {
+	wallet, err := s.getWallet(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if err := checkPassphrase(wallet, passphrase); err != nil {
+		return ErrPassphraseConfirmation
+	}
+	if wallet.TOTPSecret == "" {
+		return ErrTOTPNotEnrolled
+	}
+	if err := s.verifyTOTP(ctx, wallet, code); err != nil {
+		return err
+	}
+
+	wallet.TOTPSecret = ""
+	wallet.PendingTOTPSecret = ""
+	return s.saveWallet(ctx, wallet)
+}
@@ -0,0 +1,79 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/database"
+)
+
+// Every write of a resource ticks the writing machine's counter in the resource's vector clock.
+// Clients compare the clocks they get in changes-since with the ones they last saw, which tells a
+// concurrent edit from one made on top of theirs where millisecond versions can't.
+
+// serverClockEntry counts writes made by the server itself, such as batch rollbacks
+const serverClockEntry = "server"
+
+// vectorClocksKey returns the hash holding the vector clocks of a user's resources. Clocks name
+// machines, so they are sealed like the change log's machine IDs.
+func vectorClocksKey(userID uuid.UUID) string {
+	return fmt.Sprintf("vclocks:%s", userID.String())
+}
+
+// vectorClockField names a resource in the clock hash; message IDs are only unique per thread
+func vectorClockField(resource, threadID, id string) string {
+	if resource == "message" {
+		return resource + ":" + threadID + ":" + id
+	}
+	return resource + ":" + id
+}
+
+// tickVectorClock counts a write in the vector clock of the resource it changed. A clock that
+// can't be updated is only logged; it must not fail the write.
+func (s *SyncService) tickVectorClock(ctx context.Context, record changeRecord) {
+	field := vectorClockField(record.Resource, record.ThreadID, record.ResourceID)
+	clock, err := s.getVectorClock(ctx, record.UserID, field)
+	if err != nil {
+		fmt.Printf("Warning: failed to read vector clock of %s: %v\n", field, err)
+		return
+	}
+
+	machine := record.MachineID
+	if machine == "" {
+		machine = serverClockEntry
+	}
+	clock[machine]++
+
+	data, err := json.Marshal(clock)
+	if err == nil {
+		var sealed string
+		if sealed, err = s.sealer.Seal(record.UserID, string(data)); err == nil {
+			err = s.db.HSet(ctx, vectorClocksKey(record.UserID), field, sealed)
+		}
+	}
+	if err != nil {
+		fmt.Printf("Warning: failed to update vector clock of %s: %v\n", field, err)
+	}
+}
+
+// getVectorClock returns the vector clock stored under field, empty if the resource has none yet
+func (s *SyncService) getVectorClock(ctx context.Context, userID uuid.UUID, field string) (map[string]int64, error) {
+	clock := map[string]int64{}
+	sealed, err := s.db.HGet(ctx, vectorClocksKey(userID), field)
+	if database.IsNotFound(err) {
+		return clock, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, err := s.sealer.Open(userID, sealed)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(data), &clock); err != nil {
+		return nil, err
+	}
+	return clock, nil
+}
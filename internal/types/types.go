@@ -3,6 +3,8 @@ package types
 import (
 	"encoding/json"
 	"fmt"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -10,10 +12,403 @@ import (
 
 // Wallet represents a user's authentication wallet
 type Wallet struct {
-	UID              uuid.UUID `json:"uid"`
-	Salt             string    `json:"salt"`              // Base64 encoded salt
-	HashedPassphrase string    `json:"hashed_passphrase"` // Base64 encoded Argon2id hash
-	CreatedAt        time.Time `json:"created_at"`
+	UID              uuid.UUID     `json:"uid"`
+	Salt             string        `json:"salt"`                        // Base64 encoded salt
+	HashedPassphrase string        `json:"hashed_passphrase"`           // Base64 encoded Argon2id hash
+	PassphraseParams *Argon2Params `json:"passphrase_params,omitempty"` // nil for hashes made before parameters were recorded
+	CreatedAt        time.Time     `json:"created_at"`
+	LastLoginAt      *time.Time    `json:"last_login_at,omitempty"`
+	Plan             string        `json:"plan,omitempty"`       // empty means DefaultPlan
+	ExpiresAt        *time.Time    `json:"expires_at,omitempty"` // demo wallets only: erased with all data after this time
+
+	// Optional recovery code allowing a passphrase reset, stored like the passphrase
+	RecoverySalt      string        `json:"recovery_salt,omitempty"`       // Base64 encoded salt
+	HashedRecoveryKey string        `json:"hashed_recovery_key,omitempty"` // Base64 encoded Argon2id hash
+	RecoveryParams    *Argon2Params `json:"recovery_params,omitempty"`
+
+	// Optional TOTP second factor; secrets are encrypted with the server's TOTP key
+	TOTPSecret        string `json:"totp_secret,omitempty"`         // enabled once set
+	PendingTOTPSecret string `json:"pending_totp_secret,omitempty"` // enrolled, waiting for a first code
+}
+
+// Argon2Params are the Argon2id cost parameters a secret was hashed with
+type Argon2Params struct {
+	Time    uint32 `json:"t"`
+	Memory  uint32 `json:"m"` // KiB
+	Threads uint8  `json:"p"`
+}
+
+// DefaultPlan is the plan of wallets without one; the instance-wide quotas apply to it
+const DefaultPlan = "default"
+
+// WalletInfo is the non-sensitive account summary for the client's account screen
+type WalletInfo struct {
+	UID         uuid.UUID      `json:"uid"`
+	CreatedAt   time.Time      `json:"created_at"`
+	LastLoginAt *time.Time     `json:"last_login_at,omitempty"`
+	ExpiresAt   *time.Time     `json:"expires_at,omitempty"` // demo wallets only
+	Machines    int            `json:"machines"`             // active registered machines
+	Usage       Usage          `json:"usage"`
+	Plan        string         `json:"plan"`
+	Security    WalletSecurity `json:"security"`
+}
+
+// DemoWallet is returned once when a demo wallet is created; the passphrase is not stored in plain text
+type DemoWallet struct {
+	UID        uuid.UUID   `json:"uid"`
+	Passphrase string      `json:"passphrase"`
+	CreatedAt  time.Time   `json:"created_at"`
+	ExpiresAt  time.Time   `json:"expires_at"`
+	Tokens     *AuthTokens `json:"tokens"`
+}
+
+// TOTPEnrollment is returned once when TOTP is enrolled, to be added to an authenticator app
+type TOTPEnrollment struct {
+	Secret string `json:"secret"` // base32, for manual entry
+	URI    string `json:"uri"`    // otpauth:// URI, usually shown as a QR code
+}
+
+// WalletSecurity reports which optional security features a wallet has set up
+type WalletSecurity struct {
+	TOTPEnabled    bool `json:"totp_enabled"`
+	RecoveryKeySet bool `json:"recovery_key_set"`
+}
+
+// DeletionReceipt confirms the erasure of a wallet and everything synced under it
+type DeletionReceipt struct {
+	ReceiptID   uuid.UUID `json:"receipt_id"`
+	UserID      uuid.UUID `json:"user_id"`
+	DeletedAt   time.Time `json:"deleted_at"`
+	Threads     int64     `json:"threads"`
+	Messages    int64     `json:"messages"`
+	Memories    int64     `json:"memories"`
+	Attachments int       `json:"attachments"`
+	Machines    int       `json:"machines"`
+}
+
+// Final export modes of a wallet deletion
+const (
+	ExportNone   = ""       // no export
+	ExportInline = "inline" // returned with the deletion receipt
+	ExportStaged = "staged" // kept for download for a day after the deletion
+)
+
+// WalletDeletionRequest erases the authenticated wallet. The loss of all synced data has to be
+// acknowledged explicitly, and a final export of it can be requested.
+type WalletDeletionRequest struct {
+	Passphrase          string `json:"passphrase" binding:"required"`
+	AcknowledgeDataLoss bool   `json:"acknowledge_data_loss"`
+	Export              string `json:"export"` // "inline", "staged" or empty for none
+}
+
+// WalletDeletion is the receipt of a wallet deletion with the final export, if one was requested
+type WalletDeletion struct {
+	*DeletionReceipt
+	Export          *AccountExport `json:"export,omitempty"`            // inline exports
+	ExportURL       string         `json:"export_url,omitempty"`        // staged exports: download path, no token needed
+	ExportExpiresAt *time.Time     `json:"export_expires_at,omitempty"` // staged exports
+}
+
+// AccountExport is a copy of everything synced under a wallet, as stored: payloads the client
+// encrypted stay encrypted
+type AccountExport struct {
+	UID        uuid.UUID        `json:"uid"`
+	ExportedAt time.Time        `json:"exported_at"`
+	Threads    []ExportedThread `json:"threads"`
+	SettingsBundle
+	Memories []Memory `json:"memories"`
+	Folders  []Folder `json:"folders"`
+}
+
+// ExportedThread is a thread with its messages
+type ExportedThread struct {
+	Thread
+	Messages []Message `json:"messages"`
+}
+
+// Import item statuses
+const (
+	ImportCreated   = "created"
+	ImportUpdated   = "updated"
+	ImportUnchanged = "unchanged" // the stored copy is identical
+	ImportConflict  = "conflict"  // the stored copy is newer or differs; it was kept
+	ImportFailed    = "failed"
+)
+
+// ImportItemResult reports what an import did with one item of the document
+type ImportItemResult struct {
+	Resource string `json:"resource"` // "thread", "message", a settings resource, "memory" or "folder"
+	ID       string `json:"id,omitempty"`
+	ThreadID string `json:"thread_id,omitempty"` // messages
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+}
+
+// ImportSummary counts the items of an import by status
+type ImportSummary struct {
+	Created   int   `json:"created"`
+	Updated   int   `json:"updated"`
+	Unchanged int   `json:"unchanged"`
+	Conflicts int   `json:"conflicts"`
+	Failed    int   `json:"failed"`
+	Bytes     int64 `json:"bytes"` // of the document read
+}
+
+// Add counts result
+func (s *ImportSummary) Add(result ImportItemResult) {
+	switch result.Status {
+	case ImportCreated:
+		s.Created++
+	case ImportUpdated:
+		s.Updated++
+	case ImportUnchanged:
+		s.Unchanged++
+	case ImportConflict:
+		s.Conflicts++
+	default:
+		s.Failed++
+	}
+}
+
+// ImportEvent is one line of an import's NDJSON progress stream: an item result while the
+// document is read, then the summary, or an error if the document couldn't be read to the end
+type ImportEvent struct {
+	Item    *ImportItemResult `json:"item,omitempty"`
+	Summary *ImportSummary    `json:"summary,omitempty"`
+	Error   string            `json:"error,omitempty"`
+}
+
+// Conflict strategies: what becomes of a write whose version isn't newer than the stored document's
+const (
+	ConflictReject         = "reject"           // the write is refused with 409 and the stored document
+	ConflictLastWriterWins = "last_writer_wins" // the write replaces the stored document, and the response says so
+)
+
+// ConflictStrategies are the conflict strategies an instance applies to thread and settings writes
+type ConflictStrategies struct {
+	Threads  string `json:"threads"`
+	Settings string `json:"settings"` // provider instances, disabled models, advanced settings and tool servers
+}
+
+// ConflictResolution reports a conflicting write that was applied under last writer wins: it
+// replaced the stored document at ServerVersion
+type ConflictResolution struct {
+	Strategy      string `json:"strategy"`
+	ServerVersion int64  `json:"server_version"`
+	ClientVersion int64  `json:"client_version"`
+}
+
+// Kinds of batch operations
+const (
+	BatchUpsertThread  = "upsert_thread"
+	BatchCreateMessage = "create_message"
+	BatchDeleteThread  = "delete_thread"
+	BatchDeleteMessage = "delete_message"
+)
+
+// BatchOperation is one write of a sync batch. Thread upserts carry Thread, with its ID and version;
+// message creates carry ThreadID and Message; deletes carry ThreadID and, for messages, MessageID.
+type BatchOperation struct {
+	Op        string   `json:"op"`
+	Thread    *Thread  `json:"thread,omitempty"`
+	ThreadID  string   `json:"thread_id,omitempty"`
+	Message   *Message `json:"message,omitempty"`
+	MessageID string   `json:"message_id,omitempty"`
+}
+
+// BatchRequest applies Operations in order, all of them or none
+type BatchRequest struct {
+	MachineID  string           `json:"machine_id,omitempty"`
+	Operations []BatchOperation `json:"operations" binding:"required"`
+}
+
+// BatchOperationResult reports what a batch did with one operation
+type BatchOperationResult struct {
+	Index    int    `json:"index"`
+	Op       string `json:"op"`
+	ID       string `json:"id"` // of the thread or message; generated for messages created without one
+	ThreadID string `json:"thread_id,omitempty"`
+	Created  bool   `json:"created"` // false for updates, deletes and retried message creates
+
+	Conflict *ConflictResolution `json:"conflict,omitempty"` // thread upserts that overwrote a newer stored thread
+}
+
+// BatchResult lists the results of an applied batch, in operation order. A dry run lists what
+// applying the batch would do, and kept nothing.
+type BatchResult struct {
+	Results []BatchOperationResult `json:"results"`
+	DryRun  bool                   `json:"dry_run,omitempty"`
+}
+
+// AccountMergeRequest merges another wallet of the user into the authenticated one
+type AccountMergeRequest struct {
+	SourceUID        string `json:"source_uid" binding:"required"`
+	SourcePassphrase string `json:"source_passphrase" binding:"required"`
+	ConflictPolicy   string `json:"conflict_policy"` // "keep_target" (default), "keep_source" or "newest"
+	DryRun           bool   `json:"dry_run"`         // only report what the merge would do
+}
+
+// MergeReport describes what an account merge moved, or would move on a dry run
+type MergeReport struct {
+	SourceUID      uuid.UUID        `json:"source_uid"`
+	TargetUID      uuid.UUID        `json:"target_uid"`
+	ConflictPolicy string           `json:"conflict_policy"`
+	DryRun         bool             `json:"dry_run"`
+	Threads        int              `json:"threads"`     // threads only the source held
+	Messages       int              `json:"messages"`    // messages of all the source's threads
+	Settings       int              `json:"settings"`    // settings documents only the source held
+	Memories       int              `json:"memories"`    // memories only the source held
+	Folders        int              `json:"folders"`     // folders only the source held
+	Attachments    int              `json:"attachments"` // attachments only the source held
+	Conflicts      []MergeConflict  `json:"conflicts"`
+	Usage          Usage            `json:"usage"` // usage of the target after the merge
+	Warnings       []QuotaWarning   `json:"warnings"`
+	MergedAt       *time.Time       `json:"merged_at,omitempty"`
+	Receipt        *DeletionReceipt `json:"receipt,omitempty"` // erasure of the source wallet
+}
+
+// MergeConflict is a record both wallets held, and whose copy the merge kept
+type MergeConflict struct {
+	Resource string `json:"resource"`     // "thread", "memory", "folder", "attachment" or a settings resource
+	ID       string `json:"id,omitempty"` // empty for settings
+	Kept     string `json:"kept"`         // "source" or "target"
+}
+
+// PurgeRequest permanently removes the user's soft-deleted data older than an age
+type PurgeRequest struct {
+	MachineID string `json:"machine_id,omitempty"`
+	OlderThan string `json:"older_than" binding:"required"` // Go duration, e.g. "720h"
+}
+
+// PurgeReport counts what a purge permanently removed
+type PurgeReport struct {
+	OlderThan          string    `json:"older_than"`
+	Cutoff             time.Time `json:"cutoff"`              // data deleted or archived before it was purged
+	ThreadTombstones   int       `json:"thread_tombstones"`   // deletions of threads, forgotten
+	ArchivedThreads    int       `json:"archived_threads"`    // archived threads last written before the cutoff, deleted
+	Messages           int       `json:"messages"`            // messages of the deleted archived threads
+	MemoryTombstones   int       `json:"memory_tombstones"`   // deleted memories, removed
+	FolderTombstones   int       `json:"folder_tombstones"`   // deleted folders, removed
+	SettingsTombstones int       `json:"settings_tombstones"` // deleted settings documents, removed
+	KeysFreed          int       `json:"keys_freed"`          // stored records removed, all of the above
+	PurgedAt           time.Time `json:"purged_at"`
+}
+
+// WalletRotationRequest moves the authenticated wallet to a new UID, confirmed with its passphrase
+type WalletRotationRequest struct {
+	Passphrase string `json:"passphrase" binding:"required"`
+}
+
+// WalletRotation is the result of moving a wallet to a new UID. The previous UID no longer exists.
+type WalletRotation struct {
+	UID         uuid.UUID        `json:"uid"`
+	PreviousUID uuid.UUID        `json:"previous_uid"`
+	Tokens      *AuthTokens      `json:"tokens"` // for the device that requested the rotation
+	Threads     int              `json:"threads"`
+	Messages    int              `json:"messages"`
+	Settings    int              `json:"settings"`
+	Memories    int              `json:"memories"`
+	Folders     int              `json:"folders"`
+	Attachments int              `json:"attachments"`
+	Machines    int              `json:"machines"`
+	RotatedAt   time.Time        `json:"rotated_at"`
+	Receipt     *DeletionReceipt `json:"receipt"` // erasure of the previous UID
+}
+
+// Passkey is a WebAuthn credential registered to a wallet for passphrase-less login
+type Passkey struct {
+	ID         string     `json:"id"` // base64url credential ID
+	Name       string     `json:"name"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// PasskeyCreationOptions are the WebAuthn PublicKeyCredentialCreationOptions of a passkey registration.
+// Binary values are base64url encoded, as in the WebAuthn JSON serialization.
+type PasskeyCreationOptions struct {
+	Challenge              string                        `json:"challenge"`
+	RP                     PasskeyRelyingParty           `json:"rp"`
+	User                   PasskeyUser                   `json:"user"`
+	PubKeyCredParams       []PasskeyCredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                         `json:"timeout"` // milliseconds
+	ExcludeCredentials     []PasskeyDescriptor           `json:"excludeCredentials"`
+	AuthenticatorSelection PasskeyAuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                        `json:"attestation"`
+}
+
+// PasskeyRequestOptions are the WebAuthn PublicKeyCredentialRequestOptions of a passkey login
+type PasskeyRequestOptions struct {
+	Challenge        string              `json:"challenge"`
+	RPID             string              `json:"rpId"`
+	Timeout          int64               `json:"timeout"`                    // milliseconds
+	AllowCredentials []PasskeyDescriptor `json:"allowCredentials,omitempty"` // empty lets the user pick any passkey of this site
+	UserVerification string              `json:"userVerification"`
+}
+
+type PasskeyRelyingParty struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type PasskeyUser struct {
+	ID          string `json:"id"` // base64url of the wallet UID's bytes
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type PasskeyCredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int64  `json:"alg"`
+}
+
+type PasskeyDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+type PasskeyAuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// PasskeyCredential is the PublicKeyCredential returned by the browser, binary values base64url encoded
+type PasskeyCredential struct {
+	ID       string                    `json:"id" binding:"required"`
+	Type     string                    `json:"type"`
+	Response PasskeyCredentialResponse `json:"response"`
+}
+
+// PasskeyCredentialResponse is the authenticator's response to a registration or login
+type PasskeyCredentialResponse struct {
+	ClientDataJSON    string `json:"clientDataJSON"`
+	AttestationObject string `json:"attestationObject,omitempty"` // registration
+	AuthenticatorData string `json:"authenticatorData,omitempty"` // login
+	Signature         string `json:"signature,omitempty"`         // login
+	UserHandle        string `json:"userHandle,omitempty"`        // login with a discoverable passkey
+}
+
+// OpaqueRegistrationResponse is the server's answer to an OPAQUE registration request. Binary
+// values are base64 encoded, like in every OPAQUE message.
+type OpaqueRegistrationResponse struct {
+	RegistrationResponse []byte `json:"registration_response"` // evaluated element and server public key
+	ServerPublicKey      []byte `json:"server_public_key"`
+}
+
+// OpaqueSignupChallenge starts creating a wallet with OPAQUE: the UID the server picked and its
+// answer to the client's registration request for it
+type OpaqueSignupChallenge struct {
+	UserID               uuid.UUID `json:"user_id"`
+	RegistrationResponse []byte    `json:"registration_response"`
+	ServerPublicKey      []byte    `json:"server_public_key"`
+	ExpiresAt            time.Time `json:"expires_at"` // the wallet has to be finished by then
+}
+
+// OpaqueLoginChallenge is the server's KE2 answering a client's KE1, and the ID the client's KE3
+// has to be sent back with
+type OpaqueLoginChallenge struct {
+	LoginID   string    `json:"login_id"`
+	KE2       []byte    `json:"ke2"`
+	ExpiresAt time.Time `json:"expires_at"`
 }
 
 // AuthTokens represents JWT tokens
@@ -23,6 +418,113 @@ type AuthTokens struct {
 	ExpiresAt    time.Time `json:"expires_at"`
 }
 
+// TokenClaims represents the validated claims of a JWT
+type TokenClaims struct {
+	UserID       uuid.UUID
+	Type         string    // "access", "refresh", "guest" or "scoped"
+	TokenID      string    // jti
+	ExpiresAt    time.Time // exp
+	MachineID    string    // machine the token was issued to, if the client sent one at login
+	SessionID    string    // refresh tokens only: login session the token belongs to
+	Scopes       []string  // scoped tokens only: scopes the token grants
+	Resources    []string  // guest tokens only: resources the token may read
+	MetadataOnly bool      // guest tokens only: payload bodies are redacted
+}
+
+// AdminClaims are the verified claims of an admin token. Admin tokens are issued to server
+// operators, not wallets, and only reach the admin API.
+type AdminClaims struct {
+	Name      string    `json:"name"`     // operator the token was issued to
+	TokenID   string    `json:"token_id"` // jti
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Token scopes. Tokens issued at login grant all of them, scoped tokens the ones they were minted with.
+const (
+	ScopeRead  = "read"  // read synced data
+	ScopeWrite = "write" // change synced data
+	ScopeAdmin = "admin" // manage the wallet: machines, sessions, tokens, recovery and deletion
+)
+
+// HasScope reports whether the token grants scope. Access tokens grant every scope and guest
+// tokens only read; tokens of any other type, refresh tokens among them, grant none.
+func (c *TokenClaims) HasScope(scope string) bool {
+	switch c.Type {
+	case "access":
+		return true
+	case "guest":
+		return scope == ScopeRead
+	case "scoped":
+		return slices.Contains(c.Scopes, scope)
+	}
+	return false
+}
+
+// JWK is a public token verification key (RFC 7517)
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`   // RSA modulus
+	E         string `json:"e,omitempty"`   // RSA exponent
+	Curve     string `json:"crv,omitempty"` // OKP curve
+	X         string `json:"x,omitempty"`   // OKP public key
+}
+
+// JWKS is the set of keys tokens of this instance can be verified with
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// GuestTokenRequest represents a request to mint a read-only guest token
+type GuestTokenRequest struct {
+	Resources       []string `json:"resources"`        // subset of "threads", "messages", "settings", "changes"; empty means all
+	IncludePayloads bool     `json:"include_payloads"` // include encrypted payload bodies (metadata only by default)
+	TTLMinutes      int      `json:"ttl_minutes"`
+}
+
+// GuestTokenInfo describes a minted guest token for the audit trail. Views and LastAccessAt tell
+// how a shared token was used; who used it is not recorded.
+type GuestTokenInfo struct {
+	TokenID      string     `json:"token_id"`
+	Resources    []string   `json:"resources"`
+	MetadataOnly bool       `json:"metadata_only"`
+	IssuedAt     time.Time  `json:"issued_at"`
+	ExpiresAt    time.Time  `json:"expires_at"`
+	Views        int64      `json:"views"`                    // requests made with the token
+	LastAccessAt *time.Time `json:"last_access_at,omitempty"` // time of the last one
+}
+
+// GuestToken is returned to the user who minted it
+type GuestToken struct {
+	Token string `json:"token"`
+	GuestTokenInfo
+}
+
+// ScopedTokenRequest represents a request to mint an access token limited to some scopes
+type ScopedTokenRequest struct {
+	Name       string   `json:"name"`   // what the token is for, e.g. "dashboard"
+	Scopes     []string `json:"scopes"` // subset of "read", "write", "admin"
+	TTLMinutes int      `json:"ttl_minutes"`
+}
+
+// ScopedTokenInfo describes a minted scoped token
+type ScopedTokenInfo struct {
+	TokenID   string    `json:"token_id"`
+	Name      string    `json:"name"`
+	Scopes    []string  `json:"scopes"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ScopedToken is returned to the user who minted it
+type ScopedToken struct {
+	Token string `json:"token"`
+	ScopedTokenInfo
+}
+
 // VersionedData represents data with versioning information
 type VersionedData struct {
 	ID        uuid.UUID   `json:"id"`
@@ -49,12 +551,36 @@ type Thread struct {
 	WebSearchContextSize string                 `json:"webSearchContextSize"`      // CLIENT-ENCRYPTED STRING (originally int)
 	Settings             map[string]interface{} `json:"settings"`                  // CLIENT-ENCRYPTED JSON VALUES
 	Version              int64                  `json:"version"`
-	UpdatedAt            string                 `json:"updated_at"` // CLIENT-ENCRYPTED STRING (originally time.Time)
-	CreatedAt            string                 `json:"created_at"` // CLIENT-ENCRYPTED STRING (originally time.Time)
+	UpdatedAt            string                 `json:"updated_at"`                // CLIENT-ENCRYPTED STRING (originally time.Time)
+	CreatedAt            string                 `json:"created_at"`                // CLIENT-ENCRYPTED STRING (originally time.Time)
+	EncV                 int                    `json:"enc_v"`                     // Encryption envelope version used by the client
+	ArchivedRemote       bool                   `json:"archived_remote,omitempty"` // Server-managed: messages are held in the archival store
+	Archived             bool                   `json:"archived,omitempty"`        // Server-managed: hidden from thread listings; set with PUT /threads/:id/archive
+}
+
+// ThreadTombstone records a thread's deletion, so devices that still have it learn it was deleted
+// and re-uploads of it are refused unless they are newer than the deletion
+type ThreadTombstone struct {
+	ThreadID  uuid.UUID `json:"thread_id"`
+	Version   int64     `json:"version"` // the thread's version as of its deletion; newer writes revive it
+	MachineID string    `json:"machine_id,omitempty"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// ThreadMetaEntry records the (client-encrypted) model and settings tokens a thread used from a given version on
+type ThreadMetaEntry struct {
+	Version              int64                  `json:"version"`
+	Timestamp            time.Time              `json:"timestamp"` // server time the change was accepted
+	MachineID            string                 `json:"machine_id,omitempty"`
+	ProviderInstanceId   string                 `json:"providerInstanceId"`   // CLIENT-ENCRYPTED STRING
+	Model                string                 `json:"model"`                // CLIENT-ENCRYPTED STRING
+	WebSearchEnabled     string                 `json:"webSearchEnabled"`     // CLIENT-ENCRYPTED STRING
+	WebSearchContextSize string                 `json:"webSearchContextSize"` // CLIENT-ENCRYPTED STRING
+	Settings             map[string]interface{} `json:"settings"`             // CLIENT-ENCRYPTED JSON VALUES
 }
 
 // Message represents a chat message with client-encrypted data
-// ALL FIELDS EXCEPT ID ARE CLIENT-ENCRYPTED STRINGS
+// ALL FIELDS EXCEPT ID, ENC_V AND ATTACHMENT_REFS ARE CLIENT-ENCRYPTED STRINGS
 type Message struct {
 	ID                   string `json:"id" validate:"required"`
 	ThreadID             string `json:"threadId" validate:"required"`   // CLIENT-ENCRYPTED STRING (originally uuid.UUID)
@@ -71,13 +597,19 @@ type Message struct {
 	Error                string `json:"error,omitempty"`                // CLIENT-ENCRYPTED STRING (originally *ChatError)
 	WebSearchEnabled     string `json:"webSearchEnabled,omitempty"`     // CLIENT-ENCRYPTED STRING (originally *bool)
 	WebSearchContextSize string `json:"webSearchContextSize,omitempty"` // CLIENT-ENCRYPTED STRING
+	EncV                 int    `json:"enc_v"`                          // Encryption envelope version used by the client
+
+	// IDs of the attachments the message references, in plaintext so unreferenced attachments can be collected
+	AttachmentRefs []uuid.UUID `json:"attachment_refs,omitempty"`
 }
 
 // ProviderInstances represents user's AI provider configurations
 type ProviderInstances struct {
 	UserID    uuid.UUID              `json:"user_id" validate:"required"`
 	Providers map[string]interface{} `json:"providers" validate:"required"` // CLIENT-ENCRYPTED JSON VALUES
+	EncV      int                    `json:"enc_v"`                         // Encryption envelope version used by the client
 	Version   int64                  `json:"version"`
+	Deleted   bool                   `json:"deleted,omitempty"` // tombstone: the user cleared the document on some device
 	UpdatedAt time.Time              `json:"updated_at"`
 	CreatedAt time.Time              `json:"created_at"`
 }
@@ -86,7 +618,9 @@ type ProviderInstances struct {
 type DisabledModels struct {
 	UserID    uuid.UUID         `json:"user_id" validate:"required"`
 	Models    map[string]string `json:"models" validate:"required"` // CLIENT-ENCRYPTED record mapping provider instance ID to encrypted string
+	EncV      int               `json:"enc_v"`                      // Encryption envelope version used by the client
 	Version   int64             `json:"version"`
+	Deleted   bool              `json:"deleted,omitempty"` // tombstone: the user cleared the document on some device
 	UpdatedAt time.Time         `json:"updated_at"`
 	CreatedAt time.Time         `json:"created_at"`
 }
@@ -95,69 +629,859 @@ type DisabledModels struct {
 type AdvancedSettings struct {
 	UserID    uuid.UUID              `json:"user_id" validate:"required"`
 	Settings  map[string]interface{} `json:"settings" validate:"required"` // CLIENT-ENCRYPTED JSON VALUES
+	EncV      int                    `json:"enc_v"`                        // Encryption envelope version used by the client
 	Version   int64                  `json:"version"`
+	Deleted   bool                   `json:"deleted,omitempty"` // tombstone: the user cleared the document on some device
 	UpdatedAt time.Time              `json:"updated_at"`
 	CreatedAt time.Time              `json:"created_at"`
 }
 
+// ToolServers represents user's MCP/tool server connection configurations
+type ToolServers struct {
+	UserID    uuid.UUID              `json:"user_id" validate:"required"`
+	Servers   map[string]interface{} `json:"servers" validate:"required"` // CLIENT-ENCRYPTED JSON VALUES: endpoints, credentials
+	EncV      int                    `json:"enc_v"`                       // Encryption envelope version used by the client
+	Version   int64                  `json:"version"`
+	Deleted   bool                   `json:"deleted,omitempty"` // tombstone: the user cleared their tool servers on some device
+	UpdatedAt time.Time              `json:"updated_at"`
+	CreatedAt time.Time              `json:"created_at"`
+}
+
+// PromptLibrary represents user's saved prompts and personas
+type PromptLibrary struct {
+	UserID    uuid.UUID              `json:"user_id" validate:"required"`
+	Prompts   map[string]interface{} `json:"prompts" validate:"required"` // CLIENT-ENCRYPTED JSON VALUES keyed by prompt or persona ID
+	EncV      int                    `json:"enc_v"`                       // Encryption envelope version used by the client
+	Version   int64                  `json:"version"`
+	Deleted   bool                   `json:"deleted,omitempty"` // tombstone: the user cleared the library on some device
+	UpdatedAt time.Time              `json:"updated_at"`
+	CreatedAt time.Time              `json:"created_at"`
+}
+
+// Tags represents user's thread tags: the tags themselves, and which threads carry them
+type Tags struct {
+	UserID      uuid.UUID              `json:"user_id" validate:"required"`
+	Definitions map[string]interface{} `json:"definitions" validate:"required"` // CLIENT-ENCRYPTED JSON VALUES keyed by tag ID
+	Assignments map[string][]string    `json:"assignments"`                     // tag IDs keyed by thread ID
+	EncV        int                    `json:"enc_v"`                           // Encryption envelope version used by the client
+	Version     int64                  `json:"version"`
+	Deleted     bool                   `json:"deleted,omitempty"` // tombstone: the user cleared their tags on some device
+	UpdatedAt   time.Time              `json:"updated_at"`
+	CreatedAt   time.Time              `json:"created_at"`
+}
+
+// FavoriteModels represents user's favorite and pinned AI models, complementing DisabledModels
+type FavoriteModels struct {
+	UserID    uuid.UUID         `json:"user_id" validate:"required"`
+	Models    map[string]string `json:"models" validate:"required"` // CLIENT-ENCRYPTED record mapping provider instance ID to encrypted string
+	Order     string            `json:"order"`                      // CLIENT-ENCRYPTED STRING: the order pickers show the models in
+	EncV      int               `json:"enc_v"`                      // Encryption envelope version used by the client
+	Version   int64             `json:"version"`
+	Deleted   bool              `json:"deleted,omitempty"` // tombstone: the user cleared the document on some device
+	UpdatedAt time.Time         `json:"updated_at"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// CustomInstructions represents user's custom instructions: what the assistant should know about
+// them and how it should respond, applied to every thread
+type CustomInstructions struct {
+	UserID    uuid.UUID `json:"user_id" validate:"required"`
+	Content   string    `json:"content"` // CLIENT-ENCRYPTED STRING
+	EncV      int       `json:"enc_v"`   // Encryption envelope version used by the client
+	Version   int64     `json:"version"`
+	Deleted   bool      `json:"deleted,omitempty"` // tombstone: the user cleared their instructions on some device
+	UpdatedAt time.Time `json:"updated_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Profile represents user's profile: how they appear in clients and their UI preferences
+type Profile struct {
+	UserID      uuid.UUID              `json:"user_id" validate:"required"`
+	DisplayName string                 `json:"display_name"` // CLIENT-ENCRYPTED STRING
+	Avatar      string                 `json:"avatar"`       // CLIENT-ENCRYPTED STRING (an attachment ID or URL)
+	Preferences map[string]interface{} `json:"preferences"`  // CLIENT-ENCRYPTED JSON VALUES
+	EncV        int                    `json:"enc_v"`        // Encryption envelope version used by the client
+	Version     int64                  `json:"version"`
+	UpdatedAt   time.Time              `json:"updated_at"`
+	CreatedAt   time.Time              `json:"created_at"`
+}
+
+// SettingsDocument is a per-user settings document, so the settings endpoints, imports and exports
+// can handle all of them alike
+type SettingsDocument interface {
+	// Fields points to the fields every settings document has
+	Fields() SettingsFields
+	// Redact drops the client-encrypted payload, for metadata-only access
+	Redact()
+}
+
+// SettingsFields points to the fields of a settings document the server manages. Deleted is nil
+// for documents that can't be deleted.
+type SettingsFields struct {
+	UserID    *uuid.UUID
+	EncV      *int
+	Version   *int64
+	Deleted   *bool
+	UpdatedAt *time.Time
+}
+
+// SettingsBundle holds one of each settings document, for responses carrying all of them
+type SettingsBundle struct {
+	ProviderInstances  *ProviderInstances  `json:"provider_instances,omitempty"`
+	DisabledModels     *DisabledModels     `json:"disabled_models,omitempty"`
+	AdvancedSettings   *AdvancedSettings   `json:"advanced_settings,omitempty"`
+	ToolServers        *ToolServers        `json:"tool_servers,omitempty"`
+	PromptLibrary      *PromptLibrary      `json:"prompt_library,omitempty"`
+	Tags               *Tags               `json:"tags,omitempty"`
+	CustomInstructions *CustomInstructions `json:"custom_instructions,omitempty"`
+	FavoriteModels     *FavoriteModels     `json:"favorite_models,omitempty"`
+	Profile            *Profile            `json:"profile,omitempty"`
+}
+
+// Memory represents a single long-term memory / note entry, scoped to the account
+type Memory struct {
+	ID        uuid.UUID `json:"id" validate:"required"`
+	Content   string    `json:"content"` // CLIENT-ENCRYPTED STRING
+	EncV      int       `json:"enc_v"`
+	Version   int64     `json:"version"`
+	Deleted   bool      `json:"deleted,omitempty"` // tombstone, kept so deletions propagate to other devices
+	UpdatedAt time.Time `json:"updated_at"`        // server time of the last change
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Folder groups threads into a project. Only its name is encrypted: the server keeps folders in
+// order and knows which threads they hold.
+type Folder struct {
+	ID        uuid.UUID   `json:"id" validate:"required"`
+	Name      string      `json:"name"`       // CLIENT-ENCRYPTED STRING
+	Position  int64       `json:"position"`   // folders are listed by position, lowest first
+	ThreadIDs []uuid.UUID `json:"thread_ids"` // threads in the folder, in the client's order
+	EncV      int         `json:"enc_v"`
+	Version   int64       `json:"version"`
+	Deleted   bool        `json:"deleted,omitempty"` // tombstone, kept so deletions propagate to other devices
+	UpdatedAt time.Time   `json:"updated_at"`        // server time of the last change
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// Draft is a message being written in a thread, synced so it can be finished on another device.
+// Drafts are last write wins and expire unless written again.
+type Draft struct {
+	ThreadID       uuid.UUID   `json:"thread_id"`
+	Content        string      `json:"content"`                   // CLIENT-ENCRYPTED STRING
+	Attachments    string      `json:"attachments,omitempty"`     // CLIENT-ENCRYPTED STRING: attachments being added
+	AttachmentRefs []uuid.UUID `json:"attachment_refs,omitempty"` // uploaded attachments the draft uses, kept from collection
+	EncV           int         `json:"enc_v"`
+	UpdatedAt      time.Time   `json:"updated_at"` // server time of the last write
+	ExpiresAt      time.Time   `json:"expires_at"`
+}
+
+// ReadState is how far the user has read a thread, synced so unread indicators agree across
+// devices. Devices write it out of order, so the state set last on the client wins, by ReadAt.
+type ReadState struct {
+	ThreadID  uuid.UUID `json:"thread_id"`
+	LastRead  string    `json:"last_read"` // CLIENT-ENCRYPTED STRING: marker of the last message read
+	Unread    bool      `json:"unread"`
+	EncV      int       `json:"enc_v"`
+	ReadAt    time.Time `json:"read_at"`    // client time the state was set, the latest one is kept
+	UpdatedAt time.Time `json:"updated_at"` // server time of the last write
+}
+
+// Attachment describes a client-encrypted blob (an image or file) referenced from
+// Message.AttachmentIds. The blob itself is stored outside the database and served as is.
+type Attachment struct {
+	ID        uuid.UUID `json:"id"`
+	Size      int64     `json:"size"`   // bytes of the encrypted blob
+	SHA256    string    `json:"sha256"` // hex digest of the encrypted blob
+	EncV      int       `json:"enc_v"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
 // ChangeOperation represents a single change operation for sync
 type ChangeOperation struct {
-	Resource  string      `json:"resource"`       // e.g., "thread", "message", "provider_instances", etc.
-	Operation string      `json:"operation"`      // "add", "update", "delete"
-	ID        string      `json:"id"`             // ID of the resource (string to accommodate both UUIDs and message IDs)
-	MachineID string      `json:"machine_id"`     // UUIDv7 of the client that made the change
-	Data      interface{} `json:"data,omitempty"` // full object for add/update
-	Timestamp time.Time   `json:"timestamp"`      // when the change occurred
+	Resource  string           `json:"resource"`        // e.g., "thread", "message", "provider_instances", etc.
+	Operation string           `json:"operation"`       // "add", "update", "delete", or "migrate" for the instance
+	ID        string           `json:"id"`              // ID of the resource (string to accommodate both UUIDs and message IDs)
+	MachineID string           `json:"machine_id"`      // UUIDv7 of the client that made the change
+	Data      interface{}      `json:"data,omitempty"`  // full object for add/update
+	Timestamp time.Time        `json:"timestamp"`       // when the change occurred
+	Clock     map[string]int64 `json:"clock,omitempty"` // vector clock of the resource: writes counted per machine ID
 }
 
 // ChangesSinceResponse represents response data for the changes-since endpoint
 // It includes full data on initial sync or operations for incremental updates
 type ChangesSinceResponse struct {
-	FullThreads       []Thread           `json:"threads,omitempty"`            // full thread list on initial sync
-	FullMessages      []Message          `json:"messages,omitempty"`           // full message list on initial sync
-	ProviderInstances *ProviderInstances `json:"provider_instances,omitempty"` // full settings on initial sync
-	DisabledModels    *DisabledModels    `json:"disabled_models,omitempty"`    // full settings on initial sync
-	AdvancedSettings  *AdvancedSettings  `json:"advanced_settings,omitempty"`  // full settings on initial sync
-	Operations        []ChangeOperation  `json:"operations,omitempty"`         // incremental operations since last sync
-	SyncTimestamp     time.Time          `json:"sync_timestamp"`               // server timestamp for this sync
+	FullThreads       []Thread          `json:"threads,omitempty"`  // full thread list on initial sync
+	FullMessages      []Message         `json:"messages,omitempty"` // full message list on initial sync
+	SettingsBundle                      // full settings on initial sync
+	FullMemories      []Memory          `json:"memories,omitempty"`           // full memory list on initial sync
+	FullFolders       []Folder          `json:"folders,omitempty"`            // full folder list on initial sync
+	FullDrafts        []Draft           `json:"drafts,omitempty"`             // unexpired drafts on initial sync
+	FullReadStates    []ReadState       `json:"read_states,omitempty"`        // all read states on initial sync
+	SettingsRevisions SettingsRevisions `json:"settings_revisions,omitempty"` // last change of each settings resource
+	Operations        []ChangeOperation `json:"operations,omitempty"`         // incremental operations since last sync
+	CorruptedCount    int               `json:"corrupted_count,omitempty"`    // unreadable changes skipped in this sync
+	SyncCursor        string            `json:"sync_cursor"`                  // opaque cursor the next changes-since request resumes after
+	SyncTimestamp     time.Time         `json:"sync_timestamp"`               // time of the last change read; deprecated cursor of older clients
+}
+
+// Types of the events pushed over the realtime sync socket
+const (
+	SyncEventChange = "change" // Operation was recorded by another machine
+	SyncEventResync = "resync" // events were lost; run changes-since from the last sync_timestamp, then reconnect
+)
+
+// SyncEvent is a message pushed over the realtime sync socket
+type SyncEvent struct {
+	Type          string           `json:"type"`
+	Operation     *ChangeOperation `json:"operation,omitempty"`
+	SyncCursor    string           `json:"sync_cursor,omitempty"` // changes-since cursor resuming after this event
+	SyncTimestamp time.Time        `json:"sync_timestamp"`        // time of the change, for clients still syncing by timestamp
+}
+
+// SyncCheckpoint is the last changes-since cursor a machine applied, kept by the server so a
+// reinstalled client can resume from it instead of running a full sync
+type SyncCheckpoint struct {
+	MachineID     string    `json:"machine_id"`
+	SyncCursor    string    `json:"sync_cursor,omitempty"` // sync_cursor of the last changes-since response the machine applied
+	SyncTimestamp time.Time `json:"sync_timestamp"`        // its sync_timestamp, all older clients send
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// SyncCheckpointRequest stores a machine's sync checkpoint, given as a sync cursor or timestamp
+type SyncCheckpointRequest struct {
+	MachineID     string    `json:"machine_id"`
+	SyncCursor    string    `json:"sync_cursor,omitempty"`
+	SyncTimestamp time.Time `json:"sync_timestamp"`
+}
+
+// ProtocolVersion is the version of the sync protocol this server build speaks
+const ProtocolVersion = "v1"
+
+// ServerFeatures lists the optional parts of the sync API this server build implements, so clients
+// can adapt to the server instead of assuming the newest build
+type ServerFeatures struct {
+	Batch          bool     `json:"batch"`         // POST /sync/batch
+	BatchDryRun    bool     `json:"batch_dry_run"` // ?dry_run=true on batches
+	WebSocket      bool     `json:"websocket"`     // GET /sync/ws
+	LongPoll       bool     `json:"long_poll"`     // ?wait= on changes-since
+	SyncCursors    bool     `json:"sync_cursors"`  // opaque changes-since cursors
+	ThreadArchive  bool     `json:"thread_archive"`
+	ThreadBranches bool     `json:"thread_branches"`
+	Purge          bool     `json:"purge"`
+	Import         bool     `json:"import"`
+	Attachments    bool     `json:"attachments"` // /sync/attachments, off unless the instance configures a store
+	ETags          bool     `json:"etags"`       // If-None-Match on reads, If-Match on writes
+	Compression    []string `json:"compression"` // Content-Encodings responses can be sent with, empty for none
+}
+
+// Discovery is the document served at /.well-known/helios-sync.json. Clients pointed at a domain
+// read it to find the API and configure themselves for the instance.
+type Discovery struct {
+	Issuer          string                `json:"issuer"` // public URL of the instance, iss and aud of its tokens
+	APIBase         string                `json:"api_base"`
+	ProtocolVersion string                `json:"protocol_version"`
+	ProtocolURI     string                `json:"protocol_uri"` // full protocol description
+	SchemaURI       string                `json:"schema_uri"`   // JSON Schema of the sync types
+	JWKSURI         string                `json:"jwks_uri"`
+	Capabilities    DiscoveryCapabilities `json:"capabilities"`
+	Registration    RegistrationPolicy    `json:"registration"`
+	Migration       *MigrationNotice      `json:"migration,omitempty"` // set while the instance is moving
+}
+
+// DiscoveryCapabilities are the optional features an instance has enabled
+type DiscoveryCapabilities struct {
+	Encryption         EncryptionPolicy   `json:"encryption"`
+	LoginMethods       []string           `json:"login_methods"`    // "passphrase", "passkey" and "opaque"
+	OpaqueExclusive    bool               `json:"opaque_exclusive"` // wallets registered for OPAQUE can't log in with their passphrase
+	TOTP               bool               `json:"totp"`
+	DemoWallets        bool               `json:"demo_wallets"`
+	RegisteredMachines bool               `json:"registered_machines"` // machines must register before syncing
+	RequestSignatures  bool               `json:"request_signatures"`  // writes must be signed by the machine
+	Durability         DurabilityLevels   `json:"durability"`
+	ConflictStrategies ConflictStrategies `json:"conflict_strategies"`
+}
+
+// DurabilityLevels describes when writes are acknowledged. Clients ask for one of Supported with
+// ?durability= on a write; writes that don't ask get Default.
+type DurabilityLevels struct {
+	Default   string   `json:"default"`   // "ack" or "flush"
+	Supported []string `json:"supported"` // "flush" only when the storage backend can flush
+}
+
+// RegistrationPolicy describes who can create wallets on an instance and with which passphrases
+type RegistrationPolicy struct {
+	Open                     bool                `json:"open"` // anyone can create a wallet, within the limits
+	PassphraseMinLength      int                 `json:"passphrase_min_length,omitempty"`
+	PassphraseMinEntropyBits float64             `json:"passphrase_min_entropy_bits,omitempty"`
+	Limits                   []RegistrationLimit `json:"limits"`
+	DemoWalletTTLHours       int                 `json:"demo_wallet_ttl_hours,omitempty"`
+	Methods                  []string            `json:"methods"` // "passphrase", and "opaque" for creating wallets without sending the passphrase
+}
+
+// RegistrationLimit caps wallet creations per scope ("ip", "subnet" or "asn") in a sliding window
+type RegistrationLimit struct {
+	Scope         string `json:"scope"`
+	Limit         int    `json:"limit"`
+	WindowSeconds int64  `json:"window_seconds"`
+}
+
+// ProtocolDescription describes the sync protocol as implemented by this instance. It is generated
+// from the registered routes, the error catalog, the configured limits and the API types.
+type ProtocolDescription struct {
+	Version       string                 `json:"version"`
+	Endpoints     []ProtocolEndpoint     `json:"endpoints"`
+	ConflictRules []ProtocolRule         `json:"conflict_rules"`
+	Cursor        string                 `json:"cursor"` // semantics of the changes-since timestamp
+	ErrorCodes    []ProtocolErrorCode    `json:"error_codes"`
+	Limits        map[string]int64       `json:"limits"`       // 0 means unlimited
+	Requirements  map[string]bool        `json:"requirements"` // optional checks this instance enforces
+	Examples      map[string]interface{} `json:"examples"`
+}
+
+// ProtocolEndpoint is a route of the API
+type ProtocolEndpoint struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Scope  string `json:"scope,omitempty"` // scope a scoped token needs for the route, on routes that take tokens
+}
+
+// ProtocolRule states how writes to a resource are reconciled with the stored state
+type ProtocolRule struct {
+	Resource string `json:"resource"`
+	Rule     string `json:"rule"`
+}
+
+// ProtocolErrorCode is a stable error code with its message in the client's locale
+type ProtocolErrorCode struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// EncryptionPolicy describes which client-side encryption envelope versions the server accepts
+type EncryptionPolicy struct {
+	CurrentVersion    int   `json:"current_version"`    // version new writes should use
+	SupportedVersions []int `json:"supported_versions"` // versions accepted on write
+}
+
+// MigrationNotice announces that an instance is moving and will shut down after MovesAt. Clients
+// should prompt users to move their wallets to TargetURL before then.
+type MigrationNotice struct {
+	TargetURL string    `json:"target_url"`
+	MovesAt   time.Time `json:"moves_at"`
+	Message   string    `json:"message,omitempty"` // operator's note for users
+}
+
+// Resource and operation of the changes-since operation carrying a MigrationNotice
+const (
+	ResourceInstance = "instance"
+	OperationMigrate = "migrate"
+)
+
+// LegacyEncryptionVersion is assumed for records written before enc_v existed
+const LegacyEncryptionVersion = 1
+
+// Validate checks an envelope version, defaulting a missing version to the legacy scheme
+func (p EncryptionPolicy) Validate(encV *int) error {
+	if *encV == 0 {
+		*encV = LegacyEncryptionVersion
+	}
+
+	for _, supported := range p.SupportedVersions {
+		if *encV == supported {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("encryption version %d is not supported, supported versions: %v", *encV, p.SupportedVersions)
 }
 
 // PaginationParams represents pagination parameters
 type PaginationParams struct {
-	Offset int `json:"offset"`
-	Limit  int `json:"limit"`
+	Offset int    `json:"offset"`
+	Limit  int    `json:"limit"`
+	Cursor string `json:"cursor,omitempty"` // next_cursor of the previous page; takes precedence over Offset
 }
 
 // PaginatedThreadsResponse represents a paginated response for threads
 type PaginatedThreadsResponse struct {
-	Threads []Thread `json:"threads"`
-	Total   int      `json:"total"`
-	Offset  int      `json:"offset"`
-	Limit   int      `json:"limit"`
-	HasMore bool     `json:"has_more"`
+	Threads        []Thread `json:"threads"`
+	Total          int      `json:"total"`
+	Offset         int      `json:"offset"`
+	Limit          int      `json:"limit"`
+	HasMore        bool     `json:"has_more"`
+	NextCursor     string   `json:"next_cursor,omitempty"` // requests the following page; set while HasMore
+	CorruptedCount int      `json:"corrupted_count"`       // unreadable records skipped on this page
+
+	MessageCounts map[string]int64 `json:"message_counts"` // thread ID → messages counted by the server; threads not yet counted are missing
+}
+
+// SettingsRevisions maps each settings resource ("provider_instances", "disabled_models",
+// "advanced_settings", "tool_servers", "prompt_library", "tags", "profile", "custom_instructions",
+// "favorite_models") to the unix ms time of its last change
+type SettingsRevisions map[string]int64
+
+// SyncLimits describes server limits clients should respect
+type SyncLimits struct {
+	ThreadsPageDefault  int   `json:"threads_page_default"`
+	ThreadsPageMax      int   `json:"threads_page_max"`
+	MessagesPageDefault int   `json:"messages_page_default"`
+	MessagesPageMax     int   `json:"messages_page_max"`
+	BatchOperationsMax  int   `json:"batch_operations_max"`
+	ReadStateBatchMax   int   `json:"read_state_batch_max"`
+	ImportItemMaxBytes  int64 `json:"import_item_max_bytes"` // largest record of an import stream
+	MessageIDMaxLength  int   `json:"message_id_max_length"`
+	AttachmentMaxBytes  int64 `json:"attachment_max_bytes"` // largest attachment upload, 0 for no limit
+}
+
+// Machine is a device registered to a wallet
+type Machine struct {
+	ID            uuid.UUID  `json:"id"` // UUIDv7 the device sends as machine_id
+	Name          string     `json:"name"`
+	Platform      string     `json:"platform,omitempty"`
+	Active        bool       `json:"active"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+	DeactivatedAt *time.Time `json:"deactivated_at,omitempty"`
+}
+
+// Session is a login of a wallet that can still be refreshed
+type Session struct {
+	ID         string    `json:"id"`
+	MachineID  string    `json:"machine_id,omitempty"`
+	IssuedAt   time.Time `json:"issued_at"`    // time of the login that started the session
+	LastUsedAt time.Time `json:"last_used_at"` // time of the latest login or token refresh
+	ExpiresAt  time.Time `json:"expires_at"`   // expiry of the session's newest refresh token
+}
+
+// MachineRegisterRequest registers a device under the authenticated wallet
+type MachineRegisterRequest struct {
+	ID       string `json:"id" binding:"required"`
+	Name     string `json:"name" binding:"required"`
+	Platform string `json:"platform"`
+
+	// Confirms the issue of a signing secret when the instance requires signed writes,
+	// so a stolen token alone can't register a machine that can write
+	Passphrase string `json:"passphrase,omitempty"`
+}
+
+// MachineRegistration is a registered machine with its request signing secret, which is only
+// returned by the registration that issued it
+type MachineRegistration struct {
+	Machine
+	SigningSecret string `json:"signing_secret,omitempty"`
+}
+
+// MachineRenameRequest changes the display name of a registered device
+type MachineRenameRequest struct {
+	Name string `json:"name" binding:"required"`
+}
+
+// Quotas are per-user storage limits (0 means unlimited)
+type Quotas struct {
+	Threads  int64 `json:"threads"`
+	Messages int64 `json:"messages"`
+	Memories int64 `json:"memories"`
+
+	MessagesPerThread int64 `json:"messages_per_thread"` // enforced: creating more messages in a thread is refused
+	AttachmentBytes   int64 `json:"attachment_bytes"`    // enforced: uploads that would exceed it are refused
+}
+
+// Usage counts the records a user currently stores
+type Usage struct {
+	Threads         int64 `json:"threads"`
+	Messages        int64 `json:"messages"`
+	Memories        int64 `json:"memories"`
+	Attachments     int64 `json:"attachments"`
+	AttachmentBytes int64 `json:"attachment_bytes"`
+}
+
+// Quota warning levels
+const (
+	QuotaWarningLevel  = "warning"  // 80% of the limit reached
+	QuotaCriticalLevel = "critical" // 95% of the limit reached
+)
+
+// QuotaWarning tells the client a user is approaching one of their limits
+type QuotaWarning struct {
+	Resource string `json:"resource"` // "threads", "messages", "memories" or "attachment_bytes"
+	Level    string `json:"level"`
+	Used     int64  `json:"used"`
+	Limit    int64  `json:"limit"`
+	Message  string `json:"message,omitempty"` // localized for the requesting client
+}
+
+// UsageResponse reports a user's usage against their limits
+type UsageResponse struct {
+	Usage    Usage          `json:"usage"`
+	Quotas   Quotas         `json:"quotas"`
+	Warnings []QuotaWarning `json:"warnings"`
+}
+
+// BenchmarkRequest configures a storage latency benchmark; zero values select the defaults
+type BenchmarkRequest struct {
+	PayloadBytes int `json:"payload_bytes"`
+	Iterations   int `json:"iterations"`
+}
+
+// LatencyStats summarizes the samples of one benchmarked operation
+type LatencyStats struct {
+	MinMs float64 `json:"min_ms"`
+	AvgMs float64 `json:"avg_ms"`
+	MaxMs float64 `json:"max_ms"`
+}
+
+// BenchmarkReport is the result of a storage latency benchmark
+type BenchmarkReport struct {
+	PayloadBytes int          `json:"payload_bytes"`
+	Iterations   int          `json:"iterations"`
+	RoundTrip    LatencyStats `json:"round_trip"` // storage lookup of a missing key
+	Write        LatencyStats `json:"write"`
+	Read         LatencyStats `json:"read"`
+	TotalMs      float64      `json:"total_ms"` // server time spent on the benchmark, to subtract from the client's measurement
+	MeasuredAt   time.Time    `json:"measured_at"`
+}
+
+// BootstrapResponse bundles everything a client loads at startup into one response
+type BootstrapResponse struct {
+	SettingsBundle
+	Threads           *PaginatedThreadsResponse `json:"threads"`  // first page, most recent first
+	Folders           []Folder                  `json:"folders"`  // all folders, by position
+	Machines          []Machine                 `json:"machines"` // registered devices of the wallet
+	SettingsRevisions SettingsRevisions         `json:"settings_revisions"`
+	Limits            SyncLimits                `json:"limits"`
+	SyncCursor        string                    `json:"sync_cursor"`    // cursor for the first changes-since request
+	SyncTimestamp     time.Time                 `json:"sync_timestamp"` // the same for clients still syncing by timestamp
 }
 
 // PaginatedMessagesResponse represents a paginated response for messages
 type PaginatedMessagesResponse struct {
-	Messages []Message `json:"messages"`
-	Total    int       `json:"total"`
-	Offset   int       `json:"offset"`
-	Limit    int       `json:"limit"`
-	HasMore  bool      `json:"has_more"`
+	Messages       []Message `json:"messages"`
+	Total          int       `json:"total"`
+	Offset         int       `json:"offset"`
+	Limit          int       `json:"limit"`
+	HasMore        bool      `json:"has_more"`
+	NextCursor     string    `json:"next_cursor,omitempty"` // requests the following page; set while HasMore
+	CorruptedCount int       `json:"corrupted_count"`       // unreadable messages in the thread, skipped
 }
 
 // APIError represents a standardized API error response
 type APIError struct {
-	Code    int    `json:"code"`
+	Code      int    `json:"code"`
+	ErrorCode string `json:"error_code,omitempty"` // stable machine-readable code; Message is localized
+	Message   string `json:"message"`
+	Details   string `json:"details,omitempty"`
+
+	Violations []PolicyViolation `json:"violations,omitempty"` // rules a submitted value broke, e.g. the passphrase policy
+	Current    interface{}       `json:"current,omitempty"`    // the stored document a write conflicted with, for the client to merge
+}
+
+// PolicyViolation names a broken validation rule and explains it
+type PolicyViolation struct {
+	Rule    string `json:"rule"`
 	Message string `json:"message"`
-	Details string `json:"details,omitempty"`
 }
 
 // APIResponse represents a standardized API response
 type APIResponse struct {
-	Success bool        `json:"success"`
-	Data    interface{} `json:"data,omitempty"`
-	Error   *APIError   `json:"error,omitempty"`
+	Success  bool           `json:"success"`
+	Data     interface{}    `json:"data,omitempty"`
+	Error    *APIError      `json:"error,omitempty"`
+	Warnings []QuotaWarning `json:"warnings,omitempty"` // set on writes when the user nears a limit
+
+	Conflict *ConflictResolution `json:"conflict,omitempty"` // set on writes that overwrote a newer stored document
+}
+
+// Redacted returns the thread without its client-encrypted payload, for metadata-only access
+func (t Thread) Redacted() Thread {
+	return Thread{
+		ID:             t.ID,
+		UserID:         t.UserID,
+		Version:        t.Version,
+		EncV:           t.EncV,
+		ArchivedRemote: t.ArchivedRemote,
+		Archived:       t.Archived,
+	}
+}
+
+// Redacted returns the message without its client-encrypted payload, for metadata-only access
+func (m Message) Redacted() Message {
+	return Message{
+		ID:   m.ID,
+		EncV: m.EncV,
+	}
+}
+
+// Redacted returns the meta-history entry without its client-encrypted tokens
+func (e ThreadMetaEntry) Redacted() ThreadMetaEntry {
+	return ThreadMetaEntry{
+		Version:   e.Version,
+		Timestamp: e.Timestamp,
+		MachineID: e.MachineID,
+	}
+}
+
+// Redacted returns the memory without its client-encrypted payload, for metadata-only access
+func (m Memory) Redacted() Memory {
+	m.Content = ""
+	return m
+}
+
+// Redacted returns the draft without its client-encrypted payload, for metadata-only access
+func (d Draft) Redacted() Draft {
+	d.Content = ""
+	d.Attachments = ""
+	return d
+}
+
+// Redacted returns the read state without its client-encrypted marker, for metadata-only access.
+// The unread flag is kept, it's what a metadata view shows.
+func (r ReadState) Redacted() ReadState {
+	r.LastRead = ""
+	return r
+}
+
+// Redacted returns the folder without its client-encrypted name, for metadata-only access
+func (f Folder) Redacted() Folder {
+	f.Name = ""
+	return f
+}
+
+// Redacted returns the provider instances without their client-encrypted payload
+func (p ProviderInstances) Redacted() ProviderInstances {
+	p.Providers = nil
+	return p
+}
+
+// Redacted returns the disabled models without their client-encrypted payload
+func (d DisabledModels) Redacted() DisabledModels {
+	d.Models = nil
+	return d
+}
+
+// Redacted returns the advanced settings without their client-encrypted payload
+func (a AdvancedSettings) Redacted() AdvancedSettings {
+	a.Settings = nil
+	return a
+}
+
+// Redacted returns the tool servers without their client-encrypted payload
+func (t ToolServers) Redacted() ToolServers {
+	t.Servers = nil
+	return t
+}
+
+// Redacted returns the prompt library without its client-encrypted payload
+func (p PromptLibrary) Redacted() PromptLibrary {
+	p.Prompts = nil
+	return p
+}
+
+// Redacted returns the tags without their client-encrypted definitions
+func (t Tags) Redacted() Tags {
+	t.Definitions = nil
+	return t
+}
+
+// Redacted returns the favorite models without their client-encrypted payload
+func (f FavoriteModels) Redacted() FavoriteModels {
+	f.Models = nil
+	f.Order = ""
+	return f
+}
+
+// Redacted returns the custom instructions without their client-encrypted content
+func (c CustomInstructions) Redacted() CustomInstructions {
+	c.Content = ""
+	return c
+}
+
+// Redacted returns the profile without its client-encrypted payload
+func (p Profile) Redacted() Profile {
+	p.DisplayName = ""
+	p.Avatar = ""
+	p.Preferences = nil
+	return p
+}
+
+// Fields points to the fields of the document the server manages
+func (p *ProviderInstances) Fields() SettingsFields {
+	return SettingsFields{UserID: &p.UserID, EncV: &p.EncV, Version: &p.Version, Deleted: &p.Deleted, UpdatedAt: &p.UpdatedAt}
+}
+
+// Redact drops the document's client-encrypted payload
+func (p *ProviderInstances) Redact() {
+	*p = p.Redacted()
+}
+
+// Fields points to the fields of the document the server manages
+func (d *DisabledModels) Fields() SettingsFields {
+	return SettingsFields{UserID: &d.UserID, EncV: &d.EncV, Version: &d.Version, Deleted: &d.Deleted, UpdatedAt: &d.UpdatedAt}
+}
+
+// Redact drops the document's client-encrypted payload
+func (d *DisabledModels) Redact() {
+	*d = d.Redacted()
+}
+
+// Fields points to the fields of the document the server manages
+func (a *AdvancedSettings) Fields() SettingsFields {
+	return SettingsFields{UserID: &a.UserID, EncV: &a.EncV, Version: &a.Version, Deleted: &a.Deleted, UpdatedAt: &a.UpdatedAt}
+}
+
+// Redact drops the document's client-encrypted payload
+func (a *AdvancedSettings) Redact() {
+	*a = a.Redacted()
+}
+
+// Fields points to the fields of the document the server manages
+func (t *ToolServers) Fields() SettingsFields {
+	return SettingsFields{UserID: &t.UserID, EncV: &t.EncV, Version: &t.Version, Deleted: &t.Deleted, UpdatedAt: &t.UpdatedAt}
+}
+
+// Redact drops the document's client-encrypted payload
+func (t *ToolServers) Redact() {
+	*t = t.Redacted()
+}
+
+// Fields points to the fields of the document the server manages
+func (p *PromptLibrary) Fields() SettingsFields {
+	return SettingsFields{UserID: &p.UserID, EncV: &p.EncV, Version: &p.Version, Deleted: &p.Deleted, UpdatedAt: &p.UpdatedAt}
+}
+
+// Redact drops the document's client-encrypted payload
+func (p *PromptLibrary) Redact() {
+	*p = p.Redacted()
+}
+
+// Fields points to the fields of the document the server manages
+func (t *Tags) Fields() SettingsFields {
+	return SettingsFields{UserID: &t.UserID, EncV: &t.EncV, Version: &t.Version, Deleted: &t.Deleted, UpdatedAt: &t.UpdatedAt}
+}
+
+// Redact drops the document's client-encrypted payload
+func (t *Tags) Redact() {
+	*t = t.Redacted()
+}
+
+// Fields points to the fields of the document the server manages
+func (f *FavoriteModels) Fields() SettingsFields {
+	return SettingsFields{UserID: &f.UserID, EncV: &f.EncV, Version: &f.Version, Deleted: &f.Deleted, UpdatedAt: &f.UpdatedAt}
+}
+
+// Redact drops the document's client-encrypted payload
+func (f *FavoriteModels) Redact() {
+	*f = f.Redacted()
+}
+
+// Fields points to the fields of the document the server manages
+func (c *CustomInstructions) Fields() SettingsFields {
+	return SettingsFields{UserID: &c.UserID, EncV: &c.EncV, Version: &c.Version, Deleted: &c.Deleted, UpdatedAt: &c.UpdatedAt}
+}
+
+// Redact drops the document's client-encrypted payload
+func (c *CustomInstructions) Redact() {
+	*c = c.Redacted()
+}
+
+// Fields points to the fields of the document the server manages
+func (p *Profile) Fields() SettingsFields {
+	return SettingsFields{UserID: &p.UserID, EncV: &p.EncV, Version: &p.Version, UpdatedAt: &p.UpdatedAt}
+}
+
+// Redact drops the document's client-encrypted payload
+func (p *Profile) Redact() {
+	*p = p.Redacted()
+}
+
+// Redacted returns the settings documents without their client-encrypted payloads
+func (b SettingsBundle) Redacted() SettingsBundle {
+	if b.ProviderInstances != nil {
+		pi := b.ProviderInstances.Redacted()
+		b.ProviderInstances = &pi
+	}
+	if b.DisabledModels != nil {
+		dm := b.DisabledModels.Redacted()
+		b.DisabledModels = &dm
+	}
+	if b.AdvancedSettings != nil {
+		as := b.AdvancedSettings.Redacted()
+		b.AdvancedSettings = &as
+	}
+	if b.ToolServers != nil {
+		ts := b.ToolServers.Redacted()
+		b.ToolServers = &ts
+	}
+	if b.PromptLibrary != nil {
+		pl := b.PromptLibrary.Redacted()
+		b.PromptLibrary = &pl
+	}
+	if b.Tags != nil {
+		tags := b.Tags.Redacted()
+		b.Tags = &tags
+	}
+	if b.CustomInstructions != nil {
+		instructions := b.CustomInstructions.Redacted()
+		b.CustomInstructions = &instructions
+	}
+	if b.FavoriteModels != nil {
+		favorites := b.FavoriteModels.Redacted()
+		b.FavoriteModels = &favorites
+	}
+	if b.Profile != nil {
+		profile := b.Profile.Redacted()
+		b.Profile = &profile
+	}
+	return b
+}
+
+// Redacted returns the bootstrap data without any client-encrypted payloads
+func (b BootstrapResponse) Redacted() BootstrapResponse {
+	b.SettingsBundle = b.SettingsBundle.Redacted()
+	if b.Threads != nil {
+		threads := *b.Threads
+		threads.Threads = make([]Thread, len(b.Threads.Threads))
+		for i, t := range b.Threads.Threads {
+			threads.Threads[i] = t.Redacted()
+		}
+		b.Threads = &threads
+	}
+	if b.Folders != nil {
+		folders := make([]Folder, len(b.Folders))
+		for i, f := range b.Folders {
+			folders[i] = f.Redacted()
+		}
+		b.Folders = folders
+	}
+	return b
+}
+
+// Redacted returns the changes without any payload data, keeping only operation metadata
+func (r ChangesSinceResponse) Redacted() ChangesSinceResponse {
+	redacted := ChangesSinceResponse{SyncCursor: r.SyncCursor, SyncTimestamp: r.SyncTimestamp, SettingsRevisions: r.SettingsRevisions, CorruptedCount: r.CorruptedCount}
+	for _, t := range r.FullThreads {
+		redacted.FullThreads = append(redacted.FullThreads, t.Redacted())
+	}
+	for _, m := range r.FullMessages {
+		redacted.FullMessages = append(redacted.FullMessages, m.Redacted())
+	}
+	redacted.SettingsBundle = r.SettingsBundle.Redacted()
+	for _, m := range r.FullMemories {
+		redacted.FullMemories = append(redacted.FullMemories, m.Redacted())
+	}
+	for _, f := range r.FullFolders {
+		redacted.FullFolders = append(redacted.FullFolders, f.Redacted())
+	}
+	for _, d := range r.FullDrafts {
+		redacted.FullDrafts = append(redacted.FullDrafts, d.Redacted())
+	}
+	for _, rs := range r.FullReadStates {
+		redacted.FullReadStates = append(redacted.FullReadStates, rs.Redacted())
+	}
+	for _, op := range r.Operations {
+		op.Data = nil
+		redacted.Operations = append(redacted.Operations, op)
+	}
+	return redacted
 }
 
 // ValidateUUIDv7 validates that a UUID is version 7
@@ -176,9 +1500,52 @@ func ValidateUUIDv7(u uuid.UUID) error {
 	return nil
 }
 
+// Message IDs are opaque strings chosen by the client. A client may namespace the IDs it generates
+// as "<prefix>:<id>", e.g. with its machine ID, so IDs generated offline on different devices never
+// collide and a retried create is recognized as the same message.
+const (
+	MessageIDMaxLength       = 128
+	MessageIDPrefixMaxLength = 40
+)
+
+// ValidateMessageID checks a message ID against the ID policy: 1 to MessageIDMaxLength characters
+// out of A-Z, a-z, 0-9, '.', '_', '~' and '-', with at most one ':' ending a non-empty client prefix
+// of up to MessageIDPrefixMaxLength characters
+func ValidateMessageID(id string) error {
+	if id == "" {
+		return fmt.Errorf("message ID cannot be empty")
+	}
+	if len(id) > MessageIDMaxLength {
+		return fmt.Errorf("message ID must be at most %d characters, got %d", MessageIDMaxLength, len(id))
+	}
+
+	prefix, local, namespaced := strings.Cut(id, ":")
+	if namespaced {
+		if prefix == "" || len(prefix) > MessageIDPrefixMaxLength {
+			return fmt.Errorf("message ID prefix must be 1 to %d characters", MessageIDPrefixMaxLength)
+		}
+		if local == "" {
+			return fmt.Errorf("message ID cannot end with its prefix")
+		}
+	}
+
+	for _, part := range []string{prefix, local} {
+		for _, r := range part {
+			if !isMessageIDChar(r) {
+				return fmt.Errorf("message ID contains invalid character %q", r)
+			}
+		}
+	}
+	return nil
+}
+
+func isMessageIDChar(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '_' || r == '~' || r == '-'
+}
+
 // SyncRequest represents a generic sync request wrapper for PUT operations
 type SyncRequest[T any] struct {
-	MachineID string    `json:"machine_id" validate:"required"` // Unique ID for the machine making the request
+	MachineID string    `json:"machine_id" validate:"required"` // Unique ID for the machine making the request; may be omitted with machine-bound tokens
 	UserID    uuid.UUID `json:"user_id" validate:"required"`    // User ID for whom the sync is being performed
 	Data      T         `json:"data" validate:"required"`       // The actual data payload
 	Version   int64     `json:"version" validate:"required"`    // Version of the data being sent
@@ -192,6 +1559,22 @@ type ThreadUpdateRequest struct {
 	Version   int64     `json:"version" validate:"required"`
 }
 
+// BranchThreadRequest creates a branch of a thread holding its messages up to UpToMessageID.
+// Data is the new thread, with its own ID and (client-encrypted) metadata.
+type BranchThreadRequest struct {
+	MachineID     string    `json:"machine_id" validate:"required"`
+	UserID        uuid.UUID `json:"user_id" validate:"required"`
+	Data          Thread    `json:"data" validate:"required"`
+	Version       int64     `json:"version" validate:"required"`
+	UpToMessageID string    `json:"up_to_message_id" validate:"required"` // last message the branch inherits, in message ID order
+}
+
+// ThreadBranch is the result of branching a thread
+type ThreadBranch struct {
+	Thread            Thread `json:"thread"`
+	InheritedMessages int    `json:"inherited_messages"` // messages shared with the source thread rather than copied
+}
+
 // MessageUpdateRequest represents a message update request with machine ID
 type MessageUpdateRequest struct {
 	MachineID string    `json:"machine_id" validate:"required"`
@@ -225,6 +1608,125 @@ type AdvancedSettingsUpdateRequest struct {
 	Version   int64            `json:"version" validate:"required"`
 }
 
+// ToolServersUpdateRequest represents a tool servers update request with machine ID
+type ToolServersUpdateRequest struct {
+	MachineID string      `json:"machine_id" validate:"required"`
+	UserID    uuid.UUID   `json:"user_id" validate:"required"`
+	Data      ToolServers `json:"data" validate:"required"`
+	Version   int64       `json:"version" validate:"required"`
+}
+
+// PromptLibraryUpdateRequest represents a prompt library update request with machine ID
+type PromptLibraryUpdateRequest struct {
+	MachineID string        `json:"machine_id" validate:"required"`
+	UserID    uuid.UUID     `json:"user_id" validate:"required"`
+	Data      PromptLibrary `json:"data" validate:"required"`
+	Version   int64         `json:"version" validate:"required"`
+}
+
+// TagsUpdateRequest represents a tags update request with machine ID
+type TagsUpdateRequest struct {
+	MachineID string    `json:"machine_id" validate:"required"`
+	UserID    uuid.UUID `json:"user_id" validate:"required"`
+	Data      Tags      `json:"data" validate:"required"`
+	Version   int64     `json:"version" validate:"required"`
+}
+
+// CustomInstructionsUpdateRequest represents a custom instructions update request with machine ID
+type CustomInstructionsUpdateRequest struct {
+	MachineID string             `json:"machine_id" validate:"required"`
+	UserID    uuid.UUID          `json:"user_id" validate:"required"`
+	Data      CustomInstructions `json:"data" validate:"required"`
+	Version   int64              `json:"version" validate:"required"`
+}
+
+// FavoriteModelsUpdateRequest represents a favorite models update request with machine ID
+type FavoriteModelsUpdateRequest struct {
+	MachineID string         `json:"machine_id" validate:"required"`
+	UserID    uuid.UUID      `json:"user_id" validate:"required"`
+	Data      FavoriteModels `json:"data" validate:"required"`
+	Version   int64          `json:"version" validate:"required"`
+}
+
+// ProfileUpdateRequest represents a profile update request with machine ID
+type ProfileUpdateRequest struct {
+	MachineID string    `json:"machine_id" validate:"required"`
+	UserID    uuid.UUID `json:"user_id" validate:"required"`
+	Data      Profile   `json:"data" validate:"required"`
+	Version   int64     `json:"version" validate:"required"`
+}
+
+// AuditEntry records a single authorization decision
+type AuditEntry struct {
+	Timestamp  time.Time `json:"timestamp"`
+	UserID     string    `json:"user_id,omitempty"`
+	MachineID  string    `json:"machine_id,omitempty"`
+	Method     string    `json:"method"`
+	Route      string    `json:"route"`
+	ResourceID string    `json:"resource_id,omitempty"`
+	Decision   string    `json:"decision"` // "allow" or "deny"
+	Status     int       `json:"status"`
+	ClientIP   string    `json:"client_ip"`
+	TokenType  string    `json:"token_type,omitempty"`
+	TokenID    string    `json:"token_id,omitempty"`
+}
+
+// TraceEntry is a redacted request/response envelope recorded while a user has debug tracing on.
+// Payload bodies are never recorded; Request and Response only keep unencrypted protocol fields
+// (IDs, versions, machine IDs, sync timestamps) and the lengths of lists.
+type TraceEntry struct {
+	Timestamp  time.Time              `json:"timestamp"`
+	MachineID  string                 `json:"machine_id,omitempty"`
+	Method     string                 `json:"method"`
+	Route      string                 `json:"route"`
+	Params     map[string]string      `json:"params,omitempty"` // path and query parameters
+	Status     int                    `json:"status"`
+	DurationMs int64                  `json:"duration_ms"`
+	BytesIn    int                    `json:"bytes_in"`
+	BytesOut   int                    `json:"bytes_out"`
+	ErrorCode  string                 `json:"error_code,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+	Request    map[string]interface{} `json:"request,omitempty"`
+	Response   map[string]interface{} `json:"response,omitempty"`
+}
+
+// DebugTrace is a user's debug tracing state and the envelopes recorded so far, newest first
+type DebugTrace struct {
+	EnabledUntil *time.Time   `json:"enabled_until,omitempty"` // nil when tracing is off
+	Entries      []TraceEntry `json:"entries"`
+}
+
+// MemoryUpdateRequest represents a memory upsert request with machine ID
+type MemoryUpdateRequest struct {
+	MachineID string    `json:"machine_id" validate:"required"`
+	UserID    uuid.UUID `json:"user_id" validate:"required"`
+	Data      Memory    `json:"data" validate:"required"`
+	Version   int64     `json:"version" validate:"required"`
+}
+
+// DraftUpdateRequest represents a draft write with machine ID. Drafts are last write wins, so it
+// carries no version.
+type DraftUpdateRequest struct {
+	MachineID string    `json:"machine_id" validate:"required"`
+	UserID    uuid.UUID `json:"user_id" validate:"required"`
+	Data      Draft     `json:"data" validate:"required"`
+}
+
+// ReadStateBatchRequest writes the read states of several threads at once
+type ReadStateBatchRequest struct {
+	MachineID string      `json:"machine_id" validate:"required"`
+	UserID    uuid.UUID   `json:"user_id" validate:"required"`
+	States    []ReadState `json:"states" binding:"required"`
+}
+
+// FolderUpdateRequest represents a folder upsert request with machine ID
+type FolderUpdateRequest struct {
+	MachineID string    `json:"machine_id" validate:"required"`
+	UserID    uuid.UUID `json:"user_id" validate:"required"`
+	Data      Folder    `json:"data" validate:"required"`
+	Version   int64     `json:"version" validate:"required"`
+}
+
 // Helper function to marshal Wallet to JSON
 func WalletToJSON(wallet *Wallet) ([]byte, error) {
 	return json.Marshal(wallet)
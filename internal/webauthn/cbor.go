@@ -0,0 +1,150 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// CBOR major types (RFC 8949)
+const (
+	cborUnsigned = 0
+	cborNegative = 1
+	cborBytes    = 2
+	cborText     = 3
+	cborArray    = 4
+	cborMap      = 5
+	cborTag      = 6
+	cborSimple   = 7
+)
+
+// Nesting limit of decoded values; authenticator data is never deeper than a few levels
+const cborMaxDepth = 16
+
+var errTruncated = errors.New("cbor: unexpected end of data")
+
+// decodeCBOR decodes the CBOR value at the start of data and returns it with the remaining bytes.
+// It supports the subset authenticators produce: integers (as int64), byte and text strings, arrays,
+// maps (keyed by int64 or string), booleans and null. Indefinite lengths and floats are rejected.
+func decodeCBOR(data []byte) (interface{}, []byte, error) {
+	return decodeCBORValue(data, 0)
+}
+
+func decodeCBORValue(data []byte, depth int) (interface{}, []byte, error) {
+	if depth > cborMaxDepth {
+		return nil, nil, errors.New("cbor: nesting too deep")
+	}
+	if len(data) == 0 {
+		return nil, nil, errTruncated
+	}
+
+	major := data[0] >> 5
+	info := data[0] & 0x1f
+	data = data[1:]
+
+	if major == cborSimple {
+		switch info {
+		case 20:
+			return false, data, nil
+		case 21:
+			return true, data, nil
+		case 22, 23:
+			return nil, data, nil
+		}
+		return nil, nil, fmt.Errorf("cbor: unsupported simple value %d", info)
+	}
+
+	arg, data, err := cborArgument(info, data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch major {
+	case cborUnsigned:
+		if arg > 1<<63-1 {
+			return nil, nil, errors.New("cbor: integer overflows int64")
+		}
+		return int64(arg), data, nil
+	case cborNegative:
+		if arg > 1<<63-1 {
+			return nil, nil, errors.New("cbor: integer overflows int64")
+		}
+		return -1 - int64(arg), data, nil
+	case cborBytes, cborText:
+		if arg > uint64(len(data)) {
+			return nil, nil, errTruncated
+		}
+		value := data[:arg]
+		if major == cborText {
+			return string(value), data[arg:], nil
+		}
+		return append([]byte(nil), value...), data[arg:], nil
+	case cborArray:
+		if arg > uint64(len(data)) {
+			return nil, nil, errTruncated // every item takes at least a byte
+		}
+		items := make([]interface{}, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			var item interface{}
+			if item, data, err = decodeCBORValue(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			items = append(items, item)
+		}
+		return items, data, nil
+	case cborMap:
+		if arg > uint64(len(data)) {
+			return nil, nil, errTruncated
+		}
+		entries := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			var key, value interface{}
+			if key, data, err = decodeCBORValue(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, errors.New("cbor: unsupported map key type")
+			}
+			if value, data, err = decodeCBORValue(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			entries[key] = value
+		}
+		return entries, data, nil
+	case cborTag:
+		// Tags only annotate the value that follows
+		return decodeCBORValue(data, depth+1)
+	}
+	return nil, nil, fmt.Errorf("cbor: unsupported major type %d", major)
+}
+
+// cborArgument reads the argument encoded by the additional information of an initial byte
+func cborArgument(info byte, data []byte) (uint64, []byte, error) {
+	switch {
+	case info < 24:
+		return uint64(info), data, nil
+	case info == 24:
+		if len(data) < 1 {
+			return 0, nil, errTruncated
+		}
+		return uint64(data[0]), data[1:], nil
+	case info == 25:
+		if len(data) < 2 {
+			return 0, nil, errTruncated
+		}
+		return uint64(binary.BigEndian.Uint16(data)), data[2:], nil
+	case info == 26:
+		if len(data) < 4 {
+			return 0, nil, errTruncated
+		}
+		return uint64(binary.BigEndian.Uint32(data)), data[4:], nil
+	case info == 27:
+		if len(data) < 8 {
+			return 0, nil, errTruncated
+		}
+		return binary.BigEndian.Uint64(data), data[8:], nil
+	}
+	return 0, nil, errors.New("cbor: indefinite lengths are not supported")
+}
@@ -0,0 +1,92 @@
+package webauthn
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeCBOR(t *testing.T) {
+	tests := []struct {
+		input string
+		want  interface{}
+	}{
+		{"00", int64(0)},
+		{"17", int64(23)},
+		{"1818", int64(24)},
+		{"190100", int64(256)},
+		{"1a000f4240", int64(1000000)},
+		{"1b7fffffffffffffff", int64(1<<63 - 1)},
+		{"20", int64(-1)},
+		{"3863", int64(-100)},
+		{"3b7fffffffffffffff", int64(-1 << 63)},
+		{"f4", false},
+		{"f5", true},
+		{"f6", nil},
+		{"43010203", []byte{1, 2, 3}},
+		{"6449455446", "IETF"},
+		{"83010203", []interface{}{int64(1), int64(2), int64(3)}},
+		{"a201020326", map[interface{}]interface{}{int64(1): int64(2), int64(3): int64(-7)}},
+		{"a1616101", map[interface{}]interface{}{"a": int64(1)}},
+		{"c11a514b67b0", int64(1363896240)}, // tagged epoch time
+	}
+	for _, tt := range tests {
+		data, _ := hex.DecodeString(tt.input)
+		got, rest, err := decodeCBOR(data)
+		if err != nil {
+			t.Errorf("decodeCBOR(%s): %v", tt.input, err)
+			continue
+		}
+		if len(rest) != 0 {
+			t.Errorf("decodeCBOR(%s): %d bytes left over", tt.input, len(rest))
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("decodeCBOR(%s) = %#v, want %#v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeCBORRejectsMalformedInput(t *testing.T) {
+	tests := map[string]string{
+		"empty":                     "",
+		"truncated argument":        "19ff",
+		"truncated byte string":     "4401",
+		"truncated text string":     "636162",
+		"byte string length beyond": "5bffffffffffffffff",
+		"array length beyond":       "9bffffffffffffffff01",
+		"map length beyond":         "bbffffffffffffffff01",
+		"truncated array":           "830102",
+		"map without value":         "a101",
+		"unsigned overflow":         "1bffffffffffffffff",
+		"negative overflow":         "3bffffffffffffffff",
+		"indefinite length":         "5f41014102ff",
+		"reserved argument":         "1c",
+		"float":                     "f93c00",
+		"unassigned simple value":   "f0",
+		"array map key":             "a1800102",
+		"bytes map key":             "a1410101",
+		"nesting too deep":          strings.Repeat("81", cborMaxDepth+2) + "00",
+		"tag nesting too deep":      strings.Repeat("c1", cborMaxDepth+2) + "00",
+	}
+	for name, input := range tests {
+		data, err := hex.DecodeString(input)
+		if err != nil {
+			t.Fatalf("%s: invalid hex: %v", name, err)
+		}
+		if value, _, err := decodeCBOR(data); err == nil {
+			t.Errorf("%s: decodeCBOR(%s) = %#v, want an error", name, input, value)
+		}
+	}
+}
+
+func TestDecodeCBORLeavesTrailingData(t *testing.T) {
+	_, rest, err := decodeCBOR([]byte{0x01, 0x02, 0x03})
+	if err != nil {
+		t.Fatalf("decodeCBOR: %v", err)
+	}
+	if !bytes.Equal(rest, []byte{0x02, 0x03}) {
+		t.Fatalf("rest = %x, want 0203", rest)
+	}
+}
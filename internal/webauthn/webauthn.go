@@ -0,0 +1,239 @@
+// Package webauthn verifies WebAuthn (passkey) ceremonies: registration responses, whose attestation
+// statements are not checked, and login assertions signed with ES256, RS256 or Ed25519 keys.
+package webauthn
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+)
+
+// ErrInvalidResponse is returned for authenticator responses that don't verify
+var ErrInvalidResponse = errors.New("invalid webauthn response")
+
+// Ceremony types of the client data
+const (
+	TypeCreate = "webauthn.create"
+	TypeGet    = "webauthn.get"
+)
+
+// Authenticator data flags
+const (
+	FlagUserPresent  = 0x01
+	FlagUserVerified = 0x04
+	FlagAttestedData = 0x40
+)
+
+// COSE algorithms of the supported credential keys
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+// SupportedAlgorithms are the key algorithms offered to authenticators, in order of preference
+var SupportedAlgorithms = []int64{AlgES256, AlgEdDSA, AlgRS256}
+
+// ClientData is the client data the browser signs over
+type ClientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"` // base64url, unpadded
+	Origin    string `json:"origin"`
+}
+
+// VerifyClientData checks that raw is client data of the ceremony type for challenge, collected on one of origins
+func VerifyClientData(raw []byte, ceremony, challenge string, origins []string) error {
+	var data ClientData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return fmt.Errorf("%w: malformed client data", ErrInvalidResponse)
+	}
+	if data.Type != ceremony {
+		return fmt.Errorf("%w: unexpected ceremony %q", ErrInvalidResponse, data.Type)
+	}
+	if subtle.ConstantTimeCompare([]byte(data.Challenge), []byte(challenge)) != 1 {
+		return fmt.Errorf("%w: challenge mismatch", ErrInvalidResponse)
+	}
+	if !slices.Contains(origins, data.Origin) {
+		return fmt.Errorf("%w: origin %q is not allowed", ErrInvalidResponse, data.Origin)
+	}
+	return nil
+}
+
+// ChallengeOf returns the challenge of client data, before it is verified
+func ChallengeOf(raw []byte) (string, error) {
+	var data ClientData
+	if err := json.Unmarshal(raw, &data); err != nil || data.Challenge == "" {
+		return "", fmt.Errorf("%w: malformed client data", ErrInvalidResponse)
+	}
+	return data.Challenge, nil
+}
+
+// AuthenticatorData is the authenticator's statement about a ceremony
+type AuthenticatorData struct {
+	RPIDHash  []byte
+	Flags     byte
+	SignCount uint32
+
+	// Set on registration only
+	CredentialID []byte
+	PublicKey    []byte // COSE_Key
+}
+
+// ParseAuthenticatorData parses the binary authenticator data
+func ParseAuthenticatorData(data []byte) (*AuthenticatorData, error) {
+	if len(data) < 37 {
+		return nil, fmt.Errorf("%w: authenticator data too short", ErrInvalidResponse)
+	}
+	auth := &AuthenticatorData{
+		RPIDHash:  data[:32],
+		Flags:     data[32],
+		SignCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	if auth.Flags&FlagAttestedData == 0 {
+		return auth, nil
+	}
+
+	// AAGUID (16 bytes), credential ID length (2 bytes), credential ID, COSE_Key
+	rest := data[37:]
+	if len(rest) < 18 {
+		return nil, fmt.Errorf("%w: attested credential data too short", ErrInvalidResponse)
+	}
+	idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if len(rest) < idLen {
+		return nil, fmt.Errorf("%w: credential ID truncated", ErrInvalidResponse)
+	}
+	auth.CredentialID = rest[:idLen]
+	rest = rest[idLen:]
+
+	_, after, err := decodeCBOR(rest)
+	if err != nil {
+		return nil, fmt.Errorf("%w: credential public key: %v", ErrInvalidResponse, err)
+	}
+	auth.PublicKey = rest[:len(rest)-len(after)]
+	if _, err := ParsePublicKey(auth.PublicKey); err != nil {
+		return nil, err
+	}
+	return auth, nil
+}
+
+// ParseAttestationObject returns the authenticator data of a registration response. The attestation
+// statement is not verified: any authenticator the user chooses is accepted.
+func ParseAttestationObject(data []byte) (*AuthenticatorData, error) {
+	value, _, err := decodeCBOR(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: attestation object: %v", ErrInvalidResponse, err)
+	}
+	object, ok := value.(map[interface{}]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: attestation object is not a map", ErrInvalidResponse)
+	}
+	authData, ok := object["authData"].([]byte)
+	if !ok {
+		return nil, fmt.Errorf("%w: attestation object lacks authenticator data", ErrInvalidResponse)
+	}
+
+	auth, err := ParseAuthenticatorData(authData)
+	if err != nil {
+		return nil, err
+	}
+	if auth.CredentialID == nil {
+		return nil, fmt.Errorf("%w: registration lacks attested credential data", ErrInvalidResponse)
+	}
+	return auth, nil
+}
+
+// Verify checks that the ceremony was made for rpID with the user present, and verified if requireUV is set
+func (a *AuthenticatorData) Verify(rpID string, requireUV bool) error {
+	expected := sha256.Sum256([]byte(rpID))
+	if !bytes.Equal(a.RPIDHash, expected[:]) {
+		return fmt.Errorf("%w: relying party mismatch", ErrInvalidResponse)
+	}
+	if a.Flags&FlagUserPresent == 0 {
+		return fmt.Errorf("%w: user not present", ErrInvalidResponse)
+	}
+	if requireUV && a.Flags&FlagUserVerified == 0 {
+		return fmt.Errorf("%w: user not verified", ErrInvalidResponse)
+	}
+	return nil
+}
+
+// ParsePublicKey decodes a COSE_Key of one of the SupportedAlgorithms
+func ParsePublicKey(coseKey []byte) (crypto.PublicKey, error) {
+	value, _, err := decodeCBOR(coseKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: public key: %v", ErrInvalidResponse, err)
+	}
+	key, ok := value.(map[interface{}]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: public key is not a map", ErrInvalidResponse)
+	}
+	param := func(label int64) []byte {
+		b, _ := key[label].([]byte)
+		return b
+	}
+	alg, _ := key[int64(3)].(int64)
+
+	switch alg {
+	case AlgES256:
+		x, y := param(-2), param(-3)
+		if crv, _ := key[int64(-1)].(int64); crv != 1 || len(x) != 32 || len(y) != 32 {
+			return nil, fmt.Errorf("%w: malformed P-256 key", ErrInvalidResponse)
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, fmt.Errorf("%w: point is not on P-256", ErrInvalidResponse)
+		}
+		return pub, nil
+	case AlgEdDSA:
+		x := param(-2)
+		if crv, _ := key[int64(-1)].(int64); crv != 6 || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("%w: malformed Ed25519 key", ErrInvalidResponse)
+		}
+		return ed25519.PublicKey(x), nil
+	case AlgRS256:
+		n, e := param(-1), param(-2)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("%w: malformed or short RSA key", ErrInvalidResponse)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	}
+	return nil, fmt.Errorf("%w: unsupported key algorithm %d", ErrInvalidResponse, alg)
+}
+
+// VerifySignature checks an assertion signature over the authenticator data and the client data hash
+func VerifySignature(coseKey, authData, clientDataJSON, signature []byte) error {
+	pub, err := ParsePublicKey(coseKey)
+	if err != nil {
+		return err
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte(nil), authData...), clientDataHash[:]...)
+
+	valid := false
+	switch key := pub.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(signed)
+		valid = ecdsa.VerifyASN1(key, digest[:], signature)
+	case ed25519.PublicKey:
+		valid = ed25519.Verify(key, signed, signature)
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(signed)
+		valid = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil
+	}
+	if !valid {
+		return fmt.Errorf("%w: bad signature", ErrInvalidResponse)
+	}
+	return nil
+}
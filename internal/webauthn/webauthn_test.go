@@ -0,0 +1,133 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// es256Key returns the COSE_Key of the P-256 base point: {1: 2, 3: -7, -1: 1, -2: x, -3: y}
+func es256Key() []byte {
+	params := elliptic.P256().Params()
+	key := []byte{0xa5, 0x01, 0x02, 0x03, 0x26, 0x20, 0x01, 0x21, 0x58, 0x20}
+	key = append(key, params.Gx.FillBytes(make([]byte, 32))...)
+	key = append(key, 0x22, 0x58, 0x20)
+	return append(key, params.Gy.FillBytes(make([]byte, 32))...)
+}
+
+// registrationAuthData returns authenticator data with attested credential data for coseKey
+func registrationAuthData(rpID string, credentialID, coseKey []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	data := append([]byte{}, rpIDHash[:]...)
+	data = append(data, FlagUserPresent|FlagAttestedData, 0, 0, 0, 1)
+	data = append(data, make([]byte, 16)...) // AAGUID
+	data = binary.BigEndian.AppendUint16(data, uint16(len(credentialID)))
+	data = append(data, credentialID...)
+	return append(data, coseKey...)
+}
+
+// attestationObject returns a "none" attestation object: {"fmt": "none", "attStmt": {}, "authData": authData}
+func attestationObject(authData []byte) []byte {
+	object := []byte{0xa3, 0x63, 'f', 'm', 't', 0x64, 'n', 'o', 'n', 'e'}
+	object = append(object, 0x67, 'a', 't', 't', 'S', 't', 'm', 't', 0xa0)
+	object = append(object, 0x68, 'a', 'u', 't', 'h', 'D', 'a', 't', 'a', 0x59)
+	object = binary.BigEndian.AppendUint16(object, uint16(len(authData)))
+	return append(object, authData...)
+}
+
+func TestParseAttestationObject(t *testing.T) {
+	credentialID := bytes.Repeat([]byte{0xc1}, 16)
+	auth, err := ParseAttestationObject(attestationObject(registrationAuthData("example.com", credentialID, es256Key())))
+	if err != nil {
+		t.Fatalf("ParseAttestationObject: %v", err)
+	}
+	if !bytes.Equal(auth.CredentialID, credentialID) {
+		t.Errorf("CredentialID = %x, want %x", auth.CredentialID, credentialID)
+	}
+	if !bytes.Equal(auth.PublicKey, es256Key()) {
+		t.Errorf("PublicKey = %x, want %x", auth.PublicKey, es256Key())
+	}
+	if auth.SignCount != 1 {
+		t.Errorf("SignCount = %d, want 1", auth.SignCount)
+	}
+	if err := auth.Verify("example.com", false); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+	if err := auth.Verify("example.org", false); !errors.Is(err, ErrInvalidResponse) {
+		t.Errorf("Verify of another relying party: error = %v, want ErrInvalidResponse", err)
+	}
+	if err := auth.Verify("example.com", true); !errors.Is(err, ErrInvalidResponse) {
+		t.Errorf("Verify without user verification: error = %v, want ErrInvalidResponse", err)
+	}
+}
+
+func TestParseAttestationObjectRejectsMalformedInput(t *testing.T) {
+	credentialID := bytes.Repeat([]byte{0xc1}, 16)
+	authData := registrationAuthData("example.com", credentialID, es256Key())
+
+	offCurve := es256Key()
+	offCurve[len(offCurve)-1] ^= 1
+	unsupported := []byte{0xa1, 0x03, 0x39, 0x01, 0x00} // {3: -257} without the RSA parameters
+	longID := append([]byte{}, authData...)
+	binary.BigEndian.PutUint16(longID[53:55], 0xffff)
+	assertion := append([]byte{}, authData[:37]...)
+	assertion[32] = FlagUserPresent
+
+	tests := map[string][]byte{
+		"empty":                      nil,
+		"not a map":                  {0x80},
+		"no authData":                {0xa1, 0x63, 'f', 'm', 't', 0x64, 'n', 'o', 'n', 'e'},
+		"authData is text":           {0xa1, 0x68, 'a', 'u', 't', 'h', 'D', 'a', 't', 'a', 0x61, 'x'},
+		"short authData":             attestationObject(authData[:36]),
+		"short attested data":        attestationObject(authData[:37+17]),
+		"credential ID beyond data":  attestationObject(longID),
+		"missing public key":         attestationObject(authData[:37+18+len(credentialID)]),
+		"public key not on curve":    attestationObject(registrationAuthData("example.com", credentialID, offCurve)),
+		"public key not a map":       attestationObject(registrationAuthData("example.com", credentialID, []byte{0x80})),
+		"unsupported key":            attestationObject(registrationAuthData("example.com", credentialID, unsupported)),
+		"no attested credential":     attestationObject(assertion),
+		"indefinite length authData": {0xa1, 0x68, 'a', 'u', 't', 'h', 'D', 'a', 't', 'a', 0x5f, 0x41, 0x00, 0xff},
+	}
+	for name, input := range tests {
+		if _, err := ParseAttestationObject(input); !errors.Is(err, ErrInvalidResponse) {
+			t.Errorf("%s: error = %v, want ErrInvalidResponse", name, err)
+		}
+	}
+}
+
+// Every truncation and single-bit corruption of a valid attestation object has to be rejected or
+// parsed, never panic
+func TestParseAttestationObjectCorruptions(t *testing.T) {
+	valid := attestationObject(registrationAuthData("example.com", bytes.Repeat([]byte{0xc1}, 16), es256Key()))
+
+	for i := 0; i < len(valid); i++ {
+		if _, err := ParseAttestationObject(valid[:i]); !errors.Is(err, ErrInvalidResponse) {
+			t.Errorf("truncated to %d bytes: error = %v, want ErrInvalidResponse", i, err)
+		}
+	}
+
+	corrupted := make([]byte, len(valid))
+	for i := 0; i < len(valid)*8; i++ {
+		copy(corrupted, valid)
+		corrupted[i/8] ^= 1 << (i % 8)
+		_, _ = ParseAttestationObject(corrupted)
+	}
+}
+
+func TestParsePublicKeyRejectsMalformedKeys(t *testing.T) {
+	tests := map[string][]byte{
+		"empty":             nil,
+		"no algorithm":      {0xa1, 0x01, 0x02},
+		"P-256 without y":   append([]byte{0xa4}, es256Key()[1:len(es256Key())-35]...),
+		"Ed25519 short key": {0xa4, 0x01, 0x01, 0x03, 0x27, 0x20, 0x06, 0x21, 0x41, 0x00},
+		"RSA short modulus": {0xa3, 0x03, 0x39, 0x01, 0x00, 0x20, 0x41, 0x01, 0x21, 0x43, 0x01, 0x00, 0x01},
+	}
+	for name, input := range tests {
+		if _, err := ParsePublicKey(input); !errors.Is(err, ErrInvalidResponse) {
+			t.Errorf("%s: error = %v, want ErrInvalidResponse", name, err)
+		}
+	}
+}
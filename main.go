@@ -1,15 +1,31 @@
 package main
 
 import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"net/url"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/alerting"
+	"github.com/helioschat/sync/internal/blobstore"
+	"github.com/helioschat/sync/internal/clock"
 	"github.com/helioschat/sync/internal/config"
 	"github.com/helioschat/sync/internal/database"
 	"github.com/helioschat/sync/internal/handlers"
+	"github.com/helioschat/sync/internal/metrics"
 	"github.com/helioschat/sync/internal/middleware"
 	"github.com/helioschat/sync/internal/services"
+	"github.com/helioschat/sync/internal/types"
 	"github.com/joho/godotenv"
 )
 
@@ -22,23 +38,343 @@ func main() {
 	// Initialize configuration
 	cfg := config.Load()
 
+	// Every service tells the time from the same clock
+	clk := clock.System
+
 	// Initialize database
-	db, err := database.NewRedisClient(cfg.RedisURL, cfg.RedisPassword, cfg.RedisDB)
-	if err != nil {
-		log.Fatal("Failed to connect to Redis:", err)
+	retryPolicy := database.RetryPolicy{
+		Attempts:   cfg.RedisRetryAttempts,
+		Backoff:    time.Duration(cfg.RedisRetryBackoffMs) * time.Millisecond,
+		MaxBackoff: time.Duration(cfg.RedisRetryMaxBackoffMs) * time.Millisecond,
+	}
+	compression := database.CompressionPolicy{
+		Algorithm: cfg.StorageCompression,
+		MinBytes:  cfg.StorageCompressionMinBytes,
+	}
+
+	var db database.Backend
+	var memoryMonitor *database.MemoryMonitor
+	switch cfg.StorageBackend {
+	case "bolt":
+		store, err := database.NewBoltStore(cfg.BoltPath, compression)
+		if err != nil {
+			log.Fatal("Failed to open embedded database:", err)
+		}
+		db = store
+	case "redis":
+		redisClient, err := database.NewRedisClient(cfg.RedisURL, cfg.RedisPassword, cfg.RedisDB, time.Duration(cfg.RedisOpTimeoutMs)*time.Millisecond, retryPolicy, compression, database.DurabilityPolicy{
+			LocalFsync: cfg.RedisDurabilityFsync,
+			Replicas:   cfg.RedisDurabilityReplicas,
+			Timeout:    time.Duration(cfg.RedisDurabilityTimeoutMs) * time.Millisecond,
+		})
+		if err != nil {
+			log.Fatal("Failed to connect to Redis:", err)
+		}
+		db = redisClient
+
+		// An evicting Redis would silently delete user chats under memory pressure
+		if err := redisClient.VerifyNoEviction(context.Background()); err != nil {
+			if !cfg.RedisAllowEviction {
+				log.Fatal("Unsafe Redis configuration (set REDIS_ALLOW_EVICTION=true to override): ", err)
+			}
+			log.Println("Warning: unsafe Redis configuration:", err)
+		}
+
+		// Monitor Redis memory usage
+		memoryMonitor = database.NewMemoryMonitor(redisClient, cfg.RedisMemoryThreshold)
+		go memoryMonitor.Run(time.Duration(cfg.RedisMemoryCheckInterval) * time.Second)
+	default:
+		log.Fatalf("Unknown storage backend %q (available: redis, bolt)", cfg.StorageBackend)
 	}
 	defer db.Close()
 
+	// Write durability: what writes asking for (or defaulting to) ?durability=flush wait for
+	durability := types.DurabilityLevels{
+		Default:   cfg.DurabilityDefault,
+		Supported: database.SupportedDurability(db),
+	}
+	if !slices.Contains(durability.Supported, durability.Default) {
+		log.Fatalf("DURABILITY_DEFAULT %q is not supported by the %s backend (supported: %s)", durability.Default, cfg.StorageBackend, strings.Join(durability.Supported, ", "))
+	}
+
+	// Background job leases and in-flight operations, shared with the other instances
+	jobs := services.NewJobCoordinator(db, clk)
+
+	// Initialize archival of cold threads (optional)
+	var archiveService *services.ArchiveService
+	if cfg.ArchiveAfterMonths > 0 {
+		store, err := blobstore.NewS3Store(cfg.S3Endpoint, cfg.S3Region, cfg.S3Bucket, cfg.S3AccessKey, cfg.S3SecretKey, cfg.S3UseSSL)
+		if err != nil {
+			log.Fatal("Failed to initialize archival store:", err)
+		}
+		archiveService = services.NewArchiveService(db, store, cfg.ArchiveAfterMonths, jobs, clk)
+		go archiveService.Run(time.Duration(cfg.ArchiveIntervalMinutes) * time.Minute)
+	}
+
 	// Initialize services
-	authService := services.NewAuthService(cfg.JWTSecret, db) // Added db argument
-	syncService := services.NewSyncService(db)
+	var privateKey []byte
+	if cfg.JWTSigningMethod != "HS256" {
+		key, err := os.ReadFile(cfg.JWTPrivateKeyFile)
+		if err != nil {
+			log.Fatal("Failed to read JWT private key:", err)
+		}
+		privateKey = key
+	}
+	signer, err := services.NewTokenSigner(cfg.JWTSigningMethod, cfg.JWTSecret, privateKey)
+	if err != nil {
+		log.Fatal("Failed to initialize token signing:", err)
+	}
+	hashParams := types.Argon2Params{
+		Time:    uint32(cfg.Argon2Time),
+		Memory:  uint32(cfg.Argon2MemoryKB),
+		Threads: uint8(cfg.Argon2Threads),
+	}
+	if err := services.ValidateArgon2Params(hashParams); err != nil {
+		log.Fatal("Invalid Argon2 configuration: ", err)
+	}
+	lockoutPolicy := services.LockoutPolicy{
+		WalletThreshold: cfg.LoginLockoutThreshold,
+		IPThreshold:     cfg.LoginIPLockoutThreshold,
+		BaseLockout:     time.Duration(cfg.LoginLockoutBaseSeconds) * time.Second,
+		MaxLockout:      time.Duration(cfg.LoginLockoutMaxMinutes) * time.Minute,
+		FailureWindow:   time.Duration(cfg.LoginFailureWindowMins) * time.Minute,
+	}
+	machinePolicy := services.MachinePolicy{
+		RequireRegistration: cfg.RequireRegisteredMachines,
+		RequireSignatures:   cfg.RequireRequestSignatures,
+	}
+	passphrasePolicy, err := loadPassphrasePolicy(cfg)
+	if err != nil {
+		log.Fatal("Failed to load passphrase policy: ", err)
+	}
+	sealer, err := loadSealer(cfg.MetadataEncryptionKey)
+	if err != nil {
+		log.Fatal("Invalid metadata encryption key: ", err)
+	}
+	totpSealer, err := loadSealer(cfg.TOTPEncryptionKey)
+	if err != nil {
+		log.Fatal("Invalid TOTP encryption key: ", err)
+	}
+	authService := services.NewAuthService(signer, db, machinePolicy, cfg.PublicURL, hashParams, lockoutPolicy, passphrasePolicy, sealer, totpSealer, clk) // Added db argument
+	syncService := services.NewSyncService(db, archiveService, types.Quotas{
+		Threads:  cfg.QuotaMaxThreads,
+		Messages: cfg.QuotaMaxMessages,
+		Memories: cfg.QuotaMaxMemories,
+
+		MessagesPerThread: cfg.QuotaMaxMessagesPerThread,
+		AttachmentBytes:   cfg.QuotaMaxAttachmentBytes,
+	}, sealer, clk)
+
+	// Initialize attachment storage (optional)
+	switch cfg.AttachmentStore {
+	case "off":
+	case "fs":
+		store, err := blobstore.NewFSStore(cfg.AttachmentDir)
+		if err != nil {
+			log.Fatal("Failed to initialize attachment store:", err)
+		}
+		syncService.UseAttachmentStore(store, cfg.AttachmentMaxBytes)
+	case "s3":
+		store, err := blobstore.NewS3Store(cfg.S3Endpoint, cfg.S3Region, cfg.S3Bucket, cfg.S3AccessKey, cfg.S3SecretKey, cfg.S3UseSSL)
+		if err != nil {
+			log.Fatal("Failed to initialize attachment store:", err)
+		}
+		syncService.UseAttachmentStore(store, cfg.AttachmentMaxBytes)
+	default:
+		log.Fatalf("Unknown attachment store %q (available: off, fs, s3)", cfg.AttachmentStore)
+	}
+
+	syncService.UseDraftTTL(time.Duration(cfg.DraftTTLHours) * time.Hour)
+
+	// Delete attachments no message references anymore (optional)
+	var attachmentCollector *services.AttachmentCollector
+	if syncService.AttachmentsEnabled() && cfg.AttachmentGCIntervalMinutes > 0 {
+		attachmentCollector = services.NewAttachmentCollector(syncService, jobs, time.Duration(cfg.AttachmentGCGraceHours)*time.Hour)
+		go attachmentCollector.Run(time.Duration(cfg.AttachmentGCIntervalMinutes) * time.Minute)
+	}
+
+	conflicts := types.ConflictStrategies{
+		Threads:  cfg.ThreadConflictStrategy,
+		Settings: cfg.SettingsConflictStrategy,
+	}
+	if !services.ValidConflictStrategy(conflicts.Threads) || !services.ValidConflictStrategy(conflicts.Settings) {
+		log.Fatalf("Unknown conflict strategy: THREAD_CONFLICT_STRATEGY %q, SETTINGS_CONFLICT_STRATEGY %q (available: %s, %s)", conflicts.Threads, conflicts.Settings, types.ConflictReject, types.ConflictLastWriterWins)
+	}
+	syncService.UseConflictStrategies(conflicts)
+
+	eraser := services.NewAccountEraser(authService, syncService, jobs)
+	merger := services.NewAccountMerger(authService, syncService, eraser, jobs)
+	batches := services.NewBatchApplier(syncService)
+	feed := services.NewChangeFeed(syncService)
+
+	var auditService *services.AuditService
+	if cfg.AuditMode != "off" {
+		auditService = services.NewAuditService(db, cfg.AuditMode == "store", cfg.AuditMaxEntries)
+	}
+
+	// Move messages from the legacy key-per-message layout into per-thread hashes
+	if migrated, err := syncService.MigrateMessageLayout(context.Background()); err != nil {
+		log.Fatal("Failed to migrate message storage layout:", err)
+	} else if migrated > 0 {
+		log.Printf("Migrated %d messages to per-thread hashes", migrated)
+	}
+
+	// Index existing threads so enumeration doesn't rely on keyspace scans
+	if indexed, err := syncService.BuildThreadIndexes(context.Background()); err != nil {
+		log.Fatal("Failed to build thread indexes:", err)
+	} else if indexed > 0 {
+		log.Printf("Indexed %d existing threads", indexed)
+	}
+
+	// Record who owns existing thread IDs, so messages are only served to their owner
+	if claimed, err := syncService.BuildThreadOwners(context.Background()); err != nil {
+		log.Fatal("Failed to record thread owners:", err)
+	} else if claimed > 0 {
+		log.Printf("Recorded owners of %d existing threads", claimed)
+	}
+
+	// Index existing messages per user so syncs never scan other users' data
+	if indexed, err := syncService.BuildMessageIndexes(context.Background()); err != nil {
+		log.Fatal("Failed to build message indexes:", err)
+	} else if indexed > 0 {
+		log.Printf("Indexed %d existing messages", indexed)
+	}
+
+	// Count the messages of existing threads, which the server can't read from the encrypted threads
+	if counted, err := syncService.BuildMessageCounts(context.Background()); err != nil {
+		log.Fatal("Failed to count messages of threads:", err)
+	} else if counted > 0 {
+		log.Printf("Counted the messages of %d existing threads", counted)
+	}
+
+	// Operator commands run once against the configured Redis and exit
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1], os.Args[2:], authService, syncService, merger); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Release what crashed instances left locked and finish or roll back their interrupted operations
+	if err := jobs.Heartbeat(context.Background()); err != nil {
+		log.Fatal("Failed to register instance: ", err)
+	}
+	if released, recovered, err := jobs.Recover(context.Background()); err != nil {
+		log.Println("Warning: recovery of crashed instances failed:", err)
+	} else if released > 0 || recovered > 0 {
+		log.Printf("Released %d stale leases and recovered %d interrupted operations", released, recovered)
+	}
+	go jobs.Run()
+
+	// Metrics
+	registry := metrics.NewRegistry()
+	errorRate := alerting.NewErrorRate()
+	registerMetrics(registry, memoryMonitor, syncService, errorRate)
+
+	// Operator alerts
+	alerter := alerting.NewAlerter(alertSinks(cfg), cfg.PublicURL, time.Duration(cfg.AlertRepeatInterval)*time.Minute)
+	if alerter.Enabled() {
+		registerAlerts(alerter, cfg, db, memoryMonitor, archiveService, attachmentCollector, errorRate)
+		go alerter.Run(time.Duration(cfg.AlertCheckInterval) * time.Second)
+	}
+
+	encryptionPolicy := types.EncryptionPolicy{
+		CurrentVersion:    cfg.EncryptionCurrentVersion,
+		SupportedVersions: cfg.EncryptionSupportedVersions,
+	}
+
+	// Announcement that the instance is moving (optional)
+	migration, err := loadMigrationNotice(cfg)
+	if err != nil {
+		log.Fatal("Invalid migration announcement: ", err)
+	}
+	if migration != nil {
+		syncService.AnnounceMigration(migration)
+		log.Printf("Announcing migration to %s at %s", migration.TargetURL, migration.MovesAt.Format(time.RFC3339))
+	}
 
 	// Initialize handlers
-	authHandler := handlers.NewAuthHandler(authService)
-	syncHandler := handlers.NewSyncHandler(syncService, authService)
+	authHandler := handlers.NewAuthHandler(authService, syncService, eraser, merger)
+	adminHandler := handlers.NewAdminHandler(authService)
+	syncHandler := handlers.NewSyncHandler(syncService, authService, encryptionPolicy)
+	syncHandler.LimitImportItems(cfg.ImportMaxItemBytes)
+	syncHandler.UseBatches(batches)
+	syncHandler.UseChangeFeed(feed)
+	capabilitiesHandler := handlers.NewCapabilitiesHandler(encryptionPolicy, durability, migration, syncHandler.Features(), syncHandler.Limits())
+	tracer := services.NewDebugTracer(db, clk)
+	debugHandler := handlers.NewDebugHandler(tracer)
+	protocolHandler := handlers.NewProtocolHandler(authService, syncService, map[string]int64{
+		"benchmark_runs_per_hour":    int64(cfg.BenchmarkRateLimit),
+		"demo_wallets_per_ip_hour":   int64(cfg.DemoWalletRateLimit),
+		"demo_wallet_ttl_hours":      int64(cfg.DemoWalletTTLHours),
+		"archive_after_months":       int64(cfg.ArchiveAfterMonths),
+		"encryption_current_version": int64(cfg.EncryptionCurrentVersion),
+	})
+
+	// Wallet creation limits
+	registrationRules, err := services.ParseRegistrationRules(cfg.RegistrationLimits)
+	if err != nil {
+		log.Fatal("Invalid REGISTRATION_LIMITS: ", err)
+	}
+	var asnTable *services.ASNTable
+	if cfg.RegistrationASNDB != "" {
+		file, err := os.Open(cfg.RegistrationASNDB)
+		if err != nil {
+			log.Fatal("Failed to open ASN database: ", err)
+		}
+		asnTable, err = services.LoadASNTable(file)
+		file.Close()
+		if err != nil {
+			log.Fatal("Failed to load ASN database: ", err)
+		}
+		log.Printf("Loaded %d ASN ranges", asnTable.Len())
+	}
+	registrationThrottle := services.NewRegistrationThrottle(db, registrationRules, asnTable, clk)
+
+	// Passkey (WebAuthn) login (optional)
+	var passkeyHandler *handlers.PasskeyHandler
+	if cfg.WebAuthnRPID != "" {
+		if len(cfg.WebAuthnOrigins) == 0 {
+			log.Fatal("WEBAUTHN_ORIGINS is required when passkeys are enabled")
+		}
+		passkeyService := services.NewPasskeyService(authService, services.PasskeyPolicy{
+			RPID:    cfg.WebAuthnRPID,
+			RPName:  cfg.WebAuthnRPName,
+			Origins: cfg.WebAuthnOrigins,
+		})
+		passkeyHandler = handlers.NewPasskeyHandler(passkeyService)
+	}
+
+	// OPAQUE login (optional)
+	var opaqueHandler *handlers.OpaqueHandler
+	if cfg.OpaqueServerKey != "" {
+		secret, err := base64.StdEncoding.DecodeString(cfg.OpaqueServerKey)
+		if err != nil {
+			log.Fatal("Invalid OPAQUE server key: ", err)
+		}
+		opaqueService, err := services.NewOpaqueService(authService, secret)
+		if err != nil {
+			log.Fatal("Invalid OPAQUE server key: ", err)
+		}
+		if cfg.OpaqueExclusive {
+			authService.RequireOpaqueLogin()
+		}
+		opaqueHandler = handlers.NewOpaqueHandler(opaqueService)
+	}
+
+	// Auto-expiring demo wallets (optional)
+	var demoHandler *handlers.DemoHandler
+	if cfg.DemoWalletTTLHours > 0 {
+		demoService := services.NewDemoService(authService, eraser, jobs, time.Duration(cfg.DemoWalletTTLHours)*time.Hour)
+		go demoService.Run(time.Duration(cfg.DemoCleanupMinutes) * time.Minute)
+		demoHandler = handlers.NewDemoHandler(demoService)
+	}
+
+	// Discovery document for clients pointed at this instance's domain
+	discoveryHandler := handlers.NewDiscoveryHandler(discoveryDocument(cfg, encryptionPolicy, durability, conflicts, migration, passphrasePolicy, registrationRules, totpSealer != nil, passkeyHandler != nil, opaqueHandler != nil))
 
 	// Setup router
-	router := setupRouter(cfg, authHandler, syncHandler)
+	router := setupRouter(cfg, registry, errorRate, db, durability, memoryMonitor, auditService, authHandler, adminHandler, syncHandler, capabilitiesHandler, protocolHandler, discoveryHandler, debugHandler, tracer, passkeyHandler, opaqueHandler, demoHandler, registrationThrottle, clk)
 
 	// Start server
 	port := os.Getenv("PORT")
@@ -52,13 +388,15 @@ func main() {
 	}
 }
 
-func setupRouter(cfg *config.Config, authHandler *handlers.AuthHandler, syncHandler *handlers.SyncHandler) *gin.Engine {
+func setupRouter(cfg *config.Config, registry *metrics.Registry, errorRate *alerting.ErrorRate, db database.Backend, durability types.DurabilityLevels, memoryMonitor *database.MemoryMonitor, auditService *services.AuditService, authHandler *handlers.AuthHandler, adminHandler *handlers.AdminHandler, syncHandler *handlers.SyncHandler, capabilitiesHandler *handlers.CapabilitiesHandler, protocolHandler *handlers.ProtocolHandler, discoveryHandler *handlers.DiscoveryHandler, debugHandler *handlers.DebugHandler, tracer *services.DebugTracer, passkeyHandler *handlers.PasskeyHandler, opaqueHandler *handlers.OpaqueHandler, demoHandler *handlers.DemoHandler, registrationThrottle *services.RegistrationThrottle, clk clock.Clock) *gin.Engine {
 	if cfg.GinMode == "release" {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
 	router := gin.New()
+	protocolHandler.UseRoutes(router.Routes)
 	router.Use(gin.Logger())
+	router.Use(middleware.TrackErrors(errorRate))
 	router.Use(gin.Recovery())
 	router.Use(middleware.CORS(cfg.CORSOrigins))
 
@@ -67,25 +405,130 @@ func setupRouter(cfg *config.Config, authHandler *handlers.AuthHandler, syncHand
 		c.JSON(200, gin.H{"status": "ok"})
 	})
 
+	// Prometheus metrics
+	router.GET("/metrics", registry.Handler(cfg.MetricsToken))
+
+	// Public token verification keys (empty unless tokens are signed asymmetrically)
+	router.GET("/.well-known/jwks.json", authHandler.GetJWKS)
+
+	// Instance discovery: API base, capabilities and registration policy
+	router.GET("/.well-known/helios-sync.json", discoveryHandler.GetDiscovery)
+
 	// API versioning
 	v1 := router.Group("/api/v1")
+	if auditService != nil {
+		v1.Use(middleware.Audit(auditService))
+	}
+	v1.Use(middleware.Durability(db, durability))
 	{
-		// Authentication endpoints
+		v1.GET("/capabilities", capabilitiesHandler.GetCapabilities)
+
+		// Protocol description generated from this instance's routes, rules and limits, and the JSON
+		// Schema of the sync types
+		v1.GET("/protocol", protocolHandler.GetProtocol)
+		v1.GET("/schema", protocolHandler.GetSchema)
+
+		// Authentication endpoints. Requests naming a wallet answer no faster than AuthMinResponseMs,
+		// so timings don't tell which wallets exist.
+		padAuth := middleware.PadResponseTime(time.Duration(cfg.AuthMinResponseMs) * time.Millisecond)
 		auth := v1.Group("/auth")
 		{
-			auth.POST("/generate-wallet", authHandler.GenerateWallet)
-			auth.POST("/login", authHandler.Login)
+			auth.POST("/generate-wallet", padAuth, middleware.RejectWritesUnderMemoryPressure(memoryMonitor), middleware.ThrottleRegistrations(registrationThrottle), authHandler.GenerateWallet)
+			auth.POST("/login", padAuth, authHandler.Login)
+			if demoHandler != nil {
+				auth.POST("/demo-wallet", padAuth, middleware.RejectWritesUnderMemoryPressure(memoryMonitor), middleware.RateLimit(middleware.NewRateLimiter(cfg.DemoWalletRateLimit, time.Hour, clk)), middleware.ThrottleRegistrations(registrationThrottle), demoHandler.CreateDemoWallet)
+			}
 			auth.POST("/refresh", authHandler.RefreshToken)
+			auth.POST("/logout", middleware.RequireAuth(authHandler.AuthService), authHandler.Logout)
+			auth.GET("/sessions", middleware.RequireAuth(authHandler.AuthService), authHandler.ListSessions)
+			auth.GET("/wallet", middleware.RequireAuth(authHandler.AuthService), authHandler.GetWallet)
+			auth.DELETE("/wallet", middleware.RequireAuth(authHandler.AuthService), authHandler.DeleteWallet)
+			auth.GET("/final-export/:token", authHandler.GetFinalExport)
+			auth.POST("/wallet/merge", middleware.RequireAuth(authHandler.AuthService), authHandler.MergeAccount)
+			auth.POST("/wallet/rotate", middleware.RequireAuth(authHandler.AuthService), authHandler.RotateWallet)
+
+			// Passphrase recovery
+			auth.POST("/recovery-code", middleware.RequireAuth(authHandler.AuthService), authHandler.IssueRecoveryCode)
+			auth.POST("/reset-passphrase", padAuth, authHandler.ResetPassphrase)
+
+			// TOTP second factor
+			auth.POST("/totp", middleware.RequireAuth(authHandler.AuthService), authHandler.EnrollTOTP)
+			auth.POST("/totp/confirm", middleware.RequireAuth(authHandler.AuthService), authHandler.ConfirmTOTP)
+			auth.DELETE("/totp", middleware.RequireAuth(authHandler.AuthService), authHandler.DisableTOTP)
+
+			// Passkey (WebAuthn) login as an alternative to the passphrase
+			if passkeyHandler != nil {
+				auth.POST("/passkeys/login/begin", passkeyHandler.BeginLogin)
+				auth.POST("/passkeys/login/finish", padAuth, passkeyHandler.FinishLogin)
+				auth.GET("/passkeys", middleware.RequireAuth(authHandler.AuthService), passkeyHandler.ListPasskeys)
+				auth.POST("/passkeys/register/begin", middleware.RequireAuth(authHandler.AuthService), passkeyHandler.BeginRegistration)
+				auth.POST("/passkeys/register/finish", middleware.RequireAuth(authHandler.AuthService), passkeyHandler.FinishRegistration)
+				auth.DELETE("/passkeys/:id", middleware.RequireAuth(authHandler.AuthService), passkeyHandler.DeletePasskey)
+			}
+
+			// OPAQUE login and wallet creation, where the passphrase never leaves the client
+			if opaqueHandler != nil {
+				auth.POST("/opaque/login/begin", padAuth, opaqueHandler.BeginLogin)
+				auth.POST("/opaque/login/finish", padAuth, opaqueHandler.FinishLogin)
+				auth.POST("/opaque/signup/begin", padAuth, opaqueHandler.BeginSignup)
+				auth.POST("/opaque/signup/finish", padAuth, middleware.RejectWritesUnderMemoryPressure(memoryMonitor), middleware.ThrottleRegistrations(registrationThrottle), opaqueHandler.FinishSignup)
+				auth.POST("/opaque/register/begin", middleware.RequireAuth(authHandler.AuthService), opaqueHandler.BeginRegistration)
+				auth.POST("/opaque/register/finish", middleware.RequireAuth(authHandler.AuthService), opaqueHandler.FinishRegistration)
+				auth.DELETE("/opaque", middleware.RequireAuth(authHandler.AuthService), opaqueHandler.DeleteRegistration)
+			}
+
+			// Device registry
+			auth.GET("/machines", middleware.RequireAuth(authHandler.AuthService), authHandler.ListMachines)
+			auth.POST("/machines", middleware.RequireAuth(authHandler.AuthService), authHandler.RegisterMachine)
+			auth.PATCH("/machines/:id", middleware.RequireAuth(authHandler.AuthService), authHandler.RenameMachine)
+			auth.POST("/machines/:id/deactivate", middleware.RequireAuth(authHandler.AuthService), authHandler.DeactivateMachine)
+			auth.DELETE("/machines/:id", middleware.RequireAuth(authHandler.AuthService), authHandler.SignOutMachine)
+
+			// Read-only guest tokens for support/debugging
+			auth.GET("/guest-tokens", middleware.RequireAuth(authHandler.AuthService), authHandler.ListGuestTokens)
+			auth.POST("/guest-tokens", middleware.RequireAuth(authHandler.AuthService), authHandler.CreateGuestToken)
+
+			// Access tokens limited to some scopes, e.g. read-only tokens for dashboards and export tools
+			auth.GET("/scoped-tokens", middleware.RequireAuth(authHandler.AuthService), authHandler.ListScopedTokens)
+			auth.POST("/scoped-tokens", middleware.RequireAuth(authHandler.AuthService), authHandler.CreateScopedToken)
+			auth.DELETE("/scoped-tokens/:id", middleware.RequireAuth(authHandler.AuthService), authHandler.RevokeScopedToken)
+		}
+
+		// Operator endpoints, reachable with admin tokens only
+		admin := v1.Group("/admin")
+		admin.Use(middleware.RequireAdmin(authHandler.AuthService))
+		{
+			admin.GET("/token", adminHandler.GetToken)
+			admin.DELETE("/token", adminHandler.RevokeToken)
 		}
 
+		// Realtime sync socket; browsers pass their token as a WebSocket subprotocol
+		v1.GET("/sync/ws", middleware.WebSocketBearer(), middleware.RequireAuth(authHandler.AuthService), syncHandler.SyncSocket)
+
 		// Protected sync endpoints
 		sync := v1.Group("/sync")
 		sync.Use(middleware.RequireAuth(authHandler.AuthService))
+		sync.Use(middleware.TraceRequests(tracer))
+		sync.Use(middleware.VerifySignatures(authHandler.AuthService))
+		sync.Use(middleware.RejectWritesUnderMemoryPressure(memoryMonitor))
 		{
+			// Startup data in a single request
+			sync.GET("/bootstrap", syncHandler.GetBootstrap)
+
+			// Stored record counts against per-user limits
+			sync.GET("/usage", syncHandler.GetUsage)
+
+			// Storage latency report for the client's server health screen
+			sync.POST("/benchmark", middleware.RateLimit(middleware.NewRateLimiter(cfg.BenchmarkRateLimit, time.Hour, clk)), syncHandler.Benchmark)
+
 			// Thread endpoints
 			sync.GET("/threads", syncHandler.GetThreads)
 			sync.PUT("/threads/:id", syncHandler.UpsertThread)
 			sync.DELETE("/threads/:id", syncHandler.DeleteThread)
+			sync.PUT("/threads/:id/archive", syncHandler.ArchiveThread)
+			sync.DELETE("/threads/:id/archive", syncHandler.UnarchiveThread)
+			sync.POST("/threads/:id/branch", syncHandler.BranchThread)
+			sync.GET("/threads/:id/meta-history", syncHandler.GetThreadMetaHistory)
 
 			// Message endpoints
 			sync.GET("/messages", syncHandler.GetMessages)
@@ -94,18 +537,365 @@ func setupRouter(cfg *config.Config, authHandler *handlers.AuthHandler, syncHand
 			sync.DELETE("/messages/:id", syncHandler.DeleteMessage)
 
 			// User settings endpoints
-			sync.GET("/provider-instances", syncHandler.GetProviderInstances)
-			sync.PUT("/provider-instances", syncHandler.UpdateProviderInstances)
+			sync.GET("/settings-revisions", syncHandler.GetSettingsRevisions)
+
+			for _, settings := range services.SettingsResources {
+				sync.GET(settings.Path(), syncHandler.GetSettings(settings))
+				sync.PUT(settings.Path(), syncHandler.UpdateSettings(settings))
+				if settings.Deletable {
+					sync.DELETE(settings.Path(), syncHandler.DeleteSettings(settings))
+				}
+			}
+
+			// Memory endpoints
+			sync.GET("/memories", syncHandler.GetMemories)
+			sync.PUT("/memories/:id", syncHandler.UpsertMemory)
+			sync.DELETE("/memories/:id", syncHandler.DeleteMemory)
+
+			// Folder endpoints
+			sync.GET("/folders", syncHandler.GetFolders)
+			sync.PUT("/folders/:id", syncHandler.UpsertFolder)
+			sync.DELETE("/folders/:id", syncHandler.DeleteFolder)
+
+			// Draft endpoints, one draft per thread
+			sync.GET("/drafts", syncHandler.GetDrafts)
+			sync.PUT("/drafts/:id", syncHandler.UpsertDraft)
+			sync.DELETE("/drafts/:id", syncHandler.DeleteDraft)
 
-			sync.GET("/disabled-models", syncHandler.GetDisabledModels)
-			sync.PUT("/disabled-models", syncHandler.UpdateDisabledModels)
+			// Read state endpoints, written in batches
+			sync.GET("/read-states", syncHandler.GetReadStates)
+			sync.PUT("/read-states", syncHandler.UpdateReadStates)
 
-			sync.GET("/advanced-settings", syncHandler.GetAdvancedSettings)
-			sync.PUT("/advanced-settings", syncHandler.UpdateAdvancedSettings)
+			// Client-encrypted attachment blobs referenced by messages
+			sync.GET("/attachments", syncHandler.GetAttachments)
+			sync.GET("/attachments/:id", syncHandler.GetAttachment)
+			sync.PUT("/attachments/:id", syncHandler.UploadAttachment)
+			sync.DELETE("/attachments/:id", syncHandler.DeleteAttachment)
 
-			sync.GET("/changes-since/:timestamp", syncHandler.GetChangesSince)
+			sync.GET("/changes-since/:cursor", syncHandler.GetChangesSince)
+			sync.GET("/checkpoint", syncHandler.GetSyncCheckpoint)
+			sync.PUT("/checkpoint", syncHandler.PutSyncCheckpoint)
+
+			// Thread and message writes applied all or nothing
+			sync.POST("/batch", syncHandler.ApplyBatch)
+
+			// Permanent removal of old tombstones and archived threads
+			sync.POST("/purge", syncHandler.Purge)
+
+			// Streaming import of account exports
+			sync.POST("/import", syncHandler.ImportData)
+
+			// Opt-in, time-boxed capture of redacted request envelopes for support investigations
+			sync.GET("/debug/trace", debugHandler.GetTrace)
+			sync.POST("/debug/trace", debugHandler.EnableTrace)
+			sync.DELETE("/debug/trace", debugHandler.DisableTrace)
 		}
 	}
 
 	return router
 }
+
+// runCommand executes an operator command given on the command line
+func runCommand(name string, args []string, authService *services.AuthService, syncService *services.SyncService, merger *services.AccountMerger) error {
+	ctx := context.Background()
+
+	switch name {
+	case "repair-changes":
+		repaired, failed, err := syncService.RepairMissedChanges(ctx)
+		if err != nil {
+			return fmt.Errorf("repair failed: %w", err)
+		}
+		log.Printf("Repaired %d missed changes, %d still failing", repaired, failed)
+		return nil
+	case "list-quarantined":
+		records, err := syncService.GetQuarantinedRecords(ctx)
+		if err != nil {
+			return err
+		}
+		for _, record := range records {
+			log.Printf("%s %s %s (user %s, detected %s): %s", record.Resource, record.Key, record.Field, record.UserID, record.DetectedAt.Format(time.RFC3339), record.Error)
+		}
+		log.Printf("%d quarantined records", len(records))
+		return nil
+	case "drop-quarantined":
+		dropped, released, err := syncService.DropQuarantinedRecords(ctx)
+		if err != nil {
+			return fmt.Errorf("dropping quarantined records failed: %w", err)
+		}
+		log.Printf("Dropped %d unreadable records, released %d that are readable again", dropped, released)
+		return nil
+	case "merge-accounts":
+		// merge-accounts <source UID> <target UID> [keep_target|keep_source|newest] [--dry-run]
+		dryRun := slices.Contains(args, "--dry-run")
+		args = slices.DeleteFunc(args, func(arg string) bool { return arg == "--dry-run" })
+		if len(args) < 2 || len(args) > 3 {
+			return errors.New("usage: merge-accounts <source UID> <target UID> [keep_target|keep_source|newest] [--dry-run]")
+		}
+		sourceID, err := uuid.Parse(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid source UID: %w", err)
+		}
+		targetID, err := uuid.Parse(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid target UID: %w", err)
+		}
+		policy := ""
+		if len(args) == 3 {
+			policy = args[2]
+		}
+
+		report, err := merger.Merge(ctx, sourceID, targetID, policy, dryRun)
+		if err != nil {
+			return fmt.Errorf("merge failed: %w", err)
+		}
+		verb := "Moved"
+		if dryRun {
+			verb = "Dry run: would move"
+		}
+		log.Printf("%s %d threads, %d messages, %d settings, %d memories and %d folders from %s to %s", verb, report.Threads, report.Messages, report.Settings, report.Memories, report.Folders, sourceID, targetID)
+		for _, conflict := range report.Conflicts {
+			log.Printf("Conflict on %s %s: kept the %s copy", conflict.Resource, conflict.ID, conflict.Kept)
+		}
+		return nil
+	case "admin-token":
+		// admin-token <name> [lifetime in hours]
+		if len(args) < 1 || len(args) > 2 {
+			return errors.New("usage: admin-token <name> [lifetime in hours]")
+		}
+		ttl := services.DefaultAdminTokenTTL
+		if len(args) == 2 {
+			hours, err := strconv.Atoi(args[1])
+			if err != nil {
+				return fmt.Errorf("invalid lifetime: %w", err)
+			}
+			ttl = time.Duration(hours) * time.Hour
+		}
+
+		token, err := authService.IssueAdminToken(args[0], ttl)
+		if err != nil {
+			return err
+		}
+		log.Printf("Admin token for %s, valid for %s:", args[0], ttl)
+		fmt.Println(token)
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q (available: repair-changes, list-quarantined, drop-quarantined, merge-accounts, admin-token)", name)
+	}
+}
+
+func registerMetrics(registry *metrics.Registry, memoryMonitor *database.MemoryMonitor, syncService *services.SyncService, errorRate *alerting.ErrorRate) {
+	registry.Counter("helios_http_responses_total", "HTTP responses served", func(ctx context.Context) (float64, error) {
+		requests, _ := errorRate.Totals()
+		return float64(requests), nil
+	})
+	registry.Counter("helios_http_server_errors_total", "HTTP responses with a 5xx status", func(ctx context.Context) (float64, error) {
+		_, errors := errorRate.Totals()
+		return float64(errors), nil
+	})
+
+	if memoryMonitor != nil {
+		registry.Gauge("helios_redis_memory_pressure", "1 if Redis memory usage is above the write threshold", func(ctx context.Context) (float64, error) {
+			if memoryMonitor.UnderPressure() {
+				return 1, nil
+			}
+			return 0, nil
+		})
+	}
+
+	registry.Gauge("helios_quarantined_records", "Stored records that failed to decode and are hidden from clients", func(ctx context.Context) (float64, error) {
+		records, err := syncService.GetQuarantinedRecords(ctx)
+		if err != nil {
+			return 0, err
+		}
+		return float64(len(records)), nil
+	})
+
+	// Change pipeline health
+	pipelineStat := func(pick func(*services.PipelineStats) float64) metrics.Collector {
+		return func(ctx context.Context) (float64, error) {
+			stats, err := syncService.PipelineStats(ctx)
+			if err != nil {
+				return 0, err
+			}
+			return pick(stats), nil
+		}
+	}
+	registry.Counter("helios_pipeline_accepted_writes_total", "Writes accepted by the sync service", pipelineStat(func(s *services.PipelineStats) float64 {
+		return float64(s.AcceptedWrites)
+	}))
+	registry.Counter("helios_pipeline_recorded_changes_total", "Change-log entries written for accepted writes", pipelineStat(func(s *services.PipelineStats) float64 {
+		return float64(s.RecordedChanges)
+	}))
+	registry.Gauge("helios_pipeline_missed_changes", "Accepted writes whose change-log entry is missing", pipelineStat(func(s *services.PipelineStats) float64 {
+		return float64(s.MissedChanges)
+	}))
+	registry.Gauge("helios_pipeline_lag_seconds", "Age of the oldest missed change awaiting repair", pipelineStat(func(s *services.PipelineStats) float64 {
+		return s.Lag.Seconds()
+	}))
+}
+
+// loadPassphrasePolicy builds the passphrase policy, reading the optional deny list file
+func loadPassphrasePolicy(cfg *config.Config) (*services.PassphrasePolicy, error) {
+	var denyList io.Reader
+	if cfg.PassphraseDenyListFile != "" {
+		file, err := os.Open(cfg.PassphraseDenyListFile)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		denyList = file
+	}
+	return services.NewPassphrasePolicy(cfg.PassphraseMinLength, float64(cfg.PassphraseMinEntropyBits), denyList)
+}
+
+// discoveryDocument describes the instance for /.well-known/helios-sync.json
+func discoveryDocument(cfg *config.Config, encryption types.EncryptionPolicy, durability types.DurabilityLevels, conflicts types.ConflictStrategies, migration *types.MigrationNotice, passphrasePolicy *services.PassphrasePolicy, registrationRules []services.RegistrationRule, totp, passkeys, opaque bool) types.Discovery {
+	apiBase := cfg.PublicURL + "/api/v1"
+	discovery := types.Discovery{
+		Issuer:          cfg.PublicURL,
+		APIBase:         apiBase,
+		ProtocolVersion: types.ProtocolVersion,
+		ProtocolURI:     apiBase + "/protocol",
+		SchemaURI:       apiBase + "/schema",
+		JWKSURI:         cfg.PublicURL + "/.well-known/jwks.json",
+		Capabilities: types.DiscoveryCapabilities{
+			Encryption:         encryption,
+			LoginMethods:       []string{"passphrase"},
+			TOTP:               totp,
+			DemoWallets:        cfg.DemoWalletTTLHours > 0,
+			RegisteredMachines: cfg.RequireRegisteredMachines,
+			RequestSignatures:  cfg.RequireRequestSignatures,
+			Durability:         durability,
+			ConflictStrategies: conflicts,
+		},
+		Registration: types.RegistrationPolicy{
+			Open:               true,
+			Limits:             []types.RegistrationLimit{},
+			Methods:            []string{"passphrase"},
+			DemoWalletTTLHours: cfg.DemoWalletTTLHours,
+		},
+		Migration: migration,
+	}
+	if passkeys {
+		discovery.Capabilities.LoginMethods = append(discovery.Capabilities.LoginMethods, "passkey")
+	}
+	if opaque {
+		discovery.Capabilities.LoginMethods = append(discovery.Capabilities.LoginMethods, "opaque")
+		discovery.Capabilities.OpaqueExclusive = cfg.OpaqueExclusive
+		discovery.Registration.Methods = append(discovery.Registration.Methods, "opaque")
+	}
+	if passphrasePolicy != nil {
+		discovery.Registration.PassphraseMinLength = passphrasePolicy.MinLength
+		discovery.Registration.PassphraseMinEntropyBits = passphrasePolicy.MinEntropyBits
+	}
+	for _, rule := range registrationRules {
+		discovery.Registration.Limits = append(discovery.Registration.Limits, types.RegistrationLimit{
+			Scope:         rule.Scope,
+			Limit:         rule.Limit,
+			WindowSeconds: int64(rule.Window.Seconds()),
+		})
+	}
+	return discovery
+}
+
+// loadMigrationNotice returns the configured migration announcement, or nil if none is configured
+func loadMigrationNotice(cfg *config.Config) (*types.MigrationNotice, error) {
+	if cfg.MigrationURL == "" {
+		return nil, nil
+	}
+	target, err := url.Parse(cfg.MigrationURL)
+	if err != nil || (target.Scheme != "https" && target.Scheme != "http") || target.Host == "" {
+		return nil, fmt.Errorf("MIGRATION_URL must be an absolute http(s) URL, got %q", cfg.MigrationURL)
+	}
+	if cfg.MigrationDate == "" {
+		return nil, errors.New("MIGRATION_DATE is required with MIGRATION_URL")
+	}
+	movesAt, err := time.Parse(time.RFC3339, cfg.MigrationDate)
+	if err != nil {
+		if movesAt, err = time.Parse(time.DateOnly, cfg.MigrationDate); err != nil {
+			return nil, fmt.Errorf("MIGRATION_DATE must be RFC 3339 or YYYY-MM-DD, got %q", cfg.MigrationDate)
+		}
+	}
+	return &types.MigrationNotice{
+		TargetURL: strings.TrimSuffix(cfg.MigrationURL, "/"),
+		MovesAt:   movesAt.UTC(),
+		Message:   cfg.MigrationMessage,
+	}, nil
+}
+
+// loadSealer returns a sealer for a base64 key, or nil if no key is configured
+func loadSealer(encodedKey string) (*services.MetadataSealer, error) {
+	if encodedKey == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(encodedKey)
+	if err != nil {
+		return nil, err
+	}
+	return services.NewMetadataSealer(key)
+}
+
+// alertSinks returns a sink for every configured alert URL
+func alertSinks(cfg *config.Config) []alerting.Sink {
+	var sinks []alerting.Sink
+	if cfg.AlertWebhookURL != "" {
+		sinks = append(sinks, alerting.NewWebhookSink(cfg.AlertWebhookURL))
+	}
+	if cfg.AlertSlackWebhookURL != "" {
+		sinks = append(sinks, alerting.NewSlackSink(cfg.AlertSlackWebhookURL))
+	}
+	if cfg.AlertNtfyURL != "" {
+		sinks = append(sinks, alerting.NewNtfySink(cfg.AlertNtfyURL))
+	}
+	return sinks
+}
+
+func registerAlerts(alerter *alerting.Alerter, cfg *config.Config, db database.Backend, memoryMonitor *database.MemoryMonitor, archiveService *services.ArchiveService, attachmentCollector *services.AttachmentCollector, errorRate *alerting.ErrorRate) {
+	alerter.Watch(alerting.Condition{
+		Name: "storage_unreachable",
+		Check: func(ctx context.Context) (bool, string) {
+			if _, err := db.Get(ctx, "alerting:probe"); err != nil && !database.IsNotFound(err) {
+				return true, fmt.Sprintf("storage backend is not responding: %v", err)
+			}
+			return false, "storage backend is responding again"
+		},
+	})
+
+	if memoryMonitor != nil {
+		alerter.Watch(alerting.Condition{
+			Name: "storage_full",
+			Check: func(ctx context.Context) (bool, string) {
+				if memoryMonitor.UnderPressure() {
+					return true, fmt.Sprintf("Redis memory usage at %.1f%% is above the write threshold, non-essential writes are refused", memoryMonitor.Usage()*100)
+				}
+				return false, fmt.Sprintf("Redis memory usage at %.1f%% is below the write threshold again", memoryMonitor.Usage()*100)
+			},
+		})
+	}
+
+	alerter.Watch(errorRate.Condition(cfg.AlertErrorRate, cfg.AlertErrorRateMinRequests))
+
+	if archiveService != nil {
+		alerter.Watch(alerting.Condition{
+			Name: "archival_failed",
+			Check: func(ctx context.Context) (bool, string) {
+				if err := archiveService.LastRunError(); err != nil {
+					return true, fmt.Sprintf("thread archival run failed: %v", err)
+				}
+				return false, "thread archival succeeded again"
+			},
+		})
+	}
+
+	if attachmentCollector != nil {
+		alerter.Watch(alerting.Condition{
+			Name: "attachment_gc_failed",
+			Check: func(ctx context.Context) (bool, string) {
+				if err := attachmentCollector.LastRunError(); err != nil {
+					return true, fmt.Sprintf("attachment collection run failed: %v", err)
+				}
+				return false, "attachment collection succeeded again"
+			},
+		})
+	}
+}
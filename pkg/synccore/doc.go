@@ -0,0 +1,15 @@
+// Package synccore exports the sync engine for Go programs that embed it or build their own
+// frontend on it, without forking the module.
+//
+// Everything here is an alias of, or a thin wrapper around, the implementation in the internal
+// packages: the types are identical, so values pass freely between the two, and the sentinel
+// errors are the very values the engine returns, so errors.Is and errors.As work on them.
+//
+// A minimal embedding opens a storage backend and builds the services on it:
+//
+//	db, err := synccore.NewBoltStore("helios.db", synccore.CompressionPolicy{})
+//	signer, err := synccore.NewTokenSigner("HS256", secret, nil)
+//	auth := synccore.NewAuthService(signer, db, synccore.MachinePolicy{}, "https://sync.example.com",
+//		params, synccore.LockoutPolicy{}, nil, nil, nil, synccore.SystemClock)
+//	sync := synccore.NewSyncService(db, nil, synccore.Quotas{}, nil, synccore.SystemClock)
+package synccore
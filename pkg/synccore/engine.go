@@ -0,0 +1,175 @@
+package synccore
+
+import (
+	"io"
+	"time"
+
+	"github.com/helioschat/sync/internal/clock"
+	"github.com/helioschat/sync/internal/services"
+)
+
+// Clock tells the services the time; tests can pass a FakeClock
+type Clock = clock.Clock
+
+// FakeClock is a Clock that only moves when told to
+type FakeClock = clock.Fake
+
+// SystemClock is the wall clock
+var SystemClock = clock.System
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return clock.NewFake(now)
+}
+
+// Services of the engine
+type (
+	AuthService          = services.AuthService
+	SyncService          = services.SyncService
+	AccountEraser        = services.AccountEraser
+	AccountMerger        = services.AccountMerger
+	BatchApplier         = services.BatchApplier
+	JobCoordinator       = services.JobCoordinator
+	ArchiveService       = services.ArchiveService
+	AttachmentCollector  = services.AttachmentCollector
+	AuditService         = services.AuditService
+	DebugTracer          = services.DebugTracer
+	DemoService          = services.DemoService
+	PasskeyService       = services.PasskeyService
+	OpaqueService        = services.OpaqueService
+	RegistrationThrottle = services.RegistrationThrottle
+)
+
+// Keys, policies and their parts
+type (
+	TokenSigner       = services.TokenSigner
+	MetadataSealer    = services.MetadataSealer
+	MachinePolicy     = services.MachinePolicy
+	LockoutPolicy     = services.LockoutPolicy
+	PassphrasePolicy  = services.PassphrasePolicy
+	PasskeyPolicy     = services.PasskeyPolicy
+	RegistrationRule  = services.RegistrationRule
+	ASNTable          = services.ASNTable
+	PendingOperation  = services.PendingOperation
+	QuarantinedRecord = services.QuarantinedRecord
+	PipelineStats     = services.PipelineStats
+	ImportOptions     = services.ImportOptions
+	StoredDocument    = services.StoredDocument
+)
+
+// Conflict policies of an account merge
+const (
+	MergeKeepTarget = services.MergeKeepTarget
+	MergeKeepSource = services.MergeKeepSource
+	MergeNewest     = services.MergeNewest
+)
+
+// NewTokenSigner signs tokens with HS256 and secret, or with RS256 or EdDSA and a PEM private key
+func NewTokenSigner(method, secret string, privateKeyPEM []byte) (*TokenSigner, error) {
+	return services.NewTokenSigner(method, secret, privateKeyPEM)
+}
+
+// NewMetadataSealer encrypts stored metadata with keys derived from masterKey per user
+func NewMetadataSealer(masterKey []byte) (*MetadataSealer, error) {
+	return services.NewMetadataSealer(masterKey)
+}
+
+// NewPassphrasePolicy creates a policy denying common passphrases and those read from denyList (may be nil)
+func NewPassphrasePolicy(minLength int, minEntropyBits float64, denyList io.Reader) (*PassphrasePolicy, error) {
+	return services.NewPassphrasePolicy(minLength, minEntropyBits, denyList)
+}
+
+// ValidateArgon2Params rejects hashing parameters too weak to protect a wallet
+func ValidateArgon2Params(params Argon2Params) error {
+	return services.ValidateArgon2Params(params)
+}
+
+// NewAuthService creates the wallet, login and token service. issuer is the public URL of the
+// instance; sealer, totpSealer and passphrasePolicy may be nil.
+func NewAuthService(signer *TokenSigner, db Backend, machinePolicy MachinePolicy, issuer string, hashParams Argon2Params, lockout LockoutPolicy, passphrasePolicy *PassphrasePolicy, sealer, totpSealer *MetadataSealer, clk Clock) *AuthService {
+	return services.NewAuthService(signer, db, machinePolicy, issuer, hashParams, lockout, passphrasePolicy, sealer, totpSealer, clk)
+}
+
+// NewSyncService creates the service storing synced records. archive and sealer may be nil.
+func NewSyncService(db Backend, archive *ArchiveService, quotas Quotas, sealer *MetadataSealer, clk Clock) *SyncService {
+	return services.NewSyncService(db, archive, quotas, sealer, clk)
+}
+
+// NewJobCoordinator creates the coordinator of operations that must be finished after a crash
+func NewJobCoordinator(db Backend, clk Clock) *JobCoordinator {
+	return services.NewJobCoordinator(db, clk)
+}
+
+// NewAccountEraser creates the service deleting wallets with all their data
+func NewAccountEraser(auth *AuthService, sync *SyncService, jobs *JobCoordinator) *AccountEraser {
+	return services.NewAccountEraser(auth, sync, jobs)
+}
+
+// NewAccountMerger creates the service merging and rotating wallets
+func NewAccountMerger(auth *AuthService, sync *SyncService, eraser *AccountEraser, jobs *JobCoordinator) *AccountMerger {
+	return services.NewAccountMerger(auth, sync, eraser, jobs)
+}
+
+// NewBatchApplier creates the service applying sync batches all or nothing
+func NewBatchApplier(sync *SyncService) *BatchApplier {
+	return services.NewBatchApplier(sync)
+}
+
+// NewArchiveService moves threads untouched for afterMonths to store
+func NewArchiveService(db Backend, store BlobStore, afterMonths int, jobs *JobCoordinator, clk Clock) *ArchiveService {
+	return services.NewArchiveService(db, store, afterMonths, jobs, clk)
+}
+
+// NewAttachmentCollector deletes attachments no message references once they are older than grace
+func NewAttachmentCollector(sync *SyncService, jobs *JobCoordinator, grace time.Duration) *AttachmentCollector {
+	return services.NewAttachmentCollector(sync, jobs, grace)
+}
+
+// NewAuditService records authorization decisions, in db as well when store is set
+func NewAuditService(db Backend, store bool, maxEntries int64) *AuditService {
+	return services.NewAuditService(db, store, maxEntries)
+}
+
+// NewDebugTracer creates the service recording per-wallet debug traces
+func NewDebugTracer(db Backend, clk Clock) *DebugTracer {
+	return services.NewDebugTracer(db, clk)
+}
+
+// NewDemoService creates demo wallets erased with their data after ttl
+func NewDemoService(auth *AuthService, eraser *AccountEraser, jobs *JobCoordinator, ttl time.Duration) *DemoService {
+	return services.NewDemoService(auth, eraser, jobs, ttl)
+}
+
+// NewPasskeyService creates the WebAuthn passkey login
+func NewPasskeyService(auth *AuthService, policy PasskeyPolicy) *PasskeyService {
+	return services.NewPasskeyService(auth, policy)
+}
+
+// NewOpaqueService creates the OPAQUE login with server keys derived from a 32 byte secret
+func NewOpaqueService(auth *AuthService, secret []byte) (*OpaqueService, error) {
+	return services.NewOpaqueService(auth, secret)
+}
+
+// ParseRegistrationRules parses wallet creation limits like "ip:10/1h,subnet:50/1h"
+func ParseRegistrationRules(policy string) ([]RegistrationRule, error) {
+	return services.ParseRegistrationRules(policy)
+}
+
+// LoadASNTable reads an iptoasn.com ip2asn-combined.tsv file for ASN registration rules
+func LoadASNTable(r io.Reader) (*ASNTable, error) {
+	return services.LoadASNTable(r)
+}
+
+// NewRegistrationThrottle limits wallet creations by rules; asns may be nil
+func NewRegistrationThrottle(db Backend, rules []RegistrationRule, asns *ASNTable, clk Clock) *RegistrationThrottle {
+	return services.NewRegistrationThrottle(db, rules, asns, clk)
+}
+
+// SignRequest computes the signature a registered machine sends with a request
+func SignRequest(secret, method, requestURI, timestamp string, body []byte) string {
+	return services.SignRequest(secret, method, requestURI, timestamp, body)
+}
+
+// DescribeProtocol describes the sync protocol as implemented by the given services
+func DescribeProtocol(auth *AuthService, sync *SyncService) *ProtocolDescription {
+	return services.DescribeProtocol(auth, sync)
+}
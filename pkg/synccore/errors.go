@@ -0,0 +1,106 @@
+package synccore
+
+import (
+	"github.com/helioschat/sync/internal/blobstore"
+	"github.com/helioschat/sync/internal/database"
+	"github.com/helioschat/sync/internal/jsonstream"
+	"github.com/helioschat/sync/internal/services"
+)
+
+// Storage errors
+var (
+	ErrNotFound     = database.ErrNotFound   // key or hash field doesn't exist
+	ErrBlobNotFound = blobstore.ErrNotFound  // archived object or attachment blob doesn't exist
+	ErrNotDurable   = database.ErrNotDurable // a flush didn't complete in time
+)
+
+// IsNotFound reports whether err means a storage key or field doesn't exist
+func IsNotFound(err error) bool {
+	return database.IsNotFound(err)
+}
+
+// Authentication, wallet and token errors
+var (
+	ErrInvalidCredentials      = services.ErrInvalidCredentials
+	ErrLoginLocked             = services.ErrLoginLocked
+	ErrWeakPassphrase          = services.ErrWeakPassphrase
+	ErrPassphraseConfirmation  = services.ErrPassphraseConfirmation
+	ErrInvalidRecoveryCode     = services.ErrInvalidRecoveryCode
+	ErrTOTPRequired            = services.ErrTOTPRequired
+	ErrInvalidTOTP             = services.ErrInvalidTOTP
+	ErrTOTPUnavailable         = services.ErrTOTPUnavailable
+	ErrTOTPAlreadyEnabled      = services.ErrTOTPAlreadyEnabled
+	ErrTOTPNotEnrolled         = services.ErrTOTPNotEnrolled
+	ErrSessionEnded            = services.ErrSessionEnded
+	ErrScopedTokenNotFound     = services.ErrScopedTokenNotFound
+	ErrNotAdminToken           = services.ErrNotAdminToken
+	ErrInvalidAdminTokenTTL    = services.ErrInvalidAdminTokenTTL
+	ErrRegistrationThrottled   = services.ErrRegistrationThrottled
+	ErrWalletMerged            = services.ErrWalletMerged
+	ErrMergeIntoSelf           = services.ErrMergeIntoSelf
+	ErrInvalidMergePolicy      = services.ErrInvalidMergePolicy
+	ErrDeletionNotAcknowledged = services.ErrDeletionNotAcknowledged
+	ErrInvalidExportMode       = services.ErrInvalidExportMode
+	ErrExportNotFound          = services.ErrExportNotFound
+)
+
+// Passkey and OPAQUE login errors
+var (
+	ErrPasskeyNotFound      = services.ErrPasskeyNotFound
+	ErrInvalidPasskey       = services.ErrInvalidPasskey
+	ErrTooManyPasskeys      = services.ErrTooManyPasskeys
+	ErrOpaqueNotRegistered  = services.ErrOpaqueNotRegistered
+	ErrInvalidOpaqueMessage = services.ErrInvalidOpaqueMessage
+	ErrOpaqueLoginFailed    = services.ErrOpaqueLoginFailed
+	ErrOpaqueSignupNotFound = services.ErrOpaqueSignupNotFound
+	ErrOpaqueRequired       = services.ErrOpaqueRequired
+)
+
+// Machine and request signature errors
+var (
+	ErrMachineNotFound      = services.ErrMachineNotFound
+	ErrMachineNotRegistered = services.ErrMachineNotRegistered
+	ErrMachineDeactivated   = services.ErrMachineDeactivated
+	ErrSignatureRequired    = services.ErrSignatureRequired
+	ErrInvalidSignature     = services.ErrInvalidSignature
+)
+
+// Sync errors
+var (
+	ErrVersionConflict       = services.ErrVersionConflict
+	ErrMessageIDTaken        = services.ErrMessageIDTaken
+	ErrThreadNotFound        = services.ErrThreadNotFound
+	ErrThreadExists          = services.ErrThreadExists
+	ErrThreadDeleted         = services.ErrThreadDeleted
+	ErrSettingsDeleted       = services.ErrSettingsDeleted
+	ErrSettingsNotFound      = services.ErrSettingsNotFound
+	ErrInvalidBatchOperation = services.ErrInvalidBatchOperation
+	ErrInvalidCursor         = services.ErrInvalidCursor
+	ErrCheckpointNotFound    = services.ErrCheckpointNotFound
+	ErrInvalidCheckpoint     = services.ErrInvalidCheckpoint
+	ErrBranchPointNotFound   = services.ErrBranchPointNotFound
+	ErrMemoryNotFound        = services.ErrMemoryNotFound
+	ErrFolderNotFound        = services.ErrFolderNotFound
+	ErrDraftNotFound         = services.ErrDraftNotFound
+	ErrInvalidBenchmark      = services.ErrInvalidBenchmark
+	ErrInvalidPurge          = services.ErrInvalidPurge
+	ErrThreadMessageLimit    = services.ErrThreadMessageLimit
+	ErrAttachmentsDisabled   = services.ErrAttachmentsDisabled
+	ErrAttachmentNotFound    = services.ErrAttachmentNotFound
+	ErrAttachmentExists      = services.ErrAttachmentExists
+	ErrAttachmentTooLarge    = services.ErrAttachmentTooLarge
+	ErrAttachmentQuota       = services.ErrAttachmentQuota
+	ErrInvalidTraceDuration  = services.ErrInvalidTraceDuration
+	ErrItemTooLarge          = jsonstream.ErrItemTooLarge
+)
+
+// Errors carrying details, to be matched with errors.As
+type (
+	LockedError           = services.LockedError           // login locked out, with the time until it may be retried
+	ThrottledError        = services.ThrottledError        // wallet creation refused by a registration rule
+	PolicyError           = services.PolicyError           // passphrase rejected by the passphrase policy
+	BatchError            = services.BatchError            // sync batch rolled back, with the operation that failed
+	ThreadConflictError   = services.ThreadConflictError   // thread write not newer than the stored thread, which it carries
+	MessageConflictError  = services.MessageConflictError  // message update not newer than the last one, with the stored message
+	SettingsConflictError = services.SettingsConflictError // settings write not newer than the stored document, which it carries
+)
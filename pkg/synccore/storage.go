@@ -0,0 +1,64 @@
+package synccore
+
+import (
+	"time"
+
+	"github.com/helioschat/sync/internal/blobstore"
+	"github.com/helioschat/sync/internal/database"
+)
+
+// Backend is the key-value storage the engine keeps everything in. Embedders may implement it
+// on top of their own storage; Redis and a single-file Bolt store are built in.
+type Backend = database.Backend
+
+// StreamEntry is an entry of a Backend stream
+type StreamEntry = database.StreamEntry
+
+type (
+	RedisClient       = database.RedisClient
+	BoltStore         = database.BoltStore
+	RetryPolicy       = database.RetryPolicy       // retries of failed Redis operations
+	CompressionPolicy = database.CompressionPolicy // compression of large stored values
+	DurabilityPolicy  = database.DurabilityPolicy  // what flushing Redis waits for
+)
+
+// Flusher is implemented by Backends that can make acknowledged writes durable on demand
+type Flusher = database.Flusher
+
+// Durability levels a write can ask for
+const (
+	DurabilityAck   = database.DurabilityAck
+	DurabilityFlush = database.DurabilityFlush
+)
+
+// SupportedDurability returns the durability levels db supports
+func SupportedDurability(db Backend) []string {
+	return database.SupportedDurability(db)
+}
+
+// NewRedisClient connects to Redis at url
+func NewRedisClient(url, password string, db int, timeout time.Duration, retry RetryPolicy, compression CompressionPolicy, durability DurabilityPolicy) (*RedisClient, error) {
+	return database.NewRedisClient(url, password, db, timeout, retry, compression, durability)
+}
+
+// NewBoltStore opens or creates a Bolt database file, for single-node deployments without Redis
+func NewBoltStore(file string, compression CompressionPolicy) (*BoltStore, error) {
+	return database.NewBoltStore(file, compression)
+}
+
+// BlobStore keeps archived threads and attachments outside of the Backend
+type BlobStore = blobstore.Store
+
+type S3Store = blobstore.S3Store
+
+// NewS3Store returns a BlobStore on an S3-compatible bucket
+func NewS3Store(endpoint, region, bucket, accessKey, secretKey string, useSSL bool) (*S3Store, error) {
+	return blobstore.NewS3Store(endpoint, region, bucket, accessKey, secretKey, useSSL)
+}
+
+type FSStore = blobstore.FSStore
+
+// NewFSStore returns a BlobStore keeping objects as files below root
+func NewFSStore(root string) (*FSStore, error) {
+	return blobstore.NewFSStore(root)
+}
@@ -0,0 +1,213 @@
+package synccore
+
+import (
+	"github.com/google/uuid"
+	"github.com/helioschat/sync/internal/types"
+)
+
+// Synced records
+type (
+	VersionedData      = types.VersionedData
+	Thread             = types.Thread
+	ThreadMetaEntry    = types.ThreadMetaEntry
+	ThreadTombstone    = types.ThreadTombstone
+	Message            = types.Message
+	ProviderInstances  = types.ProviderInstances
+	DisabledModels     = types.DisabledModels
+	AdvancedSettings   = types.AdvancedSettings
+	ToolServers        = types.ToolServers
+	PromptLibrary      = types.PromptLibrary
+	Tags               = types.Tags
+	Profile            = types.Profile
+	CustomInstructions = types.CustomInstructions
+	FavoriteModels     = types.FavoriteModels
+	Memory             = types.Memory
+	Folder             = types.Folder
+	Draft              = types.Draft
+	ReadState          = types.ReadState
+	SettingsRevisions  = types.SettingsRevisions
+	Attachment         = types.Attachment
+)
+
+// Sync requests and responses
+type (
+	ThreadUpdateRequest             = types.ThreadUpdateRequest
+	BranchThreadRequest             = types.BranchThreadRequest
+	ThreadBranch                    = types.ThreadBranch
+	MessageUpdateRequest            = types.MessageUpdateRequest
+	ProviderInstancesUpdateRequest  = types.ProviderInstancesUpdateRequest
+	DisabledModelsUpdateRequest     = types.DisabledModelsUpdateRequest
+	AdvancedSettingsUpdateRequest   = types.AdvancedSettingsUpdateRequest
+	ToolServersUpdateRequest        = types.ToolServersUpdateRequest
+	PromptLibraryUpdateRequest      = types.PromptLibraryUpdateRequest
+	TagsUpdateRequest               = types.TagsUpdateRequest
+	ProfileUpdateRequest            = types.ProfileUpdateRequest
+	CustomInstructionsUpdateRequest = types.CustomInstructionsUpdateRequest
+	FavoriteModelsUpdateRequest     = types.FavoriteModelsUpdateRequest
+	MemoryUpdateRequest             = types.MemoryUpdateRequest
+	FolderUpdateRequest             = types.FolderUpdateRequest
+	DraftUpdateRequest              = types.DraftUpdateRequest
+	ReadStateBatchRequest           = types.ReadStateBatchRequest
+	BatchOperation                  = types.BatchOperation
+	BatchRequest                    = types.BatchRequest
+	BatchOperationResult            = types.BatchOperationResult
+	BatchResult                     = types.BatchResult
+	ConflictStrategies              = types.ConflictStrategies
+	ConflictResolution              = types.ConflictResolution
+	ChangeOperation                 = types.ChangeOperation
+	ChangesSinceResponse            = types.ChangesSinceResponse
+	BootstrapResponse               = types.BootstrapResponse
+	PaginationParams                = types.PaginationParams
+	PaginatedThreadsResponse        = types.PaginatedThreadsResponse
+	PaginatedMessagesResponse       = types.PaginatedMessagesResponse
+	SyncLimits                      = types.SyncLimits
+	Quotas                          = types.Quotas
+	Usage                           = types.Usage
+	QuotaWarning                    = types.QuotaWarning
+	UsageResponse                   = types.UsageResponse
+	BenchmarkRequest                = types.BenchmarkRequest
+	LatencyStats                    = types.LatencyStats
+	BenchmarkReport                 = types.BenchmarkReport
+	PurgeRequest                    = types.PurgeRequest
+	PurgeReport                     = types.PurgeReport
+)
+
+// Wallets and their lifecycle
+type (
+	Wallet                = types.Wallet
+	Argon2Params          = types.Argon2Params
+	WalletInfo            = types.WalletInfo
+	WalletSecurity        = types.WalletSecurity
+	DemoWallet            = types.DemoWallet
+	TOTPEnrollment        = types.TOTPEnrollment
+	DeletionReceipt       = types.DeletionReceipt
+	WalletDeletionRequest = types.WalletDeletionRequest
+	WalletDeletion        = types.WalletDeletion
+	AccountExport         = types.AccountExport
+	ExportedThread        = types.ExportedThread
+	ImportItemResult      = types.ImportItemResult
+	ImportSummary         = types.ImportSummary
+	ImportEvent           = types.ImportEvent
+	AccountMergeRequest   = types.AccountMergeRequest
+	MergeReport           = types.MergeReport
+	MergeConflict         = types.MergeConflict
+	WalletRotationRequest = types.WalletRotationRequest
+	WalletRotation        = types.WalletRotation
+)
+
+// Tokens, sessions and machines
+type (
+	AuthTokens             = types.AuthTokens
+	TokenClaims            = types.TokenClaims
+	AdminClaims            = types.AdminClaims
+	JWK                    = types.JWK
+	JWKS                   = types.JWKS
+	GuestTokenRequest      = types.GuestTokenRequest
+	GuestTokenInfo         = types.GuestTokenInfo
+	GuestToken             = types.GuestToken
+	ScopedTokenRequest     = types.ScopedTokenRequest
+	ScopedTokenInfo        = types.ScopedTokenInfo
+	ScopedToken            = types.ScopedToken
+	Session                = types.Session
+	Machine                = types.Machine
+	MachineRegisterRequest = types.MachineRegisterRequest
+	MachineRegistration    = types.MachineRegistration
+	MachineRenameRequest   = types.MachineRenameRequest
+)
+
+// Passkey (WebAuthn) and OPAQUE login messages
+type (
+	Passkey                       = types.Passkey
+	PasskeyCreationOptions        = types.PasskeyCreationOptions
+	PasskeyRequestOptions         = types.PasskeyRequestOptions
+	PasskeyRelyingParty           = types.PasskeyRelyingParty
+	PasskeyUser                   = types.PasskeyUser
+	PasskeyCredentialParameter    = types.PasskeyCredentialParameter
+	PasskeyDescriptor             = types.PasskeyDescriptor
+	PasskeyAuthenticatorSelection = types.PasskeyAuthenticatorSelection
+	PasskeyCredential             = types.PasskeyCredential
+	PasskeyCredentialResponse     = types.PasskeyCredentialResponse
+	OpaqueRegistrationResponse    = types.OpaqueRegistrationResponse
+	OpaqueLoginChallenge          = types.OpaqueLoginChallenge
+	OpaqueSignupChallenge         = types.OpaqueSignupChallenge
+)
+
+// Instance description
+type (
+	EncryptionPolicy      = types.EncryptionPolicy
+	MigrationNotice       = types.MigrationNotice
+	Discovery             = types.Discovery
+	DiscoveryCapabilities = types.DiscoveryCapabilities
+	DurabilityLevels      = types.DurabilityLevels
+	ServerFeatures        = types.ServerFeatures
+	RegistrationPolicy    = types.RegistrationPolicy
+	RegistrationLimit     = types.RegistrationLimit
+	ProtocolDescription   = types.ProtocolDescription
+	ProtocolEndpoint      = types.ProtocolEndpoint
+	ProtocolRule          = types.ProtocolRule
+	ProtocolErrorCode     = types.ProtocolErrorCode
+)
+
+// API envelope, audit log and debug traces
+type (
+	APIResponse     = types.APIResponse
+	APIError        = types.APIError
+	PolicyViolation = types.PolicyViolation
+	AuditEntry      = types.AuditEntry
+	TraceEntry      = types.TraceEntry
+	DebugTrace      = types.DebugTrace
+)
+
+const (
+	DefaultPlan             = types.DefaultPlan
+	LegacyEncryptionVersion = types.LegacyEncryptionVersion
+	ProtocolVersion         = types.ProtocolVersion
+
+	// Final export modes of a wallet deletion
+	ExportNone   = types.ExportNone
+	ExportInline = types.ExportInline
+	ExportStaged = types.ExportStaged
+
+	// Statuses of imported items
+	ImportCreated   = types.ImportCreated
+	ImportUpdated   = types.ImportUpdated
+	ImportUnchanged = types.ImportUnchanged
+	ImportConflict  = types.ImportConflict
+	ImportFailed    = types.ImportFailed
+
+	// Kinds of batch operations
+	BatchUpsertThread  = types.BatchUpsertThread
+	BatchCreateMessage = types.BatchCreateMessage
+	BatchDeleteThread  = types.BatchDeleteThread
+	BatchDeleteMessage = types.BatchDeleteMessage
+
+	// Scopes of scoped tokens
+	ScopeRead  = types.ScopeRead
+	ScopeWrite = types.ScopeWrite
+	ScopeAdmin = types.ScopeAdmin
+
+	// Changes-since operation announcing an instance migration
+	ResourceInstance = types.ResourceInstance
+	OperationMigrate = types.OperationMigrate
+
+	// Conflict strategies of thread and settings writes
+	ConflictReject         = types.ConflictReject
+	ConflictLastWriterWins = types.ConflictLastWriterWins
+
+	// Usage levels quota warnings are raised at
+	QuotaWarningLevel  = types.QuotaWarningLevel
+	QuotaCriticalLevel = types.QuotaCriticalLevel
+
+	MessageIDMaxLength       = types.MessageIDMaxLength
+	MessageIDPrefixMaxLength = types.MessageIDPrefixMaxLength
+)
+
+// ValidateUUIDv7 checks that an ID is a UUIDv7, as thread and machine IDs must be
+func ValidateUUIDv7(u uuid.UUID) error {
+	return types.ValidateUUIDv7(u)
+}
+
+// ValidateMessageID checks a client-chosen message ID against the message ID policy
+func ValidateMessageID(id string) error {
+	return types.ValidateMessageID(id)
+}